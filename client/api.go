@@ -0,0 +1,27 @@
+package client
+
+import (
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+)
+
+// API is the kv surface of Client that applications code against,
+// so that tests can swap in clientmock.Client without a server.
+// Commands tied to server internals, e.g. ListTxns, SlowLog, Backup, Fsck, snapshots and Doc*, are not part of it.
+type API interface {
+	mondis.Client
+	TTL(k []byte) (time.Duration, error)
+	Count(option mondis.ScanOption) (int64, error)
+	ScanStream(option mondis.ScanOption, fn func(entry mondis.Entry) bool) error
+	Incr(key []byte, delta int64) (int64, error)
+	Inc(key []byte, delta int64) (int64, error)
+	CompareAndSwap(key, expected, new []byte) (bool, error)
+	CompareAndSwapAbsent(key, new []byte) (bool, error)
+	CompareAndSet(key, expected, newValue []byte) (bool, []byte, error)
+	CompareAndDelete(key, expected []byte) (bool, []byte, error)
+	Watch(prefix []byte, fn func(ev mondis.WatchEvent)) (func(), error)
+	SetMaintenanceMode(on bool) error
+}
+
+var _ API = (*Client)(nil)
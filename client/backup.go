@@ -0,0 +1,157 @@
+package client
+
+import (
+	"io"
+
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/mondis/server"
+	"github.com/zhiqiangxu/qrpc"
+)
+
+// Backup streams a backup of versions committed after since from server into w,
+// the returned version is to be passed as since for the next incremental backup.
+// server.Option.EnableBackupCmds should be set on server side.
+func (c *Client) Backup(w io.Writer, since uint64) (version uint64, err error) {
+	req := pb.BackupRequest{Since: since}
+	bytes, _ := req.Marshal()
+
+	con, err := c.pool.get()
+	if err != nil {
+		return
+	}
+	sw, resp, err := con.StreamRequest(server.BackupCmd, qrpc.NBFlag, bytes)
+	if err != nil {
+		return
+	}
+
+	// closing our side stops the backup if it's not done yet
+	closed := false
+	closeStream := func() {
+		if closed {
+			return
+		}
+		closed = true
+		sw.StartWrite(server.DiscardCmd)
+		sw.EndWrite(true)
+	}
+	defer closeStream()
+
+	firstFrame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var (
+		frame    = firstFrame
+		writeErr error
+	)
+	for {
+		var backupResp pb.BackupResponse
+		err = backupResp.Unmarshal(frame.Payload)
+		if err != nil {
+			return
+		}
+		if backupResp.Code != 0 {
+			err = errorFromCode(backupResp.Code, backupResp.Msg)
+			return
+		}
+
+		if writeErr == nil && len(backupResp.Data) > 0 {
+			_, writeErr = w.Write(backupResp.Data)
+			if writeErr != nil {
+				// remaining frames are drained below
+				closeStream()
+			}
+		}
+
+		if frame.Flags.IsDone() {
+			if writeErr != nil {
+				err = writeErr
+				return
+			}
+			version = backupResp.Version
+			return
+		}
+
+		frame = <-firstFrame.FrameCh()
+		if frame == nil {
+			if writeErr != nil {
+				err = writeErr
+			} else {
+				err = ErrStreamClosed
+			}
+			return
+		}
+	}
+}
+
+// Restore streams a backup written by Backup from r into server,
+// server.Option.EnableBackupCmds should be set and server should be in maintenance mode,
+// otherwise server.ErrNotInMaintenance is returned.
+// The restore is not atomic, data loaded before a failure is kept.
+func (c *Client) Restore(r io.Reader) (err error) {
+	con, err := c.pool.get()
+	if err != nil {
+		return
+	}
+
+	var (
+		sw   qrpc.StreamWriter
+		resp qrpc.Response
+		buf  = make([]byte, server.BackupChunkSize)
+	)
+	for {
+		var req pb.RestoreRequest
+		n, readErr := io.ReadFull(r, buf)
+		end := true
+		switch readErr {
+		case nil:
+			end = false
+		case io.EOF, io.ErrUnexpectedEOF:
+		default:
+			req.Abort = true
+		}
+		req.Data = buf[:n]
+		bytes, _ := req.Marshal()
+
+		if sw == nil {
+			flag := qrpc.NBFlag
+			if end {
+				flag |= qrpc.StreamEndFlag
+			}
+			sw, resp, err = con.StreamRequest(server.RestoreCmd, flag, bytes)
+		} else {
+			sw.StartWrite(server.RestoreCmd)
+			sw.WriteBytes(bytes)
+			err = sw.EndWrite(end)
+		}
+		if err != nil {
+			return
+		}
+
+		if req.Abort {
+			// the response only echoes the abort, readErr tells why
+			_, _ = resp.GetFrame()
+			err = readErr
+			return
+		}
+		if end {
+			break
+		}
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var restoreResp pb.RestoreResponse
+	err = restoreResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+	if restoreResp.Code != 0 {
+		err = errorFromCode(restoreResp.Code, restoreResp.Msg)
+	}
+	return
+}
@@ -1,10 +1,17 @@
 package client
 
 import (
+	"crypto/tls"
+	"errors"
+	"time"
+
 	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
 	"github.com/zhiqiangxu/mondis/kv"
 	"github.com/zhiqiangxu/mondis/pb"
 	"github.com/zhiqiangxu/mondis/server"
+	"github.com/zhiqiangxu/mondis/slowlog"
+	"github.com/zhiqiangxu/mondis/util"
 	"github.com/zhiqiangxu/qrpc"
 )
 
@@ -12,17 +19,59 @@ type (
 	// Option for Client
 	Option struct {
 		QrpcConfig qrpc.ConnectionConfig
+		// PoolSize is the number of connections, defaults to 1
+		PoolSize int
+		// DialTimeout overrides QrpcConfig.DialTimeout if not 0
+		DialTimeout time.Duration
+		// ReconnectBackoff is the initial wait before redialing a connection that failed to dial,
+		// it doubles on each consecutive failure, defaults to 50ms
+		ReconnectBackoff time.Duration
+		// MaxRetries is the max number of times Update retries on kv.ErrTxnConflict
+		MaxRetries int
+		// ReadRetries is the max number of times Get, Exists and Scan outside transactions
+		// are retried on another connection when the transport fails, writes are never retried
+		ReadRetries int
+		// TLSConfig enables TLS when dialing if not nil, it overrides QrpcConfig.TLSConf
+		TLSConfig *tls.Config
+		// Token is sent by AuthCmd on each new connection if not empty, for server.Option.AuthProvider.
+		// A connection whose token is rejected is closed, and the dial fails with server.ErrAuthFailed.
+		Token string
 	}
 	// Client implements mondis.Client
 	Client struct {
-		con *qrpc.Connection
+		pool             *connPool
+		maxRetries       int
+		readRetries      int
+		readRetryCount   uint64
+		readFailureCount uint64
 	}
 )
 
 // New is ctor for Client
 func New(addr string, option Option) (c mondis.Client) {
-	con := qrpc.NewConnectionWithReconnect([]string{addr}, option.QrpcConfig, nil)
-	c = &Client{con: con}
+	conf := option.QrpcConfig
+	if option.TLSConfig != nil {
+		conf.TLSConf = option.TLSConfig
+	}
+	if option.DialTimeout != 0 {
+		conf.DialTimeout = option.DialTimeout
+	}
+	c = &Client{pool: newConnPool(addr, conf, option.PoolSize, option.ReconnectBackoff, option.Token), maxRetries: option.MaxRetries, readRetries: option.ReadRetries}
+	return
+}
+
+// Close all connections
+func (c *Client) Close() error {
+	return c.pool.close()
+}
+
+func (c *Client) request(cmd qrpc.Cmd, bytes []byte) (resp qrpc.Response, err error) {
+	con, err := c.pool.get()
+	if err != nil {
+		return
+	}
+
+	_, resp, err = con.Request(cmd, qrpc.NBFlag, bytes)
 	return
 }
 
@@ -56,7 +105,7 @@ func parseSetRespFromFrame(respFrame *qrpc.Frame) (err error) {
 		if setResp.Code == server.CodeTxnTooBig {
 			err = kv.ErrTxnTooBig
 		} else {
-			err = newPBError(setResp.Code, setResp.Msg)
+			err = errorFromCode(setResp.Code, setResp.Msg)
 		}
 
 		return
@@ -71,7 +120,7 @@ func (c *Client) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
 	req := setReq2PB(k, v, meta)
 	bytes, _ := req.Marshal()
 
-	_, resp, err := c.con.Request(server.SetCmd, qrpc.NBFlag, bytes)
+	resp, err := c.request(server.SetCmd, bytes)
 	if err != nil {
 		return
 	}
@@ -81,16 +130,6 @@ func (c *Client) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
 	return
 }
 
-func parseExistsResp(resp qrpc.Response) (exists bool, err error) {
-	frame, err := resp.GetFrame()
-	if err != nil {
-		return
-	}
-
-	exists, err = parseExistsRespFromFrame(frame)
-	return
-}
-
 func parseExistsRespFromFrame(respFrame *qrpc.Frame) (exists bool, err error) {
 	var existsResp pb.ExistsResponse
 	err = existsResp.Unmarshal(respFrame.Payload)
@@ -100,7 +139,7 @@ func parseExistsRespFromFrame(respFrame *qrpc.Frame) (exists bool, err error) {
 
 	if existsResp.Code != 0 {
 
-		err = newPBError(existsResp.Code, existsResp.Msg)
+		err = errorFromCode(existsResp.Code, existsResp.Msg)
 
 		return
 	}
@@ -115,23 +154,13 @@ func (c *Client) Exists(k []byte) (exists bool, err error) {
 	req := pb.ExistsRequest{Key: k}
 	bytes, _ := req.Marshal()
 
-	_, resp, err := c.con.Request(server.ExistsCmd, qrpc.NBFlag, bytes)
+	frame, err := c.readRequest(server.ExistsCmd, bytes)
 	if err != nil {
 		return
 	}
 
-	exists, err = parseExistsResp(resp)
-
-	return
-}
-
-func parseGetResp(resp qrpc.Response) (v []byte, meta mondis.VMetaResp, err error) {
-	frame, err := resp.GetFrame()
-	if err != nil {
-		return
-	}
+	exists, err = parseExistsRespFromFrame(frame)
 
-	v, meta, err = parseGetRespFromFrame(frame)
 	return
 }
 
@@ -147,15 +176,19 @@ func parseGetRespFromFrame(respFrame *qrpc.Frame) (v []byte, meta mondis.VMetaRe
 		if getResp.Code == server.CodeKeyNotFound {
 			err = kv.ErrKeyNotFound
 		} else {
-			err = newPBError(getResp.Code, getResp.Msg)
+			err = errorFromCode(getResp.Code, getResp.Msg)
 		}
 
 		return
 	}
 
+	// empty value is omitted on the wire, but the key is present
 	v = getResp.Value
-	meta.ExpiresAt = getResp.Meta.ExpiresAt
-	meta.Tag = byte(getResp.Meta.Tag)
+	if v == nil {
+		v = []byte{}
+	}
+	meta.ExpiresAt = getResp.GetMeta().GetExpiresAt()
+	meta.Tag = byte(getResp.GetMeta().GetTag())
 
 	return
 }
@@ -165,13 +198,41 @@ func (c *Client) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
 	req := pb.GetRequest{Key: k}
 	bytes, _ := req.Marshal()
 
-	_, resp, err := c.con.Request(server.GetCmd, qrpc.NBFlag, bytes)
+	frame, err := c.readRequest(server.GetCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	v, meta, err = parseGetRespFromFrame(frame)
+
+	return
+}
+
+// ErrNoTTL when key has no ttl
+var ErrNoTTL = errors.New("key has no ttl")
+
+// TTL returns the remaining time to live of key computed from meta.ExpiresAt,
+// ErrNoTTL is returned if it never expires, kv.ErrKeyNotFound if it doesn't exist or has expired.
+func (c *Client) TTL(k []byte) (ttl time.Duration, err error) {
+	_, meta, err := c.Get(k)
 	if err != nil {
 		return
 	}
 
-	v, meta, err = parseGetResp(resp)
+	ttl, err = ttlFromMeta(meta)
+	return
+}
+
+func ttlFromMeta(meta mondis.VMetaResp) (ttl time.Duration, err error) {
+	if meta.ExpiresAt == 0 {
+		err = ErrNoTTL
+		return
+	}
 
+	ttl = time.Until(time.Unix(int64(meta.ExpiresAt), 0))
+	if ttl < 0 {
+		ttl = 0
+	}
 	return
 }
 
@@ -193,7 +254,7 @@ func parseDeleteRespFromFrame(respFrame *qrpc.Frame) (err error) {
 	}
 
 	if deleteResp.Code != 0 {
-		err = newPBError(deleteResp.Code, deleteResp.Msg)
+		err = errorFromCode(deleteResp.Code, deleteResp.Msg)
 		return
 	}
 
@@ -205,7 +266,7 @@ func (c *Client) Delete(k []byte) (err error) {
 	req := pb.DeleteRequest{Key: k}
 	bytes, _ := req.Marshal()
 
-	_, resp, err := c.con.Request(server.DeleteCmd, qrpc.NBFlag, bytes)
+	resp, err := c.request(server.DeleteCmd, bytes)
 	if err != nil {
 		return
 	}
@@ -215,8 +276,17 @@ func (c *Client) Delete(k []byte) (err error) {
 	return
 }
 
-// Update for implement mondis.Client
+// Update for implement mondis.Client,
+// fn is rerun with a fresh Txn on kv.ErrTxnConflict up to Option.MaxRetries times,
+// so it should have no side effects other than through the Txn.
 func (c *Client) Update(fn func(t mondis.Txn) error) (err error) {
+	err = util.RetryOnConflict(c.maxRetries, func() error {
+		return c.update(fn)
+	})
+	return
+}
+
+func (c *Client) update(fn func(t mondis.Txn) error) (err error) {
 	txn := newTxn(c, true)
 	defer txn.Discard()
 
@@ -229,6 +299,24 @@ func (c *Client) Update(fn func(t mondis.Txn) error) (err error) {
 	return
 }
 
+// UpdateSplit is like Update, but server commits the transaction in chunks when it's too big for one provider txn,
+// instead of failing with kv.ErrTxnTooBig, if server.Option.EnableTxnSplit is set.
+// splits is the number of chunks committed early as reported on commit, each chunk is atomic on its own,
+// and reads after a split don't see the snapshot of the start.
+// Chunks committed before a failure are kept, so fn is never rerun.
+func (c *Client) UpdateSplit(fn func(t mondis.Txn) error) (splits int, err error) {
+	txn := newTxn(c, true)
+	txn.split = true
+	defer txn.Discard()
+
+	err = fn(txn)
+	if err == nil {
+		err = txn.Commit()
+	}
+	splits = txn.splits
+	return
+}
+
 // View for implement mondis.Client
 func (c *Client) View(fn func(t mondis.Txn) error) (err error) {
 	txn := newTxn(c, false)
@@ -239,7 +327,8 @@ func (c *Client) View(fn func(t mondis.Txn) error) (err error) {
 	return
 }
 
-func parseScanRespFromFrame(respFrame *qrpc.Frame) (entries []mondis.Entry, err error) {
+// parseScanRespFromFrame parses entries, values are nil if keysOnly, otherwise non-nil
+func parseScanRespFromFrame(respFrame *qrpc.Frame, keysOnly bool) (entries []mondis.Entry, err error) {
 	var scanResp pb.ScanResponse
 	err = scanResp.Unmarshal(respFrame.Payload)
 	if err != nil {
@@ -247,14 +336,20 @@ func parseScanRespFromFrame(respFrame *qrpc.Frame) (entries []mondis.Entry, err
 	}
 
 	if scanResp.Code != 0 {
-		err = newPBError(scanResp.Code, scanResp.Msg)
+		err = errorFromCode(scanResp.Code, scanResp.Msg)
 		return
 	}
 
 	entries = make([]mondis.Entry, len(scanResp.Entries))
 	for i, entry := range scanResp.Entries {
-		meta := mondis.VMetaResp{ExpiresAt: entry.Meta.ExpiresAt, Tag: byte(entry.Meta.Tag)}
-		entries[i] = mondis.Entry{Key: entry.Key, Value: entry.Value, Meta: meta}
+		meta := mondis.VMetaResp{ExpiresAt: entry.GetMeta().GetExpiresAt(), Tag: byte(entry.GetMeta().GetTag())}
+		value := entry.Value
+		if keysOnly {
+			value = nil
+		} else if value == nil {
+			value = []byte{}
+		}
+		entries[i] = mondis.Entry{Key: entry.Key, Value: value, Meta: meta}
 		entry.Key = nil
 		entry.Value = nil
 	}
@@ -262,20 +357,13 @@ func parseScanRespFromFrame(respFrame *qrpc.Frame) (entries []mondis.Entry, err
 	return
 }
 
-func parseScanResp(resp qrpc.Response) (entries []mondis.Entry, err error) {
-	frame, err := resp.GetFrame()
-	if err != nil {
-		return
-	}
-
-	entries, err = parseScanRespFromFrame(frame)
-	return
+func scanOption2PB(option mondis.ScanOption) *pb.ScanRequest {
+	pso := &pb.ProviderScanOption{Reverse: option.Reverse, Prefix: option.Prefix, Offset: option.Offset, Stop: option.Stop, KeysOnly: option.KeysOnly, KeyPattern: option.KeyPattern}
+	return &pb.ScanRequest{ProviderScanOption: pso, Limit: int32(option.Limit)}
 }
 
 func scanOption2Bytes(option mondis.ScanOption) (bytes []byte) {
-	pso := &pb.ProviderScanOption{Reverse: option.Reverse, Prefix: option.Prefix, Offset: option.Offset}
-	req := pb.ScanRequest{ProviderScanOption: pso, Limit: int32(option.Limit)}
-	bytes, _ = req.Marshal()
+	bytes, _ = scanOption2PB(option).Marshal()
 	return
 }
 
@@ -291,12 +379,401 @@ func (c *Client) Scan(option mondis.ScanOption) (entries []mondis.Entry, err err
 
 	bytes := scanOption2Bytes(option)
 
-	_, resp, err := c.con.Request(server.ScanCmd, qrpc.NBFlag, bytes)
+	frame, err := c.readRequest(server.ScanCmd, bytes)
 	if err != nil {
 		return
 	}
 
-	entries, err = parseScanResp(resp)
+	entries, err = parseScanRespFromFrame(frame, option.KeysOnly)
+
+	return
+}
+
+// Count returns the number of keys matching option on server side without shipping entries back,
+// option.Limit caps the count if positive, 0 means unlimited.
+func (c *Client) Count(option mondis.ScanOption) (n int64, err error) {
+	if option.Limit < 0 {
+		option.Limit = 0
+	}
+
+	bytes := scanOption2Bytes(option)
+
+	resp, err := c.request(server.CountCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var countResp pb.CountResponse
+	err = countResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if countResp.Code != 0 {
+		err = errorFromCode(countResp.Code, countResp.Msg)
+		return
+	}
+
+	n = countResp.N
+	return
+}
+
+// ListTxns returns the open transactions on server side, oldest first,
+// it fails unless server.Option.EnableListTxnsCmd is set.
+func (c *Client) ListTxns() (txns []server.TxnInfo, err error) {
+	resp, err := c.request(server.ListTxnsCmd, nil)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var listResp pb.ListTxnsResponse
+	err = listResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if listResp.Code != 0 {
+		err = errorFromCode(listResp.Code, listResp.Msg)
+		return
+	}
+
+	txns = make([]server.TxnInfo, 0, len(listResp.Txns))
+	for _, info := range listResp.Txns {
+		txns = append(txns, server.TxnInfo{
+			ID:         info.Id,
+			RemoteAddr: info.RemoteAddr,
+			StartTime:  time.Unix(0, info.StartTime),
+			Ops:        info.Ops,
+		})
+	}
+	return
+}
+
+// ListNodes returns the live nodes registered in meta by server.Option.NodeRegistry of servers on the same kvdb, ordered by epoch
+func (c *Client) ListNodes() (nodes []model.NodeInfo, err error) {
+	resp, err := c.request(server.ListNodesCmd, nil)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var listResp pb.ListNodesResponse
+	err = listResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if listResp.Code != 0 {
+		err = errorFromCode(listResp.Code, listResp.Msg)
+		return
+	}
+
+	nodes = make([]model.NodeInfo, 0, len(listResp.Nodes))
+	for _, node := range listResp.Nodes {
+		nodes = append(nodes, model.NodeInfo{
+			ID:        node.Id,
+			Host:      node.Host,
+			PID:       int(node.Pid),
+			StartTime: time.Unix(0, node.StartTime),
+			Epoch:     node.Epoch,
+			Roles:     node.Roles,
+			Heartbeat: time.Unix(0, node.Heartbeat),
+			TTL:       time.Duration(node.Ttl),
+		})
+	}
+	return
+}
+
+// SlowLog returns up to limit most recent slow operations recorded by server.Option.SlowLog, newest first,
+// limit <= 0 means all kept by server. It fails unless server.Option.SlowLog is set.
+func (c *Client) SlowLog(limit int) (records []slowlog.Record, err error) {
+	req := pb.SlowLogRequest{Limit: int32(limit)}
+	bytes, _ := req.Marshal()
+
+	resp, err := c.request(server.SlowLogCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var slowLogResp pb.SlowLogResponse
+	err = slowLogResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if slowLogResp.Code != 0 {
+		err = errorFromCode(slowLogResp.Code, slowLogResp.Msg)
+		return
+	}
+
+	records = make([]slowlog.Record, 0, len(slowLogResp.Records))
+	for _, r := range slowLogResp.Records {
+		records = append(records, slowlog.Record{
+			ID:        r.Id,
+			Kind:      r.Kind,
+			Namespace: r.Namespace,
+			Option:    r.Option,
+			Returned:  r.Returned,
+			Skipped:   r.Skipped,
+			Duration:  time.Duration(r.Duration),
+			TxnAge:    time.Duration(r.TxnAge),
+			Time:      time.Unix(0, r.Time),
+			Stack:     r.Stack,
+		})
+	}
+	return
+}
+
+// Incr is the same as Inc, it's kept for callers from before Inc,
+// and counters written by either are compatible with the other.
+func (c *Client) Incr(key []byte, delta int64) (n int64, err error) {
+	req := pb.IncrRequest{Key: key, Delta: delta}
+	bytes, _ := req.Marshal()
+
+	resp, err := c.request(server.IncrCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var incrResp pb.IncrResponse
+	err = incrResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if incrResp.Code != 0 {
+		err = errorFromCode(incrResp.Code, incrResp.Msg)
+		return
+	}
+
+	n = incrResp.Value
+	return
+}
+
+func parseIncRespFromFrame(respFrame *qrpc.Frame) (n int64, err error) {
+	var incResp pb.IncResponse
+	err = incResp.Unmarshal(respFrame.Payload)
+	if err != nil {
+		return
+	}
+
+	if incResp.Code != 0 {
+		if incResp.Code == server.CodeTxnTooBig {
+			err = kv.ErrTxnTooBig
+		} else {
+			err = errorFromCode(incResp.Code, incResp.Msg)
+		}
+		return
+	}
+
+	n = incResp.Value
+	return
+}
+
+// Inc atomically adds delta to the counter at key and returns the new value,
+// a missing key counts as 0. The value is stored as 8-byte big-endian like kv.IncBinaryInt64,
+// and kv.ErrOverflow is returned instead of wrapping around.
+func (c *Client) Inc(key []byte, delta int64) (n int64, err error) {
+	req := pb.IncRequest{Key: key, Delta: delta}
+	bytes, _ := req.Marshal()
+
+	resp, err := c.request(server.IncCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	n, err = parseIncRespFromFrame(frame)
+	return
+}
+
+// CompareAndSwap atomically sets key to new if its current value equals expected,
+// a missing key never matches, use CompareAndSwapAbsent for that.
+func (c *Client) CompareAndSwap(key, expected, new []byte) (swapped bool, err error) {
+	swapped, _, err = c.cas(&pb.CASRequest{Key: key, Expected: expected, NewValue: new})
+	return
+}
+
+// CompareAndSwapAbsent atomically sets key to new if it doesn't exist.
+func (c *Client) CompareAndSwapAbsent(key, new []byte) (swapped bool, err error) {
+	swapped, _, err = c.cas(&pb.CASRequest{Key: key, NewValue: new, ExpectAbsent: true})
+	return
+}
+
+// CompareAndSet atomically sets key to newValue if its current value equals expected, nil expected means key must not exist.
+// When not swapped, current is the value compared, nil if key doesn't exist.
+// It's one round trip, see Txn.CompareAndSet for the same in a transaction.
+func (c *Client) CompareAndSet(key, expected, newValue []byte) (swapped bool, current []byte, err error) {
+	swapped, current, err = c.cas(&pb.CASRequest{Key: key, Expected: expected, NewValue: newValue, ExpectAbsent: expected == nil})
+	return
+}
+
+// CompareAndDelete is like CompareAndSet but deletes key instead
+func (c *Client) CompareAndDelete(key, expected []byte) (deleted bool, current []byte, err error) {
+	deleted, current, err = c.cas(&pb.CASRequest{Key: key, Expected: expected, ExpectAbsent: expected == nil, Delete: true})
+	return
+}
+
+func (c *Client) cas(req *pb.CASRequest) (swapped bool, current []byte, err error) {
+	bytes, _ := req.Marshal()
+
+	resp, err := c.request(server.CASCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	swapped, current, err = parseCASRespFromFrame(frame)
+	return
+}
+
+func parseCASRespFromFrame(frame *qrpc.Frame) (swapped bool, current []byte, err error) {
+	var casResp pb.CASResponse
+	err = casResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if casResp.Code != 0 {
+		err = errorFromCode(casResp.Code, casResp.Msg)
+		return
+	}
+
+	swapped = casResp.Swapped
+	if casResp.Exists {
+		current = casResp.Current
+		if current == nil {
+			current = []byte{}
+		}
+	}
+	return
+}
+
+// ErrStreamClosed when stream is closed before the last frame
+var ErrStreamClosed = errors.New("stream closed unexpectedly")
+
+// ScanStream is like Scan but entries are streamed from server in batches instead of buffered,
+// fn is called for each entry and the scan is stopped once fn returns false.
+// option.Limit <= 0 means no limit, and MaxEntry doesn't apply.
+func (c *Client) ScanStream(option mondis.ScanOption, fn func(entry mondis.Entry) bool) (err error) {
+	bytes := scanOption2Bytes(option)
+
+	con, err := c.pool.get()
+	if err != nil {
+		return
+	}
+	sw, resp, err := con.StreamRequest(server.ScanStreamCmd, qrpc.NBFlag, bytes)
+	if err != nil {
+		return
+	}
+
+	// closing our side stops the scan if it's not done yet
+	closed := false
+	closeStream := func() {
+		if closed {
+			return
+		}
+		closed = true
+		sw.StartWrite(server.DiscardCmd)
+		sw.EndWrite(true)
+	}
+	defer closeStream()
+
+	firstFrame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var (
+		frame   = firstFrame
+		entries []mondis.Entry
+	)
+	for {
+		entries, err = parseScanRespFromFrame(frame, option.KeysOnly)
+		if err != nil {
+			return
+		}
+
+		if !closed {
+			for _, entry := range entries {
+				if !fn(entry) {
+					// remaining frames are drained below
+					closeStream()
+					break
+				}
+			}
+		}
+
+		if frame.Flags.IsDone() {
+			return
+		}
+
+		frame = <-firstFrame.FrameCh()
+		if frame == nil {
+			err = ErrStreamClosed
+			return
+		}
+	}
+}
+
+// SetMaintenanceMode turns maintenance mode of server on or off,
+// server.Option.EnableMaintenanceCmd should be set on server side.
+func (c *Client) SetMaintenanceMode(on bool) (err error) {
+	req := pb.MaintenanceRequest{On: on}
+	bytes, _ := req.Marshal()
+
+	resp, err := c.request(server.MaintenanceCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var maintenanceResp pb.MaintenanceResponse
+	err = maintenanceResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if maintenanceResp.Code != 0 {
+		err = errorFromCode(maintenanceResp.Code, maintenanceResp.Msg)
+		return
+	}
 
 	return
 }
@@ -2,6 +2,7 @@ package client
 
 import (
 	"errors"
+	"time"
 
 	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/pb"
@@ -9,13 +10,24 @@ import (
 	"github.com/zhiqiangxu/qrpc"
 )
 
-// Txn for client side transaction
+// Txn for client side transaction, it's pinned to one connection for its lifetime,
+// and fails with ErrConnectionLost once that connection breaks,
+// or with server.ErrTxnTimedOut once it's discarded by server for being idle.
 type Txn struct {
-	c          *Client
-	update     bool
+	c      *Client
+	update bool
+	// split is whether server can split the update txn, see Client.UpdateSplit
+	split bool
+	// splits reported by server on commit
+	splits     int
+	con        *qrpc.Connection
 	sw         qrpc.StreamWriter
 	resp       qrpc.Response
 	firstFrame *qrpc.Frame
+	// ended is whether the stream is closed on our side
+	ended bool
+	// err fails all later operations
+	err error
 }
 
 var _ mondis.Txn = (*Txn)(nil)
@@ -51,22 +63,61 @@ func (txn *Txn) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
 
 func (txn *Txn) getRespFrame() (respFrame *qrpc.Frame, err error) {
 	if txn.firstFrame != nil {
+		// the stream is reset when connection breaks
 		respFrame = <-txn.firstFrame.FrameCh()
+		if respFrame == nil {
+			err = ErrConnectionLost
+			return
+		}
+		if respFrame.Cmd == server.TxnTimedOutRespCmd {
+			txn.timedOut()
+			respFrame = nil
+			err = txn.err
+		}
 		return
 	}
 
 	respFrame, err = txn.resp.GetFrame()
+	if err == qrpc.ErrConnAlreadyClosed {
+		err = ErrConnectionLost
+	}
 	if err == nil {
 		txn.firstFrame = respFrame
 	}
 	return
 }
 
+// timedOut fails txn with server.ErrTxnTimedOut, and closes the stream if not yet,
+// server consumes frames of the stream without answering after timeout.
+func (txn *Txn) timedOut() {
+	txn.err = server.ErrTxnTimedOut
+	if !txn.ended {
+		txn.sw.StartWrite(server.DiscardCmd)
+		txn.sw.EndWrite(true)
+		txn.ended = true
+	}
+}
+
 func (txn *Txn) request(cmd qrpc.Cmd, bytes []byte, end bool) (noop bool, err error) {
+	if txn.err != nil {
+		err = txn.err
+		return
+	}
+
 	if txn.sw != nil {
+		if txn.con.IsClosed() {
+			err = ErrConnectionLost
+			return
+		}
 		txn.sw.StartWrite(cmd)
 		txn.sw.WriteBytes(bytes)
 		err = txn.sw.EndWrite(end)
+		if err != nil && txn.con.IsClosed() {
+			err = ErrConnectionLost
+		}
+		if err == nil && end {
+			txn.ended = true
+		}
 		return
 	}
 
@@ -76,21 +127,33 @@ func (txn *Txn) request(cmd qrpc.Cmd, bytes []byte, end bool) (noop bool, err er
 		return
 	}
 
-	if txn.update {
-		cmd = qrpc.CmdWithOpaque(cmd, 1)
+	switch {
+	case txn.split:
+		cmd = qrpc.CmdWithOpaque(cmd, server.TxnOpaqueSplit)
+	case txn.update:
+		cmd = qrpc.CmdWithOpaque(cmd, server.TxnOpaqueUpdate)
 	}
 
 	flag := qrpc.StreamFlag
 	if end {
 		flag |= qrpc.StreamEndFlag
 	}
-	sw, resp, err := txn.c.con.StreamRequest(cmd, flag, bytes)
+	con, err := txn.c.pool.get()
+	if err != nil {
+		return
+	}
+	sw, resp, err := con.StreamRequest(cmd, flag, bytes)
 	if err != nil {
+		if con.IsClosed() {
+			err = ErrConnectionLost
+		}
 		return
 	}
 
+	txn.con = con
 	txn.sw = sw
 	txn.resp = resp
+	txn.ended = end
 	return
 }
 
@@ -133,6 +196,17 @@ func (txn *Txn) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
 	return
 }
 
+// TTL is like Client.TTL but reads from txn
+func (txn *Txn) TTL(k []byte) (ttl time.Duration, err error) {
+	_, meta, err := txn.Get(k)
+	if err != nil {
+		return
+	}
+
+	ttl, err = ttlFromMeta(meta)
+	return
+}
+
 // ErrMutateForROTxn when trying to delete/set on readonly txn
 var ErrMutateForROTxn = errors.New("mutate for readonly txn")
 
@@ -161,7 +235,66 @@ func (txn *Txn) Delete(k []byte) (err error) {
 	return
 }
 
-func parseCommitResp(respFrame *qrpc.Frame) (err error) {
+// Inc is like Client.Inc but within the transaction
+func (txn *Txn) Inc(key []byte, delta int64) (n int64, err error) {
+	if !txn.update {
+		err = ErrMutateForROTxn
+		return
+	}
+
+	req := pb.IncRequest{Key: key, Delta: delta}
+	bytes, _ := req.Marshal()
+
+	_, err = txn.request(server.IncCmd, bytes, false)
+	if err != nil {
+		return
+	}
+
+	respFrame, err := txn.getRespFrame()
+	if err != nil {
+		return
+	}
+
+	n, err = parseIncRespFromFrame(respFrame)
+	return
+}
+
+// CompareAndSet is like Client.CompareAndSet but within the transaction,
+// the compare is against what the transaction sees, and a concurrent write of key fails the commit with conflict.
+func (txn *Txn) CompareAndSet(key, expected, newValue []byte) (swapped bool, current []byte, err error) {
+	swapped, current, err = txn.cas(&pb.CASRequest{Key: key, Expected: expected, NewValue: newValue, ExpectAbsent: expected == nil})
+	return
+}
+
+// CompareAndDelete is like CompareAndSet but deletes key instead
+func (txn *Txn) CompareAndDelete(key, expected []byte) (deleted bool, current []byte, err error) {
+	deleted, current, err = txn.cas(&pb.CASRequest{Key: key, Expected: expected, ExpectAbsent: expected == nil, Delete: true})
+	return
+}
+
+func (txn *Txn) cas(req *pb.CASRequest) (swapped bool, current []byte, err error) {
+	if !txn.update {
+		err = ErrMutateForROTxn
+		return
+	}
+
+	bytes, _ := req.Marshal()
+
+	_, err = txn.request(server.CASCmd, bytes, false)
+	if err != nil {
+		return
+	}
+
+	respFrame, err := txn.getRespFrame()
+	if err != nil {
+		return
+	}
+
+	swapped, current, err = parseCASRespFromFrame(respFrame)
+	return
+}
+
+func parseCommitResp(respFrame *qrpc.Frame) (splits int, err error) {
 
 	var commitResp pb.CommitResponse
 	err = commitResp.Unmarshal(respFrame.Payload)
@@ -169,8 +302,10 @@ func parseCommitResp(respFrame *qrpc.Frame) (err error) {
 		return
 	}
 
+	splits = int(commitResp.Splits)
+
 	if commitResp.Code != 0 {
-		err = newPBError(commitResp.Code, commitResp.Msg)
+		err = errorFromCode(commitResp.Code, commitResp.Msg)
 		return
 	}
 
@@ -193,7 +328,7 @@ func (txn *Txn) Commit() (err error) {
 		return
 	}
 
-	err = parseCommitResp(respFrame)
+	txn.splits, err = parseCommitResp(respFrame)
 
 	return
 }
@@ -236,7 +371,7 @@ func (txn *Txn) Scan(option mondis.ScanOption) (entries []mondis.Entry, err erro
 		return
 	}
 
-	entries, err = parseScanRespFromFrame(respFrame)
+	entries, err = parseScanRespFromFrame(respFrame, option.KeysOnly)
 
 	return
 }
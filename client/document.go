@@ -0,0 +1,132 @@
+package client
+
+import (
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/mondis/server"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DocInsert inserts doc into collection of db on server side and returns the allocated did,
+// it fails unless server.Option.EnableDocumentCmds is set.
+func (c *Client) DocInsert(db, collection string, doc interface{}) (did int64, err error) {
+	payload, err := bson.Marshal(doc)
+	if err != nil {
+		return
+	}
+
+	req := pb.DocInsertRequest{Db: db, Collection: collection, Payload: payload}
+	bytes, _ := req.Marshal()
+	resp, err := c.request(server.DocInsertCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var insertResp pb.DocInsertResponse
+	err = insertResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if insertResp.Code != 0 {
+		err = errorFromCode(insertResp.Code, insertResp.Msg)
+		return
+	}
+
+	did = insertResp.Did
+	return
+}
+
+// DocGet gets the document of did, dml.ErrDocNotFound is returned if it doesn't exist.
+func (c *Client) DocGet(db, collection string, did int64) (doc bson.Raw, err error) {
+	req := pb.DocGetRequest{Db: db, Collection: collection, Did: did}
+	bytes, _ := req.Marshal()
+	resp, err := c.request(server.DocGetCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var getResp pb.DocGetResponse
+	err = getResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if getResp.Code != 0 {
+		err = errorFromCode(getResp.Code, getResp.Msg)
+		return
+	}
+
+	doc = getResp.Payload
+	return
+}
+
+// DocUpdate replaces the document of did with doc,
+// dml.ErrDocNotFound is returned if it doesn't exist unless upsert is true.
+func (c *Client) DocUpdate(db, collection string, did int64, doc interface{}, upsert bool) (isNew bool, err error) {
+	payload, err := bson.Marshal(doc)
+	if err != nil {
+		return
+	}
+
+	req := pb.DocUpdateRequest{Db: db, Collection: collection, Did: did, Payload: payload, Upsert: upsert}
+	bytes, _ := req.Marshal()
+	resp, err := c.request(server.DocUpdateCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var updateResp pb.DocUpdateResponse
+	err = updateResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if updateResp.Code != 0 {
+		err = errorFromCode(updateResp.Code, updateResp.Msg)
+		return
+	}
+
+	isNew = updateResp.IsNew
+	return
+}
+
+// DocDelete deletes the document of did, it's not an error if it doesn't exist.
+func (c *Client) DocDelete(db, collection string, did int64) (err error) {
+	req := pb.DocDeleteRequest{Db: db, Collection: collection, Did: did}
+	bytes, _ := req.Marshal()
+	resp, err := c.request(server.DocDeleteCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var deleteResp pb.DocDeleteResponse
+	err = deleteResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if deleteResp.Code != 0 {
+		err = errorFromCode(deleteResp.Code, deleteResp.Msg)
+	}
+	return
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"io"
+
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/mondis/server"
+	"github.com/zhiqiangxu/qrpc"
+)
+
+// FsckScope is the same as document.CheckScope, which is not imported to keep the client light
+type FsckScope int32
+
+const (
+	// FsckScopeMeta checks the database list only
+	FsckScopeMeta FsckScope = iota
+	// FsckScopeDB checks one database specified by FsckOption.DBID
+	FsckScopeDB
+	// FsckScopeAll checks the database list and every database
+	FsckScopeAll
+)
+
+// FsckOption for Fsck, the pause between two databases is decided by server
+type FsckOption struct {
+	Scope FsckScope
+	DBID  int64
+	// Repair fixes the repairable issues
+	Repair bool
+}
+
+// FsckIssue is an inconsistency found by Fsck
+type FsckIssue struct {
+	Msg      string
+	Repaired bool
+}
+
+// FsckReport of Fsck
+type FsckReport struct {
+	// Checked is the number of checked units, the database list counts as one
+	Checked int64
+	Issues  []FsckIssue
+}
+
+// Repaired returns the number of repaired issues
+func (r *FsckReport) Repaired() (n int) {
+	for _, issue := range r.Issues {
+		if issue.Repaired {
+			n++
+		}
+	}
+	return
+}
+
+// ReportStream for reading fsck progress
+type ReportStream interface {
+	// Next returns the report so far, done is true for the final report,
+	// io.EOF is returned after the final report.
+	// Progress frames not read block the underlying connection, so it should be read until done.
+	Next() (report FsckReport, done bool, err error)
+}
+
+type reportStream struct {
+	resp       qrpc.Response
+	firstFrame *qrpc.Frame
+	done       bool
+	// issues of all frames read, each frame only carries new ones
+	issues []FsckIssue
+}
+
+func (rs *reportStream) Next() (report FsckReport, done bool, err error) {
+	if rs.done {
+		err = io.EOF
+		return
+	}
+
+	var frame *qrpc.Frame
+	if rs.firstFrame == nil {
+		frame, err = rs.resp.GetFrame()
+		if err != nil {
+			return
+		}
+		rs.firstFrame = frame
+	} else {
+		frame = <-rs.firstFrame.FrameCh()
+		if frame == nil {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+	}
+
+	var fsckResp pb.FsckResponse
+	err = fsckResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	rs.done = fsckResp.Done
+	if fsckResp.Code != 0 {
+		rs.done = true
+		err = errorFromCode(fsckResp.Code, fsckResp.Msg)
+		return
+	}
+
+	for _, issue := range fsckResp.Issues {
+		rs.issues = append(rs.issues, FsckIssue{Msg: issue.Msg, Repaired: issue.Repaired})
+	}
+	report.Checked = fsckResp.Checked
+	report.Issues = rs.issues[:len(rs.issues):len(rs.issues)]
+	done = fsckResp.Done
+	return
+}
+
+// Fsck checks consistency of document meta on server side, which needs server.Option.EnableFsckCmd
+func (c *Client) Fsck(option FsckOption) (rs ReportStream, err error) {
+	req := pb.FsckRequest{Scope: int32(option.Scope), DbId: option.DBID, Repair: option.Repair}
+	bytes, _ := req.Marshal()
+
+	con, err := c.pool.get()
+	if err != nil {
+		return
+	}
+	_, resp, err := con.StreamRequest(server.FsckCmd, qrpc.NBFlag|qrpc.StreamEndFlag, bytes)
+	if err != nil {
+		return
+	}
+
+	rs = &reportStream{resp: resp}
+	return
+}
+
+// CancelFsck cancels the running fsck on server side, which needs server.Option.EnableFsckCmd
+func (c *Client) CancelFsck() (err error) {
+	resp, err := c.request(server.FsckCancelCmd, nil)
+	if err != nil {
+		return
+	}
+
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var cancelResp pb.FsckCancelResponse
+	err = cancelResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+
+	if cancelResp.Code != 0 {
+		err = errorFromCode(cancelResp.Code, cancelResp.Msg)
+		return
+	}
+
+	return
+}
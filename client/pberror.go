@@ -1,6 +1,12 @@
 package client
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/zhiqiangxu/mondis/document/dml"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/server"
+)
 
 type pbError struct {
 	Code int32
@@ -14,3 +20,50 @@ func newPBError(code int32, msg string) *pbError {
 func (pbe *pbError) Error() string {
 	return fmt.Sprintf("pbError code:%d msg:%s", pbe.Code, pbe.Msg)
 }
+
+// errorFromCode converts well known codes to errors, others to pbError
+func errorFromCode(code int32, msg string) error {
+	switch code {
+	case server.CodeTxnConflict:
+		return kv.ErrTxnConflict
+	case server.CodeTxnTooBig:
+		return kv.ErrTxnTooBig
+	case server.CodeMaintenance:
+		return server.ErrMaintenance
+	case server.CodeFsckRunning:
+		return server.ErrFsckRunning
+	case server.CodeNoFsckRunning:
+		return server.ErrNoFsckRunning
+	case server.CodeOverflow:
+		return kv.ErrOverflow
+	case server.CodeDraining:
+		return server.ErrDraining
+	case server.CodeWatcherTooSlow:
+		return server.ErrWatcherTooSlow
+	case server.CodeAuthFailed:
+		return server.ErrAuthFailed
+	case server.CodePermissionDenied:
+		return server.ErrPermissionDenied
+	case server.CodeTxnTimedOut:
+		return server.ErrTxnTimedOut
+	case server.CodeBackupUnavailable:
+		return kv.ErrBackupUnavailable
+	case server.CodeNotInMaintenance:
+		return server.ErrNotInMaintenance
+	case server.CodeSnapshotNotFound:
+		return server.ErrSnapshotNotFound
+	case server.CodePreCommitRejected:
+		return &server.PreCommitError{Msg: msg}
+	case server.CodeValidationFailed:
+		return &server.ValidationError{Msg: msg}
+	case server.CodeDBNotExists:
+		return dml.ErrDBNotExists
+	case server.CodeCollectionNotExists:
+		return dml.ErrCollectionNotExists
+	case server.CodeDocNotFound:
+		return dml.ErrDocNotFound
+	case server.CodeUniqueViolated:
+		return dml.ErrUniqueViolated
+	}
+	return newPBError(code, msg)
+}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/mondis/server"
+	"github.com/zhiqiangxu/qrpc"
+)
+
+const (
+	defaultReconnectBackoff = 50 * time.Millisecond
+	// the backoff stops doubling after this many consecutive failures
+	maxReconnectBackoffShift = 5
+)
+
+// connPool round-robins requests over PoolSize connections.
+// Connections are multiplexed, so the pool never blocks when all of them are in use,
+// concurrent requests and transactions simply share them.
+// A dead connection is evicted and redialed lazily on its next use,
+// the dial error is returned to the caller if the server is unreachable,
+// and callers without another connection wait for a dial in progress instead of starting their own.
+// After a failed dial the slot is skipped until its backoff elapses,
+// which doubles on each consecutive failure.
+type connPool struct {
+	addr     string
+	conf     qrpc.ConnectionConfig
+	token    string
+	backoff  time.Duration
+	next     uint32
+	mu       sync.Mutex
+	cons     []*qrpc.Connection
+	failures []uint
+	retryAt  []time.Time
+	// dialing is closed when the dial of the slot is done, nil if not dialing
+	dialing []chan struct{}
+	closed  bool
+}
+
+var (
+	// ErrClientClosed when using a closed client
+	ErrClientClosed = errors.New("client already closed")
+	// ErrConnectionLost when the connection breaks, or no connection can be made before backoff elapses
+	ErrConnectionLost = errors.New("connection lost")
+)
+
+func newConnPool(addr string, conf qrpc.ConnectionConfig, size int, backoff time.Duration, token string) *connPool {
+	if size <= 0 {
+		size = 1
+	}
+	if backoff <= 0 {
+		backoff = defaultReconnectBackoff
+	}
+
+	return &connPool{
+		addr:     addr,
+		conf:     conf,
+		token:    token,
+		backoff:  backoff,
+		cons:     make([]*qrpc.Connection, size),
+		failures: make([]uint, size),
+		retryAt:  make([]time.Time, size),
+		dialing:  make([]chan struct{}, size),
+	}
+}
+
+func (p *connPool) get() (con *qrpc.Connection, err error) {
+	con, err = p.getExcept(nil)
+	return
+}
+
+// getExcept is like get but prefers connections other than skip,
+// skip is returned only if no other connection is available.
+// Dials are made outside p.mu, so a slow dial only blocks callers that have no other connection to use.
+func (p *connPool) getExcept(skip *qrpc.Connection) (con *qrpc.Connection, err error) {
+	idx := int(atomic.AddUint32(&p.next, 1) % uint32(len(p.cons)))
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			err = ErrClientClosed
+			return
+		}
+
+		now := time.Now()
+		fallback := false
+		var dialing chan struct{}
+		for i := 0; i < len(p.cons); i++ {
+			slot := (idx + i) % len(p.cons)
+			c := p.cons[slot]
+			if c != nil && !c.IsClosed() {
+				if c == skip {
+					fallback = true
+					continue
+				}
+				p.mu.Unlock()
+				con = c
+				return
+			}
+			if p.dialing[slot] != nil {
+				dialing = p.dialing[slot]
+				continue
+			}
+			if now.Before(p.retryAt[slot]) {
+				continue
+			}
+
+			// connection is closed by qrpc when the underlying conn breaks, evict and redial
+			p.cons[slot] = nil
+			done := make(chan struct{})
+			p.dialing[slot] = done
+			p.mu.Unlock()
+			con, err = p.redial(slot, done)
+			return
+		}
+		p.mu.Unlock()
+
+		if fallback {
+			con = skip
+			return
+		}
+		if dialing == nil {
+			err = ErrConnectionLost
+			return
+		}
+		// wait for the dial of another caller
+		<-dialing
+	}
+}
+
+// redial dials for slot, which is marked as dialing by done, then publishes the connection or backs off the slot
+func (p *connPool) redial(slot int, done chan struct{}) (con *qrpc.Connection, err error) {
+	con, err = p.dial()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dialing[slot] = nil
+	close(done)
+
+	if p.closed {
+		if con != nil {
+			con.Close()
+			con = nil
+		}
+		err = ErrClientClosed
+		return
+	}
+	if err != nil {
+		shift := p.failures[slot]
+		if shift > maxReconnectBackoffShift {
+			shift = maxReconnectBackoffShift
+		}
+		p.failures[slot]++
+		p.retryAt[slot] = time.Now().Add(p.backoff << shift)
+		return
+	}
+	p.cons[slot] = con
+	p.failures[slot] = 0
+	return
+}
+
+// dial a new connection, which is authenticated by token if any
+func (p *connPool) dial() (con *qrpc.Connection, err error) {
+	con, err = qrpc.NewConnection(p.addr, p.conf, nil)
+	if err != nil || p.token == "" {
+		return
+	}
+
+	req := pb.AuthRequest{Token: p.token}
+	bytes, _ := req.Marshal()
+	_, resp, err := con.Request(server.AuthCmd, qrpc.NBFlag, bytes)
+	if err == nil {
+		var frame *qrpc.Frame
+		frame, err = resp.GetFrame()
+		if err == nil {
+			var authResp pb.AuthResponse
+			err = authResp.Unmarshal(frame.Payload)
+			if err == nil && authResp.Code != 0 {
+				err = errorFromCode(authResp.Code, authResp.Msg)
+			}
+		}
+	}
+	if err != nil {
+		con.Close()
+		con = nil
+	}
+	return
+}
+
+func (p *connPool) close() (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		err = ErrClientClosed
+		return
+	}
+	p.closed = true
+
+	for i, con := range p.cons {
+		if con != nil {
+			con.Close()
+			p.cons[i] = nil
+		}
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhiqiangxu/qrpc"
+)
+
+// readRetryBackoff is the base wait before retrying a read, it doubles on each retry and is jittered
+const readRetryBackoff = 10 * time.Millisecond
+
+// Stats for client
+type Stats struct {
+	// ReadRetries is the number of times idempotent reads are retried on transient errors
+	ReadRetries uint64
+	// ReadFailures is the number of idempotent reads that fail with transient errors after all retries
+	ReadFailures uint64
+}
+
+// Stats returns current stats of client
+func (c *Client) Stats() (stats Stats) {
+	stats.ReadRetries = atomic.LoadUint64(&c.readRetryCount)
+	stats.ReadFailures = atomic.LoadUint64(&c.readFailureCount)
+	return
+}
+
+// isTransientErr tells whether err is from the transport rather than the server,
+// in which case a fresh connection may succeed
+func isTransientErr(err error) bool {
+	switch err {
+	case qrpc.ErrConnAlreadyClosed, ErrConnectionLost:
+		return true
+	}
+
+	// dial timeout, connection reset etc.
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// readRequest is like request but waits for the response frame,
+// and retries on transient errors up to Option.ReadRetries times on another connection,
+// so it must only be used for idempotent reads outside transactions.
+func (c *Client) readRequest(cmd qrpc.Cmd, bytes []byte) (frame *qrpc.Frame, err error) {
+	var failed *qrpc.Connection
+	for retry := 0; ; retry++ {
+		var con *qrpc.Connection
+		con, err = c.pool.getExcept(failed)
+		if err == nil {
+			var resp qrpc.Response
+			_, resp, err = con.Request(cmd, qrpc.NBFlag, bytes)
+			if err == nil {
+				frame, err = resp.GetFrame()
+			}
+		}
+		if err == nil || !isTransientErr(err) {
+			return
+		}
+
+		if retry >= c.readRetries {
+			atomic.AddUint64(&c.readFailureCount, 1)
+			return
+		}
+		atomic.AddUint64(&c.readRetryCount, 1)
+		if con != nil {
+			failed = con
+		}
+
+		backoff := readRetryBackoff << uint(retry)
+		time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2))))
+	}
+}
@@ -0,0 +1,421 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/util"
+)
+
+var (
+	// ErrNoShard when a key is not covered by any ShardSpec
+	ErrNoShard = errors.New("no shard for key")
+	// ErrCrossShard when a txn of Sharded touches keys of shards on different servers,
+	// since there's no atomicity across them
+	ErrCrossShard = errors.New("txn across shards")
+)
+
+type (
+	// ShardSpec maps keys in [Start, End) to the server at Addr
+	ShardSpec struct {
+		// Start is the inclusive lower bound, nil means unbounded
+		Start []byte
+		// End is the exclusive upper bound, nil means unbounded
+		End []byte
+		// Prefix overrides Start and End by the range of keys with Prefix if not nil
+		Prefix []byte
+		Addr   string
+	}
+	// Sharded implements mondis.Client over independent servers by static key ranges,
+	// keys of a Txn must be within a single shard.
+	Sharded struct {
+		// shards are sorted by start and don't overlap
+		shards     []shard
+		clients    []*Client
+		maxRetries int
+	}
+	shard struct {
+		start, end []byte
+		c          *Client
+	}
+)
+
+var _ mondis.Client = (*Sharded)(nil)
+
+// NewSharded is ctor for Sharded, one Client is created for each distinct address with option,
+// it fails if shards overlap.
+func NewSharded(specs []ShardSpec, option Option) (s *Sharded, err error) {
+	if len(specs) == 0 {
+		err = errors.New("no shards")
+		return
+	}
+
+	clients := make(map[string]*Client)
+	s = &Sharded{maxRetries: option.MaxRetries}
+	for _, spec := range specs {
+		start, end := spec.Start, spec.End
+		if spec.Prefix != nil {
+			start, end = spec.Prefix, prefixEnd(spec.Prefix)
+		}
+		if end != nil && bytes.Compare(start, end) >= 0 {
+			err = fmt.Errorf("empty shard [%q, %q) of %s", start, end, spec.Addr)
+			s.Close()
+			s = nil
+			return
+		}
+
+		c := clients[spec.Addr]
+		if c == nil {
+			c = New(spec.Addr, option).(*Client)
+			clients[spec.Addr] = c
+			s.clients = append(s.clients, c)
+		}
+		s.shards = append(s.shards, shard{start: start, end: end, c: c})
+	}
+
+	sort.Slice(s.shards, func(i, j int) bool {
+		return bytes.Compare(s.shards[i].start, s.shards[j].start) < 0
+	})
+	for i := 1; i < len(s.shards); i++ {
+		prev := s.shards[i-1]
+		if prev.end == nil || bytes.Compare(prev.end, s.shards[i].start) > 0 {
+			err = fmt.Errorf("shard starting at %q overlaps with the previous one", s.shards[i].start)
+			s.Close()
+			s = nil
+			return
+		}
+	}
+	return
+}
+
+// prefixEnd returns the smallest key greater than all keys with prefix, nil if there's none
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append([]byte(nil), prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// shardIndex returns the index of the shard containing key, -1 if none
+func (s *Sharded) shardIndex(key []byte) int {
+	// the first shard starting after key
+	i := sort.Search(len(s.shards), func(i int) bool {
+		return bytes.Compare(s.shards[i].start, key) > 0
+	})
+	if i == 0 {
+		return -1
+	}
+	sh := s.shards[i-1]
+	if sh.end != nil && bytes.Compare(key, sh.end) >= 0 {
+		return -1
+	}
+	return i - 1
+}
+
+func (s *Sharded) route(key []byte) (c *Client, err error) {
+	i := s.shardIndex(key)
+	if i < 0 {
+		err = ErrNoShard
+		return
+	}
+	c = s.shards[i].c
+	return
+}
+
+// overlapping returns indexes of shards overlapping the range of option in key order
+func (s *Sharded) overlapping(option mondis.ScanOption) (idxes []int) {
+	start, end := scanRange(option)
+	for i, sh := range s.shards {
+		if end != nil && bytes.Compare(sh.start, end) >= 0 {
+			break
+		}
+		if sh.end != nil && bytes.Compare(sh.end, start) <= 0 {
+			continue
+		}
+		idxes = append(idxes, i)
+	}
+	return
+}
+
+// scanRange returns [start, end) that contains all keys scanned with option, end is nil if unbounded
+func scanRange(option mondis.ScanOption) (start, end []byte) {
+	start, end = option.Prefix, prefixEnd(option.Prefix)
+	lower, upper := option.Offset, option.Stop
+	if option.Reverse {
+		lower, upper = option.Stop, option.Offset
+		// Offset is inclusive when scanning backwards
+		if upper != nil {
+			upper = append(append([]byte(nil), upper...), 0)
+		}
+	}
+	if bytes.Compare(lower, start) > 0 {
+		start = lower
+	}
+	if upper != nil && (end == nil || bytes.Compare(upper, end) < 0) {
+		end = upper
+	}
+	return
+}
+
+// Set for implement mondis.Client
+func (s *Sharded) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	c, err := s.route(k)
+	if err != nil {
+		return
+	}
+	err = c.Set(k, v, meta)
+	return
+}
+
+// Exists for implement mondis.Client
+func (s *Sharded) Exists(k []byte) (exists bool, err error) {
+	c, err := s.route(k)
+	if err != nil {
+		return
+	}
+	exists, err = c.Exists(k)
+	return
+}
+
+// Get for implement mondis.Client
+func (s *Sharded) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	c, err := s.route(k)
+	if err != nil {
+		return
+	}
+	v, meta, err = c.Get(k)
+	return
+}
+
+// Delete for implement mondis.Client
+func (s *Sharded) Delete(k []byte) (err error) {
+	c, err := s.route(k)
+	if err != nil {
+		return
+	}
+	err = c.Delete(k)
+	return
+}
+
+// Scan for implement mondis.Client, shards overlapping option are scanned concurrently,
+// since shards don't overlap, their results are merged by concatenating them in key order.
+func (s *Sharded) Scan(option mondis.ScanOption) (entries []mondis.Entry, err error) {
+	if option.Limit <= 0 {
+		return
+	}
+
+	idxes := s.overlapping(option)
+	if option.Reverse {
+		for i, j := 0, len(idxes)-1; i < j; i, j = i+1, j-1 {
+			idxes[i], idxes[j] = idxes[j], idxes[i]
+		}
+	}
+
+	results := make([][]mondis.Entry, len(idxes))
+	errs := make([]error, len(idxes))
+	var wg sync.WaitGroup
+	for i, idx := range idxes {
+		wg.Add(1)
+		go func(i int, sh shard) {
+			defer wg.Done()
+			results[i], errs[i] = sh.scan(option)
+		}(i, s.shards[idx])
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if errs[i] != nil {
+			err = errs[i]
+			entries = nil
+			return
+		}
+		entries = append(entries, result...)
+	}
+	if len(entries) > option.Limit {
+		entries = entries[:option.Limit]
+	}
+	return
+}
+
+func (sh shard) contains(key []byte) bool {
+	return bytes.Compare(key, sh.start) >= 0 && (sh.end == nil || bytes.Compare(key, sh.end) < 0)
+}
+
+// scan returns keys of option within sh, which may share the server with other shards
+func (sh shard) scan(option mondis.ScanOption) (entries []mondis.Entry, err error) {
+	if option.Reverse {
+		// Offset is inclusive, so the key at sh.end may take one of Limit
+		if sh.end != nil && (option.Offset == nil || bytes.Compare(sh.end, option.Offset) <= 0) {
+			option.Offset = sh.end
+			option.Limit++
+		}
+	} else {
+		if bytes.Compare(sh.start, option.Offset) > 0 {
+			option.Offset = sh.start
+		}
+		if sh.end != nil && (option.Stop == nil || bytes.Compare(sh.end, option.Stop) < 0) {
+			option.Stop = sh.end
+		}
+	}
+
+	all, err := sh.c.Scan(option)
+	if err != nil {
+		return
+	}
+	for _, entry := range all {
+		if sh.contains(entry.Key) {
+			entries = append(entries, entry)
+		}
+	}
+	return
+}
+
+// Update for implement mondis.Client, fn is rerun on kv.ErrTxnConflict like Client.Update
+func (s *Sharded) Update(fn func(t mondis.Txn) error) (err error) {
+	err = util.RetryOnConflict(s.maxRetries, func() (err error) {
+		txn := &shardedTxn{s: s, update: true}
+		defer txn.Discard()
+
+		err = fn(txn)
+		if err != nil {
+			return
+		}
+		err = txn.Commit()
+		return
+	})
+	return
+}
+
+// View for implement mondis.Client
+func (s *Sharded) View(fn func(t mondis.Txn) error) (err error) {
+	txn := &shardedTxn{s: s}
+	defer txn.Discard()
+
+	err = fn(txn)
+	return
+}
+
+// Close all clients
+func (s *Sharded) Close() (err error) {
+	for _, c := range s.clients {
+		closeErr := c.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// shardedTxn is bound to the server of the first key it touches,
+// shards of the same address share the server so they can be mixed.
+type shardedTxn struct {
+	s      *Sharded
+	update bool
+	c      *Client
+	txn    *Txn
+}
+
+// bind returns the txn on the server of shard i, ErrCrossShard if it's bound to another one
+func (t *shardedTxn) bind(i int) (txn *Txn, err error) {
+	c := t.s.shards[i].c
+	if t.txn == nil {
+		t.c = c
+		t.txn = newTxn(c, t.update)
+	} else if t.c != c {
+		err = ErrCrossShard
+		return
+	}
+	txn = t.txn
+	return
+}
+
+func (t *shardedTxn) route(key []byte) (txn *Txn, err error) {
+	i := t.s.shardIndex(key)
+	if i < 0 {
+		err = ErrNoShard
+		return
+	}
+	txn, err = t.bind(i)
+	return
+}
+
+// Set for implement mondis.Txn
+func (t *shardedTxn) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	txn, err := t.route(k)
+	if err != nil {
+		return
+	}
+	err = txn.Set(k, v, meta)
+	return
+}
+
+// Exists for implement mondis.Txn
+func (t *shardedTxn) Exists(k []byte) (exists bool, err error) {
+	txn, err := t.route(k)
+	if err != nil {
+		return
+	}
+	exists, err = txn.Exists(k)
+	return
+}
+
+// Get for implement mondis.Txn
+func (t *shardedTxn) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	txn, err := t.route(k)
+	if err != nil {
+		return
+	}
+	v, meta, err = txn.Get(k)
+	return
+}
+
+// Delete for implement mondis.Txn
+func (t *shardedTxn) Delete(k []byte) (err error) {
+	txn, err := t.route(k)
+	if err != nil {
+		return
+	}
+	err = txn.Delete(k)
+	return
+}
+
+// Scan for implement mondis.Txn, shards overlapping option must be on the server of the txn
+func (t *shardedTxn) Scan(option mondis.ScanOption) (entries []mondis.Entry, err error) {
+	idxes := t.s.overlapping(option)
+	if len(idxes) == 0 {
+		return
+	}
+
+	var txn *Txn
+	for _, i := range idxes {
+		txn, err = t.bind(i)
+		if err != nil {
+			return
+		}
+	}
+	entries, err = txn.Scan(option)
+	return
+}
+
+// Commit for implement mondis.Txn, it's a noop if no key is touched
+func (t *shardedTxn) Commit() (err error) {
+	if t.txn != nil {
+		err = t.txn.Commit()
+	}
+	return
+}
+
+// Discard for implement mondis.Txn
+func (t *shardedTxn) Discard() {
+	if t.txn != nil {
+		t.txn.Discard()
+	}
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/mondis/server"
+)
+
+// Snapshot is a read only view of server as of its creation, reads on it can go through any connection.
+// It should be released once done, otherwise it's released by server
+// when it's not read for server.Option.SnapshotTTL, after which reads fail with server.ErrSnapshotNotFound.
+type Snapshot struct {
+	c  *Client
+	id uint64
+}
+
+// NewSnapshot creates a snapshot on server
+func (c *Client) NewSnapshot() (snapshot *Snapshot, err error) {
+	resp, err := c.request(server.SnapshotCmd, nil)
+	if err != nil {
+		return
+	}
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var snapshotResp pb.SnapshotResponse
+	err = snapshotResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+	if snapshotResp.Code != 0 {
+		err = errorFromCode(snapshotResp.Code, snapshotResp.Msg)
+		return
+	}
+
+	snapshot = &Snapshot{c: c, id: snapshotResp.SnapshotId}
+	return
+}
+
+// Get v by k as of snapshot
+func (s *Snapshot) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	req := pb.SnapshotGetRequest{SnapshotId: s.id, Key: k}
+	bytes, _ := req.Marshal()
+
+	frame, err := s.c.readRequest(server.SnapshotGetCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	v, meta, err = parseGetRespFromFrame(frame)
+	return
+}
+
+// Scan as of snapshot, same as Client.Scan otherwise
+func (s *Snapshot) Scan(option mondis.ScanOption) (entries []mondis.Entry, err error) {
+	if option.Limit <= 0 {
+		return
+	}
+
+	if option.Limit > mondis.MaxEntry {
+		option.Limit = mondis.MaxEntry
+	}
+
+	req := pb.SnapshotScanRequest{SnapshotId: s.id, Scan: scanOption2PB(option)}
+	bytes, _ := req.Marshal()
+
+	frame, err := s.c.readRequest(server.SnapshotScanCmd, bytes)
+	if err != nil {
+		return
+	}
+
+	entries, err = parseScanRespFromFrame(frame, option.KeysOnly)
+	return
+}
+
+// Release snapshot on server, server.ErrSnapshotNotFound if it's released or expired already
+func (s *Snapshot) Release() (err error) {
+	req := pb.SnapshotReleaseRequest{SnapshotId: s.id}
+	bytes, _ := req.Marshal()
+
+	resp, err := s.c.request(server.SnapshotReleaseCmd, bytes)
+	if err != nil {
+		return
+	}
+	frame, err := resp.GetFrame()
+	if err != nil {
+		return
+	}
+
+	var releaseResp pb.SnapshotReleaseResponse
+	err = releaseResp.Unmarshal(frame.Payload)
+	if err != nil {
+		return
+	}
+	if releaseResp.Code != 0 {
+		err = errorFromCode(releaseResp.Code, releaseResp.Msg)
+	}
+	return
+}
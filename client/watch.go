@@ -0,0 +1,173 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/mondis/server"
+	"github.com/zhiqiangxu/qrpc"
+)
+
+// watchItem is an event, or the end of a watch with err if it's not canceled
+type watchItem struct {
+	ev   mondis.WatchEvent
+	err  error
+	done bool
+}
+
+// watchAckBatch is the max number of events handled before they're acked,
+// they're also acked once there's no more event to handle.
+const watchAckBatch = 64
+
+// Watch calls fn for each Set and Delete of keys with prefix committed after Watch returns,
+// server.Option.EnableWatchCmd should be set on server side.
+// fn is called sequentially in a goroutine of its own, and it should keep up with the writes,
+// otherwise server drops the watcher after server.Option.WatchMaxUnacked events are left unacked.
+// Once watching stops for another reason than cancel, e.g., dropped or connection lost,
+// fn is called a last time with WatchEvent.Err set.
+func (c *Client) Watch(prefix []byte, fn func(ev mondis.WatchEvent)) (cancel func(), err error) {
+	req := pb.WatchRequest{Prefix: prefix}
+	bytes, _ := req.Marshal()
+
+	con, err := c.pool.get()
+	if err != nil {
+		return
+	}
+	sw, resp, err := con.StreamRequest(server.WatchCmd, qrpc.NBFlag, bytes)
+	if err != nil {
+		return
+	}
+
+	var (
+		mu       sync.Mutex
+		ended    bool
+		canceled int32
+	)
+	// write a frame on our side of the stream unless it's ended
+	write := func(cmd qrpc.Cmd, bytes []byte, end bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ended {
+			return
+		}
+		sw.StartWrite(cmd)
+		sw.WriteBytes(bytes)
+		sw.EndWrite(end)
+		ended = end
+	}
+	closeStream := func() {
+		write(server.DiscardCmd, nil, true)
+	}
+
+	firstFrame, err := resp.GetFrame()
+	if err != nil {
+		closeStream()
+		return
+	}
+	var watchResp pb.WatchResponse
+	err = watchResp.Unmarshal(firstFrame.Payload)
+	if err == nil && watchResp.Code != 0 {
+		err = errorFromCode(watchResp.Code, watchResp.Msg)
+	}
+	if err != nil {
+		closeStream()
+		return
+	}
+
+	var (
+		queueMu   sync.Mutex
+		queueCond = sync.NewCond(&queueMu)
+		queue     []watchItem
+	)
+	push := func(item watchItem) {
+		queueMu.Lock()
+		queue = append(queue, item)
+		queueMu.Unlock()
+		queueCond.Signal()
+	}
+
+	// frames are read off the connection as soon as they arrive so that a slow fn doesn't block other streams,
+	// the queue is bounded by server.Option.WatchMaxUnacked since server drops the watcher beyond it.
+	go func() {
+		for {
+			frame := <-firstFrame.FrameCh()
+			if frame == nil {
+				push(watchItem{err: ErrStreamClosed})
+				return
+			}
+
+			var watchResp pb.WatchResponse
+			err := watchResp.Unmarshal(frame.Payload)
+			if err == nil && watchResp.Code != 0 {
+				err = errorFromCode(watchResp.Code, watchResp.Msg)
+			}
+			switch {
+			case err != nil:
+				push(watchItem{err: err})
+				return
+			case watchResp.Event != nil:
+				ev := watchResp.Event
+				push(watchItem{ev: mondis.WatchEvent{Seq: ev.Seq, Key: ev.Key, Value: ev.Value, Deleted: ev.Deleted}})
+			}
+			if frame.Flags.IsDone() {
+				// server ends the stream with CodeOK only after cancel
+				push(watchItem{done: true})
+				return
+			}
+		}
+	}()
+
+	go func() {
+		var acked, lastAcked uint64
+		ack := func() {
+			if acked == lastAcked {
+				return
+			}
+			req := pb.WatchRequest{Acked: acked}
+			bytes, _ := req.Marshal()
+			write(server.WatchCmd, bytes, false)
+			lastAcked = acked
+		}
+
+		for {
+			queueMu.Lock()
+			if len(queue) == 0 {
+				queueMu.Unlock()
+				ack()
+				queueMu.Lock()
+				for len(queue) == 0 {
+					queueCond.Wait()
+				}
+			}
+			item := queue[0]
+			queue = queue[1:]
+			queueMu.Unlock()
+
+			if item.done {
+				return
+			}
+			if item.err != nil {
+				if atomic.LoadInt32(&canceled) == 0 {
+					fn(mondis.WatchEvent{Err: item.err})
+				}
+				closeStream()
+				return
+			}
+			if atomic.LoadInt32(&canceled) == 0 {
+				fn(item.ev)
+			}
+			acked++
+			if acked-lastAcked >= watchAckBatch {
+				ack()
+			}
+		}
+	}()
+
+	cancel = func() {
+		atomic.StoreInt32(&canceled, 1)
+		closeStream()
+	}
+	return
+}
@@ -0,0 +1,354 @@
+// Package clientmock provides an in-memory client.API for application tests,
+// it needs neither network nor disk.
+package clientmock
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/client"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/provider"
+	"github.com/zhiqiangxu/mondis/server"
+	"github.com/zhiqiangxu/mondis/util"
+)
+
+// oneShotMaxRetries is the max number of retries on conflict of Incr, Inc and CAS, the same as server
+const oneShotMaxRetries = 20
+
+// Client is an in-memory client.API backed by provider.Memory,
+// results and errors are the same as those of client.Client talking to a server with default options,
+// except that maintenance and watch are always available as if enabled on server side.
+// Errors only converted to pbError by client.Client, e.g. an invalid KeyPattern, are returned as is.
+type Client struct {
+	kvdb        mondis.KVDB
+	maxRetries  int
+	maintenance int32
+	closed      int32
+	// mu serializes commits with publishing so that events are in commit order
+	mu      sync.Mutex
+	watches watchHub
+}
+
+var _ client.API = (*Client)(nil)
+
+// New is ctor for Client, Update doesn't retry on conflict like client.Option{}
+func New() *Client {
+	return NewWithRetries(0)
+}
+
+// NewWithRetries is like New, but Update retries on kv.ErrTxnConflict up to maxRetries times like client.Option.MaxRetries
+func NewWithRetries(maxRetries int) *Client {
+	kvdb := provider.NewMemory()
+	// never fails for Memory
+	kvdb.Open(mondis.KVOption{})
+	return &Client{kvdb: kvdb, maxRetries: maxRetries}
+}
+
+func (c *Client) checkClosed() (err error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		err = client.ErrClientClosed
+	}
+	return
+}
+
+// checkWritable is checkClosed plus maintenance mode
+func (c *Client) checkWritable() (err error) {
+	err = c.checkClosed()
+	if err != nil {
+		return
+	}
+	if c.inMaintenance() {
+		err = server.ErrMaintenance
+	}
+	return
+}
+
+func (c *Client) inMaintenance() bool {
+	return atomic.LoadInt32(&c.maintenance) == 1
+}
+
+// SetMaintenanceMode turns maintenance mode on or off, writes and update transactions fail with server.ErrMaintenance when on
+func (c *Client) SetMaintenanceMode(on bool) (err error) {
+	err = c.checkClosed()
+	if err != nil {
+		return
+	}
+
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.maintenance, v)
+	return
+}
+
+// Close drops all data, later calls fail with client.ErrClientClosed
+func (c *Client) Close() (err error) {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+
+	c.watches.close()
+	err = c.kvdb.Close()
+	return
+}
+
+// Set for implement mondis.Client
+func (c *Client) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	err = c.checkWritable()
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = c.kvdb.Set(k, v, meta)
+	if err != nil {
+		return
+	}
+	c.watches.publish([]mutation{{key: k, value: v}})
+	return
+}
+
+// Exists for implement mondis.Client
+func (c *Client) Exists(k []byte) (exists bool, err error) {
+	err = c.checkClosed()
+	if err != nil {
+		return
+	}
+
+	exists, err = c.kvdb.Exists(k)
+	return
+}
+
+// Get for implement mondis.Client
+func (c *Client) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	err = c.checkClosed()
+	if err != nil {
+		return
+	}
+
+	v, meta, err = c.kvdb.Get(k)
+	if err != nil {
+		return
+	}
+	v, meta = wireValue(v, false), wireMeta(meta)
+	return
+}
+
+// TTL is like client.Client.TTL
+func (c *Client) TTL(k []byte) (ttl time.Duration, err error) {
+	_, meta, err := c.Get(k)
+	if err != nil {
+		return
+	}
+
+	ttl, err = ttlFromMeta(meta)
+	return
+}
+
+// Delete for implement mondis.Client
+func (c *Client) Delete(k []byte) (err error) {
+	err = c.checkWritable()
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = c.kvdb.Delete(k)
+	if err != nil {
+		return
+	}
+	c.watches.publish([]mutation{{key: k, deleted: true}})
+	return
+}
+
+// Update for implement mondis.Client,
+// fn is rerun with a fresh Txn on kv.ErrTxnConflict up to the retries passed to NewWithRetries.
+func (c *Client) Update(fn func(t mondis.Txn) error) (err error) {
+	err = util.RetryOnConflict(c.maxRetries, func() error {
+		return c.update(fn)
+	})
+	return
+}
+
+func (c *Client) update(fn func(t mondis.Txn) error) (err error) {
+	txn := newTxn(c, true)
+	defer txn.Discard()
+
+	err = fn(txn)
+	if err != nil {
+		return
+	}
+
+	err = txn.Commit()
+	return
+}
+
+// View for implement mondis.Client
+func (c *Client) View(fn func(t mondis.Txn) error) (err error) {
+	txn := newTxn(c, false)
+	defer txn.Discard()
+
+	err = fn(txn)
+	return
+}
+
+// Scan for implement mondis.Client
+func (c *Client) Scan(option mondis.ScanOption) (entries []mondis.Entry, err error) {
+	if option.Limit <= 0 {
+		return
+	}
+
+	err = c.checkClosed()
+	if err != nil {
+		return
+	}
+
+	entries, err = scan(c.kvdb, option)
+	return
+}
+
+// Count is like client.Client.Count
+func (c *Client) Count(option mondis.ScanOption) (n int64, err error) {
+	err = c.checkClosed()
+	if err != nil {
+		return
+	}
+
+	limit := int64(option.Limit)
+	option.KeysOnly = true
+	err = c.kvdb.Scan(option.ProviderScanOption, func(key, value []byte, meta mondis.VMetaResp) bool {
+		n++
+		return limit <= 0 || n < limit
+	})
+	if err != nil {
+		n = 0
+	}
+	return
+}
+
+// ScanStream is like client.Client.ScanStream, option.Limit <= 0 means no limit
+func (c *Client) ScanStream(option mondis.ScanOption, fn func(entry mondis.Entry) bool) (err error) {
+	err = c.checkClosed()
+	if err != nil {
+		return
+	}
+
+	var n int
+	err = c.kvdb.Scan(option.ProviderScanOption, func(key, value []byte, meta mondis.VMetaResp) bool {
+		n++
+		if !fn(wireEntry(key, value, meta, option.KeysOnly)) {
+			return false
+		}
+		return option.Limit <= 0 || n < option.Limit
+	})
+	return
+}
+
+// runInUpdateTxn runs f in a new update txn retried on conflict, and publishes its mutations once committed
+func (c *Client) runInUpdateTxn(f func(txn *Txn) error) (err error) {
+	err = c.checkWritable()
+	if err != nil {
+		return
+	}
+
+	err = util.RetryOnConflict(oneShotMaxRetries, func() error {
+		txn := newTxn(c, true)
+		defer txn.Discard()
+
+		err := f(txn)
+		if err != nil {
+			return err
+		}
+		return txn.Commit()
+	})
+	return
+}
+
+// Incr is like client.Client.Incr
+func (c *Client) Incr(key []byte, delta int64) (n int64, err error) {
+	err = c.runInUpdateTxn(func(txn *Txn) (err error) {
+		n, err = txn.Inc(key, delta)
+		return
+	})
+	return
+}
+
+// Inc is like client.Client.Inc
+func (c *Client) Inc(key []byte, delta int64) (n int64, err error) {
+	err = c.runInUpdateTxn(func(txn *Txn) (err error) {
+		n, err = txn.Inc(key, delta)
+		return
+	})
+	return
+}
+
+// CompareAndSwap is like client.Client.CompareAndSwap
+func (c *Client) CompareAndSwap(key, expected, new []byte) (swapped bool, err error) {
+	if expected == nil {
+		// like an empty value on the wire
+		expected = []byte{}
+	}
+	swapped, _, err = c.cas(key, expected, new, false)
+	return
+}
+
+// CompareAndSwapAbsent is like client.Client.CompareAndSwapAbsent
+func (c *Client) CompareAndSwapAbsent(key, new []byte) (swapped bool, err error) {
+	swapped, _, err = c.cas(key, nil, new, false)
+	return
+}
+
+// CompareAndSet is like client.Client.CompareAndSet
+func (c *Client) CompareAndSet(key, expected, newValue []byte) (swapped bool, current []byte, err error) {
+	swapped, current, err = c.cas(key, expected, newValue, false)
+	return
+}
+
+// CompareAndDelete is like client.Client.CompareAndDelete
+func (c *Client) CompareAndDelete(key, expected []byte) (deleted bool, current []byte, err error) {
+	deleted, current, err = c.cas(key, expected, nil, true)
+	return
+}
+
+// cas compares with expected, nil expected means key must not exist
+func (c *Client) cas(key, expected, newValue []byte, delete bool) (swapped bool, current []byte, err error) {
+	err = c.runInUpdateTxn(func(txn *Txn) (err error) {
+		if delete {
+			swapped, current, err = kv.CompareAndDelete(txn.providerTxn(), key, expected)
+		} else {
+			swapped, current, err = kv.CompareAndSet(txn.providerTxn(), key, expected, newValue)
+		}
+		return
+	})
+	switch {
+	case err != nil:
+		swapped = false
+		current = nil
+	case swapped:
+		// only returned when not swapped
+		current = nil
+	default:
+		current = copyBytes(current)
+	}
+	return
+}
+
+// Watch is like client.Client.Watch, fn is called in a goroutine of its own for mutations committed after Watch returns,
+// and a last time with client.ErrStreamClosed once Client is closed.
+func (c *Client) Watch(prefix []byte, fn func(ev mondis.WatchEvent)) (cancel func(), err error) {
+	err = c.checkClosed()
+	if err != nil {
+		return
+	}
+
+	cancel = c.watches.add(copyBytes(prefix), fn)
+	return
+}
@@ -0,0 +1,212 @@
+package clientmock
+
+import (
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/client"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/server"
+)
+
+// Txn is like client.Txn but on the memory provider,
+// the provider txn is begun by the first operation like the stream of client.Txn.
+type Txn struct {
+	c         *Client
+	update    bool
+	txn       mondis.ProviderTxn
+	mutations []mutation
+	// err fails all later operations
+	err error
+}
+
+var _ mondis.Txn = (*Txn)(nil)
+
+func newTxn(c *Client, update bool) *Txn {
+	return &Txn{c: c, update: update}
+}
+
+// begin the provider txn if not yet, an update txn begun in maintenance mode fails all operations
+func (txn *Txn) begin() (err error) {
+	if txn.err != nil {
+		err = txn.err
+		return
+	}
+	if txn.txn != nil {
+		return
+	}
+
+	err = txn.c.checkClosed()
+	if err == nil && txn.update && txn.c.inMaintenance() {
+		err = server.ErrMaintenance
+	}
+	if err != nil {
+		txn.err = err
+		return
+	}
+	txn.txn = txn.c.kvdb.NewTransaction(txn.update)
+	return
+}
+
+// providerTxn is for kv helpers, which call Get, Set and Delete of Txn through it
+func (txn *Txn) providerTxn() mondis.ProviderTxn {
+	return providerTxn{txn}
+}
+
+// Set for implement mondis.Txn
+func (txn *Txn) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	if !txn.update {
+		err = client.ErrMutateForROTxn
+		return
+	}
+
+	err = txn.begin()
+	if err != nil {
+		return
+	}
+
+	err = txn.txn.Set(k, v, meta)
+	if err != nil {
+		return
+	}
+	txn.mutations = append(txn.mutations, mutation{key: copyBytes(k), value: copyBytes(v)})
+	return
+}
+
+// Exists for implement mondis.Txn
+func (txn *Txn) Exists(k []byte) (exists bool, err error) {
+	err = txn.begin()
+	if err != nil {
+		return
+	}
+
+	exists, err = txn.txn.Exists(k)
+	return
+}
+
+// Get for implement mondis.Txn
+func (txn *Txn) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	err = txn.begin()
+	if err != nil {
+		return
+	}
+
+	v, meta, err = txn.txn.Get(k)
+	if err != nil {
+		return
+	}
+	v, meta = wireValue(v, false), wireMeta(meta)
+	return
+}
+
+// TTL is like client.Txn.TTL
+func (txn *Txn) TTL(k []byte) (ttl time.Duration, err error) {
+	_, meta, err := txn.Get(k)
+	if err != nil {
+		return
+	}
+
+	ttl, err = ttlFromMeta(meta)
+	return
+}
+
+// Delete for implement mondis.Txn
+func (txn *Txn) Delete(k []byte) (err error) {
+	if !txn.update {
+		err = client.ErrMutateForROTxn
+		return
+	}
+
+	err = txn.begin()
+	if err != nil {
+		return
+	}
+
+	err = txn.txn.Delete(k)
+	if err != nil {
+		return
+	}
+	txn.mutations = append(txn.mutations, mutation{key: copyBytes(k), deleted: true})
+	return
+}
+
+// Inc is like client.Txn.Inc
+func (txn *Txn) Inc(key []byte, delta int64) (n int64, err error) {
+	if !txn.update {
+		err = client.ErrMutateForROTxn
+		return
+	}
+
+	n, err = kv.IncBinaryInt64(txn.providerTxn(), key, delta)
+	return
+}
+
+// Scan for implement mondis.Txn
+func (txn *Txn) Scan(option mondis.ScanOption) (entries []mondis.Entry, err error) {
+	if option.Limit <= 0 {
+		return
+	}
+
+	err = txn.begin()
+	if err != nil {
+		return
+	}
+
+	entries, err = scan(txn.txn, option)
+	return
+}
+
+// Commit for implement mondis.Txn, mutations are published to watchers once committed
+func (txn *Txn) Commit() (err error) {
+	if txn.err != nil {
+		err = txn.err
+		return
+	}
+	if txn.txn == nil {
+		// noop if transaction empty
+		return
+	}
+
+	txn.c.mu.Lock()
+	defer txn.c.mu.Unlock()
+
+	err = txn.txn.Commit()
+	txn.err = errTxnDone
+	if err != nil {
+		return
+	}
+	txn.c.watches.publish(txn.mutations)
+	return
+}
+
+// Discard for implement mondis.Txn
+func (txn *Txn) Discard() {
+	if txn.txn != nil {
+		txn.txn.Discard()
+	}
+	if txn.err == nil {
+		txn.err = errTxnDone
+	}
+}
+
+// providerTxn adapts Txn to mondis.ProviderTxn so that mutations are tracked
+type providerTxn struct {
+	*Txn
+}
+
+func (t providerTxn) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	err = t.begin()
+	if err != nil {
+		return
+	}
+
+	err = t.txn.Scan(option, fn)
+	return
+}
+
+func (t providerTxn) StartTS() uint64 {
+	if t.begin() != nil {
+		return 0
+	}
+	return t.txn.StartTS()
+}
@@ -0,0 +1,73 @@
+package clientmock
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/client"
+)
+
+// errTxnDone when a Txn is used after Commit or Discard
+var errTxnDone = errors.New("txn already committed or discarded")
+
+// wireValue returns a copy of v as it arrives at client.Client, nil if keysOnly, otherwise non-nil
+func wireValue(v []byte, keysOnly bool) []byte {
+	if keysOnly {
+		return nil
+	}
+	if v == nil {
+		return []byte{}
+	}
+	return copyBytes(v)
+}
+
+// wireMeta returns meta as it arrives at client.Client, Version is not sent over the wire
+func wireMeta(meta mondis.VMetaResp) mondis.VMetaResp {
+	return mondis.VMetaResp{ExpiresAt: meta.ExpiresAt, Tag: meta.Tag}
+}
+
+func wireEntry(key, value []byte, meta mondis.VMetaResp, keysOnly bool) mondis.Entry {
+	return mondis.Entry{Key: copyBytes(key), Value: wireValue(value, keysOnly), Meta: wireMeta(meta)}
+}
+
+// scan entries of option with the limit capped to mondis.MaxEntry like server, option.Limit should be positive
+func scan(kvop mondis.ProviderReadOP, option mondis.ScanOption) (entries []mondis.Entry, err error) {
+	limit := option.Limit
+	if limit > mondis.MaxEntry {
+		limit = mondis.MaxEntry
+	}
+
+	entries = []mondis.Entry{}
+	err = kvop.Scan(option.ProviderScanOption, func(key, value []byte, meta mondis.VMetaResp) bool {
+		entries = append(entries, wireEntry(key, value, meta, option.KeysOnly))
+		return len(entries) < limit
+	})
+	if err != nil {
+		entries = nil
+	}
+	return
+}
+
+func ttlFromMeta(meta mondis.VMetaResp) (ttl time.Duration, err error) {
+	if meta.ExpiresAt == 0 {
+		err = client.ErrNoTTL
+		return
+	}
+
+	ttl = time.Until(time.Unix(int64(meta.ExpiresAt), 0))
+	if ttl < 0 {
+		ttl = 0
+	}
+	return
+}
+
+// copyBytes keeps nil as nil and empty as non-nil
+func copyBytes(in []byte) (out []byte) {
+	if in == nil {
+		return
+	}
+	out = make([]byte, len(in))
+	copy(out, in)
+	return
+}
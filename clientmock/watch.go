@@ -0,0 +1,123 @@
+package clientmock
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/client"
+)
+
+// mutation is a committed Set or Delete
+type mutation struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+// watcher queues events for fn, which is called in a goroutine of its own
+type watcher struct {
+	prefix   []byte
+	fn       func(ev mondis.WatchEvent)
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []mondis.WatchEvent
+	canceled bool
+}
+
+func (w *watcher) push(ev mondis.WatchEvent) {
+	w.mu.Lock()
+	w.queue = append(w.queue, ev)
+	w.mu.Unlock()
+	w.cond.Signal()
+}
+
+func (w *watcher) cancel() {
+	w.mu.Lock()
+	w.canceled = true
+	w.mu.Unlock()
+	w.cond.Signal()
+}
+
+// run calls fn for queued events until canceled or an event with Err is handled
+func (w *watcher) run() {
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && !w.canceled {
+			w.cond.Wait()
+		}
+		if w.canceled {
+			w.mu.Unlock()
+			return
+		}
+		ev := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		w.fn(ev)
+		if ev.Err != nil {
+			return
+		}
+	}
+}
+
+// watchHub is like the one of server, but events are never dropped for being unacked
+type watchHub struct {
+	mu       sync.Mutex
+	seq      uint64
+	watchers map[*watcher]struct{}
+}
+
+func (h *watchHub) add(prefix []byte, fn func(ev mondis.WatchEvent)) (cancel func()) {
+	w := &watcher{prefix: prefix, fn: fn}
+	w.cond = sync.NewCond(&w.mu)
+
+	h.mu.Lock()
+	if h.watchers == nil {
+		h.watchers = make(map[*watcher]struct{})
+	}
+	h.watchers[w] = struct{}{}
+	h.mu.Unlock()
+
+	go w.run()
+
+	cancel = func() {
+		h.mu.Lock()
+		delete(h.watchers, w)
+		h.mu.Unlock()
+		w.cancel()
+	}
+	return
+}
+
+// publish committed mutations, each is assigned the next sequence number
+func (h *watchHub) publish(mutations []mutation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, m := range mutations {
+		h.seq++
+		for w := range h.watchers {
+			if !bytes.HasPrefix(m.key, w.prefix) {
+				continue
+			}
+			ev := mondis.WatchEvent{Seq: h.seq, Key: copyBytes(m.key), Deleted: m.deleted}
+			// an empty value is omitted on the wire
+			if len(m.value) > 0 {
+				ev.Value = copyBytes(m.value)
+			}
+			w.push(ev)
+		}
+	}
+}
+
+// close ends all watchers with client.ErrStreamClosed like a lost connection
+func (h *watchHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for w := range h.watchers {
+		w.push(mondis.WatchEvent{Err: client.ErrStreamClosed})
+	}
+	h.watchers = nil
+}
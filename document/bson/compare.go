@@ -0,0 +1,261 @@
+package bson
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OrderOf returns the bson comparison order of a value decoded by bson.Unmarshal
+func OrderOf(v interface{}) Order {
+	switch v.(type) {
+	case primitive.MinKey:
+		return MinKeyOrder
+	case nil, primitive.Null, primitive.Undefined:
+		return NullOrder
+	case int, int32, int64, float32, float64:
+		return NumberOrder
+	case string, primitive.Symbol:
+		return StringOrder
+	case bson.M, bson.D:
+		return ObjectOrder
+	case bson.A, []interface{}:
+		return ArrayOrder
+	case primitive.Binary, []byte:
+		return BinDataOrder
+	case primitive.ObjectID:
+		return ObjectIDOrder
+	case bool:
+		return BooleanOrder
+	case primitive.DateTime, time.Time:
+		return DateOrder
+	case primitive.Timestamp:
+		return TimestampOrder
+	case primitive.Regex:
+		return REOrder
+	case primitive.MaxKey:
+		return MaxKeyOrder
+	default:
+		// unknown types are compared as objects
+		return ObjectOrder
+	}
+}
+
+// Compare returns -1, 0 or 1 by the bson comparison order
+func Compare(a, b interface{}) int {
+	oa, ob := OrderOf(a), OrderOf(b)
+	if oa != ob {
+		if oa < ob {
+			return -1
+		}
+		return 1
+	}
+
+	switch oa {
+	case MinKeyOrder, NullOrder, MaxKeyOrder:
+		return 0
+	case NumberOrder:
+		return compareNumber(a, b)
+	case StringOrder:
+		return strings.Compare(toString(a), toString(b))
+	case ObjectOrder:
+		return compareObject(a, b)
+	case ArrayOrder:
+		return compareArray(toArray(a), toArray(b))
+	case BinDataOrder:
+		ba, bb := toBinary(a), toBinary(b)
+		if len(ba.Data) != len(bb.Data) {
+			return compareInt64(int64(len(ba.Data)), int64(len(bb.Data)))
+		}
+		if ba.Subtype != bb.Subtype {
+			return compareInt64(int64(ba.Subtype), int64(bb.Subtype))
+		}
+		return bytes.Compare(ba.Data, bb.Data)
+	case ObjectIDOrder:
+		ia, ib := a.(primitive.ObjectID), b.(primitive.ObjectID)
+		return bytes.Compare(ia[:], ib[:])
+	case BooleanOrder:
+		ba, bb := a.(bool), b.(bool)
+		switch {
+		case ba == bb:
+			return 0
+		case !ba:
+			return -1
+		default:
+			return 1
+		}
+	case DateOrder:
+		return compareInt64(toMillis(a), toMillis(b))
+	case TimestampOrder:
+		ta, tb := a.(primitive.Timestamp), b.(primitive.Timestamp)
+		if ta.T != tb.T {
+			return compareInt64(int64(ta.T), int64(tb.T))
+		}
+		return compareInt64(int64(ta.I), int64(tb.I))
+	case REOrder:
+		ra, rb := a.(primitive.Regex), b.(primitive.Regex)
+		if c := strings.Compare(ra.Pattern, rb.Pattern); c != 0 {
+			return c
+		}
+		return strings.Compare(ra.Options, rb.Options)
+	}
+
+	return 0
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareNumber(a, b interface{}) int {
+	fa, ca := numberKey(a)
+	fb, cb := numberKey(b)
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	default:
+		return compareInt64(ca, cb)
+	}
+}
+
+const twoTo63 = float64(1 << 63)
+
+// numberKey converts number to (float64, int64 correction) which is totally ordered across number types,
+// the correction is the exact difference between an integer and its float64 approximation,
+// and NaN is converted to (-Inf, MinInt64) so that it's less than all other numbers.
+func numberKey(v interface{}) (f float64, correction int64) {
+	i, isInt := toInt64(v)
+	if !isInt {
+		f = toFloat64(v)
+		if math.IsNaN(f) {
+			f = math.Inf(-1)
+			correction = math.MinInt64
+		}
+		return
+	}
+
+	f = float64(i)
+	if f >= twoTo63 {
+		// float64(i) rounded up to 2^63 which doesn't fit int64
+		correction = -int64(uint64(1<<63) - uint64(i))
+	} else {
+		correction = i - int64(f)
+	}
+	return
+}
+
+func toInt64(v interface{}) (i int64, ok bool) {
+	ok = true
+	switch n := v.(type) {
+	case int:
+		i = int64(n)
+	case int32:
+		i = int64(n)
+	case int64:
+		i = n
+	default:
+		ok = false
+	}
+	return
+}
+
+func toFloat64(v interface{}) float64 {
+	switch n := v.(type) {
+	case float32:
+		return float64(n)
+	case float64:
+		return n
+	}
+	i, _ := toInt64(v)
+	return float64(i)
+}
+
+func toString(v interface{}) string {
+	if s, ok := v.(primitive.Symbol); ok {
+		return string(s)
+	}
+	return v.(string)
+}
+
+func toArray(v interface{}) []interface{} {
+	if a, ok := v.(bson.A); ok {
+		return a
+	}
+	return v.([]interface{})
+}
+
+func toBinary(v interface{}) primitive.Binary {
+	if b, ok := v.(primitive.Binary); ok {
+		return b
+	}
+	return primitive.Binary{Data: v.([]byte)}
+}
+
+func toMillis(v interface{}) int64 {
+	if t, ok := v.(time.Time); ok {
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	return int64(v.(primitive.DateTime))
+}
+
+func compareArray(a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt64(int64(len(a)), int64(len(b)))
+}
+
+func compareObject(a, b interface{}) int {
+	da, okA := toD(a)
+	db, okB := toD(b)
+	if !okA || !okB {
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+
+	for i := 0; i < len(da) && i < len(db); i++ {
+		if c := Compare(da[i].Value, db[i].Value); c != 0 {
+			return c
+		}
+		if c := strings.Compare(da[i].Key, db[i].Key); c != 0 {
+			return c
+		}
+	}
+	return compareInt64(int64(len(da)), int64(len(db)))
+}
+
+// toD converts object to bson.D, bson.M is ordered by key since it's unordered
+func toD(v interface{}) (d bson.D, ok bool) {
+	switch o := v.(type) {
+	case bson.D:
+		return o, true
+	case bson.M:
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d = make(bson.D, 0, len(keys))
+		for _, k := range keys {
+			d = append(d, bson.E{Key: k, Value: o[k]})
+		}
+		return d, true
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package bson
+
+import (
+	"errors"
+
+	"github.com/zhiqiangxu/mondis/kv/memcomparable"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrUnsupportedIndexValue when value type can't be indexed
+var ErrUnsupportedIndexValue = errors.New("unsupported index value type")
+
+// AppendIndexValue appends the memcomparable encoding of v to buf,
+// the encoded bytes sort the same way as Compare.
+//
+// Each value is prefixed by its Order as type tag, so that cross-type ordering is well defined.
+// Encodings are prefix free, so values can be concatenated for compound indexes,
+// and desc simply inverts the appended bytes.
+func AppendIndexValue(buf []byte, v interface{}, desc bool) (result []byte, err error) {
+	start := len(buf)
+	result, err = appendIndexValue(buf, v)
+	if err != nil {
+		result = buf
+		return
+	}
+
+	if desc {
+		for i := start; i < len(result); i++ {
+			result[i] = ^result[i]
+		}
+	}
+	return
+}
+
+func appendIndexValue(buf []byte, v interface{}) (result []byte, err error) {
+	order := OrderOf(v)
+	result = append(buf, byte(order))
+
+	switch order {
+	case MinKeyOrder, NullOrder, MaxKeyOrder:
+	case NumberOrder:
+		result = appendNumber(result, v)
+	case StringOrder:
+		result = memcomparable.EncodeBytes(result, []byte(toString(v)))
+	case BinDataOrder:
+		b := toBinary(v)
+		result = memcomparable.EncodeUint64(result, uint64(len(b.Data)))
+		result = append(result, b.Subtype)
+		result = memcomparable.EncodeBytes(result, b.Data)
+	case ObjectIDOrder:
+		oid := v.(primitive.ObjectID)
+		result = append(result, oid[:]...)
+	case BooleanOrder:
+		if v.(bool) {
+			result = append(result, 1)
+		} else {
+			result = append(result, 0)
+		}
+	case DateOrder:
+		result = memcomparable.EncodeInt64(result, toMillis(v))
+	case TimestampOrder:
+		ts := v.(primitive.Timestamp)
+		result = memcomparable.EncodeUint64(result, uint64(ts.T)<<32|uint64(ts.I))
+	default:
+		err = ErrUnsupportedIndexValue
+	}
+	return
+}
+
+// appendNumber encodes number as numberKey
+func appendNumber(buf []byte, v interface{}) []byte {
+	f, correction := numberKey(v)
+	buf = memcomparable.EncodeFloat64(buf, f)
+	return memcomparable.EncodeInt64(buf, correction)
+}
@@ -0,0 +1,110 @@
+package bson
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gotest.tools/assert"
+)
+
+func TestIndexValueOrder(t *testing.T) {
+	// in ascending order, adjacent values in the same group are equal
+	groups := [][]interface{}{
+		{primitive.MinKey{}},
+		{nil, primitive.Null{}},
+		{math.NaN()},
+		{math.Inf(-1)},
+		{int64(math.MinInt64)},
+		{int64(-1 << 53), float64(-1 << 53)},
+		{-1.5},
+		{int32(-1), int64(-1), -1.0},
+		{-0.5},
+		{int32(0), 0.0, math.Copysign(0, -1)},
+		{0.5},
+		{int32(1), int64(1), 1.0},
+		{1.5},
+		{int32(math.MaxInt32)},
+		{int64(1 << 53), float64(1 << 53)},
+		{int64(1<<53 + 1)},
+		{float64(1<<53 + 2), int64(1<<53 + 2)},
+		{int64(math.MaxInt64 - 1)},
+		{int64(math.MaxInt64)},
+		{float64(1 << 63)},
+		{math.MaxFloat64},
+		{math.Inf(1)},
+		{""},
+		{"\x00"},
+		{"a"},
+		{"a\x00"},
+		{"ab"},
+		{"abcdefghijklmnop"},
+		{"b"},
+		{primitive.Binary{Data: []byte{9}}},
+		{primitive.Binary{Subtype: 1, Data: []byte{0}}},
+		{primitive.Binary{Data: []byte{0, 0}}},
+		{primitive.Binary{Data: []byte{0, 1}}},
+		{primitive.ObjectID{}},
+		{primitive.ObjectID{1}},
+		{false},
+		{true},
+		{primitive.DateTime(-1)},
+		{primitive.DateTime(0)},
+		{primitive.DateTime(1)},
+		{primitive.Timestamp{T: 1, I: 2}},
+		{primitive.Timestamp{T: 2, I: 1}},
+		{primitive.MaxKey{}},
+	}
+
+	for _, desc := range []bool{false, true} {
+		var prev []byte
+		for i, group := range groups {
+			var first []byte
+			for j, v := range group {
+				encoded, err := AppendIndexValue(nil, v, desc)
+				assert.Assert(t, err == nil, v)
+				if j == 0 {
+					first = encoded
+				} else {
+					assert.Assert(t, bytes.Equal(first, encoded), "%v should equal %v", v, group[0])
+					assert.Assert(t, Compare(v, group[0]) == 0, "%v should equal %v", v, group[0])
+				}
+			}
+
+			if i > 0 {
+				c := bytes.Compare(prev, first)
+				if desc {
+					c = -c
+				}
+				assert.Assert(t, c < 0, "%v should be less than %v", groups[i-1][0], group[0])
+				assert.Assert(t, Compare(groups[i-1][0], group[0]) < 0, "%v should be less than %v", groups[i-1][0], group[0])
+			}
+			prev = first
+		}
+	}
+}
+
+func TestIndexValueCompound(t *testing.T) {
+	// prefix free encoding keeps order for concatenated values
+	encode := func(a, b interface{}) []byte {
+		buf, err := AppendIndexValue(nil, a, false)
+		assert.Assert(t, err == nil)
+		buf, err = AppendIndexValue(buf, b, true)
+		assert.Assert(t, err == nil)
+		return buf
+	}
+
+	assert.Assert(t, bytes.Compare(encode("a", int32(2)), encode("a", int32(1))) < 0)
+	assert.Assert(t, bytes.Compare(encode("a", int32(1)), encode("a\x00", int32(2))) < 0)
+	assert.Assert(t, bytes.Compare(encode("abcdefgh", nil), encode("abcdefgh\x00", nil)) < 0)
+}
+
+func TestIndexValueUnsupported(t *testing.T) {
+	buf := []byte("prefix")
+	result, err := AppendIndexValue(buf, bson.M{"a": 1}, false)
+	assert.Assert(t, err == ErrUnsupportedIndexValue && bytes.Equal(result, buf))
+	_, err = AppendIndexValue(nil, bson.A{1}, false)
+	assert.Assert(t, err == ErrUnsupportedIndexValue)
+}
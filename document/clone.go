@@ -0,0 +1,229 @@
+package document
+
+import (
+	"errors"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv/compact"
+	"github.com/zhiqiangxu/mondis/kv/memcomparable"
+	tutil "github.com/zhiqiangxu/mondis/util"
+)
+
+// cloneBatchSize is the max number of entries written to dst in one txn by DB.Clone
+const cloneBatchSize = 256
+
+// ErrCloneToSelf when DB.Clone is called with dst on the same kvdb
+var ErrCloneToSelf = errors.New("cannot clone to the same kvdb")
+
+// Clone copies all collections of db to dst for a warm standby, dst is expected to have none of them.
+// Documents keep their ids, and the document sequence of each collection on dst is raised to the one on db,
+// or to the max cloned id if it's greater, so that inserts on dst never take a cloned id.
+// Index definitions, unique index entries, counter names and creation time index entries are cloned as well.
+// db is read in a single txn, so the clone is consistent as of when Clone starts,
+// while dst is written in batches of cloneBatchSize entries, each in its own txn.
+// Collections are opened on dst without CollectionOption, set their options by the setters of Collection.
+func (db *DB) Clone(dst *DB) (err error) {
+	if dst.kvdb == db.kvdb {
+		err = ErrCloneToSelf
+		return
+	}
+
+	// prologue start
+	err = db.checkState()
+	if err != nil {
+		return
+	}
+	err = db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer db.closer.Done()
+	err = dst.checkState()
+	if err != nil {
+		return
+	}
+	err = dst.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer dst.closer.Done()
+	// prologue end
+
+	txn := db.kvdb.NewTransaction(false)
+	defer txn.Discard()
+
+	names, err := collectionNames(txn)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		err = db.cloneCollection(dst, name, txn)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// collectionNames returns names of all collections as of txn
+func collectionNames(txn mondis.ProviderTxn) (names []string, err error) {
+	prefix := []byte(metaCName2IDPrefix)
+	var fnErr error
+	scanErr := txn.Scan(mondis.ProviderScanOption{Prefix: prefix, KeysOnly: true}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		var name []byte
+		_, name, fnErr = memcomparable.DecodeBytes(key[len(prefix):], nil)
+		if fnErr != nil {
+			return false
+		}
+		names = append(names, string(name))
+		return true
+	})
+	if fnErr != nil {
+		err = fnErr
+		return
+	}
+	err = scanErr
+	return
+}
+
+func (db *DB) cloneCollection(dst *DB, name string, txn mondis.ProviderTxn) (err error) {
+	src, err := db.collection(name, model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+	c, err := dst.collection(name, src.kind)
+	if err != nil {
+		return
+	}
+	err = c.checkKind(src.kind)
+	if err != nil {
+		return
+	}
+
+	lastKey, err := clonePrefix(dst, AppendCollectionDocumentPrefix(nil, src.cid), AppendCollectionDocumentPrefix(nil, c.cid), txn)
+	if err != nil {
+		return
+	}
+	seq, err := src.documentSequence.Cur()
+	if err != nil {
+		return
+	}
+	if lastKey != nil {
+		var maxDid int64
+		_, maxDid, err = DecodeCollectionDocumentKey(lastKey)
+		if err != nil {
+			return
+		}
+		if uint64(maxDid) > seq {
+			seq = uint64(maxDid)
+		}
+	}
+	err = c.documentSequence.SetIfGreater(seq)
+	if err != nil {
+		return
+	}
+
+	_, err = clonePrefix(dst, AppendCollectionCounterNamePrefix(nil, src.cid), AppendCollectionCounterNamePrefix(nil, c.cid), txn)
+	if err != nil {
+		return
+	}
+	_, err = clonePrefix(dst, AppendCollectionCreationTimePrefix(nil, src.cid), AppendCollectionCreationTimePrefix(nil, c.cid), txn)
+	if err != nil {
+		return
+	}
+
+	indexes, err := src.getIndexes(txn)
+	if err != nil {
+		return
+	}
+	for _, idef := range indexes {
+		var srcIID, dstIID int64
+		srcIID, err = indexID(src.cid, idef.Name, txn)
+		if err != nil {
+			return
+		}
+		dstIID, err = c.cloneIndex(idef)
+		if err != nil {
+			return
+		}
+
+		srcPrefix := memcomparable.EncodeInt64(AppendCollectionIndexDataPrefix(nil, src.cid), srcIID)
+		dstPrefix := memcomparable.EncodeInt64(AppendCollectionIndexDataPrefix(nil, c.cid), dstIID)
+		_, err = clonePrefix(dst, srcPrefix, dstPrefix, txn)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// cloneIndex returns the id of index idef, which is created if c doesn't have an index of the same name
+func (c *Collection) cloneIndex(idef IndexDefinition) (iid int64, err error) {
+	c.mu.RLock()
+	_, ok := c.indexMap[idef.Name]
+	c.mu.RUnlock()
+	if !ok {
+		iid, err = c.CreateIndex(idef.Clone())
+		return
+	}
+
+	txn := c.kvdb.NewTransaction(false)
+	defer txn.Discard()
+	iid, err = indexID(c.cid, idef.Name, txn)
+	return
+}
+
+// indexID returns the id of index name of collection cid as of txn
+func indexID(cid int64, name string, txn mondis.ProviderTxn) (iid int64, err error) {
+	v, _, err := txn.Get(EncodeCollectionIndexName2IDKey(nil, cid, name))
+	if err != nil {
+		return
+	}
+	_, iid, err = compact.DecodeVarint(v)
+	return
+}
+
+// clonePrefix copies entries under srcPrefix as of txn to dst under dstPrefix, with the same key suffixes and values,
+// lastKey is the last source key copied, nil if there is none.
+func clonePrefix(dst *DB, srcPrefix, dstPrefix []byte, txn mondis.ProviderTxn) (lastKey []byte, err error) {
+	var keys, values [][]byte
+	flush := func() (err error) {
+		err = tutil.RunInNewUpdateTxnWithRetry(dst.kvdb, func(txn mondis.ProviderTxn) (err error) {
+			for i, key := range keys {
+				err = txn.Set(key, values[i], nil)
+				if err != nil {
+					return
+				}
+			}
+			return
+		}, upsertMaxRetries)
+		keys = keys[:0]
+		values = values[:0]
+		return
+	}
+
+	var fnErr error
+	scanErr := txn.Scan(mondis.ProviderScanOption{Prefix: srcPrefix}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		lastKey = append(lastKey[:0], key...)
+		keys = append(keys, append(append([]byte(nil), dstPrefix...), key[len(srcPrefix):]...))
+		values = append(values, append([]byte(nil), value...))
+		if len(keys) == cloneBatchSize {
+			fnErr = flush()
+			return fnErr == nil
+		}
+		return true
+	})
+	if fnErr != nil {
+		err = fnErr
+		return
+	}
+	if scanErr != nil {
+		err = scanErr
+		return
+	}
+	if len(keys) > 0 {
+		err = flush()
+	}
+	return
+}
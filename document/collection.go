@@ -1,17 +1,21 @@
 package document
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"sync"
+	"time"
 
 	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
 	"github.com/zhiqiangxu/mondis/kv"
 	"github.com/zhiqiangxu/mondis/kv/compact"
-	tutil "github.com/zhiqiangxu/mondis/util"
 	"github.com/zhiqiangxu/util"
 	"github.com/zhiqiangxu/util/logger"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
@@ -21,14 +25,20 @@ type Collection struct {
 	kvdb             mondis.KVDB
 	cid              int64
 	name             string
+	kind             model.CollectionKind
 	documentSequence *Sequence
 	mu               sync.RWMutex
 	indexMap         map[string]IndexDefinition
+	rateLimiter      *rateLimiter
+	counterNames     counterNameGroup
+	systemFields     int32
+	timestamps       int32
+	creationIndex    int32
 }
 
-func newCollection(db *DB, name string) (c *Collection, err error) {
+func newCollection(db *DB, name string, kind model.CollectionKind) (c *Collection, err error) {
 
-	cid, err := db.getCollectionID(name)
+	cid, kind, err := db.getCollectionID(name, kind)
 	if err != nil {
 		return
 	}
@@ -38,13 +48,16 @@ func newCollection(db *DB, name string) (c *Collection, err error) {
 	if err != nil {
 		return
 	}
+	documentSequence.metrics = db.metrics
 	c = &Collection{
 		db:               db,
 		kvdb:             kvdb,
 		cid:              cid,
 		name:             name,
+		kind:             kind,
 		documentSequence: documentSequence,
 		indexMap:         make(map[string]IndexDefinition),
+		rateLimiter:      newRateLimiter(RateLimit{}),
 	}
 	indexes, err := c.getIndexes(nil)
 	if err != nil {
@@ -56,9 +69,68 @@ func newCollection(db *DB, name string) (c *Collection, err error) {
 	return
 }
 
+func (c *Collection) applyOption(option CollectionOption) {
+	c.SetRateLimit(option.RateLimit)
+	c.SetIncludeSystemFields(option.IncludeSystemFields)
+	c.SetAutoTimestamps(option.AutoTimestamps, option.OverwriteTimestamps)
+	c.SetCreationTimeIndex(option.CreationTimeIndex)
+}
+
+// Kind returns the kind of collection
+func (c *Collection) Kind() model.CollectionKind {
+	return c.kind
+}
+
+func (c *Collection) checkKind(kind model.CollectionKind) (err error) {
+	if c.kind != kind {
+		err = ErrCollectionKindMismatch
+	}
+	return
+}
+
+// WriteOption for InsertOneWithOption and UpdateOneWithOption
+type WriteOption struct {
+	// TTL makes the document expire after it, together with its creation time index entry, 0 means never.
+	// Like mondis.VMetaReq.TTL, it's of second precision, and a later write of the document without TTL makes it permanent.
+	TTL time.Duration
+}
+
+// meta for the document key
+func (o WriteOption) meta() *mondis.VMetaReq {
+	if o.TTL <= 0 {
+		return nil
+	}
+	return &mondis.VMetaReq{TTL: o.TTL}
+}
+
 // InsertOne for insert a document into collection
 func (c *Collection) InsertOne(doc bson.M, txn mondis.ProviderTxn) (did int64, err error) {
+	did, err = c.InsertOneWithOption(doc, WriteOption{}, txn)
+	return
+}
+
+// InsertOneWithOption is like InsertOne but with option
+func (c *Collection) InsertOneWithOption(doc bson.M, option WriteOption, txn mondis.ProviderTxn) (did int64, err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpInsert, time.Now(), &err)
+	}
 
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+	err = c.checkUserFields(doc)
+	if err != nil {
+		return
+	}
+
+	doc = c.stamp(doc, nil, true)
+	creationIndex := c.creationTimeIndex()
+	var createdAt primitive.DateTime
+	if creationIndex {
+		createdAt = primitive.NewDateTimeFromTime(c.db.now())
+		doc = c.withCreatedAt(doc, createdAt)
+	}
 	data, err := bson.Marshal(doc)
 	if err != nil {
 		return
@@ -76,6 +148,11 @@ func (c *Collection) InsertOne(doc bson.M, txn mondis.ProviderTxn) (did int64, e
 	defer c.db.closer.Done()
 	// prologue end
 
+	err = c.waitRateLimit(len(data))
+	if err != nil {
+		return
+	}
+
 	udid, err := c.documentSequence.Next()
 	if err != nil {
 		return
@@ -84,16 +161,129 @@ func (c *Collection) InsertOne(doc bson.M, txn mondis.ProviderTxn) (did int64, e
 	did = int64(udid)
 	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
 
-	insertFunc := func(txn mondis.ProviderTxn) error {
-		return txn.Set(docKey, data, nil)
+	meta := option.meta()
+	insertFunc := func(txn mondis.ProviderTxn) (err error) {
+		err = txn.Set(docKey, data, meta)
+		if err != nil {
+			return
+		}
+		if creationIndex {
+			err = c.addCreationEntry(int64(createdAt), did, meta, txn)
+			if err != nil {
+				return
+			}
+		}
+		err = c.putUniqueEntries(did, nil, doc, meta, txn)
+		return
+	}
+
+	if txn == nil {
+		err = c.runInImplicitUpdateTxn(insertFunc)
+	} else {
+		err = insertFunc(txn)
+	}
+
+	return
+}
+
+// InsertMany for insert documents into collection, dids are in the same order as docs and allocated as a contiguous band.
+// Nothing is written if any doc is rejected or fails to marshal, and all docs are written in a single txn if txn is nil.
+func (c *Collection) InsertMany(docs []bson.M, txn mondis.ProviderTxn) (dids []int64, err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpInsert, time.Now(), &err)
+	}
+
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+	if len(docs) == 0 {
+		return
+	}
+
+	creationIndex := c.creationTimeIndex()
+	var createdAt primitive.DateTime
+	if creationIndex {
+		createdAt = primitive.NewDateTimeFromTime(c.db.now())
+	}
+
+	datas := make([][]byte, 0, len(docs))
+	size := 0
+	for _, doc := range docs {
+		err = c.checkUserFields(doc)
+		if err != nil {
+			return
+		}
+		doc = c.stamp(doc, nil, true)
+		if creationIndex {
+			doc = c.withCreatedAt(doc, createdAt)
+		}
+		var data []byte
+		data, err = bson.Marshal(doc)
+		if err != nil {
+			return
+		}
+		datas = append(datas, data)
+		size += len(data)
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	err = c.waitRateLimit(size)
+	if err != nil {
+		return
+	}
+
+	start, err := c.documentSequence.NextN(uint64(len(docs)))
+	if err != nil {
+		return
+	}
+
+	insertFunc := func(txn mondis.ProviderTxn) (err error) {
+		for i, data := range datas {
+			did := int64(start) + int64(i)
+			docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+			err = txn.Set(docKey, data, nil)
+			if err != nil {
+				return
+			}
+			if creationIndex {
+				err = c.addCreationEntry(int64(createdAt), did, nil, txn)
+				if err != nil {
+					return
+				}
+			}
+			err = c.putUniqueEntries(did, nil, docs[i], nil, txn)
+			if err != nil {
+				return
+			}
+		}
+		return
 	}
 
 	if txn == nil {
-		err = tutil.RunInNewUpdateTxn(c.kvdb, insertFunc)
+		err = c.runInImplicitUpdateTxn(insertFunc)
 	} else {
 		err = insertFunc(txn)
 	}
+	if err != nil {
+		return
+	}
 
+	dids = make([]int64, 0, len(docs))
+	for i := range docs {
+		dids = append(dids, int64(start)+int64(i))
+	}
 	return
 }
 
@@ -106,17 +296,72 @@ var (
 	ErrIndexFieldsEmpty = errors.New("index fields cannot be empty")
 	// ErrDocIDExists when document withe specified id exists
 	ErrDocIDExists = errors.New("document withe specified id exists")
+	// ErrVersionUnavailable when the requested version has been garbage collected
+	ErrVersionUnavailable = kv.ErrVersionUnavailable
+	// ErrInvalidPage when page or page size is not positive
+	ErrInvalidPage = errors.New("page and page size must be positive")
 )
 
-// InsertOneManaged for insert a new document with specified document id
+// ReserveDids reserves n document ids in one lease txn for InsertOneManaged,
+// they never collide with ids allocated by InsertOne or other reservations, even from other processes.
+// Ids reserved but not inserted are simply gaps.
+func (c *Collection) ReserveDids(n int) (dids []int64, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	if n <= 0 {
+		err = ErrZeroBandwidth
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	start, end, err := c.documentSequence.AllocateBand(uint64(n))
+	if err != nil {
+		return
+	}
+
+	dids = make([]int64, 0, n)
+	for did := start; did < end; did++ {
+		dids = append(dids, int64(did))
+	}
+	return
+}
+
+// InsertOneManaged for insert a new document with specified document id,
+// ErrDocIDExists is returned if it exists, ids from ReserveDids never do.
 func (c *Collection) InsertOneManaged(did int64, doc bson.M, txn mondis.ProviderTxn) (err error) {
-	_, _, err = c.updateOne(did, doc, updateForInsert, txn)
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpInsert, time.Now(), &err)
+	}
+	_, _, _, err = c.updateOne(did, doc, updateForInsert, WriteOption{}, txn)
 	return
 }
 
 // UpdateOne for update an existing document in collection
 func (c *Collection) UpdateOne(did int64, doc bson.M, txn mondis.ProviderTxn) (exists bool, err error) {
-	exists, _, err = c.updateOne(did, doc, updateForUpdate, txn)
+	exists, err = c.UpdateOneWithOption(did, doc, WriteOption{}, txn)
+	return
+}
+
+// UpdateOneWithOption is like UpdateOne but with option, the TTL restarts from now
+func (c *Collection) UpdateOneWithOption(did int64, doc bson.M, option WriteOption, txn mondis.ProviderTxn) (exists bool, err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpUpdate, time.Now(), &err)
+	}
+	exists, _, _, err = c.updateOne(did, doc, updateForUpdate, option, txn)
 	return
 }
 
@@ -126,7 +371,17 @@ const (
 	updateForInsert
 )
 
-func (c *Collection) updateOne(did int64, doc bson.M, updateFor int8, txn mondis.ProviderTxn) (existsForUpdate, isNewForUpsert bool, err error) {
+// updateOne writes doc as did according to updateFor, stored is what's written if any
+func (c *Collection) updateOne(did int64, doc bson.M, updateFor int8, option WriteOption, txn mondis.ProviderTxn) (existsForUpdate, isNewForUpsert bool, stored []byte, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+	err = c.checkUserFields(doc)
+	if err != nil {
+		return
+	}
+
 	data, err := bson.Marshal(doc)
 	if err != nil {
 		return
@@ -144,84 +399,542 @@ func (c *Collection) updateOne(did int64, doc bson.M, updateFor int8, txn mondis
 	defer c.db.closer.Done()
 	// prologue end
 
+	err = c.waitRateLimit(len(data))
+	if err != nil {
+		return
+	}
+
 	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
 
-	updateFunc := func(txn mondis.ProviderTxn) (err error) {
-		existsForUpdate, err = txn.Exists(docKey)
-		if err != nil {
+	autoTimestamps := c.autoTimestamps()
+	creationIndex := c.creationTimeIndex()
+	uniqueFields := c.hasUniqueFields()
+	meta := option.meta()
+	updateFunc := func(txn mondis.ProviderTxn) (err error) {
+		var old bson.M
+		if autoTimestamps || creationIndex || uniqueFields {
+			// the stored CreatedAtField, SystemFieldCreatedAt or values of unique fields are needed
+			old, existsForUpdate, err = c.getStored(docKey, txn)
+		} else {
+			existsForUpdate, err = txn.Exists(docKey)
+		}
+		if err != nil {
+			return
+		}
+
+		switch updateFor {
+		case updateForUpdate:
+			if !existsForUpdate {
+				return
+			}
+		case updateForUpsert:
+			isNewForUpsert = !existsForUpdate
+		case updateForInsert:
+			if existsForUpdate {
+				err = ErrDocIDExists
+				return
+			}
+		}
+
+		stored = data
+		if autoTimestamps || creationIndex {
+			written := c.stamp(doc, old, !existsForUpdate)
+			if creationIndex {
+				written, err = c.carryCreatedAt(written, old, !existsForUpdate, did, meta, txn)
+				if err != nil {
+					return
+				}
+			}
+			stored, err = bson.Marshal(written)
+			if err != nil {
+				return
+			}
+		}
+
+		err = txn.Set(docKey, stored, meta)
+		if err != nil || !uniqueFields {
+			return
+		}
+		err = c.putUniqueEntries(did, old, doc, meta, txn)
+		return
+	}
+
+	if txn == nil {
+		err = c.runInImplicitUpdateTxn(updateFunc)
+	} else {
+		err = updateFunc(txn)
+	}
+
+	return
+}
+
+// UpsertOne for upsert an existing document in collection
+func (c *Collection) UpsertOne(did int64, doc bson.M, txn mondis.ProviderTxn) (isNew bool, err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpUpdate, time.Now(), &err)
+	}
+	_, isNew, _, err = c.updateOne(did, doc, updateForUpsert, WriteOption{}, txn)
+	return
+}
+
+// Merge sets the top level fields of patch into an existing document, other fields are kept,
+// ErrDocNotFound is returned if the document doesn't exist.
+//
+// Badger merge operators work outside transactions, so the merge is always done by read-merge-write within txn,
+// which only saves the caller a round trip of the document.
+func (c *Collection) Merge(did int64, patch bson.M, txn mondis.ProviderTxn) (err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpUpdate, time.Now(), &err)
+	}
+	_, err = c.merge(did, patch, false, txn)
+	return
+}
+
+// MergeUpsert is like Merge but inserts patch as a new document if it doesn't exist
+func (c *Collection) MergeUpsert(did int64, patch bson.M, txn mondis.ProviderTxn) (isNew bool, err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpUpdate, time.Now(), &err)
+	}
+	isNew, err = c.merge(did, patch, true, txn)
+	return
+}
+
+func (c *Collection) merge(did int64, patch bson.M, upsert bool, txn mondis.ProviderTxn) (isNew bool, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	err = c.checkUserFields(patch)
+	if err != nil {
+		return
+	}
+
+	// the merged size is unknown until read, patch size is used
+	patchData, err := bson.Marshal(patch)
+	if err != nil {
+		return
+	}
+	err = c.waitRateLimit(len(patchData))
+	if err != nil {
+		return
+	}
+
+	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+
+	uniqueFields := c.hasUniqueFields()
+	mergeFunc := func(txn mondis.ProviderTxn) (err error) {
+		doc := bson.M{}
+		v, _, err := txn.Get(docKey)
+		switch err {
+		case nil:
+			err = bson.Unmarshal(v, &doc)
+			if err != nil {
+				return
+			}
+		case kv.ErrKeyNotFound:
+			if !upsert {
+				err = ErrDocNotFound
+				return
+			}
+			err = nil
+			isNew = true
+		default:
+			return
+		}
+
+		var old bson.M
+		if uniqueFields {
+			// for the values of unique fields before the patch
+			old = make(bson.M, len(doc))
+			for field, value := range doc {
+				old[field] = value
+			}
+		}
+		for field, value := range c.stamp(patch, doc, isNew) {
+			doc[field] = value
+		}
+		if isNew && c.creationTimeIndex() {
+			doc, err = c.carryCreatedAt(doc, nil, true, did, nil, txn)
+			if err != nil {
+				return
+			}
+		}
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return
+		}
+
+		err = txn.Set(docKey, data, nil)
+		if err != nil || !uniqueFields {
+			return
+		}
+		err = c.putUniqueEntries(did, old, doc, nil, txn)
+		return
+	}
+
+	if txn == nil {
+		err = c.runInImplicitUpdateTxn(mergeFunc)
+	} else {
+		err = mergeFunc(txn)
+	}
+
+	return
+}
+
+// DeleteOne for delete a document from collection
+func (c *Collection) DeleteOne(did int64, txn mondis.ProviderTxn) (err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpDelete, time.Now(), &err)
+	}
+
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	err = c.waitRateLimit(0)
+	if err != nil {
+		return
+	}
+
+	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+
+	creationIndex := c.creationTimeIndex()
+	uniqueFields := c.hasUniqueFields()
+	deleteFunc := func(txn mondis.ProviderTxn) (err error) {
+		if creationIndex {
+			err = c.deleteCreationEntry(docKey, did, txn)
+			if err != nil {
+				return
+			}
+		}
+		if uniqueFields {
+			var old bson.M
+			old, _, err = c.getStored(docKey, txn)
+			if err != nil {
+				return
+			}
+			err = c.putUniqueEntries(did, old, nil, nil, txn)
+			if err != nil {
+				return
+			}
+		}
+		err = txn.Delete(docKey)
+		return
+	}
+
+	if txn == nil {
+		err = c.runInImplicitUpdateTxn(deleteFunc)
+	} else {
+		err = deleteFunc(txn)
+	}
+
+	return
+}
+
+// GetOne for get a document by document id
+func (c *Collection) GetOne(did int64, txn mondis.ProviderTxn) (data bson.M, err error) {
+	data, _, err = c.GetOneWithVersion(did, txn)
+	return
+}
+
+// GetOneWithVersion is like GetOne but also returns the commit version of the document,
+// which can be passed to GetOneAsOf later
+func (c *Collection) GetOneWithVersion(did int64, txn mondis.ProviderTxn) (data bson.M, commitVersion uint64, err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpGet, time.Now(), &err)
+	}
+
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+	if txn == nil {
+		var done func()
+		txn, done, err = c.newImplicitTxn()
+		if err != nil {
+			return
+		}
+		defer done()
+	}
+	v, meta, err := txn.Get(docKey)
+	if err == kv.ErrKeyNotFound {
+		err = ErrDocNotFound
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	err = bson.Unmarshal(v, &data)
+	if err != nil {
+		return
+	}
+	c.stripSystemFields(data)
+	commitVersion = meta.Version
+	return
+}
+
+// GetOneAsOf for get a document as of the specified commit version,
+// ErrVersionUnavailable is returned if the version has been garbage collected,
+// see mondis.KVOption.NumVersionsToKeep for how many versions are retained.
+func (c *Collection) GetOneAsOf(did int64, commitVersion uint64) (data bson.M, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+	v, _, err := c.kvdb.GetAsOf(docKey, commitVersion)
+	if err == kv.ErrKeyNotFound {
+		err = ErrDocNotFound
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	err = bson.Unmarshal(v, &data)
+	if err != nil {
+		return
+	}
+	c.stripSystemFields(data)
+	return
+}
+
+// CountOption for Count
+type CountOption struct {
+	// Filter is matched like Find, empty means all documents
+	Filter bson.M
+	// Exact forces a scan when Filter is empty
+	Exact bool
+}
+
+// CountDocuments returns the number of documents by a keys only scan of the collection, no document is decoded.
+// It's Count with CountOption.Exact and no filter, but only for model.CollectionKindDocument.
+func (c *Collection) CountDocuments(txn mondis.ProviderTxn) (n int64, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	n, _, err = c.Count(CountOption{Exact: true}, txn)
+	return
+}
+
+// Count for number of documents matching option.Filter.
+// When option.Filter is empty and option.Exact is false, the key estimate of kvdb is returned with exact set to false,
+// otherwise documents are scanned and exact is true, values are not fetched when option.Filter is empty.
+// Documents are also scanned when the estimate is unavailable or 0, e.g., they're all in memtables not flushed yet.
+func (c *Collection) Count(option CountOption, txn mondis.ProviderTxn) (n int64, exact bool, err error) {
+	if len(option.Filter) > 0 {
+		err = c.checkKind(model.CollectionKindDocument)
+		if err != nil {
+			return
+		}
+		err = checkFilter(option.Filter)
+		if err != nil {
+			return
+		}
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	collectionDocumentPrefix := AppendCollectionDocumentPrefix(nil, c.cid)
+
+	if len(option.Filter) == 0 && !option.Exact {
+		n, err = c.kvdb.EstimateKeys(collectionDocumentPrefix)
+		if err != kv.ErrEstimateUnavailable && (err != nil || n > 0) {
 			return
 		}
+		// fallback to scan
+		n, err = 0, nil
+	}
 
-		switch updateFor {
-		case updateForUpdate:
-			if !existsForUpdate {
-				return
-			}
-		case updateForUpsert:
-			isNewForUpsert = !existsForUpdate
-		case updateForInsert:
-			if existsForUpdate {
-				err = ErrDocIDExists
-				return
-			}
-		}
-
-		err = txn.Set(docKey, data, nil)
+	if txn == nil {
+		var done func()
+		txn, done, err = c.newImplicitTxn()
 		if err != nil {
 			return
 		}
+		defer done()
+	}
 
+	exact = true
+	if len(option.Filter) == 0 {
+		err = txn.Scan(mondis.ProviderScanOption{Prefix: collectionDocumentPrefix, KeysOnly: true}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+			n++
+			return true
+		})
 		return
 	}
 
-	if txn == nil {
-		err = tutil.RunInNewUpdateTxn(c.kvdb, updateFunc)
-	} else {
-		err = updateFunc(txn)
+	var fnErr error
+	err = txn.Scan(mondis.ProviderScanOption{Prefix: collectionDocumentPrefix}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		var doc bson.M
+		fnErr = bson.Unmarshal(value, &doc)
+		if fnErr != nil {
+			return false
+		}
+		if matchFilter(doc, option.Filter) {
+			n++
+		}
+		return true
+	})
+	if fnErr != nil {
+		err = fnErr
 	}
-
 	return
 }
 
-// UpsertOne for upsert an existing document in collection
-func (c *Collection) UpsertOne(did int64, doc bson.M, txn mondis.ProviderTxn) (isNew bool, err error) {
-	_, isNew, err = c.updateOne(did, doc, updateForUpsert, txn)
+// ForEach calls fn for each document in did order until fn returns false.
+// When txn is nil, a read only txn is used, so writes made by fn in other txns are not visible.
+func (c *Collection) ForEach(fn func(did int64, doc bson.M) bool, txn mondis.ProviderTxn) (err error) {
+	err = c.iterate(SlowKindForEach, fn, txn)
 	return
 }
 
-// DeleteOne for delete a document from collection
-func (c *Collection) DeleteOne(did int64, txn mondis.ProviderTxn) (err error) {
+// Iterate is ForEach, for callers expecting a cursor style name
+func (c *Collection) Iterate(fn func(did int64, doc bson.M) bool, txn mondis.ProviderTxn) (err error) {
+	err = c.iterate(SlowKindIterate, fn, txn)
+	return
+}
 
-	// prologue start
-	err = c.db.checkState()
-	if err != nil {
+func (c *Collection) iterate(kind string, fn func(did int64, doc bson.M) bool, txn mondis.ProviderTxn) (err error) {
+	if c.db.slowLog == nil {
+		err = c.forEach(context.Background(), fn, true, txn)
 		return
 	}
-	err = c.db.closer.Add(1)
+
+	start := time.Now()
+	var n int64
+	err = c.forEach(context.Background(), func(did int64, doc bson.M) bool {
+		n++
+		return fn(did, doc)
+	}, true, txn)
+	c.observeSlow(kind, start, nil, n, 0)
+	return
+}
+
+// Find returns ids and documents matching filter in did order, filter supports equality, $in, $gt and $lt of top level fields,
+// see matchFilter for details. It's a full scan of the collection for now, since secondary indexes are not used.
+func (c *Collection) Find(filter bson.M, txn mondis.ProviderTxn) (dids []int64, docs []bson.M, err error) {
+	err = checkFilter(filter)
 	if err != nil {
 		return
 	}
-	defer c.db.closer.Done()
-	// prologue end
 
-	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+	start := time.Now()
+	var skipped int64
+	err = c.forEach(context.Background(), func(did int64, doc bson.M) bool {
+		if matchFilter(doc, filter) {
+			dids = append(dids, did)
+			docs = append(docs, doc)
+		} else {
+			skipped++
+		}
+		return true
+	}, false, txn)
+	if err != nil {
+		dids = nil
+		docs = nil
+	}
+	c.observeSlow(SlowKindFind, start, filter, int64(len(docs)), skipped)
+	return
+}
 
-	deleteFunc := func(txn mondis.ProviderTxn) (err error) {
-		err = txn.Delete(docKey)
+// FindCtx returns all documents matching filter like Find,
+// ctx is checked for each document scanned and ctx.Err() is returned once it's done.
+func (c *Collection) FindCtx(ctx context.Context, filter bson.M) (docs []bson.M, err error) {
+	err = checkFilter(filter)
+	if err != nil {
 		return
 	}
 
-	if txn == nil {
-		err = tutil.RunInNewUpdateTxn(c.kvdb, deleteFunc)
-	} else {
-		err = deleteFunc(txn)
+	start := time.Now()
+	var skipped int64
+	err = c.forEach(ctx, func(did int64, doc bson.M) bool {
+		if matchFilter(doc, filter) {
+			docs = append(docs, doc)
+		} else {
+			skipped++
+		}
+		return true
+	}, false, nil)
+	if err != nil {
+		docs = nil
 	}
-
+	c.observeSlow(SlowKindFind, start, filter, int64(len(docs)), skipped)
 	return
 }
 
-// GetOne for get a document by document id
-func (c *Collection) GetOne(did int64, txn mondis.ProviderTxn) (data bson.M, err error) {
+func (c *Collection) forEach(ctx context.Context, fn func(did int64, doc bson.M) bool, userFn bool, txn mondis.ProviderTxn) (err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
 	// prologue start
 	err = c.db.checkState()
 	if err != nil {
@@ -234,48 +947,128 @@ func (c *Collection) GetOne(did int64, txn mondis.ProviderTxn) (data bson.M, err
 	defer c.db.closer.Done()
 	// prologue end
 
-	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
-	if txn == nil {
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	// the limiter is not held across user code, which may call back into the collection
+	if txn == nil && userFn {
 		txn = c.kvdb.NewTransaction(false)
 		defer txn.Discard()
 	}
-	v, _, err := txn.Get(docKey)
-	if err == kv.ErrKeyNotFound {
-		err = ErrDocNotFound
-		return
+	if txn == nil {
+		var done func()
+		txn, done, err = c.newImplicitTxn()
+		if err != nil {
+			return
+		}
+		defer done()
 	}
-	if err != nil {
+
+	var (
+		did     int64
+		fnErr   error
+		scanErr error
+	)
+	collectionDocumentPrefix := AppendCollectionDocumentPrefix(nil, c.cid)
+	scanErr = txn.Scan(mondis.ProviderScanOption{Prefix: collectionDocumentPrefix}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		fnErr = ctx.Err()
+		if fnErr != nil {
+			return false
+		}
+		_, did, fnErr = DecodeCollectionDocumentKey(key)
+		if fnErr != nil {
+			return false
+		}
+		var doc bson.M
+		fnErr = bson.Unmarshal(value, &doc)
+		if fnErr != nil {
+			return false
+		}
+		c.stripSystemFields(doc)
+		return fn(did, doc)
+	})
+	if fnErr != nil {
+		err = fnErr
 		return
 	}
-
-	err = bson.Unmarshal(v, &data)
+	err = scanErr
 	return
 }
 
-// Count for total number of documents
-func (c *Collection) Count(txn mondis.ProviderTxn) (n int, err error) {
-	// prologue start
-	err = c.db.checkState()
+type (
+	// FindPageResult for FindPage
+	FindPageResult struct {
+		Dids       []int64
+		Docs       []bson.M
+		Total      int64
+		TotalPages int64
+		HasNext    bool
+	}
+)
+
+// FindPage returns the page-th(starting from 1) page of documents matching filter,
+// ordered by sort(field => 1 for asc, -1 for desc, fields are applied in name order, ties broken by did),
+// or by did when sort is empty.
+// Cost: it scans and unmarshals the whole collection to compute Total,
+// and keeps all matching documents in memory when sort is not empty.
+func (c *Collection) FindPage(filter bson.M, page, pageSize int, sort map[string]int) (result FindPageResult, err error) {
+	if page < 1 || pageSize < 1 {
+		err = ErrInvalidPage
+		return
+	}
+	err = checkFilter(filter)
 	if err != nil {
 		return
 	}
-	err = c.db.closer.Add(1)
+
+	start := int64(page-1) * int64(pageSize)
+	end := start + int64(pageSize)
+
+	var (
+		allDids []int64
+		allDocs []bson.M
+		scanned int64
+	)
+	startTime := time.Now()
+	defer func() {
+		c.observeSlow(SlowKindFindPage, startTime, filter, int64(len(result.Docs)), scanned-int64(len(result.Docs)))
+	}()
+	err = c.forEach(context.Background(), func(did int64, doc bson.M) bool {
+		scanned++
+		if !matchFilter(doc, filter) {
+			return true
+		}
+		if len(sort) > 0 || (result.Total >= start && result.Total < end) {
+			allDids = append(allDids, did)
+			allDocs = append(allDocs, doc)
+		}
+		result.Total++
+		return true
+	}, true, nil)
 	if err != nil {
 		return
 	}
-	defer c.db.closer.Done()
-	// prologue end
 
-	if txn == nil {
-		txn = c.kvdb.NewTransaction(false)
-		defer txn.Discard()
+	result.TotalPages = (result.Total + int64(pageSize) - 1) / int64(pageSize)
+	result.HasNext = int64(page) < result.TotalPages
+
+	if len(sort) == 0 {
+		result.Dids = allDids
+		result.Docs = allDocs
+		return
 	}
 
-	collectionDocumentPrefix := AppendCollectionDocumentPrefix(nil, c.cid)
-	err = txn.Scan(mondis.ProviderScanOption{Prefix: collectionDocumentPrefix}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
-		n++
-		return true
-	})
+	sortDocs(allDids, allDocs, sort)
+	if start >= int64(len(allDocs)) {
+		return
+	}
+	if end > int64(len(allDocs)) {
+		end = int64(len(allDocs))
+	}
+	result.Dids = allDids[start:end]
+	result.Docs = allDocs[start:end]
 	return
 }
 
@@ -298,10 +1091,9 @@ func (c *Collection) DeleteAll(txn mondis.ProviderTxn) (n int, err error) {
 		return
 	}
 
-	err = tutil.RunInNewTxn(c.kvdb, func(txn mondis.ProviderTxn) error {
-		newN, err := c.deleteAllWithTxn(txn)
-		n += newN
-		return err
+	err = c.runInImplicitUpdateTxn(func(txn mondis.ProviderTxn) (err error) {
+		n, err = c.deleteAllWithTxn(txn)
+		return
 	})
 
 	return
@@ -322,12 +1114,57 @@ func (c *Collection) deleteAllWithTxn(txn mondis.ProviderTxn) (n int, err error)
 		return
 	}
 	err = scanErr
+	if err != nil {
+		return
+	}
+
+	// entries of CollectionOption.CreationTimeIndex
+	creationTimePrefix := AppendCollectionCreationTimePrefix(nil, c.cid)
+	scanErr = txn.Scan(mondis.ProviderScanOption{Prefix: creationTimePrefix, KeysOnly: true}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		err = txn.Delete(append([]byte(nil), key...))
+		return err == nil
+	})
+	if err != nil {
+		return
+	}
+	err = scanErr
+	if err != nil {
+		return
+	}
+
+	// entries of unique indexes
+	_, err = deletePrefix(AppendCollectionIndexDataPrefix(nil, c.cid), 0, txn)
 
 	return
 }
 
-// GetMany for get many documents by document id list
+// GetMany for get many documents by document id list, ErrDocNotFound is returned if any of them is missing
 func (c *Collection) GetMany(dids []int64, txn mondis.ProviderTxn) (datas []bson.M, err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpGet, time.Now(), &err)
+	}
+
+	datas, _, err = c.getMany(dids, false, txn)
+	return
+}
+
+// GetManyPartial is like GetMany but missing documents are nil in datas instead of failing the whole batch,
+// datas is aligned with dids and found is the dids that exist, in the same order.
+func (c *Collection) GetManyPartial(dids []int64, txn mondis.ProviderTxn) (datas []bson.M, found []int64, err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpGet, time.Now(), &err)
+	}
+
+	datas, found, err = c.getMany(dids, true, txn)
+	return
+}
+
+func (c *Collection) getMany(dids []int64, partial bool, txn mondis.ProviderTxn) (datas []bson.M, found []int64, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
 	// prologue start
 	err = c.db.checkState()
 	if err != nil {
@@ -341,8 +1178,12 @@ func (c *Collection) GetMany(dids []int64, txn mondis.ProviderTxn) (datas []bson
 	// prologue end
 
 	if txn == nil {
-		txn = c.kvdb.NewTransaction(false)
-		defer txn.Discard()
+		var done func()
+		txn, done, err = c.newImplicitTxn()
+		if err != nil {
+			return
+		}
+		defer done()
 	}
 
 	var v []byte
@@ -350,6 +1191,11 @@ func (c *Collection) GetMany(dids []int64, txn mondis.ProviderTxn) (datas []bson
 		docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
 		v, _, err = txn.Get(docKey)
 		if err == kv.ErrKeyNotFound {
+			if partial {
+				err = nil
+				datas = append(datas, nil)
+				continue
+			}
 			err = ErrDocNotFound
 			return
 		}
@@ -361,8 +1207,86 @@ func (c *Collection) GetMany(dids []int64, txn mondis.ProviderTxn) (datas []bson
 		if err != nil {
 			return
 		}
+		c.stripSystemFields(data)
 
 		datas = append(datas, data)
+		found = append(found, did)
+	}
+	return
+}
+
+// GetRange for get documents with startID <= did <= endID by a single ordered scan,
+// missing dids are skipped, which is cheaper than GetMany for dense ranges.
+func (c *Collection) GetRange(startID, endID int64, txn mondis.ProviderTxn) (datas map[int64]bson.M, err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpGet, time.Now(), &err)
+	}
+
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	datas = make(map[int64]bson.M)
+	if startID > endID {
+		return
+	}
+
+	if txn == nil {
+		var done func()
+		txn, done, err = c.newImplicitTxn()
+		if err != nil {
+			return
+		}
+		defer done()
+	}
+
+	option := mondis.ProviderScanOption{
+		Prefix: AppendCollectionDocumentPrefix(nil, c.cid),
+		Offset: EncodeCollectionDocumentKey(nil, c.cid, startID),
+	}
+	// Stop is exclusive, the prefix bounds the scan when endID+1 overflows
+	if endID < math.MaxInt64 {
+		option.Stop = EncodeCollectionDocumentKey(nil, c.cid, endID+1)
+	}
+	var (
+		did     int64
+		fnErr   error
+		scanErr error
+	)
+	scanErr = txn.Scan(option, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		_, did, fnErr = DecodeCollectionDocumentKey(key)
+		if fnErr != nil {
+			return false
+		}
+		var data bson.M
+		fnErr = bson.Unmarshal(value, &data)
+		if fnErr != nil {
+			return false
+		}
+		c.stripSystemFields(data)
+		datas[did] = data
+		return true
+	})
+	if fnErr != nil {
+		err = fnErr
+	} else {
+		err = scanErr
+	}
+	if err != nil {
+		datas = nil
 	}
 	return
 }
@@ -395,8 +1319,16 @@ func (idef *IndexDefinition) Clone() (clone IndexDefinition) {
 	return
 }
 
-// CreateIndex for collection
+// CreateIndex for collection. A unique index on a single field is enforced by writes of collection,
+// which return ErrUniqueViolated for a value taken by another document,
+// and is backfilled for existing documents, it's dropped again if they violate it.
+// A backfill interrupted by a crash is resumed by the recovery of NewDB once its intent expires.
 func (c *Collection) CreateIndex(idef IndexDefinition) (iid int64, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
 	if idef.Name == "" {
 		err = ErrIndexNameEmpty
 		return
@@ -456,6 +1388,18 @@ func (c *Collection) CreateIndex(idef IndexDefinition) (iid int64, err error) {
 		return
 	}
 
+	var (
+		backfillID int64
+		backfill   *uniqueBackfill
+	)
+	if isUniqueField(&idef) {
+		// recorded along with the index, so that the backfill is resumed if interrupted
+		backfillID, backfill, err = beginUniqueBackfill(c.cid, iid, idef.Name, idef.Fields[0].Name, txn)
+		if err != nil {
+			return
+		}
+	}
+
 	err = txn.Commit()
 	if err != nil {
 		return
@@ -464,6 +1408,16 @@ func (c *Collection) CreateIndex(idef IndexDefinition) (iid int64, err error) {
 	c.mu.Lock()
 	c.indexMap[idef.Name] = idef
 	c.mu.Unlock()
+
+	if backfill != nil {
+		// writes from now on maintain the entries, so only earlier documents are backfilled
+		err = runUniqueBackfill(c.kvdb, backfillID, backfill)
+		if err == ErrUniqueViolated {
+			c.mu.Lock()
+			delete(c.indexMap, idef.Name)
+			c.mu.Unlock()
+		}
+	}
 	return
 }
 
@@ -550,14 +1504,39 @@ func (c *Collection) DropIndex(iname string) (exists bool, err error) {
 	txn := c.kvdb.NewTransaction(true)
 	defer txn.Discard()
 
-	indexName2IDKey := EncodeCollectionIndexName2IDKey(nil, c.cid, iname)
+	idef, iid, exists, err := dropIndexMeta(c.cid, iname, txn)
+	if err != nil || !exists {
+		return
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.indexMap, idef.Name)
+	c.mu.Unlock()
+
+	if isUniqueField(&idef) {
+		err = dropUniqueEntries(c.kvdb, c.cid, iid)
+	}
+	return
+}
+
+// dropIndexMeta deletes the definition of index iname of collection cid in txn
+func dropIndexMeta(cid int64, iname string, txn mondis.ProviderTxn) (idef IndexDefinition, iid int64, exists bool, err error) {
+	indexName2IDKey := EncodeCollectionIndexName2IDKey(nil, cid, iname)
 	iidv, _, err := txn.Get(indexName2IDKey)
 	if err == kv.ErrKeyNotFound {
 		err = nil
 		return
 	}
+	if err != nil {
+		return
+	}
 
-	_, iid, err := compact.DecodeVarint(iidv)
+	_, iid, err = compact.DecodeVarint(iidv)
 	if err != nil {
 		return
 	}
@@ -568,14 +1547,13 @@ func (c *Collection) DropIndex(iname string) (exists bool, err error) {
 		return
 	}
 
-	var idef IndexDefinition
 	err = bson.Unmarshal(idefBytes, &idef)
 	if err != nil {
 		return
 	}
 
 	exists = true
-	ciKey := EncodeCollectionColumnsIndexedKey(nil, c.cid, idef.Fields)
+	ciKey := EncodeCollectionColumnsIndexedKey(nil, cid, idef.Fields)
 	err = txn.Delete(ciKey)
 	if err != nil {
 		return
@@ -585,18 +1563,6 @@ func (c *Collection) DropIndex(iname string) (exists bool, err error) {
 		return
 	}
 	err = txn.Delete(indexName2IDKey)
-	if err != nil {
-		return
-	}
-
-	err = txn.Commit()
-	if err != nil {
-		return
-	}
-
-	c.mu.Lock()
-	delete(c.indexMap, idef.Name)
-	c.mu.Unlock()
 	return
 }
 
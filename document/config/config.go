@@ -6,6 +6,12 @@ import "time"
 type Value struct {
 	WorkerMaxTickInterval time.Duration
 	Lease                 time.Duration
+	// HistoryJobRetention is the number of latest history DDL jobs kept when DDL prunes the history, 0 disables pruning.
+	// An API waiting for a job that is pruned before it checks the result never returns,
+	// so it should be well above the number of jobs finished within a lease.
+	HistoryJobRetention int
+	// HistoryJobPruneInterval is the interval DDL prunes the history at, it's read once by DDL.Start and 0 disables pruning
+	HistoryJobPruneInterval time.Duration
 }
 
 // Load config
@@ -3,6 +3,8 @@ package config
 import "time"
 
 var config = Value{
-	WorkerMaxTickInterval: time.Second,
-	Lease:                 0,
+	WorkerMaxTickInterval:   time.Second,
+	Lease:                   0,
+	HistoryJobRetention:     0,
+	HistoryJobPruneInterval: 10 * time.Minute,
 }
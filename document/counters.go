@@ -0,0 +1,341 @@
+package document
+
+import (
+	"sync"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/numeric"
+)
+
+// counterMaxRetries is the max retries on txn conflict when txn is not specified
+const counterMaxRetries = 20
+
+// counterValueSize is the size of an encoded counter
+const counterValueSize = 8
+
+// InsertCounter for insert a new counter with value n into counters collection
+func (c *Collection) InsertCounter(n int64, txn mondis.ProviderTxn) (did int64, err error) {
+	err = c.checkKind(model.CollectionKindCounters)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	err = c.waitRateLimit(counterValueSize)
+	if err != nil {
+		return
+	}
+
+	udid, err := c.documentSequence.Next()
+	if err != nil {
+		return
+	}
+
+	did = int64(udid)
+	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+
+	insertFunc := func(txn mondis.ProviderTxn) error {
+		return txn.Set(docKey, numeric.Encode2Binary(uint64(n), nil), nil)
+	}
+
+	if txn == nil {
+		err = c.runInImplicitUpdateTxn(insertFunc)
+	} else {
+		err = insertFunc(txn)
+	}
+
+	return
+}
+
+// IncBy adds delta to counter did and returns the new value, a missing counter starts from 0,
+// kv.ErrOverflow is returned instead of wrapping around.
+// When txn is nil, it's retried on conflict so concurrent increments are not lost.
+func (c *Collection) IncBy(did, delta int64, txn mondis.ProviderTxn) (n int64, err error) {
+	err = c.checkKind(model.CollectionKindCounters)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	err = c.waitRateLimit(counterValueSize)
+	if err != nil {
+		return
+	}
+
+	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+
+	incFunc := func(txn mondis.ProviderTxn) (err error) {
+		n, err = kv.IncBinaryInt64(txn, docKey, delta)
+		return
+	}
+
+	if txn == nil {
+		err = c.runInImplicitUpdateTxnWithRetry(incFunc, counterMaxRetries)
+	} else {
+		err = incFunc(txn)
+	}
+
+	return
+}
+
+// GetCounter for get the value of counter did, ErrDocNotFound is returned if it doesn't exist
+func (c *Collection) GetCounter(did int64, txn mondis.ProviderTxn) (n int64, err error) {
+	err = c.checkKind(model.CollectionKindCounters)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	if txn == nil {
+		var done func()
+		txn, done, err = c.newImplicitTxn()
+		if err != nil {
+			return
+		}
+		defer done()
+	}
+
+	v, _, err := txn.Get(EncodeCollectionDocumentKey(nil, c.cid, did))
+	if err == kv.ErrKeyNotFound {
+		err = ErrDocNotFound
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	un, err := numeric.DecodeFromBinary(v)
+	if err != nil {
+		return
+	}
+	n = int64(un)
+	return
+}
+
+// SetCounter for set the value of counter did, it's created if not exists
+func (c *Collection) SetCounter(did, n int64, txn mondis.ProviderTxn) (err error) {
+	err = c.checkKind(model.CollectionKindCounters)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	err = c.waitRateLimit(counterValueSize)
+	if err != nil {
+		return
+	}
+
+	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+
+	setFunc := func(txn mondis.ProviderTxn) error {
+		return txn.Set(docKey, numeric.Encode2Binary(uint64(n), nil), nil)
+	}
+
+	if txn == nil {
+		err = c.runInImplicitUpdateTxn(setFunc)
+	} else {
+		err = setFunc(txn)
+	}
+
+	return
+}
+
+// CounterID resolves counter name to document id, a new document id is allocated if name doesn't exist.
+// The mapping is committed in its own txn, concurrent resolutions of the same name in this process
+// are coalesced, and resolutions from other processes are serialized by txn conflict.
+func (c *Collection) CounterID(name string) (did int64, err error) {
+	err = c.checkKind(model.CollectionKindCounters)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	did, err = c.lookupCounterID(name, nil)
+	if err != ErrDocNotFound {
+		return
+	}
+
+	did, err = c.counterNames.do(name, func() (did int64, err error) {
+		nameKey := EncodeCollectionCounterName2IDKey(nil, c.cid, name)
+		err = c.runInImplicitUpdateTxnWithRetry(func(txn mondis.ProviderTxn) (err error) {
+			did, err = c.lookupCounterID(name, txn)
+			if err != ErrDocNotFound {
+				return
+			}
+
+			// a did allocated by a conflicted txn is simply wasted
+			udid, err := c.documentSequence.Next()
+			if err != nil {
+				return
+			}
+			did = int64(udid)
+			err = txn.Set(nameKey, numeric.Encode2Binary(udid, nil), nil)
+			return
+		}, counterMaxRetries)
+		return
+	})
+	return
+}
+
+// lookupCounterID returns ErrDocNotFound if name doesn't exist
+func (c *Collection) lookupCounterID(name string, txn mondis.ProviderTxn) (did int64, err error) {
+	if txn == nil {
+		var done func()
+		txn, done, err = c.newImplicitTxn()
+		if err != nil {
+			return
+		}
+		defer done()
+	}
+
+	v, _, err := txn.Get(EncodeCollectionCounterName2IDKey(nil, c.cid, name))
+	if err == kv.ErrKeyNotFound {
+		err = ErrDocNotFound
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	udid, err := numeric.DecodeFromBinary(v)
+	if err != nil {
+		return
+	}
+	did = int64(udid)
+	return
+}
+
+// IncByName is like IncBy but the counter is specified by name
+func (c *Collection) IncByName(name string, delta int64, txn mondis.ProviderTxn) (n int64, err error) {
+	did, err := c.CounterID(name)
+	if err != nil {
+		return
+	}
+
+	n, err = c.IncBy(did, delta, txn)
+	return
+}
+
+// GetCounterByName is like GetCounter but the counter is specified by name,
+// ErrDocNotFound is returned if name doesn't exist
+func (c *Collection) GetCounterByName(name string, txn mondis.ProviderTxn) (n int64, err error) {
+	err = c.checkKind(model.CollectionKindCounters)
+	if err != nil {
+		return
+	}
+
+	did, err := c.lookupCounterID(name, txn)
+	if err != nil {
+		return
+	}
+
+	n, err = c.GetCounter(did, txn)
+	return
+}
+
+// SetCounterByName is like SetCounter but the counter is specified by name
+func (c *Collection) SetCounterByName(name string, n int64, txn mondis.ProviderTxn) (err error) {
+	did, err := c.CounterID(name)
+	if err != nil {
+		return
+	}
+
+	err = c.SetCounter(did, n, txn)
+	return
+}
+
+// counterNameGroup coalesces concurrent resolutions of the same counter name
+type counterNameGroup struct {
+	mu    sync.Mutex
+	calls map[string]*counterNameCall
+}
+
+type counterNameCall struct {
+	wg  sync.WaitGroup
+	did int64
+	err error
+}
+
+func (g *counterNameGroup) do(name string, fn func() (int64, error)) (did int64, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*counterNameCall)
+	}
+	if call, ok := g.calls[name]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		did, err = call.did, call.err
+		return
+	}
+	call := &counterNameCall{}
+	call.wg.Add(1)
+	g.calls[name] = call
+	g.mu.Unlock()
+
+	call.did, call.err = fn()
+	call.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, name)
+	g.mu.Unlock()
+
+	did, err = call.did, call.err
+	return
+}
@@ -0,0 +1,279 @@
+package document
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/memcomparable"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// creationBackfillBatchSize is the max number of documents visited in one txn of BackfillCreationTime
+const creationBackfillBatchSize = 256
+
+// TimeRangeOption for FindByTimeRange
+type TimeRangeOption struct {
+	// Reverse returns the most recently created first
+	Reverse bool
+	// Limit is the max number of documents returned, 0 means unlimited
+	Limit int
+}
+
+// SetCreationTimeIndex toggles whether writes of collection maintain the creation time index, see CollectionOption.CreationTimeIndex
+func (c *Collection) SetCreationTimeIndex(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.creationIndex, v)
+}
+
+func (c *Collection) creationTimeIndex() bool {
+	return atomic.LoadInt32(&c.creationIndex) == 1
+}
+
+// storedCreatedAt returns SystemFieldCreatedAt of doc as stored, ok is false if it's absent
+func (c *Collection) storedCreatedAt(doc bson.M) (createdAt primitive.DateTime, ok bool) {
+	createdAt, ok = doc[c.db.SystemField(SystemFieldCreatedAt)].(primitive.DateTime)
+	return
+}
+
+// withCreatedAt returns a copy of doc with SystemFieldCreatedAt set
+func (c *Collection) withCreatedAt(doc bson.M, createdAt primitive.DateTime) bson.M {
+	stamped := make(bson.M, len(doc)+1)
+	for field, value := range doc {
+		stamped[field] = value
+	}
+	stamped[c.db.SystemField(SystemFieldCreatedAt)] = createdAt
+	return stamped
+}
+
+// addCreationEntry with the meta of the document, so that it expires along
+func (c *Collection) addCreationEntry(createdAt, did int64, meta *mondis.VMetaReq, txn mondis.ProviderTxn) error {
+	return txn.Set(EncodeCollectionCreationTimeKey(nil, c.cid, createdAt, did), nil, meta)
+}
+
+// carryCreatedAt returns doc replacing old as did, with the creation time of old carried over,
+// or the time of now if it's new, its index entry is written with meta either way.
+// old written before CreationTimeIndex has no creation time until BackfillCreationTime.
+func (c *Collection) carryCreatedAt(doc, old bson.M, isNew bool, did int64, meta *mondis.VMetaReq, txn mondis.ProviderTxn) (carried bson.M, err error) {
+	if isNew {
+		createdAt := primitive.NewDateTimeFromTime(c.db.now())
+		carried = c.withCreatedAt(doc, createdAt)
+		err = c.addCreationEntry(int64(createdAt), did, meta, txn)
+		return
+	}
+
+	carried = doc
+	if createdAt, ok := c.storedCreatedAt(old); ok {
+		carried = c.withCreatedAt(doc, createdAt)
+		// rewritten so that its TTL follows the document
+		err = c.addCreationEntry(int64(createdAt), did, meta, txn)
+	}
+	return
+}
+
+// deleteCreationEntry deletes the index entry of the document at docKey if it has a creation time
+func (c *Collection) deleteCreationEntry(docKey []byte, did int64, txn mondis.ProviderTxn) (err error) {
+	old, exists, err := c.getStored(docKey, txn)
+	if err != nil || !exists {
+		return
+	}
+	createdAt, ok := c.storedCreatedAt(old)
+	if !ok {
+		return
+	}
+
+	err = txn.Delete(EncodeCollectionCreationTimeKey(nil, c.cid, int64(createdAt), did))
+	return
+}
+
+// FindByTimeRange returns ids and documents created in [from, to) in creation time order, ties broken by did,
+// by a scan of the index of CollectionOption.CreationTimeIndex. Creation time is of millisecond precision.
+// Documents written before the index is enabled are not found until BackfillCreationTime,
+// which regards them as created at the zero of Unix time.
+func (c *Collection) FindByTimeRange(from, to time.Time, option TimeRangeOption, txn mondis.ProviderTxn) (dids []int64, docs []bson.M, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	fromMS, toMS := int64(primitive.NewDateTimeFromTime(from)), int64(primitive.NewDateTimeFromTime(to))
+	if fromMS >= toMS {
+		return
+	}
+
+	if txn == nil {
+		var done func()
+		txn, done, err = c.newImplicitTxn()
+		if err != nil {
+			return
+		}
+		defer done()
+	}
+
+	// keys of the same creation time sort after their time bound, so fromKey includes and toKey excludes them
+	prefix := AppendCollectionCreationTimePrefix(nil, c.cid)
+	fromKey := memcomparable.EncodeInt64(append([]byte(nil), prefix...), fromMS)
+	toKey := memcomparable.EncodeInt64(append([]byte(nil), prefix...), toMS)
+	scanOption := mondis.ProviderScanOption{Prefix: prefix, Offset: fromKey, Stop: toKey, KeysOnly: true}
+	if option.Reverse {
+		scanOption.Offset, scanOption.Stop = toKey, fromKey
+		scanOption.Reverse = true
+	}
+
+	var fnErr error
+	scanErr := txn.Scan(scanOption, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		var did int64
+		_, _, did, fnErr = DecodeCollectionCreationTimeKey(key)
+		if fnErr != nil {
+			return false
+		}
+
+		var (
+			doc    bson.M
+			exists bool
+		)
+		doc, exists, fnErr = c.getStored(EncodeCollectionDocumentKey(nil, c.cid, did), txn)
+		if fnErr != nil {
+			return false
+		}
+		// left behind by a delete when the index is disabled
+		if !exists {
+			return true
+		}
+		c.stripSystemFields(doc)
+		dids = append(dids, did)
+		docs = append(docs, doc)
+		return option.Limit <= 0 || len(docs) < option.Limit
+	})
+	if fnErr != nil {
+		err = fnErr
+	} else {
+		err = scanErr
+	}
+	if err != nil {
+		dids = nil
+		docs = nil
+	}
+	return
+}
+
+// BackfillCreationTime indexes documents without creation time, i.e., written before CollectionOption.CreationTimeIndex,
+// as created at the zero of Unix time, which is also stored as their SystemFieldCreatedAt.
+// Documents are visited in did order in batches of creationBackfillBatchSize, each in its own txn retried on conflict,
+// so batches committed before ctx is done or an error stay committed, and calling it again resumes the rest.
+// n is the number of documents backfilled.
+func (c *Collection) BackfillCreationTime(ctx context.Context) (n int, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	offset := AppendCollectionDocumentPrefix(nil, c.cid)
+	for {
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+
+		var (
+			batchN  int
+			visited int
+			lastDid int64
+		)
+		err = c.runInImplicitUpdateTxnWithRetry(func(txn mondis.ProviderTxn) (err error) {
+			batchN, visited, lastDid, err = c.backfillCreationTime(offset, txn)
+			return
+		}, upsertMaxRetries)
+		if err != nil {
+			return
+		}
+		n += batchN
+		if visited < creationBackfillBatchSize {
+			return
+		}
+		offset = EncodeCollectionDocumentKey(nil, c.cid, lastDid+1)
+	}
+}
+
+func (c *Collection) backfillCreationTime(offset kv.Key, txn mondis.ProviderTxn) (n, visited int, lastDid int64, err error) {
+	var (
+		missingDids []int64
+		missingDocs []bson.M
+		fnErr       error
+	)
+	scanErr := txn.Scan(mondis.ProviderScanOption{Prefix: AppendCollectionDocumentPrefix(nil, c.cid), Offset: offset}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		_, lastDid, fnErr = DecodeCollectionDocumentKey(key)
+		if fnErr != nil {
+			return false
+		}
+		visited++
+
+		var doc bson.M
+		fnErr = bson.Unmarshal(value, &doc)
+		if fnErr != nil {
+			return false
+		}
+		if _, ok := c.storedCreatedAt(doc); !ok {
+			missingDids = append(missingDids, lastDid)
+			missingDocs = append(missingDocs, doc)
+		}
+		return visited < creationBackfillBatchSize
+	})
+	if fnErr != nil {
+		err = fnErr
+		return
+	}
+	if scanErr != nil {
+		err = scanErr
+		return
+	}
+
+	for i, did := range missingDids {
+		var data []byte
+		data, err = bson.Marshal(c.withCreatedAt(missingDocs[i], 0))
+		if err != nil {
+			return
+		}
+		err = txn.Set(EncodeCollectionDocumentKey(nil, c.cid, did), data, nil)
+		if err != nil {
+			return
+		}
+		err = c.addCreationEntry(0, did, nil, txn)
+		if err != nil {
+			return
+		}
+	}
+	n = len(missingDids)
+	return
+}
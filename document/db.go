@@ -6,10 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"sync/atomic"
+	"time"
 
 	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/intents"
+	"github.com/zhiqiangxu/mondis/document/model"
 	"github.com/zhiqiangxu/mondis/kv"
 	"github.com/zhiqiangxu/mondis/kv/numeric"
+	"github.com/zhiqiangxu/mondis/slowlog"
 	"github.com/zhiqiangxu/util/closer"
 	"github.com/zhiqiangxu/util/logger"
 	"go.uber.org/zap"
@@ -17,26 +21,68 @@ import (
 
 // DB defines a column db
 type DB struct {
-	mu                 sync.RWMutex
-	once               sync.Once
-	state              uint32
-	closer             *closer.Strict
-	kvdb               mondis.KVDB
-	collectionSequence *Sequence
-	indexSequence      *Sequence
-	collections        map[string]*Collection
+	mu                  sync.RWMutex
+	once                sync.Once
+	state               uint32
+	closer              *closer.Strict
+	kvdb                mondis.KVDB
+	collectionSequence  *Sequence
+	indexSequence       *Sequence
+	collections         map[string]*Collection
+	rateLimiter         *rateLimiter
+	reservedFieldPrefix string
+	clock               func() time.Time
+	metrics             Metrics
+	implicitTxns        *implicitTxnLimiter
+	slowLog             *slowlog.Log
 }
 
-// NewDB is ctor for DB
-func NewDB(kvdb mondis.KVDB) *DB {
+// NewDB is ctor for DB, option is applied if specified, dangling intents whose lease expired are recovered before return
+func NewDB(kvdb mondis.KVDB, options ...DBOption) *DB {
+	n, err := intents.Recover(kvdb)
+	if err != nil {
+		logger.Instance().Error("intents.Recover", zap.Error(err))
+	} else if n > 0 {
+		logger.Instance().Info("intents.Recover", zap.Int("n", n))
+	}
+
+	reservedFieldPrefix := DefaultReservedFieldPrefix
+	var (
+		clock        func() time.Time
+		metrics      Metrics
+		slowLog      *slowlog.Log
+		implicitTxns = newImplicitTxnLimiter(0, 0)
+	)
+	if len(options) != 0 {
+		if options[0].ReservedFieldPrefix != "" {
+			reservedFieldPrefix = options[0].ReservedFieldPrefix
+		}
+		clock = options[0].Clock
+		metrics = options[0].Metrics
+		slowLog = options[0].SlowLog
+		implicitTxns = newImplicitTxnLimiter(options[0].MaxImplicitTxns, options[0].ImplicitTxnWait)
+	}
+
 	collectionSequence, _ := NewSequence(kvdb, reservedKeywordCollectionBytes, collectionIDBandWidth)
 	indexSequence, _ := NewSequence(kvdb, reservedKeywordIndexBytes, indexIDBandWidth)
+	if collectionSequence != nil {
+		collectionSequence.metrics = metrics
+	}
+	if indexSequence != nil {
+		indexSequence.metrics = metrics
+	}
 	return &DB{
-		kvdb:               kvdb,
-		collectionSequence: collectionSequence,
-		indexSequence:      indexSequence,
-		collections:        make(map[string]*Collection),
-		closer:             closer.NewStrict(),
+		kvdb:                kvdb,
+		collectionSequence:  collectionSequence,
+		indexSequence:       indexSequence,
+		collections:         make(map[string]*Collection),
+		closer:              closer.NewStrict(),
+		rateLimiter:         newRateLimiter(RateLimit{}),
+		reservedFieldPrefix: reservedFieldPrefix,
+		clock:               clock,
+		metrics:             metrics,
+		implicitTxns:        implicitTxns,
+		slowLog:             slowLog,
 	}
 }
 
@@ -49,10 +95,37 @@ var (
 	ErrZeroBandwidth = errors.New("bandwidth must be greater than zero")
 	// ErrCollectionNameForbiden when collection name is a reserved keyword
 	ErrCollectionNameForbiden = errors.New("collection name is a reserved keyword")
+	// ErrCollectionKindMismatch when the operation is not supported by the collection kind
+	ErrCollectionKindMismatch = errors.New("operation not supported by collection kind")
+	// ErrInvalidCollectionKind when the stored collection kind is corrupt
+	ErrInvalidCollectionKind = errors.New("invalid collection kind")
 )
 
-// Collection returns collection operator
-func (db *DB) Collection(name string) (collection *Collection, err error) {
+// Collection returns collection operator, option is applied if specified and it's the first time db opens the collection,
+// later calls share the same operator and its options. A new collection is created as model.CollectionKindDocument.
+func (db *DB) Collection(name string, options ...CollectionOption) (collection *Collection, err error) {
+	collection, err = db.collection(name, model.CollectionKindDocument, options...)
+	return
+}
+
+// CountersCollection is like Collection but a new collection is created as model.CollectionKindCounters,
+// ErrCollectionKindMismatch is returned if the collection already exists with another kind.
+func (db *DB) CountersCollection(name string, options ...CollectionOption) (collection *Collection, err error) {
+	collection, err = db.collection(name, model.CollectionKindCounters, options...)
+	if err != nil {
+		return
+	}
+	err = collection.checkKind(model.CollectionKindCounters)
+	if err != nil {
+		collection = nil
+		return
+	}
+	return
+}
+
+// collection returns the cached operator of collection name, or opens it with options,
+// which are not applied if it's of another kind than asked.
+func (db *DB) collection(name string, kind model.CollectionKind, options ...CollectionOption) (collection *Collection, err error) {
 	if name == "" {
 		err = ErrEmptyCollectionName
 		return
@@ -69,11 +142,13 @@ func (db *DB) Collection(name string) (collection *Collection, err error) {
 
 	db.mu.RLock()
 	collection = db.collections[name]
+	db.mu.RUnlock()
+	if db.metrics != nil {
+		db.metrics.CollectionCacheAccess(collection != nil)
+	}
 	if collection != nil {
-		db.mu.RUnlock()
 		return
 	}
-	db.mu.RUnlock()
 
 	err = db.closer.Add(1)
 	if err != nil {
@@ -87,11 +162,14 @@ func (db *DB) Collection(name string) (collection *Collection, err error) {
 		db.mu.Unlock()
 		return
 	}
-	collection, err = newCollection(db, name)
+	collection, err = newCollection(db, name, kind)
 	if err != nil {
 		db.mu.Unlock()
 		return
 	}
+	if len(options) != 0 && collection.kind == kind {
+		collection.applyOption(options[0])
+	}
 	db.collections[name] = collection
 	db.mu.Unlock()
 
@@ -161,7 +239,8 @@ func (db *DB) nextIndexID() (iid int64, err error) {
 	return
 }
 
-func (db *DB) getCollectionID(name string) (cid int64, err error) {
+// getCollectionID returns the id and kind of collection name, it's created with kind if not exists
+func (db *DB) getCollectionID(name string, kind model.CollectionKind) (cid int64, actualKind model.CollectionKind, err error) {
 
 	cn2idKey := EncodeMetaCollectionName2IDKey(nil, name)
 
@@ -183,11 +262,18 @@ func (db *DB) getCollectionID(name string) (cid int64, err error) {
 			if err != nil {
 				return
 			}
+			if kind != model.CollectionKindDocument {
+				err = txn.Set(EncodeMetaCollectionKindKey(nil, int64(ucid)), []byte{byte(kind)}, nil)
+				if err != nil {
+					return
+				}
+			}
 			err = txn.Commit()
 			if err != nil {
 				return
 			}
 			cid = int64(ucid)
+			actualKind = kind
 		}
 		return
 	}
@@ -197,5 +283,19 @@ func (db *DB) getCollectionID(name string) (cid int64, err error) {
 		return
 	}
 	cid = int64(ucid)
+
+	// collections without kind are created before kind is introduced
+	v, _, err = txn.Get(EncodeMetaCollectionKindKey(nil, cid))
+	switch err {
+	case nil:
+		if len(v) != 1 {
+			err = ErrInvalidCollectionKind
+			return
+		}
+		actualKind = model.CollectionKind(v[0])
+	case kv.ErrKeyNotFound:
+		err = nil
+		actualKind = model.CollectionKindDocument
+	}
 	return
 }
@@ -1,10 +1,13 @@
 package ddl
 
 import (
+	"context"
 	"errors"
+	"sync"
 
 	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/document/dml"
+	"github.com/zhiqiangxu/mondis/document/gcworker"
 	"github.com/zhiqiangxu/mondis/document/meta"
 	"github.com/zhiqiangxu/mondis/util"
 )
@@ -20,6 +23,8 @@ var (
 	ErrDBAlreadyExists = errors.New("db already exists")
 	// ErrCollectionNotExists used by DDL
 	ErrCollectionNotExists = errors.New("collection not exists")
+	// ErrCollectionAlreadyExists used by DDL
+	ErrCollectionAlreadyExists = errors.New("collection already exists")
 	// ErrDBNotExists used by DDL
 	ErrDBNotExists = errors.New("db not exists")
 	// ErrIndexAlreadyExists used by DDL
@@ -28,32 +33,114 @@ var (
 	ErrIndexNotExists = errors.New("index not exists")
 	// ErrInvalidDDLState used by DDL
 	ErrInvalidDDLState = errors.New("invalid ddl state")
+	// ErrJobAlreadyRunning when cancelling a job that's been started
+	ErrJobAlreadyRunning = errors.New("ddl job already running")
+	// ErrCancelledDDLJob is the error of jobs cancelled by DDL.CancelJob
+	ErrCancelledDDLJob = errors.New("ddl job cancelled")
 )
 
 // DDL is responsible for updating schema in data store and maintaining in-memory schema cache.
+// Its APIs wait for the job to finish, job.SchemaVersion is then the resulting schema version,
+// which can be passed to domain.Domain.WaitSchemaVersion of other processes.
 type DDL struct {
-	kvdb    mondis.KVDB
-	options Options
-	workers map[workerType]*worker
+	kvdb     mondis.KVDB
+	options  Options
+	workers  map[workerType]*worker
+	gcWorker *gcworker.Worker
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// historyCh is closed and replaced each time jobs are moved into history
+	historyMu sync.Mutex
+	historyCh chan struct{}
 }
 
 // New is ctor for DDL
 func New(kvdb mondis.KVDB, options Options) *DDL {
 	ddl := &DDL{
-		kvdb:    kvdb,
-		options: options,
-		workers: make(map[workerType]*worker),
+		kvdb:      kvdb,
+		options:   options,
+		workers:   make(map[workerType]*worker),
+		gcWorker:  gcworker.New(kvdb),
+		historyCh: make(chan struct{}),
 	}
+	ddl.workers[defaultWorkerType] = newWorker(defaultWorkerType, ddl)
 
 	return ddl
 }
 
-func (d *DDL) start() {
-	d.workers[defaultWorkerType] = newWorker(defaultWorkerType, d)
+// Start ddl workers and gc worker in background, it's a no-op if already started
+func (d *DDL) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cancel != nil {
+		return
+	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
 	for _, w := range d.workers {
-		go w.start()
+		d.wg.Add(1)
+		go func(w *worker) {
+			defer d.wg.Done()
+			w.start(ctx)
+		}(w)
 	}
+	d.gcWorker.Start(ctx, &d.wg)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.pruneHistoryJobsLoop(ctx)
+	}()
+}
+
+// Stop background workers and wait for them to exit,
+// pending delete ranges are resumed on next Start.
+func (d *DDL) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cancel == nil {
+		return
+	}
+
+	d.cancel()
+	d.wg.Wait()
+	d.cancel = nil
+}
+
+// GCStatus returns the status of deleting data of dropped collections
+func (d *DDL) GCStatus() (status gcworker.Status, err error) {
+	status, err = d.gcWorker.Status()
+	return
+}
+
+// Close stops background workers and discards the did sequences created by Init,
+// so that the same collections can be loaded again by another DDL in this process.
+func (d *DDL) Close() (err error) {
+	d.Stop()
+
+	err = util.RunInNewTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		dbs, err := m.ListDatabases()
+		if err != nil {
+			return
+		}
+
+		for _, db := range dbs {
+			for _, ci := range db.Collections {
+				err = dml.DiscardSequenceIfExists(ci.ID)
+				if err != nil {
+					return
+				}
+			}
+		}
+		return
+	})
+	return
 }
 
 // Init DDL
@@ -79,6 +166,6 @@ func (d *DDL) Init() (err error) {
 	if err != nil {
 		return
 	}
-	d.start()
+	d.Start()
 	return
 }
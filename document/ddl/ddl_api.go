@@ -2,11 +2,13 @@ package ddl
 
 import (
 	"context"
+	"sort"
 
 	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/document/meta"
 	"github.com/zhiqiangxu/mondis/document/model"
 	"github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/util/osc"
 )
 
 // CreateSchema for create db
@@ -92,6 +94,78 @@ func (d *DDL) CreateSchema(ctx context.Context, input CreateSchemaInput) (job *m
 	return
 }
 
+// CreateCollection for create collection in an existing db
+func (d *DDL) CreateCollection(ctx context.Context, input CreateCollectionInput) (job *model.Job, err error) {
+	err = input.Validate()
+	if err != nil {
+		return
+	}
+
+	n := 2 + len(input.Indices)
+	err = util.RunInNewUpdateTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		queueLength, err := m.DDLJobQueueLen()
+		if err != nil {
+			return
+		}
+		if queueLength > maxJobsInQueue {
+			err = ErrJobsInQueueExceeded
+			return
+		}
+
+		dbInfo, err := getDbInfo(m, input.DB)
+		if err != nil {
+			return
+		}
+		if dbInfo == nil || dbInfo.State != osc.StatePublic {
+			err = ErrDBNotExists
+			return
+		}
+		if dbInfo.CollectionExists(input.Collection) {
+			err = ErrCollectionAlreadyExists
+			return
+		}
+
+		start, _, err := m.GenGlobalIDs(n)
+		if err != nil {
+			return
+		}
+
+		nextID := start + 1
+		collectionInfo := &model.CollectionInfo{
+			ID:      nextID,
+			Name:    input.Collection,
+			Indices: make(map[string]*model.IndexInfo),
+		}
+		for _, indexInfo := range input.Indices {
+			iif := indexInfo.ToModel()
+			iif.ID = nextID + 1
+			nextID++
+			collectionInfo.Indices[indexInfo.Name] = iif
+			collectionInfo.IndexOrder = append(collectionInfo.IndexOrder, indexInfo.Name)
+		}
+
+		job = &model.Job{
+			ID:   nextID + 1,
+			Type: model.ActionCreateCollection,
+			Arg:  &model.CreateCollectionArg{DBID: dbInfo.ID, Collection: collectionInfo},
+		}
+
+		err = m.EnQueueDDLJob(job)
+
+		return
+	})
+
+	if err != nil {
+		return
+	}
+
+	d.notifyWorker(job.Type)
+
+	err = d.checkJob(ctx, job)
+	return
+}
+
 // AddIndex for add index
 func (d *DDL) AddIndex(ctx context.Context, input AddIndexInput) (job *model.Job, err error) {
 	err = util.RunInNewUpdateTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
@@ -138,8 +212,263 @@ func (d *DDL) AddIndex(ctx context.Context, input AddIndexInput) (job *model.Job
 	return
 }
 
-// DropSchema for drop db
-func (d *DDL) DropSchema(ctx context.Context, input DropSchemaInput) (err error) {
+// RebuildIndex for delete all entries of a public index and backfill them again,
+// the index is not readable meanwhile. If documents violate a unique index, the index is dropped like a failed AddIndex.
+// Cancelling ctx only stops waiting, the job goes on in background, see Options.ReorgInterval for its pace.
+func (d *DDL) RebuildIndex(ctx context.Context, input RebuildIndexInput) (job *model.Job, err error) {
+	err = input.Validate()
+	if err != nil {
+		return
+	}
+
+	err = util.RunInNewUpdateTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		queueLength, err := m.DDLJobQueueLen()
+		if err != nil {
+			return
+		}
+		if queueLength > maxJobsInQueue {
+			err = ErrJobsInQueueExceeded
+			return
+		}
+
+		dbInfo, err := getDbInfo(m, input.DB)
+		if err != nil {
+			return
+		}
+		if dbInfo == nil || dbInfo.State != osc.StatePublic {
+			err = ErrDBNotExists
+			return
+		}
+		ci := dbInfo.CollectionInfo(input.Collection)
+		if ci == nil || ci.State != osc.StatePublic {
+			err = ErrCollectionNotExists
+			return
+		}
+		// an index not public is being added or rebuilt
+		iif := ci.IndexInfo(input.IndexName)
+		if iif == nil || iif.State != osc.StatePublic {
+			err = ErrIndexNotExists
+			return
+		}
+
+		jobID, err := m.GenGlobalID()
+		if err != nil {
+			return
+		}
+
+		arg := iif.Clone()
+		arg.JobRedundant = &model.IndexInfoRedundant{
+			DB:         input.DB,
+			Collection: input.Collection,
+			CID:        ci.ID,
+		}
+		job = &model.Job{
+			ID:          jobID,
+			Type:        model.ActionRebuildIndex,
+			Arg:         arg,
+			SchemaState: osc.StatePublic,
+		}
+
+		err = m.EnQueueDDLJob(job)
+
+		return
+	})
+
+	if err != nil {
+		return
+	}
+
+	d.notifyWorker(job.Type)
+
+	err = d.checkJob(ctx, job)
+	return
+}
+
+// DropIndex for drop a public index in two phases: it's made unusable first, i.e. write only then delete only,
+// so that readers stop choosing it before any entry is deleted, then its entries are deleted batch by batch
+// and it's removed once all are gone. Lookups of the index by name fail with dml.ErrIndexDropping after the first phase.
+// The job can be cancelled by CancelJob before entries are deleted, which makes the index public again.
+func (d *DDL) DropIndex(ctx context.Context, input DropIndexInput) (job *model.Job, err error) {
+	err = input.Validate()
+	if err != nil {
+		return
+	}
+
+	err = util.RunInNewUpdateTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		queueLength, err := m.DDLJobQueueLen()
+		if err != nil {
+			return
+		}
+		if queueLength > maxJobsInQueue {
+			err = ErrJobsInQueueExceeded
+			return
+		}
+
+		dbInfo, err := getDbInfo(m, input.DB)
+		if err != nil {
+			return
+		}
+		if dbInfo == nil || dbInfo.State != osc.StatePublic {
+			err = ErrDBNotExists
+			return
+		}
+		ci := dbInfo.CollectionInfo(input.Collection)
+		if ci == nil || ci.State != osc.StatePublic {
+			err = ErrCollectionNotExists
+			return
+		}
+		// an index not public is being added, rebuilt or dropped
+		iif := ci.IndexInfo(input.IndexName)
+		if iif == nil || iif.State != osc.StatePublic {
+			err = ErrIndexNotExists
+			return
+		}
+
+		jobID, err := m.GenGlobalID()
+		if err != nil {
+			return
+		}
+
+		arg := iif.Clone()
+		arg.JobRedundant = &model.IndexInfoRedundant{
+			DB:         input.DB,
+			Collection: input.Collection,
+			CID:        ci.ID,
+		}
+		job = &model.Job{
+			ID:          jobID,
+			Type:        model.ActionDropIndex,
+			Arg:         arg,
+			SchemaState: osc.StatePublic,
+		}
+
+		err = m.EnQueueDDLJob(job)
+
+		return
+	})
+
+	if err != nil {
+		return
+	}
+
+	d.notifyWorker(job.Type)
+
+	err = d.checkJob(ctx, job)
+	return
+}
+
+// DropSchema for drop db, its collections are dropped as well
+func (d *DDL) DropSchema(ctx context.Context, input DropSchemaInput) (job *model.Job, err error) {
+	err = input.Validate()
+	if err != nil {
+		return
+	}
+
+	err = util.RunInNewUpdateTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		queueLength, err := m.DDLJobQueueLen()
+		if err != nil {
+			return
+		}
+		if queueLength > maxJobsInQueue {
+			err = ErrJobsInQueueExceeded
+			return
+		}
+
+		dbInfo, err := getDbInfo(m, input.DB)
+		if err != nil {
+			return
+		}
+		// a db not public is being dropped
+		if dbInfo == nil || dbInfo.State != osc.StatePublic {
+			err = ErrDBNotExists
+			return
+		}
+
+		jobID, err := m.GenGlobalID()
+		if err != nil {
+			return
+		}
+
+		job = &model.Job{
+			ID:   jobID,
+			Type: model.ActionDropSchema,
+			Arg:  &model.DBInfo{ID: dbInfo.ID, Name: dbInfo.Name},
+		}
+
+		err = m.EnQueueDDLJob(job)
+
+		return
+	})
+
+	if err != nil {
+		return
+	}
+
+	d.notifyWorker(job.Type)
+
+	err = d.checkJob(ctx, job)
+	return
+}
+
+// DropCollection for drop collection, documents are deleted in background after the job is done
+func (d *DDL) DropCollection(ctx context.Context, input DropCollectionInput) (job *model.Job, err error) {
+	err = input.Validate()
+	if err != nil {
+		return
+	}
+
+	err = util.RunInNewUpdateTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		queueLength, err := m.DDLJobQueueLen()
+		if err != nil {
+			return
+		}
+		if queueLength > maxJobsInQueue {
+			err = ErrJobsInQueueExceeded
+			return
+		}
+
+		dbInfo, err := getDbInfo(m, input.DB)
+		if err != nil {
+			return
+		}
+		if dbInfo == nil || dbInfo.State != osc.StatePublic {
+			err = ErrDBNotExists
+			return
+		}
+		// a collection not public is being dropped
+		ci := dbInfo.CollectionInfo(input.Collection)
+		if ci == nil || ci.State != osc.StatePublic {
+			err = ErrCollectionNotExists
+			return
+		}
+
+		jobID, err := m.GenGlobalID()
+		if err != nil {
+			return
+		}
+
+		job = &model.Job{
+			ID:   jobID,
+			Type: model.ActionDropCollection,
+			Arg:  &model.DropCollectionArg{DBID: dbInfo.ID, Collection: ci},
+		}
+
+		err = m.EnQueueDDLJob(job)
+
+		return
+	})
+
+	if err != nil {
+		return
+	}
+
+	d.notifyWorker(job.Type)
+
+	err = d.checkJob(ctx, job)
 	return
 }
 
@@ -154,3 +483,99 @@ func (d *DDL) GetHistoryJob(jobID int64) (job *model.Job, err error) {
 
 	return
 }
+
+// GetPendingJobs returns jobs in both DefaultJobListKey and AddIndexJobListKey queues, in queue order
+func (d *DDL) GetPendingJobs() (jobs []*model.Job, err error) {
+	err = util.RunInNewTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		jobs = nil
+		for _, listKey := range []meta.JobListKeyType{meta.DefaultJobListKey, meta.AddIndexJobListKey} {
+			var listJobs []*model.Job
+			listJobs, err = queuedJobs(m, listKey)
+			if err != nil {
+				return
+			}
+			jobs = append(jobs, listJobs...)
+		}
+		return
+	})
+	return
+}
+
+// queuedJobs returns jobs of listKey from head to tail, i.e., indexed as by Meta.UpdateDDLJob
+func queuedJobs(m *meta.Meta, listKey meta.JobListKeyType) (jobs []*model.Job, err error) {
+	jobs, err = m.GetAllDDLJobsInQueue(listKey)
+	if err != nil {
+		return
+	}
+	// GetAllDDLJobsInQueue goes from tail to head
+	for i, j := 0, len(jobs)-1; i < j; i, j = i+1, j-1 {
+		jobs[i], jobs[j] = jobs[j], jobs[i]
+	}
+	return
+}
+
+// GetHistoryJobs returns up to limit most recent finished jobs, newest first, limit <= 0 means all
+func (d *DDL) GetHistoryJobs(limit int) (jobs []*model.Job, err error) {
+	err = util.RunInNewTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		if limit > 0 {
+			jobs, err = m.GetLastNHistoryDDLJobs(limit)
+			return
+		}
+		jobs, err = m.GetAllHistoryDDLJobs()
+		if err != nil {
+			return
+		}
+		sort.Slice(jobs, func(i, j int) bool {
+			return jobs[i].ID > jobs[j].ID
+		})
+		return
+	})
+	return
+}
+
+// CancelJob marks a queued job as cancelled, which the worker finishes without running it,
+// and the API waiting for it fails with the model.JobError of ErrCancelledDDLJob.
+// A started ActionDropIndex job is rolled back instead if its index entries are not being deleted yet.
+// ErrJobAlreadyRunning is returned if the job is the head of its queue and has been started otherwise,
+// meta.ErrJobNotExists if it's not queued.
+func (d *DDL) CancelJob(jobID int64) (err error) {
+	err = util.RunInNewUpdateTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		for _, listKey := range []meta.JobListKeyType{meta.DefaultJobListKey, meta.AddIndexJobListKey} {
+			var jobs []*model.Job
+			jobs, err = queuedJobs(m, listKey)
+			if err != nil {
+				return
+			}
+			for i, job := range jobs {
+				if job.ID != jobID {
+					continue
+				}
+				if job.IsCancelled() || job.IsCancelling() {
+					return
+				}
+				job.Error = model.NewJobError(ErrCancelledDDLJob)
+				switch {
+				case i != 0 || job.State == model.JobStateNone:
+					job.State = model.JobStateCancelled
+				case job.Type == model.ActionDropIndex && job.State == model.JobStateRunning && job.SchemaState == osc.StateWriteOnly:
+					// rolled back by the worker
+					job.State = model.JobStateCancelling
+				default:
+					err = ErrJobAlreadyRunning
+					return
+				}
+				err = m.UpdateDDLJob(int64(i), job, listKey)
+				return
+			}
+		}
+		err = meta.ErrJobNotExists
+		return
+	})
+	if err == nil {
+		d.notifyWorker(model.ActionNone)
+	}
+	return
+}
@@ -3,6 +3,7 @@ package ddl
 import (
 	"context"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/zhiqiangxu/mondis"
@@ -10,6 +11,7 @@ import (
 	"github.com/zhiqiangxu/mondis/document/dml"
 	"github.com/zhiqiangxu/mondis/document/meta"
 	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv"
 	"github.com/zhiqiangxu/mondis/util"
 	util2 "github.com/zhiqiangxu/util"
 	"github.com/zhiqiangxu/util/logger"
@@ -30,10 +32,11 @@ type worker struct {
 }
 
 func newWorker(tp workerType, d *DDL) *worker {
-	return &worker{tp: tp, jobCh: make(chan struct{}), d: d}
+	// buffered so that a job enqueued while the worker is busy is not left to the ticker
+	return &worker{tp: tp, jobCh: make(chan struct{}, 1), d: d}
 }
 
-func (w *worker) start() {
+func (w *worker) start(ctx context.Context) {
 	conf := config.Load()
 	workerCheckTime := util.ChooseTime(2*conf.Lease, conf.WorkerMaxTickInterval)
 
@@ -44,6 +47,8 @@ func (w *worker) start() {
 		select {
 		case <-ticker.C:
 		case <-w.jobCh:
+		case <-ctx.Done():
+			return
 		}
 
 		err := w.handleJobQueue()
@@ -68,9 +73,12 @@ func (w *worker) handleJobQueue() (err error) {
 		failNow             bool
 		runJobErr           error
 		afterCommitFunc4Job func()
+		cancelFunc4Job      func()
 		job                 *model.Job
+		finished            bool
 	)
 	for {
+		finished = false
 		err = util.RunInNewUpdateTxnWithCallback(w.d.kvdb, func(txn mondis.ProviderTxn) (err error) {
 			m := meta.NewMeta(txn)
 
@@ -83,31 +91,41 @@ func (w *worker) handleJobQueue() (err error) {
 				return
 			}
 
+			// cancelled by DDL.CancelJob before it's started
+			if job.IsCancelled() {
+				finished = true
+				err = w.finishJob(m, job)
+				return
+			}
+
 			if job.IsDone() || job.IsRollbackDone() {
 				if !job.IsRollbackDone() {
 					job.State = model.JobStateSynced
 				}
+				finished = true
 				err = w.finishJob(m, job)
 				return
 			}
 
 			util2.RunWithRecovery(func() {
-				schemaVersion, afterCommitFunc4Job, failNow, runJobErr = w.runJob(m, job)
+				schemaVersion, afterCommitFunc4Job, cancelFunc4Job, failNow, runJobErr = w.runJob(txn, m, job)
 			}, func(interface{}) {
 				job.State = model.JobStateCancelling
 			})
 
 			if runJobErr != nil {
 				job.ErrorCount++
-				job.Error = runJobErr
+				job.Error = model.NewJobError(runJobErr)
 				logger.Instance().Error("runJob", zap.Any("job", job), zap.Error(runJobErr))
 				if failNow || job.ErrorCount >= jobMaxErrorCount {
+					finished = true
 					err = w.finishJob(m, job)
 					return
 				}
 			}
 
 			if job.IsCancelled() {
+				finished = true
 				err = w.finishJob(m, job)
 				return
 			}
@@ -119,7 +137,15 @@ func (w *worker) handleJobQueue() (err error) {
 				afterCommitFunc4Job()
 			}
 		})
+		// undo what the job did outside the txn
+		if cancelFunc4Job != nil && (err != nil || runJobErr != nil) {
+			cancelFunc4Job()
+		}
 		afterCommitFunc4Job = nil
+		cancelFunc4Job = nil
+		if finished && err == nil {
+			w.d.notifyHistory()
+		}
 
 		if nojob {
 			return
@@ -137,13 +163,16 @@ func (w *worker) handleJobQueue() (err error) {
 
 		if runJobErr != nil {
 			time.Sleep(time.Second)
+		} else if !finished && schemaVersion == 0 && w.d.options.ReorgInterval > 0 {
+			// a batch of reorganization
+			time.Sleep(w.d.options.ReorgInterval)
 		}
 
 		w.waitSchemaChanged(schemaVersion, job)
 	}
 }
 
-func (w *worker) runJob(m *meta.Meta, job *model.Job) (schemaVersion int64, afterCommitFunc4Job func(), failNow bool, err error) {
+func (w *worker) runJob(txn mondis.ProviderTxn, m *meta.Meta, job *model.Job) (schemaVersion int64, afterCommitFunc4Job, cancelFunc4Job func(), failNow bool, err error) {
 	if job.IsFinished() {
 		return
 	}
@@ -155,13 +184,26 @@ func (w *worker) runJob(m *meta.Meta, job *model.Job) (schemaVersion int64, afte
 	switch job.Type {
 	case model.ActionCreateSchema:
 		schemaVersion, afterCommitFunc4Job, failNow, err = w.onCreateSchema(m, job)
+	case model.ActionCreateCollection:
+		schemaVersion, afterCommitFunc4Job, cancelFunc4Job, failNow, err = w.onCreateCollection(m, job)
 	case model.ActionAddIndex:
-		schemaVersion, afterCommitFunc4Job, failNow, err = w.onAddIndex(m, job)
+		schemaVersion, afterCommitFunc4Job, failNow, err = w.onAddIndex(txn, m, job)
+	case model.ActionRebuildIndex:
+		schemaVersion, afterCommitFunc4Job, failNow, err = w.onRebuildIndex(txn, m, job)
+	case model.ActionDropIndex:
+		schemaVersion, failNow, err = w.onDropIndex(txn, m, job)
+	case model.ActionDropCollection:
+		schemaVersion, afterCommitFunc4Job, failNow, err = w.onDropCollection(m, job)
+	case model.ActionDropSchema:
+		schemaVersion, afterCommitFunc4Job, failNow, err = w.onDropSchema(m, job)
 	default:
 		// Invalid job, cancel it.
 		job.State = model.JobStateCancelled
 		err = fmt.Errorf("invalid ddl job type: %v", job.Type)
 	}
+	if schemaVersion > 0 {
+		job.SchemaVersion = schemaVersion
+	}
 	return
 }
 
@@ -186,7 +228,7 @@ func (w *worker) getFirstJob(m *meta.Meta) (job *model.Job, err error) {
 	return
 }
 
-func (w *worker) onAddIndex(m *meta.Meta, job *model.Job) (schemaVersion int64, afterCommitFunc4Job func(), failNow bool, err error) {
+func (w *worker) onAddIndex(txn mondis.ProviderTxn, m *meta.Meta, job *model.Job) (schemaVersion int64, afterCommitFunc4Job func(), failNow bool, err error) {
 	indexInfo := &model.IndexInfo{}
 	if err = job.DecodeArg(indexInfo); err != nil {
 		job.State = model.JobStateCancelled
@@ -211,6 +253,11 @@ func (w *worker) onAddIndex(m *meta.Meta, job *model.Job) (schemaVersion int64,
 		return
 	}
 
+	if job.IsRollingback() {
+		schemaVersion, afterCommitFunc4Job, err = w.rollbackAddIndex(m, job, dbi, ci, indexInfo)
+		return
+	}
+
 	iif := ci.IndexInfo(indexInfo.Name)
 
 	switch job.SchemaState {
@@ -223,57 +270,214 @@ func (w *worker) onAddIndex(m *meta.Meta, job *model.Job) (schemaVersion int64,
 		}
 
 		indexInfo.JobRedundant.CID = ci.ID
-		clone := indexInfo.Clone()
-		clone.JobRedundant = nil
-		clone.State = osc.StateDeleteOnly
-		ok := ci.AddIndexInfo(clone)
-		if !ok {
-			panic("AddIndexInfo: bug happened")
-		}
-
-		schemaVersion, err = updateSchemaVersionAndCollectionInfo(m, job, dbi, ci)
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateDeleteOnly)
 		if err != nil {
 			return
 		}
-
-		job.RawArg = nil // will encode job.Arg into job.RawArg
 		job.SchemaState = osc.StateDeleteOnly
 
 	case osc.StateDeleteOnly:
 		// delete only -> write only
-		if ci == nil {
+		if iif == nil {
 			err = ErrIndexNotExists
 			failNow = true
 			return
 		}
-		iif.State = osc.StateWriteOnly
-		ok := ci.UpdateIndexInfo(iif)
-		if !ok {
-			panic("UpdateIndexInfo: bug happened")
-		}
-		schemaVersion, err = updateSchemaVersionAndCollectionInfo(m, job, dbi, ci)
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateWriteOnly)
 		if err != nil {
 			return
 		}
 		job.SchemaState = osc.StateWriteOnly
 	case osc.StateWriteOnly:
 		// write only -> reorganization
-		if ci == nil {
+		if iif == nil {
+			err = ErrIndexNotExists
+			failNow = true
+			return
+		}
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateWriteReorganization)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateWriteReorganization
+	case osc.StateWriteReorganization:
+		// reorganization -> public
+		if iif == nil {
 			err = ErrIndexNotExists
 			failNow = true
 			return
 		}
-		iif.State = osc.StateWriteReorganization
-		ok := ci.UpdateIndexInfo(iif)
-		if !ok {
-			panic("UpdateIndexInfo: bug happened")
+
+		var done bool
+		done, err = w.backfillIndex(txn, m, job, ci.ID, iif)
+		if err != nil {
+			return
+		}
+		if !done || job.IsRollingback() {
+			return
+		}
+
+		err = m.RemoveDDLReorgHandle(job)
+		if err != nil {
+			return
+		}
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StatePublic)
+		if err != nil {
+			return
+		}
+		job.FinishCollectionJob(model.JobStateDone, osc.StatePublic, schemaVersion, ci)
+	default:
+		err = ErrInvalidDDLState
+		failNow = true
+		return
+	}
+
+	return
+}
+
+const (
+	// reorgBatchSize is the max number of documents backfilled in one job txn
+	reorgBatchSize = 256
+)
+
+// backfillIndex backfills a batch of documents in the job txn, so that the progress is saved atomically,
+// the job is rolled back if unique index is violated.
+func (w *worker) backfillIndex(txn mondis.ProviderTxn, m *meta.Meta, job *model.Job, cid int64, iif *model.IndexInfo) (done bool, err error) {
+	offset := dml.AppendCollectionDocumentPrefix(nil, cid)
+	lastDid, err := m.GetDDLReorgStartHandle(job)
+	switch err {
+	case nil:
+		if lastDid == math.MaxInt64 {
+			done = true
+			return
+		}
+		offset = dml.EncodeCollectionDocumentKey(nil, cid, lastDid+1)
+	case kv.ErrKeyNotFound:
+		err = nil
+	default:
+		return
+	}
+
+	lastDid, n, err := dml.BackfillIndex(txn, cid, iif, offset, reorgBatchSize)
+	if err == dml.ErrUniqueViolated {
+		job.State = model.JobStateRollingback
+		job.Error = model.NewJobError(fmt.Errorf("%v: did %d", err, lastDid))
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if n > 0 {
+		err = m.UpdateDDLReorgStartHandle(job, lastDid)
+		if err != nil {
+			return
+		}
+	}
+	done = n < reorgBatchSize
+	return
+}
+
+// onRebuildIndex goes public -> write only -> delete only, where old entries are deleted batch by batch,
+// then write only -> reorganization -> public like onAddIndex.
+// Old entries are only deleted in delete only state, so that none written meanwhile is lost,
+// and the index is write only before that, so that readers of public index don't miss new documents.
+func (w *worker) onRebuildIndex(txn mondis.ProviderTxn, m *meta.Meta, job *model.Job) (schemaVersion int64, afterCommitFunc4Job func(), failNow bool, err error) {
+	indexInfo := &model.IndexInfo{}
+	if err = job.DecodeArg(indexInfo); err != nil {
+		job.State = model.JobStateCancelled
+		return
+	}
+
+	dbi, err := getDbInfo(m, indexInfo.JobRedundant.DB)
+	if err != nil {
+		return
+	}
+
+	if dbi == nil {
+		err = ErrDBNotExists
+		failNow = true
+		return
+	}
+
+	ci := dbi.CollectionInfo(indexInfo.JobRedundant.Collection)
+	if ci == nil || ci.ID != indexInfo.JobRedundant.CID {
+		err = ErrCollectionNotExists
+		failNow = true
+		return
+	}
+
+	if job.IsRollingback() {
+		schemaVersion, afterCommitFunc4Job, err = w.rollbackAddIndex(m, job, dbi, ci, indexInfo)
+		return
+	}
+
+	iif := ci.IndexInfo(indexInfo.Name)
+	if iif == nil || iif.ID != indexInfo.ID {
+		err = ErrIndexNotExists
+		failNow = true
+		return
+	}
+
+	switch job.SchemaState {
+	case osc.StatePublic:
+		// public -> write only
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateWriteOnly)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateWriteOnly
+	case osc.StateWriteOnly:
+		if !indexInfo.JobRedundant.Cleared {
+			// write only -> delete only
+			schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateDeleteOnly)
+			if err != nil {
+				return
+			}
+			job.SchemaState = osc.StateDeleteOnly
+			return
 		}
-		schemaVersion, err = updateSchemaVersionAndCollectionInfo(m, job, dbi, ci)
+		// write only -> reorganization
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateWriteReorganization)
 		if err != nil {
 			return
 		}
 		job.SchemaState = osc.StateWriteReorganization
+	case osc.StateDeleteOnly:
+		var n int
+		n, err = dml.ClearIndex(txn, ci.ID, indexInfo.ID, reorgBatchSize)
+		if err != nil || n == reorgBatchSize {
+			return
+		}
+
+		// delete only -> write only
+		indexInfo.JobRedundant.Cleared = true
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateWriteOnly)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateWriteOnly
 	case osc.StateWriteReorganization:
+		// reorganization -> public
+		var done bool
+		done, err = w.backfillIndex(txn, m, job, ci.ID, iif)
+		if err != nil {
+			return
+		}
+		if !done || job.IsRollingback() {
+			return
+		}
+
+		err = m.RemoveDDLReorgHandle(job)
+		if err != nil {
+			return
+		}
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StatePublic)
+		if err != nil {
+			return
+		}
+		job.FinishCollectionJob(model.JobStateDone, osc.StatePublic, schemaVersion, ci)
 	default:
 		err = ErrInvalidDDLState
 		failNow = true
@@ -283,6 +487,155 @@ func (w *worker) onAddIndex(m *meta.Meta, job *model.Job) (schemaVersion int64,
 	return
 }
 
+// onDropIndex goes public -> write only -> delete only, where entries are deleted batch by batch, -> absent.
+// The index is never read once it's write only, and no entry is written once it's delete only,
+// so the entries are only deleted when no reader or writer is using them.
+// The job is rolled back to public if it's cancelled while write only.
+func (w *worker) onDropIndex(txn mondis.ProviderTxn, m *meta.Meta, job *model.Job) (schemaVersion int64, failNow bool, err error) {
+	indexInfo := &model.IndexInfo{}
+	if err = job.DecodeArg(indexInfo); err != nil {
+		job.State = model.JobStateCancelled
+		return
+	}
+
+	dbi, err := getDbInfo(m, indexInfo.JobRedundant.DB)
+	if err != nil {
+		return
+	}
+
+	if dbi == nil {
+		err = ErrDBNotExists
+		failNow = true
+		return
+	}
+
+	ci := dbi.CollectionInfo(indexInfo.JobRedundant.Collection)
+	if ci == nil || ci.ID != indexInfo.JobRedundant.CID {
+		err = ErrCollectionNotExists
+		failNow = true
+		return
+	}
+
+	iif := ci.IndexInfo(indexInfo.Name)
+	if iif == nil || iif.ID != indexInfo.ID {
+		err = ErrIndexNotExists
+		failNow = true
+		return
+	}
+
+	if job.IsCancelling() {
+		// write only -> public
+		indexInfo.Dropping = false
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StatePublic)
+		if err != nil {
+			return
+		}
+		job.State = model.JobStateRollbackDone
+		job.SchemaState = osc.StatePublic
+		return
+	}
+
+	switch job.SchemaState {
+	case osc.StatePublic:
+		// public -> write only
+		indexInfo.Dropping = true
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateWriteOnly)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateWriteOnly
+	case osc.StateWriteOnly:
+		// write only -> delete only
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateDeleteOnly)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateDeleteOnly
+	case osc.StateDeleteOnly:
+		var n int
+		n, err = dml.ClearIndex(txn, ci.ID, indexInfo.ID, reorgBatchSize)
+		if err != nil || n == reorgBatchSize {
+			return
+		}
+
+		// delete only -> absent
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateAbsent)
+		if err != nil {
+			return
+		}
+		job.FinishCollectionJob(model.JobStateDone, osc.StateAbsent, schemaVersion, ci)
+	default:
+		err = ErrInvalidDDLState
+		failNow = true
+		return
+	}
+
+	return
+}
+
+// rollbackAddIndex takes the index back to absent, entries already written are deleted by gc worker
+func (w *worker) rollbackAddIndex(m *meta.Meta, job *model.Job, dbi *model.DBInfo, ci *model.CollectionInfo, indexInfo *model.IndexInfo) (schemaVersion int64, afterCommitFunc4Job func(), err error) {
+	if ci.IndexInfo(indexInfo.Name) == nil {
+		job.State = model.JobStateRollbackDone
+		job.SchemaState = osc.StateAbsent
+		return
+	}
+
+	switch job.SchemaState {
+	case osc.StateWriteReorganization, osc.StateWriteOnly:
+		// -> delete only, so that no more entries are written
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateDeleteOnly)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateDeleteOnly
+	default:
+		// delete only -> absent
+		err = m.RemoveDDLReorgHandle(job)
+		if err != nil {
+			return
+		}
+		err = m.AddDeleteRange(&model.DeleteRange{Prefix: dml.AppendCollectionIndexPrefix(nil, ci.ID, indexInfo.ID)})
+		if err != nil {
+			return
+		}
+		schemaVersion, err = updateSchemaVersionAndIndexInfo(m, job, dbi, ci, indexInfo, osc.StateAbsent)
+		if err != nil {
+			return
+		}
+		job.State = model.JobStateRollbackDone
+		job.SchemaState = osc.StateAbsent
+
+		afterCommitFunc4Job = w.d.gcWorker.Notify
+	}
+	return
+}
+
+// updateSchemaVersionAndIndexInfo moves the index to state,
+// indexInfo is also the arg of schema diff, so that meta cache can apply it.
+func updateSchemaVersionAndIndexInfo(m *meta.Meta, job *model.Job, dbInfo *model.DBInfo, ci *model.CollectionInfo, indexInfo *model.IndexInfo, state osc.SchemaState) (schemaVersion int64, err error) {
+	indexInfo.State = state
+	clone := indexInfo.Clone()
+	clone.JobRedundant = nil
+	var ok bool
+	switch {
+	case state == osc.StateAbsent:
+		ok = ci.RemoveIndexInfo(clone.Name)
+	case state == osc.StateDeleteOnly && job.SchemaState == osc.StateAbsent:
+		ok = ci.AddIndexInfo(clone)
+	default:
+		ok = ci.UpdateIndexInfo(clone)
+	}
+	if !ok {
+		panic("updateSchemaVersionAndIndexInfo: bug happened")
+	}
+
+	job.Arg = indexInfo
+	job.RawArg = nil // will encode job.Arg into job.RawArg
+	schemaVersion, err = updateSchemaVersionAndCollectionInfo(m, job, dbInfo, ci)
+	return
+}
+
 func (w *worker) onCreateSchema(m *meta.Meta, job *model.Job) (schemaVersion int64, afterCommitFunc4Job func(), failNow bool, err error) {
 
 	dbInfo := &model.DBInfo{}
@@ -319,6 +672,7 @@ func (w *worker) onCreateSchema(m *meta.Meta, job *model.Job) (schemaVersion int
 		}
 	}
 
+	job.RawArg = nil // will encode job.Arg into job.RawArg
 	schemaVersion, err = updateSchemaVersion(m, job)
 	if err != nil {
 		return
@@ -340,6 +694,289 @@ func (w *worker) onCreateSchema(m *meta.Meta, job *model.Job) (schemaVersion int
 
 }
 
+func (w *worker) onCreateCollection(m *meta.Meta, job *model.Job) (schemaVersion int64, afterCommitFunc4Job, cancelFunc4Job func(), failNow bool, err error) {
+	arg := &model.CreateCollectionArg{}
+	if err = job.DecodeArg(arg); err != nil {
+		job.State = model.JobStateCancelled
+		return
+	}
+	collection := arg.Collection
+
+	dbInfo, err := m.GetDatabase(arg.DBID)
+	if err != nil {
+		if err == meta.ErrDBNotExists {
+			err = ErrDBNotExists
+			failNow = true
+		}
+		return
+	}
+	if dbInfo.State != osc.StatePublic {
+		err = ErrDBNotExists
+		failNow = true
+		return
+	}
+	if dbInfo.CollectionExists(collection.Name) {
+		err = ErrCollectionAlreadyExists
+		failNow = true
+		return
+	}
+
+	cancelFunc4Job = func() {
+		err := dml.DropSequenceIfExists(collection.ID)
+		if err != nil {
+			logger.Instance().Error("DropSequenceIfExists", zap.Int64("cid", collection.ID), zap.Error(err))
+		}
+	}
+
+	switch job.SchemaState {
+	case osc.StateAbsent:
+		// the sequence is allocated in its own txn which updates the db hash,
+		// it would conflict with CreateCollection if done in the same step.
+		err = dml.CreateSequence(w.d.kvdb, dbInfo.ID, collection.ID, 0)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateDeleteOnly
+	case osc.StateDeleteOnly:
+		collection.State = osc.StatePublic
+		for _, index := range collection.Indices {
+			index.State = osc.StatePublic
+		}
+		err = m.CreateCollection(dbInfo.ID, collection)
+		if err != nil {
+			return
+		}
+		dbInfo.AddCollectionInfo(collection)
+		err = m.UpdateDatabase(dbInfo)
+		if err != nil {
+			return
+		}
+
+		job.RawArg = nil // will encode job.Arg into job.RawArg
+		schemaVersion, err = updateSchemaVersion(m, job)
+		if err != nil {
+			return
+		}
+		job.FinishCollectionJob(model.JobStateDone, osc.StatePublic, schemaVersion, collection)
+
+		// the sequence is gone if restarted or failed after the first step
+		if dml.GetSequence(collection.ID) == nil {
+			afterCommitFunc4Job = func() {
+				util2.TryUntilSuccess(func() bool {
+					err := dml.CreateSequence(w.d.kvdb, dbInfo.ID, collection.ID, 0)
+					if err != nil {
+						logger.Instance().Error("CreateSequence", zap.Int64("dbid", dbInfo.ID), zap.Int64("cid", collection.ID), zap.Error(err))
+					}
+					return err == nil
+				}, time.Second)
+			}
+		}
+	default:
+		err = ErrInvalidDDLState
+		failNow = true
+	}
+	return
+}
+
+func (w *worker) onDropCollection(m *meta.Meta, job *model.Job) (schemaVersion int64, afterCommitFunc4Job func(), failNow bool, err error) {
+	arg := &model.DropCollectionArg{}
+	if err = job.DecodeArg(arg); err != nil {
+		job.State = model.JobStateCancelled
+		return
+	}
+
+	dbInfo, err := m.GetDatabase(arg.DBID)
+	if err != nil {
+		if err == meta.ErrDBNotExists {
+			err = ErrDBNotExists
+			failNow = true
+		}
+		return
+	}
+	ci := dbInfo.CollectionInfo(arg.Collection.Name)
+	if ci == nil || ci.ID != arg.Collection.ID {
+		err = ErrCollectionNotExists
+		failNow = true
+		return
+	}
+
+	job.RawArg = nil // will encode job.Arg into job.RawArg
+	switch ci.State {
+	case osc.StatePublic:
+		// public -> write only
+		ci.State = osc.StateWriteOnly
+		arg.Collection = ci
+		schemaVersion, err = updateSchemaVersionAndCollectionInfo(m, job, dbInfo, ci)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateWriteOnly
+	case osc.StateWriteOnly:
+		// write only -> delete only
+		ci.State = osc.StateDeleteOnly
+		arg.Collection = ci
+		schemaVersion, err = updateSchemaVersionAndCollectionInfo(m, job, dbInfo, ci)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateDeleteOnly
+
+		// no more inserts from now on
+		afterCommitFunc4Job = func() {
+			w.discardSequence(ci.ID)
+		}
+	case osc.StateDeleteOnly:
+		// delete only -> absent
+		err = m.DropCollection(dbInfo.ID, ci.ID, true)
+		if err != nil {
+			return
+		}
+		err = addDeleteRanges(m, ci.ID)
+		if err != nil {
+			return
+		}
+		dbInfo.RemoveCollectionInfo(ci.Name)
+		err = m.UpdateDatabase(dbInfo)
+		if err != nil {
+			return
+		}
+
+		ci.State = osc.StateAbsent
+		arg.Collection = ci
+		schemaVersion, err = updateSchemaVersion(m, job)
+		if err != nil {
+			return
+		}
+		job.FinishCollectionJob(model.JobStateDone, osc.StateAbsent, schemaVersion, ci)
+
+		afterCommitFunc4Job = func() {
+			// the sequence is recreated if restarted in delete only state
+			w.discardSequence(ci.ID)
+			w.d.gcWorker.Notify()
+		}
+	default:
+		err = ErrInvalidDDLState
+		failNow = true
+	}
+	return
+}
+
+func (w *worker) onDropSchema(m *meta.Meta, job *model.Job) (schemaVersion int64, afterCommitFunc4Job func(), failNow bool, err error) {
+	arg := &model.DBInfo{}
+	if err = job.DecodeArg(arg); err != nil {
+		job.State = model.JobStateCancelled
+		return
+	}
+
+	dbInfo, err := m.GetDatabase(arg.ID)
+	if err != nil {
+		if err == meta.ErrDBNotExists {
+			err = ErrDBNotExists
+			failNow = true
+		}
+		return
+	}
+
+	job.Arg = dbInfo
+	job.RawArg = nil // will encode job.Arg into job.RawArg
+	switch dbInfo.State {
+	case osc.StatePublic:
+		// public -> write only
+		schemaVersion, err = updateSchemaVersionAndDBState(m, job, dbInfo, osc.StateWriteOnly)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateWriteOnly
+	case osc.StateWriteOnly:
+		// write only -> delete only
+		schemaVersion, err = updateSchemaVersionAndDBState(m, job, dbInfo, osc.StateDeleteOnly)
+		if err != nil {
+			return
+		}
+		job.SchemaState = osc.StateDeleteOnly
+
+		// no more inserts from now on
+		afterCommitFunc4Job = func() {
+			for _, ci := range dbInfo.Collections {
+				w.discardSequence(ci.ID)
+			}
+		}
+	case osc.StateDeleteOnly:
+		// delete only -> absent
+		for _, ci := range dbInfo.Collections {
+			err = m.DropCollection(dbInfo.ID, ci.ID, true)
+			if err != nil {
+				return
+			}
+			err = addDeleteRanges(m, ci.ID)
+			if err != nil {
+				return
+			}
+		}
+		err = m.DropDatabase(dbInfo.ID)
+		if err != nil {
+			return
+		}
+
+		dbInfo.State = osc.StateAbsent
+		for _, ci := range dbInfo.Collections {
+			ci.State = osc.StateAbsent
+		}
+		schemaVersion, err = updateSchemaVersion(m, job)
+		if err != nil {
+			return
+		}
+		job.FinishDBJob(model.JobStateDone, osc.StateAbsent, schemaVersion, dbInfo)
+
+		afterCommitFunc4Job = func() {
+			for _, ci := range dbInfo.Collections {
+				// the sequence is recreated if restarted in delete only state
+				w.discardSequence(ci.ID)
+			}
+			w.d.gcWorker.Notify()
+		}
+	default:
+		err = ErrInvalidDDLState
+		failNow = true
+	}
+	return
+}
+
+func (w *worker) discardSequence(cid int64) {
+	err := dml.DiscardSequenceIfExists(cid)
+	if err != nil {
+		logger.Instance().Error("DiscardSequenceIfExists", zap.Int64("cid", cid), zap.Error(err))
+	}
+}
+
+// addDeleteRanges persists documents and index data of a dropped collection for gc worker
+func addDeleteRanges(m *meta.Meta, cid int64) (err error) {
+	for _, prefix := range [][]byte{dml.AppendCollectionDocumentPrefix(nil, cid), dml.AppendCollectionIndexDataPrefix(nil, cid)} {
+		err = m.AddDeleteRange(&model.DeleteRange{Prefix: prefix})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func updateSchemaVersionAndDBState(m *meta.Meta, job *model.Job, dbInfo *model.DBInfo, state osc.SchemaState) (schemaVersion int64, err error) {
+	dbInfo.State = state
+	for _, ci := range dbInfo.Collections {
+		ci.State = state
+		err = m.UpdateCollection(dbInfo.ID, ci)
+		if err != nil {
+			return
+		}
+	}
+	err = m.UpdateDatabase(dbInfo)
+	if err != nil {
+		return
+	}
+	schemaVersion, err = updateSchemaVersion(m, job)
+	return
+}
+
 func updateSchemaVersionAndCollectionInfo(m *meta.Meta, job *model.Job, dbInfo *model.DBInfo, ci *model.CollectionInfo) (schemaVersion int64, err error) {
 	err = m.UpdateCollection(dbInfo.ID, ci)
 	if err != nil {
@@ -373,6 +1010,7 @@ func updateSchemaVersion(m *meta.Meta, job *model.Job) (schemaVersion int64, err
 		CollectionIDs: job2CollectionIDs(job),
 		Arg:           job.Arg,
 		RawArg:        job.RawArg,
+		ArgVersion:    job.ArgVersion,
 	}
 
 	err = m.SetSchemaDiff(diff)
@@ -385,15 +1023,29 @@ func (d *DDL) checkJob(ctx context.Context, job *model.Job) (err error) {
 	ticker := time.NewTicker(util.ChooseTime(10*config.Load().Lease, checkJobMaxInterval(job.Type)))
 	defer ticker.Stop()
 
-	var historyJob *model.Job
+	var (
+		historyJob *model.Job
+		// in LocalSync mode history is checked right away, and again once jobs are moved into history
+		historyCh <-chan struct{}
+	)
+	if d.options.LocalSync {
+		checkNow := make(chan struct{})
+		close(checkNow)
+		historyCh = checkNow
+	}
 	for {
 		select {
 		case <-ticker.C:
+		case <-historyCh:
 		case <-ctx.Done():
 			err = ctx.Err()
 			return
 		}
 
+		if d.options.LocalSync {
+			// taken before the check so that a job moved after it is not missed
+			historyCh = d.historyChanged()
+		}
 		historyJob, err = d.GetHistoryJob(job.ID)
 		if err != nil {
 			logger.Instance().Error("GetHistoryJob", zap.Error(err))
@@ -404,6 +1056,7 @@ func (d *DDL) checkJob(ctx context.Context, job *model.Job) (err error) {
 		}
 
 		if historyJob.IsSynced() {
+			job.SchemaVersion = historyJob.SchemaVersion
 			return
 		}
 
@@ -420,10 +1073,11 @@ func (d *DDL) checkJob(ctx context.Context, job *model.Job) (err error) {
 func checkJobMaxInterval(jobTp model.ActionType) time.Duration {
 	// The job of adding index takes more time to process.
 	// So it uses the longer time.
-	if jobTp == model.ActionAddIndex {
+	if jobTp == model.ActionAddIndex || jobTp == model.ActionRebuildIndex || jobTp == model.ActionDropIndex {
 		return 3 * time.Second
 	}
-	if jobTp == model.ActionCreateCollection || jobTp == model.ActionCreateSchema {
+	switch jobTp {
+	case model.ActionCreateCollection, model.ActionCreateSchema, model.ActionDropCollection, model.ActionDropSchema:
 		return 500 * time.Millisecond
 	}
 	return 1 * time.Second
@@ -436,9 +1090,25 @@ func (d *DDL) notifyWorker(jobTp model.ActionType) {
 	}
 }
 
+func (d *DDL) notifyHistory() {
+	d.historyMu.Lock()
+	close(d.historyCh)
+	d.historyCh = make(chan struct{})
+	d.historyMu.Unlock()
+}
+
+// historyChanged returns a channel closed once jobs are moved into history
+func (d *DDL) historyChanged() (ch <-chan struct{}) {
+	d.historyMu.Lock()
+	ch = d.historyCh
+	d.historyMu.Unlock()
+	return
+}
+
 func (w *worker) waitSchemaChanged(schemaVersion int64, job *model.Job) {
 	lease := config.Load().Lease
-	if lease == 0 {
+	// schema not changed, e.g. a batch of reorganization
+	if lease == 0 || schemaVersion == 0 || w.d.options.LocalSync {
 		return
 	}
 
@@ -452,7 +1122,16 @@ func job2CollectionIDs(job *model.Job) (collectionIDs []int64) {
 		for _, c := range dbInfo.Collections {
 			collectionIDs = append(collectionIDs, c.ID)
 		}
-	case model.ActionAddIndex:
+	case model.ActionCreateCollection:
+		collectionIDs = []int64{job.Arg.(*model.CreateCollectionArg).Collection.ID}
+	case model.ActionDropSchema:
+		dbInfo := job.Arg.(*model.DBInfo)
+		for _, c := range dbInfo.Collections {
+			collectionIDs = append(collectionIDs, c.ID)
+		}
+	case model.ActionDropCollection:
+		collectionIDs = []int64{job.Arg.(*model.DropCollectionArg).Collection.ID}
+	case model.ActionAddIndex, model.ActionRebuildIndex, model.ActionDropIndex:
 		collectionIDs = []int64{job.Arg.(*model.IndexInfo).JobRedundant.CID}
 	default:
 	}
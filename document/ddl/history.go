@@ -0,0 +1,53 @@
+package ddl
+
+import (
+	"context"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/config"
+	"github.com/zhiqiangxu/mondis/document/meta"
+	"github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// PruneHistoryJobs deletes the oldest history jobs beyond the latest keepLast ones in a single txn,
+// it's run periodically by Start when config.Value.HistoryJobRetention is set.
+func (d *DDL) PruneHistoryJobs(keepLast int) (removed int, err error) {
+	err = util.RunInNewUpdateTxn(d.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		removed, err = meta.NewMeta(txn).PruneHistoryDDLJobs(keepLast)
+		return
+	})
+	return
+}
+
+// pruneHistoryJobsLoop prunes history jobs every config.Value.HistoryJobPruneInterval until ctx is done,
+// retention is read each time so that it can be changed at runtime.
+func (d *DDL) pruneHistoryJobsLoop(ctx context.Context) {
+	interval := config.Load().HistoryJobPruneInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		retention := config.Load().HistoryJobRetention
+		if retention <= 0 {
+			continue
+		}
+		removed, err := d.PruneHistoryJobs(retention)
+		if err != nil {
+			logger.Instance().Error("PruneHistoryJobs", zap.Error(err))
+		} else if removed > 0 {
+			logger.Instance().Info("PruneHistoryJobs", zap.Int("removed", removed))
+		}
+	}
+}
@@ -141,6 +141,30 @@ func (in *DropIndexInput) Validate() (err error) {
 	return
 }
 
+// RebuildIndexInput for RebuildIndex
+type RebuildIndexInput struct {
+	DB         string
+	Collection string
+	IndexName  string
+}
+
+// Validate RebuildIndexInput
+func (in *RebuildIndexInput) Validate() (err error) {
+	if in.DB == "" {
+		err = fmt.Errorf("db empty")
+		return
+	}
+	if in.Collection == "" {
+		err = fmt.Errorf("collection empty")
+		return
+	}
+	if in.IndexName == "" {
+		err = fmt.Errorf("index name empty")
+		return
+	}
+	return
+}
+
 // IndexInfo for ddl input
 // basically model.IndexInfo minus state
 type IndexInfo struct {
@@ -1,5 +1,7 @@
 package ddl
 
+import "time"
+
 // Callback when ddl happened
 type Callback struct {
 	OnChanged func(err error)
@@ -8,4 +10,11 @@ type Callback struct {
 // Options for ddl
 type Options struct {
 	Callback Callback
+	// LocalSync replaces the lease based waits by a notification once a job is synced,
+	// so that DDL APIs return as soon as the job is done. It's only correct when no other process
+	// caches the schema, e.g. in tests, since they are not waited for.
+	LocalSync bool
+	// ReorgInterval is the pause between two batches of reorganization like backfilling an index,
+	// which limits the load of a long job on the store.
+	ReorgInterval time.Duration
 }
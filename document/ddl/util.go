@@ -19,16 +19,9 @@ func checkDBNameNotExists(m *meta.Meta, dbName string) (exists bool, err error)
 }
 
 func getDbInfo(m *meta.Meta, dbName string) (dbInfo *model.DBInfo, err error) {
-	dbInfos, err := m.ListDatabases()
-	if err != nil {
-		return
-	}
-
-	for _, dbi := range dbInfos {
-		if dbi.Name == dbName {
-			dbInfo = dbi
-			return
-		}
+	dbInfo, err = m.GetDatabaseByName(dbName)
+	if err == meta.ErrDBNotExists {
+		err = nil
 	}
 	return
 }
@@ -50,17 +50,14 @@ func (c *Collection) InsertOne(doc interface{}, t *txn.Txn) (did int64, err erro
 		return
 	}
 
-	origT := t
-
 	insertFunc := func(t *txn.Txn) (ierr error) {
 		ci := t.StartMetaCache().CollectionInfo(c.dbName, c.collectionName)
 		if ci == nil {
-			err = ErrCollectionNotExists
+			ierr = ErrCollectionNotExists
 			return
 		}
-		if origT != nil {
-			origT.ReferredCollections(ci.ID)
-		}
+		// so that the commit fails if the collection is changed by ddl meanwhile
+		t.ReferredCollections(ci.ID)
 
 		seq := GetSequence(ci.ID)
 		if seq == nil {
@@ -73,10 +70,16 @@ func (c *Collection) InsertOne(doc interface{}, t *txn.Txn) (did int64, err erro
 			return
 		}
 
+		ierr = updateIndices(t, ci, did, nil, data)
+		if ierr != nil {
+			seq.PutBack(did)
+			return
+		}
+
 		docKey := EncodeCollectionDocumentKey(nil, ci.ID, did)
 
 		ierr = t.Set(docKey, data, nil)
-		if err != nil {
+		if ierr != nil {
 			return
 		}
 
@@ -118,8 +121,6 @@ func (c *Collection) UpsertOne(did int64, doc interface{}, t *txn.Txn) (isNew bo
 // DeleteOne for delete a document from collection
 func (c *Collection) DeleteOne(did int64, t *txn.Txn) (err error) {
 
-	origT := t
-
 	deleteFunc := func(t *txn.Txn) (err error) {
 		ci := t.StartMetaCache().CollectionInfo(c.dbName, c.collectionName)
 		if ci == nil {
@@ -127,11 +128,23 @@ func (c *Collection) DeleteOne(did int64, t *txn.Txn) (err error) {
 			return
 		}
 
-		if origT != nil {
-			origT.ReferredCollections(ci.ID)
-		}
+		t.ReferredCollections(ci.ID)
 
 		docKey := EncodeCollectionDocumentKey(nil, ci.ID, did)
+		oldData, _, err := t.Get(docKey)
+		if err == kv.ErrKeyNotFound {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		err = updateIndices(t, ci, did, oldData, nil)
+		if err != nil {
+			return
+		}
+
 		err = t.Delete(docKey)
 		return
 	}
@@ -156,8 +169,6 @@ func (c *Collection) updateOne(did int64, doc interface{}, updateFor int8, t *tx
 		return
 	}
 
-	origT := t
-
 	updateFunc := func(t *txn.Txn) (err error) {
 		ci := t.StartMetaCache().CollectionInfo(c.dbName, c.collectionName)
 		if ci == nil {
@@ -165,14 +176,17 @@ func (c *Collection) updateOne(did int64, doc interface{}, updateFor int8, t *tx
 			return
 		}
 
-		if origT != nil {
-			origT.ReferredCollections(ci.ID)
-		}
+		t.ReferredCollections(ci.ID)
 
 		docKey := EncodeCollectionDocumentKey(nil, ci.ID, did)
 
-		existsForUpdate, err = t.Exists(docKey)
-		if err != nil {
+		oldData, _, err := t.Get(docKey)
+		switch err {
+		case nil:
+			existsForUpdate = true
+		case kv.ErrKeyNotFound:
+			err = nil
+		default:
 			return
 		}
 
@@ -190,6 +204,11 @@ func (c *Collection) updateOne(did int64, doc interface{}, updateFor int8, t *tx
 			}
 		}
 
+		err = updateIndices(t, ci, did, oldData, data)
+		if err != nil {
+			return
+		}
+
 		err = t.Set(docKey, data, nil)
 		if err != nil {
 			return
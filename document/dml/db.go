@@ -20,6 +20,8 @@ var (
 	ErrCollectionNotExists = errors.New("collection not exists")
 	// ErrIndexNotExists used by Domain
 	ErrIndexNotExists = errors.New("index not exists")
+	// ErrIndexDropping when looking up an index by name that's being dropped
+	ErrIndexDropping = errors.New("index is being dropped")
 )
 
 // NewDB is ctor for DB
@@ -0,0 +1,125 @@
+package dml
+
+import (
+	"bytes"
+
+	"github.com/zhiqiangxu/mondis"
+	dbson "github.com/zhiqiangxu/mondis/document/bson"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/document/txn"
+	"github.com/zhiqiangxu/util/osc"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CountDistinct returns the number of distinct values of field among documents matching filter,
+// values are compared like index keys, e.g., 30 and 30.0 are the same, and a missing field counts as null.
+// filter only supports equality, dotted name means nested field.
+// When filter is empty and a public index is on field alone, only index keys are scanned.
+// Otherwise documents are scanned and the encoded distinct values are kept in memory,
+// which grows with the cardinality of field.
+func (c *Collection) CountDistinct(field string, filter bson.M, t *txn.Txn) (n int64, err error) {
+
+	origT := t
+
+	if t == nil {
+		t = c.Txn(false)
+		defer t.Discard()
+	}
+
+	ci := t.StartMetaCache().CollectionInfo(c.dbName, c.collectionName)
+	if ci == nil {
+		err = ErrCollectionNotExists
+		return
+	}
+
+	if origT != nil {
+		origT.ReferredCollections(ci.ID)
+	}
+
+	if len(filter) == 0 {
+		for _, iif := range ci.Indices {
+			if iif.State == osc.StatePublic && len(iif.Columns) == 1 && iif.Columns[0] == field {
+				n, err = countDistinctByIndex(t, ci.ID, iif)
+				return
+			}
+		}
+	}
+
+	n, err = countDistinctByScan(t, ci.ID, field, filter)
+	return
+}
+
+// countDistinctByIndex counts distinct values of the single column index iif,
+// entries of the same value are adjacent, so it takes constant memory.
+func countDistinctByIndex(t *txn.Txn, cid int64, iif *model.IndexInfo) (n int64, err error) {
+	prefix := AppendCollectionIndexPrefix(nil, cid, iif.ID)
+	var last []byte
+	err = t.Scan(mondis.ProviderScanOption{Prefix: prefix, KeysOnly: true}, func(key []byte, _ []byte, _ mondis.VMetaResp) bool {
+		if iif.Unique {
+			n++
+			return true
+		}
+
+		// strip did
+		value := key[len(prefix) : len(key)-8]
+		if n == 0 || !bytes.Equal(value, last) {
+			n++
+			last = append(last[:0], value...)
+		}
+		return true
+	})
+	return
+}
+
+func countDistinctByScan(t *txn.Txn, cid int64, field string, filter bson.M) (n int64, err error) {
+	columns := []string{field}
+	seen := make(map[string]struct{})
+	scanErr := t.Scan(mondis.ProviderScanOption{Prefix: AppendCollectionDocumentPrefix(nil, cid)}, func(_ []byte, value []byte, _ mondis.VMetaResp) bool {
+		var ok bool
+		ok, err = matchFilter(value, filter)
+		if err != nil || !ok {
+			return err == nil
+		}
+
+		var (
+			values  []interface{}
+			encoded []byte
+		)
+		values, err = indexValues(value, columns)
+		if err != nil {
+			return false
+		}
+		encoded, err = dbson.AppendIndexValue(nil, values[0], false)
+		if err != nil {
+			return false
+		}
+		seen[string(encoded)] = struct{}{}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	if scanErr != nil {
+		err = scanErr
+		return
+	}
+
+	n = int64(len(seen))
+	return
+}
+
+// matchFilter checks doc against filter of equality, a missing field equals null
+func matchFilter(doc bson.Raw, filter bson.M) (ok bool, err error) {
+	for field, expected := range filter {
+		var values []interface{}
+		values, err = indexValues(doc, []string{field})
+		if err != nil {
+			return
+		}
+		if dbson.Compare(values[0], expected) != 0 {
+			return
+		}
+	}
+	ok = true
+	return
+}
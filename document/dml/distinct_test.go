@@ -0,0 +1,95 @@
+package dml
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/document/schema"
+	"github.com/zhiqiangxu/mondis/provider"
+	"github.com/zhiqiangxu/util/osc"
+	"go.mongodb.org/mongo-driver/bson"
+	"gotest.tools/assert"
+)
+
+func TestCountDistinct(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mondis_dml")
+	assert.Assert(t, err == nil)
+	defer os.RemoveAll(dir)
+
+	kvdb := provider.NewBadger()
+	err = kvdb.Open(mondis.KVOption{Dir: dir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	const (
+		dbID      = 1
+		cid       = 2
+		emailIID  = 3
+		cityIID   = 4
+		bandwidth = 10
+	)
+	ci := &model.CollectionInfo{
+		ID:    cid,
+		Name:  "c",
+		State: osc.StatePublic,
+		Indices: map[string]*model.IndexInfo{
+			"email": {ID: emailIID, Name: "email", Columns: []string{"email"}, Unique: true, State: osc.StatePublic},
+			"city":  {ID: cityIID, Name: "city", Columns: []string{"addr.city"}, State: osc.StatePublic},
+		},
+	}
+	dbInfo := &model.DBInfo{ID: dbID, Name: "db", State: osc.StatePublic, Collections: map[string]*model.CollectionInfo{"c": ci}}
+	handle := schema.NewHandle()
+	err = handle.Update(context.Background(), schema.NewMetaCache(1, []*model.DBInfo{dbInfo}))
+	assert.Assert(t, err == nil)
+
+	err = CreateSequence(kvdb, dbID, cid, bandwidth)
+	assert.Assert(t, err == nil)
+	defer DropSequenceIfExists(cid)
+
+	db, err := NewDB("db", kvdb, handle)
+	assert.Assert(t, err == nil)
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	docs := []bson.M{
+		{"email": "a@x", "age": 30, "addr": bson.M{"city": "sh"}},
+		{"email": "b@x", "age": int64(30), "addr": bson.M{"city": "sh"}},
+		{"email": "c@x", "age": 30.0, "addr": bson.M{"city": "bj"}},
+		{"email": "d@x", "age": 40},
+		{"email": "e@x", "addr": bson.M{"city": "bj"}},
+		{"email": "f@x", "age": 40, "addr": bson.M{"city": "gz"}},
+	}
+	for _, doc := range docs {
+		_, err = c.InsertOne(doc, nil)
+		assert.Assert(t, err == nil)
+	}
+
+	// indexed
+	n, err := c.CountDistinct("email", nil, nil)
+	assert.Assert(t, err == nil && n == 6, n)
+	n, err = c.CountDistinct("addr.city", nil, nil)
+	// sh, bj, gz and null
+	assert.Assert(t, err == nil && n == 4, n)
+	// same as scan
+	txn := c.Txn(false)
+	n, err = countDistinctByScan(txn, cid, "addr.city", nil)
+	txn.Discard()
+	assert.Assert(t, err == nil && n == 4, n)
+
+	// scan, numbers of the same value are not distinct
+	n, err = c.CountDistinct("age", nil, nil)
+	// 30, 40 and null
+	assert.Assert(t, err == nil && n == 3, n)
+	n, err = c.CountDistinct("age", bson.M{"addr.city": "sh"}, nil)
+	assert.Assert(t, err == nil && n == 1, n)
+	n, err = c.CountDistinct("addr.city", bson.M{"age": 40}, nil)
+	assert.Assert(t, err == nil && n == 2, n)
+	n, err = c.CountDistinct("email", bson.M{"age": nil}, nil)
+	assert.Assert(t, err == nil && n == 1, n)
+	n, err = c.CountDistinct("email", bson.M{"age": 50}, nil)
+	assert.Assert(t, err == nil && n == 0, n)
+}
@@ -3,6 +3,7 @@ package dml
 import (
 	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/document/schema"
+	"github.com/zhiqiangxu/mondis/document/txn"
 )
 
 // Index model
@@ -17,7 +18,9 @@ func newIndex(dbName, collectionName, indexName string, kvdb mondis.KVDB, handle
 	return &Index{dbName: dbName, collectionName: collectionName, indexName: indexName, base: base{kvdb: kvdb, handle: handle}}
 }
 
-// Lookup by index
-func (idx *Index) Lookup() {
-
+// Lookup by index, see Collection.FindByIndex
+func (idx *Index) Lookup(value interface{}, t *txn.Txn) (dids []int64, err error) {
+	c := newCollection(idx.dbName, idx.collectionName, idx.kvdb, idx.handle)
+	dids, err = c.FindByIndex(idx.indexName, value, t)
+	return
 }
@@ -0,0 +1,339 @@
+package dml
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/document/txn"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/memcomparable"
+	"github.com/zhiqiangxu/util/osc"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	// ErrUniqueViolated when the value of a unique index already maps to another document
+	ErrUniqueViolated = errors.New("unique index violated")
+	// ErrIndexValuesMismatch when the number of values doesn't match the columns of index
+	ErrIndexValuesMismatch = errors.New("index values mismatch")
+)
+
+// indexWritable returns whether entries should be added to index in state
+func indexWritable(state osc.SchemaState) bool {
+	switch state {
+	case osc.StateWriteOnly, osc.StateWriteReorganization, osc.StatePublic:
+		return true
+	default:
+		return false
+	}
+}
+
+// indexDeletable returns whether entries should be deleted from index in state
+func indexDeletable(state osc.SchemaState) bool {
+	return state != osc.StateAbsent
+}
+
+// indexValues extracts values of columns from doc, dotted column means nested field,
+// missing field is indexed as null.
+func indexValues(doc bson.Raw, columns []string) (values []interface{}, err error) {
+	values = make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		var v interface{}
+		rv, lookupErr := doc.LookupErr(strings.Split(column, ".")...)
+		if lookupErr == nil {
+			err = rv.Unmarshal(&v)
+			if err != nil {
+				return
+			}
+		}
+		values = append(values, v)
+	}
+	return
+}
+
+// encodeIndexKey returns the index key of doc, did is appended for non unique index
+func encodeIndexKey(cid int64, iif *model.IndexInfo, doc bson.Raw, did int64) (key kv.Key, err error) {
+	values, err := indexValues(doc, iif.Columns)
+	if err != nil {
+		return
+	}
+
+	key, err = EncodeCollectionIndexKey(nil, cid, iif.ID, values)
+	if err != nil {
+		return
+	}
+	if !iif.Unique {
+		key = memcomparable.EncodeInt64(key, did)
+	}
+	return
+}
+
+// updateIndices replaces index entries of oldDoc with those of newDoc for document did,
+// oldDoc is nil for insert and newDoc is nil for delete.
+// indexes not public yet are maintained following the online schema change rules.
+func updateIndices(t mondis.ProviderTxn, ci *model.CollectionInfo, did int64, oldDoc, newDoc bson.Raw) (err error) {
+	for _, iif := range ci.Indices {
+		var oldKey, newKey kv.Key
+		if oldDoc != nil && indexDeletable(iif.State) {
+			oldKey, err = encodeIndexKey(ci.ID, iif, oldDoc, did)
+			if err != nil {
+				return
+			}
+		}
+		if newDoc != nil && indexWritable(iif.State) {
+			newKey, err = encodeIndexKey(ci.ID, iif, newDoc, did)
+			if err != nil {
+				return
+			}
+		}
+
+		if oldKey != nil && newKey != nil && bytes.Equal(oldKey, newKey) {
+			continue
+		}
+
+		if oldKey != nil {
+			err = deleteIndexEntry(t, iif, oldKey, did)
+			if err != nil {
+				return
+			}
+		}
+		if newKey != nil {
+			err = addIndexEntry(t, iif, newKey, did)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func addIndexEntry(t mondis.ProviderTxn, iif *model.IndexInfo, key kv.Key, did int64) (err error) {
+	if !iif.Unique {
+		err = t.Set(key, nil, nil)
+		return
+	}
+
+	v, _, err := t.Get(key)
+	switch err {
+	case nil:
+		var existingDid int64
+		_, existingDid, err = memcomparable.DecodeInt64(v)
+		if err != nil {
+			return
+		}
+		if existingDid != did {
+			err = ErrUniqueViolated
+		}
+		return
+	case kv.ErrKeyNotFound:
+		err = t.Set(key, memcomparable.EncodeInt64(nil, did), nil)
+		return
+	default:
+		return
+	}
+}
+
+func deleteIndexEntry(t mondis.ProviderTxn, iif *model.IndexInfo, key kv.Key, did int64) (err error) {
+	if iif.Unique {
+		// only delete the entry if it still maps to did
+		var v []byte
+		v, _, err = t.Get(key)
+		if err == kv.ErrKeyNotFound {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		var existingDid int64
+		_, existingDid, err = memcomparable.DecodeInt64(v)
+		if err != nil || existingDid != did {
+			return
+		}
+	}
+
+	err = t.Delete(key)
+	return
+}
+
+// BackfillIndex writes entries of index iif for at most batchSize documents of collection cid,
+// starting from offset, which is the document prefix of cid or a document key of cid.
+// It returns the last handled did and the number of documents handled,
+// or the did of the offending document on ErrUniqueViolated.
+func BackfillIndex(t mondis.ProviderTxn, cid int64, iif *model.IndexInfo, offset kv.Key, batchSize int) (lastDid int64, n int, err error) {
+	type doc struct {
+		did  int64
+		data []byte
+	}
+
+	// collect first since writes are not allowed while iterating
+	docs := make([]doc, 0, batchSize)
+	scanErr := t.Scan(mondis.ProviderScanOption{Prefix: AppendCollectionDocumentPrefix(nil, cid), Offset: offset}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		var did int64
+		_, did, err = DecodeCollectionDocumentKey(key)
+		if err != nil {
+			return false
+		}
+		docs = append(docs, doc{did: did, data: append([]byte(nil), value...)})
+		return len(docs) < batchSize
+	})
+	if err != nil {
+		return
+	}
+	if scanErr != nil {
+		err = scanErr
+		return
+	}
+
+	var key kv.Key
+	for _, d := range docs {
+		lastDid = d.did
+		key, err = encodeIndexKey(cid, iif, d.data, d.did)
+		if err != nil {
+			return
+		}
+		err = addIndexEntry(t, iif, key, d.did)
+		if err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// ClearIndex deletes at most batchSize entries of index iid of collection cid,
+// it returns the number of entries deleted.
+func ClearIndex(t mondis.ProviderTxn, cid, iid int64, batchSize int) (n int, err error) {
+	// collect first since writes are not allowed while iterating
+	keys := make([]kv.Key, 0, batchSize)
+	err = t.Scan(mondis.ProviderScanOption{Prefix: AppendCollectionIndexPrefix(nil, cid, iid), KeysOnly: true}, func(key []byte, _ []byte, _ mondis.VMetaResp) bool {
+		keys = append(keys, append(kv.Key(nil), key...))
+		return len(keys) < batchSize
+	})
+	if err != nil {
+		return
+	}
+
+	for _, key := range keys {
+		err = t.Delete(key)
+		if err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// FindByIndex returns ids of documents whose indexed columns equal value by a lookup of the public index indexName, in did order,
+// ErrIndexDropping is returned once indexName is made unusable by ActionDropIndex.
+// value is the value of the only column, or []interface{} of values in the order of columns,
+// so an array value of a single column index should be wrapped in []interface{}.
+func (c *Collection) FindByIndex(indexName string, value interface{}, t *txn.Txn) (dids []int64, err error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	dids, err = c.lookupIndex(func(iif *model.IndexInfo) bool {
+		return iif.Name == indexName
+	}, values, t)
+	return
+}
+
+// getByIndex returns ids of documents whose indexed columns equal values, in the order of columns
+func (c *Collection) getByIndex(indexID int64, values []interface{}, t *txn.Txn) (dids []int64, err error) {
+	dids, err = c.lookupIndex(func(iif *model.IndexInfo) bool {
+		return iif.ID == indexID
+	}, values, t)
+	return
+}
+
+// lookupIndex returns ids of documents whose indexed columns equal values by the public index matched
+func (c *Collection) lookupIndex(match func(*model.IndexInfo) bool, values []interface{}, t *txn.Txn) (dids []int64, err error) {
+
+	origT := t
+
+	if t == nil {
+		t = c.Txn(false)
+		defer t.Discard()
+	}
+
+	ci := t.StartMetaCache().CollectionInfo(c.dbName, c.collectionName)
+	if ci == nil {
+		err = ErrCollectionNotExists
+		return
+	}
+
+	if origT != nil {
+		origT.ReferredCollections(ci.ID)
+	}
+
+	var iif *model.IndexInfo
+	for _, ii := range ci.Indices {
+		if !match(ii) {
+			continue
+		}
+		if ii.State == osc.StatePublic {
+			iif = ii
+			break
+		}
+		if ii.Dropping {
+			err = ErrIndexDropping
+			return
+		}
+	}
+	if iif == nil {
+		err = ErrIndexNotExists
+		return
+	}
+	if len(values) != len(iif.Columns) {
+		err = ErrIndexValuesMismatch
+		return
+	}
+
+	key, err := EncodeCollectionIndexKey(nil, ci.ID, iif.ID, values)
+	if err != nil {
+		return
+	}
+
+	if iif.Unique {
+		var v []byte
+		v, _, err = t.Get(key)
+		if err == kv.ErrKeyNotFound {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		var did int64
+		_, did, err = memcomparable.DecodeInt64(v)
+		if err != nil {
+			return
+		}
+		dids = append(dids, did)
+		return
+	}
+
+	scanErr := t.Scan(mondis.ProviderScanOption{Prefix: key, KeysOnly: true}, func(k []byte, _ []byte, _ mondis.VMetaResp) bool {
+		var did int64
+		_, did, err = memcomparable.DecodeInt64(k[len(key):])
+		if err != nil {
+			return false
+		}
+		dids = append(dids, did)
+		return true
+	})
+	if err != nil {
+		return
+	}
+	if scanErr != nil {
+		err = scanErr
+		return
+	}
+
+	return
+}
@@ -0,0 +1,134 @@
+package dml
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/document/schema"
+	"github.com/zhiqiangxu/mondis/provider"
+	"github.com/zhiqiangxu/util/osc"
+	"go.mongodb.org/mongo-driver/bson"
+	"gotest.tools/assert"
+)
+
+func TestIndexMaintenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mondis_dml")
+	assert.Assert(t, err == nil)
+	defer os.RemoveAll(dir)
+
+	kvdb := provider.NewBadger()
+	err = kvdb.Open(mondis.KVOption{Dir: dir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	const (
+		dbID      = 1
+		cid       = 2
+		emailIID  = 3
+		ageIID    = 4
+		cityIID   = 5
+		bandwidth = 10
+	)
+	ci := &model.CollectionInfo{
+		ID:    cid,
+		Name:  "c",
+		State: osc.StatePublic,
+		Indices: map[string]*model.IndexInfo{
+			"email": {ID: emailIID, Name: "email", Columns: []string{"email"}, Unique: true, State: osc.StatePublic},
+			"age":   {ID: ageIID, Name: "age", Columns: []string{"age"}, State: osc.StatePublic},
+			"city":  {ID: cityIID, Name: "city", Columns: []string{"addr.city", "age"}, State: osc.StatePublic},
+		},
+	}
+	dbInfo := &model.DBInfo{ID: dbID, Name: "db", State: osc.StatePublic, Collections: map[string]*model.CollectionInfo{"c": ci}}
+	handle := schema.NewHandle()
+	err = handle.Update(context.Background(), schema.NewMetaCache(1, []*model.DBInfo{dbInfo}))
+	assert.Assert(t, err == nil)
+
+	err = CreateSequence(kvdb, dbID, cid, bandwidth)
+	assert.Assert(t, err == nil)
+	defer DropSequenceIfExists(cid)
+
+	db, err := NewDB("db", kvdb, handle)
+	assert.Assert(t, err == nil)
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	did1, err := c.InsertOne(bson.M{"email": "a@x", "age": 30, "addr": bson.M{"city": "sh"}}, nil)
+	assert.Assert(t, err == nil)
+	did2, err := c.InsertOne(bson.M{"email": "b@x", "age": int64(30)}, nil)
+	assert.Assert(t, err == nil)
+
+	dids, err := c.getByIndex(emailIID, []interface{}{"a@x"}, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did1)
+	dids, err = c.getByIndex(ageIID, []interface{}{30.0}, nil)
+	assert.Assert(t, err == nil && len(dids) == 2 && dids[0] == did1 && dids[1] == did2)
+	dids, err = c.getByIndex(cityIID, []interface{}{"sh", 30}, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did1)
+	// missing field is indexed as null
+	dids, err = c.getByIndex(cityIID, []interface{}{nil, 30}, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did2)
+	_, err = c.getByIndex(cityIID, []interface{}{"sh"}, nil)
+	assert.Assert(t, err == ErrIndexValuesMismatch)
+	_, err = c.getByIndex(100, []interface{}{"sh"}, nil)
+	assert.Assert(t, err == ErrIndexNotExists)
+
+	// unique violation leaves nothing behind
+	_, err = c.InsertOne(bson.M{"email": "a@x", "age": 40}, nil)
+	assert.Assert(t, err == ErrUniqueViolated)
+	dids, err = c.getByIndex(ageIID, []interface{}{40}, nil)
+	assert.Assert(t, err == nil && len(dids) == 0)
+	_, err = c.UpdateOne(did2, bson.M{"email": "a@x"}, nil)
+	assert.Assert(t, err == ErrUniqueViolated)
+
+	// update removes stale entries
+	exists, err := c.UpdateOne(did1, bson.M{"email": "c@x", "age": 31}, nil)
+	assert.Assert(t, err == nil && exists)
+	dids, err = c.getByIndex(emailIID, []interface{}{"a@x"}, nil)
+	assert.Assert(t, err == nil && len(dids) == 0)
+	dids, err = c.getByIndex(emailIID, []interface{}{"c@x"}, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did1)
+	dids, err = c.getByIndex(ageIID, []interface{}{30}, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did2)
+	dids, err = c.getByIndex(cityIID, []interface{}{"sh", 30}, nil)
+	assert.Assert(t, err == nil && len(dids) == 0)
+
+	// the released value can be taken by another document
+	isNew, err := c.UpsertOne(did1+100, bson.M{"email": "a@x", "age": 30}, nil)
+	assert.Assert(t, err == nil && isNew)
+	dids, err = c.getByIndex(emailIID, []interface{}{"a@x"}, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did1+100)
+
+	// delete removes entries
+	err = c.DeleteOne(did2, nil)
+	assert.Assert(t, err == nil)
+	dids, err = c.getByIndex(emailIID, []interface{}{"b@x"}, nil)
+	assert.Assert(t, err == nil && len(dids) == 0)
+	dids, err = c.getByIndex(ageIID, []interface{}{30}, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did1+100)
+	err = c.DeleteOne(did2, nil)
+	assert.Assert(t, err == nil)
+
+	// entries of the same document and value are kept across updates
+	_, err = c.UpdateOne(did1+100, bson.M{"email": "a@x", "age": 30, "other": 1}, nil)
+	assert.Assert(t, err == nil)
+	dids, err = c.getByIndex(emailIID, []interface{}{"a@x"}, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did1+100)
+
+	// lookup by name
+	dids, err = c.FindByIndex("email", "a@x", nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did1+100)
+	dids, err = c.FindByIndex("city", []interface{}{nil, 30}, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did1+100)
+	_, err = c.FindByIndex("city", "sh", nil)
+	assert.Assert(t, err == ErrIndexValuesMismatch)
+	_, err = c.FindByIndex("none", "sh", nil)
+	assert.Assert(t, err == ErrIndexNotExists)
+	idx, err := c.Index("age")
+	assert.Assert(t, err == nil)
+	dids, err = idx.Lookup(30, nil)
+	assert.Assert(t, err == nil && len(dids) == 1 && dids[0] == did1+100)
+}
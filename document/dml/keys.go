@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 
+	dbson "github.com/zhiqiangxu/mondis/document/bson"
 	"github.com/zhiqiangxu/mondis/document/keyspace"
 	"github.com/zhiqiangxu/mondis/kv"
 	"github.com/zhiqiangxu/mondis/kv/memcomparable"
@@ -73,6 +74,27 @@ func AppendCollectionIndexDataPrefix(buf []byte, cid int64) kv.Key {
 	return buf
 }
 
+// AppendCollectionIndexPrefix appends c[cid]_id[iid] to buf
+func AppendCollectionIndexPrefix(buf []byte, cid, iid int64) kv.Key {
+	buf = AppendCollectionIndexDataPrefix(buf, cid)
+	buf = memcomparable.EncodeInt64(buf, iid)
+	return buf
+}
+
+// EncodeCollectionIndexKey returns c[cid]_id[iid][values], the encoded values are prefix free,
+// so for non unique index, did is appended to make the key unique.
+func EncodeCollectionIndexKey(buf []byte, cid, iid int64, values []interface{}) (key kv.Key, err error) {
+	buf = AppendCollectionIndexPrefix(buf, cid, iid)
+	for _, v := range values {
+		buf, err = dbson.AppendIndexValue(buf, v, false)
+		if err != nil {
+			return
+		}
+	}
+	key = buf
+	return
+}
+
 // EncodeMetaSequenceKey returns m_s[keyword]
 func EncodeMetaSequenceKey(buf, keyword []byte) kv.Key {
 	if buf == nil {
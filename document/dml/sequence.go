@@ -73,3 +73,17 @@ func DropSequenceIfExists(cid int64) (err error) {
 	err = v.(*sequence.Hash).Close(config.Load().Lease == 0)
 	return
 }
+
+// DiscardSequenceIfExists is like DropSequenceIfExists but never releases the remaining ids,
+// it's used when the collection is dropped.
+func DiscardSequenceIfExists(cid int64) (err error) {
+	v, exists := sequenceMap.Load(cid)
+	if !exists {
+		return
+	}
+
+	sequenceMap.Delete(cid)
+
+	err = v.(*sequence.Hash).Close(false)
+	return
+}
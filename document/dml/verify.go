@@ -0,0 +1,340 @@
+package dml
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/document/txn"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/memcomparable"
+	"github.com/zhiqiangxu/util/osc"
+)
+
+const (
+	defaultVerifyChunkSize  = 256
+	defaultVerifyMaxSamples = 10
+)
+
+type (
+	// VerifyOption for VerifyIndex
+	VerifyOption struct {
+		// ChunkSize is the max number of documents or entries checked in one read txn, defaults to 256
+		ChunkSize int
+		// Rate is the max number of documents and entries checked per second, 0 means unlimited
+		Rate int
+		// MaxSamples is the max number of problems kept in IndexReport.Samples, defaults to 10
+		MaxSamples int
+	}
+	// IndexProblemKind is the kind of an inconsistency found by VerifyIndex
+	IndexProblemKind int
+	// IndexProblem is an inconsistency found by VerifyIndex
+	IndexProblem struct {
+		Kind IndexProblemKind
+		Did  int64
+		Key  kv.Key
+	}
+	// IndexReport is the result of VerifyIndex
+	IndexReport struct {
+		// Docs is the number of documents checked
+		Docs int
+		// Entries is the number of index entries checked
+		Entries int
+		// Missing is the number of documents without their entry
+		Missing int
+		// Extra is the number of entries whose document doesn't exist
+		Extra int
+		// Mismatched is the number of entries whose document indexes to another key
+		Mismatched int
+		// Samples of problems found, at most VerifyOption.MaxSamples
+		Samples []IndexProblem
+	}
+)
+
+const (
+	// IndexProblemMissing for a document without its entry
+	IndexProblemMissing IndexProblemKind = iota
+	// IndexProblemExtra for an entry whose document doesn't exist
+	IndexProblemExtra
+	// IndexProblemMismatched for an entry whose document indexes to another key
+	IndexProblemMismatched
+)
+
+func (k IndexProblemKind) String() string {
+	switch k {
+	case IndexProblemMissing:
+		return "missing"
+	case IndexProblemExtra:
+		return "extra"
+	case IndexProblemMismatched:
+		return "mismatched"
+	default:
+		return "unknown"
+	}
+}
+
+// Clean returns whether no inconsistency is found
+func (r *IndexReport) Clean() bool {
+	return r.Missing == 0 && r.Extra == 0 && r.Mismatched == 0
+}
+
+func (r *IndexReport) add(p IndexProblem, maxSamples int) {
+	switch p.Kind {
+	case IndexProblemMissing:
+		r.Missing++
+	case IndexProblemExtra:
+		r.Extra++
+	case IndexProblemMismatched:
+		r.Mismatched++
+	}
+	if len(r.Samples) < maxSamples {
+		r.Samples = append(r.Samples, p)
+	}
+}
+
+// indexVerifier checks a public index chunk by chunk, each chunk in its own read txn
+type indexVerifier struct {
+	c      *Collection
+	opt    VerifyOption
+	cid    int64
+	iif    *model.IndexInfo
+	report IndexReport
+}
+
+// VerifyIndex checks index name against documents of collection, it returns ErrIndexNotExists if name is not public.
+// Entries are ordered by the indexed values rather than did, so instead of merging the two streams,
+// documents are scanned in did order with a lookup of their entry, then entries are scanned with a lookup of their document.
+// Each lookup is in the same txn as the scan, so writes meanwhile don't cause false reports,
+// and memory is bounded by VerifyOption.ChunkSize. ctx cancels it between chunks.
+func (c *Collection) VerifyIndex(ctx context.Context, name string, opt VerifyOption) (report IndexReport, err error) {
+	if opt.ChunkSize <= 0 {
+		opt.ChunkSize = defaultVerifyChunkSize
+	}
+	if opt.MaxSamples <= 0 {
+		opt.MaxSamples = defaultVerifyMaxSamples
+	}
+
+	v := &indexVerifier{c: c, opt: opt}
+	err = c.RunInNewTxn(func(t *txn.Txn) (err error) {
+		v.cid, v.iif, err = v.publicIndex(t, name)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	err = v.run(ctx, v.verifyDocs)
+	if err != nil {
+		return
+	}
+	err = v.run(ctx, v.verifyEntries)
+	if err != nil {
+		return
+	}
+
+	report = v.report
+	return
+}
+
+// publicIndex returns the index by name, which must be public
+func (v *indexVerifier) publicIndex(t *txn.Txn, name string) (cid int64, iif *model.IndexInfo, err error) {
+	ci := t.StartMetaCache().CollectionInfo(v.c.dbName, v.c.collectionName)
+	if ci == nil {
+		err = ErrCollectionNotExists
+		return
+	}
+	iif = ci.IndexInfo(name)
+	if iif == nil || iif.State != osc.StatePublic {
+		err = ErrIndexNotExists
+		return
+	}
+	cid = ci.ID
+	return
+}
+
+// run calls chunk in a new read txn until it reports the end, each chunk returns the offset of the next one
+func (v *indexVerifier) run(ctx context.Context, chunk func(*txn.Txn, kv.Key) (kv.Key, int, error)) (err error) {
+	var (
+		offset kv.Key
+		n      int
+	)
+	for {
+		start := time.Now()
+		err = v.c.RunInNewTxn(func(t *txn.Txn) (err error) {
+			// the index may be dropped or rebuilt meanwhile
+			cid, iif, err := v.publicIndex(t, v.iif.Name)
+			if err != nil {
+				return
+			}
+			if cid != v.cid || iif.ID != v.iif.ID {
+				err = ErrIndexNotExists
+				return
+			}
+			offset, n, err = chunk(t, offset)
+			return
+		})
+		if err != nil || n < v.opt.ChunkSize {
+			return
+		}
+
+		err = v.throttle(ctx, n, time.Since(start))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// throttle waits until n checks fit in VerifyOption.Rate, or ctx is done
+func (v *indexVerifier) throttle(ctx context.Context, n int, elapsed time.Duration) (err error) {
+	var wait time.Duration
+	if v.opt.Rate > 0 {
+		wait = time.Duration(n)*time.Second/time.Duration(v.opt.Rate) - elapsed
+	}
+	if wait <= 0 {
+		err = ctx.Err()
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// verifyDocs checks a chunk of documents starting from offset, nil offset means the first document
+func (v *indexVerifier) verifyDocs(t *txn.Txn, offset kv.Key) (next kv.Key, n int, err error) {
+	prefix := AppendCollectionDocumentPrefix(nil, v.cid)
+	if offset == nil {
+		offset = prefix
+	}
+
+	var lastDid int64
+	scanErr := t.Scan(mondis.ProviderScanOption{Prefix: prefix, Offset: offset}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		_, lastDid, err = DecodeCollectionDocumentKey(key)
+		if err != nil {
+			return false
+		}
+		n++
+		v.report.Docs++
+
+		var (
+			expected kv.Key
+			ok       bool
+		)
+		expected, err = encodeIndexKey(v.cid, v.iif, value, lastDid)
+		if err != nil {
+			return false
+		}
+		ok, err = v.hasEntry(t, expected, lastDid)
+		if err != nil {
+			return false
+		}
+		if !ok {
+			v.report.add(IndexProblem{Kind: IndexProblemMissing, Did: lastDid, Key: expected}, v.opt.MaxSamples)
+		}
+		return n < v.opt.ChunkSize
+	})
+	if err != nil {
+		return
+	}
+	if scanErr != nil {
+		err = scanErr
+		return
+	}
+
+	next = EncodeCollectionDocumentKey(nil, v.cid, lastDid+1)
+	return
+}
+
+// hasEntry returns whether entry key exists and maps to did
+func (v *indexVerifier) hasEntry(t *txn.Txn, key kv.Key, did int64) (ok bool, err error) {
+	if !v.iif.Unique {
+		ok, err = t.Exists(key)
+		return
+	}
+
+	value, _, err := t.Get(key)
+	if err == kv.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	_, existingDid, decodeErr := memcomparable.DecodeInt64(value)
+	ok = decodeErr == nil && existingDid == did
+	return
+}
+
+// verifyEntries checks a chunk of entries starting from offset, nil offset means the first entry
+func (v *indexVerifier) verifyEntries(t *txn.Txn, offset kv.Key) (next kv.Key, n int, err error) {
+	prefix := AppendCollectionIndexPrefix(nil, v.cid, v.iif.ID)
+	if offset == nil {
+		offset = prefix
+	}
+
+	var lastKey kv.Key
+	scanErr := t.Scan(mondis.ProviderScanOption{Prefix: prefix, Offset: offset, KeysOnly: !v.iif.Unique}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		lastKey = append(lastKey[:0], key...)
+		n++
+		v.report.Entries++
+
+		err = v.verifyEntry(t, lastKey, value)
+		return err == nil && n < v.opt.ChunkSize
+	})
+	if err != nil {
+		return
+	}
+	if scanErr != nil {
+		err = scanErr
+		return
+	}
+
+	// the smallest key after lastKey
+	next = append(lastKey, 0)
+	return
+}
+
+func (v *indexVerifier) verifyEntry(t *txn.Txn, key kv.Key, value []byte) (err error) {
+	var (
+		did       int64
+		decodeErr error
+	)
+	if v.iif.Unique {
+		_, did, decodeErr = memcomparable.DecodeInt64(value)
+	} else if len(key) < 8 {
+		decodeErr = memcomparable.ErrInsufficientBytesToDecode
+	} else {
+		_, did, decodeErr = memcomparable.DecodeInt64(key[len(key)-8:])
+	}
+	if decodeErr != nil {
+		// an entry that can't map to any document
+		v.report.add(IndexProblem{Kind: IndexProblemExtra, Key: append(kv.Key(nil), key...)}, v.opt.MaxSamples)
+		return
+	}
+
+	doc, _, err := t.Get(EncodeCollectionDocumentKey(nil, v.cid, did))
+	if err == kv.ErrKeyNotFound {
+		err = nil
+		v.report.add(IndexProblem{Kind: IndexProblemExtra, Did: did, Key: append(kv.Key(nil), key...)}, v.opt.MaxSamples)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	expected, err := encodeIndexKey(v.cid, v.iif, doc, did)
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(expected, key) {
+		v.report.add(IndexProblem{Kind: IndexProblemMismatched, Did: did, Key: append(kv.Key(nil), key...)}, v.opt.MaxSamples)
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package dml
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/document/schema"
+	"github.com/zhiqiangxu/mondis/kv/memcomparable"
+	"github.com/zhiqiangxu/mondis/provider"
+	"github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/util/osc"
+	"go.mongodb.org/mongo-driver/bson"
+	"gotest.tools/assert"
+)
+
+func TestVerifyIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mondis_dml")
+	assert.Assert(t, err == nil)
+	defer os.RemoveAll(dir)
+
+	kvdb := provider.NewBadger()
+	err = kvdb.Open(mondis.KVOption{Dir: dir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	const (
+		dbID      = 1
+		cid       = 2
+		emailIID  = 3
+		ageIID    = 4
+		bandwidth = 10
+	)
+	ci := &model.CollectionInfo{
+		ID:    cid,
+		Name:  "c",
+		State: osc.StatePublic,
+		Indices: map[string]*model.IndexInfo{
+			"email": {ID: emailIID, Name: "email", Columns: []string{"email"}, Unique: true, State: osc.StatePublic},
+			"age":   {ID: ageIID, Name: "age", Columns: []string{"age"}, State: osc.StatePublic},
+		},
+	}
+	dbInfo := &model.DBInfo{ID: dbID, Name: "db", State: osc.StatePublic, Collections: map[string]*model.CollectionInfo{"c": ci}}
+	handle := schema.NewHandle()
+	err = handle.Update(context.Background(), schema.NewMetaCache(1, []*model.DBInfo{dbInfo}))
+	assert.Assert(t, err == nil)
+
+	err = CreateSequence(kvdb, dbID, cid, bandwidth)
+	assert.Assert(t, err == nil)
+	defer DropSequenceIfExists(cid)
+
+	db, err := NewDB("db", kvdb, handle)
+	assert.Assert(t, err == nil)
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	const n = 5
+	var dids []int64
+	for i := 0; i < n; i++ {
+		did, err := c.InsertOne(bson.M{"email": string(rune('a'+i)) + "@x", "age": 30 + i}, nil)
+		assert.Assert(t, err == nil)
+		dids = append(dids, did)
+	}
+
+	// small chunks so that verify resumes across txns
+	opt := VerifyOption{ChunkSize: 2}
+	for _, name := range []string{"email", "age"} {
+		report, err := c.VerifyIndex(context.Background(), name, opt)
+		assert.Assert(t, err == nil && report.Clean() && report.Docs == n && report.Entries == n, report)
+	}
+	_, err = c.VerifyIndex(context.Background(), "none", opt)
+	assert.Assert(t, err == ErrIndexNotExists)
+
+	ageKey := func(age int, did int64) []byte {
+		key, err := EncodeCollectionIndexKey(nil, cid, ageIID, []interface{}{age})
+		assert.Assert(t, err == nil)
+		return memcomparable.EncodeInt64(key, did)
+	}
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		// missing
+		err = txn.Delete(ageKey(30, dids[0]))
+		if err != nil {
+			return
+		}
+		// extra
+		err = txn.Set(ageKey(30, dids[n-1]+100), nil, nil)
+		if err != nil {
+			return
+		}
+		// mismatched, the entry of the actual value is kept
+		err = txn.Set(ageKey(99, dids[1]), nil, nil)
+		return
+	})
+	assert.Assert(t, err == nil)
+
+	report, err := c.VerifyIndex(context.Background(), "age", opt)
+	assert.Assert(t, err == nil, err)
+	assert.Assert(t, report.Docs == n && report.Entries == n+1 && report.Missing == 1 && report.Extra == 1 && report.Mismatched == 1, report)
+	assert.Assert(t, len(report.Samples) == 3)
+	report, err = c.VerifyIndex(context.Background(), "age", VerifyOption{MaxSamples: 1})
+	assert.Assert(t, err == nil && len(report.Samples) == 1)
+
+	// a unique entry taken by another document is missing for one and mismatched for the other
+	emailKey, err := EncodeCollectionIndexKey(nil, cid, emailIID, []interface{}{"a@x"})
+	assert.Assert(t, err == nil)
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) error {
+		return txn.Set(emailKey, memcomparable.EncodeInt64(nil, dids[2]), nil)
+	})
+	assert.Assert(t, err == nil)
+	report, err = c.VerifyIndex(context.Background(), "email", opt)
+	assert.Assert(t, err == nil && report.Missing == 1 && report.Extra == 0 && report.Mismatched == 1, report)
+
+	// cancelled while throttled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.VerifyIndex(ctx, "age", VerifyOption{ChunkSize: 1, Rate: 1})
+	assert.Assert(t, err == context.Canceled)
+}
@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -16,19 +17,40 @@ import (
 	"go.uber.org/zap"
 )
 
+// ErrDomainClosed when waiting on a closed Domain
+var ErrDomainClosed = errors.New("domain closed")
+
+// storedSchemaVersionTTL is how long the stored schema version is cached for SchemaLag
+const storedSchemaVersionTTL = time.Second
+
 // Domain represents a storage space
 type Domain struct {
-	handle   *schema.Handle
-	kvdb     mondis.KVDB
-	ddl      *ddl.DDL
-	reloadMu sync.Mutex
+	handle     *schema.Handle
+	kvdb       mondis.KVDB
+	ddl        *ddl.DDL
+	ddlOptions ddl.Options
+	reloadMu   sync.Mutex
+	closeCh    chan struct{}
+	// reloadedCh is closed and replaced each time schema cache is updated
+	reloadedMu sync.Mutex
+	reloadedCh chan struct{}
+	// stored schema version cached for SchemaLag
+	storedMu      sync.Mutex
+	storedVersion int64
+	storedAt      time.Time
 }
 
-// NewDomain is ctor for Domain
-func NewDomain(kvdb mondis.KVDB) *Domain {
+// NewDomain is ctor for Domain, ddlOptions is applied to its DDL if specified,
+// Callback.OnChanged of it is called after Domain reloads the schema on change.
+func NewDomain(kvdb mondis.KVDB, ddlOptions ...ddl.Options) *Domain {
 	do := &Domain{
-		handle: schema.NewHandle(),
-		kvdb:   kvdb,
+		handle:     schema.NewHandle(),
+		kvdb:       kvdb,
+		closeCh:    make(chan struct{}),
+		reloadedCh: make(chan struct{}),
+	}
+	if len(ddlOptions) != 0 {
+		do.ddlOptions = ddlOptions[0]
 	}
 	return do
 }
@@ -42,8 +64,9 @@ func (do *Domain) Init() (err error) {
 		return
 	}
 
-	callback := ddl.Callback{OnChanged: do.onChange}
-	ddl := ddl.New(do.kvdb, ddl.Options{Callback: callback})
+	ddlOptions := do.ddlOptions
+	ddlOptions.Callback = ddl.Callback{OnChanged: do.onChange}
+	ddl := ddl.New(do.kvdb, ddlOptions)
 	err = ddl.Init()
 	if err != nil {
 		logger.Instance().Error("Domain.Init ddl.Init", zap.Error(err))
@@ -55,11 +78,13 @@ func (do *Domain) Init() (err error) {
 }
 
 func (do *Domain) onChange(err error) {
-	if err != nil {
-		return
+	if err == nil {
+		do.mustReload()
 	}
 
-	do.mustReload()
+	if do.ddlOptions.Callback.OnChanged != nil {
+		do.ddlOptions.Callback.OnChanged(err)
+	}
 }
 
 func (do *Domain) mustReload() {
@@ -91,6 +116,8 @@ func (do *Domain) reloadInLoop() {
 			if err != nil {
 				logger.Instance().Error("reloadInLoop reload", zap.Error(err))
 			}
+		case <-do.closeCh:
+			return
 		}
 	}
 }
@@ -137,6 +164,9 @@ func (do *Domain) reloadSchema() (err error) {
 		}
 
 		err = do.handle.Update(context.Background(), newMetaCache)
+		if err == nil {
+			do.notifyReloaded()
+		}
 		return
 	}
 
@@ -147,7 +177,89 @@ func (do *Domain) reloadSchema() (err error) {
 
 	newMetaCache := schema.NewMetaCache(schemaVersionInKV, dbInfos)
 	err = do.handle.Update(context.Background(), newMetaCache)
+	if err == nil {
+		do.notifyReloaded()
+	}
+
+	return
+}
+
+func (do *Domain) notifyReloaded() {
+	do.reloadedMu.Lock()
+	close(do.reloadedCh)
+	do.reloadedCh = make(chan struct{})
+	do.reloadedMu.Unlock()
+}
+
+func (do *Domain) localSchemaVersion() (version int64) {
+	metaCache := do.handle.Get()
+	if metaCache != nil {
+		version = metaCache.Version()
+	}
+	return
+}
+
+// WaitSchemaVersion blocks until the local schema cache has applied at least version,
+// e.g. job.SchemaVersion of a DDL done by another process.
+// It reloads once if the cache is behind, and then waits for reloads by DDL of this Domain or by the reload loop,
+// so it may block until ctx is done if the reload loop is disabled by a zero config.Lease.
+func (do *Domain) WaitSchemaVersion(ctx context.Context, version int64) (err error) {
+	reloaded := false
+	for {
+		// taken before checking version so that a reload in between is not missed
+		do.reloadedMu.Lock()
+		reloadedCh := do.reloadedCh
+		do.reloadedMu.Unlock()
+
+		if do.localSchemaVersion() >= version {
+			return
+		}
+		if !reloaded {
+			reloaded = true
+			err = do.reload()
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-reloadedCh:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-do.closeCh:
+			err = ErrDomainClosed
+			return
+		}
+	}
+}
+
+// SchemaLag returns the schema version of the local cache and the one stored,
+// the stored one is cached for a second so that it's cheap to call for monitoring.
+func (do *Domain) SchemaLag() (localVersion, storedVersion int64, err error) {
+	localVersion = do.localSchemaVersion()
+
+	do.storedMu.Lock()
+	defer do.storedMu.Unlock()
 
+	if time.Since(do.storedAt) < storedSchemaVersionTTL {
+		storedVersion = do.storedVersion
+		// the cached one may be older than a reload since
+		if storedVersion < localVersion {
+			storedVersion = localVersion
+		}
+		return
+	}
+
+	txn := do.kvdb.NewTransaction(false)
+	defer txn.Discard()
+	storedVersion, err = meta.NewMeta(txn).GetSchemaVersion()
+	if err != nil {
+		return
+	}
+	do.storedVersion = storedVersion
+	do.storedAt = time.Now()
 	return
 }
 
@@ -192,6 +304,13 @@ func (do *Domain) DB(name string) (db *dml.DB, err error) {
 	return
 }
 
+// Close stops background goroutines of Domain, kvdb should be closed after it
+func (do *Domain) Close() (err error) {
+	close(do.closeCh)
+	err = do.ddl.Close()
+	return
+}
+
 // DDL getter
 func (do *Domain) DDL() *ddl.DDL {
 	return do.ddl
@@ -0,0 +1,143 @@
+package document
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	dbson "github.com/zhiqiangxu/mondis/document/bson"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrInvalidFilter when filter has an unknown operator or $in without an array
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// checkFilter validates operators of filter,
+// a value is an operator document if it's bson.M with all keys starting with $, e.g., {"$gt": 1, "$lt": 5}.
+func checkFilter(filter bson.M) (err error) {
+	for _, expected := range filter {
+		ops, ok := filterOps(expected)
+		if !ok {
+			continue
+		}
+		for op, v := range ops {
+			switch op {
+			case "$gt", "$lt":
+			case "$in":
+				if _, ok := v.(bson.A); !ok {
+					if _, ok := v.([]interface{}); !ok {
+						err = ErrInvalidFilter
+						return
+					}
+				}
+			default:
+				err = ErrInvalidFilter
+				return
+			}
+		}
+	}
+	return
+}
+
+// filterOps returns expected as operators if it's an operator document
+func filterOps(expected interface{}) (ops bson.M, ok bool) {
+	ops, ok = expected.(bson.M)
+	if !ok || len(ops) == 0 {
+		ok = false
+		return
+	}
+	for op := range ops {
+		if !strings.HasPrefix(op, "$") {
+			ok = false
+			return
+		}
+	}
+	return
+}
+
+// matchFilter checks doc against filter checked by checkFilter, only top level fields are supported,
+// a field matches equality, $in of any value, or both $gt and $lt if specified.
+// Like mongo, a missing field equals null, and $gt/$lt only match values of the same type order, e.g., numbers.
+func matchFilter(doc, filter bson.M) bool {
+	for field, expected := range filter {
+		// nil if missing
+		actual := doc[field]
+
+		ops, ok := filterOps(expected)
+		if !ok {
+			if dbson.Compare(actual, expected) != 0 {
+				return false
+			}
+			continue
+		}
+		for op, v := range ops {
+			if !matchOp(actual, op, v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchOp(actual interface{}, op string, v interface{}) bool {
+	switch op {
+	case "$gt":
+		return dbson.OrderOf(actual) == dbson.OrderOf(v) && dbson.Compare(actual, v) > 0
+	case "$lt":
+		return dbson.OrderOf(actual) == dbson.OrderOf(v) && dbson.Compare(actual, v) < 0
+	case "$in":
+		values, ok := v.(bson.A)
+		if !ok {
+			values = v.([]interface{})
+		}
+		for _, value := range values {
+			if dbson.Compare(actual, value) == 0 {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// sortDocs sorts dids and docs together by spec, fields are applied in name order
+func sortDocs(dids []int64, docs []bson.M, spec map[string]int) {
+	fields := make([]string, 0, len(spec))
+	for field := range spec {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	sort.Stable(&docSorter{dids: dids, docs: docs, fields: fields, spec: spec})
+}
+
+type docSorter struct {
+	dids   []int64
+	docs   []bson.M
+	fields []string
+	spec   map[string]int
+}
+
+func (s *docSorter) Len() int {
+	return len(s.docs)
+}
+
+func (s *docSorter) Less(i, j int) bool {
+	for _, field := range s.fields {
+		c := dbson.Compare(s.docs[i][field], s.docs[j][field])
+		if c == 0 {
+			continue
+		}
+		if s.spec[field] < 0 {
+			return c > 0
+		}
+		return c < 0
+	}
+	return s.dids[i] < s.dids[j]
+}
+
+func (s *docSorter) Swap(i, j int) {
+	s.dids[i], s.dids[j] = s.dids[j], s.dids[i]
+	s.docs[i], s.docs[j] = s.docs[j], s.docs[i]
+}
@@ -0,0 +1,109 @@
+package document
+
+import (
+	"context"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/meta"
+	"github.com/zhiqiangxu/mondis/util"
+)
+
+// CheckScope for CheckConsistency
+type CheckScope int32
+
+const (
+	// CheckScopeMeta checks the database list only
+	CheckScopeMeta CheckScope = iota
+	// CheckScopeDB checks one database specified by CheckOption.DBID
+	CheckScopeDB
+	// CheckScopeAll checks the database list and every database
+	CheckScopeAll
+)
+
+// CheckOption for CheckConsistency
+type CheckOption struct {
+	Scope CheckScope
+	DBID  int64
+	// Repair fixes the repairable issues
+	Repair bool
+	// Interval is the pause between two databases, for limiting the load
+	Interval time.Duration
+}
+
+// CheckReport for CheckConsistency
+type CheckReport struct {
+	// Checked is the number of checked units, the database list counts as one
+	Checked int64
+	Issues  []meta.CheckIssue
+}
+
+// Repaired returns the number of repaired issues
+func (r *CheckReport) Repaired() (n int) {
+	for _, issue := range r.Issues {
+		if issue.Repaired {
+			n++
+		}
+	}
+	return
+}
+
+// CheckConsistency checks meta of the document layer, each unit is checked in its own transaction,
+// progress is called after each unit if not nil.
+func CheckConsistency(ctx context.Context, kvdb mondis.KVDB, option CheckOption, progress func(CheckReport)) (report CheckReport, err error) {
+	run := util.RunInNewTxn
+	if option.Repair {
+		run = util.RunInNewUpdateTxn
+	}
+
+	checked := func(issues []meta.CheckIssue) {
+		report.Checked++
+		report.Issues = append(report.Issues, issues...)
+		if progress != nil {
+			progress(report)
+		}
+	}
+
+	var dbIDs []int64
+	switch option.Scope {
+	case CheckScopeDB:
+		dbIDs = []int64{option.DBID}
+	default:
+		var issues []meta.CheckIssue
+		err = run(kvdb, func(txn mondis.ProviderTxn) (err error) {
+			dbIDs, issues, err = meta.NewMeta(txn).CheckDatabases(option.Repair)
+			return
+		})
+		if err != nil {
+			return
+		}
+		checked(issues)
+		if option.Scope == CheckScopeMeta {
+			return
+		}
+	}
+
+	for i, dbID := range dbIDs {
+		if i > 0 && option.Interval > 0 {
+			select {
+			case <-time.After(option.Interval):
+			case <-ctx.Done():
+			}
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var issues []meta.CheckIssue
+		err = run(kvdb, func(txn mondis.ProviderTxn) (err error) {
+			issues, err = meta.NewMeta(txn).CheckDatabase(dbID, option.Repair)
+			return
+		})
+		if err != nil {
+			return
+		}
+		checked(issues)
+	}
+
+	return
+}
@@ -0,0 +1,164 @@
+package gcworker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/meta"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// deleteBatchSize is the max number of keys deleted in one txn
+	deleteBatchSize = 1000
+	// checkInterval is the interval to check pending ranges without notification
+	checkInterval = time.Second
+	// removeMaxRetries is the max retries on conflict when removing a finished range
+	removeMaxRetries = 10
+)
+
+// Status of gc worker
+type Status struct {
+	// PendingRanges is the number of ranges not deleted yet
+	PendingRanges int64
+	// KeysDeleted is the number of keys deleted since the worker is created
+	KeysDeleted int64
+}
+
+// Worker deletes ranges persisted in meta by ddl in background,
+// ranges not finished are resumed after restart.
+type Worker struct {
+	kvdb        mondis.KVDB
+	notifyCh    chan struct{}
+	keysDeleted int64
+}
+
+// New is ctor for Worker
+func New(kvdb mondis.KVDB) *Worker {
+	return &Worker{kvdb: kvdb, notifyCh: make(chan struct{}, 1)}
+}
+
+// Start deletes pending ranges until ctx is done
+func (w *Worker) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.run(ctx)
+	}()
+}
+
+// Notify the worker that new ranges are added
+func (w *Worker) Notify() {
+	select {
+	case w.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the current gc status
+func (w *Worker) Status() (status Status, err error) {
+	txn := w.kvdb.NewTransaction(false)
+	defer txn.Discard()
+
+	status.PendingRanges, err = meta.NewMeta(txn).DeleteRangeCount()
+	if err != nil {
+		return
+	}
+	status.KeysDeleted = atomic.LoadInt64(&w.keysDeleted)
+	return
+}
+
+func (w *Worker) run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		err := w.deletePendingRanges(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Instance().Error("deletePendingRanges", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.notifyCh:
+		}
+	}
+}
+
+func (w *Worker) deletePendingRanges(ctx context.Context) (err error) {
+	txn := w.kvdb.NewTransaction(false)
+	ranges, err := meta.NewMeta(txn).ListDeleteRanges()
+	txn.Discard()
+	if err != nil {
+		return
+	}
+
+	for _, r := range ranges {
+		err = w.deletePrefix(ctx, r.Prefix)
+		if err != nil {
+			return
+		}
+
+		err = util.RunInNewUpdateTxnWithRetry(w.kvdb, func(txn mondis.ProviderTxn) error {
+			return meta.NewMeta(txn).RemoveDeleteRange(r.ID)
+		}, removeMaxRetries)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// deletePrefix deletes all keys with prefix in batches,
+// a batch is committed early on ErrTxnTooBig and the rest is picked up by the next batch.
+func (w *Worker) deletePrefix(ctx context.Context, prefix []byte) (err error) {
+	var keys [][]byte
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		keys = keys[:0]
+		deleted := 0
+		err = util.RunInNewUpdateTxn(w.kvdb, func(txn mondis.ProviderTxn) (err error) {
+			err = txn.Scan(mondis.ProviderScanOption{Prefix: prefix, KeysOnly: true}, func(key []byte, _ []byte, _ mondis.VMetaResp) bool {
+				keys = append(keys, append([]byte(nil), key...))
+				return len(keys) < deleteBatchSize
+			})
+			if err != nil {
+				return
+			}
+			for _, key := range keys {
+				err = txn.Delete(key)
+				if err == kv.ErrTxnTooBig {
+					err = nil
+					return
+				}
+				if err != nil {
+					return
+				}
+				deleted++
+			}
+			return
+		})
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&w.keysDeleted, int64(deleted))
+		if deleted == 0 && len(keys) > 0 {
+			err = kv.ErrTxnTooBig
+			return
+		}
+		if len(keys) < deleteBatchSize && deleted == len(keys) {
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package document
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	tutil "github.com/zhiqiangxu/mondis/util"
+)
+
+// defaultImplicitTxnWait is the default of DBOption.ImplicitTxnWait
+const defaultImplicitTxnWait = time.Millisecond * 100
+
+// ErrTooBusy when DBOption.MaxImplicitTxns implicit txns are open and none is done within DBOption.ImplicitTxnWait
+var ErrTooBusy = errors.New("too many implicit transactions")
+
+// implicitTxnLimiter bounds txns created by Collection methods called with a nil txn,
+// a burst of short txns contends on the oracle of the provider, which slows down all of them.
+type implicitTxnLimiter struct {
+	// open is first for atomic alignment
+	open int64
+	// sem is nil when unlimited
+	sem  chan struct{}
+	wait time.Duration
+}
+
+func newImplicitTxnLimiter(max int, wait time.Duration) *implicitTxnLimiter {
+	l := &implicitTxnLimiter{}
+	if max > 0 {
+		l.sem = make(chan struct{}, max)
+		l.wait = wait
+		if l.wait <= 0 {
+			l.wait = defaultImplicitTxnWait
+		}
+	}
+	return l
+}
+
+func (l *implicitTxnLimiter) acquire() (err error) {
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		default:
+			timer := time.NewTimer(l.wait)
+			select {
+			case l.sem <- struct{}{}:
+				timer.Stop()
+			case <-timer.C:
+				err = ErrTooBusy
+				return
+			}
+		}
+	}
+	atomic.AddInt64(&l.open, 1)
+	return
+}
+
+func (l *implicitTxnLimiter) release() {
+	atomic.AddInt64(&l.open, -1)
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// ImplicitTxns returns the number of txns open now that are created by Collection methods called with a nil txn
+func (db *DB) ImplicitTxns() int64 {
+	return atomic.LoadInt64(&db.implicitTxns.open)
+}
+
+// newImplicitTxn creates a read only txn limited by DBOption.MaxImplicitTxns, done discards it
+func (c *Collection) newImplicitTxn() (txn mondis.ProviderTxn, done func(), err error) {
+	limiter := c.db.implicitTxns
+	err = limiter.acquire()
+	if err != nil {
+		return
+	}
+	txn = c.kvdb.NewTransaction(false)
+	done = func() {
+		txn.Discard()
+		limiter.release()
+	}
+	return
+}
+
+// runInImplicitUpdateTxn is tutil.RunInNewUpdateTxn limited by DBOption.MaxImplicitTxns
+func (c *Collection) runInImplicitUpdateTxn(f func(mondis.ProviderTxn) error) (err error) {
+	err = c.db.implicitTxns.acquire()
+	if err != nil {
+		return
+	}
+	defer c.db.implicitTxns.release()
+
+	err = tutil.RunInNewUpdateTxn(c.kvdb, f)
+	return
+}
+
+// runInImplicitUpdateTxnWithRetry is tutil.RunInNewUpdateTxnWithRetry limited by DBOption.MaxImplicitTxns,
+// the slot is kept across retries.
+func (c *Collection) runInImplicitUpdateTxnWithRetry(f func(mondis.ProviderTxn) error, retry int) (err error) {
+	err = c.db.implicitTxns.acquire()
+	if err != nil {
+		return
+	}
+	defer c.db.implicitTxns.release()
+
+	err = tutil.RunInNewUpdateTxnWithRetry(c.kvdb, f, retry)
+	return
+}
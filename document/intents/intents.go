@@ -0,0 +1,313 @@
+package intents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/keyspace"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/memcomparable"
+	"github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// Intent log structure:
+//	s -> int64, the last allocated intent id
+//	r:1 -> intent record
+//	r:2 -> intent record
+//
+// An operation spanning multiple transactions calls Begin in its first transaction,
+// Renew or Update in each subsequent transaction to make sure the intent is still alive and extend its lease,
+// and Complete in its final transaction.
+// Intents whose lease expired are regarded as left behind by a crash,
+// and are handed to the recovery handler registered for the kind.
+
+var (
+	seqKey       = []byte(keyspace.IntentPrefix + "s")
+	recordPrefix = []byte(keyspace.IntentPrefix + "r")
+)
+
+var (
+	// ErrIntentNotFound when intent not exists
+	ErrIntentNotFound = errors.New("intent not found")
+	// ErrEmptyKind when intent kind is empty
+	ErrEmptyKind = errors.New("intent kind cannot be empty")
+	// ErrRecoveryRegistered when recovery handler for the kind already registered
+	ErrRecoveryRegistered = errors.New("recovery handler already registered")
+	// ErrInvalidLease when lease is not positive
+	ErrInvalidLease = errors.New("intent lease must be positive")
+)
+
+// DefaultLease is the lease of intents began by Begin
+const DefaultLease = time.Minute
+
+// Intent for an operation spanning multiple transactions
+type Intent struct {
+	ID      int64
+	Kind    string
+	Payload []byte
+	// Lease is extended by each Renew or Update
+	Lease time.Duration
+	// Deadline is when the lease expires, after which the intent is recovered
+	Deadline time.Time
+}
+
+type record struct {
+	Kind     string
+	Payload  []byte
+	Lease    int64
+	Deadline int64
+}
+
+func encodeRecordKey(id int64) []byte {
+	return memcomparable.EncodeInt64(append([]byte(nil), recordPrefix...), id)
+}
+
+func decodeRecordKey(key []byte) (id int64, err error) {
+	_, id, err = memcomparable.DecodeInt64(key[len(recordPrefix):])
+	return
+}
+
+// Begin is BeginWithLease with DefaultLease
+func Begin(kind string, payload []byte, txn mondis.ProviderTxn) (id int64, err error) {
+	id, err = BeginWithLease(kind, payload, DefaultLease, txn)
+	return
+}
+
+// BeginWithLease writes a new intent record in txn and returns its id,
+// ids are allocated in increasing order.
+// The operation must Renew or Update the intent within lease, otherwise it may be recovered while still running.
+func BeginWithLease(kind string, payload []byte, lease time.Duration, txn mondis.ProviderTxn) (id int64, err error) {
+	if kind == "" {
+		err = ErrEmptyKind
+		return
+	}
+	if lease <= 0 {
+		err = ErrInvalidLease
+		return
+	}
+
+	id, err = kv.IncInt64(txn, seqKey, 1)
+	if err != nil {
+		return
+	}
+
+	err = put(Intent{ID: id, Kind: kind, Payload: payload, Lease: lease}, txn)
+	return
+}
+
+// Renew extends the lease of intent id from now.
+func Renew(id int64, txn mondis.ProviderTxn) (err error) {
+	intent, err := Get(id, txn)
+	if err != nil {
+		return
+	}
+
+	err = put(intent, txn)
+	return
+}
+
+// Update replaces the payload of intent id, eg, to record progress, and extends its lease from now.
+func Update(id int64, payload []byte, txn mondis.ProviderTxn) (err error) {
+	intent, err := Get(id, txn)
+	if err != nil {
+		return
+	}
+
+	intent.Payload = payload
+	err = put(intent, txn)
+	return
+}
+
+// put writes intent with its deadline extended by its lease from now
+func put(intent Intent, txn mondis.ProviderTxn) (err error) {
+	r := record{
+		Kind:     intent.Kind,
+		Payload:  intent.Payload,
+		Lease:    int64(intent.Lease),
+		Deadline: time.Now().Add(intent.Lease).UnixNano(),
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	err = txn.Set(encodeRecordKey(intent.ID), data, nil)
+	return
+}
+
+func (r *record) intent(id int64) Intent {
+	return Intent{ID: id, Kind: r.Kind, Payload: r.Payload, Lease: time.Duration(r.Lease), Deadline: time.Unix(0, r.Deadline)}
+}
+
+// Get returns intent id, ErrIntentNotFound is returned if it's completed.
+func Get(id int64, txn mondis.ProviderTxn) (intent Intent, err error) {
+	data, _, err := txn.Get(encodeRecordKey(id))
+	if err != nil {
+		if err == kv.ErrKeyNotFound {
+			err = ErrIntentNotFound
+		}
+		return
+	}
+
+	var r record
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		return
+	}
+
+	intent = r.intent(id)
+	return
+}
+
+// Complete removes intent id in txn.
+func Complete(id int64, txn mondis.ProviderTxn) (err error) {
+	key := encodeRecordKey(id)
+	_, _, err = txn.Get(key)
+	if err != nil {
+		if err == kv.ErrKeyNotFound {
+			err = ErrIntentNotFound
+		}
+		return
+	}
+
+	err = txn.Delete(key)
+	return
+}
+
+// List returns all dangling intents in id order.
+func List(txn mondis.ProviderTxn) (intents []Intent, err error) {
+	var iterErr error
+	err = txn.Scan(mondis.ProviderScanOption{Prefix: recordPrefix}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		var id int64
+		id, iterErr = decodeRecordKey(key)
+		if iterErr != nil {
+			return false
+		}
+		var r record
+		iterErr = json.Unmarshal(value, &r)
+		if iterErr != nil {
+			return false
+		}
+		intents = append(intents, r.intent(id))
+		return true
+	})
+	if err == nil {
+		err = iterErr
+	}
+	return
+}
+
+// RecoveryFunc resumes or rolls back a dangling intent,
+// it may span multiple transactions but must be idempotent,
+// since it's called again if crashed before the intent is completed.
+// The intent is renewed before the call, a handler running longer than its lease should Renew it.
+type RecoveryFunc func(kvdb mondis.KVDB, intent Intent) error
+
+var (
+	mu         sync.RWMutex
+	recoveries = make(map[string]RecoveryFunc)
+)
+
+// RegisterRecovery registers the recovery handler for kind
+func RegisterRecovery(kind string, fn RecoveryFunc) (err error) {
+	if kind == "" {
+		err = ErrEmptyKind
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := recoveries[kind]; ok {
+		err = ErrRecoveryRegistered
+		return
+	}
+	recoveries[kind] = fn
+	return
+}
+
+// UnregisterRecovery removes the recovery handler for kind
+func UnregisterRecovery(kind string) {
+	mu.Lock()
+	delete(recoveries, kind)
+	mu.Unlock()
+}
+
+func getRecovery(kind string) RecoveryFunc {
+	mu.RLock()
+	defer mu.RUnlock()
+	return recoveries[kind]
+}
+
+// Recover hands dangling intents whose lease expired to their recovery handlers in id order,
+// each intent is completed after its handler succeeds.
+// Intents still in lease may belong to a live operation of another handle or process, they are left untouched,
+// so are intents without a registered handler, a later Recover handles them once expired.
+func Recover(kvdb mondis.KVDB) (n int, err error) {
+	var intents []Intent
+	err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		intents, err = List(txn)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, intent := range intents {
+		if now.Before(intent.Deadline) {
+			continue
+		}
+
+		fn := getRecovery(intent.Kind)
+		if fn == nil {
+			logger.Instance().Warn("no recovery handler for intent", zap.Int64("id", intent.ID), zap.String("kind", intent.Kind))
+			continue
+		}
+
+		// claimed by renewing, so that other handles or processes leave it alone while it's recovered
+		var claimed bool
+		err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+			current, err := Get(intent.ID, txn)
+			if err != nil || now.Before(current.Deadline) {
+				return
+			}
+			err = Renew(intent.ID, txn)
+			claimed = err == nil
+			return
+		})
+		if err == ErrIntentNotFound || err == kv.ErrTxnConflict {
+			// completed or claimed by others
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if !claimed {
+			continue
+		}
+
+		err = fn(kvdb, intent)
+		if err != nil {
+			err = fmt.Errorf("recover intent %d of kind %s: %v", intent.ID, intent.Kind, err)
+			return
+		}
+
+		err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) error {
+			return Complete(intent.ID, txn)
+		})
+		if err != nil && err != ErrIntentNotFound {
+			return
+		}
+		err = nil
+		n++
+	}
+	return
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"sort"
 
+	dbson "github.com/zhiqiangxu/mondis/document/bson"
 	"github.com/zhiqiangxu/mondis/document/keyspace"
 	"github.com/zhiqiangxu/mondis/kv"
 	"github.com/zhiqiangxu/mondis/kv/compact"
@@ -15,17 +16,25 @@ import (
 
 const (
 	collectionPrefixLen       = len(keyspace.CollectionPrefix)
-	documentPrefix            = "_d"  // stores all collection documents
+	documentPrefix            = "_d" // stores all collection documents
+	documentPrefixLen         = len(documentPrefix)
 	indexDataPrefix           = "_id" // stores all collection index data
 	columnsIndexedPrefix      = "_ci" // stores all columns with index
 	indexNamePrefix           = "_in" // stores index name => index id
 	indexNamePrefixLen        = len(indexNamePrefix)
 	sequencePrefix            = "_s" // stores latest sequence id of all keywords
 	metaSequencePrefix        = keyspace.MetaPrefix + sequencePrefix
+	sequenceJournalPrefix     = "_j" // stores the unreleased lease of journaled sequences
+	metaSequenceJournalPrefix = keyspace.MetaPrefix + sequenceJournalPrefix
 	cName2IDPrefix            = "_cn2id" // stores collection name => collection id
 	metaCName2IDPrefix        = keyspace.MetaPrefix + cName2IDPrefix
 	cID2NamePrefix            = "_cid2n" // stores collection id => collection name
 	metaCID2NamePrefix        = keyspace.MetaPrefix + cID2NamePrefix
+	cKindPrefix               = "_ck" // stores collection id => collection kind
+	metaCKindPrefix           = keyspace.MetaPrefix + cKindPrefix
+	counterNamePrefix         = "_cn" // stores counter name => document id for counters collection
+	creationTimePrefix        = "_ct" // stores creation time and document id of documents for CollectionOption.CreationTimeIndex
+	creationTimePrefixLen     = len(creationTimePrefix)
 	indexPrefix               = "_i" // stores index id => index definition
 	metaIndexPrefix           = keyspace.MetaPrefix + indexPrefix
 	reservedKeywordCollection = "collection"
@@ -39,6 +48,8 @@ var (
 	reservedKeywordCollectionBytes = []byte(reservedKeywordCollection)
 	reservedKeywordIndexBytes      = []byte(reservedKeywordIndex)
 	indexNamePrefixBytes           = []byte(indexNamePrefix)
+	documentPrefixBytes            = []byte(documentPrefix)
+	creationTimePrefixBytes        = []byte(creationTimePrefix)
 )
 
 // AppendCollectionDocumentPrefix appends c[cid]_d to buf
@@ -74,6 +85,19 @@ func AppendCollectionIndexDataPrefix(buf []byte, cid int64) kv.Key {
 	return buf
 }
 
+// AppendCollectionUniqueIndexPrefix appends c[cid]_id[iid] to buf
+func AppendCollectionUniqueIndexPrefix(buf []byte, cid, iid int64) kv.Key {
+	buf = AppendCollectionIndexDataPrefix(buf, cid)
+	return memcomparable.EncodeInt64(buf, iid)
+}
+
+// EncodeCollectionUniqueIndexKey returns c[cid]_id[iid][value], it maps value to document id for unique index
+func EncodeCollectionUniqueIndexKey(buf []byte, cid, iid int64, value interface{}) (key kv.Key, err error) {
+	buf = AppendCollectionUniqueIndexPrefix(buf, cid, iid)
+	key, err = dbson.AppendIndexValue(buf, value, false)
+	return
+}
+
 // EncodeCollectionColumnsIndexedKey return c[cid]_ci[sorted fields]
 func EncodeCollectionColumnsIndexedKey(buf []byte, cid int64, fields []IndexField) kv.Key {
 
@@ -120,6 +144,16 @@ func EncodeMetaSequenceKey(buf, keyword []byte) kv.Key {
 	return buf
 }
 
+// EncodeMetaSequenceJournalKey returns m_j[keyword]
+func EncodeMetaSequenceJournalKey(buf, keyword []byte) kv.Key {
+	if buf == nil {
+		buf = make([]byte, 0, len(metaSequenceJournalPrefix)+len(keyword))
+	}
+	buf = append(buf, metaSequenceJournalPrefix...)
+	buf = append(buf, keyword...)
+	return buf
+}
+
 // EncodeMetaCollectionName2IDKey returns m_cn2id[cname] to buf
 func EncodeMetaCollectionName2IDKey(buf []byte, cname string) kv.Key {
 	if buf == nil {
@@ -130,6 +164,16 @@ func EncodeMetaCollectionName2IDKey(buf []byte, cname string) kv.Key {
 	return buf
 }
 
+// EncodeMetaCollectionKindKey returns m_ck[cid]
+func EncodeMetaCollectionKindKey(buf []byte, cid int64) kv.Key {
+	if buf == nil {
+		buf = make([]byte, 0, len(metaCKindPrefix)+8)
+	}
+	buf = append(buf, metaCKindPrefix...)
+	buf = memcomparable.EncodeInt64(buf, cid)
+	return buf
+}
+
 // EncodeMetaIndexKey returns m_i[iid]
 func EncodeMetaIndexKey(buf []byte, iid int64) kv.Key {
 	if buf == nil {
@@ -163,6 +207,52 @@ func EncodeCollectionIndexName2IDKey(buf []byte, cid int64, iname string) kv.Key
 	return buf
 }
 
+// AppendCollectionCounterNamePrefix appends c[cid]_cn to buf
+func AppendCollectionCounterNamePrefix(buf []byte, cid int64) kv.Key {
+	if buf == nil {
+		buf = make([]byte, 0, collectionPrefixLen+8+len(counterNamePrefix))
+	}
+	buf = append(buf, keyspace.CollectionPrefix...)
+	buf = memcomparable.EncodeInt64(buf, cid)
+	buf = append(buf, counterNamePrefix...)
+	return buf
+}
+
+// EncodeCollectionCounterName2IDKey returns c[cid]_cn[name]
+func EncodeCollectionCounterName2IDKey(buf []byte, cid int64, name string) kv.Key {
+	if buf == nil {
+		buf = make([]byte, 0, collectionPrefixLen+8+len(counterNamePrefix)+memcomparable.EncodedBytesLength(len(name)))
+	}
+	buf = append(buf, keyspace.CollectionPrefix...)
+	buf = memcomparable.EncodeInt64(buf, cid)
+	buf = append(buf, counterNamePrefix...)
+	buf = memcomparable.EncodeBytes(buf, util.Slice(name))
+	return buf
+}
+
+// AppendCollectionCreationTimePrefix appends c[cid]_ct to buf
+func AppendCollectionCreationTimePrefix(buf []byte, cid int64) kv.Key {
+	if buf == nil {
+		buf = make([]byte, 0, collectionPrefixLen+8+creationTimePrefixLen)
+	}
+	buf = append(buf, keyspace.CollectionPrefix...)
+	buf = memcomparable.EncodeInt64(buf, cid)
+	buf = append(buf, creationTimePrefix...)
+	return buf
+}
+
+// EncodeCollectionCreationTimeKey returns c[cid]_ct[createdAt][did], createdAt is in milliseconds
+func EncodeCollectionCreationTimeKey(buf []byte, cid, createdAt, did int64) kv.Key {
+	if buf == nil {
+		buf = make([]byte, 0, collectionPrefixLen+8+creationTimePrefixLen+8+8)
+	}
+
+	buf = AppendCollectionCreationTimePrefix(buf, cid)
+	buf = memcomparable.EncodeInt64(buf, createdAt)
+	buf = memcomparable.EncodeInt64(buf, did)
+	return buf
+}
+
 func hasCollectionPrefix(key kv.Key) bool {
 	return bytes.HasPrefix(key, keyspace.CollectionPrefixBytes)
 }
@@ -171,6 +261,87 @@ func hasIndexNamePrefix(key kv.Key) bool {
 	return bytes.HasPrefix(key, indexNamePrefixBytes)
 }
 
+func hasDocumentPrefix(key kv.Key) bool {
+	return bytes.HasPrefix(key, documentPrefixBytes)
+}
+
+// DecodeCollectionDocumentKey is reverse of EncodeCollectionDocumentKey
+func DecodeCollectionDocumentKey(key kv.Key) (cid, did int64, err error) {
+	if len(key) != collectionPrefixLen+8+documentPrefixLen+8 {
+		err = fmt.Errorf("invalid collection document key - %q", key)
+		return
+	}
+
+	k := key
+
+	if !hasCollectionPrefix(key) {
+		err = fmt.Errorf("invalid collection document key - %q", k)
+		return
+	}
+
+	key = key[collectionPrefixLen:]
+	key, cid, err = memcomparable.DecodeInt64(key)
+	if err != nil {
+		return
+	}
+
+	if !hasDocumentPrefix(key) {
+		err = fmt.Errorf("invalid collection document key - %q", k)
+		return
+	}
+
+	key = key[documentPrefixLen:]
+	_, did, err = memcomparable.DecodeInt64(key)
+	if err != nil {
+		err = fmt.Errorf("invalid collection document key - %q", k)
+		return
+	}
+	return
+}
+
+func hasCreationTimePrefix(key kv.Key) bool {
+	return bytes.HasPrefix(key, creationTimePrefixBytes)
+}
+
+// DecodeCollectionCreationTimeKey is reverse of EncodeCollectionCreationTimeKey
+func DecodeCollectionCreationTimeKey(key kv.Key) (cid, createdAt, did int64, err error) {
+	if len(key) != collectionPrefixLen+8+creationTimePrefixLen+8+8 {
+		err = fmt.Errorf("invalid collection creation time key - %q", key)
+		return
+	}
+
+	k := key
+
+	if !hasCollectionPrefix(key) {
+		err = fmt.Errorf("invalid collection creation time key - %q", k)
+		return
+	}
+
+	key = key[collectionPrefixLen:]
+	key, cid, err = memcomparable.DecodeInt64(key)
+	if err != nil {
+		return
+	}
+
+	if !hasCreationTimePrefix(key) {
+		err = fmt.Errorf("invalid collection creation time key - %q", k)
+		return
+	}
+
+	key = key[creationTimePrefixLen:]
+	key, createdAt, err = memcomparable.DecodeInt64(key)
+	if err != nil {
+		err = fmt.Errorf("invalid collection creation time key - %q", k)
+		return
+	}
+	_, did, err = memcomparable.DecodeInt64(key)
+	if err != nil {
+		err = fmt.Errorf("invalid collection creation time key - %q", k)
+		return
+	}
+	return
+}
+
 // DecodeCollectionIndexName2IDKey is reverse for EncodeCollectionIndexName2IDKey
 func DecodeCollectionIndexName2IDKey(key kv.Key) (cid int64, iname []byte, err error) {
 	if len(key) <= collectionPrefixLen+8+len(indexNamePrefix) {
@@ -7,6 +7,8 @@ const (
 	MetaPrefix = BasePrefix + "m"
 	// CollectionPrefix for collection
 	CollectionPrefix = BasePrefix + "c"
+	// IntentPrefix for intent log
+	IntentPrefix = BasePrefix + "i"
 )
 
 var (
@@ -14,4 +16,6 @@ var (
 	MetaPrefixBytes = []byte(MetaPrefix)
 	// CollectionPrefixBytes for collection
 	CollectionPrefixBytes = []byte(CollectionPrefix)
+	// IntentPrefixBytes for intent log
+	IntentPrefixBytes = []byte(IntentPrefix)
 )
@@ -0,0 +1,241 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/numeric"
+	"github.com/zhiqiangxu/mondis/structure"
+)
+
+// CheckIssue is an inconsistency found in meta
+type CheckIssue struct {
+	Msg      string
+	Repaired bool
+}
+
+// checkHashLen checks the field count of hash key, repairs it if asked
+func (m *Meta) checkHashLen(key []byte, repair bool) (issues []CheckIssue, err error) {
+	l, err := m.txn.HLen(key)
+	if err != nil {
+		return
+	}
+	n, err := m.txn.HCount(key)
+	if err != nil {
+		return
+	}
+	if l == n {
+		return
+	}
+
+	issue := CheckIssue{Msg: fmt.Sprintf("%s field count %d, actual %d", key, l, n)}
+	if repair {
+		if _, err = m.txn.HRepairLen(key); err != nil {
+			return
+		}
+		issue.Repaired = true
+	}
+	issues = append(issues, issue)
+	return
+}
+
+// CheckDatabases checks the database list and its name index, returns ids of databases that can be checked further.
+func (m *Meta) CheckDatabases(repair bool) (dbIDs []int64, issues []CheckIssue, err error) {
+	issues, err = m.checkHashLen(dbsKey, repair)
+	if err != nil {
+		return
+	}
+	nameIssues, err := m.checkHashLen(dbNamesKey, repair)
+	if err != nil {
+		return
+	}
+	issues = append(issues, nameIssues...)
+
+	res, err := m.txn.HGetAll(dbsKey)
+	if err != nil {
+		return
+	}
+
+	for _, r := range res {
+		dbInfo := &model.DBInfo{}
+		if jsonErr := json.Unmarshal(r.Value, dbInfo); jsonErr != nil {
+			issues = append(issues, CheckIssue{Msg: fmt.Sprintf("%s undecodable: %v", r.Field, jsonErr)})
+			continue
+		}
+		if !bytes.Equal(r.Field, dbKeyByID(dbInfo.ID)) {
+			issues = append(issues, CheckIssue{Msg: fmt.Sprintf("%s has id %d", r.Field, dbInfo.ID)})
+			continue
+		}
+		dbIDs = append(dbIDs, dbInfo.ID)
+
+		_, err = m.txn.HGet(dbNamesKey, []byte(dbInfo.Name))
+		if err != kv.ErrKeyNotFound {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		issue := CheckIssue{Msg: fmt.Sprintf("%s missing in name index", r.Field)}
+		if repair {
+			if err = m.txn.HSetInt64(dbNamesKey, []byte(dbInfo.Name), dbInfo.ID); err != nil {
+				return
+			}
+			issue.Repaired = true
+		}
+		err = nil
+		issues = append(issues, issue)
+	}
+
+	res, err = m.txn.HGetAll(dbNamesKey)
+	if err != nil {
+		return
+	}
+	for _, r := range res {
+		var issue *CheckIssue
+		issue, err = m.checkDBName(r, repair)
+		if err != nil {
+			return
+		}
+		if issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+	return
+}
+
+// checkDBName checks the db name index entry r points at a database with the same name,
+// the entry is removed if asked to repair.
+func (m *Meta) checkDBName(r structure.HashPair, repair bool) (issue *CheckIssue, err error) {
+	dbID, decodeErr := numeric.DecodeFromHuman(r.Value)
+	if decodeErr == nil {
+		var value []byte
+		value, err = m.txn.HGet(dbsKey, dbKeyByID(dbID))
+		switch {
+		case err == nil:
+			dbInfo := &model.DBInfo{}
+			if json.Unmarshal(value, dbInfo) != nil || dbInfo.Name == string(r.Field) {
+				// undecodable ones are reported by CheckDatabases
+				return
+			}
+			issue = &CheckIssue{Msg: fmt.Sprintf("%s.%s points at db %d named %s", dbNamesKey, r.Field, dbID, dbInfo.Name)}
+		case err == kv.ErrKeyNotFound:
+			err = nil
+			issue = &CheckIssue{Msg: fmt.Sprintf("%s.%s points at missing db %d", dbNamesKey, r.Field, dbID)}
+		default:
+			return
+		}
+	} else {
+		issue = &CheckIssue{Msg: fmt.Sprintf("%s.%s undecodable: %v", dbNamesKey, r.Field, decodeErr)}
+	}
+
+	if repair {
+		if err = m.txn.HDel(dbNamesKey, r.Field); err != nil {
+			return
+		}
+		issue.Repaired = true
+	}
+	return
+}
+
+// CheckDatabase checks collections and did sequences of database dbID.
+func (m *Meta) CheckDatabase(dbID int64, repair bool) (issues []CheckIssue, err error) {
+	dbKey := dbKeyByID(dbID)
+	if err = m.checkDBExists(dbKey); err != nil {
+		return
+	}
+
+	issues, err = m.checkHashLen(dbKey, repair)
+	if err != nil {
+		return
+	}
+
+	res, err := m.txn.HGetAll(dbKey)
+	if err != nil {
+		return
+	}
+
+	for _, r := range res {
+		switch {
+		case bytes.HasPrefix(r.Field, collectionInfoPrefix):
+			collectionInfo := &model.CollectionInfo{}
+			if jsonErr := json.Unmarshal(r.Value, collectionInfo); jsonErr != nil {
+				issues = append(issues, CheckIssue{Msg: fmt.Sprintf("%s.%s undecodable: %v", dbKey, r.Field, jsonErr)})
+				continue
+			}
+			if !bytes.Equal(r.Field, m.collectionInfoKeyByID(collectionInfo.ID)) {
+				issues = append(issues, CheckIssue{Msg: fmt.Sprintf("%s.%s has id %d", dbKey, r.Field, collectionInfo.ID)})
+				continue
+			}
+			nameKey := collectionNameKey(collectionInfo.Name)
+			_, err = m.txn.HGet(dbKey, nameKey)
+			if err != kv.ErrKeyNotFound {
+				if err != nil {
+					return
+				}
+				continue
+			}
+			issue := CheckIssue{Msg: fmt.Sprintf("%s.%s missing in name index", dbKey, r.Field)}
+			if repair {
+				if err = m.txn.HSetInt64(dbKey, nameKey, collectionInfo.ID); err != nil {
+					return
+				}
+				issue.Repaired = true
+			}
+			err = nil
+			issues = append(issues, issue)
+		case bytes.HasPrefix(r.Field, collectionNamePrefix):
+			var issue *CheckIssue
+			issue, err = m.checkCollectionName(dbID, r, repair)
+			if err != nil {
+				return
+			}
+			if issue != nil {
+				issues = append(issues, *issue)
+			}
+		case bytes.HasPrefix(r.Field, didSequencePrefix):
+			if _, decodeErr := numeric.DecodeFromHuman(r.Value); decodeErr != nil {
+				issues = append(issues, CheckIssue{Msg: fmt.Sprintf("%s.%s undecodable: %v", dbKey, r.Field, decodeErr)})
+			}
+		default:
+			issues = append(issues, CheckIssue{Msg: fmt.Sprintf("%s.%s unknown field", dbKey, r.Field)})
+		}
+	}
+	return
+}
+
+// checkCollectionName checks the collection name index entry r points at a collection with the same name,
+// the entry is removed if asked to repair.
+func (m *Meta) checkCollectionName(dbID int64, r structure.HashPair, repair bool) (issue *CheckIssue, err error) {
+	dbKey := dbKeyByID(dbID)
+	name := string(r.Field[len(collectionNamePrefix)+1:])
+
+	collectionID, decodeErr := numeric.DecodeFromHuman(r.Value)
+	if decodeErr == nil {
+		var collectionInfo *model.CollectionInfo
+		collectionInfo, err = m.GetCollection(dbID, collectionID)
+		switch {
+		case err == nil && collectionInfo.Name == name:
+			return
+		case err == nil:
+			issue = &CheckIssue{Msg: fmt.Sprintf("%s.%s points at collection %d named %s", dbKey, r.Field, collectionID, collectionInfo.Name)}
+		case err == ErrCollectionNotExists:
+			err = nil
+			issue = &CheckIssue{Msg: fmt.Sprintf("%s.%s points at missing collection %d", dbKey, r.Field, collectionID)}
+		default:
+			return
+		}
+	} else {
+		issue = &CheckIssue{Msg: fmt.Sprintf("%s.%s undecodable: %v", dbKey, r.Field, decodeErr)}
+	}
+
+	if repair {
+		if err = m.txn.HDel(dbKey, r.Field); err != nil {
+			return
+		}
+		issue.Repaired = true
+	}
+	return
+}
@@ -0,0 +1,254 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/numeric"
+)
+
+const (
+	defaultDumpHistoryLimit = 10
+	redacted                = "<redacted>"
+)
+
+// DumpOption for Dump
+type DumpOption struct {
+	// HistoryLimit is the number of latest history jobs to dump, defaults to 10
+	HistoryLimit int
+	// Redact hides job args and delete range prefixes, which may carry user data
+	Redact bool
+}
+
+// Dump writes a human readable rendering of all meta in txn to w,
+// the output is deterministic for the same meta.
+// A read only txn is enough, so it can be used on a live system without blocking writes.
+func Dump(txn mondis.ProviderTxn, w io.Writer, opt DumpOption) (err error) {
+	if opt.HistoryLimit <= 0 {
+		opt.HistoryLimit = defaultDumpHistoryLimit
+	}
+
+	m := NewMeta(txn)
+	d := &dumper{m: m, opt: opt}
+	err = d.dump()
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(d.buf.Bytes())
+	return
+}
+
+type dumper struct {
+	m   *Meta
+	opt DumpOption
+	buf bytes.Buffer
+}
+
+func (d *dumper) printf(indent int, format string, a ...interface{}) {
+	d.buf.WriteString(strings.Repeat("  ", indent))
+	fmt.Fprintf(&d.buf, format, a...)
+	d.buf.WriteByte('\n')
+}
+
+func (d *dumper) dump() (err error) {
+	globalID, err := d.m.GetGlobalID()
+	if err != nil {
+		return
+	}
+	schemaVersion, err := d.m.GetSchemaVersion()
+	if err != nil {
+		return
+	}
+	bootstrap, err := d.m.GetBootstrapVersion()
+	if err != nil {
+		return
+	}
+	d.printf(0, "globalID: %d", globalID)
+	d.printf(0, "schemaVersion: %d", schemaVersion)
+	d.printf(0, "bootstrap: %d", bootstrap)
+
+	err = d.dumpDatabases()
+	if err != nil {
+		return
+	}
+
+	for _, listKey := range []JobListKeyType{DefaultJobListKey, AddIndexJobListKey} {
+		var jobs []*model.Job
+		jobs, err = d.m.GetAllDDLJobsInQueue(listKey)
+		if err != nil {
+			return
+		}
+		d.dumpJobs(fmt.Sprintf("queue %s", listKey), jobs)
+	}
+
+	history, err := d.m.GetLastNHistoryDDLJobs(d.opt.HistoryLimit)
+	if err != nil {
+		return
+	}
+	d.dumpJobs(fmt.Sprintf("history (last %d)", d.opt.HistoryLimit), history)
+
+	err = d.dumpReorgHandles()
+	if err != nil {
+		return
+	}
+
+	err = d.dumpDeleteRanges()
+	return
+}
+
+func (d *dumper) dumpDatabases() (err error) {
+	dbs, err := d.m.ListDatabases()
+	if err != nil {
+		return
+	}
+	sort.Slice(dbs, func(i, j int) bool {
+		return dbs[i].ID < dbs[j].ID
+	})
+
+	d.printf(0, "databases: %d", len(dbs))
+	for _, db := range dbs {
+		d.printf(1, "db %d %q state=%s", db.ID, db.Name, db.State)
+
+		var collections []*model.CollectionInfo
+		collections, err = d.m.ListCollections(db.ID)
+		if err != nil {
+			return
+		}
+		sort.Slice(collections, func(i, j int) bool {
+			return collections[i].ID < collections[j].ID
+		})
+
+		for _, ci := range collections {
+			var didSequence string
+			didSequence, err = d.didSequence(db.ID, ci.ID)
+			if err != nil {
+				return
+			}
+			d.printf(2, "collection %d %q state=%s didSequence=%s", ci.ID, ci.Name, ci.State, didSequence)
+
+			indices := make([]*model.IndexInfo, 0, len(ci.Indices))
+			for _, iif := range ci.Indices {
+				indices = append(indices, iif)
+			}
+			sort.Slice(indices, func(i, j int) bool {
+				return indices[i].ID < indices[j].ID
+			})
+			for _, iif := range indices {
+				d.printf(3, "index %d %q columns=%s unique=%v state=%s", iif.ID, iif.Name, strings.Join(iif.Columns, ","), iif.Unique, iif.State)
+			}
+		}
+	}
+	return
+}
+
+// didSequence returns the leased auto id of collection, or "none" if not allocated yet
+func (d *dumper) didSequence(dbID, cid int64) (s string, err error) {
+	n, err := d.m.txn.HGetInt64(dbKeyByID(dbID), didSequenceKeyByID(cid))
+	if err == kv.ErrKeyNotFound {
+		err = nil
+		s = "none"
+		return
+	}
+	if err != nil {
+		return
+	}
+	s = fmt.Sprintf("%d", n)
+	return
+}
+
+func (d *dumper) dumpJobs(title string, jobs []*model.Job) {
+	d.printf(0, "%s: %d", title, len(jobs))
+	for _, job := range jobs {
+		d.printf(1, "job %d type=%q state=%q schemaState=%q errorCount=%d dependency=%d", job.ID, job.Type, job.State, job.SchemaState, job.ErrorCount, job.DependencyID)
+		if job.Error != nil {
+			d.printf(2, "error: %s", job.Error.Msg)
+		}
+		d.dumpArg(job.ArgVersion, job.RawArg)
+	}
+}
+
+// dumpArg pretty prints json arg, and hex of binary arg encoded by other codecs
+func (d *dumper) dumpArg(version model.ArgVersion, raw json.RawMessage) {
+	if len(raw) == 0 {
+		return
+	}
+	if d.opt.Redact {
+		d.printf(2, "arg(v%d): %s", version, redacted)
+		return
+	}
+
+	if version != model.ArgVersionJSON {
+		var data []byte
+		if err := json.Unmarshal(raw, &data); err == nil {
+			d.printf(2, "arg(v%d): %s", version, hex.EncodeToString(data))
+			return
+		}
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, raw, strings.Repeat("  ", 2), "  "); err != nil {
+		d.printf(2, "arg(v%d): %q", version, raw)
+		return
+	}
+	d.printf(2, "arg(v%d): %s", version, out.Bytes())
+}
+
+func (d *dumper) dumpReorgHandles() (err error) {
+	pairs, err := d.m.txn.HGetAll(ddlJobReorgKey)
+	if err != nil {
+		return
+	}
+
+	d.printf(0, "reorg handles: %d", len(pairs))
+	for _, pair := range pairs {
+		var (
+			jobID  uint64
+			handle int64
+		)
+		if len(pair.Field) < 8 {
+			d.printf(1, "undecodable field %x", pair.Field)
+			continue
+		}
+		jobID, err = numeric.DecodeFromBinary(pair.Field[:8])
+		if err != nil {
+			return
+		}
+		handle, err = numeric.DecodeFromHuman(pair.Value)
+		if err != nil {
+			return
+		}
+
+		kind := "start"
+		if len(pair.Field) > 8 {
+			kind = strings.TrimPrefix(string(pair.Field[8:]), "_")
+		}
+		d.printf(1, "job %d %s=%d", jobID, kind, handle)
+	}
+	return
+}
+
+func (d *dumper) dumpDeleteRanges() (err error) {
+	ranges, err := d.m.ListDeleteRanges()
+	if err != nil {
+		return
+	}
+
+	d.printf(0, "delete ranges: %d", len(ranges))
+	for _, r := range ranges {
+		prefix := redacted
+		if !d.opt.Redact {
+			prefix = hex.EncodeToString(r.Prefix)
+		}
+		d.printf(1, "range %d prefix=%s", r.ID, prefix)
+	}
+	return
+}
@@ -0,0 +1,92 @@
+package meta
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/provider"
+	"github.com/zhiqiangxu/util/osc"
+	"gotest.tools/assert"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestDump(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	// fixture
+	txn := kvdb.NewTransaction(true)
+	m := NewMeta(txn)
+	_, _, err = m.GenGlobalIDs(8)
+	assert.Assert(t, err == nil)
+	_, err = m.GenSchemaVersion()
+	assert.Assert(t, err == nil)
+	err = m.FinishBootstrap(1)
+	assert.Assert(t, err == nil)
+
+	for _, db := range []*model.DBInfo{{ID: 2, Name: "shop", State: osc.StatePublic}, {ID: 1, Name: "test", State: osc.StatePublic}} {
+		err = m.CreateDatabase(db)
+		assert.Assert(t, err == nil)
+	}
+	email := &model.IndexInfo{ID: 5, Name: "email", Columns: []string{"email"}, Unique: true, State: osc.StatePublic}
+	city := &model.IndexInfo{ID: 6, Name: "city", Columns: []string{"addr.city", "age"}, State: osc.StateWriteReorganization}
+	users := &model.CollectionInfo{ID: 4, Name: "users", State: osc.StatePublic}
+	users.AddIndexInfo(city)
+	users.AddIndexInfo(email)
+	err = m.CreateCollection(2, users)
+	assert.Assert(t, err == nil)
+	err = m.CreateCollection(2, &model.CollectionInfo{ID: 3, Name: "hits", State: osc.StatePublic})
+	assert.Assert(t, err == nil)
+	_, err = m.txn.HInc(dbKeyByID(2), didSequenceKeyByID(4), 1000)
+	assert.Assert(t, err == nil)
+
+	addIndex := &model.Job{ID: 7, Type: model.ActionAddIndex, State: model.JobStateRunning, SchemaState: osc.StateWriteReorganization, Arg: city}
+	err = m.EnQueueDDLJob(addIndex, AddIndexJobListKey)
+	assert.Assert(t, err == nil)
+	err = m.UpdateDDLReorgStartHandle(addIndex, 321)
+	assert.Assert(t, err == nil)
+
+	for _, job := range []*model.Job{
+		{ID: 2, Type: model.ActionCreateSchema, State: model.JobStateSynced, SchemaState: osc.StatePublic, Arg: "shop"},
+		{ID: 8, Type: model.ActionAddIndex, State: model.JobStateRollbackDone, Error: model.NewJobError(errors.New("unique index violated: did 12")), ErrorCount: 1, Arg: email},
+	} {
+		err = m.AddHistoryDDLJob(job)
+		assert.Assert(t, err == nil)
+	}
+	err = m.AddDeleteRange(&model.DeleteRange{ID: 9, Prefix: []byte("c4_i8")})
+	assert.Assert(t, err == nil)
+	err = txn.Commit()
+	assert.Assert(t, err == nil)
+
+	for _, golden := range []struct {
+		file string
+		opt  DumpOption
+	}{
+		{file: "dump.golden"},
+		{file: "dump_redact.golden", opt: DumpOption{HistoryLimit: 1, Redact: true}},
+	} {
+		txn := kvdb.NewTransaction(false)
+		var out bytes.Buffer
+		err = Dump(txn, &out, golden.opt)
+		txn.Discard()
+		assert.Assert(t, err == nil)
+
+		path := filepath.Join("testdata", golden.file)
+		if *update {
+			err = ioutil.WriteFile(path, out.Bytes(), 0644)
+			assert.Assert(t, err == nil)
+		}
+		expected, err := ioutil.ReadFile(path)
+		assert.Assert(t, err == nil)
+		assert.Equal(t, out.String(), string(expected))
+	}
+}
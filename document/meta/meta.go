@@ -1,9 +1,9 @@
 package meta
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"sync"
 
 	"errors"
@@ -36,9 +36,19 @@ var (
 //		db:1 -> db meta data []byte
 //		db:2 -> db meta data []byte
 //	}
+//	dbNames -> {
+//		name1 -> int64
+//		name2 -> int64
+//	}
+//	nodeEpoch -> int64
+//	nodes -> {
+//		node id -> node info []byte
+//	}
 //	db:1 -> {
 //		collectionInfo:1 -> collection meta data []byte
 //		collectionInfo:2 -> collection meta data []byte
+//		collectionName:name1 -> int64
+//		collectionName:name2 -> int64
 //		didSequence:1 -> int64
 //		didSequence:2 -> int64
 //	}
@@ -50,9 +60,15 @@ var (
 	bootstrapKey         = []byte("bootstrap")
 	globalIDKey          = []byte("globalID")
 	dbsKey               = []byte("dbs")
+	dbNamesKey           = []byte("dbNames")
 	dbPrefix             = []byte("db")
 	collectionInfoPrefix = []byte("collectionInfo")
+	collectionNamePrefix = []byte("collectionName")
 	didSequencePrefix    = []byte("didSequence")
+	nodeEpochKey         = []byte("nodeEpoch")
+	nodesKey             = []byte("nodes")
+	// collectionInfoFieldPrefix is the prefix of collection meta fields in db hash
+	collectionInfoFieldPrefix = []byte("collectionInfo:")
 )
 
 var (
@@ -66,8 +82,20 @@ var (
 	ErrCollectionNotExists = errors.New("collection not exists")
 	// ErrJobNotExists used by Meta
 	ErrJobNotExists = errors.New("job not exists")
+	// ErrDBNameIndexCorrupt when db name index points at a missing db
+	ErrDBNameIndexCorrupt = errors.New("db name index corrupt")
+	// ErrCollectionNameIndexCorrupt when collection name index points at a missing collection
+	ErrCollectionNameIndexCorrupt = errors.New("collection name index corrupt")
+	// ErrTooManyItems when a list has more items than ListOption.MaxItems, the paged variant should be used instead
+	ErrTooManyItems = errors.New("too many items, list by page instead")
 )
 
+// ListOption for ListDatabasesWithOption and ListCollectionsWithOption
+type ListOption struct {
+	// MaxItems fails the list with ErrTooManyItems if there're more items, 0 means no limit
+	MaxItems int
+}
+
 // NewMeta creates a Meta in transaction txn.
 func NewMeta(txn mondis.ProviderTxn, jobListKeys ...JobListKeyType) *Meta {
 	t := structure.New(txn, keyspace.MetaPrefixBytes)
@@ -89,6 +117,10 @@ func (m *Meta) collectionInfoKeyByID(collectionID int64) []byte {
 	return []byte(fmt.Sprintf("%s:%d", collectionInfoPrefix, collectionID))
 }
 
+func collectionNameKey(name string) []byte {
+	return []byte(fmt.Sprintf("%s:%s", collectionNamePrefix, name))
+}
+
 func didSequenceKeyByID(collectionID int64) []byte {
 	return []byte(fmt.Sprintf("%s:%d", didSequencePrefix, collectionID))
 }
@@ -171,29 +203,53 @@ func (m *Meta) CreateDatabase(dbInfo *model.DBInfo) (err error) {
 	if err = m.checkDBNotExists(dbKey); err != nil {
 		return
 	}
+	if err = m.checkDBNameNotExists(dbInfo.Name); err != nil {
+		return
+	}
 
 	data, err := json.Marshal(dbInfo)
 	if err != nil {
 		return
 	}
 
-	return m.txn.HSet(dbsKey, dbKey, data)
+	if err = m.txn.HSet(dbsKey, dbKey, data); err != nil {
+		return
+	}
+
+	err = m.txn.HSetInt64(dbNamesKey, []byte(dbInfo.Name), dbInfo.ID)
+	return
 }
 
 // UpdateDatabase updates a database with db info.
 func (m *Meta) UpdateDatabase(dbInfo *model.DBInfo) (err error) {
 	dbKey := dbKeyByID(dbInfo.ID)
 
-	if err = m.checkDBExists(dbKey); err != nil {
+	oldDBInfo, err := m.GetDatabase(dbInfo.ID)
+	if err != nil {
 		return
 	}
+	if oldDBInfo.Name != dbInfo.Name {
+		if err = m.checkDBNameNotExists(dbInfo.Name); err != nil {
+			return
+		}
+	}
 
 	data, err := json.Marshal(dbInfo)
 	if err != nil {
 		return
 	}
 
-	return m.txn.HSet(dbsKey, dbKey, data)
+	if err = m.txn.HSet(dbsKey, dbKey, data); err != nil {
+		return
+	}
+
+	if oldDBInfo.Name != dbInfo.Name {
+		if err = m.txn.HDel(dbNamesKey, []byte(oldDBInfo.Name)); err != nil {
+			return
+		}
+	}
+	err = m.txn.HSetInt64(dbNamesKey, []byte(dbInfo.Name), dbInfo.ID)
+	return
 }
 
 // CreateCollection creates a collection with CollectoinInfo in database.
@@ -209,20 +265,30 @@ func (m *Meta) CreateCollection(dbID int64, collectionInfo *model.CollectionInfo
 	if err = m.checkCollectionNotExists(dbKey, collectionInfoKey); err != nil {
 		return
 	}
+	nameKey := collectionNameKey(collectionInfo.Name)
+	if err = m.checkCollectionNameNotExists(dbID, collectionInfo.Name); err != nil {
+		return
+	}
 
 	data, err := json.Marshal(collectionInfo)
 	if err != nil {
 		return
 	}
 
-	return m.txn.HSet(dbKey, collectionInfoKey, data)
+	if err = m.txn.HSet(dbKey, collectionInfoKey, data); err != nil {
+		return
+	}
+
+	err = m.txn.HSetInt64(dbKey, nameKey, collectionInfo.ID)
+	return
 }
 
 // DropDatabase drops whole database.
 func (m *Meta) DropDatabase(dbID int64) (err error) {
 	// Check if db exists.
 	dbKey := dbKeyByID(dbID)
-	if err = m.checkDBExists(dbKey); err != nil {
+	dbInfo, err := m.GetDatabase(dbID)
+	if err != nil {
 		return
 	}
 
@@ -234,6 +300,38 @@ func (m *Meta) DropDatabase(dbID int64) (err error) {
 		return
 	}
 
+	err = m.delDBName(dbInfo.Name, dbID)
+	return
+}
+
+func (m *Meta) checkDBNameNotExists(name string) (err error) {
+	_, err = m.txn.HGet(dbNamesKey, []byte(name))
+	if err == kv.ErrKeyNotFound {
+		// databases created before the name index have no entry
+		_, err = m.scanDatabaseByName(name)
+		if err == ErrDBNotExists {
+			err = nil
+		} else if err == nil {
+			err = ErrDBExists
+		}
+	} else if err == nil {
+		err = ErrDBExists
+	}
+	return
+}
+
+// delDBName removes name from the db name index if it points at dbID
+func (m *Meta) delDBName(name string, dbID int64) (err error) {
+	id, err := m.txn.HGetInt64(dbNamesKey, []byte(name))
+	if err == kv.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil || id != dbID {
+		return
+	}
+
+	err = m.txn.HDel(dbNamesKey, []byte(name))
 	return
 }
 
@@ -248,13 +346,17 @@ func (m *Meta) DropCollection(dbID int64, collectionID int64, delAutoID bool) (e
 
 	// Check if collection exists.
 	collectionInfoKey := m.collectionInfoKeyByID(collectionID)
-	if err = m.checkCollectionExists(dbKey, collectionInfoKey); err != nil {
+	collectionInfo, err := m.GetCollection(dbID, collectionID)
+	if err != nil {
 		return
 	}
 
 	if err = m.txn.HDel(dbKey, collectionInfoKey); err != nil {
 		return
 	}
+	if err = m.delCollectionName(dbKey, collectionInfo.Name, collectionID); err != nil {
+		return
+	}
 	if delAutoID {
 		if err = m.txn.HDel(dbKey, didSequenceKeyByID(collectionID)); err != nil {
 			return
@@ -273,65 +375,187 @@ func (m *Meta) UpdateCollection(dbID int64, collectionInfo *model.CollectionInfo
 
 	// Check if collection exists.
 	collectionInfoKey := m.collectionInfoKeyByID(collectionInfo.ID)
-	if err = m.checkCollectionExists(dbKey, collectionInfoKey); err != nil {
+	oldCollectionInfo, err := m.GetCollection(dbID, collectionInfo.ID)
+	if err != nil {
 		return
 	}
+	renamed := oldCollectionInfo.Name != collectionInfo.Name
+	if renamed {
+		if err = m.checkCollectionNameNotExists(dbID, collectionInfo.Name); err != nil {
+			return
+		}
+	}
 
 	data, err := json.Marshal(collectionInfo)
 	if err != nil {
 		return
 	}
 
-	err = m.txn.HSet(dbKey, collectionInfoKey, data)
+	if err = m.txn.HSet(dbKey, collectionInfoKey, data); err != nil {
+		return
+	}
+
+	if renamed {
+		if err = m.delCollectionName(dbKey, oldCollectionInfo.Name, collectionInfo.ID); err != nil {
+			return
+		}
+	}
+	err = m.txn.HSetInt64(dbKey, collectionNameKey(collectionInfo.Name), collectionInfo.ID)
 	return
 }
 
-// ListCollections shows all collections in database.
-func (m *Meta) ListCollections(dbID int64) (collections []*model.CollectionInfo, err error) {
+// checkCollectionNameNotExists is checkCollectionNotExists by name,
+// which also finds collections created before the name index.
+func (m *Meta) checkCollectionNameNotExists(dbID int64, name string) (err error) {
 	dbKey := dbKeyByID(dbID)
-	if err = m.checkDBExists(dbKey); err != nil {
+	if err = m.checkCollectionNotExists(dbKey, collectionNameKey(name)); err != nil {
 		return
 	}
 
-	res, err := m.txn.HGetAll(dbKey)
-	if err != nil {
+	_, err = m.scanCollectionByName(dbID, name)
+	if err == ErrCollectionNotExists {
+		err = nil
+	} else if err == nil {
+		err = ErrCollectionExists
+	}
+	return
+}
+
+// delCollectionName removes name from the collection name index if it points at collectionID
+func (m *Meta) delCollectionName(dbKey []byte, name string, collectionID int64) (err error) {
+	nameKey := collectionNameKey(name)
+	id, err := m.txn.HGetInt64(dbKey, nameKey)
+	if err == kv.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil || id != collectionID {
 		return
 	}
 
-	collections = make([]*model.CollectionInfo, 0, len(res)/2)
-	for _, r := range res {
-		// only handle collection meta
-		if !bytes.HasPrefix(r.Field, collectionInfoPrefix) {
-			continue
-		}
+	err = m.txn.HDel(dbKey, nameKey)
+	return
+}
+
+// ListCollections shows all collections in database.
+func (m *Meta) ListCollections(dbID int64) (collections []*model.CollectionInfo, err error) {
+	collections, err = m.ListCollectionsWithOption(dbID, ListOption{})
+	return
+}
+
+// ListCollectionsWithOption is like ListCollections but fails with ErrTooManyItems beyond option.MaxItems.
+func (m *Meta) ListCollectionsWithOption(dbID int64, option ListOption) (collections []*model.CollectionInfo, err error) {
+	collections, next, err := m.ListCollectionsPage(dbID, nil, option.MaxItems)
+	if err == nil && next != nil {
+		collections = nil
+		err = ErrTooManyItems
+	}
+	return
+}
+
+// ListCollectionsPage lists at most limit collections in database after cursor, limit <= 0 means no limit.
+// Pass nil cursor for the first page and next for the next page, next is nil if there're no more.
+func (m *Meta) ListCollectionsPage(dbID int64, cursor []byte, limit int) (collections []*model.CollectionInfo, next []byte, err error) {
+	dbKey := dbKeyByID(dbID)
+	if err = m.checkDBExists(dbKey); err != nil {
+		return
+	}
 
+	collections = []*model.CollectionInfo{}
+	// other fields of db like collection names and did sequences are not scanned
+	next, err = m.listHash(dbKey, collectionInfoFieldPrefix, cursor, limit, func(value []byte) (err error) {
 		tbInfo := &model.CollectionInfo{}
-		err = json.Unmarshal(r.Value, tbInfo)
+		err = json.Unmarshal(value, tbInfo)
 		if err != nil {
 			return
 		}
-
 		collections = append(collections, tbInfo)
+		return
+	})
+	if err != nil {
+		collections = nil
 	}
+	return
+}
 
+// CollectionCount returns the number of collections in database by a keys only scan of their meta.
+func (m *Meta) CollectionCount(dbID int64) (n int64, err error) {
+	dbKey := dbKeyByID(dbID)
+	if err = m.checkDBExists(dbKey); err != nil {
+		return
+	}
+
+	n, err = m.txn.HCountPrefix(dbKey, collectionInfoFieldPrefix)
 	return
 }
 
 // ListDatabases shows all databases.
 func (m *Meta) ListDatabases() (dbs []*model.DBInfo, err error) {
-	res, err := m.txn.HGetAll(dbsKey)
-	if err != nil {
-		return
+	dbs, err = m.ListDatabasesWithOption(ListOption{})
+	return
+}
+
+// ListDatabasesWithOption is like ListDatabases but fails with ErrTooManyItems beyond option.MaxItems.
+func (m *Meta) ListDatabasesWithOption(option ListOption) (dbs []*model.DBInfo, err error) {
+	dbs, next, err := m.ListDatabasesPage(nil, option.MaxItems)
+	if err == nil && next != nil {
+		dbs = nil
+		err = ErrTooManyItems
 	}
+	return
+}
 
-	dbs = make([]*model.DBInfo, 0, len(res))
-	for _, r := range res {
+// ListDatabasesPage is like ListCollectionsPage but for databases.
+func (m *Meta) ListDatabasesPage(cursor []byte, limit int) (dbs []*model.DBInfo, next []byte, err error) {
+	dbs = []*model.DBInfo{}
+	next, err = m.listHash(dbsKey, nil, cursor, limit, func(value []byte) (err error) {
 		dbInfo := &model.DBInfo{}
-		err = json.Unmarshal(r.Value, dbInfo)
+		err = json.Unmarshal(value, dbInfo)
 		if err != nil {
 			return
 		}
 		dbs = append(dbs, dbInfo)
+		return
+	})
+	if err != nil {
+		dbs = nil
+	}
+	return
+}
+
+// DatabaseCount returns the number of databases by the field count of their hash.
+func (m *Meta) DatabaseCount() (n int64, err error) {
+	n, err = m.txn.HLen(dbsKey)
+	return
+}
+
+// listHash calls decode for values of fields with fieldPrefix after cursor, at most limit of them if positive,
+// next is the field of the last one decoded if there're more.
+func (m *Meta) listHash(key, fieldPrefix, cursor []byte, limit int, decode func(value []byte) error) (next []byte, err error) {
+	var (
+		n     int
+		last  []byte
+		dcErr error
+	)
+	err = m.txn.HScanAfter(key, fieldPrefix, cursor, func(field, value []byte) bool {
+		if limit > 0 && n == limit {
+			next = last
+			return false
+		}
+
+		dcErr = decode(value)
+		if dcErr != nil {
+			return false
+		}
+		n++
+		last = append(last[:0], field...)
+		return true
+	})
+	if dcErr != nil {
+		err = dcErr
+	}
+	if err != nil {
+		next = nil
 	}
 	return
 }
@@ -374,6 +598,81 @@ func (m *Meta) GetCollection(dbID int64, collectionID int64) (collectionnfo *mod
 	return
 }
 
+// GetDatabaseByName gets the database value with name.
+func (m *Meta) GetDatabaseByName(name string) (dbInfo *model.DBInfo, err error) {
+	dbID, err := m.txn.HGetInt64(dbNamesKey, []byte(name))
+	if err == kv.ErrKeyNotFound {
+		dbInfo, err = m.scanDatabaseByName(name)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	dbInfo, err = m.GetDatabase(dbID)
+	if err == ErrDBNotExists {
+		err = ErrDBNameIndexCorrupt
+	}
+	return
+}
+
+// GetCollectionByName gets the collection value in database with name.
+func (m *Meta) GetCollectionByName(dbID int64, name string) (collectionInfo *model.CollectionInfo, err error) {
+	// Check if db exists.
+	dbKey := dbKeyByID(dbID)
+	if err = m.checkDBExists(dbKey); err != nil {
+		return
+	}
+
+	collectionID, err := m.txn.HGetInt64(dbKey, collectionNameKey(name))
+	if err == kv.ErrKeyNotFound {
+		collectionInfo, err = m.scanCollectionByName(dbID, name)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	collectionInfo, err = m.GetCollection(dbID, collectionID)
+	if err == ErrCollectionNotExists {
+		err = ErrCollectionNameIndexCorrupt
+	}
+	return
+}
+
+// scanDatabaseByName finds the database with name by listing all databases,
+// for those missing in the name index, e.g., created before it.
+func (m *Meta) scanDatabaseByName(name string) (dbInfo *model.DBInfo, err error) {
+	dbs, err := m.ListDatabases()
+	if err != nil {
+		return
+	}
+	for _, db := range dbs {
+		if db.Name == name {
+			dbInfo = db
+			return
+		}
+	}
+	err = ErrDBNotExists
+	return
+}
+
+// scanCollectionByName is scanDatabaseByName for collections in database dbID.
+func (m *Meta) scanCollectionByName(dbID int64, name string) (collectionInfo *model.CollectionInfo, err error) {
+	collections, err := m.ListCollections(dbID)
+	if err != nil {
+		return
+	}
+	for _, c := range collections {
+		if c.Name == name {
+			collectionInfo = c
+			return
+		}
+	}
+	err = ErrCollectionNotExists
+	return
+}
+
 // GetBootstrapVersion returns the version of the server which bootstrap the store.
 // If the store is not bootstraped, the version will be zero.
 func (m *Meta) GetBootstrapVersion() (ver int64, err error) {
@@ -425,12 +724,14 @@ func (m *Meta) SetSchemaDiff(diff *model.SchemaDiff) (err error) {
 //	DDLJobList: list jobs
 //	DDLJobHistory: hash
 //	DDLJobReorg: hash
+//	DDLDeleteRange: hash
 
 var (
 	ddlJobListKey       = []byte("DDLJobList")
 	ddlJobAddIdxListKey = []byte("DDLJobAddIdxList")
 	ddlJobHistoryKey    = []byte("DDLJobHistory")
 	ddlJobReorgKey      = []byte("DDLJobReorg")
+	ddlDeleteRangeKey   = []byte("DDLDeleteRange")
 )
 
 // JobListKeyType is a key type of the DDL job queue.
@@ -620,7 +921,7 @@ func (m *Meta) GetAllHistoryDDLJobs() (jobs []*model.Job, err error) {
 	return
 }
 
-// GetLastNHistoryDDLJobs gets latest N history ddl jobs.
+// GetLastNHistoryDDLJobs gets latest N history ddl jobs in descending id order.
 func (m *Meta) GetLastNHistoryDDLJobs(num int) (jobs []*model.Job, err error) {
 	pairs, err := m.txn.HGetNDesc(ddlJobHistoryKey, num)
 	if err != nil {
@@ -630,6 +931,53 @@ func (m *Meta) GetLastNHistoryDDLJobs(num int) (jobs []*model.Job, err error) {
 	return
 }
 
+// GetHistoryDDLJobsInRange gets history DDL jobs with ids in [startID, endID] in ascending id order,
+// ids are not contiguous since they're allocated from the global id space.
+func (m *Meta) GetHistoryDDLJobsInRange(startID, endID int64) (jobs []*model.Job, err error) {
+	if startID > endID {
+		return
+	}
+
+	var end []byte
+	if endID < math.MaxInt64 {
+		end = m.historyJobIDKey(endID + 1)
+	}
+	pairs, err := m.txn.HGetRange(ddlJobHistoryKey, m.historyJobIDKey(startID), end)
+	if err != nil {
+		return
+	}
+	jobs, err = decodeJobs(pairs)
+	return
+}
+
+// PruneHistoryDDLJobs deletes the oldest history DDL jobs beyond the latest keepLast ones in the txn,
+// nothing is deleted if there are no more than keepLast jobs.
+func (m *Meta) PruneHistoryDDLJobs(keepLast int) (removed int, err error) {
+	if keepLast < 0 {
+		keepLast = 0
+	}
+
+	n, err := m.txn.HLen(ddlJobHistoryKey)
+	if err != nil || n <= int64(keepLast) {
+		return
+	}
+
+	pairs, err := m.txn.HGetN(ddlJobHistoryKey, int(n)-keepLast)
+	if err != nil {
+		return
+	}
+	fields := make([][]byte, 0, len(pairs))
+	for _, pair := range pairs {
+		fields = append(fields, pair.Field)
+	}
+	err = m.txn.HDel(ddlJobHistoryKey, fields...)
+	if err != nil {
+		return
+	}
+	removed = len(fields)
+	return
+}
+
 func (m *Meta) reorgJobStartHandle(id int64) []byte {
 	return numeric.Encode2Binary(uint64(id), nil)
 }
@@ -667,6 +1015,12 @@ func (m *Meta) RemoveDDLReorgHandle(job *model.Job) (err error) {
 	return
 }
 
+// GetDDLReorgStartHandle gets the latest processed start handle, kv.ErrKeyNotFound if not saved yet.
+func (m *Meta) GetDDLReorgStartHandle(job *model.Job) (startHandle int64, err error) {
+	startHandle, err = m.txn.HGetInt64(ddlJobReorgKey, m.reorgJobStartHandle(job.ID))
+	return
+}
+
 // GetDDLReorgHandle gets the latest processed DDL reorganize position.
 func (m *Meta) GetDDLReorgHandle(job *model.Job) (startHandle, endHandle int64, err error) {
 	startHandle, err = m.txn.HGetInt64(ddlJobReorgKey, m.reorgJobStartHandle(job.ID))
@@ -679,3 +1033,55 @@ func (m *Meta) GetDDLReorgHandle(job *model.Job) (startHandle, endHandle int64,
 	}
 	return
 }
+
+func (m *Meta) deleteRangeIDKey(id int64) []byte {
+	return numeric.Encode2Binary(uint64(id), nil)
+}
+
+// AddDeleteRange persists a range to be deleted by gc worker, r.ID is allocated if 0.
+func (m *Meta) AddDeleteRange(r *model.DeleteRange) (err error) {
+	if r.ID == 0 {
+		r.ID, err = m.GenGlobalID()
+		if err != nil {
+			return
+		}
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	err = m.txn.HSet(ddlDeleteRangeKey, m.deleteRangeIDKey(r.ID), b)
+	return
+}
+
+// ListDeleteRanges returns all pending delete ranges in the order they're added.
+func (m *Meta) ListDeleteRanges() (ranges []*model.DeleteRange, err error) {
+	pairs, err := m.txn.HGetAll(ddlDeleteRangeKey)
+	if err != nil {
+		return
+	}
+
+	ranges = make([]*model.DeleteRange, 0, len(pairs))
+	for _, pair := range pairs {
+		r := &model.DeleteRange{}
+		err = json.Unmarshal(pair.Value, r)
+		if err != nil {
+			return
+		}
+		ranges = append(ranges, r)
+	}
+	return
+}
+
+// RemoveDeleteRange removes a delete range once it's done.
+func (m *Meta) RemoveDeleteRange(id int64) (err error) {
+	err = m.txn.HDel(ddlDeleteRangeKey, m.deleteRangeIDKey(id))
+	return
+}
+
+// DeleteRangeCount returns the number of pending delete ranges.
+func (m *Meta) DeleteRangeCount() (n int64, err error) {
+	n, err = m.txn.HLen(ddlDeleteRangeKey)
+	return
+}
@@ -0,0 +1,225 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/provider"
+	"github.com/zhiqiangxu/util/osc"
+	"gotest.tools/assert"
+)
+
+// newCollectionsFixture creates db 1 with n collections and their did sequences
+func newCollectionsFixture(t assert.TestingT, n int) mondis.KVDB {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+
+	txn := kvdb.NewTransaction(true)
+	m := NewMeta(txn)
+	err = m.CreateDatabase(&model.DBInfo{ID: 1, Name: "db", State: osc.StatePublic})
+	assert.Assert(t, err == nil)
+	for i := 0; i < n; i++ {
+		cid := int64(i + 2)
+		err = m.CreateCollection(1, &model.CollectionInfo{ID: cid, Name: fmt.Sprintf("c%d", i), State: osc.StatePublic})
+		assert.Assert(t, err == nil)
+		err = m.txn.HSetInt64(dbKeyByID(1), didSequenceKeyByID(cid), 1)
+		assert.Assert(t, err == nil)
+	}
+	err = txn.Commit()
+	assert.Assert(t, err == nil)
+	return kvdb
+}
+
+func TestListCollections(t *testing.T) {
+	kvdb := newCollectionsFixture(t, 20)
+	defer kvdb.Close()
+
+	txn := kvdb.NewTransaction(false)
+	defer txn.Discard()
+	m := NewMeta(txn)
+
+	collections, err := m.ListCollections(1)
+	assert.Assert(t, err == nil && len(collections) == 20)
+	// fields are ordered bytewise
+	assert.Assert(t, collections[0].ID == 10 && collections[19].ID == 9)
+	n, err := m.CollectionCount(1)
+	assert.Assert(t, err == nil && n == 20)
+
+	_, err = m.ListCollections(2)
+	assert.Assert(t, err == ErrDBNotExists)
+	_, err = m.CollectionCount(2)
+	assert.Assert(t, err == ErrDBNotExists)
+}
+
+func TestListGuardrail(t *testing.T) {
+	kvdb := newCollectionsFixture(t, 20)
+	defer kvdb.Close()
+
+	txn := kvdb.NewTransaction(true)
+	defer txn.Discard()
+	m := NewMeta(txn)
+	for i := int64(100); i < 104; i++ {
+		err := m.CreateDatabase(&model.DBInfo{ID: i, Name: fmt.Sprintf("db%d", i), State: osc.StatePublic})
+		assert.Assert(t, err == nil)
+	}
+
+	_, err := m.ListCollectionsWithOption(1, ListOption{MaxItems: 19})
+	assert.Assert(t, err == ErrTooManyItems)
+	collections, err := m.ListCollectionsWithOption(1, ListOption{MaxItems: 20})
+	assert.Assert(t, err == nil && len(collections) == 20)
+
+	n, err := m.DatabaseCount()
+	assert.Assert(t, err == nil && n == 5)
+	_, err = m.ListDatabasesWithOption(ListOption{MaxItems: 4})
+	assert.Assert(t, err == ErrTooManyItems)
+	dbs, err := m.ListDatabasesWithOption(ListOption{MaxItems: 5})
+	assert.Assert(t, err == nil && len(dbs) == 5)
+
+	// pages cover all collections in the same order
+	var (
+		cursor []byte
+		pages  int
+		paged  []*model.CollectionInfo
+	)
+	for {
+		var page []*model.CollectionInfo
+		page, cursor, err = m.ListCollectionsPage(1, cursor, 6)
+		assert.Assert(t, err == nil && len(page) <= 6)
+		paged = append(paged, page...)
+		pages++
+		if cursor == nil {
+			break
+		}
+	}
+	assert.Assert(t, pages == 4 && len(paged) == 20)
+	for i := range paged {
+		assert.Assert(t, paged[i].ID == collections[i].ID)
+	}
+
+	dbs, cursor, err = m.ListDatabasesPage(nil, 3)
+	assert.Assert(t, err == nil && len(dbs) == 3 && cursor != nil)
+	dbs, cursor, err = m.ListDatabasesPage(cursor, 3)
+	assert.Assert(t, err == nil && len(dbs) == 2 && cursor == nil)
+
+	// counts follow drops
+	err = m.DropCollection(1, collections[0].ID, true)
+	assert.Assert(t, err == nil)
+	n, err = m.CollectionCount(1)
+	assert.Assert(t, err == nil && n == 19)
+	err = m.DropDatabase(100)
+	assert.Assert(t, err == nil)
+	n, err = m.DatabaseCount()
+	assert.Assert(t, err == nil && n == 4)
+}
+
+func TestNameIndexUpgrade(t *testing.T) {
+	kvdb := newCollectionsFixture(t, 2)
+	defer kvdb.Close()
+
+	txn := kvdb.NewTransaction(true)
+	defer txn.Discard()
+	m := NewMeta(txn)
+
+	// stores created before the name index have no entries
+	err := m.txn.HDel(dbNamesKey, []byte("db"))
+	assert.Assert(t, err == nil)
+	err = m.txn.HDel(dbKeyByID(1), collectionNameKey("c0"))
+	assert.Assert(t, err == nil)
+
+	dbInfo, err := m.GetDatabaseByName("db")
+	assert.Assert(t, err == nil && dbInfo.ID == 1, err)
+	_, err = m.GetDatabaseByName("none")
+	assert.Assert(t, err == ErrDBNotExists)
+	collectionInfo, err := m.GetCollectionByName(1, "c0")
+	assert.Assert(t, err == nil && collectionInfo.ID == 2, err)
+	_, err = m.GetCollectionByName(1, "none")
+	assert.Assert(t, err == ErrCollectionNotExists)
+
+	err = m.CreateDatabase(&model.DBInfo{ID: 100, Name: "db", State: osc.StatePublic})
+	assert.Assert(t, err == ErrDBExists, err)
+	err = m.CreateCollection(1, &model.CollectionInfo{ID: 100, Name: "c0", State: osc.StatePublic})
+	assert.Assert(t, err == ErrCollectionExists, err)
+	err = m.UpdateCollection(1, &model.CollectionInfo{ID: 3, Name: "c0", State: osc.StatePublic})
+	assert.Assert(t, err == ErrCollectionExists, err)
+
+	// a stale entry is reported too
+	err = m.txn.HSetInt64(dbNamesKey, []byte("gone"), 100)
+	assert.Assert(t, err == nil)
+
+	_, issues, err := m.CheckDatabases(true)
+	assert.Assert(t, err == nil && len(issues) == 2, issues)
+	assert.Assert(t, issues[0].Repaired && issues[1].Repaired, issues)
+	issues, err = m.CheckDatabase(1, true)
+	assert.Assert(t, err == nil && len(issues) == 1 && issues[0].Repaired, issues)
+
+	_, issues, err = m.CheckDatabases(false)
+	assert.Assert(t, err == nil && len(issues) == 0, issues)
+	issues, err = m.CheckDatabase(1, false)
+	assert.Assert(t, err == nil && len(issues) == 0, issues)
+	id, err := m.txn.HGetInt64(dbNamesKey, []byte("db"))
+	assert.Assert(t, err == nil && id == 1)
+}
+
+// listCollectionsByHGetAll is how ListCollections used to be done
+func listCollectionsByHGetAll(m *Meta, dbID int64) (collections []*model.CollectionInfo, err error) {
+	res, err := m.txn.HGetAll(dbKeyByID(dbID))
+	if err != nil {
+		return
+	}
+
+	for _, r := range res {
+		if !bytes.HasPrefix(r.Field, collectionInfoPrefix) {
+			continue
+		}
+		tbInfo := &model.CollectionInfo{}
+		err = json.Unmarshal(r.Value, tbInfo)
+		if err != nil {
+			return
+		}
+		collections = append(collections, tbInfo)
+	}
+	return
+}
+
+func BenchmarkListCollections(b *testing.B) {
+	const n = 10000
+	kvdb := newCollectionsFixture(b, n)
+	defer kvdb.Close()
+
+	list := map[string]func(m *Meta) ([]*model.CollectionInfo, error){
+		"HGetAll": func(m *Meta) ([]*model.CollectionInfo, error) {
+			return listCollectionsByHGetAll(m, 1)
+		},
+		"HScan": func(m *Meta) ([]*model.CollectionInfo, error) {
+			return m.ListCollections(1)
+		},
+	}
+	for _, name := range []string{"HGetAll", "HScan"} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				txn := kvdb.NewTransaction(false)
+				collections, err := list[name](NewMeta(txn))
+				txn.Discard()
+				if err != nil || len(collections) != n {
+					b.Fatal(err, len(collections))
+				}
+			}
+		})
+	}
+
+	b.Run("CollectionCount", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			txn := kvdb.NewTransaction(false)
+			count, err := NewMeta(txn).CollectionCount(1)
+			txn.Discard()
+			if err != nil || count != n {
+				b.Fatal(err, count)
+			}
+		}
+	})
+}
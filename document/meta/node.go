@@ -0,0 +1,351 @@
+package meta
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// GenNodeEpoch increases the node epoch and returns it, epochs are never reused
+func (m *Meta) GenNodeEpoch() (int64, error) {
+	return m.txn.Inc(nodeEpochKey, 1)
+}
+
+// GetNode returns the node record of id, nil if not exists
+func (m *Meta) GetNode(id string) (node *model.NodeInfo, err error) {
+	value, err := m.txn.HGet(nodesKey, []byte(id))
+	if err == kv.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	node = &model.NodeInfo{}
+	err = json.Unmarshal(value, node)
+	return
+}
+
+// SetNode creates or replaces the node record of node.ID
+func (m *Meta) SetNode(node *model.NodeInfo) (err error) {
+	b, err := json.Marshal(node)
+	if err != nil {
+		return
+	}
+	err = m.txn.HSet(nodesKey, []byte(node.ID), b)
+	return
+}
+
+// DeleteNode removes the node record of id
+func (m *Meta) DeleteNode(id string) (err error) {
+	err = m.txn.HDel(nodesKey, []byte(id))
+	return
+}
+
+// ListNodes returns all node records ordered by id, expired ones included
+func (m *Meta) ListNodes() (nodes []*model.NodeInfo, err error) {
+	pairs, err := m.txn.HGetAll(nodesKey)
+	if err != nil {
+		return
+	}
+
+	nodes = make([]*model.NodeInfo, 0, len(pairs))
+	for _, pair := range pairs {
+		node := &model.NodeInfo{}
+		err = json.Unmarshal(pair.Value, node)
+		if err != nil {
+			return
+		}
+		nodes = append(nodes, node)
+	}
+	return
+}
+
+const (
+	defaultNodeTTL = time.Second * 30
+	// nodeMaxRetries is the max retries on conflict with other nodes updating the registry
+	nodeMaxRetries = 10
+)
+
+var (
+	// ErrNodeFenced when the node record is re-claimed by a later registration of the same id, or removed after expiry,
+	// the node should stop the coordination work it does since another may have taken it over.
+	ErrNodeFenced = errors.New("node fenced")
+	// ErrNodeNotRegistered when NodeRegistry is used before Register
+	ErrNodeNotRegistered = errors.New("node not registered")
+)
+
+// NodeRegistryOption for NodeRegistry
+type NodeRegistryOption struct {
+	// ID of the node, a random UUID if empty. Registering with the ID of a previous process re-claims its record.
+	ID string
+	// Roles of the node, see model.NodeInfo.Roles
+	Roles []string
+	// TTL is how long the record is kept without heartbeat, which is sent every TTL/3, 0 means defaultNodeTTL
+	TTL time.Duration
+}
+
+// NodeRegistry registers the process as a node in meta and keeps its record alive by heartbeats
+type NodeRegistry struct {
+	kvdb   mondis.KVDB
+	option NodeRegistryOption
+	mu     sync.Mutex
+	node   *model.NodeInfo
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewNodeRegistry is ctor for NodeRegistry
+func NewNodeRegistry(kvdb mondis.KVDB, option NodeRegistryOption) *NodeRegistry {
+	if option.TTL <= 0 {
+		option.TTL = defaultNodeTTL
+	}
+	return &NodeRegistry{kvdb: kvdb, option: option}
+}
+
+// Register writes the record of the node with an epoch greater than any before, and starts heartbeats.
+// Expired records of other nodes are removed meanwhile.
+// It can be called again, e.g., once fenced, to re-claim the record with a new epoch.
+func (r *NodeRegistry) Register() (node model.NodeInfo, err error) {
+	r.stopHeartbeat()
+
+	id := r.option.ID
+	if id == "" {
+		id, err = newUUID()
+		if err != nil {
+			return
+		}
+	}
+	host, _ := os.Hostname()
+	now := time.Now()
+	record := &model.NodeInfo{ID: id, Host: host, PID: os.Getpid(), StartTime: now, Roles: r.option.Roles, Heartbeat: now, TTL: r.option.TTL}
+
+	err = util.RunInNewUpdateTxnWithRetry(r.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := NewMeta(txn)
+		_, err = m.expireNodes(now)
+		if err != nil {
+			return
+		}
+		record.Epoch, err = m.GenNodeEpoch()
+		if err != nil {
+			return
+		}
+		err = m.SetNode(record)
+		return
+	}, nodeMaxRetries)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.node = record
+	r.mu.Unlock()
+	logger.Instance().Info("node registered", zap.String("id", record.ID), zap.Int64("epoch", record.Epoch), zap.Strings("roles", record.Roles))
+
+	r.stopCh = make(chan struct{})
+	r.wg.Add(1)
+	go r.heartbeatLoop(record.ID, r.stopCh)
+
+	node = *record
+	return
+}
+
+// Node returns the registered record, as of the last heartbeat
+func (r *NodeRegistry) Node() (node model.NodeInfo, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.node == nil {
+		err = ErrNodeNotRegistered
+		return
+	}
+	node = *r.node
+	return
+}
+
+func (r *NodeRegistry) heartbeatLoop(id string, stopCh chan struct{}) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.option.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			err := r.Heartbeat()
+			if err == ErrNodeFenced {
+				logger.Instance().Error("node fenced", zap.String("id", id))
+				return
+			}
+			if err != nil {
+				logger.Instance().Warn("node heartbeat", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Heartbeat refreshes the record, ErrNodeFenced is returned if it's no longer of the node
+func (r *NodeRegistry) Heartbeat() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.node == nil {
+		err = ErrNodeNotRegistered
+		return
+	}
+
+	now := time.Now()
+	err = util.RunInNewUpdateTxnWithRetry(r.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := NewMeta(txn)
+		record, err := r.checkEpoch(m)
+		if err != nil {
+			return
+		}
+		record.Heartbeat = now
+		err = m.SetNode(record)
+		return
+	}, nodeMaxRetries)
+	if err != nil {
+		return
+	}
+	r.node.Heartbeat = now
+	return
+}
+
+// CheckEpoch returns ErrNodeFenced unless the record in txn is still of the node,
+// coordination features do it in the txn of their writes so that a fenced node can't overwrite its successor.
+func (r *NodeRegistry) CheckEpoch(txn mondis.ProviderTxn) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.node == nil {
+		err = ErrNodeNotRegistered
+		return
+	}
+	_, err = r.checkEpoch(NewMeta(txn))
+	return
+}
+
+// checkEpoch is CheckEpoch with r.mu held, which returns the record as well
+func (r *NodeRegistry) checkEpoch(m *Meta) (record *model.NodeInfo, err error) {
+	record, err = m.GetNode(r.node.ID)
+	if err != nil {
+		return
+	}
+	if record == nil || record.Epoch != r.node.Epoch {
+		err = ErrNodeFenced
+	}
+	return
+}
+
+// stopHeartbeat stops heartbeats without touching the record, it's what happens when the process crashes
+func (r *NodeRegistry) stopHeartbeat() {
+	if r.stopCh == nil {
+		return
+	}
+	close(r.stopCh)
+	r.stopCh = nil
+	r.wg.Wait()
+}
+
+// Close stops heartbeats and removes the record if it's still of the node
+func (r *NodeRegistry) Close() (err error) {
+	r.stopHeartbeat()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.node == nil {
+		return
+	}
+	err = util.RunInNewUpdateTxnWithRetry(r.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := NewMeta(txn)
+		_, err = r.checkEpoch(m)
+		if err == ErrNodeFenced {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		err = m.DeleteNode(r.node.ID)
+		return
+	}, nodeMaxRetries)
+	r.node = nil
+	return
+}
+
+// expireNodes removes records whose heartbeats are older than their TTL as of now
+func (m *Meta) expireNodes(now time.Time) (n int, err error) {
+	nodes, err := m.ListNodes()
+	if err != nil {
+		return
+	}
+	for _, node := range nodes {
+		if !nodeExpired(node, now) {
+			continue
+		}
+		err = m.DeleteNode(node.ID)
+		if err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+func nodeExpired(node *model.NodeInfo, now time.Time) bool {
+	return now.After(node.Heartbeat.Add(node.TTL))
+}
+
+// ListNodes returns the live nodes registered in kvdb ordered by epoch, expired records are removed
+func ListNodes(kvdb mondis.KVDB) (nodes []*model.NodeInfo, err error) {
+	err = util.RunInNewUpdateTxnWithRetry(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := NewMeta(txn)
+		now := time.Now()
+		n, err := m.expireNodes(now)
+		if err != nil {
+			return
+		}
+		if n > 0 {
+			logger.Instance().Info("nodes expired", zap.Int("n", n))
+		}
+		nodes, err = m.ListNodes()
+		return
+	}, nodeMaxRetries)
+	if err != nil {
+		return
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Epoch < nodes[j].Epoch
+	})
+	return
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() (id string, err error) {
+	var b [16]byte
+	_, err = rand.Read(b[:])
+	if err != nil {
+		return
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	id = fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	return
+}
@@ -0,0 +1,62 @@
+package meta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/provider"
+	"github.com/zhiqiangxu/mondis/util"
+	"gotest.tools/assert"
+)
+
+func TestNodeRegistry(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	const ttl = time.Millisecond * 150
+	a := NewNodeRegistry(kvdb, NodeRegistryOption{Roles: []string{"owner"}, TTL: ttl})
+	nodeA, err := a.Register()
+	assert.Assert(t, err == nil && len(nodeA.ID) == 36 && nodeA.Epoch == 1, nodeA)
+	defer a.Close()
+	b := NewNodeRegistry(kvdb, NodeRegistryOption{ID: "b", Roles: []string{"replica"}, TTL: ttl})
+	nodeB, err := b.Register()
+	assert.Assert(t, err == nil && nodeB.ID == "b" && nodeB.Epoch == 2, nodeB)
+
+	nodes, err := ListNodes(kvdb)
+	assert.Assert(t, err == nil && len(nodes) == 2)
+	assert.Assert(t, nodes[0].ID == nodeA.ID && nodes[0].Roles[0] == "owner" && nodes[1].ID == "b" && nodes[1].Roles[0] == "replica")
+
+	// b crashes, a is kept alive by heartbeats
+	b.stopHeartbeat()
+	time.Sleep(ttl * 2)
+	nodes, err = ListNodes(kvdb)
+	assert.Assert(t, err == nil && len(nodes) == 1 && nodes[0].ID == nodeA.ID, nodes)
+	assert.Assert(t, b.Heartbeat() == ErrNodeFenced)
+	err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) error {
+		assert.Assert(t, a.CheckEpoch(txn) == nil)
+		assert.Assert(t, b.CheckEpoch(txn) == ErrNodeFenced)
+		return nil
+	})
+	assert.Assert(t, err == nil)
+
+	// b restarts with the same id
+	b2 := NewNodeRegistry(kvdb, NodeRegistryOption{ID: "b", TTL: ttl})
+	nodeB2, err := b2.Register()
+	assert.Assert(t, err == nil && nodeB2.Epoch == 3, nodeB2)
+	defer b2.Close()
+	// the stale process comes back and re-claims it, which fences b2 off
+	nodeB, err = b.Register()
+	assert.Assert(t, err == nil && nodeB.Epoch == 4, nodeB)
+	assert.Assert(t, b2.Heartbeat() == ErrNodeFenced)
+	assert.Assert(t, b.Close() == nil)
+	// nothing of b2 to remove
+	assert.Assert(t, b2.Close() == nil)
+
+	nodes, err = ListNodes(kvdb)
+	assert.Assert(t, err == nil && len(nodes) == 1 && nodes[0].ID == nodeA.ID, nodes)
+	_, err = b.Node()
+	assert.Assert(t, err == ErrNodeNotRegistered)
+}
@@ -0,0 +1,48 @@
+package document
+
+import "time"
+
+// MetricOp is the kind of a collection operation reported to Metrics
+type MetricOp int
+
+const (
+	// MetricOpInsert for InsertOne, InsertMany and InsertOneManaged
+	MetricOpInsert MetricOp = iota
+	// MetricOpUpdate for UpdateOne, UpsertOne, Merge and MergeUpsert
+	MetricOpUpdate
+	// MetricOpDelete for DeleteOne
+	MetricOpDelete
+	// MetricOpGet for GetOne, GetOneWithVersion, GetMany, GetManyPartial and GetRange
+	MetricOpGet
+)
+
+func (op MetricOp) String() string {
+	switch op {
+	case MetricOpInsert:
+		return "insert"
+	case MetricOpUpdate:
+		return "update"
+	case MetricOpDelete:
+		return "delete"
+	case MetricOpGet:
+		return "get"
+	default:
+		return "unknown"
+	}
+}
+
+// Metrics is the sink of DBOption.Metrics, methods are called synchronously by the operations reported,
+// so they should be cheap and safe for concurrent use.
+type Metrics interface {
+	// ObserveOp is called once op on collection returns, err is what's returned to the caller
+	ObserveOp(collection string, op MetricOp, err error, latency time.Duration)
+	// SequenceLeaseExtended is called once the sequence of keyword leases a new band after the initial one
+	SequenceLeaseExtended(keyword string)
+	// CollectionCacheAccess is called once a collection is looked up by name, hit if it's opened already
+	CollectionCacheAccess(hit bool)
+}
+
+// observeOp reports op started at start to DBOption.Metrics, which must be set
+func (c *Collection) observeOp(op MetricOp, start time.Time, err *error) {
+	c.db.metrics.ObserveOp(c.name, op, *err, time.Since(start))
+}
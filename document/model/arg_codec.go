@@ -0,0 +1,106 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ArgVersion is the version of codec used to encode the arg of job and schema diff
+type ArgVersion uint16
+
+const (
+	// ArgVersionJSON encodes arg as json, args encoded before versioning are of this version
+	ArgVersionJSON ArgVersion = 0
+)
+
+// ArgCodec encodes and decodes the arg of job and schema diff
+type ArgCodec interface {
+	Marshal(arg interface{}) ([]byte, error)
+	Unmarshal(data []byte, arg interface{}) error
+}
+
+var (
+	// ErrArgCodecExists used by RegisterArgCodec
+	ErrArgCodecExists = errors.New("arg codec already registered")
+	// ErrArgCodecNotExists when no codec registered for the version
+	ErrArgCodecNotExists = errors.New("arg codec not registered")
+)
+
+var (
+	argCodecs         = map[ArgVersion]ArgCodec{ArgVersionJSON: jsonArgCodec{}}
+	currentArgVersion = ArgVersionJSON
+)
+
+// RegisterArgCodec registers codec for version, non thread safe, should be called before DDL starts
+func RegisterArgCodec(version ArgVersion, codec ArgCodec) (err error) {
+	if argCodecs[version] != nil {
+		err = ErrArgCodecExists
+		return
+	}
+	argCodecs[version] = codec
+	return
+}
+
+// SetArgVersion sets the version used to encode new args, non thread safe, should be called before DDL starts.
+// All nodes must have registered the codec before switching to it.
+func SetArgVersion(version ArgVersion) (err error) {
+	if argCodecs[version] == nil {
+		err = ErrArgCodecNotExists
+		return
+	}
+	currentArgVersion = version
+	return
+}
+
+// CurrentArgVersion returns the version used to encode new args
+func CurrentArgVersion() ArgVersion {
+	return currentArgVersion
+}
+
+type jsonArgCodec struct{}
+
+func (jsonArgCodec) Marshal(arg interface{}) ([]byte, error) {
+	return json.Marshal(arg)
+}
+
+func (jsonArgCodec) Unmarshal(data []byte, arg interface{}) error {
+	return json.Unmarshal(data, arg)
+}
+
+// encodeArg encodes arg with the current codec,
+// output of codecs other than json is wrapped as json string so that RawArg stays valid json.
+func encodeArg(arg interface{}) (version ArgVersion, raw json.RawMessage, err error) {
+	version = currentArgVersion
+	data, err := argCodecs[version].Marshal(arg)
+	if err != nil {
+		return
+	}
+	if version == ArgVersionJSON {
+		raw = data
+		return
+	}
+
+	raw, err = json.Marshal(data)
+	return
+}
+
+// decodeArg is reverse of encodeArg
+func decodeArg(version ArgVersion, raw json.RawMessage, arg interface{}) (err error) {
+	codec := argCodecs[version]
+	if codec == nil {
+		err = ErrArgCodecNotExists
+		return
+	}
+	if version == ArgVersionJSON {
+		err = codec.Unmarshal(raw, arg)
+		return
+	}
+
+	var data []byte
+	err = json.Unmarshal(raw, &data)
+	if err != nil {
+		return
+	}
+	err = codec.Unmarshal(data, arg)
+	return
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/zhiqiangxu/util/osc"
+	"gotest.tools/assert"
+)
+
+type gobArgCodec struct{}
+
+func (gobArgCodec) Marshal(arg interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(arg)
+	return buf.Bytes(), err
+}
+
+func (gobArgCodec) Unmarshal(data []byte, arg interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(arg)
+}
+
+func TestArgCodecVersion(t *testing.T) {
+	const gobVersion ArgVersion = 1
+	defer func() {
+		currentArgVersion = ArgVersionJSON
+		delete(argCodecs, gobVersion)
+	}()
+
+	arg := &IndexInfo{ID: 1, Name: "idx", Columns: []string{"a", "b.c"}, Unique: true, State: osc.StateWriteOnly}
+
+	// job encoded before versioning has no ArgVersion
+	legacy := []byte(`{"ID":1,"RawArg":{"ID":1,"Name":"idx","Columns":["a","b.c"],"Unique":true,"State":2}}`)
+
+	oldJob := &Job{ID: 2, Type: ActionAddIndex, Arg: arg}
+	oldBytes, err := oldJob.Encode()
+	assert.Assert(t, err == nil && oldJob.ArgVersion == ArgVersionJSON)
+
+	err = SetArgVersion(gobVersion)
+	assert.Assert(t, err == ErrArgCodecNotExists)
+	err = RegisterArgCodec(gobVersion, gobArgCodec{})
+	assert.Assert(t, err == nil)
+	err = RegisterArgCodec(gobVersion, gobArgCodec{})
+	assert.Assert(t, err == ErrArgCodecExists)
+	err = SetArgVersion(gobVersion)
+	assert.Assert(t, err == nil && CurrentArgVersion() == gobVersion)
+
+	newJob := &Job{ID: 3, Type: ActionAddIndex, Arg: arg}
+	newBytes, err := newJob.Encode()
+	assert.Assert(t, err == nil && newJob.ArgVersion == gobVersion)
+
+	for _, b := range [][]byte{legacy, oldBytes, newBytes} {
+		job := &Job{}
+		err = job.Decode(b)
+		assert.Assert(t, err == nil)
+		decoded := &IndexInfo{}
+		err = job.DecodeArg(decoded)
+		assert.Assert(t, err == nil)
+		assert.DeepEqual(t, decoded, arg)
+	}
+
+	// schema diff carries the version of job
+	diff := &SchemaDiff{Version: 1, Type: newJob.Type, Arg: newJob.Arg, RawArg: newJob.RawArg, ArgVersion: newJob.ArgVersion}
+	currentArgVersion = ArgVersionJSON
+	b, err := diff.Encode()
+	assert.Assert(t, err == nil)
+	diff = &SchemaDiff{}
+	err = diff.Decode(b)
+	assert.Assert(t, err == nil)
+	decoded := &IndexInfo{}
+	err = diff.DecodeArg(decoded)
+	assert.Assert(t, err == nil)
+	assert.DeepEqual(t, decoded, arg)
+
+	// unknown version
+	job := &Job{RawArg: newJob.RawArg, ArgVersion: gobVersion + 1}
+	err = job.DecodeArg(&IndexInfo{})
+	assert.Assert(t, err == ErrArgCodecNotExists)
+}
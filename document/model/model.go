@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/zhiqiangxu/util/osc"
 )
@@ -32,26 +33,63 @@ type (
 		Columns      []string
 		Unique       bool
 		State        osc.SchemaState
+		// Dropping is set once an ActionDropIndex job makes the index unusable, until it's removed or the job is cancelled
+		Dropping bool `json:",omitempty"`
 	}
 	// IndexInfoRedundant stores some redundant info
 	IndexInfoRedundant struct {
 		Collection string
 		DB         string
 		CID        int64
+		// Cleared is set by ActionRebuildIndex job once old entries are deleted
+		Cleared bool
+	}
+	// CreateCollectionArg is the arg of ActionCreateCollection job
+	CreateCollectionArg struct {
+		DBID       int64
+		Collection *CollectionInfo
+	}
+	// DropCollectionArg is the arg of ActionDropCollection job,
+	// Collection.State is updated as the job goes on.
+	DropCollectionArg struct {
+		DBID       int64
+		Collection *CollectionInfo
+	}
+	// NodeInfo is the identity of a process registered by meta.NodeRegistry
+	NodeInfo struct {
+		ID        string
+		Host      string
+		PID       int
+		StartTime time.Time
+		// Epoch is increased each time a node registers, so that a stale process with the same ID can be fenced off
+		Epoch int64
+		// Roles are what the node does, e.g., owner or replica, set by the coordination features it runs
+		Roles []string `json:",omitempty"`
+		// Heartbeat is when the node was last known alive, it's expired once Heartbeat+TTL is past
+		Heartbeat time.Time
+		TTL       time.Duration
+	}
+	// DeleteRange is a range of keys pending deletion by gc worker
+	DeleteRange struct {
+		ID     int64
+		Prefix []byte
 	}
 	// Job for a DDL operation
 	Job struct {
 		ID          int64
 		Type        ActionType
 		State       JobState
-		Error       error
+		Error       *JobError
 		ErrorCount  int64
 		Arg         interface{} `json:"-"`
 		RawArg      json.RawMessage
+		ArgVersion  ArgVersion
 		SchemaState osc.SchemaState
 		StartTS     uint64 `json:"start_ts"`
 		// DependencyID is the job's ID that the current job depends on.
 		DependencyID int64
+		// SchemaVersion is the latest schema version generated by the job
+		SchemaVersion int64
 	}
 	// SchemaDiff contains the schema modification at a particular schema version.
 	SchemaDiff struct {
@@ -60,9 +98,34 @@ type (
 		CollectionIDs []int64
 		Arg           interface{} `json:"-"`
 		RawArg        json.RawMessage
+		ArgVersion    ArgVersion
 	}
 )
 
+// CollectionKind is the kind of collection, which decides how documents are encoded.
+// It's kept by document.DB as m_ck[cid], collections created by DDL always store bson documents.
+type CollectionKind byte
+
+// List collection kinds.
+const (
+	// CollectionKindDocument stores bson documents
+	CollectionKindDocument CollectionKind = iota
+	// CollectionKindCounters stores int64 counters as 8-byte big-endian
+	CollectionKindCounters
+)
+
+// String return collection kind in string
+func (kind CollectionKind) String() string {
+	switch kind {
+	case CollectionKindDocument:
+		return "document"
+	case CollectionKindCounters:
+		return "counters"
+	default:
+		return "unknown"
+	}
+}
+
 // ActionType is the type for DDL action.
 type ActionType byte
 
@@ -77,6 +140,7 @@ const (
 	ActionDropIndex
 	ActionTruncateCollection
 	ActionRenameCollection
+	ActionRebuildIndex
 )
 
 var actionMap = map[ActionType]string{
@@ -88,6 +152,7 @@ var actionMap = map[ActionType]string{
 	ActionDropIndex:          "drop index",
 	ActionTruncateCollection: "truncate collection",
 	ActionRenameCollection:   "rename collection",
+	ActionRebuildIndex:       "rebuild index",
 }
 
 // String return current ddl action in string
@@ -151,6 +216,38 @@ func (db *DBInfo) UpdateCollectionInfo(ci *CollectionInfo) (ok bool) {
 	return
 }
 
+// AddCollectionInfo adds a collection to db
+func (db *DBInfo) AddCollectionInfo(ci *CollectionInfo) (ok bool) {
+	if db.Collections[ci.Name] != nil {
+		return
+	}
+
+	if db.Collections == nil {
+		db.Collections = make(map[string]*CollectionInfo)
+	}
+	db.Collections[ci.Name] = ci
+	db.CollectionOrder = append(db.CollectionOrder, ci.Name)
+	ok = true
+	return
+}
+
+// RemoveCollectionInfo removes a collection from db
+func (db *DBInfo) RemoveCollectionInfo(collectionName string) (ok bool) {
+	if db.Collections[collectionName] == nil {
+		return
+	}
+
+	delete(db.Collections, collectionName)
+	for i, cn := range db.CollectionOrder {
+		if cn == collectionName {
+			db.CollectionOrder = append(db.CollectionOrder[:i], db.CollectionOrder[i+1:]...)
+			break
+		}
+	}
+	ok = true
+	return
+}
+
 // CollectionExists check whether collection exists
 func (db *DBInfo) CollectionExists(collectionName string) bool {
 	return db.Collections[collectionName] != nil
@@ -206,12 +303,32 @@ func (c *CollectionInfo) AddIndexInfo(iif *IndexInfo) (ok bool) {
 		return
 	}
 
+	if c.Indices == nil {
+		c.Indices = make(map[string]*IndexInfo)
+	}
 	c.Indices[iif.Name] = iif.Clone()
 	c.IndexOrder = append(c.IndexOrder, iif.Name)
 	ok = true
 	return
 }
 
+// RemoveIndexInfo removes an index from collection
+func (c *CollectionInfo) RemoveIndexInfo(indexName string) (ok bool) {
+	if c.Indices[indexName] == nil {
+		return
+	}
+
+	delete(c.Indices, indexName)
+	for i, in := range c.IndexOrder {
+		if in == indexName {
+			c.IndexOrder = append(c.IndexOrder[:i], c.IndexOrder[i+1:]...)
+			break
+		}
+	}
+	ok = true
+	return
+}
+
 // IndexInfo returns the index info by name
 func (c *CollectionInfo) IndexInfo(indexName string) *IndexInfo {
 	return c.Indices[indexName]
@@ -236,10 +353,10 @@ func (ii *IndexInfo) Clone() *IndexInfo {
 	return &clone
 }
 
-// Encode encodes job with json format.
+// Encode encodes job with json format, the arg is encoded by the current ArgCodec.
 func (job *Job) Encode() (b []byte, err error) {
 	if len(job.RawArg) == 0 {
-		job.RawArg, err = json.Marshal(job.Arg)
+		job.ArgVersion, job.RawArg, err = encodeArg(job.Arg)
 		if err != nil {
 			return
 		}
@@ -257,13 +374,28 @@ func (job *Job) Decode(b []byte) (err error) {
 	return
 }
 
-// DecodeArg decodes job arg.
+// DecodeArg decodes job arg by the ArgCodec of job.ArgVersion.
 func (job *Job) DecodeArg(arg interface{}) (err error) {
-	err = json.Unmarshal(job.RawArg, arg)
+	err = decodeArg(job.ArgVersion, job.RawArg, arg)
 	job.Arg = arg
 	return
 }
 
+// JobError is the error of job, unlike error it survives encoding of job
+type JobError struct {
+	Msg string
+}
+
+// NewJobError is ctor for JobError
+func NewJobError(err error) *JobError {
+	return &JobError{Msg: err.Error()}
+}
+
+// Error implements error
+func (e *JobError) Error() string {
+	return e.Msg
+}
+
 // IsSynced returns whether the DDL modification is synced among all servers.
 func (job *Job) IsSynced() bool {
 	return job.State == JobStateSynced
@@ -315,7 +447,7 @@ func (job *Job) FinishDBJob(jobState JobState, schemaState osc.SchemaState, sche
 // Encode SchemaDiff
 func (sd *SchemaDiff) Encode() (b []byte, err error) {
 	if len(sd.RawArg) == 0 {
-		sd.RawArg, err = json.Marshal(sd.Arg)
+		sd.ArgVersion, sd.RawArg, err = encodeArg(sd.Arg)
 		if err != nil {
 			return
 		}
@@ -332,9 +464,9 @@ func (sd *SchemaDiff) Decode(b []byte) (err error) {
 	return
 }
 
-// DecodeArg decodes schema diff arg.
+// DecodeArg decodes schema diff arg by the ArgCodec of sd.ArgVersion.
 func (sd *SchemaDiff) DecodeArg(arg interface{}) (err error) {
-	err = json.Unmarshal(sd.RawArg, arg)
+	err = decodeArg(sd.ArgVersion, sd.RawArg, arg)
 	sd.Arg = arg
 	return
 }
@@ -0,0 +1,103 @@
+package document
+
+import (
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DocRef refers to the document of ID in collection CollectionName
+type DocRef struct {
+	CollectionName string
+	ID             int64
+}
+
+// MultiGet returns documents of reqs across collections, all read in a single txn so that they're consistent with each other.
+// Missing documents are absent from docs, so are documents of collections that don't exist, which are not created.
+func (db *DB) MultiGet(reqs []DocRef) (docs map[DocRef]bson.M, err error) {
+	// prologue start
+	err = db.checkState()
+	if err != nil {
+		return
+	}
+	err = db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer db.closer.Done()
+	// prologue end
+
+	err = db.implicitTxns.acquire()
+	if err != nil {
+		return
+	}
+	defer db.implicitTxns.release()
+	txn := db.kvdb.NewTransaction(false)
+	defer txn.Discard()
+
+	docs = make(map[DocRef]bson.M, len(reqs))
+	collections := make(map[string]*Collection)
+	for _, ref := range reqs {
+		c, ok := collections[ref.CollectionName]
+		if !ok {
+			c, err = db.existingCollection(ref.CollectionName, txn)
+			if err != nil {
+				docs = nil
+				return
+			}
+			collections[ref.CollectionName] = c
+		}
+		if c == nil {
+			continue
+		}
+
+		var v []byte
+		v, _, err = txn.Get(EncodeCollectionDocumentKey(nil, c.cid, ref.ID))
+		if err == kv.ErrKeyNotFound {
+			err = nil
+			continue
+		}
+		if err != nil {
+			docs = nil
+			return
+		}
+		var data bson.M
+		err = bson.Unmarshal(v, &data)
+		if err != nil {
+			docs = nil
+			return
+		}
+		c.stripSystemFields(data)
+		docs[ref] = data
+	}
+	return
+}
+
+// existingCollection returns the opened collection name, nil if it doesn't exist as of txn
+func (db *DB) existingCollection(name string, txn mondis.ProviderTxn) (c *Collection, err error) {
+	db.mu.RLock()
+	c = db.collections[name]
+	db.mu.RUnlock()
+
+	if c == nil {
+		_, _, err = txn.Get(EncodeMetaCollectionName2IDKey(nil, name))
+		if err == kv.ErrKeyNotFound {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		c, err = db.Collection(name)
+		if err != nil {
+			return
+		}
+	}
+
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		c = nil
+	}
+	return
+}
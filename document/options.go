@@ -0,0 +1,41 @@
+package document
+
+import (
+	"time"
+
+	"github.com/zhiqiangxu/mondis/slowlog"
+)
+
+// DBOption for DB
+type DBOption struct {
+	// ReservedFieldPrefix defaults to DefaultReservedFieldPrefix,
+	// it must stay the same for the same kvdb, otherwise system fields written before become user fields.
+	ReservedFieldPrefix string
+	// Clock defaults to time.Now, it's the time source of CollectionOption.AutoTimestamps and CollectionOption.CreationTimeIndex
+	Clock func() time.Time
+	// Metrics receives per-collection operation and sequence metrics if set, nothing is measured otherwise
+	Metrics Metrics
+	// MaxImplicitTxns bounds txns open at the same time that are created by Collection methods called with a nil txn,
+	// zero means unlimited. Txns passed in by callers are not limited, neither is ForEach which calls back into user code.
+	MaxImplicitTxns int
+	// ImplicitTxnWait is the max time to wait for MaxImplicitTxns before ErrTooBusy, defaults to 100ms
+	ImplicitTxnWait time.Duration
+	// SlowLog records Find, FindCtx, FindPage, ForEach and Iterate taking longer than its threshold if not nil
+	SlowLog *slowlog.Log
+}
+
+// CollectionOption for Collection, applied when DB opens the collection first, the setters of Collection change it later
+type CollectionOption struct {
+	// RateLimit applies to writes of the collection, in addition to the one of DB
+	RateLimit RateLimit
+	// IncludeSystemFields makes reads return system fields, see DBOption.ReservedFieldPrefix
+	IncludeSystemFields bool
+	// AutoTimestamps makes writes stamp CreatedAtField and UpdatedAtField with DBOption.Clock
+	AutoTimestamps bool
+	// OverwriteTimestamps makes AutoTimestamps replace the timestamp fields provided by the caller
+	OverwriteTimestamps bool
+	// CreationTimeIndex makes inserts record SystemFieldCreatedAt with DBOption.Clock and index it for FindByTimeRange,
+	// updates keep it and deletes remove the index entry. It should be set by all processes writing the collection,
+	// and the clocks of them should be in sync since documents are ordered by the clock of their writers.
+	CreationTimeIndex bool
+}
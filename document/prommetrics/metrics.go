@@ -0,0 +1,78 @@
+// Package prommetrics adapts document.Metrics to a metrics.Registry,
+// so that document metrics are scraped in the prometheus text format along with others of the registry.
+package prommetrics
+
+import (
+	"time"
+
+	"github.com/zhiqiangxu/mondis/document"
+	"github.com/zhiqiangxu/mondis/metrics"
+)
+
+// Metrics implements document.Metrics by collectors of a metrics.Registry
+type Metrics struct {
+	ops         *metrics.CounterVec
+	errors      *metrics.CounterVec
+	latency     *metrics.HistogramVec
+	leases      *metrics.CounterVec
+	cacheAccess *metrics.CounterVec
+}
+
+var _ document.Metrics = (*Metrics)(nil)
+
+// New is ctor for Metrics, collectors are named after namespace and registered to registry
+func New(namespace string, registry *metrics.Registry) (m *Metrics, err error) {
+	prefix := namespace + "_document_"
+	m = &Metrics{}
+	m.ops, err = registry.NewCounterVec(prefix+"ops_total", "Number of collection operations.", "collection", "op")
+	if err != nil {
+		m = nil
+		return
+	}
+	m.errors, err = registry.NewCounterVec(prefix+"op_errors_total", "Number of collection operations returning an error.", "collection", "op")
+	if err != nil {
+		m = nil
+		return
+	}
+	m.latency, err = registry.NewHistogramVec(prefix+"op_duration_seconds", "Latency of collection operations.",
+		metrics.ExponentialBuckets(0.00005, 4, 10), "collection", "op")
+	if err != nil {
+		m = nil
+		return
+	}
+	m.leases, err = registry.NewCounterVec(prefix+"sequence_lease_extensions_total", "Number of bands leased by sequences after the initial one.", "keyword")
+	if err != nil {
+		m = nil
+		return
+	}
+	m.cacheAccess, err = registry.NewCounterVec(prefix+"collection_cache_accesses_total", "Number of collection lookups by name, result is hit or miss.", "result")
+	if err != nil {
+		m = nil
+		return
+	}
+	return
+}
+
+// ObserveOp for implement document.Metrics
+func (m *Metrics) ObserveOp(collection string, op document.MetricOp, err error, latency time.Duration) {
+	opName := op.String()
+	m.ops.Inc(collection, opName)
+	if err != nil {
+		m.errors.Inc(collection, opName)
+	}
+	m.latency.Observe(latency.Seconds(), collection, opName)
+}
+
+// SequenceLeaseExtended for implement document.Metrics
+func (m *Metrics) SequenceLeaseExtended(keyword string) {
+	m.leases.Inc(keyword)
+}
+
+// CollectionCacheAccess for implement document.Metrics
+func (m *Metrics) CollectionCacheAccess(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheAccess.Inc(result)
+}
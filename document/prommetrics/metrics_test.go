@@ -0,0 +1,40 @@
+package prommetrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhiqiangxu/mondis/document"
+	"github.com/zhiqiangxu/mondis/metrics"
+	"gotest.tools/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	m, err := New("kvrpc", r)
+	assert.Assert(t, err == nil)
+	_, err = New("kvrpc", r)
+	assert.Assert(t, err == metrics.ErrDuplicateName)
+
+	m.ObserveOp("c", document.MetricOpInsert, nil, time.Millisecond)
+	m.ObserveOp("c", document.MetricOpInsert, errors.New("failed"), time.Millisecond)
+	m.ObserveOp("c", document.MetricOpGet, nil, time.Millisecond)
+	m.SequenceLeaseExtended("c")
+	m.CollectionCacheAccess(true)
+	m.CollectionCacheAccess(false)
+	m.CollectionCacheAccess(false)
+
+	assert.Assert(t, m.ops.Value("c", "insert") == 2 && m.ops.Value("c", "get") == 1)
+	assert.Assert(t, m.errors.Value("c", "insert") == 1 && m.errors.Value("c", "get") == 0)
+	assert.Assert(t, m.leases.Value("c") == 1)
+	assert.Assert(t, m.cacheAccess.Value("hit") == 1 && m.cacheAccess.Value("miss") == 2)
+
+	var buf bytes.Buffer
+	_, err = r.WriteTo(&buf)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, strings.Contains(buf.String(), `kvrpc_document_ops_total{collection="c",op="insert"} 2`), buf.String())
+	assert.Assert(t, strings.Contains(buf.String(), `kvrpc_document_op_duration_seconds_count{collection="c",op="get"} 1`), buf.String())
+}
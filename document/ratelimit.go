@@ -0,0 +1,162 @@
+package document
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// RateLimit for writes, zero means unlimited
+type RateLimit struct {
+	WritesPerSecond float64
+	BytesPerSecond  float64
+}
+
+// defaultMaxRateLimitWait is the max time a write waits for rate limiters,
+// there is no way to cancel the wait until ctx variants of write methods exist.
+const defaultMaxRateLimitWait = time.Second * 5
+
+// ErrRateLimited when a write would wait longer than the max wait for rate limiters
+var ErrRateLimited = errors.New("rate limited")
+
+// tokenBucket allows a burst of one second worth of tokens,
+// a take larger than what's available drives it into debt which later takes wait for.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (tb *tokenBucket) set(rate float64, now time.Time) {
+	tb.rate = rate
+	tb.tokens = rate
+	tb.last = now
+}
+
+func (tb *tokenBucket) refill(now time.Time) {
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.rate {
+		tb.tokens = tb.rate
+	}
+	tb.last = now
+}
+
+// wait returns how long to wait before taking n tokens after refill,
+// n larger than the burst only waits for a full bucket.
+func (tb *tokenBucket) wait(n float64) time.Duration {
+	if n > tb.rate {
+		n = tb.rate
+	}
+	if tb.rate <= 0 || tb.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - tb.tokens) / tb.rate * float64(time.Second))
+}
+
+func (tb *tokenBucket) take(n float64) {
+	if tb.rate <= 0 {
+		return
+	}
+	tb.tokens -= n
+}
+
+// giveBack returns n tokens taken before, up to the burst
+func (tb *tokenBucket) giveBack(n float64) {
+	if tb.rate <= 0 {
+		return
+	}
+	tb.tokens += n
+	if tb.tokens > tb.rate {
+		tb.tokens = tb.rate
+	}
+}
+
+// rateLimiter limits writes/sec and bytes/sec, runtime adjustable
+type rateLimiter struct {
+	mu     sync.Mutex
+	writes tokenBucket
+	bytes  tokenBucket
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	rl := &rateLimiter{}
+	rl.set(limit)
+	return rl
+}
+
+func (rl *rateLimiter) set(limit RateLimit) {
+	now := time.Now()
+
+	rl.mu.Lock()
+	rl.writes.set(limit.WritesPerSecond, now)
+	rl.bytes.set(limit.BytesPerSecond, now)
+	rl.mu.Unlock()
+}
+
+// reserve takes tokens for a write of n bytes and returns how long to wait before the write,
+// nothing is taken if the wait would exceed maxWait.
+func (rl *rateLimiter) reserve(n int, maxWait time.Duration) (wait time.Duration, ok bool) {
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.writes.refill(now)
+	rl.bytes.refill(now)
+
+	wait = rl.writes.wait(1)
+	if bytesWait := rl.bytes.wait(float64(n)); bytesWait > wait {
+		wait = bytesWait
+	}
+	if wait > maxWait {
+		return
+	}
+
+	rl.writes.take(1)
+	rl.bytes.take(float64(n))
+	ok = true
+	return
+}
+
+// cancel gives back the tokens taken by a reserve of n bytes that's not followed by the write
+func (rl *rateLimiter) cancel(n int) {
+	rl.mu.Lock()
+	rl.writes.giveBack(1)
+	rl.bytes.giveBack(float64(n))
+	rl.mu.Unlock()
+}
+
+// waitRateLimit waits for the collection and the db rate limiters before a write of n bytes,
+// it should be called before opening the write transaction to keep it short.
+func (c *Collection) waitRateLimit(n int) (err error) {
+	wait, ok := c.rateLimiter.reserve(n, defaultMaxRateLimitWait)
+	if !ok {
+		err = ErrRateLimited
+		return
+	}
+
+	dbWait, ok := c.db.rateLimiter.reserve(n, defaultMaxRateLimitWait)
+	if !ok {
+		c.rateLimiter.cancel(n)
+		err = ErrRateLimited
+		return
+	}
+	if dbWait > wait {
+		wait = dbWait
+	}
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return
+}
+
+// SetRateLimit adjusts the write rate limit of collection
+func (c *Collection) SetRateLimit(limit RateLimit) {
+	c.rateLimiter.set(limit)
+}
+
+// SetRateLimit adjusts the global write rate limit of db, shared by all collections
+func (db *DB) SetRateLimit(limit RateLimit) {
+	db.rateLimiter.set(limit)
+}
@@ -0,0 +1,71 @@
+package document
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultReservedFieldPrefix is the default prefix of fields reserved for system use,
+// it's unlikely to collide with user fields like _id or _rev.
+const DefaultReservedFieldPrefix = "_mondis_"
+
+// names of system fields injected into documents, to be prefixed by the reserved field prefix
+const (
+	// SystemFieldRev for document revision
+	SystemFieldRev = "rev"
+	// SystemFieldDeleted for soft delete mark
+	SystemFieldDeleted = "deleted"
+	// SystemFieldDid for document id in exports
+	SystemFieldDid = "did"
+	// SystemFieldCreatedAt for creation time when CollectionOption.CreationTimeIndex is set
+	SystemFieldCreatedAt = "createdAt"
+)
+
+// ErrReservedField when a user document contains a top level field with the reserved prefix
+var ErrReservedField = errors.New("document contains reserved field")
+
+// SystemField returns the full name of system field name
+func (db *DB) SystemField(name string) string {
+	return db.reservedFieldPrefix + name
+}
+
+// checkUserFields rejects doc if it contains reserved fields,
+// only top level fields are checked since system fields are always injected there.
+func (c *Collection) checkUserFields(doc bson.M) (err error) {
+	for field := range doc {
+		if strings.HasPrefix(field, c.db.reservedFieldPrefix) {
+			err = ErrReservedField
+			return
+		}
+	}
+	return
+}
+
+// SetIncludeSystemFields toggles whether reads of collection return system fields
+func (c *Collection) SetIncludeSystemFields(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.systemFields, v)
+}
+
+func (c *Collection) includeSystemFields() bool {
+	return atomic.LoadInt32(&c.systemFields) == 1
+}
+
+// stripSystemFields removes system fields from doc read for user, unless CollectionOption.IncludeSystemFields is set
+func (c *Collection) stripSystemFields(doc bson.M) {
+	if c.includeSystemFields() {
+		return
+	}
+
+	for field := range doc {
+		if strings.HasPrefix(field, c.db.reservedFieldPrefix) {
+			delete(doc, field)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/util/osc"
 )
 
 const (
@@ -33,33 +34,39 @@ func (c *MetaCache) Version() int64 {
 	return c.version
 }
 
-// CheckDBExists checks whether db exists
+// CheckDBExists checks whether db exists, a db being dropped is invisible
 func (c *MetaCache) CheckDBExists(dbName string) bool {
-	return c != nil && c.dbs[dbName] != nil
+	return c.publicDB(dbName) != nil
 }
 
-// CollectionInfo retrieves the collection info by name
-func (c *MetaCache) CollectionInfo(dbName, collectionName string) (collectionInfo *model.CollectionInfo) {
+func (c *MetaCache) publicDB(dbName string) *model.DBInfo {
 	if c == nil {
-		return
+		return nil
 	}
-
 	dbInfo := c.dbs[dbName]
+	if dbInfo == nil || dbInfo.State != osc.StatePublic {
+		return nil
+	}
+	return dbInfo
+}
+
+// CollectionInfo retrieves the collection info by name, a collection being dropped is invisible
+func (c *MetaCache) CollectionInfo(dbName, collectionName string) (collectionInfo *model.CollectionInfo) {
+	dbInfo := c.publicDB(dbName)
 	if dbInfo == nil {
 		return
 	}
 
 	collectionInfo = dbInfo.CollectionInfo(collectionName)
+	if collectionInfo != nil && collectionInfo.State != osc.StatePublic {
+		collectionInfo = nil
+	}
 	return
 }
 
 // CheckCollectionExists checks whether collection exists
 func (c *MetaCache) CheckCollectionExists(dbName, collectionName string) bool {
-	if c == nil {
-		return false
-	}
-	dbInfo := c.dbs[dbName]
-	return dbInfo != nil && dbInfo.CollectionExists(collectionName)
+	return c.CollectionInfo(dbName, collectionName) != nil
 }
 
 // CheckIndexExists checks whether index exists
@@ -67,12 +74,7 @@ func (c *MetaCache) CheckIndexExists(dbName, collectionName, indexName string) (
 	if c == nil {
 		return
 	}
-	dbInfo := c.dbs[dbName]
-	if dbInfo == nil {
-		return
-	}
-
-	ci := dbInfo.CollectionInfo(collectionName)
+	ci := c.CollectionInfo(dbName, collectionName)
 	if ci == nil {
 		return
 	}
@@ -118,6 +120,26 @@ func (c *MetaCache) ApplyDiffs(diffs []*model.SchemaDiff) (err error) {
 			if err != nil {
 				return
 			}
+		case model.ActionCreateCollection:
+			err = c.onCreateCollection(diff)
+			if err != nil {
+				return
+			}
+		case model.ActionDropSchema:
+			err = c.onDropSchema(diff)
+			if err != nil {
+				return
+			}
+		case model.ActionDropCollection:
+			err = c.onDropCollection(diff)
+			if err != nil {
+				return
+			}
+		case model.ActionAddIndex, model.ActionRebuildIndex, model.ActionDropIndex:
+			err = c.onAddIndex(diff)
+			if err != nil {
+				return
+			}
 		default:
 			err = fmt.Errorf("can not apply diff type %d", diff.Type)
 			return
@@ -167,3 +189,110 @@ func (c *MetaCache) onCreateSchema(diff *model.SchemaDiff) (err error) {
 	c.dbs[dbInfo.Name] = &dbInfo
 	return
 }
+
+func (c *MetaCache) onCreateCollection(diff *model.SchemaDiff) (err error) {
+	var arg model.CreateCollectionArg
+	err = diff.DecodeArg(&arg)
+	if err != nil {
+		return
+	}
+
+	for _, dbInfo := range c.dbs {
+		if dbInfo.ID != arg.DBID {
+			continue
+		}
+		if !dbInfo.AddCollectionInfo(arg.Collection) {
+			err = fmt.Errorf("collection %s exists in meta cache", arg.Collection.Name)
+			return
+		}
+		c.version = diff.Version
+		return
+	}
+
+	err = fmt.Errorf("db %d not exists in meta cache", arg.DBID)
+	return
+}
+
+func (c *MetaCache) onDropSchema(diff *model.SchemaDiff) (err error) {
+	var dbInfo model.DBInfo
+	err = diff.DecodeArg(&dbInfo)
+	if err != nil {
+		return
+	}
+
+	if c.dbs[dbInfo.Name] == nil {
+		err = fmt.Errorf("db %s not exists in meta cache", dbInfo.Name)
+		return
+	}
+
+	c.version = diff.Version
+
+	if dbInfo.State == osc.StateAbsent {
+		delete(c.dbs, dbInfo.Name)
+	} else {
+		c.dbs[dbInfo.Name] = &dbInfo
+	}
+	return
+}
+
+func (c *MetaCache) onDropCollection(diff *model.SchemaDiff) (err error) {
+	var arg model.DropCollectionArg
+	err = diff.DecodeArg(&arg)
+	if err != nil {
+		return
+	}
+
+	for _, dbInfo := range c.dbs {
+		if dbInfo.ID != arg.DBID {
+			continue
+		}
+		var ok bool
+		if arg.Collection.State == osc.StateAbsent {
+			ok = dbInfo.RemoveCollectionInfo(arg.Collection.Name)
+		} else {
+			ok = dbInfo.UpdateCollectionInfo(arg.Collection)
+		}
+		if !ok {
+			err = fmt.Errorf("collection %s not exists in meta cache", arg.Collection.Name)
+			return
+		}
+		c.version = diff.Version
+		return
+	}
+
+	err = fmt.Errorf("db %d not exists in meta cache", arg.DBID)
+	return
+}
+
+func (c *MetaCache) onAddIndex(diff *model.SchemaDiff) (err error) {
+	var indexInfo model.IndexInfo
+	err = diff.DecodeArg(&indexInfo)
+	if err != nil {
+		return
+	}
+
+	redundant := indexInfo.JobRedundant
+	if redundant == nil {
+		err = fmt.Errorf("index %s without collection in diff", indexInfo.Name)
+		return
+	}
+	dbInfo := c.dbs[redundant.DB]
+	if dbInfo == nil {
+		err = fmt.Errorf("db %s not exists in meta cache", redundant.DB)
+		return
+	}
+	ci := dbInfo.CollectionInfo(redundant.Collection)
+	if ci == nil {
+		err = fmt.Errorf("collection %s not exists in meta cache", redundant.Collection)
+		return
+	}
+
+	indexInfo.JobRedundant = nil
+	if indexInfo.State == osc.StateAbsent {
+		ci.RemoveIndexInfo(indexInfo.Name)
+	} else if !ci.UpdateIndexInfo(&indexInfo) {
+		ci.AddIndexInfo(&indexInfo)
+	}
+	c.version = diff.Version
+	return
+}
@@ -1,25 +1,56 @@
 package document
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"sync"
 
 	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/kv"
 	"github.com/zhiqiangxu/mondis/kv/numeric"
+	"github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
 )
 
+// sequenceMaxRetries is the max retries on conflict with other processes allocating from the same sequence
+const sequenceMaxRetries = 20
+
+var (
+	// ErrSequenceConflict when the stored lease keeps being changed by other processes for sequenceMaxRetries times
+	ErrSequenceConflict = errors.New("sequence lease conflicts with other processes")
+	// ErrSequenceExhausted when the sequence would wrap around math.MaxUint64
+	ErrSequenceExhausted = errors.New("sequence exhausted")
+)
+
+// SequenceOption for Sequence
+type SequenceOption struct {
+	// Initial raises the stored lease to it, see SetIfGreater
+	Initial uint64
+	// Journal records each lease under a sibling key until it's released by ReleaseRemaining,
+	// so that a lease not released, e.g. by a crash, is reported by Lost of the next Sequence on the keyword.
+	// It assumes a single Sequence on the keyword at a time.
+	Journal bool
+}
+
 // Sequence for allocating auto incrementing pk
 type Sequence struct {
 	sync.Mutex
-	kvdb      mondis.KVDB
-	key       []byte
-	bandwidth uint64
-	next      uint64
-	leased    uint64
+	kvdb       mondis.KVDB
+	key        []byte
+	journalKey []byte
+	bandwidth  uint64
+	next       uint64
+	leased     uint64
+	keyword    string
+	lost       uint64
+	// metrics is set by DB
+	metrics Metrics
 }
 
-// NewSequence is ctor for Sequence
-func NewSequence(kvdb mondis.KVDB, keyword []byte, bandwidth uint64) (s *Sequence, err error) {
+// NewSequence is ctor for Sequence, option is applied if specified
+func NewSequence(kvdb mondis.KVDB, keyword []byte, bandwidth uint64, options ...SequenceOption) (s *Sequence, err error) {
 	if len(keyword) == 0 {
 		err = ErrEmptyKeywordForSequence
 		return
@@ -30,61 +61,226 @@ func NewSequence(kvdb mondis.KVDB, keyword []byte, bandwidth uint64) (s *Sequenc
 		return
 	}
 
-	s = &Sequence{kvdb: kvdb, key: EncodeMetaSequenceKey(nil, keyword), bandwidth: bandwidth}
-	err = s.updateLease()
+	s = &Sequence{kvdb: kvdb, key: EncodeMetaSequenceKey(nil, keyword), keyword: string(keyword), bandwidth: bandwidth}
+	if len(options) != 0 {
+		if options[0].Journal {
+			s.journalKey = EncodeMetaSequenceJournalKey(nil, keyword)
+			err = s.checkJournal()
+			if err != nil {
+				return
+			}
+		}
+		if options[0].Initial > 0 {
+			err = s.raiseStored(options[0].Initial)
+			if err != nil {
+				return
+			}
+		}
+	}
+	err = s.updateLease(bandwidth)
+
+	return
+}
+
+// updateLease leases the next band of size band after the stored lease, which is authoritative since other processes may have advanced it,
+// the read and write are retried in a new txn on conflict, and s is only changed once committed.
+// The band is narrowed near math.MaxUint64, ErrSequenceExhausted once nothing is left.
+func (s *Sequence) updateLease(band uint64) (err error) {
+	var stored uint64
+	err = s.runWithRetry(func(txn mondis.ProviderTxn) (err error) {
+		stored, err = s.getStored(txn)
+		if err != nil {
+			return
+		}
+		if remaining := math.MaxUint64 - stored; band > remaining {
+			band = remaining
+		}
+		if band == 0 {
+			err = ErrSequenceExhausted
+			return
+		}
+		err = txn.Set(s.key, numeric.Encode2Binary(stored+band, nil), nil)
+		if err != nil || s.journalKey == nil {
+			return
+		}
+		err = txn.Set(s.journalKey, numeric.Encode2Binary(stored+band, numeric.Encode2Binary(stored, nil)), nil)
+		return
+	})
+	if err != nil {
+		return
+	}
 
+	s.next = stored
+	s.leased = stored + band
 	return
 }
 
-func (s *Sequence) updateLease() (err error) {
-	txn := s.kvdb.NewTransaction(true)
+// checkJournal reports the lease left in journal by the previous Sequence, which was never released
+func (s *Sequence) checkJournal() (err error) {
+	txn := s.kvdb.NewTransaction(false)
 	defer txn.Discard()
 
+	val, _, err := txn.Get(s.journalKey)
+	if err == kv.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	if len(val) != 16 {
+		err = fmt.Errorf("invalid sequence journal - %q", val)
+		return
+	}
+
+	start, err := numeric.DecodeFromBinary(val[0:8])
+	if err != nil {
+		return
+	}
+	end, err := numeric.DecodeFromBinary(val[8:])
+	if err != nil {
+		return
+	}
+	s.lost = end - start
+	logger.Instance().Warn("sequence lease not released", zap.String("keyword", s.keyword), zap.Uint64("start", start), zap.Uint64("end", end))
+	return
+}
+
+// Lost returns how many integers of the last lease of the previous Sequence on the keyword may be lost,
+// because it was never released by ReleaseRemaining, e.g. the process crashed.
+// It's always 0 unless SequenceOption.Journal is set.
+func (s *Sequence) Lost() uint64 {
+	return s.lost
+}
+
+// extendLease is updateLease after the initial lease
+func (s *Sequence) extendLease(band uint64) (err error) {
+	err = s.updateLease(band)
+	if err != nil {
+		return
+	}
+	if s.metrics != nil {
+		s.metrics.SequenceLeaseExtended(s.keyword)
+	}
+	return
+}
+
+// getStored reads the stored lease, 0 if not exists
+func (s *Sequence) getStored(txn mondis.ProviderTxn) (stored uint64, err error) {
 	val, _, err := txn.Get(s.key)
 	switch {
 	case err == kv.ErrKeyNotFound:
-		s.next = 0
+		err = nil
 	case err != nil:
-		return
 	default:
-		var num uint64
-		num, err = numeric.DecodeFromBinary(val)
-		if err != nil {
-			return
-		}
-		s.next = num
+		stored, err = numeric.DecodeFromBinary(val)
+	}
+	return
+}
+
+// runWithRetry runs f in a new update txn, ErrSequenceConflict if it still conflicts after sequenceMaxRetries retries
+func (s *Sequence) runWithRetry(f func(txn mondis.ProviderTxn) error) (err error) {
+	err = util.RunInNewUpdateTxnWithRetry(s.kvdb, f, sequenceMaxRetries)
+	if err == kv.ErrTxnConflict {
+		err = ErrSequenceConflict
 	}
+	return
+}
+
+// Cur returns the stored lease, integers returned by Next of any Sequence on the same keyword so far are not greater than it.
+// Nothing is consumed.
+func (s *Sequence) Cur() (stored uint64, err error) {
+	txn := s.kvdb.NewTransaction(false)
+	defer txn.Discard()
+
+	stored, err = s.getStored(txn)
+	return
+}
+
+// SetIfGreater raises the stored lease to v if it's lower, so that Next of any Sequence on the same keyword
+// leases integers greater than v from then on, e.g., after importing documents with ids up to v.
+// The remaining lease of s is dropped if it has integers not greater than v.
+func (s *Sequence) SetIfGreater(v uint64) (err error) {
+	s.Lock()
+	defer s.Unlock()
 
-	lease := s.next + s.bandwidth
-	buf := numeric.Encode2Binary(lease, nil)
-	err = txn.Set(s.key, buf, nil)
+	err = s.raiseStored(v)
 	if err != nil {
 		return
 	}
+	if s.next < v {
+		s.leased = s.next
+	}
+	return
+}
 
-	oldLease := s.leased
-	s.leased = lease
-	err = txn.Commit()
+// Reset sets the stored lease to value, even if it's lower, and drops the remaining lease of s,
+// so that Next resumes from value+1, e.g., to restore the sequence after a bulk import of documents with explicit ids.
+// Next of s waits until it's done, but other Sequences on the keyword keep their leases, which may overlap after a lower value.
+// The journal is cleared if any, since nothing is leased.
+func (s *Sequence) Reset(value uint64) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	err = s.runWithRetry(func(txn mondis.ProviderTxn) (err error) {
+		err = txn.Set(s.key, numeric.Encode2Binary(value, nil), nil)
+		if err != nil || s.journalKey == nil {
+			return
+		}
+		err = txn.Delete(s.journalKey)
+		return
+	})
 	if err != nil {
-		s.leased = oldLease
+		return
 	}
+
+	s.next = value
+	s.leased = value
+	return
+}
+
+// raiseStored raises the stored lease to v by read-modify-write, retried on conflict
+func (s *Sequence) raiseStored(v uint64) (err error) {
+	err = s.runWithRetry(func(txn mondis.ProviderTxn) (err error) {
+		stored, err := s.getStored(txn)
+		if err != nil || stored >= v {
+			return
+		}
+		err = txn.Set(s.key, numeric.Encode2Binary(v, nil), nil)
+		return
+	})
 	return
 }
 
-// ReleaseRemaining for release the remaining sequence to avoid wasted integers.
+// ReleaseRemaining for release the remaining sequence to avoid wasted integers,
+// it's not released if other Sequences on the keyword have leased after s. The journal is cleared if any.
 func (s *Sequence) ReleaseRemaining() (err error) {
 	s.Lock()
 	defer s.Unlock()
 
-	if s.leased == s.next {
+	if s.leased == s.next && s.journalKey == nil {
 		return
 	}
 
-	txn := s.kvdb.NewTransaction(true)
-	defer txn.Discard()
-
-	buf := numeric.Encode2Binary(s.next, nil)
-	err = txn.Set(s.key, buf, nil)
+	err = s.runWithRetry(func(txn mondis.ProviderTxn) (err error) {
+		if s.leased != s.next {
+			var stored uint64
+			stored, err = s.getStored(txn)
+			if err != nil {
+				return
+			}
+			if stored == s.leased {
+				err = txn.Set(s.key, numeric.Encode2Binary(s.next, nil), nil)
+				if err != nil {
+					return
+				}
+			}
+		}
+		if s.journalKey != nil {
+			err = txn.Delete(s.journalKey)
+		}
+		return
+	})
 	if err != nil {
 		return
 	}
@@ -94,13 +290,14 @@ func (s *Sequence) ReleaseRemaining() (err error) {
 }
 
 // Next would return the next integer in the sequence, updating the lease by running a transaction
-// if needed.
+// if needed, ErrSequenceConflict is returned if other processes keep updating it meanwhile,
+// and ErrSequenceExhausted after math.MaxUint64 is returned.
 func (s *Sequence) Next() (val uint64, err error) {
 	s.Lock()
 	defer s.Unlock()
 
 	if s.next >= s.leased {
-		err = s.updateLease()
+		err = s.extendLease(s.bandwidth)
 		if err != nil {
 			return
 		}
@@ -110,3 +307,80 @@ func (s *Sequence) Next() (val uint64, err error) {
 	val = s.next
 	return
 }
+
+// Peek returns what Next would return without consuming it,
+// the lease is extended like Next if it's used up, so a Next right after returns the same unless it's from other Sequences.
+func (s *Sequence) Peek() (val uint64, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.next >= s.leased {
+		err = s.extendLease(s.bandwidth)
+		if err != nil {
+			return
+		}
+	}
+
+	val = s.next + 1
+	return
+}
+
+// NextN is like Next but returns n contiguous integers [start, start+n), the lease is extended at most once,
+// integers left in the current lease are skipped if they're not enough.
+func (s *Sequence) NextN(n uint64) (start uint64, err error) {
+	if n == 0 {
+		err = ErrZeroBandwidth
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.leased-s.next < n {
+		band := s.bandwidth
+		if band < n {
+			band = n
+		}
+		err = s.extendLease(band)
+		if err != nil {
+			return
+		}
+		// narrowed near math.MaxUint64
+		if s.leased-s.next < n {
+			err = ErrSequenceExhausted
+			return
+		}
+	}
+
+	start = s.next + 1
+	s.next += n
+	return
+}
+
+// AllocateBand atomically reserves a contiguous band of n integers [start, end) by advancing the stored lease,
+// so that parallel loaders, even across processes, can assign them locally without further coordination.
+// Integers of the band not assigned are simply gaps in the sequence.
+func (s *Sequence) AllocateBand(n uint64) (start, end uint64, err error) {
+	if n == 0 {
+		err = ErrZeroBandwidth
+		return
+	}
+
+	err = s.runWithRetry(func(txn mondis.ProviderTxn) (err error) {
+		stored, err := s.getStored(txn)
+		if err != nil {
+			return
+		}
+
+		// end is exclusive so it must fit as well
+		if n >= math.MaxUint64-stored {
+			err = ErrSequenceExhausted
+			return
+		}
+		start = stored + 1
+		end = start + n
+		err = txn.Set(s.key, numeric.Encode2Binary(stored+n, nil), nil)
+		return
+	})
+	return
+}
@@ -0,0 +1,39 @@
+package document
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zhiqiangxu/mondis/slowlog"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Kinds of collection operations recorded to DBOption.SlowLog
+const (
+	SlowKindFind     = "find"
+	SlowKindFindPage = "find page"
+	SlowKindForEach  = "foreach"
+	SlowKindIterate  = "iterate"
+)
+
+// observeSlow records op started at start to DBOption.SlowLog if it's slow,
+// TxnAge is left 0 since the age of txns passed in by callers is unknown.
+func (c *Collection) observeSlow(kind string, start time.Time, filter bson.M, returned, skipped int64) {
+	d := time.Since(start)
+	if !c.db.slowLog.Slow(d) {
+		return
+	}
+
+	var option string
+	if len(filter) > 0 {
+		option = fmt.Sprintf("filter=%v", filter)
+	}
+	c.db.slowLog.Add(slowlog.Record{
+		Kind:      kind,
+		Namespace: c.name,
+		Option:    option,
+		Returned:  returned,
+		Skipped:   skipped,
+		Duration:  d,
+	})
+}
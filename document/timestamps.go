@@ -0,0 +1,109 @@
+package document
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fields stamped when CollectionOption.AutoTimestamps is set
+const (
+	// CreatedAtField is set when a document is inserted
+	CreatedAtField = "createdAt"
+	// UpdatedAtField is set whenever a document is written
+	UpdatedAtField = "updatedAt"
+)
+
+const (
+	timestampsOff int32 = iota
+	timestampsOn
+	timestampsOverwrite
+)
+
+// SetAutoTimestamps toggles whether writes of collection stamp CreatedAtField and UpdatedAtField,
+// values provided by the caller are kept unless overwrite.
+func (c *Collection) SetAutoTimestamps(on, overwrite bool) {
+	v := timestampsOff
+	switch {
+	case on && overwrite:
+		v = timestampsOverwrite
+	case on:
+		v = timestampsOn
+	}
+	atomic.StoreInt32(&c.timestamps, v)
+}
+
+func (c *Collection) autoTimestamps() bool {
+	return atomic.LoadInt32(&c.timestamps) != timestampsOff
+}
+
+// now returns the time of DBOption.Clock
+func (db *DB) now() time.Time {
+	if db.clock != nil {
+		return db.clock()
+	}
+	return time.Now()
+}
+
+// stamp returns a copy of doc with timestamp fields set if AutoTimestamps, doc itself is returned otherwise.
+// old is the stored document when it's not new, whose CreatedAtField is carried over
+// since doc replaces it as a whole.
+func (c *Collection) stamp(doc, old bson.M, isNew bool) bson.M {
+	mode := atomic.LoadInt32(&c.timestamps)
+	if mode == timestampsOff {
+		return doc
+	}
+
+	stamped := make(bson.M, len(doc)+2)
+	for field, value := range doc {
+		stamped[field] = value
+	}
+
+	now := primitive.NewDateTimeFromTime(c.db.now())
+	overwrite := mode == timestampsOverwrite
+	if _, ok := stamped[UpdatedAtField]; !ok || overwrite {
+		stamped[UpdatedAtField] = now
+	}
+
+	if _, ok := stamped[CreatedAtField]; ok && !overwrite {
+		return stamped
+	}
+	if isNew {
+		stamped[CreatedAtField] = now
+		return stamped
+	}
+	if createdAt, ok := old[CreatedAtField]; ok {
+		stamped[CreatedAtField] = createdAt
+	} else {
+		// written before AutoTimestamps, the creation time is unknown
+		delete(stamped, CreatedAtField)
+	}
+	return stamped
+}
+
+// getStored reads the document at docKey as stored, exists is false if there is none
+func (c *Collection) getStored(docKey []byte, txn mondis.ProviderTxn) (doc bson.M, exists bool, err error) {
+	doc, exists, err = getStoredDoc(docKey, txn)
+	return
+}
+
+// getStoredDoc is getStored for callers without a Collection
+func getStoredDoc(docKey []byte, txn mondis.ProviderTxn) (doc bson.M, exists bool, err error) {
+	v, _, err := txn.Get(docKey)
+	switch err {
+	case nil:
+	case kv.ErrKeyNotFound:
+		err = nil
+		return
+	default:
+		return
+	}
+
+	exists = true
+	err = bson.Unmarshal(v, &doc)
+	return
+}
@@ -0,0 +1,407 @@
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/zhiqiangxu/mondis"
+	dbson "github.com/zhiqiangxu/mondis/document/bson"
+	"github.com/zhiqiangxu/mondis/document/intents"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/numeric"
+	tutil "github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/util/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+// uniqueEntryBatchSize is the max number of documents or entries visited in one txn when entries of an index are backfilled or dropped
+const uniqueEntryBatchSize = 256
+
+// uniqueField is a unique index on field alone, whose entries map a value of field to the id of the document having it
+type uniqueField struct {
+	iid   int64
+	field string
+}
+
+// isUniqueField tells whether idef is an index whose entries are maintained by writes
+func isUniqueField(idef *IndexDefinition) bool {
+	return idef.Option.Unique && len(idef.Fields) == 1
+}
+
+// hasUniqueFields tells whether writes maintain entries of any index, so that the stored document is needed
+func (c *Collection) hasUniqueFields() (has bool) {
+	c.mu.RLock()
+	for _, idef := range c.indexMap {
+		if isUniqueField(&idef) {
+			has = true
+			break
+		}
+	}
+	c.mu.RUnlock()
+	return
+}
+
+// uniqueFields returns the unique indexes on a single field as of txn
+func (c *Collection) uniqueFields(txn mondis.ProviderTxn) (fields []uniqueField, err error) {
+	var names, indexed []string
+	c.mu.RLock()
+	for _, idef := range c.indexMap {
+		if isUniqueField(&idef) {
+			names = append(names, idef.Name)
+			indexed = append(indexed, idef.Fields[0].Name)
+		}
+	}
+	c.mu.RUnlock()
+
+	for i, name := range names {
+		var iid int64
+		iid, err = indexID(c.cid, name, txn)
+		if err == kv.ErrKeyNotFound {
+			// dropped by another process
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		fields = append(fields, uniqueField{iid: iid, field: indexed[i]})
+	}
+	return
+}
+
+// uniqueEntryKey returns the key of the entry for the value of field in doc,
+// nil if doc doesn't have the field or the value can't be indexed.
+func (c *Collection) uniqueEntryKey(uf uniqueField, doc bson.M) kv.Key {
+	value, ok := doc[uf.field]
+	if !ok {
+		return nil
+	}
+	key, err := EncodeCollectionUniqueIndexKey(nil, c.cid, uf.iid, value)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// putUniqueEntries moves the unique index entries of did from the values in old to those in doc,
+// old is nil for a new document and doc is nil for a deleted one.
+// ErrUniqueViolated is returned if a value in doc is taken by another document,
+// and an entry is only deleted by the document it maps to.
+func (c *Collection) putUniqueEntries(did int64, old, doc bson.M, meta *mondis.VMetaReq, txn mondis.ProviderTxn) (err error) {
+	fields, err := c.uniqueFields(txn)
+	if err != nil {
+		return
+	}
+
+	for _, uf := range fields {
+		oldKey, key := c.uniqueEntryKey(uf, old), c.uniqueEntryKey(uf, doc)
+		if key != nil {
+			err = checkUniqueEntry(c.cid, uf, key, did, doc[uf.field], txn)
+			if err != nil {
+				return
+			}
+		}
+		if oldKey != nil && !bytes.Equal(oldKey, key) {
+			err = c.deleteUniqueEntry(oldKey, did, txn)
+			if err != nil {
+				return
+			}
+		}
+		if key != nil {
+			// rewritten even if unchanged, so that its TTL follows the document
+			err = txn.Set(key, numeric.Encode2Binary(uint64(did), nil), meta)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// checkUniqueEntry returns ErrUniqueViolated if the entry at key maps to a document of collection cid other than did still having value,
+// an entry whose document is gone or has another value is stale and can be taken.
+func checkUniqueEntry(cid int64, uf uniqueField, key kv.Key, did int64, value interface{}, txn mondis.ProviderTxn) (err error) {
+	owner, exists, err := uniqueEntryDid(key, txn)
+	if err != nil || !exists || owner == did {
+		return
+	}
+
+	other, exists, err := getStoredDoc(EncodeCollectionDocumentKey(nil, cid, owner), txn)
+	if err != nil || !exists {
+		return
+	}
+	if sameUniqueValue(other[uf.field], value) {
+		err = ErrUniqueViolated
+	}
+	return
+}
+
+// deleteUniqueEntry deletes the entry at key if it maps to did
+func (c *Collection) deleteUniqueEntry(key kv.Key, did int64, txn mondis.ProviderTxn) (err error) {
+	owner, exists, err := uniqueEntryDid(key, txn)
+	if err != nil || !exists || owner != did {
+		return
+	}
+
+	err = txn.Delete(key)
+	return
+}
+
+// uniqueEntryDid returns the document id the entry at key maps to
+func uniqueEntryDid(key kv.Key, txn mondis.ProviderTxn) (did int64, exists bool, err error) {
+	v, _, err := txn.Get(key)
+	if err == kv.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	udid, err := numeric.DecodeFromBinary(v)
+	if err != nil {
+		return
+	}
+	did = int64(udid)
+	exists = true
+	return
+}
+
+// intentKindUniqueBackfill is the intent kind of backfilling a unique index on a single field,
+// a backfill interrupted by a crash is resumed by intents.Recover.
+const intentKindUniqueBackfill = "document.unique_backfill"
+
+// uniqueBackfillLease is the lease of the intent of a backfill, which is renewed by each batch
+var uniqueBackfillLease = intents.DefaultLease
+
+func init() {
+	err := intents.RegisterRecovery(intentKindUniqueBackfill, recoverUniqueBackfill)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// uniqueBackfill is the payload of intentKindUniqueBackfill
+type uniqueBackfill struct {
+	Cid   int64
+	Iid   int64
+	Name  string
+	Field string
+	// Offset is the document key the backfill resumes from
+	Offset []byte
+}
+
+// beginUniqueBackfill records the backfill of index iid on field in txn that creates the index
+func beginUniqueBackfill(cid, iid int64, name, field string, txn mondis.ProviderTxn) (id int64, bf *uniqueBackfill, err error) {
+	bf = &uniqueBackfill{Cid: cid, Iid: iid, Name: name, Field: field, Offset: AppendCollectionDocumentPrefix(nil, cid)}
+	payload, err := json.Marshal(bf)
+	if err != nil {
+		return
+	}
+	id, err = intents.BeginWithLease(intentKindUniqueBackfill, payload, uniqueBackfillLease, txn)
+	return
+}
+
+func recoverUniqueBackfill(kvdb mondis.KVDB, intent intents.Intent) (err error) {
+	var bf uniqueBackfill
+	err = json.Unmarshal(intent.Payload, &bf)
+	if err != nil {
+		return
+	}
+
+	err = runUniqueBackfill(kvdb, intent.ID, &bf)
+	if err == ErrUniqueViolated {
+		logger.Instance().Warn("unique index dropped for existing duplicates", zap.Int64("cid", bf.Cid), zap.String("name", bf.Name))
+		err = nil
+	}
+	return
+}
+
+// runUniqueBackfill writes entries for documents written before the index of bf is created,
+// in txns of uniqueEntryBatchSize documents, each recording its progress in intent id, which is completed in the last one.
+// If two documents have the same value, the index is dropped and ErrUniqueViolated is returned,
+// if the index is dropped meanwhile, its entries are deleted instead.
+func runUniqueBackfill(kvdb mondis.KVDB, id int64, bf *uniqueBackfill) (err error) {
+	uf := uniqueField{iid: bf.Iid, field: bf.Field}
+	for {
+		var (
+			dropped, done bool
+			offset        []byte
+		)
+		err = tutil.RunInNewUpdateTxnWithRetry(kvdb, func(txn mondis.ProviderTxn) (err error) {
+			dropped, err = uniqueIndexDropped(bf, txn)
+			if err != nil || dropped {
+				return
+			}
+
+			visited, lastDid, err := backfillUniqueEntryBatch(bf.Cid, uf, bf.Offset, txn)
+			if err != nil {
+				return
+			}
+			if visited < uniqueEntryBatchSize {
+				done = true
+				err = intents.Complete(id, txn)
+				return
+			}
+
+			offset = EncodeCollectionDocumentKey(nil, bf.Cid, lastDid+1)
+			progress := *bf
+			progress.Offset = offset
+			payload, err := json.Marshal(&progress)
+			if err != nil {
+				return
+			}
+			err = intents.Update(id, payload, txn)
+			return
+		}, upsertMaxRetries)
+
+		violated := err == ErrUniqueViolated
+		if violated {
+			err = tutil.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+				gone, err := uniqueIndexDropped(bf, txn)
+				if err != nil || gone {
+					return
+				}
+				_, _, _, err = dropIndexMeta(bf.Cid, bf.Name, txn)
+				return
+			})
+			dropped = err == nil
+		}
+		if err != nil || done {
+			return
+		}
+
+		if dropped {
+			err = dropUniqueEntries(kvdb, bf.Cid, bf.Iid)
+			if err != nil {
+				return
+			}
+			err = tutil.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) error {
+				return intents.Complete(id, txn)
+			})
+			if err == intents.ErrIntentNotFound {
+				err = nil
+			}
+			if err == nil && violated {
+				err = ErrUniqueViolated
+			}
+			return
+		}
+		bf.Offset = offset
+	}
+}
+
+// uniqueIndexDropped tells whether the index of bf is dropped, the name may be taken by a newer index
+func uniqueIndexDropped(bf *uniqueBackfill, txn mondis.ProviderTxn) (dropped bool, err error) {
+	iid, err := indexID(bf.Cid, bf.Name, txn)
+	if err == kv.ErrKeyNotFound {
+		dropped, err = true, nil
+		return
+	}
+	dropped = err == nil && iid != bf.Iid
+	return
+}
+
+func backfillUniqueEntryBatch(cid int64, uf uniqueField, offset kv.Key, txn mondis.ProviderTxn) (visited int, lastDid int64, err error) {
+	var (
+		dids   []int64
+		keys   []kv.Key
+		values []interface{}
+		fnErr  error
+	)
+	scanErr := txn.Scan(mondis.ProviderScanOption{Prefix: AppendCollectionDocumentPrefix(nil, cid), Offset: offset}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		_, lastDid, fnErr = DecodeCollectionDocumentKey(key)
+		if fnErr != nil {
+			return false
+		}
+		visited++
+
+		var doc bson.M
+		fnErr = bson.Unmarshal(value, &doc)
+		if fnErr != nil {
+			return false
+		}
+		fieldValue, ok := doc[uf.field]
+		if !ok {
+			return visited < uniqueEntryBatchSize
+		}
+		entryKey, keyErr := EncodeCollectionUniqueIndexKey(nil, cid, uf.iid, fieldValue)
+		if keyErr == nil {
+			dids = append(dids, lastDid)
+			keys = append(keys, entryKey)
+			values = append(values, fieldValue)
+		}
+		return visited < uniqueEntryBatchSize
+	})
+	if fnErr != nil {
+		err = fnErr
+		return
+	}
+	if scanErr != nil {
+		err = scanErr
+		return
+	}
+
+	for i, key := range keys {
+		err = checkUniqueEntry(cid, uf, key, dids[i], values[i], txn)
+		if err != nil {
+			return
+		}
+		err = txn.Set(key, numeric.Encode2Binary(uint64(dids[i]), nil), nil)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// dropUniqueEntries deletes all entries of index iid of collection cid, in txns of uniqueEntryBatchSize entries
+func dropUniqueEntries(kvdb mondis.KVDB, cid, iid int64) (err error) {
+	prefix := AppendCollectionUniqueIndexPrefix(nil, cid, iid)
+	for {
+		var n int
+		err = tutil.RunInNewUpdateTxnWithRetry(kvdb, func(txn mondis.ProviderTxn) (err error) {
+			n, err = deletePrefix(prefix, uniqueEntryBatchSize, txn)
+			return
+		}, upsertMaxRetries)
+		if err != nil || n < uniqueEntryBatchSize {
+			return
+		}
+	}
+}
+
+// deletePrefix deletes at most limit keys under prefix, limit is unlimited if not positive
+func deletePrefix(prefix []byte, limit int, txn mondis.ProviderTxn) (n int, err error) {
+	var keys [][]byte
+	scanErr := txn.Scan(mondis.ProviderScanOption{Prefix: prefix, KeysOnly: true}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		keys = append(keys, append([]byte(nil), key...))
+		return limit <= 0 || len(keys) < limit
+	})
+	if scanErr != nil {
+		err = scanErr
+		return
+	}
+
+	for _, key := range keys {
+		err = txn.Delete(key)
+		if err != nil {
+			return
+		}
+	}
+	n = len(keys)
+	return
+}
+
+// sameUniqueValue tells whether a and b are the same value of a unique index
+func sameUniqueValue(a, b interface{}) bool {
+	aKey, err := dbson.AppendIndexValue(nil, a, false)
+	if err != nil {
+		return false
+	}
+	bKey, err := dbson.AppendIndexValue(nil, b, false)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aKey, bKey)
+}
@@ -0,0 +1,85 @@
+package document
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/intents"
+	"github.com/zhiqiangxu/mondis/provider"
+	"github.com/zhiqiangxu/mondis/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"gotest.tools/assert"
+)
+
+func TestUniqueBackfillRecovery(t *testing.T) {
+	uniqueBackfillLease = time.Millisecond
+	defer func() {
+		uniqueBackfillLease = intents.DefaultLease
+	}()
+
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	// more than one batch
+	for i := 0; i < uniqueEntryBatchSize+10; i++ {
+		_, err = c.InsertOne(bson.M{"k": fmt.Sprintf("%d", i)}, nil)
+		assert.Assert(t, err == nil)
+	}
+	idef := IndexDefinition{Name: "k", Fields: []IndexField{{Name: "k"}}, Option: IndexOption{Unique: true}}
+	iid, err := c.CreateIndex(idef)
+	assert.Assert(t, err == nil)
+
+	// crashed right after the index is created, before any entry is backfilled
+	crash := func() {
+		err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+			_, err = deletePrefix(AppendCollectionUniqueIndexPrefix(nil, c.cid, iid), 0, txn)
+			if err != nil {
+				return
+			}
+			_, _, err = beginUniqueBackfill(c.cid, iid, idef.Name, "k", txn)
+			return
+		})
+		assert.Assert(t, err == nil)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	crash()
+	n, err := intents.Recover(kvdb)
+	assert.Assert(t, err == nil && n == 1, n, err)
+	inserted, updated, err := c.UpsertByKey("k", []bson.M{{"k": "0"}, {"k": fmt.Sprintf("%d", uniqueEntryBatchSize+9)}}, nil)
+	assert.Assert(t, err == nil && inserted == 0 && updated == 2, inserted, updated, err)
+
+	// a duplicate written meanwhile rolls the index back
+	data, err := bson.Marshal(bson.M{"k": "0"})
+	assert.Assert(t, err == nil)
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) error {
+		return txn.Set(EncodeCollectionDocumentKey(nil, c.cid, 1<<40), data, nil)
+	})
+	assert.Assert(t, err == nil)
+	crash()
+	n, err = intents.Recover(kvdb)
+	assert.Assert(t, err == nil && n == 1, n, err)
+	err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		indexes, err := c.getIndexes(txn)
+		assert.Assert(t, err == nil && len(indexes) == 0, indexes)
+		entries := 0
+		err = txn.Scan(mondis.ProviderScanOption{Prefix: AppendCollectionUniqueIndexPrefix(nil, c.cid, iid), KeysOnly: true}, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+			entries++
+			return true
+		})
+		assert.Assert(t, err == nil && entries == 0, entries)
+		dangling, err := intents.List(txn)
+		assert.Assert(t, err == nil && len(dangling) == 0, dangling)
+		return
+	})
+	assert.Assert(t, err == nil)
+}
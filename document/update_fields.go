@@ -0,0 +1,301 @@
+package document
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// operators supported by UpdateFields
+const (
+	// UpdateOpSet sets fields to values
+	UpdateOpSet = "$set"
+	// UpdateOpInc adds numbers to fields, a missing field counts as 0
+	UpdateOpInc = "$inc"
+)
+
+var (
+	// ErrInvalidUpdate when an update of UpdateFields is malformed,
+	// e.g. unknown operator, empty field path, conflicting paths, or a path through a non document value
+	ErrInvalidUpdate = errors.New("invalid update")
+	// ErrIncNonNumeric when $inc is given or applied to a non numeric value
+	ErrIncNonNumeric = errors.New("$inc of non numeric value")
+)
+
+// fieldUpdate is a field mutation of UpdateFields, path is the dotted field name split
+type fieldUpdate struct {
+	path  []string
+	inc   bool
+	value interface{}
+}
+
+// UpdateFields applies $set and $inc of update to the existing document did, other fields are kept,
+// ErrDocNotFound is returned if the document doesn't exist.
+// Field names can be dotted for nested documents, which are created if missing.
+// The read and the write are in the same txn, which is retried on conflict if it's implicit,
+// so concurrent callers touching different fields don't overwrite each other.
+func (c *Collection) UpdateFields(did int64, update bson.M, txn mondis.ProviderTxn) (err error) {
+	if c.db.metrics != nil {
+		defer c.observeOp(MetricOpUpdate, time.Now(), &err)
+	}
+
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	updates, err := c.parseFieldUpdates(update)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	// the updated size is unknown until read, update size is used
+	updateData, err := bson.Marshal(update)
+	if err != nil {
+		return
+	}
+	err = c.waitRateLimit(len(updateData))
+	if err != nil {
+		return
+	}
+
+	docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+
+	updateFunc := func(txn mondis.ProviderTxn) (err error) {
+		doc, exists, err := c.getStored(docKey, txn)
+		if err != nil {
+			return
+		}
+		if !exists {
+			err = ErrDocNotFound
+			return
+		}
+
+		// values of the touched fields before the update, for unique index entries
+		old := make(bson.M, len(updates))
+		for _, u := range updates {
+			if value, ok := doc[u.path[0]]; ok {
+				old[u.path[0]] = value
+			}
+		}
+
+		touched := make(bson.M, len(updates))
+		for _, u := range updates {
+			err = u.apply(doc)
+			if err != nil {
+				return
+			}
+			touched[u.path[0]] = doc[u.path[0]]
+		}
+		for field, value := range c.stamp(touched, doc, false) {
+			doc[field] = value
+		}
+
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return
+		}
+
+		err = txn.Set(docKey, data, nil)
+		if err != nil {
+			return
+		}
+		err = c.putUniqueEntries(did, old, touched, nil, txn)
+		return
+	}
+
+	if txn == nil {
+		err = c.runInImplicitUpdateTxnWithRetry(updateFunc, upsertMaxRetries)
+	} else {
+		err = updateFunc(txn)
+	}
+
+	return
+}
+
+// parseFieldUpdates validates update, ErrInvalidUpdate is returned if a path is a prefix of another
+func (c *Collection) parseFieldUpdates(update bson.M) (updates []fieldUpdate, err error) {
+	for op, arg := range update {
+		var inc bool
+		switch op {
+		case UpdateOpSet:
+		case UpdateOpInc:
+			inc = true
+		default:
+			err = ErrInvalidUpdate
+			return
+		}
+
+		var fields map[string]interface{}
+		switch arg := arg.(type) {
+		case bson.M:
+			fields = arg
+		case map[string]interface{}:
+			fields = arg
+		default:
+			err = ErrInvalidUpdate
+			return
+		}
+
+		for field, value := range fields {
+			path := strings.Split(field, ".")
+			for _, name := range path {
+				if name == "" {
+					err = ErrInvalidUpdate
+					return
+				}
+			}
+			if strings.HasPrefix(path[0], c.db.reservedFieldPrefix) {
+				err = ErrReservedField
+				return
+			}
+			if inc && !isNumber(value) {
+				err = ErrIncNonNumeric
+				return
+			}
+			updates = append(updates, fieldUpdate{path: path, inc: inc, value: value})
+		}
+	}
+	if len(updates) == 0 {
+		err = ErrInvalidUpdate
+		return
+	}
+
+	// a prefix sorts right before the paths it's a prefix of
+	fields := make([]string, 0, len(updates))
+	for _, u := range updates {
+		fields = append(fields, strings.Join(u.path, "."))
+	}
+	sort.Strings(fields)
+	for i := 1; i < len(fields); i++ {
+		if fields[i] == fields[i-1] || strings.HasPrefix(fields[i], fields[i-1]+".") {
+			err = ErrInvalidUpdate
+			return
+		}
+	}
+	return
+}
+
+// apply u to doc in place
+func (u *fieldUpdate) apply(doc bson.M) (err error) {
+	parent := doc
+	for _, name := range u.path[:len(u.path)-1] {
+		switch child := parent[name].(type) {
+		case nil:
+			if _, ok := parent[name]; ok {
+				// present as null
+				err = ErrInvalidUpdate
+				return
+			}
+			created := bson.M{}
+			parent[name] = created
+			parent = created
+		case bson.M:
+			parent = child
+		default:
+			err = ErrInvalidUpdate
+			return
+		}
+	}
+
+	name := u.path[len(u.path)-1]
+	if !u.inc {
+		parent[name] = u.value
+		return
+	}
+
+	current, ok := parent[name]
+	if !ok {
+		current = int32(0)
+	}
+	parent[name], err = incNumber(current, u.value)
+	return
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int32, int64, float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// incNumber returns current + delta, which is float64 if either is,
+// int32 if both fit in int32 and so does the sum, int64 otherwise.
+// kv.ErrOverflow is returned if the sum of integers overflows int64.
+func incNumber(current, delta interface{}) (sum interface{}, err error) {
+	if !isNumber(current) {
+		err = ErrIncNonNumeric
+		return
+	}
+
+	cf, cFloat := current.(float64)
+	df, dFloat := delta.(float64)
+	if cFloat || dFloat {
+		if !cFloat {
+			cf = float64(toInt64(current))
+		}
+		if !dFloat {
+			df = float64(toInt64(delta))
+		}
+		sum = cf + df
+		return
+	}
+
+	ci, di := toInt64(current), toInt64(delta)
+	if (di > 0 && ci > math.MaxInt64-di) || (di < 0 && ci < math.MinInt64-di) {
+		err = kv.ErrOverflow
+		return
+	}
+	n := ci + di
+	if fitsInt32(current) && fitsInt32(delta) && n >= math.MinInt32 && n <= math.MaxInt32 {
+		sum = int32(n)
+		return
+	}
+	sum = n
+	return
+}
+
+// toInt64 for integers accepted by isNumber
+func toInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	default:
+		return v.(int64)
+	}
+}
+
+// fitsInt32 tells whether the integer v is stored as int32 by bson, which is the case for int within range
+func fitsInt32(v interface{}) bool {
+	switch v := v.(type) {
+	case int32:
+		return true
+	case int:
+		return v >= math.MinInt32 && v <= math.MaxInt32
+	default:
+		return false
+	}
+}
@@ -0,0 +1,277 @@
+package document
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/compact"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// upsertBatchSize is the number of documents upserted in one txn when txn is not specified
+	upsertBatchSize = 100
+	// upsertMaxRetries is the max retries of a batch on txn conflict
+	upsertMaxRetries = 10
+)
+
+var (
+	// ErrUniqueIndexRequired when there is no unique index on the key field alone
+	ErrUniqueIndexRequired = errors.New("unique index on key field required")
+	// ErrKeyFieldMissing when document doesn't have the key field
+	ErrKeyFieldMissing = errors.New("key field missing in document")
+	// ErrUniqueViolated when the value of a unique index on a single field is taken by another document
+	ErrUniqueViolated = errors.New("unique index violated")
+)
+
+// UpsertByKey replaces the document having the same keyField value for each of docs, or inserts it if not exists.
+// A unique index on keyField alone is required, whose entries are maintained by every write of the collection,
+// and the document an entry maps to is only replaced if it still has the value.
+// When txn is nil, docs are upserted in batches of upsertBatchSize, each in its own txn retried on conflict,
+// so batches committed before an error stay committed.
+func (c *Collection) UpsertByKey(keyField string, docs []bson.M, txn mondis.ProviderTxn) (inserted, updated int, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	// prologue start
+	err = c.db.checkState()
+	if err != nil {
+		return
+	}
+	err = c.db.closer.Add(1)
+	if err != nil {
+		return
+	}
+	defer c.db.closer.Done()
+	// prologue end
+
+	iid, err := c.uniqueIndexID(keyField)
+	if err != nil {
+		return
+	}
+
+	if txn != nil {
+		inserted, updated, err = c.upsertByKey(iid, keyField, docs, txn)
+		return
+	}
+
+	for start := 0; start < len(docs); start += upsertBatchSize {
+		end := start + upsertBatchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+
+		var batchInserted, batchUpdated int
+		err = c.runInImplicitUpdateTxnWithRetry(func(txn mondis.ProviderTxn) (err error) {
+			batchInserted, batchUpdated, err = c.upsertByKey(iid, keyField, docs[start:end], txn)
+			return
+		}, upsertMaxRetries)
+		if err != nil {
+			return
+		}
+		inserted += batchInserted
+		updated += batchUpdated
+	}
+	return
+}
+
+func (c *Collection) upsertByKey(iid int64, keyField string, docs []bson.M, txn mondis.ProviderTxn) (inserted, updated int, err error) {
+	for _, doc := range docs {
+		value, ok := doc[keyField]
+		if !ok {
+			err = ErrKeyFieldMissing
+			return
+		}
+		err = c.checkUserFields(doc)
+		if err != nil {
+			return
+		}
+
+		var data []byte
+		data, err = bson.Marshal(doc)
+		if err != nil {
+			return
+		}
+
+		err = c.waitRateLimit(len(data))
+		if err != nil {
+			return
+		}
+
+		var indexKey kv.Key
+		indexKey, err = EncodeCollectionUniqueIndexKey(nil, c.cid, iid, value)
+		if err != nil {
+			return
+		}
+
+		var (
+			did       int64
+			found     bool
+			old       bson.M
+			docExists bool
+		)
+		did, found, err = uniqueEntryDid(indexKey, txn)
+		if err != nil {
+			return
+		}
+		if found {
+			old, docExists, err = c.getStored(EncodeCollectionDocumentKey(nil, c.cid, did), txn)
+			if err != nil {
+				return
+			}
+			// the entry may be stale if written by a process unaware of the index, e.g. before it was created
+			if docExists && !sameUniqueValue(old[keyField], value) {
+				old, docExists = nil, false
+			}
+		}
+		if !docExists {
+			var udid uint64
+			udid, err = c.documentSequence.Next()
+			if err != nil {
+				return
+			}
+			did = int64(udid)
+		}
+
+		docKey := EncodeCollectionDocumentKey(nil, c.cid, did)
+		if c.creationTimeIndex() {
+			var carried bson.M
+			carried, err = c.carryCreatedAt(doc, old, !docExists, did, nil, txn)
+			if err != nil {
+				return
+			}
+			data, err = bson.Marshal(carried)
+			if err != nil {
+				return
+			}
+		}
+
+		err = txn.Set(docKey, data, nil)
+		if err != nil {
+			return
+		}
+		err = c.putUniqueEntries(did, old, doc, nil, txn)
+		if err != nil {
+			return
+		}
+		if docExists {
+			updated++
+		} else {
+			inserted++
+		}
+	}
+	return
+}
+
+// uniqueIndexID returns the id of the unique index on field alone
+func (c *Collection) uniqueIndexID(field string) (iid int64, err error) {
+	var name string
+	c.mu.RLock()
+	for _, idef := range c.indexMap {
+		if idef.Option.Unique && len(idef.Fields) == 1 && idef.Fields[0].Name == field {
+			name = idef.Name
+			break
+		}
+	}
+	c.mu.RUnlock()
+	if name == "" {
+		err = ErrUniqueIndexRequired
+		return
+	}
+
+	txn := c.kvdb.NewTransaction(false)
+	defer txn.Discard()
+
+	v, _, err := txn.Get(EncodeCollectionIndexName2IDKey(nil, c.cid, name))
+	if err == kv.ErrKeyNotFound {
+		// dropped by another process
+		err = ErrUniqueIndexRequired
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	_, iid, err = compact.DecodeVarint(v)
+	return
+}
+
+// ApplyBatch replaces the document of each id in entries, or inserts it if not exists, which makes replaying a batch idempotent.
+// inserted and replaced are the ids written each way, in ascending order.
+// When txn is nil, entries are applied in ascending id order in batches of upsertBatchSize,
+// each in its own txn retried on conflict, so batches committed before an error stay committed and are reported.
+func (c *Collection) ApplyBatch(entries map[int64]bson.M, txn mondis.ProviderTxn) (inserted, replaced []int64, err error) {
+	err = c.checkKind(model.CollectionKindDocument)
+	if err != nil {
+		return
+	}
+
+	dids := make([]int64, 0, len(entries))
+	for did := range entries {
+		dids = append(dids, did)
+	}
+	sort.Slice(dids, func(i, j int) bool {
+		return dids[i] < dids[j]
+	})
+
+	if txn != nil {
+		inserted, replaced, err = c.applyBatch(dids, entries, txn)
+		return
+	}
+
+	for start := 0; start < len(dids); start += upsertBatchSize {
+		end := start + upsertBatchSize
+		if end > len(dids) {
+			end = len(dids)
+		}
+
+		var batchInserted, batchReplaced []int64
+		err = c.runInImplicitUpdateTxnWithRetry(func(txn mondis.ProviderTxn) (err error) {
+			batchInserted, batchReplaced, err = c.applyBatch(dids[start:end], entries, txn)
+			return
+		}, upsertMaxRetries)
+		if err != nil {
+			return
+		}
+		inserted = append(inserted, batchInserted...)
+		replaced = append(replaced, batchReplaced...)
+	}
+	return
+}
+
+func (c *Collection) applyBatch(dids []int64, entries map[int64]bson.M, txn mondis.ProviderTxn) (inserted, replaced []int64, err error) {
+	for _, did := range dids {
+		var isNew bool
+		_, isNew, _, err = c.updateOne(did, entries[did], updateForUpsert, WriteOption{}, txn)
+		if err != nil {
+			return
+		}
+		if isNew {
+			inserted = append(inserted, did)
+		} else {
+			replaced = append(replaced, did)
+		}
+	}
+	return
+}
+
+// UpsertOneReturning is like UpsertOne but also returns the document as stored,
+// which is decoded from the stored bytes so that value types are the same as GetOne, e.g. int becomes int32.
+func (c *Collection) UpsertOneReturning(did int64, doc bson.M, txn mondis.ProviderTxn) (result bson.M, isNew bool, err error) {
+	_, isNew, data, err := c.updateOne(did, doc, updateForUpsert, WriteOption{}, txn)
+	if err != nil {
+		return
+	}
+
+	err = bson.Unmarshal(data, &result)
+	if err != nil {
+		return
+	}
+	c.stripSystemFields(result)
+	return
+}
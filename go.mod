@@ -3,8 +3,9 @@ module github.com/zhiqiangxu/mondis
 go 1.12
 
 require (
+	github.com/cockroachdb/pebble v1.1.5
 	github.com/dgraph-io/badger v1.6.0
-	github.com/gogo/protobuf v1.3.1
+	github.com/gogo/protobuf v1.3.2
 	github.com/syndtr/goleveldb v1.0.0
 	github.com/zhiqiangxu/qrpc v0.0.0-20200225135606-e2574f37b838
 	github.com/zhiqiangxu/util v0.0.0-20200325101007-74f059bfa75e
@@ -7,4 +7,18 @@ var (
 	ErrTxnTooBig = errors.New("transaction too big")
 	// ErrKeyNotFound when key not found
 	ErrKeyNotFound = errors.New("key not found")
+	// ErrTxnConflict when transaction conflicts with another one on commit
+	ErrTxnConflict = errors.New("transaction conflict")
+	// ErrVersionUnavailable when the requested version is garbage collected or not supported
+	ErrVersionUnavailable = errors.New("version unavailable")
+	// ErrOverflow when integer overflows
+	ErrOverflow = errors.New("integer overflow")
+	// ErrEstimateUnavailable when key estimate is not supported
+	ErrEstimateUnavailable = errors.New("estimate unavailable")
+	// ErrBackupUnavailable when backup and restore are not supported
+	ErrBackupUnavailable = errors.New("backup unavailable")
+	// ErrOptionUnsupported when a KVOption is not supported by the provider
+	ErrOptionUnsupported = errors.New("option unsupported")
+	// ErrMetaUnsupported when a VMetaReq is passed to a provider not supporting TTL and tag
+	ErrMetaUnsupported = errors.New("meta unsupported")
 )
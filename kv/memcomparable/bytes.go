@@ -50,6 +50,19 @@ func EncodeBytes(b []byte, data []byte) []byte {
 	return result
 }
 
+// EncodeBytesPrefix appends the common prefix of EncodeBytes of all data starting with prefix to b,
+// which is the full groups of prefix with their markers followed by the rest of prefix without padding.
+// Data without prefix may still share it when it's padded with 0 where prefix has 0, callers should check decoded data.
+func EncodeBytesPrefix(b []byte, prefix []byte) []byte {
+	full := len(prefix) / encGroupSize * encGroupSize
+	result := b
+	for idx := 0; idx < full; idx += encGroupSize {
+		result = append(result, prefix[idx:idx+encGroupSize]...)
+		result = append(result, encMarker)
+	}
+	return append(result, prefix[full:]...)
+}
+
 // EncodedBytesLength returns the length of data after encoded
 func EncodedBytesLength(dataLen int) int {
 	mod := dataLen % encGroupSize
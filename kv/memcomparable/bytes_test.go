@@ -41,3 +41,17 @@ func TestBytes(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestBytesPrefix(t *testing.T) {
+	for _, prefix := range [][]byte{{}, []byte("abc"), []byte("abcdefgh"), []byte("abcdefghij")} {
+		encoded := EncodeBytesPrefix(nil, prefix)
+		for _, data := range [][]byte{prefix, append(append([]byte{}, prefix...), 'x'), append(append([]byte{}, prefix...), "123456789"...)} {
+			if !bytes.HasPrefix(EncodeBytes(nil, data), encoded) {
+				t.Fatalf("prefix %q data %q", prefix, data)
+			}
+		}
+		if len(prefix) > 0 && bytes.HasPrefix(EncodeBytes(nil, prefix[:len(prefix)-1]), encoded) {
+			t.Fatalf("prefix %q", prefix)
+		}
+	}
+}
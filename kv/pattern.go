@@ -0,0 +1,140 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+)
+
+const (
+	// MaxKeyPatternLen is the max length of key pattern
+	MaxKeyPatternLen = 256
+	// MaxKeyPatternStars is the max number of * in key pattern
+	MaxKeyPatternStars = 8
+)
+
+var (
+	// ErrInvalidKeyPattern when key pattern is malformed
+	ErrInvalidKeyPattern = errors.New("invalid key pattern")
+	// ErrKeyPatternTooExpensive when key pattern exceeds MaxKeyPatternLen or MaxKeyPatternStars
+	ErrKeyPatternTooExpensive = errors.New("key pattern too expensive")
+)
+
+type patternToken struct {
+	star bool
+	any  bool // ?
+	b    byte
+}
+
+// KeyPattern is a compiled glob on keys,
+// * matches any bytes, ? matches a single byte, \ escapes the next byte.
+type KeyPattern struct {
+	prefix []byte
+	tokens []patternToken
+}
+
+// CompileKeyPattern compiles glob pattern
+func CompileKeyPattern(pattern string) (p *KeyPattern, err error) {
+	if len(pattern) > MaxKeyPatternLen {
+		err = ErrKeyPatternTooExpensive
+		return
+	}
+
+	p = &KeyPattern{}
+	stars := 0
+	static := true
+	for i := 0; i < len(pattern); i++ {
+		var token patternToken
+		switch pattern[i] {
+		case '*':
+			stars++
+			if stars > MaxKeyPatternStars {
+				p = nil
+				err = ErrKeyPatternTooExpensive
+				return
+			}
+			if len(p.tokens) > 0 && p.tokens[len(p.tokens)-1].star {
+				// consecutive stars are the same as one
+				continue
+			}
+			token.star = true
+		case '?':
+			token.any = true
+		case '\\':
+			i++
+			if i == len(pattern) {
+				p = nil
+				err = ErrInvalidKeyPattern
+				return
+			}
+			token.b = pattern[i]
+		default:
+			token.b = pattern[i]
+		}
+
+		if token.star || token.any {
+			static = false
+		}
+		if static {
+			p.prefix = append(p.prefix, token.b)
+		}
+		p.tokens = append(p.tokens, token)
+	}
+	return
+}
+
+// Prefix returns the static part before the first wildcard
+func (p *KeyPattern) Prefix() []byte {
+	return p.prefix
+}
+
+// Match returns whether key matches the pattern
+func (p *KeyPattern) Match(key []byte) bool {
+	if !bytes.HasPrefix(key, p.prefix) {
+		return false
+	}
+
+	// greedy matching that backtracks to the last star only, which is O(len(key)*len(tokens))
+	ti, ki := len(p.prefix), len(p.prefix)
+	starTi, starKi := -1, 0
+	for ki < len(key) {
+		if ti < len(p.tokens) {
+			token := p.tokens[ti]
+			if token.star {
+				starTi, starKi = ti, ki
+				ti++
+				continue
+			}
+			if token.any || token.b == key[ki] {
+				ti++
+				ki++
+				continue
+			}
+		}
+		if starTi < 0 {
+			return false
+		}
+		// let the last star consume one more byte
+		starKi++
+		ti, ki = starTi+1, starKi
+	}
+
+	for ; ti < len(p.tokens); ti++ {
+		if !p.tokens[ti].star {
+			return false
+		}
+	}
+	return true
+}
+
+// NarrowPrefix returns the prefix to seek for scanning keys with prefix that match p,
+// ok is false if no such key can exist.
+func (p *KeyPattern) NarrowPrefix(prefix []byte) (narrowed []byte, ok bool) {
+	switch {
+	case bytes.HasPrefix(p.prefix, prefix):
+		return p.prefix, true
+	case bytes.HasPrefix(prefix, p.prefix):
+		return prefix, true
+	default:
+		return nil, false
+	}
+}
@@ -1,11 +1,15 @@
 package kv
 
 import (
+	"bytes"
+	"math"
+
 	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/kv/numeric"
 )
 
-// IncInt64 increases the value for key k in kv store by step.
+// IncInt64 increases the value for key k in kv store by step,
+// ErrOverflow is returned instead of wrapping around.
 func IncInt64(txn mondis.ProviderTxn, k Key, step int64) (n int64, err error) {
 	v, _, err := txn.Get(k)
 	if err == ErrKeyNotFound {
@@ -25,6 +29,10 @@ func IncInt64(txn mondis.ProviderTxn, k Key, step int64) (n int64, err error) {
 		return
 	}
 
+	if addOverflows(n, step) {
+		err = ErrOverflow
+		return
+	}
 	n += step
 	if err = txn.Set(k, numeric.Encode2Human(n), nil); err != nil {
 		return
@@ -48,3 +56,81 @@ func SetInt64(txn mondis.ProviderTxn, k Key, v int64) (err error) {
 	err = txn.Set(k, numeric.Encode2Human(v), nil)
 	return
 }
+
+// IncBinaryInt64 is like IncInt64 but the value is stored as 8-byte big-endian,
+// ErrOverflow is returned instead of wrapping around.
+func IncBinaryInt64(txn mondis.ProviderTxn, k Key, step int64) (n int64, err error) {
+	v, _, err := txn.Get(k)
+	switch err {
+	case nil:
+		var u uint64
+		u, err = numeric.DecodeFromBinary(v)
+		if err != nil {
+			return
+		}
+		n = int64(u)
+	case ErrKeyNotFound:
+		err = nil
+	default:
+		return
+	}
+
+	if addOverflows(n, step) {
+		err = ErrOverflow
+		return
+	}
+
+	n += step
+	err = txn.Set(k, numeric.Encode2Binary(uint64(n), nil), nil)
+	return
+}
+
+// addOverflows tells whether n+step overflows int64
+func addOverflows(n, step int64) bool {
+	return (step > 0 && n > math.MaxInt64-step) || (step < 0 && n < math.MinInt64-step)
+}
+
+// CompareAndSet sets k to newValue if its current value equals expected, nil expected means k must not exist.
+// current is the value compared, nil if k doesn't exist.
+func CompareAndSet(txn mondis.ProviderTxn, k Key, expected, newValue []byte) (swapped bool, current []byte, err error) {
+	swapped, current, err = compare(txn, k, expected)
+	if err != nil || !swapped {
+		return
+	}
+
+	err = txn.Set(k, newValue, nil)
+	if err != nil {
+		swapped = false
+	}
+	return
+}
+
+// CompareAndDelete is like CompareAndSet but deletes k instead
+func CompareAndDelete(txn mondis.ProviderTxn, k Key, expected []byte) (deleted bool, current []byte, err error) {
+	deleted, current, err = compare(txn, k, expected)
+	if err != nil || !deleted {
+		return
+	}
+
+	err = txn.Delete(k)
+	if err != nil {
+		deleted = false
+	}
+	return
+}
+
+func compare(txn mondis.ProviderTxn, k Key, expected []byte) (match bool, current []byte, err error) {
+	current, _, err = txn.Get(k)
+	switch err {
+	case nil:
+		if current == nil {
+			// present with empty value
+			current = []byte{}
+		}
+		match = expected != nil && bytes.Equal(current, expected)
+	case ErrKeyNotFound:
+		err = nil
+		match = expected == nil
+	}
+	return
+}
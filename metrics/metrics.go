@@ -0,0 +1,351 @@
+// Package metrics is a minimal registry of counters, gauges and histograms exposed in the prometheus text format,
+// so that servers can be scraped by prometheus without depending on its client library.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrDuplicateName when a collector of the same name is registered already
+	ErrDuplicateName = errors.New("duplicate metric name")
+	// ErrLabelCount when the number of label values differs from that of label names
+	ErrLabelCount = errors.New("label count mismatch")
+)
+
+// collector is a metric family registered to Registry
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry for collectors, it's safe for concurrent use
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+// NewRegistry is ctor for Registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, c collector) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		err = ErrDuplicateName
+		return
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+	return
+}
+
+// WriteTo writes all collectors in the order registered in the prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (n int64, err error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	err = bw.Flush()
+	n = cw.n
+	return
+}
+
+// ServeHTTP implements http.Handler for scraping
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
+
+// desc is what's common to all metric families
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP ")
+	w.WriteString(d.name)
+	w.WriteByte(' ')
+	w.WriteString(strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	w.WriteString("\n# TYPE ")
+	w.WriteString(d.name)
+	w.WriteByte(' ')
+	w.WriteString(d.typ)
+	w.WriteByte('\n')
+}
+
+// writeSample writes a line of name{labels} value, extra is an additional label like le of histograms
+func (d *desc) writeSample(w *bufio.Writer, name string, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(values) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, value := range values {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, d.labels[i], value)
+		}
+		if extraName != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	w.WriteString(labelValueReplacer.Replace(value))
+	w.WriteByte('"')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// seriesKey joins label values into a map key
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// vec holds the series of a family by label values, created on first use
+type vec struct {
+	desc
+	mu     sync.RWMutex
+	series map[string]interface{}
+	values map[string][]string
+}
+
+func (v *vec) get(values []string, create func() interface{}) (s interface{}, err error) {
+	if len(values) != len(v.labels) {
+		err = ErrLabelCount
+		return
+	}
+
+	key := seriesKey(values)
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok = v.series[key]
+	if !ok {
+		s = create()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return
+}
+
+// each calls fn for all series ordered by label values
+func (v *vec) each(fn func(values []string, s interface{})) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mu.RLock()
+		values, s := v.values[key], v.series[key]
+		v.mu.RUnlock()
+		fn(values, s)
+	}
+}
+
+func newVec(name, help, typ string, labels []string) vec {
+	return vec{
+		desc:   desc{name: name, help: help, typ: typ, labels: labels},
+		series: make(map[string]interface{}),
+		values: make(map[string][]string),
+	}
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec registers a CounterVec with label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) (c *CounterVec, err error) {
+	c = &CounterVec{vec: newVec(name, help, "counter", labels)}
+	err = r.register(name, c)
+	if err != nil {
+		c = nil
+	}
+	return
+}
+
+// Inc the counter of label values, ErrLabelCount is returned if values don't match label names
+func (c *CounterVec) Inc(values ...string) (err error) {
+	s, err := c.get(values, func() interface{} { return new(uint64) })
+	if err != nil {
+		return
+	}
+	atomic.AddUint64(s.(*uint64), 1)
+	return
+}
+
+// Value of the counter of label values, 0 if it's never increased
+func (c *CounterVec) Value(values ...string) (n uint64) {
+	c.mu.RLock()
+	s, ok := c.series[seriesKey(values)]
+	c.mu.RUnlock()
+	if ok {
+		n = atomic.LoadUint64(s.(*uint64))
+	}
+	return
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, s interface{}) {
+		c.writeSample(w, c.name, values, "", "", float64(atomic.LoadUint64(s.(*uint64))))
+	})
+}
+
+// GaugeFunc is a gauge whose value is read by a function on each scrape
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc registers a GaugeFunc, fn should be cheap and safe for concurrent use
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) (g *GaugeFunc, err error) {
+	g = &GaugeFunc{desc: desc{name: name, help: help, typ: "gauge"}, fn: fn}
+	err = r.register(name, g)
+	if err != nil {
+		g = nil
+	}
+	return
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.writeSample(w, g.name, nil, "", "", g.fn())
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+type histogram struct {
+	mu sync.Mutex
+	// counts of observations in each bucket, the last one is for +Inf
+	counts []uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a HistogramVec with upper bounds of buckets in increasing order and label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) (h *HistogramVec, err error) {
+	h = &HistogramVec{vec: newVec(name, help, "histogram", labels), buckets: buckets}
+	err = r.register(name, h)
+	if err != nil {
+		h = nil
+	}
+	return
+}
+
+// Observe v in the histogram of label values, ErrLabelCount is returned if values don't match label names
+func (h *HistogramVec) Observe(v float64, values ...string) (err error) {
+	s, err := h.get(values, func() interface{} { return &histogram{counts: make([]uint64, len(h.buckets)+1)} })
+	if err != nil {
+		return
+	}
+
+	i := sort.SearchFloat64s(h.buckets, v)
+	hist := s.(*histogram)
+	hist.mu.Lock()
+	hist.counts[i]++
+	hist.sum += v
+	hist.mu.Unlock()
+	return
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, s interface{}) {
+		hist := s.(*histogram)
+		hist.mu.Lock()
+		counts := append([]uint64(nil), hist.counts...)
+		sum := hist.sum
+		hist.mu.Unlock()
+
+		// buckets are cumulative
+		var count uint64
+		for i, n := range counts {
+			count += n
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			h.writeSample(w, h.name+"_bucket", values, "le", formatFloat(le), float64(count))
+		}
+		h.writeSample(w, h.name+"_sum", values, "", "", sum)
+		h.writeSample(w, h.name+"_count", values, "", "", float64(count))
+	})
+}
+
+// ExponentialBuckets returns count upper bounds starting from start, each is factor times the previous one
+func ExponentialBuckets(start, factor float64, count int) (buckets []float64) {
+	buckets = make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return
+}
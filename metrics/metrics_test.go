@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests, err := r.NewCounterVec("requests_total", "Number of requests.", "cmd")
+	assert.Assert(t, err == nil)
+	latency, err := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "cmd")
+	assert.Assert(t, err == nil)
+	_, err = r.NewGaugeFunc("open", "Open things.", func() float64 { return 3 })
+	assert.Assert(t, err == nil)
+
+	_, err = r.NewGaugeFunc("open", "Duplicate.", func() float64 { return 0 })
+	assert.Assert(t, err == ErrDuplicateName)
+	assert.Assert(t, requests.Inc() == ErrLabelCount)
+
+	requests.Inc("set")
+	requests.Inc("set")
+	requests.Inc(`a"b`)
+	latency.Observe(0.05, "set")
+	latency.Observe(0.1, "set")
+	latency.Observe(2, "set")
+	assert.Assert(t, requests.Value("set") == 2 && requests.Value("get") == 0)
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	assert.Assert(t, err == nil && n == int64(buf.Len()))
+	expected := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{cmd="a\"b"} 1
+requests_total{cmd="set"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{cmd="set",le="0.1"} 2
+latency_seconds_bucket{cmd="set",le="1"} 2
+latency_seconds_bucket{cmd="set",le="+Inf"} 3
+latency_seconds_sum{cmd="set"} 2.15
+latency_seconds_count{cmd="set"} 3
+# HELP open Open things.
+# TYPE open gauge
+open 3
+`
+	assert.Equal(t, buf.String(), expected)
+}
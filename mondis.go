@@ -7,6 +7,7 @@ type (
 		KVOP
 		Update(func(t Txn) error) error
 		View(func(t Txn) error) error
+		Close() error
 	}
 
 	// Txn is for transaction
@@ -34,6 +35,19 @@ type (
 		ProviderScanOption
 		Limit int
 	}
+
+	// WatchEvent is a committed mutation of a watched key
+	WatchEvent struct {
+		// Seq increases with each mutation committed on server
+		Seq   uint64
+		Key   []byte
+		Value []byte
+		// Deleted is true for deletes, Value is nil then
+		Deleted bool
+		// Err is set on the last event if watching stops for another reason than cancel,
+		// other fields are empty then
+		Err error
+	}
 )
 
 const (
@@ -1,6 +1,9 @@
 package mondis
 
-import "time"
+import (
+	"io"
+	"time"
+)
 
 type (
 
@@ -11,6 +14,23 @@ type (
 		Close() error
 		WriteBatch() ProviderWriteBatch
 		NewTransaction(update bool) ProviderTxn
+		// GetAsOf gets the value of k as of the specified commit version(VMetaResp.Version),
+		// kv.ErrVersionUnavailable is returned if it's been garbage collected.
+		GetAsOf(k []byte, version uint64) ([]byte, VMetaResp, error)
+		// EstimateKeys returns an approximate number of keys with prefix without iterating them,
+		// kv.ErrEstimateUnavailable is returned if not supported.
+		EstimateKeys(prefix []byte) (int64, error)
+		// Backup writes versions committed after since to w, and returns the version to pass as since for the next incremental backup,
+		// kv.ErrBackupUnavailable is returned if not supported.
+		Backup(w io.Writer, since uint64) (uint64, error)
+		// Restore loads a backup written by Backup, it should not run concurrently with other writes.
+		Restore(r io.Reader) error
+		// RunValueLogGC rewrites at most one value log file with at least discardRatio stale data,
+		// reclaimed is false if nothing is rewritten, always so for providers without value log.
+		RunValueLogGC(discardRatio float64) (reclaimed bool, err error)
+		// NewSnapshot returns a read only view as of now for many consistent reads without a write txn,
+		// it pins versions until released, see Snapshot for the cost.
+		NewSnapshot() (Snapshot, error)
 	}
 
 	// ProviderKVOP is KVOP for provider
@@ -20,6 +40,44 @@ type (
 		Scan(option ProviderScanOption, fn func(key []byte, value []byte, meta VMetaResp) bool) error
 	}
 
+	// ProviderReadOP is the read part of ProviderKVOP
+	ProviderReadOP interface {
+		Exists(k []byte) (bool, error)
+		Get(k []byte) ([]byte, VMetaResp, error)
+		// key and value is only valid before fn returns
+		Scan(option ProviderScanOption, fn func(key []byte, value []byte, meta VMetaResp) bool) error
+	}
+
+	// ValueGetter is optionally implemented by ProviderReadOP to read a value in place instead of copying it out,
+	// e.g., to write it into a response right away
+	ValueGetter interface {
+		// GetValue is like Get but calls fn with the value, which is only valid before fn returns,
+		// fn is not called if k is not found, and its error is returned as is.
+		GetValue(k []byte, fn func(value []byte, meta VMetaResp) error) error
+	}
+
+	// StorageStatter is optionally implemented by KVDB to report the state of its storage, e.g., for metrics
+	StorageStatter interface {
+		StorageStats() StorageStats
+	}
+
+	// StorageStats of KVDB, sizes are in bytes
+	StorageStats struct {
+		LSMSize  int64
+		VlogSize int64
+		// Level0Tables is the number of LSM tables in level 0, which are compacted into lower levels once there are enough of them
+		Level0Tables int
+	}
+
+	// Snapshot is a read only view of KVDB, it's not safe for concurrent use.
+	// It's not released by gc, Release must be called once reads are done:
+	// an unreleased badger snapshot is a read txn that keeps compaction and value log GC from dropping versions after it,
+	// an unreleased leveldb snapshot keeps the same from compaction.
+	Snapshot interface {
+		ProviderReadOP
+		Release()
+	}
+
 	// ProviderWriteBatch is WriteBatch for provider
 	ProviderWriteBatch interface {
 		Set(k, v []byte) error
@@ -47,6 +105,25 @@ type (
 	// KVOption for KVDB
 	KVOption struct {
 		Dir string
+		// NumVersionsToKeep is the number of versions to keep per key, defaults to 1.
+		// Older versions are only readable by GetAsOf when it's greater than 1.
+		NumVersionsToKeep int
+		// ValueLogGCInterval is the interval to run value log GC in background, disabled if not positive.
+		// Only badger has value log, see KVDB.RunValueLogGC to run it manually.
+		ValueLogGCInterval time.Duration
+		// ValueLogGCDiscardRatio is the ratio of stale data for a value log file to be rewritten, defaults to 0.5.
+		ValueLogGCDiscardRatio float64
+		// Compression enables ZSTD compression of tables, kv.ErrOptionUnsupported if the provider can't.
+		Compression bool
+		// EncryptionKey enables encryption at rest, kv.ErrOptionUnsupported if the provider can't.
+		EncryptionKey []byte
+		// SyncWrites syncs each write to disk, nil for the provider default.
+		SyncWrites *bool
+		// InMemory keeps data out of Dir, data is lost on Close.
+		InMemory bool
+		// MaxTableSize is the size of a table, 0 for the provider default.
+		// Badger also bounds a transaction by it, beyond which kv.ErrTxnTooBig is returned.
+		MaxTableSize int64
 	}
 
 	// ProviderScanOption is scan options for provider
@@ -58,7 +135,17 @@ type (
 		// Seek would seek to the provided key if present. If absent, it would seek to the next
 		// smallest key greater than the provided key if iterating in the forward direction.
 		// Behavior would be reversed if iterating backwards.
+		// It's the start key of a range scan.
 		Offset []byte
+		// Stop is the exclusive end key of a range scan,
+		// keys >= Stop are skipped if iterating forwards, keys <= Stop are skipped if iterating backwards.
+		Stop []byte
+		// KeysOnly skips fetching values, fn will be called with nil value.
+		KeysOnly bool
+		// KeyPattern is an optional glob on keys, see kv.CompileKeyPattern for the syntax.
+		// The static part before the first wildcard narrows Prefix to drive the seek,
+		// the rest is filtered while iterating.
+		KeyPattern string
 	}
 
 	// VMetaReq for set value meta
@@ -71,5 +158,7 @@ type (
 	VMetaResp struct {
 		ExpiresAt uint64
 		Tag       byte
+		// Version is the commit version of the value, 0 if not supported by provider
+		Version uint64
 	}
 )
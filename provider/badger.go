@@ -1,28 +1,130 @@
 package provider
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
 	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/y"
 	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
 )
 
 // Badger is mondis provider for badger
 type Badger struct {
-	db *badger.DB
+	db                *badger.DB
+	numVersionsToKeep int
+	tablesMu          sync.Mutex
+	tables            []badger.TableInfo
+	tablesAt          time.Time
+	// tmpDir is removed on Close, only set for KVOption.InMemory
+	tmpDir  string
+	gcClose chan struct{}
+	gcWG    sync.WaitGroup
 }
 
+const defaultValueLogGCDiscardRatio = 0.5
+
+// tablesTTL is how long table infos are cached for EstimateKeys,
+// since counting keys of tables iterates them.
+const tablesTTL = time.Second * 10
+
 // NewBadger is ctor for Badger provider
 func NewBadger() mondis.KVDB {
 	return &Badger{}
 }
 
-// Open db
+// Open db.
+// Badger of this version can't compress or encrypt, so KVOption.Compression and KVOption.EncryptionKey are rejected,
+// and KVOption.InMemory is backed by a temporary dir.
 func (b *Badger) Open(option mondis.KVOption) (err error) {
-	db, err := badger.Open(badger.DefaultOptions(option.Dir))
+	if option.Compression || len(option.EncryptionKey) > 0 {
+		err = kv.ErrOptionUnsupported
+		return
+	}
+
+	dir := option.Dir
+	if option.InMemory {
+		dir, err = ioutil.TempDir("", "mondis-badger")
+		if err != nil {
+			return
+		}
+	}
+
+	opts := badger.DefaultOptions(dir)
+	if option.NumVersionsToKeep > 0 {
+		opts.NumVersionsToKeep = option.NumVersionsToKeep
+	}
+	if option.SyncWrites != nil {
+		opts.SyncWrites = *option.SyncWrites
+	}
+	if option.MaxTableSize > 0 {
+		opts.MaxTableSize = option.MaxTableSize
+	}
+	db, err := badger.Open(opts)
 	if err != nil {
+		if option.InMemory {
+			os.RemoveAll(dir)
+		}
 		return
 	}
 
 	b.db = db
+	b.numVersionsToKeep = opts.NumVersionsToKeep
+	if option.InMemory {
+		b.tmpDir = dir
+	}
+	if option.ValueLogGCInterval > 0 {
+		discardRatio := option.ValueLogGCDiscardRatio
+		if discardRatio <= 0 {
+			discardRatio = defaultValueLogGCDiscardRatio
+		}
+		b.gcClose = make(chan struct{})
+		b.gcWG.Add(1)
+		go b.runValueLogGC(option.ValueLogGCInterval, discardRatio)
+	}
+	return
+}
+
+func (b *Badger) runValueLogGC(interval time.Duration, discardRatio float64) {
+	defer b.gcWG.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// each call rewrites at most one file, so repeat until nothing is rewritten
+			for {
+				select {
+				case <-b.gcClose:
+					return
+				default:
+				}
+				reclaimed, err := b.RunValueLogGC(discardRatio)
+				if err != nil || !reclaimed {
+					break
+				}
+			}
+		case <-b.gcClose:
+			return
+		}
+	}
+}
+
+// RunValueLogGC for implement mondis.KVDB, badger.ErrNoRewrite is reported as not reclaimed
+func (b *Badger) RunValueLogGC(discardRatio float64) (reclaimed bool, err error) {
+	err = b.db.RunValueLogGC(discardRatio)
+	switch err {
+	case nil:
+		reclaimed = true
+	case badger.ErrNoRewrite:
+		err = nil
+	}
 	return
 }
 
@@ -31,7 +133,16 @@ func (b *Badger) Close() (err error) {
 	if b.db == nil {
 		return
 	}
+	if b.gcClose != nil {
+		close(b.gcClose)
+		b.gcWG.Wait()
+		b.gcClose = nil
+	}
 	err = b.db.Close()
+	if b.tmpDir != "" {
+		os.RemoveAll(b.tmpDir)
+		b.tmpDir = ""
+	}
 	return
 }
 
@@ -40,6 +151,12 @@ func (b *Badger) NewTransaction(update bool) mondis.ProviderTxn {
 	return (*Txn)(b.db.NewTransaction(update))
 }
 
+// NewSnapshot returns a read only txn as snapshot
+func (b *Badger) NewSnapshot() (snapshot mondis.Snapshot, err error) {
+	snapshot = &txnSnapshot{txn: b.NewTransaction(false)}
+	return
+}
+
 // Set kv
 func (b *Badger) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
 	txn := (*Txn)(b.db.NewTransaction(true))
@@ -72,6 +189,123 @@ func (b *Badger) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
 	return
 }
 
+// GetValue for implement mondis.ValueGetter
+func (b *Badger) GetValue(k []byte, fn func(value []byte, meta mondis.VMetaResp) error) (err error) {
+	txn := (*Txn)(b.db.NewTransaction(false))
+	defer txn.Discard()
+
+	err = txn.GetValue(k, fn)
+	return
+}
+
+// GetAsOf gets the value of k as of version.
+// It iterates over all retained versions of k instead of using managed mode,
+// so that commit versions are still allocated by badger and other operations are unaffected.
+// Since badger keeps at most NumVersionsToKeep versions per key,
+// kv.ErrVersionUnavailable is returned when no version <= version is found and that many newer versions exist.
+func (b *Badger) GetAsOf(k []byte, version uint64) (v []byte, meta mondis.VMetaResp, err error) {
+	txn := b.db.NewTransaction(false)
+	defer txn.Discard()
+
+	iterOpts := badger.DefaultIteratorOptions
+	iterOpts.AllVersions = true
+	iterOpts.PrefetchValues = false
+	iterOpts.Prefix = k
+
+	iter := txn.NewIterator(iterOpts)
+	defer iter.Close()
+
+	count := 0
+	// versions of the same key are ordered from newest to oldest
+	for iter.Seek(k); iter.Valid(); iter.Next() {
+		item := iter.Item()
+		if !bytes.Equal(item.Key(), k) {
+			break
+		}
+		count++
+		if item.Version() > version {
+			continue
+		}
+
+		if item.IsDeletedOrExpired() {
+			err = kv.ErrKeyNotFound
+			return
+		}
+
+		v, err = item.ValueCopy(nil)
+		if err != nil {
+			return
+		}
+		if v == nil {
+			v = []byte{}
+		}
+		meta = mondis.VMetaResp{ExpiresAt: item.ExpiresAt(), Tag: item.UserMeta(), Version: item.Version()}
+		return
+	}
+
+	if count >= b.numVersionsToKeep {
+		err = kv.ErrVersionUnavailable
+	} else {
+		err = kv.ErrKeyNotFound
+	}
+	return
+}
+
+// EstimateKeys sums key counts of the LSM tables overlapping prefix.
+// It's approximate since keys in memtables are not counted,
+// and keys without prefix, old versions and deleted keys of the overlapping tables are counted.
+func (b *Badger) EstimateKeys(prefix []byte) (n int64, err error) {
+	for _, table := range b.getTables() {
+		left, right := y.ParseKey(table.Left), y.ParseKey(table.Right)
+		if bytes.Compare(right, prefix) < 0 {
+			continue
+		}
+		if !bytes.HasPrefix(left, prefix) && bytes.Compare(left, prefix) > 0 {
+			continue
+		}
+		n += int64(table.KeyCount)
+	}
+	return
+}
+
+// StorageStats implements mondis.StorageStatter, sizes are refreshed by badger every minute
+func (b *Badger) StorageStats() (stats mondis.StorageStats) {
+	stats.LSMSize, stats.VlogSize = b.db.Size()
+	// keys are not counted so it's cheap
+	for _, table := range b.db.Tables(false) {
+		if table.Level == 0 {
+			stats.Level0Tables++
+		}
+	}
+	return
+}
+
+// maxPendingRestoreWrites limits the memory used by Restore
+const maxPendingRestoreWrites = 256
+
+// Backup dumps versions committed after since in badger's backup format
+func (b *Badger) Backup(w io.Writer, since uint64) (version uint64, err error) {
+	version, err = b.db.Backup(w, since)
+	return
+}
+
+// Restore loads a backup of Backup
+func (b *Badger) Restore(r io.Reader) (err error) {
+	err = b.db.Load(r, maxPendingRestoreWrites)
+	return
+}
+
+func (b *Badger) getTables() []badger.TableInfo {
+	b.tablesMu.Lock()
+	defer b.tablesMu.Unlock()
+
+	if b.tables == nil || time.Since(b.tablesAt) > tablesTTL {
+		b.tables = b.db.Tables(true)
+		b.tablesAt = time.Now()
+	}
+	return b.tables
+}
+
 // Delete k
 func (b *Badger) Delete(key []byte) (err error) {
 	txn := b.db.NewTransaction(true)
@@ -101,28 +335,86 @@ func (b *Badger) WriteBatch() mondis.ProviderWriteBatch {
 }
 
 func scanByBadgerTxn(txn *badger.Txn, option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	var pattern *kv.KeyPattern
+	prefix := option.Prefix
+	if option.KeyPattern != "" {
+		pattern, err = kv.CompileKeyPattern(option.KeyPattern)
+		if err != nil {
+			return
+		}
+		var ok bool
+		prefix, ok = pattern.NarrowPrefix(prefix)
+		if !ok {
+			return
+		}
+	}
+
 	iterOpts := badger.DefaultIteratorOptions
 	iterOpts.Reverse = option.Reverse
+	iterOpts.PrefetchValues = !option.KeysOnly
 
-	if len(option.Prefix) > 0 {
-		iterOpts.Prefix = option.Prefix
+	// badger.Iterator with Prefix can't seek to the last key of prefix when iterating backwards,
+	// so prefix is checked manually for reverse scan
+	if len(prefix) > 0 && !option.Reverse {
+		iterOpts.Prefix = prefix
 	}
 
 	iter := txn.NewIterator(iterOpts)
 	defer iter.Close()
 
-	if option.Offset != nil {
+	switch {
+	case option.Offset != nil:
 		iter.Seek(option.Offset)
-	} else {
+	case option.Reverse && len(prefix) > 0:
+		upper := kv.Key(prefix).PrefixNext()
+		if bytes.HasPrefix(upper, prefix) {
+			// prefix is all 0xff
+			iter.Rewind()
+		} else {
+			iter.Seek(upper)
+		}
+	default:
 		iter.Rewind()
 	}
 
+	if option.Reverse && len(prefix) > 0 {
+		// skip keys greater than prefix
+		for ; iter.Valid(); iter.Next() {
+			key := iter.Item().Key()
+			if bytes.HasPrefix(key, prefix) || bytes.Compare(key, prefix) < 0 {
+				break
+			}
+		}
+	}
+
 	var goon bool
 	for ; iter.Valid(); iter.Next() {
 		item := iter.Item()
 
+		if option.Reverse {
+			if len(prefix) > 0 && !bytes.HasPrefix(item.Key(), prefix) {
+				break
+			}
+			if option.Stop != nil && bytes.Compare(item.Key(), option.Stop) <= 0 {
+				break
+			}
+		} else if option.Stop != nil && bytes.Compare(item.Key(), option.Stop) >= 0 {
+			break
+		}
+
+		if pattern != nil && !pattern.Match(item.Key()) {
+			continue
+		}
+
+		if option.KeysOnly {
+			if !fn(item.Key(), nil, mondis.VMetaResp{ExpiresAt: item.ExpiresAt(), Tag: item.UserMeta(), Version: item.Version()}) {
+				break
+			}
+			continue
+		}
+
 		err = item.Value(func(val []byte) error {
-			goon = fn(item.Key(), val, mondis.VMetaResp{ExpiresAt: item.ExpiresAt(), Tag: item.UserMeta()})
+			goon = fn(item.Key(), val, mondis.VMetaResp{ExpiresAt: item.ExpiresAt(), Tag: item.UserMeta(), Version: item.Version()})
 			return nil
 		})
 		if err != nil || !goon {
@@ -56,9 +56,31 @@ func (txn *Txn) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
 	if err != nil {
 		return
 	}
+	// an empty value is not nil, to distinguish from absent key
+	if v == nil {
+		v = []byte{}
+	}
 
 	meta.ExpiresAt = item.ExpiresAt()
 	meta.Tag = item.UserMeta()
+	meta.Version = item.Version()
+	return
+}
+
+// GetValue for implement mondis.ValueGetter, the value is read by badger.Item.Value with no copy
+func (txn *Txn) GetValue(k []byte, fn func(value []byte, meta mondis.VMetaResp) error) (err error) {
+	item, err := (*badger.Txn)(txn).Get(k)
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			err = kv.ErrKeyNotFound
+		}
+		return
+	}
+
+	meta := mondis.VMetaResp{ExpiresAt: item.ExpiresAt(), Tag: item.UserMeta(), Version: item.Version()}
+	err = item.Value(func(v []byte) error {
+		return fn(v, meta)
+	})
 	return
 }
 
@@ -82,6 +104,9 @@ func (txn *Txn) StartTS() uint64 {
 // Commit for implement mondis.ProviderTxn
 func (txn *Txn) Commit() (err error) {
 	err = (*badger.Txn)(txn).Commit()
+	if err == badger.ErrConflict {
+		err = kv.ErrTxnConflict
+	}
 	return
 }
 
@@ -1,9 +1,14 @@
 package provider
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 	"github.com/syndtr/goleveldb/leveldb/util"
 	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/kv"
@@ -12,6 +17,7 @@ import (
 // LevelDB is mondis provider for LevelDB
 type LevelDB struct {
 	db *leveldb.DB
+	wo *opt.WriteOptions
 }
 
 // NewLevelDB is ctor for LevelDB provider
@@ -19,14 +25,27 @@ func NewLevelDB() mondis.KVDB {
 	return &LevelDB{}
 }
 
-// Open db
+// Open db, tables are always compressed by snappy, ZSTD and encryption are not supported
 func (l *LevelDB) Open(option mondis.KVOption) (err error) {
-	db, err := leveldb.OpenFile(option.Dir, nil)
+	if option.Compression || len(option.EncryptionKey) > 0 {
+		err = kv.ErrOptionUnsupported
+		return
+	}
+
+	var db *leveldb.DB
+	if option.InMemory {
+		db, err = leveldb.Open(storage.NewMemStorage(), nil)
+	} else {
+		db, err = leveldb.OpenFile(option.Dir, nil)
+	}
 	if err != nil {
 		return
 	}
 
 	l.db = db
+	if option.SyncWrites != nil {
+		l.wo = &opt.WriteOptions{Sync: *option.SyncWrites}
+	}
 	return
 }
 
@@ -39,21 +58,67 @@ func (l *LevelDB) Close() (err error) {
 	return
 }
 
+// RunValueLogGC reclaims nothing since LevelDB has no value log
+func (l *LevelDB) RunValueLogGC(discardRatio float64) (reclaimed bool, err error) {
+	return
+}
+
 // NewTransaction creates a transaction object
 func (l *LevelDB) NewTransaction(update bool) mondis.ProviderTxn {
 	panic("transaction not supported for leveldb")
 }
 
+// NewSnapshot returns a leveldb snapshot
+func (l *LevelDB) NewSnapshot() (snapshot mondis.Snapshot, err error) {
+	snap, err := l.db.GetSnapshot()
+	if err != nil {
+		return
+	}
+	snapshot = &leveldbSnapshot{snap: snap}
+	return
+}
+
+// GetAsOf is not supported for leveldb since only the latest version is kept
+func (l *LevelDB) GetAsOf(k []byte, version uint64) (v []byte, meta mondis.VMetaResp, err error) {
+	err = kv.ErrVersionUnavailable
+	return
+}
+
+// EstimateKeys is not supported for leveldb since only sizes of key ranges are tracked
+func (l *LevelDB) EstimateKeys(prefix []byte) (n int64, err error) {
+	err = kv.ErrEstimateUnavailable
+	return
+}
+
+// Backup is not supported for leveldb yet
+func (l *LevelDB) Backup(w io.Writer, since uint64) (version uint64, err error) {
+	err = kv.ErrBackupUnavailable
+	return
+}
+
+// Restore is not supported for leveldb yet
+func (l *LevelDB) Restore(r io.Reader) (err error) {
+	err = kv.ErrBackupUnavailable
+	return
+}
+
 // Set kv
 func (l *LevelDB) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
 	if meta != nil {
-		err = fmt.Errorf("meta not supported for LevelDB")
+		err = kv.ErrMetaUnsupported
 		return
 	}
-	err = l.db.Put(k, v, nil)
+	err = l.db.Put(k, v, l.wo)
 	return
 }
 
+// leveldbReader is implemented by both *leveldb.DB and *leveldb.Snapshot
+type leveldbReader interface {
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
 // Exists checks whether k exists
 func (l *LevelDB) Exists(k []byte) (exists bool, err error) {
 	exists, err = l.db.Has(k, nil)
@@ -63,22 +128,30 @@ func (l *LevelDB) Exists(k []byte) (exists bool, err error) {
 
 // Get v by k
 func (l *LevelDB) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	v, err = getByLevelDBReader(l.db, k)
+	return
+}
 
-	v, err = l.db.Get(k, nil)
-	if err == leveldb.ErrNotFound {
-		err = kv.ErrKeyNotFound
+func getByLevelDBReader(r leveldbReader, k []byte) (v []byte, err error) {
+	v, err = r.Get(k, nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			err = kv.ErrKeyNotFound
+		}
+		v = nil
+		return
 	}
 
-	// keep behaviour the same as badger
-	if len(v) == 0 {
-		v = nil
+	// keep behaviour the same as badger, an empty value is not nil
+	if v == nil {
+		v = []byte{}
 	}
 	return
 }
 
 // Delete k
 func (l *LevelDB) Delete(key []byte) (err error) {
-	err = l.db.Delete(key, nil)
+	err = l.db.Delete(key, l.wo)
 	return
 }
 
@@ -86,22 +159,59 @@ var emptyMeta mondis.VMetaResp
 
 // Scan over keys specified by option
 func (l *LevelDB) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	err = scanByLevelDBReader(l.db, option, fn)
+	return
+}
+
+func scanByLevelDBReader(r leveldbReader, option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
 
 	if option.Reverse {
 		err = fmt.Errorf("Reverse scan not supported for LevelDB")
 		return
 	}
 
+	var pattern *kv.KeyPattern
+	prefix := option.Prefix
+	if option.KeyPattern != "" {
+		pattern, err = kv.CompileKeyPattern(option.KeyPattern)
+		if err != nil {
+			return
+		}
+		var ok bool
+		prefix, ok = pattern.NarrowPrefix(prefix)
+		if !ok {
+			return
+		}
+	}
+
 	var slice *util.Range
-	if option.Prefix != nil {
-		slice = util.BytesPrefix(option.Prefix)
+	if prefix != nil {
+		slice = util.BytesPrefix(prefix)
+	}
+	if option.Stop != nil {
+		if slice == nil {
+			slice = &util.Range{Limit: option.Stop}
+		} else if slice.Limit == nil || bytes.Compare(option.Stop, slice.Limit) < 0 {
+			slice.Limit = option.Stop
+		}
+	}
+	iter := r.NewIterator(slice, nil)
+	defer iter.Release()
+
+	value := func() []byte {
+		if option.KeysOnly {
+			return nil
+		}
+		return iter.Value()
+	}
+	matched := func() bool {
+		return pattern == nil || pattern.Match(iter.Key())
 	}
-	iter := l.db.NewIterator(slice, nil)
 	if option.Offset != nil {
 		if !iter.Seek(option.Offset) {
 			return
 		}
-		if !fn(iter.Key(), iter.Value(), emptyMeta) {
+		if matched() && !fn(iter.Key(), value(), emptyMeta) {
 			return
 		}
 	}
@@ -110,7 +220,7 @@ func (l *LevelDB) Scan(option mondis.ProviderScanOption, fn func(key []byte, val
 		if !iter.Next() {
 			break
 		}
-		if !fn(iter.Key(), iter.Value(), emptyMeta) {
+		if matched() && !fn(iter.Key(), value(), emptyMeta) {
 			break
 		}
 	}
@@ -120,5 +230,33 @@ func (l *LevelDB) Scan(option mondis.ProviderScanOption, fn func(key []byte, val
 
 // WriteBatch creates a new mondis.ProviderWriteBatch
 func (l *LevelDB) WriteBatch() mondis.ProviderWriteBatch {
-	return &leveldbWB{db: l.db, batch: new(leveldb.Batch)}
+	return &leveldbWB{db: l.db, wo: l.wo, batch: new(leveldb.Batch)}
+}
+
+// leveldbSnapshot is mondis.Snapshot for LevelDB
+type leveldbSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
+// Exists for implement mondis.Snapshot
+func (s *leveldbSnapshot) Exists(k []byte) (exists bool, err error) {
+	exists, err = s.snap.Has(k, nil)
+	return
+}
+
+// Get for implement mondis.Snapshot
+func (s *leveldbSnapshot) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	v, err = getByLevelDBReader(s.snap, k)
+	return
+}
+
+// Scan for implement mondis.Snapshot
+func (s *leveldbSnapshot) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	err = scanByLevelDBReader(s.snap, option, fn)
+	return
+}
+
+// Release for implement mondis.Snapshot
+func (s *leveldbSnapshot) Release() {
+	s.snap.Release()
 }
@@ -2,11 +2,13 @@ package provider
 
 import (
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 type leveldbWB struct {
 	batch *leveldb.Batch
 	db    *leveldb.DB
+	wo    *opt.WriteOptions
 }
 
 func (wb *leveldbWB) Set(k, v []byte) error {
@@ -20,7 +22,7 @@ func (wb *leveldbWB) Delete(key []byte) error {
 }
 
 func (wb *leveldbWB) Commit() error {
-	return wb.db.Write(wb.batch, nil)
+	return wb.db.Write(wb.batch, wb.wo)
 }
 
 func (wb *leveldbWB) Discard() {
@@ -0,0 +1,326 @@
+package provider
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
+)
+
+// Memory is mondis provider that keeps everything in memory, mainly for tests.
+//
+// Transactions behave like badger: reads are from a snapshot as of the txn start,
+// and commit fails with kv.ErrTxnConflict if a key read by the txn is committed by others since then.
+// Keys are ordered bytewise like badger, so are scans.
+// Data is lost on Close, option.Dir is ignored.
+type Memory struct {
+	mu                sync.RWMutex
+	keys              []string // sorted
+	items             map[string][]memVersion
+	ts                uint64         // version of the last commit
+	active            map[uint64]int // read ts of open txns => count
+	numVersionsToKeep int
+}
+
+// memVersion is a version of a key, versions of a key are ordered from oldest to newest
+type memVersion struct {
+	version   uint64
+	value     []byte
+	deleted   bool
+	expiresAt uint64
+	tag       byte
+}
+
+func (v *memVersion) isDeletedOrExpired() bool {
+	if v.deleted {
+		return true
+	}
+	return v.expiresAt != 0 && v.expiresAt <= uint64(time.Now().Unix())
+}
+
+func (v *memVersion) meta() mondis.VMetaResp {
+	return mondis.VMetaResp{ExpiresAt: v.expiresAt, Tag: v.tag, Version: v.version}
+}
+
+// memScanBatch is the number of keys fetched under lock at a time by Scan
+const memScanBatch = 64
+
+// NewMemory is ctor for Memory provider
+func NewMemory() mondis.KVDB {
+	return &Memory{}
+}
+
+// Open db
+func (m *Memory) Open(option mondis.KVOption) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys = nil
+	m.items = make(map[string][]memVersion)
+	m.ts = 0
+	m.active = make(map[uint64]int)
+	m.numVersionsToKeep = 1
+	if option.NumVersionsToKeep > 0 {
+		m.numVersionsToKeep = option.NumVersionsToKeep
+	}
+	return
+}
+
+// Close db
+func (m *Memory) Close() (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys = nil
+	m.items = nil
+	return
+}
+
+// RunValueLogGC reclaims nothing since Memory has no value log
+func (m *Memory) RunValueLogGC(discardRatio float64) (reclaimed bool, err error) {
+	return
+}
+
+// NewTransaction creates a transaction object
+func (m *Memory) NewTransaction(update bool) mondis.ProviderTxn {
+	m.mu.Lock()
+	readTs := m.ts
+	m.active[readTs]++
+	m.mu.Unlock()
+
+	txn := &memTxn{m: m, update: update, readTs: readTs}
+	if update {
+		txn.writes = make(map[string]*memVersion)
+		txn.reads = make(map[string]struct{})
+	}
+	return txn
+}
+
+// NewSnapshot returns a read only txn as snapshot, versions readable by it are kept until released
+func (m *Memory) NewSnapshot() (snapshot mondis.Snapshot, err error) {
+	snapshot = &txnSnapshot{txn: m.NewTransaction(false)}
+	return
+}
+
+// Set kv
+func (m *Memory) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	txn := m.NewTransaction(true)
+	defer txn.Discard()
+
+	err = txn.Set(k, v, meta)
+	if err != nil {
+		return
+	}
+
+	err = txn.Commit()
+	return
+}
+
+// Exists checks whether k exists
+func (m *Memory) Exists(k []byte) (exists bool, err error) {
+	txn := m.NewTransaction(false)
+	defer txn.Discard()
+
+	exists, err = txn.Exists(k)
+	return
+}
+
+// Get v by k
+func (m *Memory) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	txn := m.NewTransaction(false)
+	defer txn.Discard()
+
+	v, meta, err = txn.Get(k)
+	return
+}
+
+// GetAsOf gets the value of k as of version,
+// at least NumVersionsToKeep versions are kept for each key.
+func (m *Memory) GetAsOf(k []byte, version uint64) (v []byte, meta mondis.VMetaResp, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	versions := m.items[string(k)]
+	for i := len(versions) - 1; i >= 0; i-- {
+		ver := &versions[i]
+		if ver.version > version {
+			continue
+		}
+
+		if ver.isDeletedOrExpired() {
+			err = kv.ErrKeyNotFound
+			return
+		}
+		v = append([]byte{}, ver.value...)
+		meta = ver.meta()
+		return
+	}
+
+	if len(versions) >= m.numVersionsToKeep {
+		err = kv.ErrVersionUnavailable
+	} else {
+		err = kv.ErrKeyNotFound
+	}
+	return
+}
+
+// EstimateKeys counts keys with prefix, including deleted keys whose versions are still kept
+func (m *Memory) EstimateKeys(prefix []byte) (n int64, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p := string(prefix)
+	for i := sort.SearchStrings(m.keys, p); i < len(m.keys) && strings.HasPrefix(m.keys[i], p); i++ {
+		n++
+	}
+	return
+}
+
+// Backup is not supported for Memory since data is lost on Close anyway
+func (m *Memory) Backup(w io.Writer, since uint64) (version uint64, err error) {
+	err = kv.ErrBackupUnavailable
+	return
+}
+
+// Restore is not supported for Memory since data is lost on Close anyway
+func (m *Memory) Restore(r io.Reader) (err error) {
+	err = kv.ErrBackupUnavailable
+	return
+}
+
+// Delete k
+func (m *Memory) Delete(key []byte) (err error) {
+	txn := m.NewTransaction(true)
+	defer txn.Discard()
+
+	err = txn.Delete(key)
+	if err != nil {
+		return
+	}
+	err = txn.Commit()
+	return
+}
+
+// Scan over keys specified by option
+func (m *Memory) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	txn := m.NewTransaction(false)
+	defer txn.Discard()
+
+	err = txn.Scan(option, fn)
+	return
+}
+
+// WriteBatch creates a new mondis.ProviderWriteBatch
+func (m *Memory) WriteBatch() mondis.ProviderWriteBatch {
+	return &memWB{txn: m.NewTransaction(true).(*memTxn)}
+}
+
+// visible returns the version of k as of readTs, ok is false if there is none
+func (m *Memory) visible(k string, readTs uint64) (ver memVersion, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	versions := m.items[k]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].version <= readTs {
+			return versions[i], true
+		}
+	}
+	return
+}
+
+// keysFrom returns at most n keys from cursor in the direction of reverse,
+// cursor is excluded if exclusive, all keys are candidates if cursor is nil.
+func (m *Memory) keysFrom(cursor *string, exclusive, reverse bool, n int) (keys []string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !reverse {
+		i := 0
+		if cursor != nil {
+			i = sort.SearchStrings(m.keys, *cursor)
+			if exclusive && i < len(m.keys) && m.keys[i] == *cursor {
+				i++
+			}
+		}
+		for ; i < len(m.keys) && len(keys) < n; i++ {
+			keys = append(keys, m.keys[i])
+		}
+		return
+	}
+
+	i := len(m.keys) - 1
+	if cursor != nil {
+		i = sort.SearchStrings(m.keys, *cursor)
+		if exclusive || i == len(m.keys) || m.keys[i] != *cursor {
+			i--
+		}
+	}
+	for ; i >= 0 && len(keys) < n; i-- {
+		keys = append(keys, m.keys[i])
+	}
+	return
+}
+
+// commit applies writes of txn as a new version, must be called with m.mu locked
+func (m *Memory) commit(writes map[string]*memVersion) {
+	m.ts++
+	for k, w := range writes {
+		w.version = m.ts
+		versions, ok := m.items[k]
+		if !ok {
+			i := sort.SearchStrings(m.keys, k)
+			m.keys = append(m.keys, "")
+			copy(m.keys[i+1:], m.keys[i:])
+			m.keys[i] = k
+		}
+		m.items[k] = append(versions, *w)
+		m.prune(k)
+	}
+}
+
+// prune drops versions of k no longer needed, must be called with m.mu locked.
+// The newest NumVersionsToKeep versions are kept, so are the versions still readable by open txns.
+func (m *Memory) prune(k string) {
+	minActive := m.ts
+	for readTs := range m.active {
+		if readTs < minActive {
+			minActive = readTs
+		}
+	}
+
+	versions := m.items[k]
+	cut := len(versions) - m.numVersionsToKeep
+	for i := len(versions) - 1; i >= 0; i-- {
+		// the newest version as of minActive is readable by open txns
+		if versions[i].version <= minActive {
+			if i < cut {
+				cut = i
+			}
+			break
+		}
+	}
+	if cut > 0 {
+		versions = append(versions[:0:0], versions[cut:]...)
+	}
+
+	if len(versions) == 1 && versions[0].deleted && versions[0].version <= minActive {
+		// nobody can see the key any more
+		delete(m.items, k)
+		i := sort.SearchStrings(m.keys, k)
+		m.keys = append(m.keys[:i], m.keys[i+1:]...)
+		return
+	}
+	m.items[k] = versions
+}
+
+func (m *Memory) txnDone(readTs uint64) {
+	m.active[readTs]--
+	if m.active[readTs] == 0 {
+		delete(m.active, readTs)
+	}
+}
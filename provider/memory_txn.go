@@ -0,0 +1,342 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
+)
+
+// memTxn is mondis.ProviderTxn for Memory,
+// writes are buffered until commit, and keys read by Get, Exists and Scan are tracked for conflict detection if update.
+type memTxn struct {
+	m      *Memory
+	update bool
+	readTs uint64
+	writes map[string]*memVersion
+	reads  map[string]struct{}
+	done   bool
+}
+
+func (txn *memTxn) checkWritable() (err error) {
+	switch {
+	case txn.done:
+		err = fmt.Errorf("write on finished txn")
+	case !txn.update:
+		err = fmt.Errorf("write on read only txn")
+	}
+	return
+}
+
+// Set for implement mondis.ProviderTxn
+func (txn *memTxn) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	err = txn.checkWritable()
+	if err != nil {
+		return
+	}
+
+	w := &memVersion{value: append([]byte{}, v...)}
+	if meta != nil {
+		// same as badger, TTL is applied whenever meta is specified
+		w.expiresAt = uint64(time.Now().Add(meta.TTL).Unix())
+		w.tag = meta.Tag
+	}
+	txn.writes[string(k)] = w
+	return
+}
+
+// Delete for implement mondis.ProviderTxn
+func (txn *memTxn) Delete(key []byte) (err error) {
+	err = txn.checkWritable()
+	if err != nil {
+		return
+	}
+
+	txn.writes[string(key)] = &memVersion{deleted: true}
+	return
+}
+
+// addReadKey tracks k for conflict detection if update
+func (txn *memTxn) addReadKey(k string) {
+	if txn.update {
+		txn.reads[k] = struct{}{}
+	}
+}
+
+// get reads k for Get and Exists, same as badger, k is tracked unless it's written by txn
+func (txn *memTxn) get(k string) (v []byte, meta mondis.VMetaResp, ok bool) {
+	if txn.update {
+		if _, exists := txn.writes[k]; !exists {
+			txn.addReadKey(k)
+		}
+	}
+	return txn.lookup(k)
+}
+
+// lookup reads k with writes of txn applied, ok is false if it doesn't exist
+func (txn *memTxn) lookup(k string) (v []byte, meta mondis.VMetaResp, ok bool) {
+	if txn.update {
+		if w, exists := txn.writes[k]; exists {
+			if w.isDeletedOrExpired() {
+				return
+			}
+			meta = w.meta()
+			// same as badger, pending writes are versioned as of read ts
+			meta.Version = txn.readTs
+			return w.value, meta, true
+		}
+	}
+
+	ver, exists := txn.m.visible(k, txn.readTs)
+	if !exists || ver.isDeletedOrExpired() {
+		return
+	}
+	return ver.value, ver.meta(), true
+}
+
+// Exists checks whether k exists
+func (txn *memTxn) Exists(k []byte) (exists bool, err error) {
+	_, _, exists = txn.get(string(k))
+	return
+}
+
+// Get for implement mondis.ProviderTxn
+func (txn *memTxn) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	v, meta, ok := txn.get(string(k))
+	if !ok {
+		err = kv.ErrKeyNotFound
+		return
+	}
+
+	// an empty value is not nil, to distinguish from absent key
+	v = append([]byte{}, v...)
+	return
+}
+
+// StartTS for implement mondis.ProviderTxn
+func (txn *memTxn) StartTS() uint64 {
+	return txn.readTs
+}
+
+// Commit for implement mondis.ProviderTxn
+func (txn *memTxn) Commit() (err error) {
+	if txn.done {
+		err = fmt.Errorf("commit on finished txn")
+		return
+	}
+
+	m := txn.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	txn.done = true
+	m.txnDone(txn.readTs)
+	if len(txn.writes) == 0 {
+		return
+	}
+
+	for k := range txn.reads {
+		versions := m.items[k]
+		if len(versions) > 0 && versions[len(versions)-1].version > txn.readTs {
+			err = kv.ErrTxnConflict
+			return
+		}
+	}
+
+	m.commit(txn.writes)
+	return
+}
+
+// Discard for implement mondis.ProviderTxn
+func (txn *memTxn) Discard() {
+	if txn.done {
+		return
+	}
+
+	m := txn.m
+	m.mu.Lock()
+	txn.done = true
+	m.txnDone(txn.readTs)
+	m.mu.Unlock()
+}
+
+// Scan over keys specified by option, the same way as badger
+func (txn *memTxn) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	var pattern *kv.KeyPattern
+	prefix := option.Prefix
+	if option.KeyPattern != "" {
+		pattern, err = kv.CompileKeyPattern(option.KeyPattern)
+		if err != nil {
+			return
+		}
+		var ok bool
+		prefix, ok = pattern.NarrowPrefix(prefix)
+		if !ok {
+			return
+		}
+	}
+
+	// seek to the first key >= seek, or the last key <= seek if reverse, nil means from the start or the end
+	var seek *string
+	switch {
+	case option.Offset != nil:
+		s := string(option.Offset)
+		seek = &s
+	case len(prefix) > 0 && !option.Reverse:
+		s := string(prefix)
+		seek = &s
+	case len(prefix) > 0:
+		upper := kv.Key(prefix).PrefixNext()
+		// no upper bound if prefix is all 0xff
+		if !bytes.HasPrefix(upper, prefix) {
+			s := string(upper)
+			seek = &s
+		}
+	}
+
+	iter := newMemKeyIter(txn, seek, option.Reverse)
+	// skip keys greater than prefix when iterating backwards
+	skipping := option.Reverse && len(prefix) > 0
+	for {
+		key, ok := iter.next()
+		if !ok {
+			break
+		}
+		v, meta, ok := txn.lookup(key)
+		if !ok {
+			continue
+		}
+		// same as badger, every existing key the iterator stops at is tracked,
+		// including the one ending the scan
+		txn.addReadKey(key)
+
+		k := []byte(key)
+		if skipping {
+			if !bytes.HasPrefix(k, prefix) && bytes.Compare(k, prefix) > 0 {
+				continue
+			}
+			skipping = false
+		}
+		if len(prefix) > 0 && !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		if option.Stop != nil {
+			cmp := bytes.Compare(k, option.Stop)
+			if (option.Reverse && cmp <= 0) || (!option.Reverse && cmp >= 0) {
+				break
+			}
+		}
+
+		if pattern != nil && !pattern.Match(k) {
+			continue
+		}
+
+		if option.KeysOnly {
+			v = nil
+		} else if v == nil {
+			v = []byte{}
+		}
+		if !fn(k, v, meta) {
+			break
+		}
+	}
+	return
+}
+
+// memKeyIter merges committed keys fetched in batches with keys written by txn, in order
+type memKeyIter struct {
+	m       *Memory
+	reverse bool
+	seek    *string
+	// committed keys
+	batch   []string
+	bi      int
+	started bool
+	drained bool
+	// keys written by txn
+	pending []string
+	pi      int
+}
+
+func newMemKeyIter(txn *memTxn, seek *string, reverse bool) *memKeyIter {
+	iter := &memKeyIter{m: txn.m, reverse: reverse, seek: seek}
+
+	for k := range txn.writes {
+		iter.pending = append(iter.pending, k)
+	}
+	sort.Strings(iter.pending)
+	if reverse {
+		for i, j := 0, len(iter.pending)-1; i < j; i, j = i+1, j-1 {
+			iter.pending[i], iter.pending[j] = iter.pending[j], iter.pending[i]
+		}
+	}
+	if seek != nil {
+		for iter.pi < len(iter.pending) && iter.before(iter.pending[iter.pi], *seek) {
+			iter.pi++
+		}
+	}
+	return iter
+}
+
+// before tells whether a comes before b in the iteration order
+func (iter *memKeyIter) before(a, b string) bool {
+	if iter.reverse {
+		return a > b
+	}
+	return a < b
+}
+
+func (iter *memKeyIter) peekCommitted() (key string, ok bool) {
+	if iter.bi == len(iter.batch) {
+		if iter.drained {
+			return
+		}
+		if !iter.started {
+			iter.batch = iter.m.keysFrom(iter.seek, false, iter.reverse, memScanBatch)
+			iter.started = true
+		} else {
+			last := iter.batch[len(iter.batch)-1]
+			iter.batch = iter.m.keysFrom(&last, true, iter.reverse, memScanBatch)
+		}
+		iter.bi = 0
+		if len(iter.batch) < memScanBatch {
+			iter.drained = true
+		}
+		if len(iter.batch) == 0 {
+			return
+		}
+	}
+	return iter.batch[iter.bi], true
+}
+
+func (iter *memKeyIter) next() (key string, ok bool) {
+	committed, cok := iter.peekCommitted()
+	pok := iter.pi < len(iter.pending)
+	switch {
+	case cok && pok:
+		pending := iter.pending[iter.pi]
+		switch {
+		case committed == pending:
+			iter.bi++
+			iter.pi++
+			return committed, true
+		case iter.before(committed, pending):
+			iter.bi++
+			return committed, true
+		default:
+			iter.pi++
+			return pending, true
+		}
+	case cok:
+		iter.bi++
+		return committed, true
+	case pok:
+		iter.pi++
+		return iter.pending[iter.pi-1], true
+	default:
+		return
+	}
+}
@@ -0,0 +1,22 @@
+package provider
+
+// memWB is mondis.ProviderWriteBatch for Memory, it's an update txn that reads nothing
+type memWB struct {
+	txn *memTxn
+}
+
+func (wb *memWB) Set(k, v []byte) error {
+	return wb.txn.Set(k, v, nil)
+}
+
+func (wb *memWB) Delete(key []byte) error {
+	return wb.txn.Delete(key)
+}
+
+func (wb *memWB) Commit() error {
+	return wb.txn.Commit()
+}
+
+func (wb *memWB) Discard() {
+	wb.txn.Discard()
+}
@@ -0,0 +1,253 @@
+//go:build pebble
+// +build pebble
+
+package provider
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
+)
+
+// Pebble is mondis provider for pebble, only built with the pebble tag.
+//
+// Pebble has no optimistic transactions, so an update txn is an indexed batch
+// that reads its own writes on top of the latest committed data, and commits
+// without conflict detection, the last committed write wins.
+// A read only txn is a snapshot.
+type Pebble struct {
+	db *pebble.DB
+	wo *pebble.WriteOptions
+}
+
+// NewPebble is ctor for Pebble provider
+func NewPebble() mondis.KVDB {
+	return &Pebble{}
+}
+
+// Open db, ZSTD and encryption are not supported, neither is VMetaReq
+func (p *Pebble) Open(option mondis.KVOption) (err error) {
+	if option.Compression || len(option.EncryptionKey) > 0 {
+		err = kv.ErrOptionUnsupported
+		return
+	}
+
+	opts := &pebble.Options{}
+	if option.InMemory {
+		opts.FS = vfs.NewMem()
+	}
+	db, err := pebble.Open(option.Dir, opts)
+	if err != nil {
+		return
+	}
+
+	p.db = db
+	p.wo = pebble.Sync
+	if option.SyncWrites != nil && !*option.SyncWrites {
+		p.wo = pebble.NoSync
+	}
+	return
+}
+
+// Close db
+func (p *Pebble) Close() (err error) {
+	if p.db == nil {
+		return
+	}
+	err = p.db.Close()
+	return
+}
+
+// RunValueLogGC reclaims nothing since Pebble has no value log
+func (p *Pebble) RunValueLogGC(discardRatio float64) (reclaimed bool, err error) {
+	return
+}
+
+// NewTransaction creates a transaction object
+func (p *Pebble) NewTransaction(update bool) mondis.ProviderTxn {
+	if update {
+		return &pebbleTxn{batch: p.db.NewIndexedBatch(), wo: p.wo}
+	}
+	return &pebbleTxn{snapshot: p.db.NewSnapshot()}
+}
+
+// NewSnapshot returns a read only txn as snapshot
+func (p *Pebble) NewSnapshot() (snapshot mondis.Snapshot, err error) {
+	snapshot = &txnSnapshot{txn: p.NewTransaction(false)}
+	return
+}
+
+// GetAsOf is not supported for pebble since versions are not exposed
+func (p *Pebble) GetAsOf(k []byte, version uint64) (v []byte, meta mondis.VMetaResp, err error) {
+	err = kv.ErrVersionUnavailable
+	return
+}
+
+// EstimateKeys is not supported for pebble since only sizes of key ranges are tracked
+func (p *Pebble) EstimateKeys(prefix []byte) (n int64, err error) {
+	err = kv.ErrEstimateUnavailable
+	return
+}
+
+// Backup is not supported for pebble yet
+func (p *Pebble) Backup(w io.Writer, since uint64) (version uint64, err error) {
+	err = kv.ErrBackupUnavailable
+	return
+}
+
+// Restore is not supported for pebble yet
+func (p *Pebble) Restore(r io.Reader) (err error) {
+	err = kv.ErrBackupUnavailable
+	return
+}
+
+// Set kv
+func (p *Pebble) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	if meta != nil {
+		err = kv.ErrMetaUnsupported
+		return
+	}
+	err = p.db.Set(k, v, p.wo)
+	return
+}
+
+// Exists checks whether k exists
+func (p *Pebble) Exists(k []byte) (exists bool, err error) {
+	exists, err = existsByPebbleReader(p.db, k)
+	return
+}
+
+// Get v by k
+func (p *Pebble) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	v, err = getByPebbleReader(p.db, k)
+	return
+}
+
+// Delete k
+func (p *Pebble) Delete(key []byte) (err error) {
+	err = p.db.Delete(key, p.wo)
+	return
+}
+
+// Scan over keys specified by option
+func (p *Pebble) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	snapshot := p.db.NewSnapshot()
+	defer snapshot.Close()
+
+	err = scanByPebbleReader(snapshot, option, fn)
+	return
+}
+
+// WriteBatch creates a new mondis.ProviderWriteBatch
+func (p *Pebble) WriteBatch() mondis.ProviderWriteBatch {
+	return &pebbleWB{batch: p.db.NewBatch(), wo: p.wo}
+}
+
+func getByPebbleReader(r pebble.Reader, k []byte) (v []byte, err error) {
+	value, closer, err := r.Get(k)
+	if err != nil {
+		if err == pebble.ErrNotFound {
+			err = kv.ErrKeyNotFound
+		}
+		return
+	}
+	defer closer.Close()
+
+	// value is only valid before closer is closed, an empty value is not nil
+	v = append([]byte{}, value...)
+	return
+}
+
+func existsByPebbleReader(r pebble.Reader, k []byte) (exists bool, err error) {
+	_, closer, err := r.Get(k)
+	if err == pebble.ErrNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	closer.Close()
+
+	exists = true
+	return
+}
+
+func scanByPebbleReader(r pebble.Reader, option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	var pattern *kv.KeyPattern
+	prefix := option.Prefix
+	if option.KeyPattern != "" {
+		pattern, err = kv.CompileKeyPattern(option.KeyPattern)
+		if err != nil {
+			return
+		}
+		var ok bool
+		prefix, ok = pattern.NarrowPrefix(prefix)
+		if !ok {
+			return
+		}
+	}
+
+	iterOpts := &pebble.IterOptions{}
+	if len(prefix) > 0 {
+		iterOpts.LowerBound = prefix
+		upper := kv.Key(prefix).PrefixNext()
+		// no upper bound if prefix is all 0xff
+		if !bytes.HasPrefix(upper, prefix) {
+			iterOpts.UpperBound = upper
+		}
+	}
+
+	iter, err := r.NewIter(iterOpts)
+	if err != nil {
+		return
+	}
+	defer iter.Close()
+
+	switch {
+	case option.Offset != nil && option.Reverse:
+		// seek to the last key <= Offset
+		if !iter.SeekGE(option.Offset) || !bytes.Equal(iter.Key(), option.Offset) {
+			iter.SeekLT(option.Offset)
+		}
+	case option.Offset != nil:
+		iter.SeekGE(option.Offset)
+	case option.Reverse:
+		iter.Last()
+	default:
+		iter.First()
+	}
+
+	next := iter.Next
+	if option.Reverse {
+		next = iter.Prev
+	}
+	for ; iter.Valid(); next() {
+		key := iter.Key()
+		if option.Stop != nil {
+			cmp := bytes.Compare(key, option.Stop)
+			if (option.Reverse && cmp <= 0) || (!option.Reverse && cmp >= 0) {
+				break
+			}
+		}
+
+		if pattern != nil && !pattern.Match(key) {
+			continue
+		}
+
+		var value []byte
+		if !option.KeysOnly {
+			value = iter.Value()
+		}
+		if !fn(key, value, emptyMeta) {
+			break
+		}
+	}
+
+	err = iter.Error()
+	return
+}
@@ -0,0 +1,9 @@
+//go:build pebble
+// +build pebble
+
+package provider
+
+func init() {
+	providers = append(providers, NewPebble)
+	txnProviders = append(txnProviders, txnProvider{new: NewPebble})
+}
@@ -0,0 +1,98 @@
+//go:build pebble
+// +build pebble
+
+package provider
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
+)
+
+// pebbleTxn is mondis.ProviderTxn for pebble,
+// batch is set for update txn and snapshot for read only txn.
+type pebbleTxn struct {
+	batch    *pebble.Batch
+	snapshot *pebble.Snapshot
+	wo       *pebble.WriteOptions
+}
+
+func (txn *pebbleTxn) reader() pebble.Reader {
+	if txn.batch != nil {
+		return txn.batch
+	}
+	return txn.snapshot
+}
+
+// Set for implement mondis.ProviderTxn
+func (txn *pebbleTxn) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	if txn.batch == nil {
+		err = fmt.Errorf("Set on read only txn")
+		return
+	}
+	if meta != nil {
+		err = kv.ErrMetaUnsupported
+		return
+	}
+	err = txn.batch.Set(k, v, nil)
+	return
+}
+
+// Exists checks whether k exists
+func (txn *pebbleTxn) Exists(k []byte) (exists bool, err error) {
+	exists, err = existsByPebbleReader(txn.reader(), k)
+	return
+}
+
+// Get for implement mondis.ProviderTxn
+func (txn *pebbleTxn) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	v, err = getByPebbleReader(txn.reader(), k)
+	return
+}
+
+// Delete for implement mondis.ProviderTxn
+func (txn *pebbleTxn) Delete(key []byte) (err error) {
+	if txn.batch == nil {
+		err = fmt.Errorf("Delete on read only txn")
+		return
+	}
+	err = txn.batch.Delete(key, nil)
+	return
+}
+
+// StartTS for implement mondis.ProviderTxn
+func (txn *pebbleTxn) StartTS() uint64 {
+	return 0
+}
+
+// Commit for implement mondis.ProviderTxn, the txn can't be used afterwards
+func (txn *pebbleTxn) Commit() (err error) {
+	if txn.batch == nil {
+		txn.Discard()
+		return
+	}
+
+	err = txn.batch.Commit(txn.wo)
+	txn.Discard()
+	return
+}
+
+// Discard for implement mondis.ProviderTxn, it's a noop if already committed or discarded
+func (txn *pebbleTxn) Discard() {
+	if txn.batch != nil {
+		txn.batch.Close()
+		txn.batch = nil
+	}
+	if txn.snapshot != nil {
+		txn.snapshot.Close()
+		txn.snapshot = nil
+	}
+}
+
+// Scan over keys specified by option
+func (txn *pebbleTxn) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	err = scanByPebbleReader(txn.reader(), option, fn)
+	return
+}
@@ -0,0 +1,29 @@
+//go:build pebble
+// +build pebble
+
+package provider
+
+import (
+	"github.com/cockroachdb/pebble"
+)
+
+type pebbleWB struct {
+	batch *pebble.Batch
+	wo    *pebble.WriteOptions
+}
+
+func (wb *pebbleWB) Set(k, v []byte) error {
+	return wb.batch.Set(k, v, nil)
+}
+
+func (wb *pebbleWB) Delete(key []byte) error {
+	return wb.batch.Delete(key, nil)
+}
+
+func (wb *pebbleWB) Commit() error {
+	return wb.batch.Commit(wb.wo)
+}
+
+func (wb *pebbleWB) Discard() {
+	wb.batch.Close()
+}
@@ -1,20 +1,40 @@
 package provider
 
 import (
+	"bytes"
+	"math/rand"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
 	"gotest.tools/assert"
 )
 
 const dataDir = "/tmp/mondis"
 
-func TestProvider(t *testing.T) {
-
-	providers := []func() mondis.KVDB{
-		NewBadger, NewLevelDB,
+var (
+	providers = []func() mondis.KVDB{
+		NewBadger, NewLevelDB, NewMemory,
 	}
+	// providers that support transaction
+	txnProviders = []txnProvider{
+		{new: NewBadger, meta: true, conflict: true},
+		{new: NewMemory, meta: true, conflict: true},
+	}
+)
+
+// txnProvider is a provider that supports transaction, and what it supports of badger beyond that
+type txnProvider struct {
+	new func() mondis.KVDB
+	// meta is whether TTL and tag of VMetaReq are supported
+	meta bool
+	// conflict is whether commits of txns whose reads are stale fail with kv.ErrTxnConflict
+	conflict bool
+}
+
+func TestProvider(t *testing.T) {
 
 	for _, provider := range providers {
 		os.RemoveAll(dataDir)
@@ -26,14 +46,14 @@ func TestProvider(t *testing.T) {
 
 		key1 := []byte("key1")
 		{
-			// badger will return nil for both nil and empty value
+			// both nil and empty value are stored as present key with empty value
 
 			// test Set nil
 			err = b.Set(key1, nil, nil)
 			assert.Assert(t, err == nil)
 			// test Get nil value
 			v, _, err := b.Get(key1)
-			assert.Assert(t, err == nil && v == nil)
+			assert.Assert(t, err == nil && v != nil && len(v) == 0)
 
 			exists, err := b.Exists(key1)
 			assert.Assert(t, err == nil && exists)
@@ -45,10 +65,19 @@ func TestProvider(t *testing.T) {
 
 			// test Get empty value
 			v, _, err = b.Get(key1)
-			assert.Assert(t, err == nil && v == nil)
+			assert.Assert(t, err == nil && v != nil && len(v) == 0)
 
 			exists, err = b.Exists(key1)
 			assert.Assert(t, err == nil && exists)
+
+			// test Delete is distinct from empty value
+			err = b.Delete(key1)
+			assert.Assert(t, err == nil)
+			v, _, err = b.Get(key1)
+			assert.Assert(t, err == kv.ErrKeyNotFound && v == nil)
+
+			exists, err = b.Exists(key1)
+			assert.Assert(t, err == nil && !exists)
 		}
 
 		err = b.Close()
@@ -57,3 +86,390 @@ func TestProvider(t *testing.T) {
 	}
 
 }
+
+func TestProviderTxn(t *testing.T) {
+
+	for _, provider := range txnProviders {
+		os.RemoveAll(dataDir)
+
+		b := provider.new()
+		err := b.Open(mondis.KVOption{Dir: dataDir})
+		assert.Assert(t, err == nil)
+
+		keys := []string{"a", "p:1", "p:2", "p:3", "q"}
+		txn := b.NewTransaction(true)
+		for _, key := range keys {
+			assert.Assert(t, txn.Set([]byte(key), []byte(key), nil) == nil)
+		}
+		// reads its own writes
+		v, _, err := txn.Get([]byte("p:1"))
+		assert.Assert(t, err == nil && string(v) == "p:1")
+		assert.Assert(t, txn.Commit() == nil)
+
+		// discarded writes are invisible
+		txn = b.NewTransaction(true)
+		assert.Assert(t, txn.Set([]byte("p:4"), nil, nil) == nil)
+		assert.Assert(t, txn.Delete([]byte("p:1")) == nil)
+		txn.Discard()
+
+		// read txn sees a snapshot
+		rtxn := b.NewTransaction(false)
+		assert.Assert(t, b.Delete([]byte("q")) == nil)
+		exists, err := rtxn.Exists([]byte("q"))
+		assert.Assert(t, err == nil && exists)
+
+		scan := func(option mondis.ProviderScanOption) (got []string) {
+			err := rtxn.Scan(option, func(key, value []byte, meta mondis.VMetaResp) bool {
+				got = append(got, string(key))
+				return true
+			})
+			assert.Assert(t, err == nil)
+			return
+		}
+		assert.DeepEqual(t, scan(mondis.ProviderScanOption{Prefix: []byte("p:")}), []string{"p:1", "p:2", "p:3"})
+		assert.DeepEqual(t, scan(mondis.ProviderScanOption{Prefix: []byte("p:"), Reverse: true}), []string{"p:3", "p:2", "p:1"})
+		assert.DeepEqual(t, scan(mondis.ProviderScanOption{Offset: []byte("p:2"), Stop: []byte("q")}), []string{"p:2", "p:3"})
+		assert.DeepEqual(t, scan(mondis.ProviderScanOption{Offset: []byte("p:2"), Reverse: true}), []string{"p:2", "p:1", "a"})
+		assert.DeepEqual(t, scan(mondis.ProviderScanOption{Reverse: true, Stop: []byte("p:1")}), []string{"q", "p:3", "p:2"})
+		rtxn.Discard()
+
+		_, _, err = b.Get([]byte("q"))
+		assert.Assert(t, err == kv.ErrKeyNotFound)
+
+		assert.Assert(t, b.Close() == nil)
+	}
+}
+
+// TestProviderTxnConformance checks what Memory mimics of badger, as far as each provider supports
+func TestProviderTxnConformance(t *testing.T) {
+	for _, provider := range txnProviders {
+		testProviderTxnConformance(t, provider)
+	}
+}
+
+func testProviderTxnConformance(t *testing.T, provider txnProvider) {
+	os.RemoveAll(dataDir)
+
+	b := provider.new()
+	err := b.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	// closed even if an assertion fails, so that later tests can open dataDir
+	defer b.Close()
+
+	if provider.meta {
+		// ttl
+		key := []byte("ttl")
+		assert.Assert(t, b.Set(key, key, &mondis.VMetaReq{TTL: time.Hour, Tag: 1}) == nil)
+		_, meta, err := b.Get(key)
+		assert.Assert(t, err == nil && meta.Tag == 1 && meta.ExpiresAt > uint64(time.Now().Unix()))
+		assert.Assert(t, b.Set(key, key, &mondis.VMetaReq{TTL: -time.Second}) == nil)
+		_, _, err = b.Get(key)
+		assert.Assert(t, err == kv.ErrKeyNotFound)
+		exists, err := b.Exists(key)
+		assert.Assert(t, err == nil && !exists)
+	} else {
+		assert.Assert(t, b.Set([]byte("ttl"), nil, &mondis.VMetaReq{TTL: time.Hour}) == kv.ErrMetaUnsupported)
+	}
+
+	key := []byte("conflict")
+	if provider.conflict {
+		// conflict on keys read
+		txn1 := b.NewTransaction(true)
+		txn2 := b.NewTransaction(true)
+		_, _, err = txn1.Get(key)
+		assert.Assert(t, err == kv.ErrKeyNotFound)
+		assert.Assert(t, txn1.Set(key, []byte("1"), nil) == nil)
+		assert.Assert(t, txn2.Set(key, []byte("2"), nil) == nil)
+		assert.Assert(t, txn2.Commit() == nil)
+		assert.Assert(t, txn1.Commit() == kv.ErrTxnConflict)
+		txn1.Discard()
+		txn2.Discard()
+
+		// conflict on keys scanned
+		assert.Assert(t, b.Set([]byte("scan1"), []byte("1"), nil) == nil)
+		txn1 = b.NewTransaction(true)
+		txn2 = b.NewTransaction(true)
+		err = txn1.Scan(mondis.ProviderScanOption{Prefix: []byte("scan")}, func(key, value []byte, meta mondis.VMetaResp) bool {
+			return true
+		})
+		assert.Assert(t, err == nil)
+		assert.Assert(t, txn1.Set([]byte("scan2"), []byte("2"), nil) == nil)
+		assert.Assert(t, txn2.Set([]byte("scan1"), []byte("3"), nil) == nil)
+		assert.Assert(t, txn2.Commit() == nil)
+		assert.Assert(t, txn1.Commit() == kv.ErrTxnConflict)
+		txn1.Discard()
+		txn2.Discard()
+
+		// deleted keys met by a scan are not read
+		assert.Assert(t, b.Delete([]byte("scan1")) == nil)
+		txn1 = b.NewTransaction(true)
+		txn2 = b.NewTransaction(true)
+		err = txn1.Scan(mondis.ProviderScanOption{Prefix: []byte("scan")}, func(key, value []byte, meta mondis.VMetaResp) bool {
+			return true
+		})
+		assert.Assert(t, err == nil)
+		assert.Assert(t, txn1.Set([]byte("scan2"), []byte("2"), nil) == nil)
+		assert.Assert(t, txn2.Delete([]byte("scan1")) == nil)
+		assert.Assert(t, txn2.Commit() == nil)
+		assert.Assert(t, txn1.Commit() == nil)
+		txn1.Discard()
+		txn2.Discard()
+	}
+
+	// blind writes don't conflict
+	txn1 := b.NewTransaction(true)
+	txn2 := b.NewTransaction(true)
+	assert.Assert(t, txn1.Set(key, []byte("3"), nil) == nil)
+	assert.Assert(t, txn2.Set(key, []byte("4"), nil) == nil)
+	assert.Assert(t, txn2.Commit() == nil)
+	assert.Assert(t, txn1.Commit() == nil)
+	v, _, err := b.Get(key)
+	assert.Assert(t, err == nil && string(v) == "3")
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, provider := range providers {
+		os.RemoveAll(dataDir)
+
+		b := provider()
+		err := b.Open(mondis.KVOption{Dir: dataDir})
+		assert.Assert(t, err == nil)
+
+		assert.Assert(t, b.Set([]byte("a"), []byte("1"), nil) == nil)
+		assert.Assert(t, b.Set([]byte("b"), []byte("1"), nil) == nil)
+		snapshot, err := b.NewSnapshot()
+		assert.Assert(t, err == nil)
+
+		// later writes are invisible to snapshot
+		assert.Assert(t, b.Set([]byte("a"), []byte("2"), nil) == nil)
+		assert.Assert(t, b.Delete([]byte("b")) == nil)
+		assert.Assert(t, b.Set([]byte("c"), []byte("2"), nil) == nil)
+
+		v, _, err := snapshot.Get([]byte("a"))
+		assert.Assert(t, err == nil && string(v) == "1", err)
+		exists, err := snapshot.Exists([]byte("b"))
+		assert.Assert(t, err == nil && exists)
+		_, _, err = snapshot.Get([]byte("c"))
+		assert.Assert(t, err == kv.ErrKeyNotFound)
+		var got []string
+		err = snapshot.Scan(mondis.ProviderScanOption{}, func(key, value []byte, meta mondis.VMetaResp) bool {
+			got = append(got, string(key)+"="+string(value))
+			return true
+		})
+		assert.Assert(t, err == nil)
+		assert.DeepEqual(t, got, []string{"a=1", "b=1"})
+		snapshot.Release()
+
+		v, _, err = b.Get([]byte("a"))
+		assert.Assert(t, err == nil && string(v) == "2")
+
+		assert.Assert(t, b.Close() == nil)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	stuckCh := make(chan StuckTxn, 1)
+	w := NewWatchdog(NewMemory(), WatchdogOption{
+		Threshold:    50 * time.Millisecond,
+		Interval:     10 * time.Millisecond,
+		ForceDiscard: true,
+		OnStuck: func(txn StuckTxn) {
+			stuckCh <- txn
+		},
+	})
+	err := w.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+
+	assert.Assert(t, w.Set([]byte("a"), []byte("1"), nil) == nil)
+
+	// a txn finished in time is not reported
+	txn := w.NewTransaction(true)
+	assert.Assert(t, txn.Set([]byte("b"), []byte("1"), nil) == nil)
+	// operating on txn inside Scan doesn't block
+	err = txn.Scan(mondis.ProviderScanOption{}, func(key, value []byte, meta mondis.VMetaResp) bool {
+		assert.Assert(t, txn.Delete(key) == nil)
+		return true
+	})
+	assert.Assert(t, err == nil)
+	assert.Assert(t, txn.Commit() == nil)
+	assert.Assert(t, w.Stats().Open == 0)
+
+	stuck := w.NewTransaction(true)
+	assert.Assert(t, stuck.Set([]byte("c"), []byte("1"), nil) == nil)
+	assert.Assert(t, w.Stats().Open == 1)
+
+	select {
+	case stuckTxn := <-stuckCh:
+		assert.Assert(t, stuckTxn.Update && stuckTxn.Duration > 50*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("watchdog callback not triggered")
+	}
+
+	// the callback runs before force discard
+	for w.Stats().ForceDiscarded == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	stats := w.Stats()
+	assert.Assert(t, stats.Open == 0 && stats.Stuck == 1 && stats.ForceDiscarded == 1, stats)
+	assert.Assert(t, stuck.Set([]byte("d"), []byte("1"), nil) == ErrTxnForceDiscarded)
+	assert.Assert(t, stuck.Commit() == ErrTxnForceDiscarded)
+	stuck.Discard()
+
+	exists, err := w.Exists([]byte("c"))
+	assert.Assert(t, err == nil && !exists)
+
+	assert.Assert(t, w.Close() == nil)
+}
+
+func TestBadgerGetAsOf(t *testing.T) {
+	os.RemoveAll(dataDir)
+
+	option := mondis.KVOption{Dir: dataDir, NumVersionsToKeep: 2}
+	b := NewBadger()
+	err := b.Open(option)
+	assert.Assert(t, err == nil)
+
+	key := []byte("key")
+	var versions []uint64
+	for i := 0; i < 3; i++ {
+		err = b.Set(key, []byte{byte(i)}, nil)
+		assert.Assert(t, err == nil)
+		_, meta, err := b.Get(key)
+		assert.Assert(t, err == nil)
+		versions = append(versions, meta.Version)
+	}
+
+	for i, version := range versions {
+		v, meta, err := b.GetAsOf(key, version)
+		assert.Assert(t, err == nil && v[0] == byte(i) && meta.Version == version)
+	}
+	// can't tell whether older versions existed once there're NumVersionsToKeep newer versions
+	_, _, err = b.GetAsOf(key, versions[0]-1)
+	assert.Assert(t, err == kv.ErrVersionUnavailable)
+
+	key2 := []byte("key2")
+	err = b.Set(key2, key2, nil)
+	assert.Assert(t, err == nil)
+	_, meta, err := b.Get(key2)
+	assert.Assert(t, err == nil)
+	_, _, err = b.GetAsOf(key2, meta.Version-1)
+	assert.Assert(t, err == kv.ErrKeyNotFound)
+
+	// flush memtable and force compaction so that versions beyond NumVersionsToKeep are dropped
+	assert.Assert(t, b.Close() == nil)
+	assert.Assert(t, b.Open(option) == nil)
+	assert.Assert(t, b.(*Badger).db.Flatten(1) == nil)
+
+	_, _, err = b.GetAsOf(key, versions[0])
+	assert.Assert(t, err == kv.ErrVersionUnavailable, err)
+	v, _, err := b.GetAsOf(key, versions[1])
+	assert.Assert(t, err == nil && v[0] == 1)
+
+	assert.Assert(t, b.Close() == nil)
+}
+
+func TestBadgerOption(t *testing.T) {
+	b := NewBadger()
+	err := b.Open(mondis.KVOption{Compression: true})
+	assert.Assert(t, err == kv.ErrOptionUnsupported)
+
+	syncWrites := false
+	err = b.Open(mondis.KVOption{InMemory: true, SyncWrites: &syncWrites, ValueLogGCInterval: time.Millisecond * 10})
+	assert.Assert(t, err == nil)
+	tmpDir := b.(*Badger).tmpDir
+	assert.Assert(t, tmpDir != "")
+
+	for i := 0; i < 100; i++ {
+		assert.Assert(t, b.Set([]byte("key"), bytes.Repeat([]byte("v"), 1024), nil) == nil)
+	}
+	// let value log GC run a few times
+	time.Sleep(time.Millisecond * 50)
+	v, _, err := b.Get([]byte("key"))
+	assert.Assert(t, err == nil && len(v) == 1024)
+	// the only value log file is the one being written, so nothing to reclaim
+	reclaimed, err := b.RunValueLogGC(0.5)
+	assert.Assert(t, err == nil && !reclaimed, err)
+
+	assert.Assert(t, b.Close() == nil)
+	_, err = os.Stat(tmpDir)
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestMemoryScanLikeBadger(t *testing.T) {
+	os.RemoveAll(dataDir)
+	b := NewBadger()
+	assert.Assert(t, b.Open(mondis.KVOption{Dir: dataDir}) == nil)
+	defer b.Close()
+	m := NewMemory()
+	assert.Assert(t, m.Open(mondis.KVOption{}) == nil)
+	defer m.Close()
+
+	// short random keys over a small alphabet including bytes >= 0x80 to have lots of shared prefixes
+	alphabet := []byte{0, 1, 'a', 'b', 0x7f, 0x80, 0xff}
+	randKey := func(r *rand.Rand) []byte {
+		key := make([]byte, 1+r.Intn(3))
+		for i := range key {
+			key[i] = alphabet[r.Intn(len(alphabet))]
+		}
+		return key
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		key := randKey(r)
+		if r.Intn(4) == 0 {
+			assert.Assert(t, b.Delete(key) == nil)
+			assert.Assert(t, m.Delete(key) == nil)
+			continue
+		}
+		assert.Assert(t, b.Set(key, key, nil) == nil)
+		assert.Assert(t, m.Set(key, key, nil) == nil)
+	}
+
+	scan := func(kvdb mondis.KVDB, option mondis.ProviderScanOption, limit int, pending [][]byte) (keys [][]byte) {
+		txn := kvdb.NewTransaction(true)
+		defer txn.Discard()
+		// pending writes are merged into the scan
+		for _, key := range pending {
+			assert.Assert(t, txn.Set(key, key, nil) == nil)
+		}
+		err := txn.Scan(option, func(key, value []byte, meta mondis.VMetaResp) bool {
+			assert.Assert(t, option.KeysOnly || bytes.Equal(key, value))
+			keys = append(keys, append([]byte{}, key...))
+			return len(keys) < limit
+		})
+		assert.Assert(t, err == nil)
+		return
+	}
+	for i := 0; i < 500; i++ {
+		option := mondis.ProviderScanOption{Reverse: r.Intn(2) == 0, Prefix: randKey(r)[:r.Intn(2)], KeysOnly: r.Intn(2) == 0}
+		if r.Intn(2) == 0 {
+			option.Offset = randKey(r)
+		}
+		if r.Intn(3) == 0 {
+			option.Stop = randKey(r)
+		}
+		var pending [][]byte
+		for j := r.Intn(3); j > 0; j-- {
+			pending = append(pending, randKey(r))
+		}
+		limit := 1 + r.Intn(20)
+		assert.DeepEqual(t, scan(m, option, limit, pending), scan(b, option, limit, pending))
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	assert.Assert(t, m.Open(mondis.KVOption{NumVersionsToKeep: 2}) == nil)
+	defer m.Close()
+
+	key := []byte("versions")
+	assert.Assert(t, m.Set(key, []byte("2"), nil) == nil)
+	_, meta, err := m.Get(key)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, m.Set(key, []byte("3"), nil) == nil)
+	assert.Assert(t, m.Set(key, []byte("4"), nil) == nil)
+	_, _, err = m.GetAsOf(key, meta.Version)
+	assert.Assert(t, err == kv.ErrVersionUnavailable)
+	v, _, err := m.GetAsOf(key, meta.Version+1)
+	assert.Assert(t, err == nil && string(v) == "3")
+}
@@ -0,0 +1,29 @@
+package provider
+
+import "github.com/zhiqiangxu/mondis"
+
+// txnSnapshot is mondis.Snapshot on top of a read only txn,
+// for providers whose read only txns are snapshots already.
+type txnSnapshot struct {
+	txn mondis.ProviderTxn
+}
+
+// Exists for implement mondis.Snapshot
+func (s *txnSnapshot) Exists(k []byte) (bool, error) {
+	return s.txn.Exists(k)
+}
+
+// Get for implement mondis.Snapshot
+func (s *txnSnapshot) Get(k []byte) ([]byte, mondis.VMetaResp, error) {
+	return s.txn.Get(k)
+}
+
+// Scan for implement mondis.Snapshot
+func (s *txnSnapshot) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) error {
+	return s.txn.Scan(option, fn)
+}
+
+// Release for implement mondis.Snapshot
+func (s *txnSnapshot) Release() {
+	s.txn.Discard()
+}
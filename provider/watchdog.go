@@ -0,0 +1,329 @@
+package provider
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// ErrTxnForceDiscarded when operating on a txn discarded by Watchdog
+var ErrTxnForceDiscarded = errors.New("transaction force discarded for being open too long")
+
+type (
+	// WatchdogOption for Watchdog
+	WatchdogOption struct {
+		// Threshold is how long a txn can be open before it's reported as stuck
+		Threshold time.Duration
+		// Interval between two checks, defaults to Threshold/2
+		Interval time.Duration
+		// ForceDiscard discards stuck txns, later operations on them fail with ErrTxnForceDiscarded
+		ForceDiscard bool
+		// OnStuck is called once for each stuck txn, defaults to logging it by logger.Instance()
+		OnStuck func(txn StuckTxn)
+	}
+	// StuckTxn is a txn open longer than WatchdogOption.Threshold
+	StuckTxn struct {
+		Update   bool
+		OpenedAt time.Time
+		Duration time.Duration
+	}
+	// WatchdogStats for Watchdog
+	WatchdogStats struct {
+		// Open is the number of txns open now
+		Open int
+		// LongLived is the number of txns open now and longer than Threshold
+		LongLived int
+		// Stuck is the number of txns ever reported stuck
+		Stuck uint64
+		// ForceDiscarded is the number of txns discarded by Watchdog
+		ForceDiscarded uint64
+	}
+)
+
+// Watchdog is mondis.KVDB that wraps another one to track how long its txns are open,
+// txns open longer than a threshold are reported and optionally discarded,
+// since a txn leaked by a buggy caller silently pins resources of the provider.
+type Watchdog struct {
+	mondis.KVDB
+	option         WatchdogOption
+	mu             sync.Mutex
+	txns           map[*watchedTxn]struct{}
+	stuck          uint64
+	forceDiscarded uint64
+	closeCh        chan struct{}
+	wg             sync.WaitGroup
+}
+
+// NewWatchdog is ctor for Watchdog
+func NewWatchdog(kvdb mondis.KVDB, option WatchdogOption) *Watchdog {
+	if option.Interval <= 0 {
+		option.Interval = option.Threshold / 2
+	}
+	if option.OnStuck == nil {
+		option.OnStuck = logStuckTxn
+	}
+	return &Watchdog{KVDB: kvdb, option: option}
+}
+
+func logStuckTxn(txn StuckTxn) {
+	logger.Instance().Warn("stuck txn", zap.Bool("update", txn.Update), zap.Time("openedAt", txn.OpenedAt), zap.Duration("duration", txn.Duration))
+}
+
+// Open db and starts watching
+func (w *Watchdog) Open(option mondis.KVOption) (err error) {
+	err = w.KVDB.Open(option)
+	if err != nil {
+		return
+	}
+
+	w.txns = make(map[*watchedTxn]struct{})
+	w.closeCh = make(chan struct{})
+	w.wg.Add(1)
+	go w.run()
+	return
+}
+
+// Close stops watching and closes db
+func (w *Watchdog) Close() (err error) {
+	if w.closeCh != nil {
+		close(w.closeCh)
+		w.wg.Wait()
+		w.closeCh = nil
+	}
+	err = w.KVDB.Close()
+	return
+}
+
+// NewTransaction creates a watched transaction
+func (w *Watchdog) NewTransaction(update bool) mondis.ProviderTxn {
+	txn := &watchedTxn{w: w, txn: w.KVDB.NewTransaction(update), update: update, openedAt: time.Now()}
+	w.mu.Lock()
+	w.txns[txn] = struct{}{}
+	w.mu.Unlock()
+	return txn
+}
+
+// Stats returns current stats of Watchdog
+func (w *Watchdog) Stats() (stats WatchdogStats) {
+	now := time.Now()
+	w.mu.Lock()
+	stats.Open = len(w.txns)
+	for txn := range w.txns {
+		if now.Sub(txn.openedAt) > w.option.Threshold {
+			stats.LongLived++
+		}
+	}
+	w.mu.Unlock()
+	stats.Stuck = atomic.LoadUint64(&w.stuck)
+	stats.ForceDiscarded = atomic.LoadUint64(&w.forceDiscarded)
+	return
+}
+
+func (w *Watchdog) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.option.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.closeCh:
+			return
+		}
+	}
+}
+
+func (w *Watchdog) check() {
+	now := time.Now()
+	var stuck []*watchedTxn
+	w.mu.Lock()
+	for txn := range w.txns {
+		if !txn.reported && now.Sub(txn.openedAt) > w.option.Threshold {
+			txn.reported = true
+			stuck = append(stuck, txn)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, txn := range stuck {
+		atomic.AddUint64(&w.stuck, 1)
+		w.option.OnStuck(StuckTxn{Update: txn.update, OpenedAt: txn.openedAt, Duration: now.Sub(txn.openedAt)})
+		if w.option.ForceDiscard {
+			txn.forceDiscard()
+		}
+	}
+}
+
+func (w *Watchdog) remove(txn *watchedTxn) {
+	w.mu.Lock()
+	delete(w.txns, txn)
+	w.mu.Unlock()
+}
+
+// watchedTxn is mondis.ProviderTxn of Watchdog.
+// A force discard waits for operations in flight, which may be nested like a Delete in the fn of Scan,
+// so the txn is discarded by whichever finishes last.
+type watchedTxn struct {
+	w        *Watchdog
+	txn      mondis.ProviderTxn
+	update   bool
+	openedAt time.Time
+	// reported is protected by w.mu
+	reported bool
+	mu       sync.Mutex
+	inFlight int
+	// done is set once committed or discarded
+	done   bool
+	forced bool
+}
+
+func (txn *watchedTxn) forceDiscard() {
+	txn.mu.Lock()
+	if txn.done {
+		txn.mu.Unlock()
+		return
+	}
+	txn.done = true
+	txn.forced = true
+	idle := txn.inFlight == 0
+	txn.mu.Unlock()
+
+	if idle {
+		txn.txn.Discard()
+	}
+	txn.w.remove(txn)
+	atomic.AddUint64(&txn.w.forceDiscarded, 1)
+}
+
+func (txn *watchedTxn) enter() (err error) {
+	txn.mu.Lock()
+	if txn.forced {
+		err = ErrTxnForceDiscarded
+	} else {
+		txn.inFlight++
+	}
+	txn.mu.Unlock()
+	return
+}
+
+func (txn *watchedTxn) leave() {
+	txn.mu.Lock()
+	txn.inFlight--
+	discard := txn.forced && txn.inFlight == 0
+	txn.mu.Unlock()
+
+	if discard {
+		txn.txn.Discard()
+	}
+}
+
+// Set for implement mondis.ProviderTxn
+func (txn *watchedTxn) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	err = txn.enter()
+	if err != nil {
+		return
+	}
+	defer txn.leave()
+
+	err = txn.txn.Set(k, v, meta)
+	return
+}
+
+// Exists for implement mondis.ProviderTxn
+func (txn *watchedTxn) Exists(k []byte) (exists bool, err error) {
+	err = txn.enter()
+	if err != nil {
+		return
+	}
+	defer txn.leave()
+
+	exists, err = txn.txn.Exists(k)
+	return
+}
+
+// Get for implement mondis.ProviderTxn
+func (txn *watchedTxn) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	err = txn.enter()
+	if err != nil {
+		return
+	}
+	defer txn.leave()
+
+	v, meta, err = txn.txn.Get(k)
+	return
+}
+
+// Delete for implement mondis.ProviderTxn
+func (txn *watchedTxn) Delete(key []byte) (err error) {
+	err = txn.enter()
+	if err != nil {
+		return
+	}
+	defer txn.leave()
+
+	err = txn.txn.Delete(key)
+	return
+}
+
+// Scan for implement mondis.ProviderTxn
+func (txn *watchedTxn) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) (err error) {
+	err = txn.enter()
+	if err != nil {
+		return
+	}
+	defer txn.leave()
+
+	err = txn.txn.Scan(option, fn)
+	return
+}
+
+// StartTS for implement mondis.ProviderTxn
+func (txn *watchedTxn) StartTS() uint64 {
+	return txn.txn.StartTS()
+}
+
+// finish marks txn done, ok is false if it's done already
+func (txn *watchedTxn) finish() (ok bool, err error) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.forced {
+		err = ErrTxnForceDiscarded
+		return
+	}
+	ok = !txn.done
+	txn.done = true
+	return
+}
+
+// Commit for implement mondis.ProviderTxn
+func (txn *watchedTxn) Commit() (err error) {
+	ok, err := txn.finish()
+	if err != nil {
+		return
+	}
+
+	// committing again is up to the provider
+	err = txn.txn.Commit()
+	if ok {
+		txn.w.remove(txn)
+	}
+	return
+}
+
+// Discard for implement mondis.ProviderTxn
+func (txn *watchedTxn) Discard() {
+	ok, _ := txn.finish()
+	if !ok {
+		return
+	}
+
+	txn.txn.Discard()
+	txn.w.remove(txn)
+}
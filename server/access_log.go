@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// AccessEntry is a one-shot request reported to Option.AccessLogger
+type AccessEntry struct {
+	Cmd qrpc.Cmd
+	// Key of the request, which is the prefix for scans, nil for commands not on keys.
+	// It refers to the request buffer, copy it if it's kept after LogAccess returns.
+	Key         []byte
+	PayloadSize int
+	Duration    time.Duration
+	RemoteAddr  string
+}
+
+// TxnAccessEntry is a streamed transaction reported to Option.AccessLogger when it ends
+type TxnAccessEntry struct {
+	ID         uint64
+	RemoteAddr string
+	// Frames received from client, the first operation and commit or discard included
+	Frames int
+	// Keys is the number of distinct keys operated on, scans excluded
+	Keys int
+	// Duration from the first frame to the end
+	Duration  time.Duration
+	Committed bool
+}
+
+// AccessLogger receives every request for Option.AccessLogger,
+// it's called on the goroutine serving the request so should return quickly.
+type AccessLogger interface {
+	LogAccess(entry *AccessEntry)
+	LogTxnAccess(entry *TxnAccessEntry)
+}
+
+// maxLoggedKeyLen is the max bytes of a key written to the log, longer ones are truncated
+const maxLoggedKeyLen = 64
+
+// hexKey formats key as hex for the log, truncated to maxLoggedKeyLen bytes
+func hexKey(key []byte) string {
+	if len(key) <= maxLoggedKeyLen {
+		return hex.EncodeToString(key)
+	}
+	return hex.EncodeToString(key[:maxLoggedKeyLen]) + "...(" + strconv.Itoa(len(key)) + " bytes)"
+}
+
+// accessLogging tells whether requests are timed for Option.SlowThreshold or Option.AccessLogger
+func (s *Server) accessLogging() bool {
+	return s.option.SlowThreshold > 0 || s.option.AccessLogger != nil
+}
+
+// logAccess reports the one-shot request of frame started at start,
+// the payload is only parsed for the key when it's slow or there's Option.AccessLogger.
+func (s *Server) logAccess(frame *qrpc.RequestFrame, start time.Time) {
+	d := time.Since(start)
+	slow := s.option.SlowThreshold > 0 && d > s.option.SlowThreshold
+	if !slow && s.option.AccessLogger == nil {
+		return
+	}
+
+	entry := AccessEntry{
+		Cmd:         frame.Cmd.Routing(),
+		PayloadSize: len(frame.Payload),
+		Duration:    d,
+		RemoteAddr:  frame.ConnectionInfo().RemoteAddr(),
+	}
+	if acc, ok := accessOf(frame.Cmd, frame.Payload); ok {
+		entry.Key = acc.key
+	}
+	if slow {
+		logger.Instance().Warn("slow request",
+			zap.Uint32("cmd", uint32(entry.Cmd)),
+			zap.String("key", hexKey(entry.Key)),
+			zap.Int("payload", entry.PayloadSize),
+			zap.Duration("duration", d),
+			zap.String("remote", entry.RemoteAddr))
+	}
+	if s.option.AccessLogger != nil {
+		s.option.AccessLogger.LogAccess(&entry)
+	}
+}
+
+// txnAccess aggregates a streamed transaction for logTxnAccess, a nil one tracks nothing
+type txnAccess struct {
+	frames    int
+	keys      map[string]struct{}
+	committed bool
+}
+
+// newTxnAccess for the transaction started by frame
+func newTxnAccess(frame *qrpc.RequestFrame) (ta *txnAccess) {
+	ta = &txnAccess{frames: 1, keys: make(map[string]struct{})}
+	if frame.Cmd.Routing() != ScanCmd {
+		if acc, ok := accessOf(frame.Cmd, frame.Payload); ok {
+			ta.touch(acc.key)
+		}
+	}
+	return
+}
+
+func (ta *txnAccess) frame() {
+	if ta != nil {
+		ta.frames++
+	}
+}
+
+func (ta *txnAccess) touch(key []byte) {
+	if ta != nil {
+		ta.keys[string(key)] = struct{}{}
+	}
+}
+
+func (ta *txnAccess) commit(code int32) {
+	if ta != nil {
+		ta.committed = code == CodeOK
+	}
+}
+
+// logTxnAccess reports the transaction of ot once it ends
+func (s *Server) logTxnAccess(ot *openTxn, ta *txnAccess) {
+	entry := TxnAccessEntry{
+		ID:         ot.info.ID,
+		RemoteAddr: ot.info.RemoteAddr,
+		Frames:     ta.frames,
+		Keys:       len(ta.keys),
+		Duration:   time.Since(ot.info.StartTime),
+		Committed:  ta.committed,
+	}
+	if s.option.SlowThreshold > 0 && entry.Duration > s.option.SlowThreshold {
+		logger.Instance().Warn("slow txn",
+			zap.Uint64("id", entry.ID),
+			zap.Int("frames", entry.Frames),
+			zap.Int("keys", entry.Keys),
+			zap.Duration("duration", entry.Duration),
+			zap.Bool("committed", entry.Committed),
+			zap.String("remote", entry.RemoteAddr))
+	}
+	if s.option.AccessLogger != nil {
+		s.option.AccessLogger.LogTxnAccess(&entry)
+	}
+}
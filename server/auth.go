@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/mondis/document/dml"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrAuthFailed when the token is rejected by Option.AuthProvider
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrPermissionDenied when the connection is not authenticated, or not granted the access needed
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Perm is a set of access rights
+type Perm uint8
+
+const (
+	// PermRead for reading keys
+	PermRead Perm = 1 << iota
+	// PermWrite for writing keys
+	PermWrite
+	// PermAdmin for commands not on keys, e.g., MaintenanceCmd, BackupCmd and FsckCmd
+	PermAdmin
+)
+
+// Grant is Perm on keys with Prefix, an empty Prefix is for all keys.
+// Scans need the grant on their prefix, and Doc* and admin commands need it on all keys.
+type Grant struct {
+	Prefix []byte
+	Perm   Perm
+}
+
+// AuthProvider validates tokens of AuthCmd for Option.AuthProvider
+type AuthProvider interface {
+	// Authenticate returns the grants of token, a non nil error rejects it with CodeAuthFailed
+	Authenticate(token string) ([]Grant, error)
+}
+
+type grants []Grant
+
+// allows tells whether key is granted all of perm, which can be granted by different grants
+func (gs grants) allows(key []byte, perm Perm) bool {
+	var granted Perm
+	for _, g := range gs {
+		if bytes.HasPrefix(key, g.Prefix) {
+			granted |= g.Perm
+		}
+	}
+	return granted&perm == perm
+}
+
+// connState is kept on a connection by ConnectionInfo.SetAnything
+type connState struct {
+	docs docCollections
+	// authMu protects authed and grants, which are set by AuthCmd
+	authMu sync.RWMutex
+	authed bool
+	grants grants
+}
+
+// connState of the connection of frame, created on first use
+func (s *Server) connState(frame *qrpc.RequestFrame) (cs *connState) {
+	ci := frame.ConnectionInfo()
+	cs, _ = ci.GetAnything().(*connState)
+	if cs != nil {
+		return
+	}
+
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	cs, _ = ci.GetAnything().(*connState)
+	if cs == nil {
+		cs = &connState{docs: docCollections{m: make(map[docCollectionKey]*dml.Collection)}}
+		ci.SetAnything(cs)
+	}
+	return
+}
+
+// CmdAuth for authenticating the connection by a token, which replaces the grants of a previous AuthCmd.
+// It succeeds with no check if Option.AuthProvider is nil.
+type CmdAuth struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdAuth) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		authReq  pb.AuthRequest
+		authResp pb.AuthResponse
+	)
+
+	err := authReq.Unmarshal(frame.Payload)
+	switch {
+	case err != nil:
+		authResp.Code = CodeInvalidRequest
+		authResp.Msg = err.Error()
+	case cmd.s.option.AuthProvider == nil:
+		authResp.Code = CodeOK
+	default:
+		var gs []Grant
+		gs, err = cmd.s.option.AuthProvider.Authenticate(authReq.Token)
+		cs := cmd.s.connState(frame)
+		cs.authMu.Lock()
+		cs.authed = err == nil
+		cs.grants = gs
+		cs.authMu.Unlock()
+		if err != nil {
+			authResp.Code = CodeAuthFailed
+			authResp.Msg = err.Error()
+		} else {
+			authResp.Code = CodeOK
+		}
+	}
+
+	bytes, _ := authResp.Marshal()
+	err = writeRespBytes(writer, frame, AuthRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
+
+// access is the Perm a request needs on key, which is the prefix for scans
+type access struct {
+	key  []byte
+	perm Perm
+}
+
+// accessOf the request of cmd, ok is false if it needs none,
+// or payload is malformed, which is left to the handler to reject.
+func accessOf(cmd qrpc.Cmd, payload []byte) (acc access, ok bool) {
+	var err error
+	switch cmd.Routing() {
+	case SetCmd:
+		var req pb.SetRequest
+		err = req.Unmarshal(payload)
+		acc = access{key: req.Key, perm: PermWrite}
+	case DeleteCmd:
+		var req pb.DeleteRequest
+		err = req.Unmarshal(payload)
+		acc = access{key: req.Key, perm: PermWrite}
+	case GetCmd:
+		var req pb.GetRequest
+		err = req.Unmarshal(payload)
+		acc = access{key: req.Key, perm: PermRead}
+	case ExistsCmd:
+		var req pb.ExistsRequest
+		err = req.Unmarshal(payload)
+		acc = access{key: req.Key, perm: PermRead}
+	case ScanCmd:
+		var req pb.ScanRequest
+		err = req.Unmarshal(payload)
+		if req.ProviderScanOption == nil {
+			// rejected by the handler
+			return
+		}
+		acc = access{key: req.ProviderScanOption.Prefix, perm: PermRead}
+	case ScanStreamCmd, CountCmd:
+		var req pb.ScanRequest
+		err = req.Unmarshal(payload)
+		// the handler scans all keys without option
+		if req.ProviderScanOption != nil {
+			acc.key = req.ProviderScanOption.Prefix
+		}
+		acc.perm = PermRead
+	case IncrCmd:
+		var req pb.IncrRequest
+		err = req.Unmarshal(payload)
+		acc = access{key: req.Key, perm: PermRead | PermWrite}
+	case IncCmd:
+		var req pb.IncRequest
+		err = req.Unmarshal(payload)
+		acc = access{key: req.Key, perm: PermRead | PermWrite}
+	case CASCmd:
+		var req pb.CASRequest
+		err = req.Unmarshal(payload)
+		acc = access{key: req.Key, perm: PermRead | PermWrite}
+	case SnapshotGetCmd:
+		var req pb.SnapshotGetRequest
+		err = req.Unmarshal(payload)
+		acc = access{key: req.Key, perm: PermRead}
+	case SnapshotScanCmd:
+		var req pb.SnapshotScanRequest
+		err = req.Unmarshal(payload)
+		if req.Scan == nil || req.Scan.ProviderScanOption == nil {
+			// rejected by the handler
+			return
+		}
+		acc = access{key: req.Scan.ProviderScanOption.Prefix, perm: PermRead}
+	case WatchCmd:
+		var req pb.WatchRequest
+		err = req.Unmarshal(payload)
+		acc = access{key: req.Prefix, perm: PermRead}
+	case DocGetCmd:
+		acc = access{perm: PermRead}
+	case DocInsertCmd, DocUpdateCmd, DocDeleteCmd:
+		acc = access{perm: PermWrite}
+	case MaintenanceCmd, ListTxnsCmd, FsckCmd, FsckCancelCmd, BackupCmd, RestoreCmd, SlowLogCmd, ListNodesCmd:
+		acc = access{perm: PermAdmin}
+	default:
+		// AuthCmd, CommitCmd, DiscardCmd, SnapshotCmd and SnapshotReleaseCmd
+		return
+	}
+
+	ok = err == nil
+	return
+}
+
+// checkAccess returns whether the connection of frame is allowed to make the request of cmd with payload,
+// all are allowed if Option.AuthProvider is nil.
+func (s *Server) checkAccess(frame *qrpc.RequestFrame, cmd qrpc.Cmd, payload []byte) bool {
+	if s.option.AuthProvider == nil || cmd.Routing() == AuthCmd {
+		return true
+	}
+
+	cs := s.connState(frame)
+	cs.authMu.RLock()
+	authed, gs := cs.authed, cs.grants
+	cs.authMu.RUnlock()
+	if !authed {
+		return false
+	}
+
+	acc, ok := accessOf(cmd, payload)
+	if !ok {
+		return true
+	}
+	return gs.allows(acc.key, acc.perm)
+}
+
+// isTxnCmd tells whether cmd can start a txn stream
+func isTxnCmd(cmd qrpc.Cmd) bool {
+	switch cmd.Routing() {
+	case ScanStreamCmd, WatchCmd, BackupCmd, RestoreCmd:
+		return false
+	default:
+		return true
+	}
+}
+
+// authMux rejects requests not allowed by checkAccess before they're routed,
+// a txn whose first operation is denied is rejected as a whole,
+// while later operations are checked by handleTxnContinuedFrame one by one.
+// It's also where one-shot requests are timed for access logging, denied ones included.
+type authMux struct {
+	s   *Server
+	mux *qrpc.ServeMux
+}
+
+// ServeQRPC implements qrpc.Handler
+func (m *authMux) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	if frame.Flags&qrpc.StreamFlag == 0 && m.s.accessLogging() {
+		defer m.s.logAccess(frame, time.Now())
+	}
+	if m.s.checkAccess(frame, frame.Cmd, frame.Payload) {
+		m.mux.ServeQRPC(writer, frame)
+		return
+	}
+
+	// all responses start with the same code and msg fields, and response cmd always follows request cmd
+	bytes, _ := (&pb.CommitResponse{Code: CodePermissionDenied, Msg: ErrPermissionDenied.Error()}).Marshal()
+	respCmd := frame.Cmd.Routing() + 1
+	var err error
+	switch {
+	case frame.Flags&qrpc.StreamFlag == 0:
+		err = writeRespBytes(writer, frame, respCmd, bytes)
+	case frame.Flags&qrpc.StreamEndFlag != 0:
+		err = writeStreamRespBytes(writer, frame, respCmd, bytes, true)
+	case !isTxnCmd(frame.Cmd):
+		// streams other than txns are ended by the first response like their handlers do
+		err = writeStreamRespBytes(writer, frame, respCmd, bytes, true)
+		waitStreamClosedByPeer(frame)
+	default:
+		err = writeStreamRespBytes(writer, frame, respCmd, bytes, false)
+		if err == nil {
+			handleRejectedTxnFrames(writer, frame, CodePermissionDenied, ErrPermissionDenied.Error())
+		}
+	}
+	if err != nil {
+		logger.Instance().Error("authMux write", zap.Error(err))
+	}
+}
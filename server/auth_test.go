@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"gotest.tools/assert"
+)
+
+func TestAccessOfMissingScanOption(t *testing.T) {
+	scan, _ := (&pb.ScanRequest{}).Marshal()
+	_, ok := accessOf(ScanCmd, scan)
+	assert.Assert(t, !ok)
+	// scans all keys, so the whole key space is needed
+	for _, cmd := range []qrpc.Cmd{ScanStreamCmd, CountCmd} {
+		acc, ok := accessOf(cmd, scan)
+		assert.Assert(t, ok && len(acc.key) == 0 && acc.perm == PermRead, cmd)
+	}
+
+	for _, req := range []*pb.SnapshotScanRequest{{}, {Scan: &pb.ScanRequest{}}} {
+		payload, _ := req.Marshal()
+		_, ok = accessOf(SnapshotScanCmd, payload)
+		assert.Assert(t, !ok)
+	}
+
+	var resp pb.ScanResponse
+	handleScan(nil, &pb.ScanRequest{Limit: 1}, &resp)
+	assert.Assert(t, resp.Code == CodeInvalidRequest)
+}
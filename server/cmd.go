@@ -31,4 +31,98 @@ const (
 	ScanCmd
 	// ScanRespCmd is resp for ScanCmd
 	ScanRespCmd
+	// MaintenanceCmd for toggling maintenance mode
+	MaintenanceCmd
+	// MaintenanceRespCmd is resp for MaintenanceCmd
+	MaintenanceRespCmd
+	// FsckCmd for checking consistency of document meta
+	FsckCmd
+	// FsckRespCmd is resp for FsckCmd
+	FsckRespCmd
+	// FsckCancelCmd for cancelling the running fsck
+	FsckCancelCmd
+	// FsckCancelRespCmd is resp for FsckCancelCmd
+	FsckCancelRespCmd
+	// ScanStreamCmd for streaming scan
+	ScanStreamCmd
+	// ScanStreamRespCmd is resp for ScanStreamCmd
+	ScanStreamRespCmd
+	// IncrCmd for incr
+	IncrCmd
+	// IncrRespCmd is resp for IncrCmd
+	IncrRespCmd
+	// IncCmd for inc
+	IncCmd
+	// IncRespCmd is resp for IncCmd
+	IncRespCmd
+	// CASCmd for compare and swap
+	CASCmd
+	// CASRespCmd is resp for CASCmd
+	CASRespCmd
+	// CountCmd for count keys in range
+	CountCmd
+	// CountRespCmd is resp for CountCmd
+	CountRespCmd
+	// ListTxnsCmd for list open transactions
+	ListTxnsCmd
+	// ListTxnsRespCmd is resp for ListTxnsCmd
+	ListTxnsRespCmd
+	// DocInsertCmd for insert a document
+	DocInsertCmd
+	// DocInsertRespCmd is resp for DocInsertCmd
+	DocInsertRespCmd
+	// DocGetCmd for get a document by did
+	DocGetCmd
+	// DocGetRespCmd is resp for DocGetCmd
+	DocGetRespCmd
+	// DocUpdateCmd for replace or upsert a document by did
+	DocUpdateCmd
+	// DocUpdateRespCmd is resp for DocUpdateCmd
+	DocUpdateRespCmd
+	// DocDeleteCmd for delete a document by did
+	DocDeleteCmd
+	// DocDeleteRespCmd is resp for DocDeleteCmd
+	DocDeleteRespCmd
+	// TxnTimedOutRespCmd is the final frame of a transaction stream idle for longer than Option.TxnIdleTimeout
+	TxnTimedOutRespCmd
+	// BackupCmd for streaming a backup of kvdb to client
+	BackupCmd
+	// BackupRespCmd is resp for BackupCmd
+	BackupRespCmd
+	// RestoreCmd for streaming a backup from client into kvdb
+	RestoreCmd
+	// RestoreRespCmd is resp for RestoreCmd
+	RestoreRespCmd
+	// SnapshotCmd for creating a snapshot to read from by Snapshot* commands
+	SnapshotCmd
+	// SnapshotRespCmd is resp for SnapshotCmd
+	SnapshotRespCmd
+	// SnapshotGetCmd for get from a snapshot
+	SnapshotGetCmd
+	// SnapshotGetRespCmd is resp for SnapshotGetCmd
+	SnapshotGetRespCmd
+	// SnapshotScanCmd for scan from a snapshot
+	SnapshotScanCmd
+	// SnapshotScanRespCmd is resp for SnapshotScanCmd
+	SnapshotScanRespCmd
+	// SnapshotReleaseCmd for releasing a snapshot
+	SnapshotReleaseCmd
+	// SnapshotReleaseRespCmd is resp for SnapshotReleaseCmd
+	SnapshotReleaseRespCmd
+	// SlowLogCmd for listing the most recent slow operations
+	SlowLogCmd
+	// SlowLogRespCmd is resp for SlowLogCmd
+	SlowLogRespCmd
+	// WatchCmd for watching committed mutations of keys with a prefix
+	WatchCmd
+	// WatchRespCmd is resp for WatchCmd
+	WatchRespCmd
+	// AuthCmd for authenticating the connection by a token
+	AuthCmd
+	// AuthRespCmd is resp for AuthCmd
+	AuthRespCmd
+	// ListNodesCmd for listing nodes registered in meta
+	ListNodesCmd
+	// ListNodesRespCmd is resp for ListNodesCmd
+	ListNodesRespCmd
 )
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// BackupChunkSize is the typical size of data in a single BackupRespCmd frame
+const BackupChunkSize = 64 * 1024
+
+var (
+	// ErrNotInMaintenance when restoring outside maintenance mode
+	ErrNotInMaintenance = errors.New("server not in maintenance mode, restore rejected")
+	// errBackupStopped when client closes its side before the backup is done
+	errBackupStopped = errors.New("backup stopped by client")
+)
+
+const backupCmdsDisabled = "backup commands not enabled"
+
+// CmdBackup for streaming a backup of kvdb, only available when Option.EnableBackupCmds is set.
+// Data is sent in BackupResponse frames, the last frame ends the stream and carries the final code and version.
+// Client should close its side of the stream by an end frame, e.g. DiscardCmd,
+// which stops the backup early if sent before the last frame.
+type CmdBackup struct {
+	s *Server
+}
+
+// backupFrameWriter sends each write as a BackupRespCmd frame
+type backupFrameWriter struct {
+	writer   qrpc.FrameWriter
+	frame    *qrpc.RequestFrame
+	writeErr error
+}
+
+func (w *backupFrameWriter) Write(p []byte) (n int, err error) {
+	if scanStreamStopped(w.frame) {
+		err = errBackupStopped
+		return
+	}
+
+	resp := pb.BackupResponse{Data: p}
+	bytes, _ := resp.Marshal()
+	// EndWrite blocks until the frame is scheduled, which throttles the backup
+	err = writeStreamRespBytes(w.writer, w.frame, BackupRespCmd, bytes, false)
+	if err != nil {
+		w.writeErr = err
+		return
+	}
+	n = len(p)
+	return
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdBackup) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		backupReq  pb.BackupRequest
+		backupResp pb.BackupResponse
+	)
+
+	defer waitStreamClosedByPeer(frame)
+
+	err := backupReq.Unmarshal(frame.Payload)
+	switch {
+	case err != nil:
+		backupResp.Code = CodeInvalidRequest
+		backupResp.Msg = err.Error()
+	case !cmd.s.option.EnableBackupCmds:
+		backupResp.Code = CodeInvalidRequest
+		backupResp.Msg = backupCmdsDisabled
+	default:
+		fw := &backupFrameWriter{writer: writer, frame: frame}
+		w := bufio.NewWriterSize(fw, BackupChunkSize)
+		var version uint64
+		version, err = cmd.s.kvdb.Backup(w, backupReq.Since)
+		if err == nil {
+			err = w.Flush()
+		}
+		if fw.writeErr != nil {
+			logger.Instance().Error("writeStreamRespBytes", zap.Error(fw.writeErr))
+			return
+		}
+		if err == errBackupStopped {
+			return
+		}
+		if err != nil {
+			backupResp.Code = backupErrorCode(err)
+			backupResp.Msg = err.Error()
+		} else {
+			backupResp.Version = version
+		}
+	}
+
+	bytes, _ := backupResp.Marshal()
+	err = writeStreamRespBytes(writer, frame, BackupRespCmd, bytes, true)
+	if err != nil {
+		logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+	}
+}
+
+func backupErrorCode(err error) int32 {
+	if err == kv.ErrBackupUnavailable {
+		return CodeBackupUnavailable
+	}
+	return CodeInternalError
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// casMaxRetries is the max number of retries on conflict with concurrent writers of the same key
+const casMaxRetries = 20
+
+// CmdCAS for compare and swap or delete, the compare and the write are done in one update transaction,
+// or in the streamed transaction it starts or continues, the current value is returned if it doesn't match.
+type CmdCAS struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdCAS) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		casReq  pb.CASRequest
+		casResp pb.CASResponse
+	)
+
+	err := casReq.Unmarshal(frame.Payload)
+	if err != nil {
+		casResp.Code = CodeInvalidRequest
+		casResp.Msg = err.Error()
+		bytes, _ := casResp.Marshal()
+		err := writeRespBytes(writer, frame, CASRespCmd, bytes)
+		if err != nil {
+			logger.Instance().Error("writeRespBytes", zap.Error(err))
+		}
+		frame.Close()
+		return
+	}
+
+	switch frame.Flags.IsDone() {
+	case true:
+
+		if cmd.s.inMaintenance() {
+			casResp.Code = CodeMaintenance
+			casResp.Msg = ErrMaintenance.Error()
+		} else {
+			handleCAS(cmd.s, &casReq, &casResp)
+		}
+
+		bytes, _ := casResp.Marshal()
+		err = writeRespBytes(writer, frame, CASRespCmd, bytes)
+		if err != nil {
+			logger.Instance().Error("writeRespBytes", zap.Error(err))
+		}
+	case false:
+		if cmd.s.inMaintenance() {
+			casResp.Code = CodeMaintenance
+			casResp.Msg = ErrMaintenance.Error()
+			bytes, _ := casResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, CASRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeMaintenance, ErrMaintenance.Error())
+			return
+		}
+		txn, ot, err := cmd.s.beginTxn(frame, true)
+		if err != nil {
+			casResp.Code = CodeDraining
+			casResp.Msg = err.Error()
+			bytes, _ := casResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, CASRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeDraining, ErrDraining.Error())
+			return
+		}
+		defer txn.Discard()
+		defer cmd.s.txns.remove(ot)
+
+		handleTxnCAS(cmd.s, txn, &casReq, &casResp)
+		{
+			bytes, _ := casResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, CASRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+		}
+
+		handleTxnContinuedFrame(cmd.s, writer, frame, txn, ot)
+
+	}
+}
+
+// casExpected is the value req expects, nil for a missing key
+func casExpected(req *pb.CASRequest) (expected []byte) {
+	expected = req.Expected
+	switch {
+	case req.ExpectAbsent:
+		expected = nil
+	case expected == nil:
+		// an empty value is not distinguishable from a missing one on the wire
+		expected = []byte{}
+	}
+	return
+}
+
+// compareAnd does the compare and the write of req in txn
+func compareAnd(txn mondis.ProviderTxn, req *pb.CASRequest) (swapped bool, current []byte, err error) {
+	expected := casExpected(req)
+	if req.Delete {
+		swapped, current, err = kv.CompareAndDelete(txn, req.Key, expected)
+	} else {
+		swapped, current, err = kv.CompareAndSet(txn, req.Key, expected, req.NewValue)
+	}
+	return
+}
+
+// casResult fills resp with the result of a successful compareAnd
+func casResult(resp *pb.CASResponse, swapped bool, current []byte) {
+	resp.Code = CodeOK
+	resp.Msg = ""
+	resp.Swapped = swapped
+	resp.Current = nil
+	resp.Exists = false
+	if !swapped {
+		resp.Current = current
+		resp.Exists = current != nil
+	}
+}
+
+func handleTxnCAS(s *Server, txn mondis.ProviderTxn, req *pb.CASRequest, resp *pb.CASResponse) {
+	swapped, current, err := compareAnd(s.validating(txn), req)
+	if err != nil {
+		if msg, ok := validationFailed(err); ok {
+			resp.Code = CodeValidationFailed
+			resp.Msg = msg
+			return
+		}
+		resp.Code, resp.Msg = txnErrorCode(err)
+		return
+	}
+
+	casResult(resp, swapped, current)
+}
+
+func handleCAS(s *Server, req *pb.CASRequest, resp *pb.CASResponse) {
+	var (
+		swapped bool
+		current []byte
+	)
+	// a concurrent write to the key after it's read fails the commit with conflict,
+	// in which case the compare is redone against the new value
+	err := s.runInNewUpdateTxnWithRetry(func(txn mondis.ProviderTxn) (err error) {
+		swapped, current, err = compareAnd(s.validating(txn), req)
+		return
+	}, casMaxRetries)
+	if err != nil {
+		if msg, ok := preCommitRejected(err); ok {
+			resp.Code = CodePreCommitRejected
+			resp.Msg = msg
+			return
+		}
+		if msg, ok := validationFailed(err); ok {
+			resp.Code = CodeValidationFailed
+			resp.Msg = msg
+			return
+		}
+		if err == kv.ErrTxnConflict {
+			resp.Code = CodeTxnConflict
+		} else {
+			resp.Code = CodeInternalError
+		}
+		resp.Msg = err.Error()
+		return
+	}
+
+	casResult(resp, swapped, current)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// CmdCount for count keys matching a scan option, no entry is shipped back.
+// The request is a ScanRequest, Limit caps the count if positive.
+type CmdCount struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdCount) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		scanReq   pb.ScanRequest
+		countResp pb.CountResponse
+	)
+
+	err := scanReq.Unmarshal(frame.Payload)
+	if err != nil {
+		countResp.Code = CodeInvalidRequest
+		countResp.Msg = err.Error()
+	} else {
+		handleCount(cmd.s.kvdb, &scanReq, &countResp)
+	}
+
+	bytes, _ := countResp.Marshal()
+	err = writeRespBytes(writer, frame, CountRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
+
+func handleCount(kvop mondis.ProviderKVOP, req *pb.ScanRequest, resp *pb.CountResponse) {
+	var option mondis.ProviderScanOption
+	if pso := req.ProviderScanOption; pso != nil {
+		option = mondis.ProviderScanOption{Reverse: pso.Reverse, Prefix: pso.Prefix, Offset: pso.Offset, Stop: pso.Stop, KeyPattern: pso.KeyPattern}
+	}
+	option.KeysOnly = true
+	limit := int64(req.Limit)
+
+	var n int64
+	err := kvop.Scan(option, func(key, value []byte, meta mondis.VMetaResp) bool {
+		n++
+		return limit <= 0 || n < limit
+	})
+	if err != nil {
+		resp.Code = scanErrorCode(err)
+		resp.Msg = err.Error()
+		return
+	}
+
+	resp.Code = CodeOK
+	resp.Msg = ""
+	resp.N = n
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"time"
+
 	"github.com/zhiqiangxu/mondis/pb"
 	"github.com/zhiqiangxu/qrpc"
 	"github.com/zhiqiangxu/util/logger"
@@ -35,7 +37,14 @@ func (cmd *CmdDelete) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFram
 	switch frame.Flags.IsDone() {
 	case true:
 
-		handleDelete(cmd.s.kvdb, &deleteReq, &deleteResp)
+		start := time.Now()
+		if cmd.s.inMaintenance() {
+			deleteResp.Code = CodeMaintenance
+			deleteResp.Msg = ErrMaintenance.Error()
+		} else {
+			handleDelete(cmd.s, &deleteReq, &deleteResp)
+		}
+		cmd.s.observeCmd(DeleteCmd, start, deleteResp.Code)
 
 		bytes, _ := deleteResp.Marshal()
 		err = writeRespBytes(writer, frame, DeleteRespCmd, bytes)
@@ -43,10 +52,37 @@ func (cmd *CmdDelete) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFram
 			logger.Instance().Error("writeRespBytes", zap.Error(err))
 		}
 	case false:
-		txn := cmd.s.kvdb.NewTransaction(true)
+		if cmd.s.inMaintenance() {
+			deleteResp.Code = CodeMaintenance
+			deleteResp.Msg = ErrMaintenance.Error()
+			bytes, _ := deleteResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, DeleteRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeMaintenance, ErrMaintenance.Error())
+			return
+		}
+		txn, ot, err := cmd.s.beginTxn(frame, true)
+		if err != nil {
+			deleteResp.Code = CodeDraining
+			deleteResp.Msg = err.Error()
+			bytes, _ := deleteResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, DeleteRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeDraining, ErrDraining.Error())
+			return
+		}
 		defer txn.Discard()
+		defer cmd.s.txns.remove(ot)
 
+		start := time.Now()
 		handleTxnDelete(txn, &deleteReq, &deleteResp)
+		cmd.s.observeCmd(DeleteCmd, start, deleteResp.Code)
 		{
 			bytes, _ := deleteResp.Marshal()
 			err = writeStreamRespBytes(writer, frame, DeleteRespCmd, bytes, false)
@@ -56,7 +92,7 @@ func (cmd *CmdDelete) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFram
 			}
 		}
 
-		handleTxnContinuedFrame(writer, frame, txn)
+		handleTxnContinuedFrame(cmd.s, writer, frame, txn, ot)
 
 	}
 }
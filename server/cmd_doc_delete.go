@@ -0,0 +1,53 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// CmdDocDelete for delete a document from the hosted document layer
+type CmdDocDelete struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdDocDelete) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		deleteReq  pb.DocDeleteRequest
+		deleteResp pb.DocDeleteResponse
+	)
+
+	err := deleteReq.Unmarshal(frame.Payload)
+	if err != nil {
+		deleteResp.Code = CodeInvalidRequest
+		deleteResp.Msg = err.Error()
+	} else {
+		deleteResp.Code, deleteResp.Msg = cmd.s.docPrecheck(true)
+	}
+	if deleteResp.Code == CodeOK {
+		cmd.handleDocDelete(frame, &deleteReq, &deleteResp)
+	}
+
+	bytes, _ := deleteResp.Marshal()
+	err = writeRespBytes(writer, frame, DocDeleteRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
+
+func (cmd *CmdDocDelete) handleDocDelete(frame *qrpc.RequestFrame, req *pb.DocDeleteRequest, resp *pb.DocDeleteResponse) {
+	c, err := cmd.s.docCollection(frame, req.Db, req.Collection)
+	if err == nil {
+		err = c.DeleteOne(req.Did, nil)
+	}
+	if err != nil {
+		resp.Code, resp.Msg = cmd.s.docError(frame, req.Db, req.Collection, err)
+		return
+	}
+
+	resp.Code = CodeOK
+	resp.Msg = ""
+	cmd.s.observeDoc(metricDocDelete, req.Db, req.Collection)
+}
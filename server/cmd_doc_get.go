@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+// CmdDocGet for get a document from the hosted document layer
+type CmdDocGet struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdDocGet) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		getReq  pb.DocGetRequest
+		getResp pb.DocGetResponse
+	)
+
+	err := getReq.Unmarshal(frame.Payload)
+	if err != nil {
+		getResp.Code = CodeInvalidRequest
+		getResp.Msg = err.Error()
+	} else {
+		getResp.Code, getResp.Msg = cmd.s.docPrecheck(false)
+	}
+	if getResp.Code == CodeOK {
+		cmd.handleDocGet(frame, &getReq, &getResp)
+	}
+
+	bytes, _ := getResp.Marshal()
+	err = writeRespBytes(writer, frame, DocGetRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
+
+func (cmd *CmdDocGet) handleDocGet(frame *qrpc.RequestFrame, req *pb.DocGetRequest, resp *pb.DocGetResponse) {
+	var doc bson.Raw
+	c, err := cmd.s.docCollection(frame, req.Db, req.Collection)
+	if err == nil {
+		err = c.GetOne(req.Did, &doc, nil)
+	}
+	if err != nil {
+		resp.Code, resp.Msg = cmd.s.docError(frame, req.Db, req.Collection, err)
+		return
+	}
+
+	resp.Code = CodeOK
+	resp.Msg = ""
+	resp.Payload = doc
+}
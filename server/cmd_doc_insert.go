@@ -0,0 +1,57 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+// CmdDocInsert for insert a document into the hosted document layer
+type CmdDocInsert struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdDocInsert) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		insertReq  pb.DocInsertRequest
+		insertResp pb.DocInsertResponse
+	)
+
+	err := insertReq.Unmarshal(frame.Payload)
+	if err == nil {
+		err = bson.Raw(insertReq.Payload).Validate()
+	}
+	if err != nil {
+		insertResp.Code = CodeInvalidRequest
+		insertResp.Msg = err.Error()
+	} else {
+		insertResp.Code, insertResp.Msg = cmd.s.docPrecheck(true)
+	}
+	if insertResp.Code == CodeOK {
+		cmd.handleDocInsert(frame, &insertReq, &insertResp)
+	}
+
+	bytes, _ := insertResp.Marshal()
+	err = writeRespBytes(writer, frame, DocInsertRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
+
+func (cmd *CmdDocInsert) handleDocInsert(frame *qrpc.RequestFrame, req *pb.DocInsertRequest, resp *pb.DocInsertResponse) {
+	c, err := cmd.s.docCollection(frame, req.Db, req.Collection)
+	if err == nil {
+		resp.Did, err = c.InsertOne(bson.Raw(req.Payload), nil)
+	}
+	if err != nil {
+		resp.Code, resp.Msg = cmd.s.docError(frame, req.Db, req.Collection, err)
+		return
+	}
+
+	resp.Code = CodeOK
+	resp.Msg = ""
+	cmd.s.observeDoc(metricDocInsert, req.Db, req.Collection)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis/document/dml"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+// CmdDocUpdate for replace or upsert a document of the hosted document layer
+type CmdDocUpdate struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdDocUpdate) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		updateReq  pb.DocUpdateRequest
+		updateResp pb.DocUpdateResponse
+	)
+
+	err := updateReq.Unmarshal(frame.Payload)
+	if err == nil {
+		err = bson.Raw(updateReq.Payload).Validate()
+	}
+	if err != nil {
+		updateResp.Code = CodeInvalidRequest
+		updateResp.Msg = err.Error()
+	} else {
+		updateResp.Code, updateResp.Msg = cmd.s.docPrecheck(true)
+	}
+	if updateResp.Code == CodeOK {
+		cmd.handleDocUpdate(frame, &updateReq, &updateResp)
+	}
+
+	bytes, _ := updateResp.Marshal()
+	err = writeRespBytes(writer, frame, DocUpdateRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
+
+func (cmd *CmdDocUpdate) handleDocUpdate(frame *qrpc.RequestFrame, req *pb.DocUpdateRequest, resp *pb.DocUpdateResponse) {
+	c, err := cmd.s.docCollection(frame, req.Db, req.Collection)
+	if err == nil {
+		if req.Upsert {
+			resp.IsNew, err = c.UpsertOne(req.Did, bson.Raw(req.Payload), nil)
+		} else {
+			var exists bool
+			exists, err = c.UpdateOne(req.Did, bson.Raw(req.Payload), nil)
+			if err == nil && !exists {
+				err = dml.ErrDocNotFound
+			}
+		}
+	}
+	if err != nil {
+		resp.Code, resp.Msg = cmd.s.docError(frame, req.Db, req.Collection, err)
+		return
+	}
+
+	resp.Code = CodeOK
+	resp.Msg = ""
+	if resp.IsNew {
+		cmd.s.observeDoc(metricDocInsert, req.Db, req.Collection)
+	} else {
+		cmd.s.observeDoc(metricDocUpdate, req.Db, req.Collection)
+	}
+}
@@ -35,7 +35,7 @@ func (cmd *CmdExists) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFram
 	switch frame.Flags.IsDone() {
 	case true:
 
-		handleExists(cmd.s.kvdb, &existsReq, &existsResp)
+		handleExists(cmd.s.readKVOP(), &existsReq, &existsResp)
 
 		bytes, _ := existsResp.Marshal()
 		err = writeRespBytes(writer, frame, ExistsRespCmd, bytes)
@@ -43,8 +43,34 @@ func (cmd *CmdExists) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFram
 			logger.Instance().Error("writeRespBytes", zap.Error(err))
 		}
 	case false:
-		txn := cmd.s.kvdb.NewTransaction(frame.Cmd.Opaque() == 1)
+		update := isUpdateTxnFrame(frame)
+		if update && cmd.s.inMaintenance() {
+			existsResp.Code = CodeMaintenance
+			existsResp.Msg = ErrMaintenance.Error()
+			bytes, _ := existsResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, ExistsRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeMaintenance, ErrMaintenance.Error())
+			return
+		}
+		txn, ot, err := cmd.s.beginTxn(frame, update)
+		if err != nil {
+			existsResp.Code = CodeDraining
+			existsResp.Msg = err.Error()
+			bytes, _ := existsResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, ExistsRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeDraining, ErrDraining.Error())
+			return
+		}
 		defer txn.Discard()
+		defer cmd.s.txns.remove(ot)
 
 		handleExists(txn, &existsReq, &existsResp)
 		{
@@ -56,7 +82,7 @@ func (cmd *CmdExists) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFram
 			}
 		}
 
-		handleTxnContinuedFrame(writer, frame, txn)
+		handleTxnContinuedFrame(cmd.s, writer, frame, txn, ot)
 
 	}
 }
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis/document"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// fsckCmdsDisabled is the msg of FsckCmd and FsckCancelCmd without Option.EnableFsckCmd
+const fsckCmdsDisabled = "fsck commands not enabled"
+
+// CmdFsck for checking consistency of document meta, only available when Option.EnableFsckCmd is set.
+// Progress is streamed as FsckResponse frames, each carries the issues found since the previous one,
+// the last one has Done set.
+type CmdFsck struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdFsck) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		fsckReq  pb.FsckRequest
+		fsckResp pb.FsckResponse
+	)
+
+	err := fsckReq.Unmarshal(frame.Payload)
+	if err != nil {
+		fsckResp.Code = CodeInvalidRequest
+		fsckResp.Msg = err.Error()
+		fsckResp.Done = true
+		cmd.writeResp(writer, frame, &fsckResp)
+		return
+	}
+	if !cmd.s.option.EnableFsckCmd {
+		fsckResp.Code = CodeInvalidRequest
+		fsckResp.Msg = fsckCmdsDisabled
+		fsckResp.Done = true
+		cmd.writeResp(writer, frame, &fsckResp)
+		return
+	}
+
+	ctx, done, err := cmd.s.startFsck()
+	if err != nil {
+		fsckResp.Code = CodeFsckRunning
+		fsckResp.Msg = err.Error()
+		fsckResp.Done = true
+		cmd.writeResp(writer, frame, &fsckResp)
+		return
+	}
+	defer done()
+
+	option := document.CheckOption{
+		Scope:    document.CheckScope(fsckReq.Scope),
+		DBID:     fsckReq.DbId,
+		Repair:   fsckReq.Repair,
+		Interval: cmd.s.option.FsckInterval,
+	}
+	// number of issues sent by previous frames
+	sent := 0
+	report, err := document.CheckConsistency(ctx, cmd.s.kvdb, option, func(report document.CheckReport) {
+		var progressResp pb.FsckResponse
+		checkReport2PB(report, sent, &progressResp)
+		sent = len(report.Issues)
+		if cmd.writeResp(writer, frame, &progressResp) != nil {
+			// client gone
+			cmd.s.CancelFsck()
+		}
+	})
+
+	checkReport2PB(report, sent, &fsckResp)
+	if err != nil {
+		fsckResp.Code = CodeInternalError
+		fsckResp.Msg = err.Error()
+	}
+	fsckResp.Done = true
+	cmd.writeResp(writer, frame, &fsckResp)
+}
+
+func (cmd *CmdFsck) writeResp(writer qrpc.FrameWriter, frame *qrpc.RequestFrame, resp *pb.FsckResponse) (err error) {
+	bytes, _ := resp.Marshal()
+	err = writeStreamRespBytes(writer, frame, FsckRespCmd, bytes, resp.Done)
+	if err != nil {
+		logger.Instance().Error("FsckCmd writeStreamRespBytes", zap.Error(err))
+	}
+	return
+}
+
+// CmdFsckCancel for cancelling the running fsck, only available when Option.EnableFsckCmd is set
+type CmdFsckCancel struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdFsckCancel) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var cancelResp pb.FsckCancelResponse
+
+	if !cmd.s.option.EnableFsckCmd {
+		cancelResp.Code = CodeInvalidRequest
+		cancelResp.Msg = fsckCmdsDisabled
+	} else if err := cmd.s.CancelFsck(); err != nil {
+		cancelResp.Code = CodeNoFsckRunning
+		cancelResp.Msg = err.Error()
+	}
+
+	bytes, _ := cancelResp.Marshal()
+	err := writeRespBytes(writer, frame, FsckCancelRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
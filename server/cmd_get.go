@@ -1,6 +1,9 @@
 package server
 
 import (
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/pb"
 	"github.com/zhiqiangxu/qrpc"
 	"github.com/zhiqiangxu/util/logger"
@@ -34,8 +37,20 @@ func (cmd *CmdGet) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame)
 
 	switch frame.Flags.IsDone() {
 	case true:
+		start := time.Now()
+		kvop := cmd.s.readKVOP()
+		if vg, ok := kvop.(mondis.ValueGetter); ok {
+			var code int32
+			code, err = writeGetResp(writer, frame, vg, &getReq)
+			cmd.s.observeCmd(GetCmd, start, code)
+			if err != nil {
+				logger.Instance().Error("writeGetResp", zap.Error(err))
+			}
+			return
+		}
 
-		handleGet(cmd.s.kvdb, &getReq, &getResp)
+		handleGet(kvop, &getReq, &getResp)
+		cmd.s.observeCmd(GetCmd, start, getResp.Code)
 
 		bytes, _ := getResp.Marshal()
 		err = writeRespBytes(writer, frame, GetRespCmd, bytes)
@@ -43,10 +58,38 @@ func (cmd *CmdGet) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame)
 			logger.Instance().Error("writeRespBytes", zap.Error(err))
 		}
 	case false:
-		txn := cmd.s.kvdb.NewTransaction(frame.Cmd.Opaque() == 1)
+		update := isUpdateTxnFrame(frame)
+		if update && cmd.s.inMaintenance() {
+			getResp.Code = CodeMaintenance
+			getResp.Msg = ErrMaintenance.Error()
+			bytes, _ := getResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, GetRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeMaintenance, ErrMaintenance.Error())
+			return
+		}
+		txn, ot, err := cmd.s.beginTxn(frame, update)
+		if err != nil {
+			getResp.Code = CodeDraining
+			getResp.Msg = err.Error()
+			bytes, _ := getResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, GetRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeDraining, ErrDraining.Error())
+			return
+		}
 		defer txn.Discard()
+		defer cmd.s.txns.remove(ot)
 
+		start := time.Now()
 		handleGet(txn, &getReq, &getResp)
+		cmd.s.observeCmd(GetCmd, start, getResp.Code)
 		{
 			bytes, _ := getResp.Marshal()
 			err = writeStreamRespBytes(writer, frame, GetRespCmd, bytes, false)
@@ -56,7 +99,7 @@ func (cmd *CmdGet) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame)
 			}
 		}
 
-		handleTxnContinuedFrame(writer, frame, txn)
+		handleTxnContinuedFrame(cmd.s, writer, frame, txn, ot)
 
 	}
 }
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// CmdInc for inc, the value is stored as 8-byte big-endian like kv.IncBinaryInt64
+type CmdInc struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdInc) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		incReq  pb.IncRequest
+		incResp pb.IncResponse
+	)
+
+	err := incReq.Unmarshal(frame.Payload)
+	if err != nil {
+		incResp.Code = CodeInvalidRequest
+		incResp.Msg = err.Error()
+		bytes, _ := incResp.Marshal()
+		err := writeRespBytes(writer, frame, IncRespCmd, bytes)
+		if err != nil {
+			logger.Instance().Error("writeRespBytes", zap.Error(err))
+		}
+		frame.Close()
+		return
+	}
+
+	switch frame.Flags.IsDone() {
+	case true:
+
+		if cmd.s.inMaintenance() {
+			incResp.Code = CodeMaintenance
+			incResp.Msg = ErrMaintenance.Error()
+		} else {
+			handleInc(cmd.s, &incReq, &incResp)
+		}
+
+		bytes, _ := incResp.Marshal()
+		err = writeRespBytes(writer, frame, IncRespCmd, bytes)
+		if err != nil {
+			logger.Instance().Error("writeRespBytes", zap.Error(err))
+		}
+	case false:
+		if cmd.s.inMaintenance() {
+			incResp.Code = CodeMaintenance
+			incResp.Msg = ErrMaintenance.Error()
+			bytes, _ := incResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, IncRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeMaintenance, ErrMaintenance.Error())
+			return
+		}
+		txn, ot, err := cmd.s.beginTxn(frame, true)
+		if err != nil {
+			incResp.Code = CodeDraining
+			incResp.Msg = err.Error()
+			bytes, _ := incResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, IncRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeDraining, ErrDraining.Error())
+			return
+		}
+		defer txn.Discard()
+		defer cmd.s.txns.remove(ot)
+
+		handleTxnInc(cmd.s, txn, &incReq, &incResp)
+		{
+			bytes, _ := incResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, IncRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+		}
+
+		handleTxnContinuedFrame(cmd.s, writer, frame, txn, ot)
+
+	}
+}
+
+func handleInc(s *Server, req *pb.IncRequest, resp *pb.IncResponse) {
+	var n int64
+	// concurrent increments of the same key conflict on commit, retry to serialize them
+	err := s.runInNewUpdateTxnWithRetry(func(txn mondis.ProviderTxn) (err error) {
+		n, err = kv.IncBinaryInt64(s.validating(txn), req.Key, req.Delta)
+		return
+	}, incrMaxRetries)
+	if err != nil {
+		if msg, ok := preCommitRejected(err); ok {
+			resp.Code = CodePreCommitRejected
+			resp.Msg = msg
+			return
+		}
+		if msg, ok := validationFailed(err); ok {
+			resp.Code = CodeValidationFailed
+			resp.Msg = msg
+			return
+		}
+		switch err {
+		case kv.ErrOverflow:
+			resp.Code = CodeOverflow
+		case kv.ErrTxnConflict:
+			resp.Code = CodeTxnConflict
+		default:
+			resp.Code = CodeInternalError
+		}
+		resp.Msg = err.Error()
+		return
+	}
+
+	resp.Code = CodeOK
+	resp.Msg = ""
+	resp.Value = n
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// incrMaxRetries is the max number of retries on conflict with concurrent increments of the same key
+const incrMaxRetries = 20
+
+// CmdIncr for incr, which is one-shot IncCmd under another cmd,
+// so that counters have one encoding whichever of them is used.
+// IncrRequest and IncrResponse are the same on the wire as IncRequest and IncResponse.
+type CmdIncr struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdIncr) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		incReq  pb.IncRequest
+		incResp pb.IncResponse
+	)
+
+	err := incReq.Unmarshal(frame.Payload)
+	switch {
+	case err != nil:
+		incResp.Code = CodeInvalidRequest
+		incResp.Msg = err.Error()
+	case cmd.s.inMaintenance():
+		incResp.Code = CodeMaintenance
+		incResp.Msg = ErrMaintenance.Error()
+	default:
+		handleInc(cmd.s, &incReq, &incResp)
+	}
+
+	bytes, _ := incResp.Marshal()
+	err = writeRespBytes(writer, frame, IncrRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis/document/meta"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// CmdListNodes for listing live nodes registered in meta, ordered by epoch
+type CmdListNodes struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdListNodes) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var listResp pb.ListNodesResponse
+
+	nodes, err := meta.ListNodes(cmd.s.kvdb)
+	if err != nil {
+		listResp.Code = CodeInternalError
+		listResp.Msg = err.Error()
+	} else {
+		for _, node := range nodes {
+			listResp.Nodes = append(listResp.Nodes, &pb.NodeInfo{
+				Id:        node.ID,
+				Host:      node.Host,
+				Pid:       int64(node.PID),
+				StartTime: node.StartTime.UnixNano(),
+				Epoch:     node.Epoch,
+				Roles:     node.Roles,
+				Heartbeat: node.Heartbeat.UnixNano(),
+				Ttl:       int64(node.TTL),
+			})
+		}
+		listResp.Code = CodeOK
+	}
+
+	bytes, _ := listResp.Marshal()
+	err = writeRespBytes(writer, frame, ListNodesRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
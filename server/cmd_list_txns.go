@@ -0,0 +1,39 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// CmdListTxns for listing open client transactions, only available when Option.EnableListTxnsCmd is set
+type CmdListTxns struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdListTxns) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var listResp pb.ListTxnsResponse
+
+	if !cmd.s.option.EnableListTxnsCmd {
+		listResp.Code = CodeInvalidRequest
+		listResp.Msg = "list txns command not enabled"
+	} else {
+		for _, info := range cmd.s.ListTxns() {
+			listResp.Txns = append(listResp.Txns, &pb.TxnInfo{
+				Id:         info.ID,
+				RemoteAddr: info.RemoteAddr,
+				StartTime:  info.StartTime.UnixNano(),
+				Ops:        info.Ops,
+			})
+		}
+		listResp.Code = CodeOK
+	}
+
+	bytes, _ := listResp.Marshal()
+	err := writeRespBytes(writer, frame, ListTxnsRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
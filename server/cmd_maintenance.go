@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// CmdMaintenance for toggling maintenance mode, only available when Option.EnableMaintenanceCmd is set
+type CmdMaintenance struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdMaintenance) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		maintenanceReq  pb.MaintenanceRequest
+		maintenanceResp pb.MaintenanceResponse
+	)
+
+	err := maintenanceReq.Unmarshal(frame.Payload)
+	switch {
+	case err != nil:
+		maintenanceResp.Code = CodeInvalidRequest
+		maintenanceResp.Msg = err.Error()
+	case !cmd.s.option.EnableMaintenanceCmd:
+		maintenanceResp.Code = CodeInvalidRequest
+		maintenanceResp.Msg = "maintenance command not enabled"
+	default:
+		cmd.s.SetMaintenanceMode(maintenanceReq.On)
+		maintenanceResp.Code = CodeOK
+	}
+
+	bytes, _ := maintenanceResp.Marshal()
+	err = writeRespBytes(writer, frame, MaintenanceRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"io"
+
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+var (
+	// errRestoreStreamClosed when client is gone before the last frame of a restore
+	errRestoreStreamClosed = errors.New("restore stream closed before the last frame")
+	// errRestoreAborted when client aborts a restore
+	errRestoreAborted = errors.New("restore aborted by client")
+)
+
+// CmdRestore for loading a backup streamed by client in RestoreRequest frames,
+// only available when Option.EnableBackupCmds is set and the server is in maintenance mode,
+// so that the restore doesn't run concurrently with other writes.
+// The response is sent after the last frame, frames after a failure are consumed without being loaded.
+type CmdRestore struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdRestore) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var restoreResp pb.RestoreResponse
+
+	switch {
+	case !cmd.s.option.EnableBackupCmds:
+		restoreResp.Code = CodeInvalidRequest
+		restoreResp.Msg = backupCmdsDisabled
+		waitRestoreClosedByPeer(frame)
+	case !cmd.s.inMaintenance():
+		restoreResp.Code = CodeNotInMaintenance
+		restoreResp.Msg = ErrNotInMaintenance.Error()
+		waitRestoreClosedByPeer(frame)
+	default:
+		pr, pw := io.Pipe()
+		fed := make(chan struct{})
+		go func() {
+			pw.CloseWithError(feedRestore(frame, pw))
+			close(fed)
+		}()
+
+		err := cmd.s.kvdb.Restore(pr)
+		// unblocks feedRestore if Restore returns before reading all
+		pr.CloseWithError(io.ErrClosedPipe)
+		<-fed
+		if err != nil {
+			restoreResp.Code = backupErrorCode(err)
+			restoreResp.Msg = err.Error()
+		}
+	}
+
+	bytes, _ := restoreResp.Marshal()
+	err := writeStreamRespBytes(writer, frame, RestoreRespCmd, bytes, true)
+	if err != nil {
+		logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+	}
+}
+
+// feedRestore writes data of frames into w until the last frame,
+// frames are still consumed after w fails so that the stream is fully closed.
+func feedRestore(frame *qrpc.RequestFrame, w io.Writer) (err error) {
+	var (
+		restoreReq pb.RestoreRequest
+		payload    = frame.Payload
+		done       = frame.Flags.IsDone()
+	)
+	for {
+		if err == nil {
+			err = restoreReq.Unmarshal(payload)
+			switch {
+			case err != nil:
+			case restoreReq.Abort:
+				err = errRestoreAborted
+			default:
+				_, err = w.Write(restoreReq.Data)
+			}
+		}
+		if done {
+			return
+		}
+
+		select {
+		case nextFrame := <-frame.FrameCh():
+			if nextFrame == nil {
+				if err == nil {
+					err = errRestoreStreamClosed
+				}
+				return
+			}
+			payload = nextFrame.Payload
+			done = nextFrame.Flags.IsDone()
+		case <-frame.Context().Done():
+			if err == nil {
+				err = errRestoreStreamClosed
+			}
+			return
+		}
+	}
+}
+
+// waitRestoreClosedByPeer consumes frames until the last one of a restore
+func waitRestoreClosedByPeer(frame *qrpc.RequestFrame) {
+	if frame.Flags.IsDone() {
+		return
+	}
+	for {
+		select {
+		case nextFrame := <-frame.FrameCh():
+			if nextFrame == nil || nextFrame.Flags.IsDone() {
+				return
+			}
+		case <-frame.Context().Done():
+			return
+		}
+	}
+}
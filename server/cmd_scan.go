@@ -1,6 +1,8 @@
 package server
 
 import (
+	"time"
+
 	"github.com/zhiqiangxu/mondis/pb"
 	"github.com/zhiqiangxu/qrpc"
 	"github.com/zhiqiangxu/util/logger"
@@ -35,7 +37,10 @@ func (cmd *CmdScan) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame)
 	switch frame.Flags.IsDone() {
 	case true:
 
+		start := time.Now()
 		handleScan(cmd.s.kvdb, &scanReq, &scanResp)
+		cmd.s.observeScan(SlowKindScan, frame, nil, &scanReq, start, len(scanResp.Entries))
+		cmd.s.observeCmd(ScanCmd, start, scanResp.Code)
 
 		bytes, _ := scanResp.Marshal()
 		err = writeRespBytes(writer, frame, ScanRespCmd, bytes)
@@ -43,10 +48,39 @@ func (cmd *CmdScan) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame)
 			logger.Instance().Error("writeRespBytes", zap.Error(err))
 		}
 	case false:
-		txn := cmd.s.kvdb.NewTransaction(frame.Cmd.Opaque() == 1)
+		update := isUpdateTxnFrame(frame)
+		if update && cmd.s.inMaintenance() {
+			scanResp.Code = CodeMaintenance
+			scanResp.Msg = ErrMaintenance.Error()
+			bytes, _ := scanResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, ScanRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeMaintenance, ErrMaintenance.Error())
+			return
+		}
+		txn, ot, err := cmd.s.beginTxn(frame, update)
+		if err != nil {
+			scanResp.Code = CodeDraining
+			scanResp.Msg = err.Error()
+			bytes, _ := scanResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, ScanRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeDraining, ErrDraining.Error())
+			return
+		}
 		defer txn.Discard()
+		defer cmd.s.txns.remove(ot)
 
+		start := time.Now()
 		handleScan(txn, &scanReq, &scanResp)
+		cmd.s.observeScan(SlowKindTxnScan, frame, ot, &scanReq, start, len(scanResp.Entries))
+		cmd.s.observeCmd(ScanCmd, start, scanResp.Code)
 		{
 			bytes, _ := scanResp.Marshal()
 			err = writeStreamRespBytes(writer, frame, ScanRespCmd, bytes, false)
@@ -56,7 +90,7 @@ func (cmd *CmdScan) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame)
 			}
 		}
 
-		handleTxnContinuedFrame(writer, frame, txn)
+		handleTxnContinuedFrame(cmd.s, writer, frame, txn, ot)
 
 	}
 }
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// ScanStreamBatchSize is the max number of entries in a single ScanStreamRespCmd frame
+const ScanStreamBatchSize = 256
+
+// CmdScanStream for streaming scan, entries are sent in batches of ScanStreamBatchSize,
+// the last frame ends the stream and carries the final code.
+// Limit <= 0 means no limit, and MaxEntry doesn't apply.
+// Client should close its side of the stream by an end frame, e.g. DiscardCmd,
+// which stops the scan early if sent before the last frame.
+type CmdScanStream struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdScanStream) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		scanReq  pb.ScanRequest
+		scanResp pb.ScanResponse
+		writeErr error
+	)
+
+	defer waitStreamClosedByPeer(frame)
+
+	flush := func(end bool) error {
+		bytes, _ := scanResp.Marshal()
+		scanResp.Entries = scanResp.Entries[:0]
+		return writeStreamRespBytes(writer, frame, ScanStreamRespCmd, bytes, end)
+	}
+
+	err := scanReq.Unmarshal(frame.Payload)
+	if err != nil {
+		scanResp.Code = CodeInvalidRequest
+		scanResp.Msg = err.Error()
+		err = flush(true)
+		if err != nil {
+			logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+		}
+		return
+	}
+
+	var option mondis.ProviderScanOption
+	if pso := scanReq.ProviderScanOption; pso != nil {
+		option = mondis.ProviderScanOption{Reverse: pso.Reverse, Prefix: pso.Prefix, Offset: pso.Offset, Stop: pso.Stop, KeysOnly: pso.KeysOnly, KeyPattern: pso.KeyPattern}
+	}
+	limit := int(scanReq.Limit)
+	n := 0
+	start := time.Now()
+	defer func() {
+		cmd.s.observeScan(SlowKindScanStream, frame, nil, &scanReq, start, n)
+	}()
+	err = cmd.s.kvdb.Scan(option, func(key, value []byte, meta mondis.VMetaResp) bool {
+		pbMeta := &pb.VMetaResp{ExpiresAt: meta.ExpiresAt, Tag: uint32(meta.Tag)}
+		var valueCopy []byte
+		if !option.KeysOnly {
+			valueCopy = copyBytes(value)
+		}
+		scanResp.Entries = append(scanResp.Entries, &pb.Entry{Key: copyBytes(key), Value: valueCopy, Meta: pbMeta})
+		n++
+		if limit > 0 && n >= limit {
+			return false
+		}
+		if scanStreamStopped(frame) {
+			return false
+		}
+		if len(scanResp.Entries) < ScanStreamBatchSize {
+			return true
+		}
+
+		// EndWrite blocks until the frame is scheduled, which throttles the iteration
+		writeErr = flush(false)
+		return writeErr == nil
+	})
+	if writeErr != nil {
+		logger.Instance().Error("writeStreamRespBytes", zap.Error(writeErr))
+		return
+	}
+
+	if err != nil {
+		scanResp.Code = scanErrorCode(err)
+		scanResp.Msg = err.Error()
+	}
+	err = flush(true)
+	if err != nil {
+		logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+	}
+}
+
+// scanStreamStopped checks whether client has gone or closed its side
+func scanStreamStopped(frame *qrpc.RequestFrame) bool {
+	select {
+	case <-frame.Context().Done():
+		return true
+	case <-frame.FrameCh():
+		return true
+	default:
+		return false
+	}
+}
+
+// waitStreamClosedByPeer waits until client closes its side, so that the stream is fully closed
+func waitStreamClosedByPeer(frame *qrpc.RequestFrame) {
+	for {
+		select {
+		case nextFrame := <-frame.FrameCh():
+			if nextFrame == nil {
+				return
+			}
+		case <-frame.Context().Done():
+			return
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"time"
+
 	"github.com/zhiqiangxu/mondis/pb"
 	"github.com/zhiqiangxu/qrpc"
 	"github.com/zhiqiangxu/util/logger"
@@ -35,7 +37,14 @@ func (cmd *CmdSet) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame)
 	switch frame.Flags.IsDone() {
 	case true:
 
-		handleSet(cmd.s.kvdb, &setReq, &setResp)
+		start := time.Now()
+		if cmd.s.inMaintenance() {
+			setResp.Code = CodeMaintenance
+			setResp.Msg = ErrMaintenance.Error()
+		} else {
+			handleSet(cmd.s, &setReq, &setResp)
+		}
+		cmd.s.observeCmd(SetCmd, start, setResp.Code)
 
 		bytes, _ := setResp.Marshal()
 		err = writeRespBytes(writer, frame, SetRespCmd, bytes)
@@ -43,10 +52,37 @@ func (cmd *CmdSet) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame)
 			logger.Instance().Error("writeRespBytes", zap.Error(err))
 		}
 	case false:
-		txn := cmd.s.kvdb.NewTransaction(true)
+		if cmd.s.inMaintenance() {
+			setResp.Code = CodeMaintenance
+			setResp.Msg = ErrMaintenance.Error()
+			bytes, _ := setResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, SetRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeMaintenance, ErrMaintenance.Error())
+			return
+		}
+		txn, ot, err := cmd.s.beginTxn(frame, true)
+		if err != nil {
+			setResp.Code = CodeDraining
+			setResp.Msg = err.Error()
+			bytes, _ := setResp.Marshal()
+			err = writeStreamRespBytes(writer, frame, SetRespCmd, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			handleRejectedTxnFrames(writer, frame, CodeDraining, ErrDraining.Error())
+			return
+		}
 		defer txn.Discard()
+		defer cmd.s.txns.remove(ot)
 
-		handleTxnSet(txn, &setReq, &setResp)
+		start := time.Now()
+		handleTxnSet(cmd.s, txn, &setReq, &setResp)
+		cmd.s.observeCmd(SetCmd, start, setResp.Code)
 		{
 			bytes, _ := setResp.Marshal()
 			err = writeStreamRespBytes(writer, frame, SetRespCmd, bytes, false)
@@ -56,7 +92,7 @@ func (cmd *CmdSet) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame)
 			}
 		}
 
-		handleTxnContinuedFrame(writer, frame, txn)
+		handleTxnContinuedFrame(cmd.s, writer, frame, txn, ot)
 
 	}
 }
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// CmdSnapshot for creating a snapshot, the id in response is valid on all connections,
+// until it's released by SnapshotReleaseCmd or not read for Option.SnapshotTTL.
+type CmdSnapshot struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdSnapshot) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var snapshotResp pb.SnapshotResponse
+
+	snapshot, err := cmd.s.kvdb.NewSnapshot()
+	if err == nil {
+		snapshotResp.SnapshotId, err = cmd.s.snapshots.add(snapshot, cmd.s.snapshotTTL())
+		if err != nil {
+			snapshot.Release()
+		}
+	}
+	switch err {
+	case nil:
+		snapshotResp.Code = CodeOK
+	case ErrDraining:
+		snapshotResp.Code = CodeDraining
+		snapshotResp.Msg = err.Error()
+	default:
+		snapshotResp.Code = CodeInternalError
+		snapshotResp.Msg = err.Error()
+	}
+
+	bytes, _ := snapshotResp.Marshal()
+	err = writeRespBytes(writer, frame, SnapshotRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
+
+// CmdSnapshotGet for get from a snapshot
+type CmdSnapshotGet struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdSnapshotGet) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		snapshotGetReq pb.SnapshotGetRequest
+		getResp        pb.GetResponse
+	)
+
+	err := snapshotGetReq.Unmarshal(frame.Payload)
+	if err != nil {
+		getResp.Code = CodeInvalidRequest
+		getResp.Msg = err.Error()
+	} else {
+		err = cmd.s.snapshots.use(snapshotGetReq.SnapshotId, cmd.s.snapshotTTL(), func(snapshot mondis.Snapshot) {
+			handleGet(snapshot, &pb.GetRequest{Key: snapshotGetReq.Key}, &getResp)
+		})
+		if err != nil {
+			getResp.Code = CodeSnapshotNotFound
+			getResp.Msg = err.Error()
+		}
+	}
+
+	bytes, _ := getResp.Marshal()
+	err = writeRespBytes(writer, frame, SnapshotGetRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
+
+// CmdSnapshotScan for scan from a snapshot
+type CmdSnapshotScan struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdSnapshotScan) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		snapshotScanReq pb.SnapshotScanRequest
+		scanResp        pb.ScanResponse
+	)
+
+	err := snapshotScanReq.Unmarshal(frame.Payload)
+	switch {
+	case err != nil:
+		scanResp.Code = CodeInvalidRequest
+		scanResp.Msg = err.Error()
+	case snapshotScanReq.Scan == nil || snapshotScanReq.Scan.ProviderScanOption == nil:
+		scanResp.Code = CodeInvalidRequest
+		scanResp.Msg = "scan option missing"
+	default:
+		err = cmd.s.snapshots.use(snapshotScanReq.SnapshotId, cmd.s.snapshotTTL(), func(snapshot mondis.Snapshot) {
+			start := time.Now()
+			handleScan(snapshot, snapshotScanReq.Scan, &scanResp)
+			cmd.s.observeScan(SlowKindSnapshotScan, frame, nil, snapshotScanReq.Scan, start, len(scanResp.Entries))
+		})
+		if err != nil {
+			scanResp.Code = CodeSnapshotNotFound
+			scanResp.Msg = err.Error()
+		}
+	}
+
+	bytes, _ := scanResp.Marshal()
+	err = writeRespBytes(writer, frame, SnapshotScanRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
+
+// CmdSnapshotRelease for releasing a snapshot, CodeSnapshotNotFound if it's released or expired already
+type CmdSnapshotRelease struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdSnapshotRelease) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		releaseReq  pb.SnapshotReleaseRequest
+		releaseResp pb.SnapshotReleaseResponse
+	)
+
+	err := releaseReq.Unmarshal(frame.Payload)
+	if err == nil {
+		err = cmd.s.snapshots.remove(releaseReq.SnapshotId)
+		if err != nil {
+			releaseResp.Code = CodeSnapshotNotFound
+			releaseResp.Msg = err.Error()
+		}
+	} else {
+		releaseResp.Code = CodeInvalidRequest
+		releaseResp.Msg = err.Error()
+	}
+
+	bytes, _ := releaseResp.Marshal()
+	err = writeRespBytes(writer, frame, SnapshotReleaseRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
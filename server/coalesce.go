@@ -0,0 +1,98 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/zhiqiangxu/mondis"
+)
+
+// readCoalescer shares one provider read among concurrent one-shot Get/Exists of the same key,
+// waiters arriving during the read get its result, which may be slightly stale for them.
+type readCoalescer struct {
+	mondis.KVDB
+	mu        sync.Mutex
+	gets      map[string]*getCall
+	exists    map[string]*existsCall
+	coalesced uint64
+}
+
+type getCall struct {
+	wg   sync.WaitGroup
+	v    []byte
+	meta mondis.VMetaResp
+	err  error
+}
+
+type existsCall struct {
+	wg     sync.WaitGroup
+	exists bool
+	err    error
+}
+
+func newReadCoalescer(kvdb mondis.KVDB) *readCoalescer {
+	return &readCoalescer{KVDB: kvdb, gets: make(map[string]*getCall), exists: make(map[string]*existsCall)}
+}
+
+// Get for implement mondis.ProviderKVOP
+func (rc *readCoalescer) Get(k []byte) (v []byte, meta mondis.VMetaResp, err error) {
+	key := string(k)
+
+	rc.mu.Lock()
+	if c, ok := rc.gets[key]; ok {
+		rc.mu.Unlock()
+		atomic.AddUint64(&rc.coalesced, 1)
+		c.wg.Wait()
+		if c.v != nil {
+			v = copyBytes(c.v)
+		}
+		meta, err = c.meta, c.err
+		return
+	}
+	c := &getCall{}
+	c.wg.Add(1)
+	rc.gets[key] = c
+	rc.mu.Unlock()
+
+	c.v, c.meta, c.err = rc.KVDB.Get(k)
+
+	rc.mu.Lock()
+	delete(rc.gets, key)
+	rc.mu.Unlock()
+	c.wg.Done()
+
+	v, meta, err = c.v, c.meta, c.err
+	return
+}
+
+// Exists for implement mondis.ProviderKVOP
+func (rc *readCoalescer) Exists(k []byte) (exists bool, err error) {
+	key := string(k)
+
+	rc.mu.Lock()
+	if c, ok := rc.exists[key]; ok {
+		rc.mu.Unlock()
+		atomic.AddUint64(&rc.coalesced, 1)
+		c.wg.Wait()
+		exists, err = c.exists, c.err
+		return
+	}
+	c := &existsCall{}
+	c.wg.Add(1)
+	rc.exists[key] = c
+	rc.mu.Unlock()
+
+	c.exists, c.err = rc.KVDB.Exists(k)
+
+	rc.mu.Lock()
+	delete(rc.exists, key)
+	rc.mu.Unlock()
+	c.wg.Done()
+
+	exists, err = c.exists, c.err
+	return
+}
+
+func (rc *readCoalescer) coalescedCount() uint64 {
+	return atomic.LoadUint64(&rc.coalesced)
+}
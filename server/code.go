@@ -11,4 +11,42 @@ const (
 	CodeTxnTooBig
 	// CodeKeyNotFound for key not found
 	CodeKeyNotFound
+	// CodeTxnConflict for transaction conflict
+	CodeTxnConflict
+	// CodeMaintenance for writes rejected in maintenance mode
+	CodeMaintenance
+	// CodeFsckRunning when another fsck is running
+	CodeFsckRunning
+	// CodeNoFsckRunning when cancelling while no fsck is running
+	CodeNoFsckRunning
+	// CodeOverflow for integer overflow
+	CodeOverflow
+	// CodeDraining for transactions rejected while server is stopping
+	CodeDraining
+	// CodeDBNotExists when db of Doc* commands doesn't exist
+	CodeDBNotExists
+	// CodeCollectionNotExists when collection of Doc* commands doesn't exist
+	CodeCollectionNotExists
+	// CodeDocNotFound when document of Doc* commands doesn't exist
+	CodeDocNotFound
+	// CodeUniqueViolated when a write of Doc* commands violates a unique index
+	CodeUniqueViolated
+	// CodeTxnTimedOut for transactions discarded for being idle too long
+	CodeTxnTimedOut
+	// CodeBackupUnavailable when backup and restore are not supported by the provider
+	CodeBackupUnavailable
+	// CodeNotInMaintenance for restores rejected outside maintenance mode
+	CodeNotInMaintenance
+	// CodeSnapshotNotFound when the snapshot of Snapshot* commands is released or expired
+	CodeSnapshotNotFound
+	// CodePreCommitRejected for writes rejected by Option.PreCommitHook
+	CodePreCommitRejected
+	// CodeValidationFailed for writes rejected by Option.KeyValidator or Option.ValueValidator
+	CodeValidationFailed
+	// CodeWatcherTooSlow for watchers dropped for having Option.WatchMaxUnacked events unacked
+	CodeWatcherTooSlow
+	// CodeAuthFailed when the token of AuthCmd is rejected by Option.AuthProvider
+	CodeAuthFailed
+	// CodePermissionDenied for requests on connections not authenticated, or not granted the access needed
+	CodePermissionDenied
 )
@@ -12,9 +12,11 @@ import (
 )
 
 func handleTxnContinuedFrame(
+	s *Server,
 	writer qrpc.FrameWriter,
 	frame *qrpc.RequestFrame,
-	txn mondis.ProviderTxn) {
+	txn mondis.ProviderTxn,
+	ot *openTxn) {
 	var (
 		getReq     pb.GetRequest
 		getResp    pb.GetResponse
@@ -26,12 +28,53 @@ func handleTxnContinuedFrame(
 		existsResp pb.ExistsResponse
 		scanReq    pb.ScanRequest
 		scanResp   pb.ScanResponse
+		incReq     pb.IncRequest
+		incResp    pb.IncResponse
+		casReq     pb.CASRequest
+		casResp    pb.CASResponse
 		commitResp pb.CommitResponse
 		err        error
 		close      bool
 	)
+	// the first operation is done by caller
+	ot.incOps()
+
+	var ta *txnAccess
+	if s.accessLogging() {
+		ta = newTxnAccess(frame)
+		defer s.logTxnAccess(ot, ta)
+	}
+
+	var (
+		timer     *time.Timer
+		timeoutCh <-chan time.Time
+	)
+	idleTimeout := s.txnIdleTimeout()
+	if idleTimeout > 0 {
+		timer = time.NewTimer(idleTimeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	resetTimer := func() {
+		if timer != nil {
+			// idle time is counted from the last response
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(idleTimeout)
+		}
+	}
 	for {
-		nextFrame := <-frame.FrameCh()
+		var nextFrame *qrpc.Frame
+		select {
+		case nextFrame = <-frame.FrameCh():
+		case <-timeoutCh:
+			handleTxnTimedOut(s, writer, frame, txn, ot)
+			return
+		}
 		if nextFrame == nil {
 			txn.Discard()
 			err = writeStreamRespBytes(writer, frame, DiscardRespCmd, nil, true)
@@ -40,16 +83,39 @@ func handleTxnContinuedFrame(
 			}
 			return
 		}
+		ta.frame()
+		if nextFrame.Cmd == CommitCmd || nextFrame.Cmd == DiscardCmd {
+			// removed before responding so that it's not listed once the client sees the response
+			s.txns.remove(ot)
+		} else {
+			ot.incOps()
+		}
+		if !s.checkAccess(frame, nextFrame.Cmd, nextFrame.Payload) {
+			// the transaction goes on without the operation
+			bytes, _ := (&pb.CommitResponse{Code: CodePermissionDenied, Msg: ErrPermissionDenied.Error()}).Marshal()
+			err = writeStreamRespBytes(writer, frame, nextFrame.Cmd.Routing()+1, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+			resetTimer()
+			continue
+		}
 		switch nextFrame.Cmd {
 		case SetCmd:
 			close = false
-			err = setResp.Unmarshal(nextFrame.Payload)
+			// Unmarshal reuses the buffers of the previous request, which are still referenced by txn until commit
+			setReq = pb.SetRequest{}
+			err = setReq.Unmarshal(nextFrame.Payload)
 			if err != nil {
 				close = true
 				setResp.Code = CodeInvalidRequest
 				setResp.Msg = err.Error()
 			} else {
-				handleTxnSet(txn, &setReq, &setResp)
+				ta.touch(setReq.Key)
+				start := time.Now()
+				handleTxnSet(s, txn, &setReq, &setResp)
+				s.observeCmd(SetCmd, start, setResp.Code)
 			}
 
 			{
@@ -72,6 +138,7 @@ func handleTxnContinuedFrame(
 				existsResp.Code = CodeInvalidRequest
 				existsResp.Msg = err.Error()
 			} else {
+				ta.touch(existsReq.Key)
 				handleExists(txn, &existsReq, &existsResp)
 			}
 
@@ -95,7 +162,10 @@ func handleTxnContinuedFrame(
 				getResp.Code = CodeInvalidRequest
 				getResp.Msg = err.Error()
 			} else {
+				ta.touch(getReq.Key)
+				start := time.Now()
 				handleGet(txn, &getReq, &getResp)
+				s.observeCmd(GetCmd, start, getResp.Code)
 			}
 
 			{
@@ -112,13 +182,17 @@ func handleTxnContinuedFrame(
 			}
 		case DeleteCmd:
 			close = false
+			deleteReq = pb.DeleteRequest{}
 			err = deleteReq.Unmarshal(nextFrame.Payload)
 			if err != nil {
 				close = true
 				deleteResp.Code = CodeInvalidRequest
 				deleteResp.Msg = err.Error()
 			} else {
+				ta.touch(deleteReq.Key)
+				start := time.Now()
 				handleTxnDelete(txn, &deleteReq, &deleteResp)
+				s.observeCmd(DeleteCmd, start, deleteResp.Code)
 			}
 
 			{
@@ -135,13 +209,18 @@ func handleTxnContinuedFrame(
 			}
 		case ScanCmd:
 			close = false
+			scanReq = pb.ScanRequest{}
+			scanResp = pb.ScanResponse{}
 			err = scanReq.Unmarshal(nextFrame.Payload)
 			if err != nil {
 				close = true
 				scanResp.Code = CodeInvalidRequest
 				scanResp.Msg = err.Error()
 			} else {
+				start := time.Now()
 				handleScan(txn, &scanReq, &scanResp)
+				s.observeScan(SlowKindTxnScan, frame, ot, &scanReq, start, len(scanResp.Entries))
+				s.observeCmd(ScanCmd, start, scanResp.Code)
 			}
 
 			{
@@ -156,8 +235,68 @@ func handleTxnContinuedFrame(
 				frame.Close()
 				return
 			}
+		case IncCmd:
+			close = false
+			incReq = pb.IncRequest{}
+			err = incReq.Unmarshal(nextFrame.Payload)
+			if err != nil {
+				close = true
+				incResp.Code = CodeInvalidRequest
+				incResp.Msg = err.Error()
+			} else {
+				ta.touch(incReq.Key)
+				handleTxnInc(s, txn, &incReq, &incResp)
+			}
+
+			{
+				bytes, _ := incResp.Marshal()
+				err = writeStreamRespBytes(writer, frame, IncRespCmd, bytes, false)
+				if err != nil {
+					logger.Instance().Error("IncCmd writeStreamRespBytes", zap.Error(err))
+					return
+				}
+			}
+			if close {
+				frame.Close()
+				return
+			}
+		case CASCmd:
+			close = false
+			// NewValue is still referenced by txn until commit
+			casReq = pb.CASRequest{}
+			err = casReq.Unmarshal(nextFrame.Payload)
+			if err != nil {
+				close = true
+				casResp.Code = CodeInvalidRequest
+				casResp.Msg = err.Error()
+			} else {
+				ta.touch(casReq.Key)
+				handleTxnCAS(s, txn, &casReq, &casResp)
+			}
+
+			{
+				bytes, _ := casResp.Marshal()
+				err = writeStreamRespBytes(writer, frame, CASRespCmd, bytes, false)
+				if err != nil {
+					logger.Instance().Error("CASCmd writeStreamRespBytes", zap.Error(err))
+					return
+				}
+			}
+			if close {
+				frame.Close()
+				return
+			}
 		case CommitCmd:
-			handleTxnCommit(txn, &commitResp)
+			start := time.Now()
+			if s.option.RejectCommitInMaintenance && s.inMaintenance() {
+				txn.Discard()
+				commitResp.Code = CodeMaintenance
+				commitResp.Msg = ErrMaintenance.Error()
+			} else {
+				handleTxnCommit(txn, &commitResp)
+			}
+			s.observeCmd(CommitCmd, start, commitResp.Code)
+			ta.commit(commitResp.Code)
 			{
 				bytes, _ := commitResp.Marshal()
 				err = writeStreamRespBytes(writer, frame, CommitRespCmd, bytes, true)
@@ -174,32 +313,64 @@ func handleTxnContinuedFrame(
 			}
 			return
 		}
+
+		resetTimer()
+	}
+}
+
+// handleTxnTimedOut discards txn and ends the stream with TxnTimedOutRespCmd,
+// which the client takes as the response of its next operation.
+// Frames from client are then consumed without answering until the client closes the stream.
+func handleTxnTimedOut(
+	s *Server,
+	writer qrpc.FrameWriter,
+	frame *qrpc.RequestFrame,
+	txn mondis.ProviderTxn,
+	ot *openTxn) {
+
+	s.txns.remove(ot)
+	txn.Discard()
+
+	bytes, _ := (&pb.CommitResponse{Code: CodeTxnTimedOut, Msg: ErrTxnTimedOut.Error()}).Marshal()
+	err := writeStreamRespBytes(writer, frame, TxnTimedOutRespCmd, bytes, true)
+	if err != nil {
+		logger.Instance().Error("TxnTimedOutRespCmd writeStreamRespBytes", zap.Error(err))
+	}
+
+	for range frame.FrameCh() {
 	}
 }
 
-func handleTxnSet(txn mondis.ProviderTxn, req *pb.SetRequest, resp *pb.SetResponse) {
+func handleTxnSet(s *Server, txn mondis.ProviderTxn, req *pb.SetRequest, resp *pb.SetResponse) {
+	if msg, ok := s.validateSet(req.Key, req.Value); !ok {
+		resp.Code = CodeValidationFailed
+		resp.Msg = msg
+		return
+	}
 	meta := metaFromSetRequest(req)
 	err := txn.Set(req.Key, req.Value, meta)
 	if err != nil {
-
-		if err == kv.ErrTxnTooBig {
-			resp.Code = CodeTxnTooBig
-			resp.Msg = err.Error()
-		} else {
-			resp.Code = CodeInternalError
-			resp.Msg = err.Error()
-		}
+		resp.Code, resp.Msg = txnErrorCode(err)
 		return
-
 	}
 
 	resp.Code = CodeOK
 	resp.Msg = ""
 }
 
-func handleSet(kvdb mondis.KVDB, req *pb.SetRequest, resp *pb.SetResponse) {
+func handleSet(s *Server, req *pb.SetRequest, resp *pb.SetResponse) {
+	if msg, ok := s.validateSet(req.Key, req.Value); !ok {
+		resp.Code = CodeValidationFailed
+		resp.Msg = msg
+		return
+	}
 	meta := metaFromSetRequest(req)
-	err := kvdb.Set(req.Key, req.Value, meta)
+	if rejected := s.runPreCommitHook([]MutationView{{op: MutationSet, key: req.Key, value: req.Value, meta: meta}}); rejected != nil {
+		resp.Code = CodePreCommitRejected
+		resp.Msg = rejected.Msg
+		return
+	}
+	err := s.kvdb.Set(req.Key, req.Value, meta)
 	if err != nil {
 
 		resp.Code = CodeInternalError
@@ -207,12 +378,13 @@ func handleSet(kvdb mondis.KVDB, req *pb.SetRequest, resp *pb.SetResponse) {
 
 		return
 	}
+	s.publish([]MutationView{{op: MutationSet, key: req.Key, value: req.Value, meta: meta}})
 
 	resp.Code = CodeOK
 	resp.Msg = ""
 }
 
-func handleExists(kvop mondis.ProviderKVOP, req *pb.ExistsRequest, resp *pb.ExistsResponse) {
+func handleExists(kvop mondis.ProviderReadOP, req *pb.ExistsRequest, resp *pb.ExistsResponse) {
 	exists, err := kvop.Exists(req.Key)
 	if err != nil {
 
@@ -227,17 +399,10 @@ func handleExists(kvop mondis.ProviderKVOP, req *pb.ExistsRequest, resp *pb.Exis
 	resp.Exists = exists
 }
 
-func handleGet(kvop mondis.ProviderKVOP, req *pb.GetRequest, resp *pb.GetResponse) {
+func handleGet(kvop mondis.ProviderReadOP, req *pb.GetRequest, resp *pb.GetResponse) {
 	value, meta, err := kvop.Get(req.Key)
 	if err != nil {
-		if err == kv.ErrKeyNotFound {
-			resp.Code = CodeKeyNotFound
-			resp.Msg = err.Error()
-		} else {
-			resp.Code = CodeInternalError
-			resp.Msg = err.Error()
-		}
-
+		setGetError(resp, err)
 		return
 	}
 
@@ -247,13 +412,29 @@ func handleGet(kvop mondis.ProviderKVOP, req *pb.GetRequest, resp *pb.GetRespons
 	resp.Meta = &pb.VMetaResp{ExpiresAt: meta.ExpiresAt, Tag: uint32(meta.Tag)}
 }
 
-func handleDelete(kvdb mondis.KVDB, req *pb.DeleteRequest, resp *pb.DeleteResponse) {
-	err := kvdb.Delete(req.Key)
+func setGetError(resp *pb.GetResponse, err error) {
+	if err == kv.ErrKeyNotFound {
+		resp.Code = CodeKeyNotFound
+		resp.Msg = err.Error()
+	} else {
+		resp.Code = CodeInternalError
+		resp.Msg = err.Error()
+	}
+}
+
+func handleDelete(s *Server, req *pb.DeleteRequest, resp *pb.DeleteResponse) {
+	if rejected := s.runPreCommitHook([]MutationView{{op: MutationDelete, key: req.Key}}); rejected != nil {
+		resp.Code = CodePreCommitRejected
+		resp.Msg = rejected.Msg
+		return
+	}
+	err := s.kvdb.Delete(req.Key)
 	if err != nil {
 		resp.Code = CodeInternalError
 		resp.Msg = err.Error()
 		return
 	}
+	s.publish([]MutationView{{op: MutationDelete, key: req.Key}})
 
 	resp.Code = CodeOK
 	resp.Msg = ""
@@ -262,13 +443,7 @@ func handleDelete(kvdb mondis.KVDB, req *pb.DeleteRequest, resp *pb.DeleteRespon
 func handleTxnDelete(txn mondis.ProviderTxn, req *pb.DeleteRequest, resp *pb.DeleteResponse) {
 	err := txn.Delete(req.Key)
 	if err != nil {
-		if err == kv.ErrTxnTooBig {
-			resp.Code = CodeTxnTooBig
-			resp.Msg = err.Error()
-		} else {
-			resp.Code = CodeInternalError
-			resp.Msg = err.Error()
-		}
+		resp.Code, resp.Msg = txnErrorCode(err)
 		return
 	}
 
@@ -282,9 +457,24 @@ func copyBytes(in []byte) (out []byte) {
 	return
 }
 
-func handleScan(kvop mondis.ProviderKVOP, req *pb.ScanRequest, resp *pb.ScanResponse) {
+// scanErrorCode returns CodeInvalidRequest for bad key pattern, CodeInternalError otherwise
+func scanErrorCode(err error) int32 {
+	switch err {
+	case kv.ErrInvalidKeyPattern, kv.ErrKeyPatternTooExpensive:
+		return CodeInvalidRequest
+	default:
+		return CodeInternalError
+	}
+}
+
+func handleScan(kvop mondis.ProviderReadOP, req *pb.ScanRequest, resp *pb.ScanResponse) {
 	pso := req.ProviderScanOption
-	option := mondis.ProviderScanOption{Reverse: pso.Reverse, Prefix: pso.Prefix, Offset: pso.Offset}
+	if pso == nil {
+		resp.Code = CodeInvalidRequest
+		resp.Msg = "scan option missing"
+		return
+	}
+	option := mondis.ProviderScanOption{Reverse: pso.Reverse, Prefix: pso.Prefix, Offset: pso.Offset, Stop: pso.Stop, KeysOnly: pso.KeysOnly, KeyPattern: pso.KeyPattern}
 	limit := int(req.Limit)
 	if limit == 0 {
 		goto DONE
@@ -296,7 +486,10 @@ func handleScan(kvop mondis.ProviderKVOP, req *pb.ScanRequest, resp *pb.ScanResp
 	{
 		err := kvop.Scan(option, func(key, value []byte, meta mondis.VMetaResp) bool {
 			keyCopy := copyBytes(key)
-			valueCopy := copyBytes(value)
+			var valueCopy []byte
+			if !option.KeysOnly {
+				valueCopy = copyBytes(value)
+			}
 			pbMeta := &pb.VMetaResp{ExpiresAt: meta.ExpiresAt, Tag: uint32(meta.Tag)}
 			resp.Entries = append(resp.Entries, &pb.Entry{Key: keyCopy, Value: valueCopy, Meta: pbMeta})
 
@@ -307,7 +500,7 @@ func handleScan(kvop mondis.ProviderKVOP, req *pb.ScanRequest, resp *pb.ScanResp
 		})
 
 		if err != nil {
-			resp.Code = CodeInternalError
+			resp.Code = scanErrorCode(err)
 			resp.Msg = err.Error()
 			return
 		}
@@ -318,11 +511,34 @@ DONE:
 	resp.Msg = ""
 }
 
+func handleTxnInc(s *Server, txn mondis.ProviderTxn, req *pb.IncRequest, resp *pb.IncResponse) {
+	n, err := kv.IncBinaryInt64(s.validating(txn), req.Key, req.Delta)
+	if err != nil {
+		if msg, ok := validationFailed(err); ok {
+			resp.Code = CodeValidationFailed
+			resp.Msg = msg
+		} else if err == kv.ErrOverflow {
+			resp.Code = CodeOverflow
+			resp.Msg = err.Error()
+		} else {
+			resp.Code, resp.Msg = txnErrorCode(err)
+		}
+		return
+	}
+
+	resp.Code = CodeOK
+	resp.Msg = ""
+	resp.Value = n
+}
+
 func handleTxnCommit(txn mondis.ProviderTxn, resp *pb.CommitResponse) {
 	err := txn.Commit()
+	if split, ok := txn.(*splitTxn); ok {
+		// chunks committed before are durable even if the last one fails
+		resp.Splits = int32(split.splits)
+	}
 	if err != nil {
-		resp.Code = CodeInternalError
-		resp.Msg = err.Error()
+		resp.Code, resp.Msg = txnErrorCode(err)
 		return
 	}
 
@@ -330,6 +546,25 @@ func handleTxnCommit(txn mondis.ProviderTxn, resp *pb.CommitResponse) {
 	resp.Msg = ""
 }
 
+// txnErrorCode maps an error of a transaction operation to code and msg,
+// mutations of a split transaction can fail like commits.
+func txnErrorCode(err error) (code int32, msg string) {
+	if msg, ok := preCommitRejected(err); ok {
+		return CodePreCommitRejected, msg
+	}
+
+	switch err {
+	case kv.ErrTxnTooBig:
+		code = CodeTxnTooBig
+	case kv.ErrTxnConflict:
+		code = CodeTxnConflict
+	default:
+		code = CodeInternalError
+	}
+	msg = err.Error()
+	return
+}
+
 func metaFromSetRequest(req *pb.SetRequest) *mondis.VMetaReq {
 	if req.Meta == nil {
 		return nil
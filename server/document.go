@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/zhiqiangxu/mondis/document/dml"
+	"github.com/zhiqiangxu/mondis/document/txn"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/qrpc"
+)
+
+// ErrDocumentCmdsDisabled when Doc* commands are received without Option.EnableDocumentCmds
+var ErrDocumentCmdsDisabled = errors.New("document cmds disabled")
+
+type docCollectionKey struct {
+	db, collection string
+}
+
+// docCollections caches resolved collections of a connection
+type docCollections struct {
+	mu sync.Mutex
+	m  map[docCollectionKey]*dml.Collection
+}
+
+// docCollection resolves collection in db, the result is cached on the connection of frame
+func (s *Server) docCollection(frame *qrpc.RequestFrame, db, collection string) (c *dml.Collection, err error) {
+	cache := &s.connState(frame).docs
+
+	key := docCollectionKey{db: db, collection: collection}
+	cache.mu.Lock()
+	c = cache.m[key]
+	cache.mu.Unlock()
+	if c != nil {
+		return
+	}
+
+	d, err := s.domain.DB(db)
+	if err != nil {
+		return
+	}
+	c, err = d.Collection(collection)
+	if err != nil {
+		return
+	}
+
+	cache.mu.Lock()
+	cache.m[key] = c
+	cache.mu.Unlock()
+	return
+}
+
+// evictDocCollection drops a cached collection that's found dropped
+func (s *Server) evictDocCollection(frame *qrpc.RequestFrame, db, collection string) {
+	cache := &s.connState(frame).docs
+	cache.mu.Lock()
+	delete(cache.m, docCollectionKey{db: db, collection: collection})
+	cache.mu.Unlock()
+}
+
+// docPrecheck returns the code and msg to reject a Doc* request with, CodeOK if it can go on
+func (s *Server) docPrecheck(write bool) (code int32, msg string) {
+	switch {
+	case s.domain == nil:
+		code = CodeInvalidRequest
+		msg = ErrDocumentCmdsDisabled.Error()
+	case write && s.inMaintenance():
+		code = CodeMaintenance
+		msg = ErrMaintenance.Error()
+	default:
+		code = CodeOK
+	}
+	return
+}
+
+// docErrorCode maps errors of the document layer to codes
+func docErrorCode(err error) int32 {
+	switch err {
+	case dml.ErrDBNotExists:
+		return CodeDBNotExists
+	case dml.ErrCollectionNotExists:
+		return CodeCollectionNotExists
+	case dml.ErrDocNotFound:
+		return CodeDocNotFound
+	case dml.ErrUniqueViolated:
+		return CodeUniqueViolated
+	case kv.ErrTxnConflict, txn.ErrDDLConflict:
+		return CodeTxnConflict
+	case kv.ErrTxnTooBig:
+		return CodeTxnTooBig
+	default:
+		return CodeInternalError
+	}
+}
+
+// docError returns the code and msg for err of a Doc* request,
+// the cached collection is evicted if it's dropped since resolved
+func (s *Server) docError(frame *qrpc.RequestFrame, db, collection string, err error) (code int32, msg string) {
+	if err == dml.ErrCollectionNotExists {
+		s.evictDocCollection(frame, db, collection)
+	}
+	code = docErrorCode(err)
+	msg = err.Error()
+	return
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zhiqiangxu/mondis/document"
+	"github.com/zhiqiangxu/mondis/pb"
+)
+
+var (
+	// ErrFsckRunning when another fsck is running
+	ErrFsckRunning = errors.New("another fsck is running")
+	// ErrNoFsckRunning when cancelling while no fsck is running
+	ErrNoFsckRunning = errors.New("no fsck is running")
+)
+
+// startFsck registers a new fsck, only one fsck may run at a time
+func (s *Server) startFsck() (ctx context.Context, done func(), err error) {
+	s.fsckMu.Lock()
+	defer s.fsckMu.Unlock()
+
+	if s.fsckCancel != nil {
+		err = ErrFsckRunning
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.fsckCancel = cancel
+	done = func() {
+		cancel()
+		s.fsckMu.Lock()
+		s.fsckCancel = nil
+		s.fsckMu.Unlock()
+	}
+	return
+}
+
+// CancelFsck cancels the running fsck
+func (s *Server) CancelFsck() (err error) {
+	s.fsckMu.Lock()
+	defer s.fsckMu.Unlock()
+
+	if s.fsckCancel == nil {
+		err = ErrNoFsckRunning
+		return
+	}
+	s.fsckCancel()
+	return
+}
+
+// checkReport2PB fills resp with report, leaving out the first sent issues which are sent already
+func checkReport2PB(report document.CheckReport, sent int, resp *pb.FsckResponse) {
+	resp.Checked = report.Checked
+	resp.Issues = make([]*pb.FsckIssue, 0, len(report.Issues)-sent)
+	for _, issue := range report.Issues[sent:] {
+		resp.Issues = append(resp.Issues, &pb.FsckIssue{Msg: issue.Msg, Repaired: issue.Repaired})
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+)
+
+// wire tags of pb.GetResponse fields, i.e., field number << 3 | wire type 2
+const (
+	getRespValueTag byte = 3<<3 | 2
+	getRespMetaTag  byte = 4<<3 | 2
+)
+
+const (
+	// getRespHeadSize is the max size of the tag and length prefix of a field
+	getRespHeadSize = 1 + binary.MaxVarintLen64
+	// vmetaRespMaxSize is the max size of a marshaled pb.VMetaResp, a uint64 and a uint32 field
+	vmetaRespMaxSize = 2 + binary.MaxVarintLen64 + binary.MaxVarintLen32
+)
+
+// appendGetRespValueHead appends what precedes the value in a pb.GetResponse of CodeOK,
+// it's empty for an empty value, which is omitted like the generated Marshal does.
+func appendGetRespValueHead(buf []byte, valueLen int) []byte {
+	if valueLen == 0 {
+		return buf
+	}
+	buf = append(buf, getRespValueTag)
+	return appendUvarint(buf, uint64(valueLen))
+}
+
+// appendGetRespMeta appends the meta field, which follows the value in a pb.GetResponse
+func appendGetRespMeta(buf []byte, meta mondis.VMetaResp) []byte {
+	var metaBytes [vmetaRespMaxSize]byte
+	n, _ := (&pb.VMetaResp{ExpiresAt: meta.ExpiresAt, Tag: uint32(meta.Tag)}).MarshalTo(metaBytes[:])
+	buf = append(buf, getRespMetaTag)
+	buf = appendUvarint(buf, uint64(n))
+	return append(buf, metaBytes[:n]...)
+}
+
+// protobuf varints are encoded the same as binary.PutUvarint
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+// getRespBufMaxCap is the max capacity of a payload buffer put back into getRespBufs,
+// so that large values don't pin memory
+const getRespBufMaxCap = 64 << 10
+
+// getRespBufs are payload buffers of writeGetResp
+var getRespBufs = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// writeGetResp is handleGet followed by writeRespBytes for a ValueGetter,
+// the value of a found key is copied into a pooled payload buffer right from the provider,
+// instead of being copied out of it and then into the marshaled response.
+// The frame is started only after GetValue succeeds, so that a failed read never leaves a partial frame.
+// The bytes written are the same as those marshaled by pb.GetResponse, whose code is returned.
+func writeGetResp(writer qrpc.FrameWriter, frame *qrpc.RequestFrame, vg mondis.ValueGetter, req *pb.GetRequest) (code int32, err error) {
+	bufp := getRespBufs.Get().(*[]byte)
+	payload := (*bufp)[:0]
+	defer func() {
+		if cap(payload) <= getRespBufMaxCap {
+			*bufp = payload
+			getRespBufs.Put(bufp)
+		}
+	}()
+
+	getErr := vg.GetValue(req.Key, func(value []byte, meta mondis.VMetaResp) error {
+		payload = appendGetRespValueHead(payload[:0], len(value))
+		payload = append(payload, value...)
+		payload = appendGetRespMeta(payload, meta)
+		return nil
+	})
+	if getErr == nil {
+		code = CodeOK
+		err = writeRespBytes(writer, frame, GetRespCmd, payload)
+		return
+	}
+
+	var getResp pb.GetResponse
+	setGetError(&getResp, getErr)
+	code = getResp.Code
+	bytes, _ := getResp.Marshal()
+	err = writeRespBytes(writer, frame, GetRespCmd, bytes)
+	return
+}
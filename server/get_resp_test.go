@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/mondis/provider"
+	"github.com/zhiqiangxu/qrpc"
+	"gotest.tools/assert"
+)
+
+// bufFrameWriter keeps the payload of the last frame written,
+// like qrpc, bytes written are only cleared by EndWrite, not by StartWrite
+type bufFrameWriter struct {
+	cmd     qrpc.Cmd
+	wbuf    []byte
+	payload []byte
+}
+
+func (w *bufFrameWriter) StartWrite(requestID uint64, cmd qrpc.Cmd, flags qrpc.FrameFlag) {
+	w.cmd = cmd
+}
+
+func (w *bufFrameWriter) WriteBytes(v []byte) { w.wbuf = append(w.wbuf, v...) }
+
+func (w *bufFrameWriter) EndWrite() error {
+	w.payload = append(w.payload[:0], w.wbuf...)
+	w.wbuf = w.wbuf[:0]
+	return nil
+}
+
+func (w *bufFrameWriter) EndWriteCompressed() error { return nil }
+
+func (w *bufFrameWriter) ResetFrame(requestID uint64, reason qrpc.Cmd) error { return nil }
+
+func openBadger(t testing.TB) (kvdb mondis.KVDB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "mondis_get_resp")
+	assert.Assert(t, err == nil)
+	kvdb = provider.NewBadger()
+	err = kvdb.Open(mondis.KVOption{Dir: dir})
+	assert.Assert(t, err == nil)
+	cleanup = func() {
+		kvdb.Close()
+		os.RemoveAll(dir)
+	}
+	return
+}
+
+func TestWriteGetResp(t *testing.T) {
+	kvdb, cleanup := openBadger(t)
+	defer cleanup()
+
+	metas := []*mondis.VMetaReq{nil, {TTL: time.Hour}, {TTL: time.Hour, Tag: 7}, {TTL: time.Hour, Tag: 255}}
+	sizes := []int{0, 1, 127, 128, 1 << 14, 1 << 20}
+	frame := &qrpc.RequestFrame{}
+	var w bufFrameWriter
+	for i, size := range sizes {
+		for j, meta := range metas {
+			key := []byte(fmt.Sprintf("%d:%d", i, j))
+			value := bytes.Repeat([]byte{byte(i + j)}, size)
+			err := kvdb.Set(key, value, meta)
+			assert.Assert(t, err == nil)
+
+			// the same bytes as the generated Marshal
+			var expected pb.GetResponse
+			handleGet(kvdb, &pb.GetRequest{Key: key}, &expected)
+			expectedBytes, _ := expected.Marshal()
+			code, err := writeGetResp(&w, frame, kvdb.(mondis.ValueGetter), &pb.GetRequest{Key: key})
+			assert.Assert(t, err == nil && code == CodeOK && w.cmd == GetRespCmd)
+			assert.Assert(t, bytes.Equal(w.payload, expectedBytes), key)
+
+			var resp pb.GetResponse
+			err = resp.Unmarshal(w.payload)
+			assert.Assert(t, err == nil && resp.Code == CodeOK && bytes.Equal(resp.Value, value))
+			assert.Assert(t, resp.Meta.ExpiresAt == expected.Meta.ExpiresAt && resp.Meta.Tag == expected.Meta.Tag)
+		}
+	}
+
+	code, err := writeGetResp(&w, frame, kvdb.(mondis.ValueGetter), &pb.GetRequest{Key: []byte("absent")})
+	assert.Assert(t, err == nil && code == CodeKeyNotFound)
+	var resp pb.GetResponse
+	err = resp.Unmarshal(w.payload)
+	assert.Assert(t, err == nil && resp.Code == CodeKeyNotFound)
+}
+
+// failingValueGetter fails GetValue after fn is called
+type failingValueGetter struct {
+	mondis.ValueGetter
+}
+
+func (g failingValueGetter) GetValue(k []byte, fn func(value []byte, meta mondis.VMetaResp) error) (err error) {
+	err = g.ValueGetter.GetValue(k, fn)
+	if err == nil {
+		err = fmt.Errorf("read failed")
+	}
+	return
+}
+
+func TestWriteGetRespError(t *testing.T) {
+	kvdb, cleanup := openBadger(t)
+	defer cleanup()
+
+	key := []byte("k")
+	err := kvdb.Set(key, []byte("v"), nil)
+	assert.Assert(t, err == nil)
+
+	// the error response is the only thing written
+	frame := &qrpc.RequestFrame{}
+	var w bufFrameWriter
+	code, err := writeGetResp(&w, frame, failingValueGetter{kvdb.(mondis.ValueGetter)}, &pb.GetRequest{Key: key})
+	assert.Assert(t, err == nil && code == CodeInternalError)
+	expectedBytes, _ := (&pb.GetResponse{Code: CodeInternalError, Msg: "read failed"}).Marshal()
+	assert.Assert(t, bytes.Equal(w.payload, expectedBytes), w.payload)
+}
+
+func BenchmarkGetResp1MB(b *testing.B) {
+	kvdb, cleanup := openBadger(b)
+	defer cleanup()
+
+	key := []byte("1MB")
+	err := kvdb.Set(key, bytes.Repeat([]byte{1}, 1<<20), nil)
+	assert.Assert(b, err == nil)
+
+	frame := &qrpc.RequestFrame{}
+	req := &pb.GetRequest{Key: key}
+	b.Run("Marshal", func(b *testing.B) {
+		var w bufFrameWriter
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var resp pb.GetResponse
+			handleGet(kvdb, req, &resp)
+			bytes, _ := resp.Marshal()
+			writeRespBytes(&w, frame, GetRespCmd, bytes)
+		}
+	})
+	b.Run("ValueGetter", func(b *testing.B) {
+		var w bufFrameWriter
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			writeGetResp(&w, frame, kvdb.(mondis.ValueGetter), req)
+		}
+	})
+}
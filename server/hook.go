@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+type (
+	// PreCommitHook validates the mutations of a write before it's committed, see Option.PreCommitHook
+	PreCommitHook func(mutations []MutationView) error
+	// MutationOp is the kind of a mutation
+	MutationOp int
+	// MutationView is a read only view of a pending mutation,
+	// slices are not copied so they must not be modified or retained after the hook returns.
+	MutationView struct {
+		op    MutationOp
+		key   []byte
+		value []byte
+		meta  *mondis.VMetaReq
+	}
+	// PreCommitError when a write is rejected by Option.PreCommitHook, Msg is from the hook
+	PreCommitError struct {
+		Msg string
+	}
+)
+
+const (
+	// MutationSet for set
+	MutationSet MutationOp = iota
+	// MutationDelete for delete
+	MutationDelete
+)
+
+// Op of the mutation
+func (m MutationView) Op() MutationOp {
+	return m.op
+}
+
+// Key of the mutation
+func (m MutationView) Key() []byte {
+	return m.key
+}
+
+// Value of the mutation, nil for MutationDelete
+func (m MutationView) Value() []byte {
+	return m.value
+}
+
+// Meta of the mutation, ok is false if it's not specified
+func (m MutationView) Meta() (meta mondis.VMetaReq, ok bool) {
+	if m.meta == nil {
+		return
+	}
+	meta = *m.meta
+	ok = true
+	return
+}
+
+func (e *PreCommitError) Error() string {
+	return "rejected by pre-commit hook: " + e.Msg
+}
+
+// runPreCommitHook returns nil if there's no hook or the hook accepts mutations,
+// a panic in the hook rejects them.
+func (s *Server) runPreCommitHook(mutations []MutationView) (rejected *PreCommitError) {
+	hook := s.option.PreCommitHook
+	if hook == nil {
+		return
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			logger.Instance().Error("PreCommitHook panic", zap.Any("err", e))
+			rejected = &PreCommitError{Msg: fmt.Sprintf("hook panic: %v", e)}
+		}
+	}()
+	err := hook(mutations)
+	if err != nil {
+		rejected = &PreCommitError{Msg: err.Error()}
+	}
+	return
+}
+
+// preCommitRejected returns the message of the hook if err is from it
+func preCommitRejected(err error) (msg string, ok bool) {
+	rejected, ok := err.(*PreCommitError)
+	if ok {
+		msg = rejected.Msg
+	}
+	return
+}
+
+// hookedTxn records mutations of an update txn for Option.PreCommitHook and watchers
+type hookedTxn struct {
+	mondis.ProviderTxn
+	s         *Server
+	mutations []MutationView
+}
+
+// Set for implement mondis.ProviderTxn
+func (txn *hookedTxn) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	err = txn.ProviderTxn.Set(k, v, meta)
+	if err != nil {
+		return
+	}
+	txn.mutations = append(txn.mutations, MutationView{op: MutationSet, key: k, value: v, meta: meta})
+	return
+}
+
+// Delete for implement mondis.ProviderTxn
+func (txn *hookedTxn) Delete(key []byte) (err error) {
+	err = txn.ProviderTxn.Delete(key)
+	if err != nil {
+		return
+	}
+	txn.mutations = append(txn.mutations, MutationView{op: MutationDelete, key: key})
+	return
+}
+
+// preCommit runs the hook on mutations recorded so far
+func (txn *hookedTxn) preCommit() (err error) {
+	rejected := txn.s.runPreCommitHook(txn.mutations)
+	if rejected != nil {
+		err = rejected
+	}
+	return
+}
+
+// Commit for implement mondis.ProviderTxn
+func (txn *hookedTxn) Commit() (err error) {
+	err = txn.preCommit()
+	if err != nil {
+		return
+	}
+	err = txn.ProviderTxn.Commit()
+	if err == nil {
+		txn.s.publish(txn.mutations)
+	}
+	return
+}
+
+// runInNewUpdateTxnWithRetry is util.RunInNewUpdateTxnWithRetry for one-shot writes,
+// with Option.PreCommitHook applied and the committed mutations published to watchers.
+func (s *Server) runInNewUpdateTxnWithRetry(f func(mondis.ProviderTxn) error, retry int) (err error) {
+	if s.option.PreCommitHook == nil && !s.option.EnableWatchCmd {
+		err = util.RunInNewUpdateTxnWithRetry(s.kvdb, f, retry)
+		return
+	}
+
+	var htxn *hookedTxn
+	err = util.RunInNewUpdateTxnWithRetry(s.kvdb, func(txn mondis.ProviderTxn) (err error) {
+		htxn = &hookedTxn{ProviderTxn: txn, s: s}
+		err = f(htxn)
+		if err != nil {
+			return
+		}
+		err = htxn.preCommit()
+		return
+	}, retry)
+	if err == nil {
+		s.publish(htxn.mutations)
+	}
+	return
+}
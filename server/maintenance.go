@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// ErrMaintenance when writing during maintenance mode
+var ErrMaintenance = errors.New("server in maintenance mode, writes rejected")
+
+// SetMaintenanceMode turns maintenance mode on or off,
+// new writes and update transactions are rejected with ErrMaintenance when on, while reads are still served.
+// Update transactions started before are allowed to finish unless Option.RejectCommitInMaintenance is set.
+func (s *Server) SetMaintenanceMode(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&s.maintenance, v)
+}
+
+func (s *Server) inMaintenance() bool {
+	return atomic.LoadInt32(&s.maintenance) == 1
+}
+
+// handleRejectedTxnFrames answers the remaining frames of a rejected transaction with code,
+// until it's committed or discarded.
+func handleRejectedTxnFrames(writer qrpc.FrameWriter, frame *qrpc.RequestFrame, code int32, msg string) {
+	// all responses share the same code and msg fields
+	bytes, _ := (&pb.CommitResponse{Code: code, Msg: msg}).Marshal()
+	var err error
+	for {
+		nextFrame := <-frame.FrameCh()
+		if nextFrame == nil {
+			return
+		}
+		switch nextFrame.Cmd {
+		case CommitCmd:
+			err = writeStreamRespBytes(writer, frame, CommitRespCmd, bytes, true)
+			if err != nil {
+				logger.Instance().Error("CommitCmd writeStreamRespBytes", zap.Error(err))
+			}
+			return
+		case DiscardCmd:
+			err = writeStreamRespBytes(writer, frame, DiscardRespCmd, nil, true)
+			if err != nil {
+				logger.Instance().Error("DiscardCmd writeStreamRespBytes", zap.Error(err))
+			}
+			return
+		default:
+			// response cmd always follows request cmd
+			err = writeStreamRespBytes(writer, frame, nextFrame.Cmd+1, bytes, false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+		}
+	}
+}
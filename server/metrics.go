@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/metrics"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// names of commands in metrics, only these are measured
+var metricCmdNames = map[qrpc.Cmd]string{
+	SetCmd:    "set",
+	GetCmd:    "get",
+	DeleteCmd: "delete",
+	ScanCmd:   "scan",
+	CommitCmd: "commit",
+}
+
+// ops of Doc* commands in metrics
+const (
+	metricDocInsert = "insert"
+	metricDocUpdate = "update"
+	metricDocDelete = "delete"
+)
+
+// serverMetrics are the collectors served at /metrics of Option.MetricsAddr
+type serverMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec
+	errors   *metrics.CounterVec
+	latency  *metrics.HistogramVec
+	docOps   *metrics.CounterVec
+	http     *http.Server
+}
+
+func newServerMetrics(s *Server) (m *serverMetrics, err error) {
+	r := metrics.NewRegistry()
+	m = &serverMetrics{registry: r}
+
+	m.requests, err = r.NewCounterVec("kvrpc_requests_total", "Number of requests by command, operations in transactions included.", "cmd")
+	if err != nil {
+		return
+	}
+	m.errors, err = r.NewCounterVec("kvrpc_request_errors_total", "Number of requests answered with a code other than ok or key not found.", "cmd")
+	if err != nil {
+		return
+	}
+	m.latency, err = r.NewHistogramVec("kvrpc_request_duration_seconds", "Latency of requests by command.",
+		metrics.ExponentialBuckets(0.00005, 4, 10), "cmd")
+	if err != nil {
+		return
+	}
+	_, err = r.NewGaugeFunc("kvrpc_open_txns", "Number of open transactions.", func() float64 {
+		return float64(s.ActiveTxns())
+	})
+	if err != nil {
+		return
+	}
+	m.docOps, err = r.NewCounterVec("kvrpc_document_ops_total", "Number of documents written by Doc* commands.", "db", "collection", "op")
+	if err != nil {
+		return
+	}
+
+	if ss, ok := s.kvdb.(mondis.StorageStatter); ok {
+		err = registerStorageGauges(r, ss)
+	}
+	return
+}
+
+// registerStorageGauges for stats of the provider, which are read once per scrape
+func registerStorageGauges(r *metrics.Registry, ss mondis.StorageStatter) (err error) {
+	_, err = r.NewGaugeFunc("kvrpc_provider_lsm_size_bytes", "Size of the LSM tree of the provider.", func() float64 {
+		return float64(ss.StorageStats().LSMSize)
+	})
+	if err != nil {
+		return
+	}
+	_, err = r.NewGaugeFunc("kvrpc_provider_vlog_size_bytes", "Size of the value log of the provider.", func() float64 {
+		return float64(ss.StorageStats().VlogSize)
+	})
+	if err != nil {
+		return
+	}
+	_, err = r.NewGaugeFunc("kvrpc_provider_level0_tables", "Number of level 0 tables of the provider, which are pending compaction.", func() float64 {
+		return float64(ss.StorageStats().Level0Tables)
+	})
+	return
+}
+
+// serve /metrics on addr until stopped
+func (m *serverMetrics) serve(addr string) (err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.registry)
+	m.http = &http.Server{Handler: mux}
+	go func() {
+		err := m.http.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Instance().Error("metrics Serve", zap.Error(err))
+		}
+	}()
+	return
+}
+
+func (m *serverMetrics) stop() (err error) {
+	if m.http == nil {
+		return
+	}
+	err = m.http.Shutdown(context.Background())
+	return
+}
+
+// startMetrics serves metrics on Option.MetricsAddr
+func (s *Server) startMetrics() (err error) {
+	m, err := newServerMetrics(s)
+	if err != nil {
+		return
+	}
+	err = m.serve(s.option.MetricsAddr)
+	if err != nil {
+		return
+	}
+	s.metrics = m
+	return
+}
+
+// observeCmd records a request of cmd started at start and answered with code, if Option.MetricsAddr is set
+func (s *Server) observeCmd(cmd qrpc.Cmd, start time.Time, code int32) {
+	if s.metrics == nil {
+		return
+	}
+
+	name := metricCmdNames[cmd]
+	s.metrics.requests.Inc(name)
+	if code != CodeOK && code != CodeKeyNotFound {
+		s.metrics.errors.Inc(name)
+	}
+	s.metrics.latency.Observe(time.Since(start).Seconds(), name)
+}
+
+// observeDoc records a document written by Doc* commands, if Option.MetricsAddr is set.
+// Failed ones are not recorded, so that names of collections not existing don't become labels.
+func (s *Server) observeDoc(op, db, collection string) {
+	if s.metrics == nil {
+		return
+	}
+
+	s.metrics.docOps.Inc(db, collection, op)
+}
@@ -1,31 +1,125 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
 	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/document/domain"
+	"github.com/zhiqiangxu/mondis/document/meta"
+	"github.com/zhiqiangxu/mondis/slowlog"
 	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
 )
 
 type (
 	// Option for Server
 	Option struct {
+		// CoalesceReads makes concurrent one-shot Get/Exists of the same key share one provider read,
+		// at the cost of a tiny staleness window for the requests arriving during the read
+		CoalesceReads bool
+		// EnableMaintenanceCmd allows clients to toggle maintenance mode by MaintenanceCmd
+		EnableMaintenanceCmd bool
+		// EnableListTxnsCmd allows clients to list open transactions by ListTxnsCmd
+		EnableListTxnsCmd bool
+		// RejectCommitInMaintenance rejects commits of update transactions started before maintenance mode is on
+		RejectCommitInMaintenance bool
+		// DrainTimeout is the max time Stop waits for open transactions to commit or discard,
+		// new transactions are rejected with ErrDraining meanwhile
+		DrainTimeout time.Duration
+		// TxnIdleTimeout is the max time a transaction waits for the next frame from client,
+		// after which it's discarded and the client gets ErrTxnTimedOut on its next operation.
+		// 0 means defaultTxnIdleTimeout, negative means no timeout.
+		TxnIdleTimeout time.Duration
+		// SnapshotTTL is the max time a snapshot created by SnapshotCmd is kept without being read,
+		// 0 means defaultSnapshotTTL.
+		SnapshotTTL time.Duration
+		// EnableFsckCmd allows clients to check, and repair if asked, document meta by FsckCmd, and cancel it by FsckCancelCmd
+		EnableFsckCmd bool
+		// FsckInterval is the pause between two databases checked by FsckCmd
+		FsckInterval time.Duration
+		// TLSConfig enables TLS on the listener if not nil,
+		// set ClientCAs and ClientAuth to tls.RequireAndVerifyClientCert of it for mutual TLS
+		TLSConfig *tls.Config
+		// EnableDocumentCmds hosts a document layer on kvdb and serves Doc* commands
+		EnableDocumentCmds bool
+		// EnableBackupCmds allows clients to backup by BackupCmd, and restore by RestoreCmd in maintenance mode
+		EnableBackupCmds bool
+		// PreCommitHook is called with the mutations of one-shot writes, and of update transactions at commit,
+		// a non nil error rejects the write with CodePreCommitRejected. Doc* commands are not covered.
+		PreCommitHook PreCommitHook
+		// KeyValidator and ValueValidator check the key and value written by SetCmd, IncCmd, IncrCmd and CASCmd
+		// in and outside transactions before writing, a non nil error rejects it with CodeValidationFailed.
+		// The value of IncCmd and IncrCmd is the 8-byte counter, deletes are not checked. nil means no checking.
+		KeyValidator   Validator
+		ValueValidator Validator
+		// SlowLog records scans taking longer than its threshold and serves them by SlowLogCmd if not nil,
+		// it can be shared with document.DBOption.SlowLog so that both layers are in the same log.
+		SlowLog *slowlog.Log
+		// EnableWatchCmd allows clients to watch committed mutations by WatchCmd
+		EnableWatchCmd bool
+		// WatchMaxUnacked is the max number of events a watcher can leave unacked before it's dropped,
+		// 0 means defaultWatchMaxUnacked.
+		WatchMaxUnacked int
+		// AuthProvider requires connections to authenticate by AuthCmd first if not nil,
+		// each request is then checked against the grants of the token, and rejected with CodePermissionDenied if not allowed.
+		AuthProvider AuthProvider
+		// EnableTxnSplit allows trusted clients to begin transactions by TxnOpaqueSplit,
+		// which are committed in chunks when too big for one provider txn instead of failing with CodeTxnTooBig.
+		// Each chunk is atomic on its own and reads after a split lose snapshot isolation,
+		// the number of splits is reported in CommitResponse.
+		EnableTxnSplit bool
+		// NodeRegistry registers the server as a node in meta on Start if not nil, which is removed on Stop,
+		// nodes registered by all servers on the same kvdb are listed by ListNodesCmd.
+		NodeRegistry *meta.NodeRegistryOption
+		// MetricsAddr serves metrics of requests, open transactions, the provider and Doc* commands
+		// at /metrics in the prometheus text format if not empty
+		MetricsAddr string
+		// SlowThreshold logs one-shot requests and streamed transactions taking longer than it by the zap logger if positive,
+		// with the key in hex capped at maxLoggedKeyLen bytes. Streams other than transactions, e.g., WatchCmd, are not covered.
+		SlowThreshold time.Duration
+		// AccessLogger receives the same requests as SlowThreshold does, slow or not, if not nil
+		AccessLogger AccessLogger
 	}
 	// Server for mondis
 	Server struct {
-		option   Option
-		kvoption mondis.KVOption
-		kvdb     mondis.KVDB
-		qserver  *qrpc.Server
+		option      Option
+		kvoption    mondis.KVOption
+		kvdb        mondis.KVDB
+		coalescer   *readCoalescer
+		maintenance int32
+		fsckMu      sync.Mutex
+		fsckCancel  context.CancelFunc
+		txns        txnRegistry
+		snapshots   snapshotRegistry
+		watches     watchHub
+		domain      *domain.Domain
+		metrics     *serverMetrics
+		nodes       *meta.NodeRegistry
+		connMu      sync.Mutex
+		qserver     *qrpc.Server
 	}
 	// KVServer is implemneted by Server
 	KVServer interface {
 		Start() error
 		Stop() error
+		Stats() Stats
+		SetMaintenanceMode(on bool)
+		ListTxns() []TxnInfo
+		ActiveTxns() int
+		ActiveSnapshots() int
 	}
 )
 
 // New is ctor for Server
 func New(addr string, kvdb mondis.KVDB, option Option, kvoption mondis.KVOption) KVServer {
 	s := &Server{option: option, kvoption: kvoption, kvdb: kvdb}
+	if option.CoalesceReads {
+		s.coalescer = newReadCoalescer(kvdb)
+	}
 
 	mux := qrpc.NewServeMux()
 	mux.Handle(SetCmd, &CmdSet{s})
@@ -33,30 +127,125 @@ func New(addr string, kvdb mondis.KVDB, option Option, kvoption mondis.KVOption)
 	mux.Handle(GetCmd, &CmdGet{s})
 	mux.Handle(DeleteCmd, &CmdDelete{s})
 	mux.Handle(ScanCmd, &CmdScan{s})
-	bindings := []qrpc.ServerBinding{qrpc.ServerBinding{Addr: addr, Handler: mux}}
+	mux.Handle(MaintenanceCmd, &CmdMaintenance{s})
+	mux.Handle(ScanStreamCmd, &CmdScanStream{s})
+	mux.Handle(IncrCmd, &CmdIncr{s})
+	mux.Handle(IncCmd, &CmdInc{s})
+	mux.Handle(CASCmd, &CmdCAS{s})
+	mux.Handle(CountCmd, &CmdCount{s})
+	mux.Handle(ListTxnsCmd, &CmdListTxns{s})
+	mux.Handle(FsckCmd, &CmdFsck{s})
+	mux.Handle(FsckCancelCmd, &CmdFsckCancel{s})
+	mux.Handle(DocInsertCmd, &CmdDocInsert{s})
+	mux.Handle(DocGetCmd, &CmdDocGet{s})
+	mux.Handle(DocUpdateCmd, &CmdDocUpdate{s})
+	mux.Handle(DocDeleteCmd, &CmdDocDelete{s})
+	mux.Handle(BackupCmd, &CmdBackup{s})
+	mux.Handle(RestoreCmd, &CmdRestore{s})
+	mux.Handle(SnapshotCmd, &CmdSnapshot{s})
+	mux.Handle(SnapshotGetCmd, &CmdSnapshotGet{s})
+	mux.Handle(SnapshotScanCmd, &CmdSnapshotScan{s})
+	mux.Handle(SnapshotReleaseCmd, &CmdSnapshotRelease{s})
+	mux.Handle(SlowLogCmd, &CmdSlowLog{s})
+	mux.Handle(WatchCmd, &CmdWatch{s})
+	mux.Handle(AuthCmd, &CmdAuth{s})
+	mux.Handle(ListNodesCmd, &CmdListNodes{s})
+	bindings := []qrpc.ServerBinding{qrpc.ServerBinding{Addr: addr, Handler: &authMux{s: s, mux: mux}, TLSConf: option.TLSConfig}}
 	qserver := qrpc.NewServer(bindings)
 
 	s.qserver = qserver
 	return s
 }
 
+// readKVOP returns the ProviderKVOP for one-shot reads
+func (s *Server) readKVOP() mondis.ProviderKVOP {
+	if s.coalescer != nil {
+		return s.coalescer
+	}
+	return s.kvdb
+}
+
 // Start server
 func (s *Server) Start() (err error) {
 	err = s.kvdb.Open(s.kvoption)
 	if err != nil {
 		return
 	}
+	if s.option.EnableDocumentCmds {
+		do := domain.NewDomain(s.kvdb)
+		err = do.Init()
+		if err != nil {
+			s.kvdb.Close()
+			return
+		}
+		s.domain = do
+	}
+	if s.option.NodeRegistry != nil {
+		nodes := meta.NewNodeRegistry(s.kvdb, *s.option.NodeRegistry)
+		_, err = nodes.Register()
+		if err != nil {
+			if s.domain != nil {
+				s.domain.Close()
+			}
+			s.kvdb.Close()
+			return
+		}
+		s.nodes = nodes
+	}
+	if s.option.MetricsAddr != "" {
+		err = s.startMetrics()
+		if err != nil {
+			if s.nodes != nil {
+				s.nodes.Close()
+			}
+			if s.domain != nil {
+				s.domain.Close()
+			}
+			s.kvdb.Close()
+			return
+		}
+	}
 	return s.qserver.ListenAndServe()
 }
 
-// Stop server
+// Domain returns the hosted document layer, nil unless Option.EnableDocumentCmds
+func (s *Server) Domain() *domain.Domain {
+	return s.domain
+}
+
+// Stop server, open transactions are drained for up to Option.DrainTimeout before kvdb is closed
 func (s *Server) Stop() (err error) {
 
-	err = s.kvdb.Close()
-	if err != nil {
-		return
+	n := s.txns.drain(s.option.DrainTimeout)
+	if n > 0 {
+		logger.Instance().Warn("Stop with open transactions", zap.Int("n", n))
+	}
+	s.snapshots.close()
+	s.watches.close()
+
+	// every step is done even if an earlier one fails, so that neither the db nor the listener is leaked,
+	// the first error is returned and the others are logged
+	keep := func(stepErr error) {
+		if stepErr == nil {
+			return
+		}
+		if err == nil {
+			err = stepErr
+			return
+		}
+		logger.Instance().Error("Stop", zap.Error(stepErr))
 	}
 
-	err = s.qserver.Shutdown()
+	if s.metrics != nil {
+		keep(s.metrics.stop())
+	}
+	if s.nodes != nil {
+		keep(s.nodes.Close())
+	}
+	if s.domain != nil {
+		keep(s.domain.Close())
+	}
+	keep(s.kvdb.Close())
+	keep(s.qserver.Shutdown())
 	return
 }
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/mondis/slowlog"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// Kinds of scans recorded to Option.SlowLog
+const (
+	SlowKindScan         = "scan"
+	SlowKindTxnScan      = "txn scan"
+	SlowKindSnapshotScan = "snapshot scan"
+	SlowKindScanStream   = "scan stream"
+)
+
+// observeScan records the scan of req started at start to Option.SlowLog if it's slow,
+// ot is nil if it's not in a transaction.
+func (s *Server) observeScan(kind string, frame *qrpc.RequestFrame, ot *openTxn, req *pb.ScanRequest, start time.Time, returned int) {
+	now := time.Now()
+	d := now.Sub(start)
+	if !s.option.SlowLog.Slow(d) {
+		return
+	}
+
+	var option mondis.ProviderScanOption
+	if pso := req.ProviderScanOption; pso != nil {
+		option = mondis.ProviderScanOption{Reverse: pso.Reverse, Prefix: pso.Prefix, Offset: pso.Offset, Stop: pso.Stop, KeysOnly: pso.KeysOnly, KeyPattern: pso.KeyPattern}
+	}
+	r := slowlog.Record{
+		Kind:      kind,
+		Namespace: frame.ConnectionInfo().RemoteAddr(),
+		Option:    s.option.SlowLog.ScanOption(option, int(req.Limit)),
+		Returned:  int64(returned),
+		Duration:  d,
+	}
+	if ot != nil {
+		r.TxnAge = now.Sub(ot.info.StartTime)
+	}
+	s.option.SlowLog.Add(r)
+}
+
+// CmdSlowLog for listing the most recent slow operations, only available when Option.SlowLog is set
+type CmdSlowLog struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdSlowLog) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		slowLogReq  pb.SlowLogRequest
+		slowLogResp pb.SlowLogResponse
+	)
+
+	err := slowLogReq.Unmarshal(frame.Payload)
+	switch {
+	case err != nil:
+		slowLogResp.Code = CodeInvalidRequest
+		slowLogResp.Msg = err.Error()
+	case cmd.s.option.SlowLog == nil:
+		slowLogResp.Code = CodeInvalidRequest
+		slowLogResp.Msg = "slow log not enabled"
+	default:
+		for _, r := range cmd.s.option.SlowLog.Recent(int(slowLogReq.Limit)) {
+			slowLogResp.Records = append(slowLogResp.Records, &pb.SlowLogRecord{
+				Id:        r.ID,
+				Kind:      r.Kind,
+				Namespace: r.Namespace,
+				Option:    r.Option,
+				Returned:  r.Returned,
+				Skipped:   r.Skipped,
+				Duration:  int64(r.Duration),
+				TxnAge:    int64(r.TxnAge),
+				Time:      r.Time.UnixNano(),
+				Stack:     r.Stack,
+			})
+		}
+		slowLogResp.Code = CodeOK
+	}
+
+	bytes, _ := slowLogResp.Marshal()
+	err = writeRespBytes(writer, frame, SlowLogRespCmd, bytes)
+	if err != nil {
+		logger.Instance().Error("writeRespBytes", zap.Error(err))
+	}
+}
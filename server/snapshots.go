@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+)
+
+// ErrSnapshotNotFound when a snapshot is released or expired
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+const defaultSnapshotTTL = time.Minute
+
+// snapshotHandle is a snapshot created by SnapshotCmd,
+// reads on it are serialized since mondis.Snapshot is not safe for concurrent use.
+type snapshotHandle struct {
+	mu       sync.Mutex
+	snapshot mondis.Snapshot
+	timer    *time.Timer
+	released bool
+}
+
+// release is idempotent
+func (h *snapshotHandle) release() {
+	h.mu.Lock()
+	if !h.released {
+		h.released = true
+		h.timer.Stop()
+		h.snapshot.Release()
+	}
+	h.mu.Unlock()
+}
+
+// snapshotRegistry tracks snapshots created by clients,
+// a snapshot is released once it's not read for ttl so that a leaked one doesn't pin versions forever.
+type snapshotRegistry struct {
+	mu        sync.Mutex
+	nextID    uint64
+	snapshots map[uint64]*snapshotHandle
+	closed    bool
+}
+
+// add registers snapshot and returns its id, ErrDraining if the registry is closed
+func (r *snapshotRegistry) add(snapshot mondis.Snapshot, ttl time.Duration) (id uint64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		err = ErrDraining
+		return
+	}
+	if r.snapshots == nil {
+		r.snapshots = make(map[uint64]*snapshotHandle)
+		// ids of a previous run are not mistaken for new ones
+		r.nextID = uint64(time.Now().UnixNano())
+	}
+	r.nextID++
+	id = r.nextID
+	h := &snapshotHandle{snapshot: snapshot}
+	h.timer = time.AfterFunc(ttl, func() {
+		r.remove(id)
+	})
+	r.snapshots[id] = h
+	return
+}
+
+// use calls fn with snapshot id, and postpones its expiry by ttl
+func (r *snapshotRegistry) use(id uint64, ttl time.Duration, fn func(snapshot mondis.Snapshot)) (err error) {
+	r.mu.Lock()
+	h := r.snapshots[id]
+	r.mu.Unlock()
+	if h == nil {
+		err = ErrSnapshotNotFound
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// released after it's got from registry
+	if h.released {
+		err = ErrSnapshotNotFound
+		return
+	}
+	fn(h.snapshot)
+	h.timer.Reset(ttl)
+	return
+}
+
+// remove releases snapshot id, ErrSnapshotNotFound if it's released already
+func (r *snapshotRegistry) remove(id uint64) (err error) {
+	r.mu.Lock()
+	h := r.snapshots[id]
+	delete(r.snapshots, id)
+	r.mu.Unlock()
+	if h == nil {
+		err = ErrSnapshotNotFound
+		return
+	}
+
+	h.release()
+	return
+}
+
+// close releases all snapshots and rejects new ones
+func (r *snapshotRegistry) close() {
+	r.mu.Lock()
+	r.closed = true
+	snapshots := r.snapshots
+	r.snapshots = nil
+	r.mu.Unlock()
+
+	for _, h := range snapshots {
+		h.release()
+	}
+}
+
+func (r *snapshotRegistry) count() (n int) {
+	r.mu.Lock()
+	n = len(r.snapshots)
+	r.mu.Unlock()
+	return
+}
+
+func (s *Server) snapshotTTL() time.Duration {
+	if s.option.SnapshotTTL <= 0 {
+		return defaultSnapshotTTL
+	}
+	return s.option.SnapshotTTL
+}
+
+// ActiveSnapshots returns the number of snapshots created by clients and not yet released
+func (s *Server) ActiveSnapshots() int {
+	return s.snapshots.count()
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/qrpc"
+)
+
+// opaques of the first frame of a client transaction, 0 is for read only
+const (
+	// TxnOpaqueUpdate for an update transaction
+	TxnOpaqueUpdate = 1
+	// TxnOpaqueSplit for an update transaction that can be split when too big,
+	// it's a plain update transaction unless Option.EnableTxnSplit is set.
+	TxnOpaqueSplit = 2
+)
+
+// isUpdateTxnFrame tells whether the first frame of a client transaction begins an update transaction
+func isUpdateTxnFrame(frame *qrpc.RequestFrame) bool {
+	opaque := frame.Cmd.Opaque()
+	return opaque == TxnOpaqueUpdate || opaque == TxnOpaqueSplit
+}
+
+// splitTxn commits the current provider txn and continues in a new one when a mutation hits kv.ErrTxnTooBig,
+// so it's only atomic per chunk, and reads after a split see what's committed by others meanwhile.
+type splitTxn struct {
+	mondis.ProviderTxn
+	newTxn func() mondis.ProviderTxn
+	splits int
+}
+
+func newSplitTxn(newTxn func() mondis.ProviderTxn) *splitTxn {
+	return &splitTxn{ProviderTxn: newTxn(), newTxn: newTxn}
+}
+
+// split commits the current chunk and begins the next
+func (txn *splitTxn) split() (err error) {
+	err = txn.ProviderTxn.Commit()
+	if err != nil {
+		return
+	}
+	txn.ProviderTxn = txn.newTxn()
+	txn.splits++
+	return
+}
+
+// Set for implement mondis.ProviderTxn
+func (txn *splitTxn) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	err = txn.ProviderTxn.Set(k, v, meta)
+	if err != kv.ErrTxnTooBig {
+		return
+	}
+
+	err = txn.split()
+	if err != nil {
+		return
+	}
+	// kv.ErrTxnTooBig again if it doesn't fit even alone
+	err = txn.ProviderTxn.Set(k, v, meta)
+	return
+}
+
+// Delete for implement mondis.ProviderTxn
+func (txn *splitTxn) Delete(key []byte) (err error) {
+	err = txn.ProviderTxn.Delete(key)
+	if err != kv.ErrTxnTooBig {
+		return
+	}
+
+	err = txn.split()
+	if err != nil {
+		return
+	}
+	err = txn.ProviderTxn.Delete(key)
+	return
+}
@@ -0,0 +1,18 @@
+package server
+
+// Stats for server
+type Stats struct {
+	// CoalescedReads is the number of one-shot Get/Exists served by another request's provider read
+	CoalescedReads uint64
+	// Maintenance tells whether server is in maintenance mode
+	Maintenance bool
+}
+
+// Stats returns current stats of server
+func (s *Server) Stats() (stats Stats) {
+	if s.coalescer != nil {
+		stats.CoalescedReads = s.coalescer.coalescedCount()
+	}
+	stats.Maintenance = s.inMaintenance()
+	return
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/qrpc"
+)
+
+var (
+	// ErrDraining when a transaction is started while server is stopping
+	ErrDraining = errors.New("server draining, new transactions rejected")
+	// ErrTxnTimedOut when a transaction is discarded for being idle longer than Option.TxnIdleTimeout
+	ErrTxnTimedOut = errors.New("transaction timed out for being idle")
+)
+
+const defaultTxnIdleTimeout = 30 * time.Second
+
+// TxnInfo for an open client transaction
+type TxnInfo struct {
+	ID         uint64
+	RemoteAddr string
+	StartTime  time.Time
+	// Ops is the number of operations done in the transaction, commit and discard excluded
+	Ops int64
+}
+
+type openTxn struct {
+	info TxnInfo
+	ops  int64
+}
+
+func (t *openTxn) incOps() {
+	atomic.AddInt64(&t.ops, 1)
+}
+
+// txnRegistry tracks open client transactions
+type txnRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	txns     map[uint64]*openTxn
+	draining bool
+	// emptyCh is closed when the last transaction is removed while draining
+	emptyCh chan struct{}
+}
+
+// add registers a new transaction, ErrDraining if draining
+func (r *txnRegistry) add(remoteAddr string) (t *openTxn, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.draining {
+		err = ErrDraining
+		return
+	}
+	if r.txns == nil {
+		r.txns = make(map[uint64]*openTxn)
+	}
+	r.nextID++
+	t = &openTxn{info: TxnInfo{ID: r.nextID, RemoteAddr: remoteAddr, StartTime: time.Now()}}
+	r.txns[t.info.ID] = t
+	return
+}
+
+// remove is idempotent
+func (r *txnRegistry) remove(t *openTxn) {
+	r.mu.Lock()
+	delete(r.txns, t.info.ID)
+	if len(r.txns) == 0 && r.emptyCh != nil {
+		close(r.emptyCh)
+		r.emptyCh = nil
+	}
+	r.mu.Unlock()
+}
+
+func (r *txnRegistry) count() (n int) {
+	r.mu.Lock()
+	n = len(r.txns)
+	r.mu.Unlock()
+	return
+}
+
+// drain rejects new transactions and waits up to timeout for open ones to finish,
+// returns the number of transactions still open.
+func (r *txnRegistry) drain(timeout time.Duration) (n int) {
+	r.mu.Lock()
+	r.draining = true
+	n = len(r.txns)
+	if n == 0 || timeout <= 0 {
+		r.mu.Unlock()
+		return
+	}
+	if r.emptyCh == nil {
+		r.emptyCh = make(chan struct{})
+	}
+	emptyCh := r.emptyCh
+	r.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-emptyCh:
+	case <-timer.C:
+	}
+
+	n = r.count()
+	return
+}
+
+func (r *txnRegistry) list() (txns []TxnInfo) {
+	r.mu.Lock()
+	txns = make([]TxnInfo, 0, len(r.txns))
+	for _, t := range r.txns {
+		info := t.info
+		info.Ops = atomic.LoadInt64(&t.ops)
+		txns = append(txns, info)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(txns, func(i, j int) bool {
+		return txns[i].ID < txns[j].ID
+	})
+	return
+}
+
+// beginTxn registers a client transaction of frame and starts it, ErrDraining if server is stopping
+func (s *Server) beginTxn(frame *qrpc.RequestFrame, update bool) (txn mondis.ProviderTxn, ot *openTxn, err error) {
+	ot, err = s.txns.add(frame.ConnectionInfo().RemoteAddr())
+	if err != nil {
+		return
+	}
+	if update && s.option.EnableTxnSplit && frame.Cmd.Opaque() == TxnOpaqueSplit {
+		// each chunk is hooked on its own
+		txn = newSplitTxn(func() mondis.ProviderTxn {
+			return s.newProviderTxn(true)
+		})
+		return
+	}
+	txn = s.newProviderTxn(update)
+	return
+}
+
+// newProviderTxn wraps the provider txn in hookedTxn if there's Option.PreCommitHook or watchers to notify
+func (s *Server) newProviderTxn(update bool) (txn mondis.ProviderTxn) {
+	txn = s.kvdb.NewTransaction(update)
+	if update && (s.option.PreCommitHook != nil || s.option.EnableWatchCmd) {
+		txn = &hookedTxn{ProviderTxn: txn, s: s}
+	}
+	return
+}
+
+func (s *Server) txnIdleTimeout() time.Duration {
+	if s.option.TxnIdleTimeout == 0 {
+		return defaultTxnIdleTimeout
+	}
+	return s.option.TxnIdleTimeout
+}
+
+// ActiveTxns returns the number of open client transactions
+func (s *Server) ActiveTxns() int {
+	return s.txns.count()
+}
+
+// ListTxns returns the open client transactions, oldest first
+func (s *Server) ListTxns() []TxnInfo {
+	return s.txns.list()
+}
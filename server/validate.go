@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/zhiqiangxu/mondis"
+)
+
+// ErrValidationFailed is what ValidationError unwraps to
+var ErrValidationFailed = errors.New("validation failed")
+
+type (
+	// Validator checks the key or value of a write, see Option.KeyValidator and Option.ValueValidator
+	Validator func([]byte) error
+	// ValidationError when a write is rejected by a Validator, Msg is from the validator
+	ValidationError struct {
+		Msg string
+	}
+)
+
+func (e *ValidationError) Error() string {
+	return ErrValidationFailed.Error() + ": " + e.Msg
+}
+
+// Unwrap returns ErrValidationFailed
+func (e *ValidationError) Unwrap() error {
+	return ErrValidationFailed
+}
+
+// validateSet returns the message of the validator rejecting k or v, ok is false if rejected
+func (s *Server) validateSet(k, v []byte) (msg string, ok bool) {
+	if s.option.KeyValidator != nil {
+		if err := s.option.KeyValidator(k); err != nil {
+			msg = "key: " + err.Error()
+			return
+		}
+	}
+	if s.option.ValueValidator != nil {
+		if err := s.option.ValueValidator(v); err != nil {
+			msg = "value: " + err.Error()
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+// validationFailed returns the message of err if it's a *ValidationError
+func validationFailed(err error) (msg string, ok bool) {
+	invalid, ok := err.(*ValidationError)
+	if ok {
+		msg = invalid.Msg
+	}
+	return
+}
+
+// validatingTxn runs the validators on sets whose value is computed in the txn, e.g., by Inc and CAS
+type validatingTxn struct {
+	mondis.ProviderTxn
+	s *Server
+}
+
+// validating wraps txn so that sets not passing the validators fail with *ValidationError,
+// txn is returned as is if there's no validator
+func (s *Server) validating(txn mondis.ProviderTxn) mondis.ProviderTxn {
+	if s.option.KeyValidator == nil && s.option.ValueValidator == nil {
+		return txn
+	}
+	return &validatingTxn{ProviderTxn: txn, s: s}
+}
+
+// Set for implement mondis.ProviderTxn
+func (txn *validatingTxn) Set(k, v []byte, meta *mondis.VMetaReq) (err error) {
+	if msg, ok := txn.s.validateSet(k, v); !ok {
+		err = &ValidationError{Msg: msg}
+		return
+	}
+	err = txn.ProviderTxn.Set(k, v, meta)
+	return
+}
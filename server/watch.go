@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zhiqiangxu/mondis/pb"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+// ErrWatcherTooSlow when a watcher is dropped for having Option.WatchMaxUnacked events unacked
+var ErrWatcherTooSlow = errors.New("watcher dropped for too many unacked events")
+
+const defaultWatchMaxUnacked = 1024
+
+// watcher is a WatchCmd stream, events are queued in ch until written by the stream
+type watcher struct {
+	prefix []byte
+	ch     chan *pb.WatchEvent
+	// published and acked are numbers of events,
+	// published is only updated with watchHub.mu held
+	published uint64
+	acked     uint64
+	// dropped is closed with err set when watcher is removed by watchHub
+	dropped chan struct{}
+	err     error
+}
+
+func (w *watcher) ack(acked uint64) {
+	atomic.StoreUint64(&w.acked, acked)
+}
+
+func (w *watcher) unacked() uint64 {
+	return w.published - atomic.LoadUint64(&w.acked)
+}
+
+// watchHub broadcasts committed mutations to watchers of matching prefixes
+type watchHub struct {
+	mu       sync.Mutex
+	seq      uint64
+	watchers map[*watcher]struct{}
+	closed   bool
+}
+
+// add registers a watcher of prefix, which is dropped once it has maxUnacked events unacked
+func (h *watchHub) add(prefix []byte, maxUnacked int) (w *watcher, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		err = ErrDraining
+		return
+	}
+	if h.watchers == nil {
+		h.watchers = make(map[*watcher]struct{})
+	}
+	w = &watcher{prefix: prefix, ch: make(chan *pb.WatchEvent, maxUnacked), dropped: make(chan struct{})}
+	h.watchers[w] = struct{}{}
+	return
+}
+
+// remove is idempotent
+func (h *watchHub) remove(w *watcher) {
+	h.mu.Lock()
+	delete(h.watchers, w)
+	h.mu.Unlock()
+}
+
+// drop must be called with h.mu held
+func (h *watchHub) drop(w *watcher, err error) {
+	delete(h.watchers, w)
+	w.err = err
+	close(w.dropped)
+}
+
+// publish committed mutations, each is assigned the next sequence number
+func (h *watchHub) publish(mutations []MutationView) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.watchers) == 0 {
+		return
+	}
+
+	for _, m := range mutations {
+		h.seq++
+		var ev *pb.WatchEvent
+		for w := range h.watchers {
+			if !bytes.HasPrefix(m.key, w.prefix) {
+				continue
+			}
+			if w.unacked() >= uint64(cap(w.ch)) {
+				h.drop(w, ErrWatcherTooSlow)
+				continue
+			}
+			// mutations may refer to request buffers, copy once for all watchers
+			if ev == nil {
+				ev = &pb.WatchEvent{Seq: h.seq, Key: copyBytes(m.key), Deleted: m.op == MutationDelete}
+				if m.op == MutationSet {
+					ev.Value = copyBytes(m.value)
+				}
+			}
+			w.published++
+			w.ch <- ev
+		}
+	}
+}
+
+// close drops all watchers with ErrDraining and rejects new ones
+func (h *watchHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for w := range h.watchers {
+		h.drop(w, ErrDraining)
+	}
+}
+
+func (s *Server) watchMaxUnacked() int {
+	if s.option.WatchMaxUnacked <= 0 {
+		return defaultWatchMaxUnacked
+	}
+	return s.option.WatchMaxUnacked
+}
+
+// publish committed mutations to watchers if Option.EnableWatchCmd
+func (s *Server) publish(mutations []MutationView) {
+	if s.option.EnableWatchCmd {
+		s.watches.publish(mutations)
+	}
+}
+
+// CmdWatch for watching committed Set and Delete of keys with a prefix, only available when Option.EnableWatchCmd is set.
+// Mutations of one-shot writes and update transactions are covered, Doc* commands are not.
+// Events of concurrent writes are sequenced in the order they're published after commit.
+// Client should close its side of the stream by an end frame, e.g. DiscardCmd, to stop watching.
+type CmdWatch struct {
+	s *Server
+}
+
+// ServeQRPC implements qrpc.Handler
+func (cmd *CmdWatch) ServeQRPC(writer qrpc.FrameWriter, frame *qrpc.RequestFrame) {
+	var (
+		watchReq  pb.WatchRequest
+		watchResp pb.WatchResponse
+		w         *watcher
+	)
+
+	defer waitStreamClosedByPeer(frame)
+
+	write := func(end bool) error {
+		bytes, _ := watchResp.Marshal()
+		return writeStreamRespBytes(writer, frame, WatchRespCmd, bytes, end)
+	}
+
+	err := watchReq.Unmarshal(frame.Payload)
+	switch {
+	case err != nil:
+		watchResp.Code = CodeInvalidRequest
+		watchResp.Msg = err.Error()
+	case !cmd.s.option.EnableWatchCmd:
+		watchResp.Code = CodeInvalidRequest
+		watchResp.Msg = "watch command not enabled"
+	default:
+		w, err = cmd.s.watches.add(watchReq.Prefix, cmd.s.watchMaxUnacked())
+		if err != nil {
+			watchResp.Code = CodeDraining
+			watchResp.Msg = err.Error()
+		} else {
+			watchResp.Code = CodeOK
+		}
+	}
+	if w == nil {
+		err = write(true)
+		if err != nil {
+			logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+		}
+		return
+	}
+	defer cmd.s.watches.remove(w)
+
+	err = write(false)
+	if err != nil {
+		logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+		return
+	}
+
+	for {
+		select {
+		case ev := <-w.ch:
+			watchResp.Event = ev
+			err = write(false)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				return
+			}
+		case nextFrame := <-frame.FrameCh():
+			if nextFrame == nil {
+				return
+			}
+			if nextFrame.Flags.IsDone() {
+				watchResp = pb.WatchResponse{Code: CodeOK}
+				err = write(true)
+				if err != nil {
+					logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+				}
+				return
+			}
+			watchReq = pb.WatchRequest{}
+			if watchReq.Unmarshal(nextFrame.Payload) == nil {
+				w.ack(watchReq.Acked)
+			}
+		case <-w.dropped:
+			watchResp = pb.WatchResponse{Msg: w.err.Error()}
+			if w.err == ErrWatcherTooSlow {
+				watchResp.Code = CodeWatcherTooSlow
+			} else {
+				watchResp.Code = CodeDraining
+			}
+			err = write(true)
+			if err != nil {
+				logger.Instance().Error("writeStreamRespBytes", zap.Error(err))
+			}
+			return
+		case <-frame.Context().Done():
+			return
+		}
+	}
+}
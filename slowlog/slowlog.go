@@ -0,0 +1,200 @@
+package slowlog
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/util/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultThreshold is the default Option.Threshold
+	DefaultThreshold = 100 * time.Millisecond
+	// DefaultCapacity is the default Option.Capacity
+	DefaultCapacity = 128
+	// DefaultMaxKeyLen is the default Option.MaxKeyLen
+	DefaultMaxKeyLen = 32
+	// MaxOptionLen is the max length of Record.Option
+	MaxOptionLen = 256
+	// MaxNamespaceLen is the max length of Record.Namespace
+	MaxNamespaceLen = 128
+	// MaxStackLen is the max length of Record.Stack
+	MaxStackLen = 4096
+)
+
+// Option for Log
+type Option struct {
+	// Threshold is the min duration of an operation to be recorded, 0 means DefaultThreshold
+	Threshold time.Duration
+	// Capacity is the max number of records kept, the oldest is overwritten once full, 0 means DefaultCapacity
+	Capacity int
+	// MaxKeyLen truncates keys and prefixes in option summaries, 0 means DefaultMaxKeyLen
+	MaxKeyLen int
+	// Logger also writes each record to logger.Instance() at warn level
+	Logger bool
+	// CaptureStack records the stack of the goroutine adding the record,
+	// the record ID is logged with it so that they can be correlated.
+	CaptureStack bool
+}
+
+// Record of a slow operation
+type Record struct {
+	// ID increases with each record added to the Log
+	ID uint64
+	// Kind of the operation, e.g., scan or find
+	Kind string
+	// Namespace is the remote address for server commands, and the collection name for document operations
+	Namespace string
+	// Option summarizes what's asked, keys are truncated to Option.MaxKeyLen
+	Option string
+	// Returned is the number of entries or documents returned
+	Returned int64
+	// Skipped is the number of entries or documents visited but not returned, when it's known
+	Skipped int64
+	// Duration of the operation
+	Duration time.Duration
+	// TxnAge is the age of the transaction when the operation finished, 0 if it's not in a known transaction
+	TxnAge time.Duration
+	// Time when the operation finished
+	Time time.Time
+	// Stack of the goroutine adding the record if Option.CaptureStack
+	Stack string
+}
+
+// Log keeps the most recent slow operations in a ring of Option.Capacity records,
+// which is allocated once and reused, so memory is bounded by the length limits of Record fields.
+// A nil *Log is valid and records nothing.
+type Log struct {
+	option Option
+	mu     sync.Mutex
+	nextID uint64
+	// ring[(next-1) % len(ring)] is the most recent one
+	ring  []slot
+	next  uint64
+	stack sync.Pool
+}
+
+type slot struct {
+	r     Record
+	stack []byte
+}
+
+// New is ctor for Log
+func New(option Option) *Log {
+	if option.Threshold <= 0 {
+		option.Threshold = DefaultThreshold
+	}
+	if option.Capacity <= 0 {
+		option.Capacity = DefaultCapacity
+	}
+	if option.MaxKeyLen <= 0 {
+		option.MaxKeyLen = DefaultMaxKeyLen
+	}
+	l := &Log{option: option, ring: make([]slot, option.Capacity)}
+	l.stack.New = func() interface{} {
+		return make([]byte, MaxStackLen)
+	}
+	return l
+}
+
+// Slow tells whether an operation taking d should be recorded
+func (l *Log) Slow(d time.Duration) bool {
+	return l != nil && d >= l.option.Threshold
+}
+
+// Add r if it's slow, ID and Time are filled by Log, and Stack if Option.CaptureStack
+func (l *Log) Add(r Record) {
+	if !l.Slow(r.Duration) {
+		return
+	}
+
+	r.Namespace = truncate(r.Namespace, MaxNamespaceLen)
+	r.Option = truncate(r.Option, MaxOptionLen)
+	r.Time = time.Now()
+
+	var stack []byte
+	if l.option.CaptureStack {
+		stack = l.stack.Get().([]byte)
+		stack = stack[:runtime.Stack(stack[:MaxStackLen], false)]
+	}
+
+	l.mu.Lock()
+	l.nextID++
+	r.ID = l.nextID
+	s := &l.ring[l.next%uint64(len(l.ring))]
+	l.next++
+	s.r = r
+	s.r.Stack = ""
+	s.stack = append(s.stack[:0], stack...)
+	l.mu.Unlock()
+
+	if stack != nil {
+		l.stack.Put(stack[:cap(stack)])
+	}
+
+	if l.option.Logger {
+		logger.Instance().Warn("slow operation",
+			zap.Uint64("id", r.ID),
+			zap.String("kind", r.Kind),
+			zap.String("namespace", r.Namespace),
+			zap.String("option", r.Option),
+			zap.Int64("returned", r.Returned),
+			zap.Int64("skipped", r.Skipped),
+			zap.Duration("duration", r.Duration),
+			zap.Duration("txnAge", r.TxnAge))
+	}
+}
+
+// Recent returns up to limit most recent records, newest first, limit <= 0 means all kept
+func (l *Log) Recent(limit int) (records []Record) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if n > uint64(len(l.ring)) {
+		n = uint64(len(l.ring))
+	}
+	if limit > 0 && uint64(limit) < n {
+		n = uint64(limit)
+	}
+	records = make([]Record, 0, n)
+	for i := uint64(1); i <= n; i++ {
+		s := &l.ring[(l.next-i)%uint64(len(l.ring))]
+		r := s.r
+		if len(s.stack) > 0 {
+			r.Stack = string(s.stack)
+		}
+		records = append(records, r)
+	}
+	return
+}
+
+// Key formats key for Record.Option, truncated to Option.MaxKeyLen
+func (l *Log) Key(key []byte) string {
+	if len(key) > l.option.MaxKeyLen {
+		return fmt.Sprintf("%q...", key[:l.option.MaxKeyLen])
+	}
+	return fmt.Sprintf("%q", key)
+}
+
+// ScanOption summarizes option and limit for Record.Option
+func (l *Log) ScanOption(option mondis.ProviderScanOption, limit int) string {
+	return fmt.Sprintf("prefix=%s offset=%s stop=%s pattern=%s reverse=%v keysOnly=%v limit=%d",
+		l.Key(option.Prefix), l.Key(option.Offset), l.Key(option.Stop), l.Key([]byte(option.KeyPattern)),
+		option.Reverse, option.KeysOnly, limit)
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
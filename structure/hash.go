@@ -5,6 +5,7 @@ import (
 
 	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/memcomparable"
 	"github.com/zhiqiangxu/mondis/kv/numeric"
 )
 
@@ -192,6 +193,90 @@ func (t *TxStructure) HGetNDesc(key []byte, n int) (res []HashPair, err error) {
 	return
 }
 
+// HGetRange gets fields and values in hash in ascending order, whose fields are in [start, end), nil end means no upper bound.
+func (t *TxStructure) HGetRange(key, start, end []byte) (res []HashPair, err error) {
+	dataPrefix := t.hashDataKeyPrefix(key)
+	option := mondis.ProviderScanOption{Prefix: dataPrefix, Offset: t.encodeHashDataKey(key, start)}
+	if end != nil {
+		option.Stop = t.encodeHashDataKey(key, end)
+	}
+
+	var field []byte
+	scanErr := t.txn.Scan(option, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		_, field, err = t.decodeHashDataKey(key)
+		if err != nil {
+			return false
+		}
+
+		res = append(res, HashPair{
+			Field: append([]byte{}, field...),
+			Value: append([]byte{}, value...),
+		})
+		return true
+	})
+	if err == nil {
+		err = scanErr
+	}
+	return
+}
+
+// HScan calls fn for fields with fieldPrefix and their values in ascending order until fn returns false,
+// only keys of those fields are scanned. field and value are only valid before fn returns.
+func (t *TxStructure) HScan(key, fieldPrefix []byte, fn func(field, value []byte) bool) (err error) {
+	err = t.scanHashPrefix(key, fieldPrefix, nil, false, fn)
+	return
+}
+
+// HScanAfter is like HScan but starts from the first field greater than after, for paging by the last field seen.
+func (t *TxStructure) HScanAfter(key, fieldPrefix, after []byte, fn func(field, value []byte) bool) (err error) {
+	err = t.scanHashPrefix(key, fieldPrefix, after, false, fn)
+	return
+}
+
+// HCountPrefix counts fields with fieldPrefix by a keys only scan of them
+func (t *TxStructure) HCountPrefix(key, fieldPrefix []byte) (n int64, err error) {
+	err = t.scanHashPrefix(key, fieldPrefix, nil, true, func(field, value []byte) bool {
+		n++
+		return true
+	})
+	return
+}
+
+func (t *TxStructure) scanHashPrefix(key, fieldPrefix, after []byte, keysOnly bool, fn func(field, value []byte) bool) (err error) {
+	option := mondis.ProviderScanOption{
+		Prefix:   memcomparable.EncodeBytesPrefix(t.hashDataKeyPrefix(key), fieldPrefix),
+		KeysOnly: keysOnly,
+	}
+	if after != nil {
+		// seeking before prefix would end the scan right away
+		offset := t.encodeHashDataKey(key, after)
+		if bytes.Compare(offset, option.Prefix) > 0 {
+			option.Offset = offset
+		}
+	}
+
+	var field []byte
+	scanErr := t.txn.Scan(option, func(key []byte, value []byte, _ mondis.VMetaResp) bool {
+		_, field, err = t.decodeHashDataKey(key)
+		if err != nil {
+			return false
+		}
+		if !bytes.HasPrefix(field, fieldPrefix) {
+			// shorter field padded with 0
+			return true
+		}
+		if after != nil && bytes.Compare(field, after) <= 0 {
+			return true
+		}
+
+		return fn(field, value)
+	})
+	if err == nil {
+		err = scanErr
+	}
+	return
+}
+
 // HClear removes the hash value of the key.
 func (t *TxStructure) HClear(key []byte) (err error) {
 	metaKey := t.encodeHashMetaKey(key)
@@ -337,3 +422,30 @@ func (t *TxStructure) loadHashValue(dataKey []byte) (v []byte, err error) {
 
 	return
 }
+
+// HCount counts the fields of a hash by iterating over them,
+// unlike HLen it doesn't trust the field count in hash meta.
+func (t *TxStructure) HCount(key []byte) (n int64, err error) {
+	err = t.iterateHash(key, func(field []byte, value []byte) bool {
+		n++
+		return true
+	})
+	return
+}
+
+// HRepairLen resets the field count in hash meta to the counted one.
+func (t *TxStructure) HRepairLen(key []byte) (n int64, err error) {
+	n, err = t.HCount(key)
+	if err != nil {
+		return
+	}
+
+	metaKey := t.encodeHashMetaKey(key)
+	if n == 0 {
+		err = t.txn.Delete(metaKey)
+		return
+	}
+
+	err = t.txn.Set(metaKey, hashMeta{FieldCount: n}.Value(), nil)
+	return
+}
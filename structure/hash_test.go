@@ -0,0 +1,115 @@
+package structure
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zhiqiangxu/mondis"
+	"github.com/zhiqiangxu/mondis/provider"
+	"gotest.tools/assert"
+)
+
+func TestHLen(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	txn := kvdb.NewTransaction(true)
+	defer txn.Discard()
+	s := New(txn, []byte("s"))
+	key := []byte("h")
+
+	// HLen is checked against HCount after each step
+	check := func(expected int64) {
+		l, err := s.HLen(key)
+		assert.Assert(t, err == nil && l == expected, l)
+		n, err := s.HCount(key)
+		assert.Assert(t, err == nil && n == expected, n)
+	}
+	check(0)
+
+	for i := 0; i < 10; i++ {
+		err = s.HSet(key, []byte(fmt.Sprintf("f%d", i)), []byte("v"))
+		assert.Assert(t, err == nil)
+	}
+	check(10)
+
+	// overwrites and same values
+	err = s.HSet(key, []byte("f0"), []byte("v2"))
+	assert.Assert(t, err == nil)
+	err = s.HSet(key, []byte("f1"), []byte("v"))
+	assert.Assert(t, err == nil)
+	check(10)
+
+	_, err = s.HInc(key, []byte("f10"), 1)
+	assert.Assert(t, err == nil)
+	_, err = s.HInc(key, []byte("f10"), 1)
+	assert.Assert(t, err == nil)
+	check(11)
+
+	// missing and repeated fields
+	err = s.HDel(key, []byte("f0"), []byte("missing"), []byte("f0"), []byte("f1"))
+	assert.Assert(t, err == nil)
+	check(9)
+	err = s.HDel([]byte("missing"), []byte("f2"))
+	assert.Assert(t, err == nil)
+	check(9)
+
+	// other hashes aren't counted
+	err = s.HSet([]byte("h2"), []byte("f0"), []byte("v"))
+	assert.Assert(t, err == nil)
+	check(9)
+
+	err = s.HClear(key)
+	assert.Assert(t, err == nil)
+	check(0)
+	l, err := s.HLen([]byte("h2"))
+	assert.Assert(t, err == nil && l == 1)
+
+	err = s.HSet(key, []byte("f0"), []byte("v"))
+	assert.Assert(t, err == nil)
+	check(1)
+	err = s.HDel(key, []byte("f0"))
+	assert.Assert(t, err == nil)
+	check(0)
+}
+
+func TestHScanAfter(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	txn := kvdb.NewTransaction(true)
+	defer txn.Discard()
+	s := New(txn, []byte("s"))
+	key := []byte("h")
+
+	for _, field := range []string{"a1", "b1", "b2", "b3", "c1"} {
+		err = s.HSet(key, []byte(field), []byte("v"))
+		assert.Assert(t, err == nil)
+	}
+
+	scan := func(fieldPrefix, after string) (fields []string) {
+		var afterBytes []byte
+		if after != "" {
+			afterBytes = []byte(after)
+		}
+		err := s.HScanAfter(key, []byte(fieldPrefix), afterBytes, func(field, value []byte) bool {
+			fields = append(fields, string(field))
+			return true
+		})
+		assert.Assert(t, err == nil)
+		return
+	}
+
+	assert.DeepEqual(t, scan("b", ""), []string{"b1", "b2", "b3"})
+	assert.DeepEqual(t, scan("b", "b1"), []string{"b2", "b3"})
+	// after needn't be a field
+	assert.DeepEqual(t, scan("b", "b15"), []string{"b2", "b3"})
+	assert.DeepEqual(t, scan("b", "a"), []string{"b1", "b2", "b3"})
+	assert.Assert(t, scan("b", "b3") == nil)
+	assert.Assert(t, scan("b", "c") == nil)
+	assert.DeepEqual(t, scan("", "b2"), []string{"b3", "c1"})
+}
@@ -0,0 +1,11 @@
+//go:build pebble
+// +build pebble
+
+package test
+
+import "github.com/zhiqiangxu/mondis/provider"
+
+func init() {
+	kvdbProviders = append(kvdbProviders, provider.NewPebble)
+	txnProviders = append(txnProviders, provider.NewPebble)
+}
@@ -3,37 +3,106 @@ package test
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/zhiqiangxu/mondis"
 	"github.com/zhiqiangxu/mondis/client"
+	"github.com/zhiqiangxu/mondis/clientmock"
+	"github.com/zhiqiangxu/mondis/document"
+	"github.com/zhiqiangxu/mondis/document/config"
 	"github.com/zhiqiangxu/mondis/document/ddl"
 	"github.com/zhiqiangxu/mondis/document/dml"
 	"github.com/zhiqiangxu/mondis/document/domain"
+	"github.com/zhiqiangxu/mondis/document/gcworker"
+	"github.com/zhiqiangxu/mondis/document/intents"
+	"github.com/zhiqiangxu/mondis/document/keyspace"
+	"github.com/zhiqiangxu/mondis/document/meta"
+	"github.com/zhiqiangxu/mondis/document/model"
+	"github.com/zhiqiangxu/mondis/document/txn"
 	"github.com/zhiqiangxu/mondis/kv"
+	"github.com/zhiqiangxu/mondis/kv/memcomparable"
+	"github.com/zhiqiangxu/mondis/kv/numeric"
 	"github.com/zhiqiangxu/mondis/provider"
 	"github.com/zhiqiangxu/mondis/server"
+	"github.com/zhiqiangxu/mondis/slowlog"
 	"github.com/zhiqiangxu/mondis/structure"
+	"github.com/zhiqiangxu/mondis/util"
+	"github.com/zhiqiangxu/qrpc"
+	"github.com/zhiqiangxu/util/osc"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"gotest.tools/assert"
 )
 
 const (
-	addr    = "localhost:8099"
-	dataDir = "/tmp/mondis"
+	addr        = "localhost:8099"
+	metricsAddr = "localhost:8098"
+	dataDir     = "/tmp/mondis"
 )
 
+var (
+	// providers for provider agnostic tests
+	kvdbProviders = []func() mondis.KVDB{
+		provider.NewBadger, provider.NewLevelDB, provider.NewMemory,
+	}
+	// providers that support transaction, which is required by server
+	txnProviders = []func() mondis.KVDB{
+		provider.NewBadger, provider.NewMemory,
+	}
+)
+
+// goErrs collects errors of goroutines spawned by a test, which must not call t.FailNow,
+// so that they're asserted by check in the test goroutine once the goroutines are done.
+type goErrs struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// add err if it's not nil, and tell whether it's added
+func (g *goErrs) add(err error) bool {
+	if err == nil {
+		return false
+	}
+	g.mu.Lock()
+	g.errs = append(g.errs, err)
+	g.mu.Unlock()
+	return true
+}
+
+func (g *goErrs) check(t testing.TB) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	assert.Assert(t, len(g.errs) == 0, g.errs)
+}
+
 func TestBadger(t *testing.T) {
 	// server side
 	{
 		// use badger provider
 		kvdb := provider.NewBadger()
-		s := server.New(addr, kvdb, server.Option{}, mondis.KVOption{Dir: dataDir})
+		s := server.New(addr, kvdb, server.Option{MetricsAddr: metricsAddr}, mondis.KVOption{Dir: dataDir})
 		go s.Start()
 
 		time.Sleep(time.Millisecond * 500)
@@ -42,8 +111,46 @@ func TestBadger(t *testing.T) {
 	}
 
 	// client side
+	c := client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+	testClientAPI(t, c)
+
+	samples := scrapeMetrics(t)
+	for _, cmd := range []string{"set", "get", "delete", "scan", "commit"} {
+		assert.Assert(t, samples[fmt.Sprintf(`kvrpc_requests_total{cmd="%s"}`, cmd)] > 0, cmd)
+		assert.Assert(t, samples[fmt.Sprintf(`kvrpc_request_duration_seconds_count{cmd="%s"}`, cmd)] > 0, cmd)
+	}
+	for _, name := range []string{"kvrpc_open_txns", "kvrpc_provider_lsm_size_bytes", "kvrpc_provider_vlog_size_bytes", "kvrpc_provider_level0_tables"} {
+		_, ok := samples[name]
+		assert.Assert(t, ok, name)
+	}
+}
+
+// scrapeMetrics returns samples served at metricsAddr by name with labels
+func scrapeMetrics(t *testing.T) (samples map[string]float64) {
+	resp, err := http.Get("http://" + metricsAddr + "/metrics")
+	assert.Assert(t, err == nil)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Assert(t, err == nil && resp.StatusCode == http.StatusOK)
+
+	samples = make(map[string]float64)
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		var v float64
+		_, err = fmt.Sscan(line[i+1:], &v)
+		assert.Assert(t, err == nil, line)
+		samples[line[:i]] = v
+	}
+	return
+}
+
+// testClientAPI is shared by client.Client and clientmock.Client, which should behave the same
+func testClientAPI(t *testing.T, c client.API) {
 	{
-		c := client.New(addr, client.Option{})
 		nonExistingKey := []byte("nonExistingKey")
 
 		{
@@ -153,275 +260,5606 @@ func TestBadger(t *testing.T) {
 				}
 			}
 
+			// test reverse scan, with a key right after the prefix range
+			afterPrefix := kv.Key(prefix).PrefixNext()
+			err = c.Set(afterPrefix, afterPrefix, nil)
+			assert.Assert(t, err == nil)
+			scanOption = mondis.ScanOption{Limit: n, ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte(prefix), Reverse: true}}
+			entries, err = c.Scan(scanOption)
+			assert.Assert(t, err == nil && len(entries) == n)
+			// scan result should be from high to low
+			for i, entry := range entries {
+				assert.Assert(t, bytes.Equal(entry.Key, []byte(fmt.Sprintf("%s:%d", prefix, n-1-i))))
+			}
+
+			// test reverse scan with Limit, both directly and within txn
+			scanOption = mondis.ScanOption{Limit: 3, ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte(prefix), Reverse: true}}
+			for j := 0; j < 2; j++ {
+				switch j {
+				case 0:
+					entries, err = c.Scan(scanOption)
+				case 1:
+					err = c.View(func(txn mondis.Txn) error {
+						entries, err = txn.Scan(scanOption)
+						return err
+					})
+				}
+				assert.Assert(t, err == nil && len(entries) == 3)
+				for i, entry := range entries {
+					assert.Assert(t, bytes.Equal(entry.Key, []byte(fmt.Sprintf("%s:%d", prefix, n-1-i))))
+				}
+			}
+
+			// test keys only scan, values are not transferred
+			scanOption = mondis.ScanOption{Limit: n, ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte(prefix), KeysOnly: true}}
+			for j := 0; j < 2; j++ {
+				switch j {
+				case 0:
+					entries, err = c.Scan(scanOption)
+				case 1:
+					err = c.View(func(txn mondis.Txn) error {
+						entries, err = txn.Scan(scanOption)
+						return err
+					})
+				}
+				assert.Assert(t, err == nil && len(entries) == n)
+				for i, entry := range entries {
+					assert.Assert(t, bytes.Equal(entry.Key, []byte(fmt.Sprintf("%s:%d", prefix, i))) && entry.Value == nil)
+				}
+			}
+
+			// test range scan, Stop is exclusive
+			start := []byte(fmt.Sprintf("%s:%d", prefix, 2))
+			stop := []byte(fmt.Sprintf("%s:%d", prefix, 5))
+			scanOption = mondis.ScanOption{Limit: n, ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte(prefix), Offset: start, Stop: stop}}
+			entries, err = c.Scan(scanOption)
+			assert.Assert(t, err == nil && len(entries) == 3)
+			for i, entry := range entries {
+				assert.Assert(t, bytes.Equal(entry.Key, []byte(fmt.Sprintf("%s:%d", prefix, 2+i))))
+			}
+
+			// test reverse range scan
+			scanOption = mondis.ScanOption{Limit: n, ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte(prefix), Offset: stop, Stop: start, Reverse: true}}
+			entries, err = c.Scan(scanOption)
+			assert.Assert(t, err == nil && len(entries) == 3)
+			for i, entry := range entries {
+				assert.Assert(t, bytes.Equal(entry.Key, []byte(fmt.Sprintf("%s:%d", prefix, 5-i))))
+			}
+			err = c.Delete(afterPrefix)
+			assert.Assert(t, err == nil)
+		}
+
+		{
+			// test ScanOption edge cases and Count, ScanStream
+			prefix := []byte("api_prefix:")
+			for i := 0; i < 3; i++ {
+				err := c.Set(append(append([]byte(nil), prefix...), byte('0'+i)), nil, nil)
+				assert.Assert(t, err == nil)
+			}
+			entries, err := c.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: prefix}})
+			assert.Assert(t, err == nil && len(entries) == 0)
+			entries, err = c.Scan(mondis.ScanOption{Limit: 10, ProviderScanOption: mondis.ProviderScanOption{Prefix: prefix}})
+			assert.Assert(t, err == nil && len(entries) == 3 && entries[0].Value != nil && len(entries[0].Value) == 0)
+			n, err := c.Count(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: prefix}})
+			assert.Assert(t, err == nil && n == 3)
+			n, err = c.Count(mondis.ScanOption{Limit: 2, ProviderScanOption: mondis.ProviderScanOption{Prefix: prefix}})
+			assert.Assert(t, err == nil && n == 2)
+			var streamed []mondis.Entry
+			err = c.ScanStream(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: prefix, Reverse: true, KeysOnly: true}}, func(entry mondis.Entry) bool {
+				streamed = append(streamed, entry)
+				return true
+			})
+			assert.Assert(t, err == nil && len(streamed) == 3 && streamed[0].Key[len(prefix)] == '2' && streamed[0].Value == nil)
+			// an empty value is present but not nil
+			v, _, err := c.Get(entries[0].Key)
+			assert.Assert(t, err == nil && v != nil && len(v) == 0)
+			for _, entry := range entries {
+				err = c.Delete(entry.Key)
+				assert.Assert(t, err == nil)
+			}
+		}
+
+		{
+			// test txn errors: mutate in read only txn and conflict
+			key := []byte("api_txn")
+			err := c.View(func(txn mondis.Txn) error {
+				return txn.Set(key, key, nil)
+			})
+			assert.Assert(t, err == client.ErrMutateForROTxn)
+			err = c.View(func(txn mondis.Txn) error {
+				return txn.Delete(key)
+			})
+			assert.Assert(t, err == client.ErrMutateForROTxn)
+
+			err = c.Update(func(txn mondis.Txn) error {
+				_, _, err := txn.Get(key)
+				assert.Assert(t, err == kv.ErrKeyNotFound)
+				// written by others after read
+				err = c.Set(key, []byte("other"), nil)
+				assert.Assert(t, err == nil)
+				return txn.Set(key, key, nil)
+			})
+			assert.Assert(t, err == kv.ErrTxnConflict)
+			v, _, err := c.Get(key)
+			assert.Assert(t, err == nil && bytes.Equal(v, []byte("other")))
+
+			// test TTL
+			_, err = c.TTL(key)
+			assert.Assert(t, err == client.ErrNoTTL)
+			err = c.Set(key, key, &mondis.VMetaReq{TTL: time.Hour, Tag: 3})
+			assert.Assert(t, err == nil)
+			ttl, err := c.TTL(key)
+			assert.Assert(t, err == nil && ttl > 59*time.Minute && ttl <= time.Hour, ttl)
+			_, meta, err := c.Get(key)
+			assert.Assert(t, err == nil && meta.Tag == 3 && meta.Version == 0)
+			err = c.Delete(key)
+			assert.Assert(t, err == nil)
+			_, err = c.TTL(key)
+			assert.Assert(t, err == kv.ErrKeyNotFound)
+		}
+
+		{
+			// test Incr, Inc and CAS
+			key := []byte("api_counter")
+			n, err := c.Incr(key, 2)
+			assert.Assert(t, err == nil && n == 2)
+			n, err = c.Incr(key, -5)
+			assert.Assert(t, err == nil && n == -3)
+			v, _, err := c.Get(key)
+			assert.Assert(t, err == nil && bytes.Equal(v, numeric.Encode2Binary(uint64(n), nil)))
+
+			binKey := []byte("api_bin_counter")
+			n, err = c.Inc(binKey, math.MaxInt64)
+			assert.Assert(t, err == nil && n == math.MaxInt64)
+			_, err = c.Inc(binKey, 1)
+			assert.Assert(t, err == kv.ErrOverflow)
+
+			swapped, err := c.CompareAndSwap(key, []byte("-3"), []byte("x"))
+			assert.Assert(t, err == nil && !swapped)
+			swapped, err = c.CompareAndSwap(key, v, []byte("x"))
+			assert.Assert(t, err == nil && swapped)
+			swapped, err = c.CompareAndSwapAbsent(key, []byte("y"))
+			assert.Assert(t, err == nil && !swapped)
+			swapped, current, err := c.CompareAndSet(key, []byte("y"), []byte("z"))
+			assert.Assert(t, err == nil && !swapped && string(current) == "x")
+			deleted, current, err := c.CompareAndDelete(key, []byte("x"))
+			assert.Assert(t, err == nil && deleted && current == nil)
+			swapped, current, err = c.CompareAndSet(key, nil, []byte{})
+			assert.Assert(t, err == nil && swapped && current == nil)
+			// an empty value is present
+			deleted, current, err = c.CompareAndDelete(key, nil)
+			assert.Assert(t, err == nil && !deleted && current != nil && len(current) == 0)
+			swapped, err = c.CompareAndSwap(key, nil, []byte("e"))
+			assert.Assert(t, err == nil && swapped)
+
+			for _, k := range [][]byte{key, binKey} {
+				err = c.Delete(k)
+				assert.Assert(t, err == nil)
+			}
 		}
 
 	}
 
 }
 
-func TestDocument(t *testing.T) {
-	os.RemoveAll(dataDir)
-	kvdb := provider.NewBadger()
-	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
-	assert.Assert(t, err == nil)
+func TestClientMock(t *testing.T) {
+	c := clientmock.New()
+	testClientAPI(t, c)
 
-	do := domain.NewDomain(kvdb)
-	assert.Assert(t, do.Init() == nil)
-	_, err = do.DDL().CreateSchema(context.Background(), ddl.CreateSchemaInput{DB: "db", Collections: []string{"c"}})
+	// maintenance and watch are always enabled for the mock
+	events := make(chan mondis.WatchEvent, 10)
+	cancel, err := c.Watch([]byte("w:"), func(ev mondis.WatchEvent) {
+		events <- ev
+	})
 	assert.Assert(t, err == nil)
-	db, err := do.DB("db")
+	err = c.Update(func(txn mondis.Txn) error {
+		err := txn.Set([]byte("w:1"), []byte("v"), nil)
+		if err != nil {
+			return err
+		}
+		return txn.Delete([]byte("w:2"))
+	})
 	assert.Assert(t, err == nil)
-
-	c, err := db.Collection("c")
+	err = c.Set([]byte("x:1"), []byte("v"), nil)
 	assert.Assert(t, err == nil)
+	ev := <-events
+	assert.Assert(t, string(ev.Key) == "w:1" && string(ev.Value) == "v" && !ev.Deleted)
+	ev2 := <-events
+	assert.Assert(t, string(ev2.Key) == "w:2" && ev2.Deleted && ev2.Seq == ev.Seq+1)
 
-	// test GetDidRange before DeleteAll
-	{
-		key := "key range"
-		did1, err := c.InsertOne(bson.M{key: "value"}, nil)
-		assert.Assert(t, err == nil)
-		did2, err := c.InsertOne(bson.M{key: "value"}, nil)
-		assert.Assert(t, err == nil)
+	err = c.SetMaintenanceMode(true)
+	assert.Assert(t, err == nil)
+	err = c.Set([]byte("w:3"), nil, nil)
+	assert.Assert(t, err == server.ErrMaintenance)
+	_, err = c.Incr([]byte("w:3"), 1)
+	assert.Assert(t, err == server.ErrMaintenance)
+	err = c.Update(func(txn mondis.Txn) error {
+		_, _, err := txn.Get([]byte("x:1"))
+		return err
+	})
+	assert.Assert(t, err == server.ErrMaintenance)
+	v, _, err := c.Get([]byte("x:1"))
+	assert.Assert(t, err == nil && string(v) == "v")
+	err = c.SetMaintenanceMode(false)
+	assert.Assert(t, err == nil)
 
-		min, max, err := c.GetDidRange(nil)
-		assert.Assert(t, err == nil && min == did1 && max == did2)
+	// watchers end with client.ErrStreamClosed on Close
+	err = c.Close()
+	assert.Assert(t, err == nil)
+	ev = <-events
+	assert.Assert(t, ev.Err == client.ErrStreamClosed)
+	cancel()
+	_, _, err = c.Get([]byte("x:1"))
+	assert.Assert(t, err == client.ErrClientClosed)
+}
 
-		n, err := c.Count(nil)
-		assert.Assert(t, err == nil && n == 2)
+// selfSignedTLSConfig returns configs trusting a self-signed cert, which is also cert for mutual TLS
+func selfSignedTLSConfig(t *testing.T) (serverConf, clientConf *tls.Config, cert tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Assert(t, err == nil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
 
-		var result []bson.M
-		err = c.GetMany([]int64{did1, did2}, &result, nil)
-		assert.Assert(t, err == nil && len(result) == 2)
-		result = nil
-		err = c.GetAll(&result, nil)
-		assert.Assert(t, err == nil && len(result) == 2)
+		BasicConstraintsValid: true,
 	}
-
-	n, err := c.DeleteAll(nil)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
 	assert.Assert(t, err == nil)
-
-	key := "key"
-	did, err := c.InsertOne(bson.M{key: "value"}, nil)
+	parsed, err := x509.ParseCertificate(der)
 	assert.Assert(t, err == nil)
 
-	var data bson.M
-	err = c.GetOne(did, &data, nil)
-	assert.Assert(t, err == nil && data[key] == "value")
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	serverConf = &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool}
+	clientConf = &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	return
+}
 
-	updated, err := c.UpdateOne(did, bson.M{key: "value2"}, nil)
-	assert.Assert(t, err == nil && updated)
+func TestTLS(t *testing.T) {
+	os.RemoveAll(dataDir)
+	serverConf, clientConf, _ := selfSignedTLSConfig(t)
+	s := server.New(addr, provider.NewBadger(), server.Option{TLSConfig: serverConf}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
 
-	err = c.GetOne(did, &data, nil)
-	assert.Assert(t, err == nil && data[key] == "value2")
+	c := client.New(addr, client.Option{TLSConfig: clientConf})
+	defer c.Close()
 
-	n, err = c.Count(nil)
-	assert.Assert(t, err == nil && n == 1)
+	key := []byte("tls")
+	err := c.Set(key, key, nil)
+	assert.Assert(t, err == nil, err)
+	v, _, err := c.Get(key)
+	assert.Assert(t, err == nil && bytes.Equal(v, key))
+	exists, err := c.Exists(key)
+	assert.Assert(t, err == nil && exists)
+	entries, err := c.Scan(mondis.ScanOption{Limit: 10})
+	assert.Assert(t, err == nil && len(entries) == 1 && bytes.Equal(entries[0].Key, key))
 
-	err = c.DeleteOne(did, nil)
+	err = c.Update(func(txn mondis.Txn) error {
+		err := txn.Delete(key)
+		assert.Assert(t, err == nil)
+		_, _, err = txn.Get(key)
+		assert.Assert(t, err == kv.ErrKeyNotFound)
+		return nil
+	})
 	assert.Assert(t, err == nil)
+	_, _, err = c.Get(key)
+	assert.Assert(t, err == kv.ErrKeyNotFound)
 
-	err = c.InsertOneManaged(1000, bson.M{key: "value"}, nil)
-	assert.Assert(t, err == nil)
-	n, err = c.Count(nil)
-	assert.Assert(t, err == nil && n == 1)
-	err = c.DeleteOne(1000, nil)
-	assert.Assert(t, err == nil)
+	// plain client can't talk to TLS server
+	plain := client.New(addr, client.Option{QrpcConfig: qrpc.ConnectionConfig{ReadTimeout: 1}})
+	defer plain.Close()
+	_, _, err = plain.Get(key)
+	assert.Assert(t, err != nil && err != kv.ErrKeyNotFound)
+}
 
-	err = c.GetOne(did, nil, nil)
-	assert.Assert(t, err == dml.ErrDocNotFound)
+func TestMutualTLS(t *testing.T) {
+	os.RemoveAll(dataDir)
+	serverConf, clientConf, cert := selfSignedTLSConfig(t)
+	serverConf.ClientAuth = tls.RequireAndVerifyClientCert
+	s := server.New(addr, provider.NewBadger(), server.Option{TLSConfig: serverConf}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
 
-	// {
-	// 	// test index
-	// 	c, err := db.Collection("i")
-	// 	if err != nil {
-	// 		t.Fatal("db.Collection", err)
-	// 	}
-	// 	idxName := "test_idx"
-	// 	idef := document.IndexDefinition{
-	// 		Name: idxName,
-	// 		Fields: []document.IndexField{
-	// 			document.IndexField{Name: "f1"},
-	// 		},
-	// 	}
-	// 	iid, err := c.CreateIndex(idef)
-	// 	if err != nil {
-	// 		t.Fatal("c.CreateIndex", err)
-	// 	}
-	// 	if iid <= 0 {
-	// 		t.Fatal("iid <=0", iid)
-	// 	}
+	// TLS client without cert is rejected
+	anonymous := client.New(addr, client.Option{TLSConfig: clientConf, QrpcConfig: qrpc.ConnectionConfig{ReadTimeout: 1}})
+	defer anonymous.Close()
+	err := anonymous.Set([]byte("mtls"), nil, nil)
+	assert.Assert(t, err != nil)
 
-	// 	allIndexes := c.GetIndexes()
-	// 	if len(allIndexes) != 1 {
-	// 		t.Fatal("len(allIndexes)!=1")
-	// 	}
+	withCert := clientConf.Clone()
+	withCert.Certificates = []tls.Certificate{cert}
+	c := client.New(addr, client.Option{TLSConfig: withCert})
+	defer c.Close()
 
-	// 	exists, err := c.DropIndex(idxName)
-	// 	if err != nil {
-	// 		t.Fatal("c.DropIndex", err)
-	// 	}
-	// 	if !exists {
-	// 		t.Fatal()
-	// 	}
-	// }
-	// db.Close()
+	key := []byte("mtls")
+	err = c.Set(key, key, nil)
+	assert.Assert(t, err == nil, err)
+	v, _, err := c.Get(key)
+	assert.Assert(t, err == nil && bytes.Equal(v, key))
+	entries, err := c.Scan(mondis.ScanOption{Limit: 10})
+	assert.Assert(t, err == nil && len(entries) == 1 && bytes.Equal(entries[0].Key, key))
+}
 
-	// err = c.DeleteOne(did, nil)
-	// if err != document.ErrAlreadyClosed {
-	// 	t.Fatal("err != document.ErrAlreadyClosed")
-	// }
+func TestEmptyValue(t *testing.T) {
+	for _, provider := range txnProviders {
+		os.RemoveAll(dataDir)
+		s := server.New(addr, provider(), server.Option{}, mondis.KVOption{Dir: dataDir})
+		go s.Start()
+		time.Sleep(time.Millisecond * 500)
 
-}
+		c := client.New(addr, client.Option{})
 
-func TestList(t *testing.T) {
-	kvdb := provider.NewBadger()
-	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
-	assert.Assert(t, err == nil)
-	defer kvdb.Close()
+		key := []byte("empty")
+		err := c.Set(key, []byte{}, nil)
+		assert.Assert(t, err == nil)
 
-	txn := kvdb.NewTransaction(true)
-	txStruct := structure.New(txn, []byte("l"))
-	list1 := []byte("list1")
-	l, err := txStruct.LLen(list1)
-	assert.Assert(t, err == nil && l == 0)
+		v, _, err := c.Get(key)
+		assert.Assert(t, err == nil && v != nil && len(v) == 0)
+		exists, err := c.Exists(key)
+		assert.Assert(t, err == nil && exists)
 
-	err = txStruct.LPush(list1, []byte("item1"), []byte("item2"))
-	assert.Assert(t, err == nil)
-	l, err = txStruct.LLen(list1)
-	assert.Assert(t, err == nil && l == 2)
+		err = c.View(func(txn mondis.Txn) error {
+			v, _, err := txn.Get(key)
+			assert.Assert(t, err == nil && v != nil && len(v) == 0)
+			return nil
+		})
+		assert.Assert(t, err == nil)
 
-	item, err := txStruct.LPop(list1)
-	assert.Assert(t, err == nil && bytes.Equal(item, []byte("item2")))
+		entries, err := c.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: key}, Limit: 1})
+		assert.Assert(t, err == nil && len(entries) == 1 && entries[0].Value != nil && len(entries[0].Value) == 0)
 
-	item, err = txStruct.LPop(list1)
-	assert.Assert(t, err == nil && bytes.Equal(item, []byte("item1")))
+		// deleted key is absent rather than empty
+		err = c.Delete(key)
+		assert.Assert(t, err == nil)
+		v, _, err = c.Get(key)
+		assert.Assert(t, err == kv.ErrKeyNotFound && v == nil)
+		exists, err = c.Exists(key)
+		assert.Assert(t, err == nil && !exists)
 
-	err = txStruct.RPush(list1, []byte("item1"), []byte("item2"))
-	assert.Assert(t, err == nil)
-	l, err = txStruct.LLen(list1)
-	assert.Assert(t, err == nil && l == 2)
+		assert.Assert(t, c.Close() == nil)
+		assert.Assert(t, s.Stop() == nil)
+	}
+}
 
-	item, err = txStruct.LPop(list1)
-	assert.Assert(t, err == nil && bytes.Equal(item, []byte("item1")))
+func TestClientPool(t *testing.T) {
+	startServer := func() server.KVServer {
+		s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+		go s.Start()
+		time.Sleep(time.Millisecond * 500)
+		return s
+	}
 
-	item, err = txStruct.LPop(list1)
-	assert.Assert(t, err == nil && bytes.Equal(item, []byte("item2")))
+	s := startServer()
 
-	l, err = txStruct.LLen(list1)
-	assert.Assert(t, err == nil && l == 0)
+	c := client.New(addr, client.Option{PoolSize: 4})
+	defer c.Close()
 
-	err = txn.Commit()
-	assert.Assert(t, err == nil)
+	n := 20
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := []byte(fmt.Sprintf("pool:%d", i))
+			errs.add(c.Update(func(txn mondis.Txn) error {
+				return txn.Set(key, key, nil)
+			}))
+		}(i)
+	}
+	wg.Wait()
+	errs.check(t)
+
+	entries, err := c.Scan(mondis.ScanOption{Limit: n, ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("pool:")}})
+	assert.Assert(t, err == nil && len(entries) == n)
+
+	// dead connections are evicted and redialed after server restart
+	assert.Assert(t, s.Stop() == nil)
+	time.Sleep(time.Millisecond * 100)
+	s = startServer()
+	defer s.Stop()
+
+	for i := 0; i < 4; i++ {
+		_, _, err = c.Get([]byte("pool:0"))
+		assert.Assert(t, err == nil, err)
+	}
 }
 
-func TestHash(t *testing.T) {
-	kvdb := provider.NewBadger()
-	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
-	if err != nil {
-		t.Fatal("kvdb.Open", err)
+func TestReconnect(t *testing.T) {
+	os.RemoveAll(dataDir)
+	startServer := func() server.KVServer {
+		s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+		go s.Start()
+		time.Sleep(time.Millisecond * 500)
+		return s
 	}
-	defer kvdb.Close()
 
-	txn := kvdb.NewTransaction(true)
-	txStruct := structure.New(txn, []byte("l"))
-	hash1 := []byte("hash1")
+	s := startServer()
 
-	err = txStruct.HClear(hash1)
-	if err != nil {
-		t.FailNow()
+	c := client.New(addr, client.Option{PoolSize: 2, DialTimeout: time.Second, ReconnectBackoff: 10 * time.Millisecond})
+	defer c.Close()
+
+	assert.Assert(t, c.Set([]byte("reconnect"), []byte("v"), nil) == nil)
+
+	// txn fails instead of hanging when its connection breaks
+	err := c.Update(func(txn mondis.Txn) error {
+		err := txn.Set([]byte("reconnect:txn"), []byte("v"), nil)
+		assert.Assert(t, err == nil, err)
+		assert.Assert(t, s.Stop() == nil)
+		_, _, err = txn.Get([]byte("reconnect"))
+		return err
+	})
+	assert.Assert(t, err == client.ErrConnectionLost, err)
+
+	// plain calls fail while server is down
+	err = c.Set([]byte("reconnect"), []byte("v2"), nil)
+	assert.Assert(t, err != nil)
+
+	s = startServer()
+	defer s.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err = c.Set([]byte("reconnect"), []byte("v2"), nil)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
+	assert.Assert(t, err == nil, err)
 
-	f1 := []byte("f1")
-	v1 := []byte("v1")
-	f2 := []byte("f2")
-	v2 := []byte("v2")
-	f3 := []byte("f3")
-	v3 := []byte("v3")
-	err = txStruct.HSet(hash1, f1, v1)
-	if err != nil {
-		t.FailNow()
+	// all connections are usable again
+	for i := 0; i < 4; i++ {
+		v, _, err := c.Get([]byte("reconnect"))
+		assert.Assert(t, err == nil && string(v) == "v2", err)
 	}
-	err = txStruct.HSet(hash1, f2, v2)
-	if err != nil {
-		t.FailNow()
+	_, _, err = c.Get([]byte("reconnect:txn"))
+	assert.Assert(t, err == kv.ErrKeyNotFound, err)
+}
+
+func TestPoolSlowDial(t *testing.T) {
+	// accepts connections but never responds, so the auth of each dial hangs until read timeout
+	ln, err := net.Listen("tcp", "localhost:0")
+	assert.Assert(t, err == nil)
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	c := client.New(ln.Addr().String(), client.Option{PoolSize: 2, Token: "token", QrpcConfig: qrpc.ConnectionConfig{ReadTimeout: 2}})
+
+	var (
+		wg       sync.WaitGroup
+		failures int32
+	)
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.Get([]byte("slow")); err != nil {
+				atomic.AddInt32(&failures, 1)
+			}
+		}()
 	}
-	err = txStruct.HSet(hash1, f3, v3)
-	if err != nil {
-		t.FailNow()
+
+	// neither Close nor the other dial waits for a dial in progress
+	time.Sleep(100 * time.Millisecond)
+	closeStart := time.Now()
+	assert.Assert(t, c.Close() == nil)
+	assert.Assert(t, time.Since(closeStart) < time.Second)
+	wg.Wait()
+	assert.Assert(t, failures == 2 && time.Since(start) < 3*time.Second, failures, time.Since(start))
+}
+
+func TestShardedClient(t *testing.T) {
+	addrs := []string{"localhost:8101", "localhost:8102", "localhost:8103"}
+	for i, addr := range addrs {
+		dir := fmt.Sprintf("%s_shard%d", dataDir, i)
+		os.RemoveAll(dir)
+		defer os.RemoveAll(dir)
+		s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dir})
+		go s.Start()
+		defer s.Stop()
 	}
+	time.Sleep(time.Millisecond * 500)
 
-	n, err := txStruct.HLen(hash1)
-	if err != nil || n != 3 {
-		t.FailNow()
+	// [, "h") on 0, ["h", "p") on 1, keys with "p" or "q" on 2
+	sc, err := client.NewSharded([]client.ShardSpec{
+		{Prefix: []byte("q"), Addr: addrs[2]},
+		{End: []byte("h"), Addr: addrs[0]},
+		{Start: []byte("h"), End: []byte("p"), Addr: addrs[1]},
+		{Prefix: []byte("p"), Addr: addrs[2]},
+	}, client.Option{})
+	assert.Assert(t, err == nil, err)
+	defer sc.Close()
+
+	_, err = client.NewSharded([]client.ShardSpec{{End: []byte("h"), Addr: addrs[0]}, {Start: []byte("g"), Addr: addrs[1]}}, client.Option{})
+	assert.Assert(t, err != nil)
+
+	keys := []string{"a1", "g9", "h", "k2", "o9", "p1", "p2", "q1"}
+	for _, k := range keys {
+		assert.Assert(t, sc.Set([]byte(k), []byte("v"+k), nil) == nil)
 	}
+	assert.Assert(t, sc.Set([]byte("z"), nil, nil) == client.ErrNoShard)
 
-	err = txStruct.HDel(hash1, f1)
-	if err != nil {
-		t.FailNow()
+	// routing
+	for i, addr := range addrs {
+		c := client.New(addr, client.Option{})
+		entries, err := c.Scan(mondis.ScanOption{Limit: 10})
+		c.Close()
+		assert.Assert(t, err == nil)
+		var got []string
+		for _, entry := range entries {
+			got = append(got, string(entry.Key))
+		}
+		expected := [][]string{{"a1", "g9"}, {"h", "k2", "o9"}, {"p1", "p2", "q1"}}[i]
+		assert.Assert(t, reflect.DeepEqual(got, expected), got)
 	}
-	n, err = txStruct.HLen(hash1)
-	if err != nil || n != 2 {
-		t.FailNow()
+	v, _, err := sc.Get([]byte("k2"))
+	assert.Assert(t, err == nil && string(v) == "vk2")
+	assert.Assert(t, sc.Delete([]byte("k2")) == nil)
+	exists, err := sc.Exists([]byte("k2"))
+	assert.Assert(t, err == nil && !exists)
+
+	// merged scans
+	scanKeys := func(option mondis.ScanOption) (got []string) {
+		entries, err := sc.Scan(option)
+		assert.Assert(t, err == nil, err)
+		for _, entry := range entries {
+			got = append(got, string(entry.Key))
+		}
+		return
 	}
-	err = txStruct.HSet(hash1, f1, v1)
-	if err != nil {
-		t.FailNow()
+	assert.Assert(t, reflect.DeepEqual(scanKeys(mondis.ScanOption{Limit: 10}), []string{"a1", "g9", "h", "o9", "p1", "p2", "q1"}))
+	assert.Assert(t, reflect.DeepEqual(scanKeys(mondis.ScanOption{Limit: 3, ProviderScanOption: mondis.ProviderScanOption{Offset: []byte("g")}}), []string{"g9", "h", "o9"}))
+	assert.Assert(t, reflect.DeepEqual(scanKeys(mondis.ScanOption{Limit: 3, ProviderScanOption: mondis.ProviderScanOption{Reverse: true, Offset: []byte("p1")}}), []string{"p1", "o9", "h"}))
+	assert.Assert(t, reflect.DeepEqual(scanKeys(mondis.ScanOption{Limit: 10, ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("p")}}), []string{"p1", "p2"}))
+
+	// single server txns
+	err = sc.Update(func(txn mondis.Txn) error {
+		err := txn.Set([]byte("p3"), []byte("vp3"), nil)
+		if err != nil {
+			return err
+		}
+		// another shard on the same server
+		return txn.Set([]byte("q2"), []byte("vq2"), nil)
+	})
+	assert.Assert(t, err == nil, err)
+	err = sc.View(func(txn mondis.Txn) error {
+		entries, err := txn.Scan(mondis.ScanOption{Limit: 10, ProviderScanOption: mondis.ProviderScanOption{Offset: []byte("p")}})
+		assert.Assert(t, err == nil && len(entries) == 5, entries)
+		_, err = txn.Scan(mondis.ScanOption{Limit: 10})
+		return err
+	})
+	assert.Assert(t, err == client.ErrCrossShard, err)
+	err = sc.Update(func(txn mondis.Txn) error {
+		err := txn.Set([]byte("a2"), []byte("va2"), nil)
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte("h2"), []byte("vh2"), nil)
+	})
+	assert.Assert(t, err == client.ErrCrossShard, err)
+	exists, err = sc.Exists([]byte("a2"))
+	assert.Assert(t, err == nil && !exists)
+}
+
+// dropProxy forwards connections to target, and drops the connection of the next request once armed
+type dropProxy struct {
+	ln    net.Listener
+	armed int32
+}
+
+func newDropProxy(t *testing.T, target string) *dropProxy {
+	ln, err := net.Listen("tcp", "localhost:0")
+	assert.Assert(t, err == nil)
+
+	p := &dropProxy{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go func() {
+				io.Copy(conn, upstream)
+				conn.Close()
+			}()
+			go func() {
+				defer upstream.Close()
+				defer conn.Close()
+				buf := make([]byte, 4096)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					// the request is read but never forwarded, so no response arrives
+					if atomic.CompareAndSwapInt32(&p.armed, 1, 0) {
+						return
+					}
+					if _, err = upstream.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return p
+}
+
+func (p *dropProxy) Addr() string {
+	return p.ln.Addr().String()
+}
+
+func (p *dropProxy) DropNext() {
+	atomic.StoreInt32(&p.armed, 1)
+}
+
+func (p *dropProxy) Close() {
+	p.ln.Close()
+}
+
+func TestReadRetries(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	proxy := newDropProxy(t, addr)
+	defer proxy.Close()
+
+	c := client.New(proxy.Addr(), client.Option{PoolSize: 2, ReadRetries: 2}).(*client.Client)
+	defer c.Close()
+
+	key := []byte("read retries")
+	assert.Assert(t, c.Set(key, []byte("v"), nil) == nil)
+
+	// reads are retried on another connection
+	proxy.DropNext()
+	v, _, err := c.Get(key)
+	assert.Assert(t, err == nil && string(v) == "v", err)
+	proxy.DropNext()
+	exists, err := c.Exists(key)
+	assert.Assert(t, err == nil && exists, err)
+	proxy.DropNext()
+	entries, err := c.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: key}, Limit: 1})
+	assert.Assert(t, err == nil && len(entries) == 1, err)
+	stats := c.Stats()
+	assert.Assert(t, stats.ReadRetries == 3 && stats.ReadFailures == 0, stats)
+
+	// writes fail fast
+	proxy.DropNext()
+	err = c.Set(key, []byte("v2"), nil)
+	assert.Assert(t, err != nil)
+	v, _, err = c.Get(key)
+	assert.Assert(t, err == nil && string(v) == "v", err)
+
+	// reads fail once retries are exhausted
+	noRetry := client.New(proxy.Addr(), client.Option{}).(*client.Client)
+	defer noRetry.Close()
+	proxy.DropNext()
+	_, _, err = noRetry.Get(key)
+	assert.Assert(t, err == qrpc.ErrConnAlreadyClosed, err)
+	stats = noRetry.Stats()
+	assert.Assert(t, stats.ReadRetries == 0 && stats.ReadFailures == 1, stats)
+}
+
+func TestUpdateRetry(t *testing.T) {
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	key := []byte("retry")
+	for _, maxRetries := range []int{0, 1} {
+		c := client.New(addr, client.Option{MaxRetries: maxRetries})
+
+		called := 0
+		err := c.Update(func(txn mondis.Txn) error {
+			called++
+			_, _, err := txn.Get(key)
+			if err != nil && err != kv.ErrKeyNotFound {
+				return err
+			}
+			if called == 1 {
+				// concurrent write to the key read by txn
+				err = c.Set(key, []byte("other"), nil)
+				if err != nil {
+					return err
+				}
+			}
+			return txn.Set(key, []byte("txn"), nil)
+		})
+
+		if maxRetries == 0 {
+			assert.Assert(t, err == kv.ErrTxnConflict && called == 1, err)
+		} else {
+			assert.Assert(t, err == nil && called == 2, err)
+			v, _, err := c.Get(key)
+			assert.Assert(t, err == nil && string(v) == "txn")
+		}
+		c.Close()
 	}
+}
 
-	err = txn.Commit()
-	if err != nil {
-		t.FailNow()
+// countingKVDB counts provider Get and slows it down so that concurrent requests overlap
+type countingKVDB struct {
+	mondis.KVDB
+	gets uint64
+}
+
+func (c *countingKVDB) Get(k []byte) ([]byte, mondis.VMetaResp, error) {
+	atomic.AddUint64(&c.gets, 1)
+	time.Sleep(time.Millisecond * 10)
+	return c.KVDB.Get(k)
+}
+
+func TestCoalesceReads(t *testing.T) {
+	kvdb := &countingKVDB{KVDB: provider.NewBadger()}
+	s := server.New(addr, kvdb, server.Option{CoalesceReads: true}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{PoolSize: 8})
+	defer c.Close()
+
+	key := []byte("hot")
+	err := c.Set(key, key, nil)
+	assert.Assert(t, err == nil)
+
+	n := 512
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _, err := c.Get(key)
+			if err == nil && !bytes.Equal(v, key) {
+				err = fmt.Errorf("got %q", v)
+			}
+			errs.add(err)
+		}()
 	}
+	wg.Wait()
+	errs.check(t)
 
-	txn = kvdb.NewTransaction(true)
-	txStruct = structure.New(txn, []byte("l"))
-	pairs, err := txStruct.HGetN(hash1, 3)
-	if err != nil {
-		t.FailNow()
+	gets := atomic.LoadUint64(&kvdb.gets)
+	stats := s.Stats()
+	assert.Assert(t, gets < uint64(n), gets)
+	assert.Assert(t, gets+stats.CoalescedReads == uint64(n), stats.CoalescedReads)
+}
+
+// BenchmarkCoalesceReads reads one key by 512 concurrent clients per op, with and without Option.CoalesceReads
+func BenchmarkCoalesceReads(b *testing.B) {
+	const clients = 512
+	for _, coalesce := range []bool{false, true} {
+		b.Run(fmt.Sprintf("coalesce=%v", coalesce), func(b *testing.B) {
+			os.RemoveAll(dataDir)
+			kvdb := &countingKVDB{KVDB: provider.NewBadger()}
+			s := server.New(addr, kvdb, server.Option{CoalesceReads: coalesce}, mondis.KVOption{Dir: dataDir})
+			go s.Start()
+			time.Sleep(time.Millisecond * 500)
+			defer s.Stop()
+
+			c := client.New(addr, client.Option{PoolSize: 8})
+			defer c.Close()
+			key := []byte("hot")
+			err := c.Set(key, key, nil)
+			assert.Assert(b, err == nil)
+
+			before := atomic.LoadUint64(&kvdb.gets)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var (
+					wg   sync.WaitGroup
+					errs goErrs
+				)
+				for j := 0; j < clients; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						v, _, err := c.Get(key)
+						if err == nil && !bytes.Equal(v, key) {
+							err = fmt.Errorf("got %q", v)
+						}
+						errs.add(err)
+					}()
+				}
+				wg.Wait()
+				errs.check(b)
+			}
+			b.StopTimer()
+
+			gets := atomic.LoadUint64(&kvdb.gets) - before
+			reads := uint64(b.N * clients)
+			b.Logf("%d provider gets for %d reads", gets, reads)
+			if coalesce {
+				assert.Assert(b, gets < reads, gets)
+			} else {
+				assert.Assert(b, gets == reads, gets)
+			}
+		})
 	}
-	pairsDesc, err := txStruct.HGetNDesc(hash1, 3)
-	if err != nil {
-		t.FailNow()
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	for _, rejectCommit := range []bool{false, true} {
+		option := server.Option{EnableMaintenanceCmd: true, RejectCommitInMaintenance: rejectCommit}
+		s := server.New(addr, provider.NewBadger(), option, mondis.KVOption{Dir: dataDir})
+		go s.Start()
+		time.Sleep(time.Millisecond * 500)
+
+		c := client.New(addr, client.Option{})
+		key := []byte("maintenance")
+		err := c.Set(key, key, nil)
+		assert.Assert(t, err == nil)
+
+		err = c.(*client.Client).SetMaintenanceMode(true)
+		assert.Assert(t, err == nil && s.Stats().Maintenance)
+
+		// writes are rejected
+		err = c.Set(key, key, nil)
+		assert.Assert(t, err == server.ErrMaintenance)
+		err = c.Delete(key)
+		assert.Assert(t, err == server.ErrMaintenance)
+		err = c.Update(func(txn mondis.Txn) error {
+			return txn.Set(key, key, nil)
+		})
+		assert.Assert(t, err == server.ErrMaintenance)
+
+		// reads are served
+		v, _, err := c.Get(key)
+		assert.Assert(t, err == nil && bytes.Equal(v, key), err)
+		err = c.View(func(txn mondis.Txn) error {
+			_, _, err := txn.Get(key)
+			return err
+		})
+		assert.Assert(t, err == nil)
+
+		err = c.(*client.Client).SetMaintenanceMode(false)
+		assert.Assert(t, err == nil && !s.Stats().Maintenance)
+
+		// update transaction started before maintenance mode
+		err = c.Update(func(txn mondis.Txn) error {
+			err := txn.Set(key, []byte("txn"), nil)
+			if err != nil {
+				return err
+			}
+			s.SetMaintenanceMode(true)
+			return nil
+		})
+		if rejectCommit {
+			assert.Assert(t, err == server.ErrMaintenance)
+		} else {
+			assert.Assert(t, err == nil)
+		}
+		s.SetMaintenanceMode(false)
+
+		err = c.Set(key, key, nil)
+		assert.Assert(t, err == nil)
+
+		c.Close()
+		s.Stop()
 	}
+}
 
-	if len(pairs) != 3 || len(pairsDesc) != 3 {
-		t.FailNow()
+func TestBackupRestore(t *testing.T) {
+	os.RemoveAll(dataDir)
+	option := server.Option{EnableBackupCmds: true}
+	s := server.New(addr, provider.NewBadger(), option, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	c := client.New(addr, client.Option{}).(*client.Client)
+
+	// large enough to span several frames
+	value := bytes.Repeat([]byte("v"), server.BackupChunkSize/4)
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("backup%d", i))
+	}
+	for i := 0; i < 10; i++ {
+		err := c.Set(key(i), value, nil)
+		assert.Assert(t, err == nil)
+	}
+	var full, incremental bytes.Buffer
+	version, err := c.Backup(&full, 0)
+	assert.Assert(t, err == nil && version > 0 && full.Len() > server.BackupChunkSize, err)
+	for i := 10; i < 20; i++ {
+		err := c.Set(key(i), value, nil)
+		assert.Assert(t, err == nil)
 	}
+	_, err = c.Backup(&incremental, version)
+	assert.Assert(t, err == nil && incremental.Len() > 0, err)
 
-	for i, p := range pairs {
-		pdesc := pairsDesc[2-i]
-		if !(bytes.Equal(p.Field, pdesc.Field) && bytes.Equal(p.Value, pdesc.Value)) {
-			t.FailNow()
+	// restore is only accepted in maintenance mode
+	err = c.Restore(bytes.NewReader(full.Bytes()))
+	assert.Assert(t, err == server.ErrNotInMaintenance, err)
+	c.Close()
+	s.Stop()
+
+	os.RemoveAll(dataDir)
+	s = server.New(addr, provider.NewBadger(), option, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+	c = client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+
+	s.SetMaintenanceMode(true)
+	// a backup that can't be read to the end fails the restore
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(full.Bytes()[:100])
+		pw.CloseWithError(io.ErrNoProgress)
+	}()
+	err = c.Restore(pr)
+	assert.Assert(t, err == io.ErrNoProgress, err)
+
+	err = c.Restore(bytes.NewReader(full.Bytes()))
+	assert.Assert(t, err == nil, err)
+	_, _, err = c.Get(key(10))
+	assert.Assert(t, err == kv.ErrKeyNotFound)
+	err = c.Restore(bytes.NewReader(incremental.Bytes()))
+	assert.Assert(t, err == nil, err)
+	for i := 0; i < 20; i++ {
+		v, _, err := c.Get(key(i))
+		assert.Assert(t, err == nil && bytes.Equal(v, value), err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{})
+	defer c.Close()
+	cc := c.(*client.Client)
+
+	key := []byte("ttl")
+	err := c.Set(key, key, &mondis.VMetaReq{TTL: time.Hour, Tag: 7})
+	assert.Assert(t, err == nil)
+
+	_, meta, err := c.Get(key)
+	assert.Assert(t, err == nil && meta.Tag == 7 && meta.ExpiresAt > uint64(time.Now().Unix()), meta, err)
+	ttl, err := cc.TTL(key)
+	assert.Assert(t, err == nil && ttl > 59*time.Minute && ttl <= time.Hour, ttl, err)
+
+	err = c.View(func(txn mondis.Txn) error {
+		_, txnMeta, err := txn.Get(key)
+		assert.Assert(t, err == nil && txnMeta.Tag == meta.Tag && txnMeta.ExpiresAt == meta.ExpiresAt, txnMeta, err)
+		ttl, err := txn.(*client.Txn).TTL(key)
+		assert.Assert(t, err == nil && ttl > 59*time.Minute, ttl, err)
+		return nil
+	})
+	assert.Assert(t, err == nil)
+
+	noTTLKey := []byte("no ttl")
+	assert.Assert(t, c.Set(noTTLKey, noTTLKey, nil) == nil)
+	_, err = cc.TTL(noTTLKey)
+	assert.Assert(t, err == client.ErrNoTTL, err)
+	_, err = cc.TTL([]byte("missing"))
+	assert.Assert(t, err == kv.ErrKeyNotFound, err)
+}
+
+func TestListTxns(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{EnableListTxnsCmd: true}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{})
+	defer c.Close()
+	cc := c.(*client.Client)
+
+	txns, err := cc.ListTxns()
+	assert.Assert(t, err == nil && len(txns) == 0, txns, err)
+
+	key := []byte("list txns")
+	err = c.Update(func(txn mondis.Txn) error {
+		err := txn.Set(key, key, nil)
+		if err != nil {
+			return err
+		}
+		_, _, err = txn.Get(key)
+		if err != nil {
+			return err
+		}
+
+		txns, err := cc.ListTxns()
+		assert.Assert(t, err == nil && len(txns) == 1, txns, err)
+		assert.Assert(t, txns[0].Ops == 2 && txns[0].RemoteAddr != "", txns[0])
+		assert.Assert(t, !txns[0].StartTime.After(time.Now()))
+		assert.Assert(t, len(s.ListTxns()) == 1)
+		return nil
+	})
+	assert.Assert(t, err == nil)
+
+	txns, err = cc.ListTxns()
+	assert.Assert(t, err == nil && len(txns) == 0, txns, err)
+}
+
+func TestListNodes(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	const ttl = time.Millisecond * 300
+	option := server.Option{NodeRegistry: &meta.NodeRegistryOption{ID: "s1", Roles: []string{"owner"}, TTL: ttl}}
+	s := server.New(addr, kvdb, option, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+
+	nodes, err := c.ListNodes()
+	assert.Assert(t, err == nil && len(nodes) == 1, nodes, err)
+	assert.Assert(t, nodes[0].ID == "s1" && nodes[0].Roles[0] == "owner" && nodes[0].PID == os.Getpid() && nodes[0].TTL == ttl, nodes[0])
+	epoch := nodes[0].Epoch
+
+	// a replica crashes right after registering
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		replica := &model.NodeInfo{ID: "r1", Roles: []string{"replica"}, Heartbeat: time.Now(), TTL: ttl}
+		replica.Epoch, err = m.GenNodeEpoch()
+		if err != nil {
+			return
+		}
+		err = m.SetNode(replica)
+		return
+	})
+	assert.Assert(t, err == nil)
+	nodes, err = c.ListNodes()
+	assert.Assert(t, err == nil && len(nodes) == 2 && nodes[1].ID == "r1" && nodes[1].Epoch > epoch, nodes, err)
+
+	// only s1 is kept alive by heartbeats
+	time.Sleep(ttl * 2)
+	nodes, err = c.ListNodes()
+	assert.Assert(t, err == nil && len(nodes) == 1 && nodes[0].ID == "s1" && nodes[0].Epoch == epoch, nodes, err)
+
+	// the replica registers again with a greater epoch
+	r1 := meta.NewNodeRegistry(kvdb, meta.NodeRegistryOption{ID: "r1", Roles: []string{"replica"}, TTL: ttl})
+	node, err := r1.Register()
+	assert.Assert(t, err == nil && node.Epoch > epoch+1, node, err)
+	defer r1.Close()
+	nodes, err = c.ListNodes()
+	assert.Assert(t, err == nil && len(nodes) == 2 && nodes[1].ID == "r1" && nodes[1].Epoch == node.Epoch, nodes, err)
+}
+
+func TestListTxnsDisabled(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{})
+	defer c.Close()
+
+	_, err := c.(*client.Client).ListTxns()
+	assert.Assert(t, err != nil)
+}
+
+func TestDrain(t *testing.T) {
+	os.RemoveAll(dataDir)
+	drainTimeout := time.Second * 5
+	s := server.New(addr, provider.NewBadger(), server.Option{DrainTimeout: drainTimeout}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+
+	c := client.New(addr, client.Option{})
+	c2 := client.New(addr, client.Option{})
+
+	key := []byte("drain")
+	stopCh := make(chan error, 1)
+	start := time.Now()
+	err := c.Update(func(txn mondis.Txn) error {
+		err := txn.Set(key, key, nil)
+		if err != nil {
+			return err
+		}
+		assert.Assert(t, s.ActiveTxns() == 1)
+
+		go func() {
+			stopCh <- s.Stop()
+		}()
+
+		// new transactions are rejected once draining
+		for {
+			err = c2.Update(func(txn mondis.Txn) error {
+				return txn.Set(key, []byte("rejected"), nil)
+			})
+			if err == server.ErrDraining {
+				break
+			}
+			assert.Assert(t, err == nil, err)
+			time.Sleep(time.Millisecond * 10)
+		}
+		assert.Assert(t, s.ActiveTxns() == 1)
+		return nil
+	})
+	assert.Assert(t, err == nil, err)
+
+	err = <-stopCh
+	assert.Assert(t, err == nil && s.ActiveTxns() == 0)
+	assert.Assert(t, time.Since(start) < drainTimeout)
+	c.Close()
+	c2.Close()
+
+	// the drained transaction is committed
+	s = server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c = client.New(addr, client.Option{})
+	defer c.Close()
+	v, _, err := c.Get(key)
+	assert.Assert(t, err == nil && bytes.Equal(v, key), err)
+}
+
+func TestTxnIdleTimeout(t *testing.T) {
+	os.RemoveAll(dataDir)
+	idleTimeout := time.Millisecond * 200
+	s := server.New(addr, provider.NewBadger(), server.Option{TxnIdleTimeout: idleTimeout}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{})
+	defer c.Close()
+
+	key := []byte("idle")
+	err := c.Update(func(txn mondis.Txn) error {
+		err := txn.Set(key, key, nil)
+		assert.Assert(t, err == nil && s.ActiveTxns() == 1, err)
+
+		// busy but not idle for longer than idleTimeout
+		for i := 0; i < 3; i++ {
+			time.Sleep(idleTimeout / 2)
+			_, _, err = txn.Get(key)
+			assert.Assert(t, err == nil, err)
 		}
+
+		time.Sleep(idleTimeout * 2)
+		assert.Assert(t, s.ActiveTxns() == 0)
+		_, _, err = txn.Get(key)
+		assert.Assert(t, err == server.ErrTxnTimedOut, err)
+		err = txn.Set(key, key, nil)
+		assert.Assert(t, err == server.ErrTxnTimedOut, err)
+		return nil
+	})
+	assert.Assert(t, err == server.ErrTxnTimedOut, err)
+
+	// the connection is still usable
+	_, _, err = c.Get(key)
+	assert.Assert(t, err == kv.ErrKeyNotFound, err)
+	assert.Assert(t, c.Set(key, key, nil) == nil)
+
+	// txn is discarded when connection drops
+	c2 := client.New(addr, client.Option{})
+	err = c2.Update(func(txn mondis.Txn) error {
+		err := txn.Set(key, []byte("dropped"), nil)
+		assert.Assert(t, err == nil && s.ActiveTxns() == 1, err)
+		c2.Close()
+		return nil
+	})
+	assert.Assert(t, err != nil)
+	for i := 0; i < 100 && s.ActiveTxns() > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
 	}
+	assert.Assert(t, s.ActiveTxns() == 0)
+	v, _, err := c.Get(key)
+	assert.Assert(t, err == nil && bytes.Equal(v, key), err)
 }
 
-func TestWB(t *testing.T) {
+var errCloseFailed = errors.New("close failed")
+
+// failingCloseKVDB closes the db but reports a failure
+type failingCloseKVDB struct {
+	mondis.KVDB
+}
+
+func (f *failingCloseKVDB) Close() error {
+	f.KVDB.Close()
+	return errCloseFailed
+}
+
+func TestStopOnFailure(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, &failingCloseKVDB{KVDB: provider.NewBadger()}, server.Option{MetricsAddr: metricsAddr}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	c := client.New(addr, client.Option{})
+	defer c.Close()
+	assert.Assert(t, c.Set([]byte("k"), []byte("v"), nil) == nil)
+
+	// the listeners are closed anyway
+	assert.Assert(t, s.Stop() == errCloseFailed)
+	_, err := net.Dial("tcp", addr)
+	assert.Assert(t, err != nil)
+	_, err = net.Dial("tcp", metricsAddr)
+	assert.Assert(t, err != nil)
+}
+
+func TestSnapshot(t *testing.T) {
+	os.RemoveAll(dataDir)
+	ttl := time.Millisecond * 300
+	s := server.New(addr, provider.NewBadger(), server.Option{SnapshotTTL: ttl}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+	c := client.New(addr, client.Option{PoolSize: 2}).(*client.Client)
+	defer c.Close()
 
-	providers := []func() mondis.KVDB{
-		provider.NewBadger, provider.NewLevelDB,
+	keys := [][]byte{[]byte("snapshot1"), []byte("snapshot2")}
+	for _, key := range keys {
+		assert.Assert(t, c.Set(key, []byte("old"), nil) == nil)
 	}
+	snapshot, err := c.NewSnapshot()
+	assert.Assert(t, err == nil && s.ActiveSnapshots() == 1)
+	assert.Assert(t, c.Set(keys[0], []byte("new"), nil) == nil)
+	assert.Assert(t, c.Delete(keys[1]) == nil)
 
-	for _, provider := range providers {
-		{
-			os.RemoveAll(dataDir)
-			kvdb := provider()
-			err := kvdb.Open(mondis.KVOption{Dir: dataDir})
-			assert.Assert(t, err == nil)
+	// reads through any connection see the snapshot, and keep it from expiring
+	for i := 0; i < 4; i++ {
+		v, _, err := snapshot.Get(keys[0])
+		assert.Assert(t, err == nil && string(v) == "old", err)
+		entries, err := snapshot.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("snapshot")}, Limit: 10})
+		assert.Assert(t, err == nil && len(entries) == 2 && string(entries[1].Value) == "old", err)
+		time.Sleep(ttl / 2)
+	}
+	v, _, err := c.Get(keys[0])
+	assert.Assert(t, err == nil && string(v) == "new")
 
-			b := kvdb.WriteBatch()
-			k := []byte("k")
-			v := []byte("v")
-			err = b.Set(k, v)
-			assert.Assert(t, err == nil)
-			err = b.Commit()
-			assert.Assert(t, err == nil)
+	assert.Assert(t, snapshot.Release() == nil && s.ActiveSnapshots() == 0)
+	assert.Assert(t, snapshot.Release() == server.ErrSnapshotNotFound)
+	_, _, err = snapshot.Get(keys[0])
+	assert.Assert(t, err == server.ErrSnapshotNotFound)
 
-			vget, _, err := kvdb.Get(k)
-			assert.Assert(t, err == nil && reflect.DeepEqual(vget, v))
+	// a leaked snapshot expires
+	snapshot, err = c.NewSnapshot()
+	assert.Assert(t, err == nil)
+	time.Sleep(ttl * 2)
+	assert.Assert(t, s.ActiveSnapshots() == 0)
+	_, err = snapshot.Scan(mondis.ScanOption{Limit: 1})
+	assert.Assert(t, err == server.ErrSnapshotNotFound, err)
+}
 
-			assert.Assert(t, kvdb.Close() == nil)
+func TestPreCommitHook(t *testing.T) {
+	os.RemoveAll(dataDir)
+	var (
+		mu        sync.Mutex
+		committed [][]string
+	)
+	hook := func(mutations []server.MutationView) error {
+		var keys []string
+		for _, m := range mutations {
+			key := string(m.Key())
+			if strings.HasPrefix(key, "forbidden:") {
+				return fmt.Errorf("prefix of %s not registered", key)
+			}
+			if key == "panic" {
+				panic("bad hook")
+			}
+			if m.Op() == server.MutationDelete {
+				key = "-" + key
+			}
+			keys = append(keys, key)
+		}
+		mu.Lock()
+		committed = append(committed, keys)
+		mu.Unlock()
+		return nil
+	}
+	s := server.New(addr, provider.NewBadger(), server.Option{PreCommitHook: hook}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+	c := client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+
+	isRejected := func(err error, msg string) bool {
+		rejected, ok := err.(*server.PreCommitError)
+		return ok && rejected.Msg == msg
+	}
+
+	// one-shot writes
+	assert.Assert(t, c.Set([]byte("svc:a"), []byte("1"), nil) == nil)
+	err := c.Set([]byte("forbidden:a"), []byte("1"), nil)
+	assert.Assert(t, isRejected(err, "prefix of forbidden:a not registered"), err)
+	err = c.Delete([]byte("forbidden:a"))
+	assert.Assert(t, isRejected(err, "prefix of forbidden:a not registered"), err)
+	_, err = c.Incr([]byte("forbidden:n"), 1)
+	assert.Assert(t, isRejected(err, "prefix of forbidden:n not registered"), err)
+	_, err = c.CompareAndSwapAbsent([]byte("forbidden:a"), []byte("1"))
+	assert.Assert(t, isRejected(err, "prefix of forbidden:a not registered"), err)
+	err = c.Set([]byte("panic"), []byte("1"), nil)
+	assert.Assert(t, isRejected(err, "hook panic: bad hook"), err)
+	_, _, err = c.Get([]byte("forbidden:a"))
+	assert.Assert(t, err == kv.ErrKeyNotFound)
+
+	// transactions
+	err = c.Update(func(txn mondis.Txn) error {
+		if err := txn.Set([]byte("svc:b"), []byte("1"), nil); err != nil {
+			return err
+		}
+		return txn.Set([]byte("forbidden:b"), []byte("1"), nil)
+	})
+	assert.Assert(t, isRejected(err, "prefix of forbidden:b not registered"), err)
+	_, _, err = c.Get([]byte("svc:b"))
+	assert.Assert(t, err == kv.ErrKeyNotFound)
+
+	err = c.Update(func(txn mondis.Txn) error {
+		for _, key := range []string{"svc:c", "svc:d", "svc:e"} {
+			if err := txn.Set([]byte(key), []byte(key), nil); err != nil {
+				return err
+			}
 		}
+		return txn.Delete([]byte("svc:a"))
+	})
+	assert.Assert(t, err == nil, err)
+	for _, key := range []string{"svc:c", "svc:d", "svc:e"} {
+		v, _, err := c.Get([]byte(key))
+		assert.Assert(t, err == nil && string(v) == key, key)
 	}
 
+	mu.Lock()
+	assert.DeepEqual(t, committed, [][]string{{"svc:a"}, {"svc:c", "svc:d", "svc:e", "-svc:a"}})
+	mu.Unlock()
+}
+
+func TestValidators(t *testing.T) {
+	os.RemoveAll(dataDir)
+	keyValidator := func(k []byte) error {
+		if !bytes.HasPrefix(k, []byte("tenant1:")) {
+			return fmt.Errorf("tenant prefix required")
+		}
+		return nil
+	}
+	valueValidator := func(v []byte) error {
+		if len(v) == 0 {
+			return fmt.Errorf("empty value")
+		}
+		return nil
+	}
+	s := server.New(addr, provider.NewBadger(), server.Option{KeyValidator: keyValidator, ValueValidator: valueValidator}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+	c := client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+
+	isInvalid := func(err error, msg string) bool {
+		invalid, ok := err.(*server.ValidationError)
+		return ok && invalid.Msg == msg && invalid.Unwrap() == server.ErrValidationFailed
+	}
+
+	// one-shot writes
+	assert.Assert(t, c.Set([]byte("tenant1:a"), []byte("1"), nil) == nil)
+	err := c.Set([]byte("a"), []byte("1"), nil)
+	assert.Assert(t, isInvalid(err, "key: tenant prefix required"), err)
+	err = c.Set([]byte("tenant1:b"), nil, nil)
+	assert.Assert(t, isInvalid(err, "value: empty value"), err)
+	_, _, err = c.Get([]byte("tenant1:b"))
+	assert.Assert(t, err == kv.ErrKeyNotFound)
+
+	// sets in txn are rejected one by one, the txn goes on
+	err = c.Update(func(txn mondis.Txn) error {
+		err := txn.Set([]byte("tenant1:c"), []byte("1"), nil)
+		assert.Assert(t, err == nil, err)
+		err = txn.Set([]byte("tenant2:c"), []byte("1"), nil)
+		assert.Assert(t, isInvalid(err, "key: tenant prefix required"), err)
+		return nil
+	})
+	assert.Assert(t, err == nil, err)
+	exists, err := c.Exists([]byte("tenant1:c"))
+	assert.Assert(t, err == nil && exists)
+	exists, err = c.Exists([]byte("tenant2:c"))
+	assert.Assert(t, err == nil && !exists)
+	err = c.Update(func(txn mondis.Txn) error {
+		return txn.Set([]byte("x"), []byte("1"), nil)
+	})
+	assert.Assert(t, isInvalid(err, "key: tenant prefix required"), err)
+
+	// writes of Inc, Incr and CAS are checked too
+	_, err = c.Inc([]byte("x"), 1)
+	assert.Assert(t, isInvalid(err, "key: tenant prefix required"), err)
+	_, err = c.Incr([]byte("x"), 1)
+	assert.Assert(t, isInvalid(err, "key: tenant prefix required"), err)
+	n, err := c.Inc([]byte("tenant1:n"), 1)
+	assert.Assert(t, err == nil && n == 1, n, err)
+	_, _, err = c.CompareAndSet([]byte("x"), nil, []byte("1"))
+	assert.Assert(t, isInvalid(err, "key: tenant prefix required"), err)
+	_, _, err = c.CompareAndSet([]byte("tenant1:a"), []byte("1"), nil)
+	assert.Assert(t, isInvalid(err, "value: empty value"), err)
+	v, _, err := c.Get([]byte("tenant1:a"))
+	assert.Assert(t, err == nil && string(v) == "1", v, err)
+	err = c.Update(func(txn mondis.Txn) error {
+		_, err := txn.(*client.Txn).Inc([]byte("x"), 1)
+		assert.Assert(t, isInvalid(err, "key: tenant prefix required"), err)
+		_, _, err = txn.(*client.Txn).CompareAndSet([]byte("tenant1:a"), []byte("1"), nil)
+		assert.Assert(t, isInvalid(err, "value: empty value"), err)
+		return nil
+	})
+	assert.Assert(t, err == nil, err)
+	exists, err = c.Exists([]byte("x"))
+	assert.Assert(t, err == nil && !exists)
+}
+
+// slowScanKVDB slows down provider scans in and outside transactions by delay nanoseconds
+type slowScanKVDB struct {
+	mondis.KVDB
+	delay int64
+}
+
+type slowScanTxn struct {
+	mondis.ProviderTxn
+	kvdb *slowScanKVDB
+}
+
+func (s *slowScanKVDB) sleep() {
+	time.Sleep(time.Duration(atomic.LoadInt64(&s.delay)))
+}
+
+func (s *slowScanKVDB) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) error {
+	s.sleep()
+	return s.KVDB.Scan(option, fn)
+}
+
+func (s *slowScanKVDB) NewTransaction(update bool) mondis.ProviderTxn {
+	return &slowScanTxn{ProviderTxn: s.KVDB.NewTransaction(update), kvdb: s}
+}
+
+func (t *slowScanTxn) Scan(option mondis.ProviderScanOption, fn func(key []byte, value []byte, meta mondis.VMetaResp) bool) error {
+	t.kvdb.sleep()
+	return t.ProviderTxn.Scan(option, fn)
+}
+
+func TestSlowLog(t *testing.T) {
+	os.RemoveAll(dataDir)
+	slowLog := slowlog.New(slowlog.Option{Threshold: 50 * time.Millisecond, Capacity: 2, MaxKeyLen: 4, CaptureStack: true})
+	kvdb := &slowScanKVDB{KVDB: provider.NewBadger(), delay: int64(100 * time.Millisecond)}
+	s := server.New(addr, kvdb, server.Option{SlowLog: slowLog}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+	c := client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+
+	records, err := c.SlowLog(0)
+	assert.Assert(t, err == nil && len(records) == 0)
+
+	for _, k := range []string{"slow:1", "slow:2", "slow:3"} {
+		assert.Assert(t, c.Set([]byte(k), []byte(k), nil) == nil)
+	}
+	entries, err := c.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("slow:")}, Limit: 2})
+	assert.Assert(t, err == nil && len(entries) == 2)
+
+	records, err = c.SlowLog(0)
+	assert.Assert(t, err == nil && len(records) == 1, err)
+	r := records[0]
+	assert.Assert(t, r.Kind == server.SlowKindScan && r.Returned == 2 && r.TxnAge == 0, r)
+	assert.Assert(t, r.Duration >= 100*time.Millisecond && time.Since(r.Time) < time.Minute, r)
+	// prefix is truncated to MaxKeyLen
+	assert.Assert(t, strings.Contains(r.Option, `prefix="slow"...`) && strings.Contains(r.Option, "limit=2"), r.Option)
+	assert.Assert(t, r.Namespace != "" && strings.Contains(r.Stack, "goroutine"), r)
+
+	err = c.View(func(txn mondis.Txn) error {
+		// server side txn starts with the first operation
+		_, err := txn.Exists([]byte("slow:1"))
+		if err != nil {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+		_, err = txn.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("slow:")}, Limit: 10})
+		return err
+	})
+	assert.Assert(t, err == nil)
+	records, err = c.SlowLog(0)
+	assert.Assert(t, err == nil && len(records) == 2, err)
+	r = records[0]
+	assert.Assert(t, r.Kind == server.SlowKindTxnScan && r.Returned == 3 && r.ID == records[1].ID+1, r)
+	assert.Assert(t, r.TxnAge >= r.Duration+50*time.Millisecond, r)
+
+	// the oldest is overwritten by the third one
+	_, err = c.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("none")}, Limit: 1})
+	assert.Assert(t, err == nil)
+	records, err = c.SlowLog(1)
+	assert.Assert(t, err == nil && len(records) == 1 && records[0].Returned == 0 && records[0].ID == 3, records)
+	records, err = c.SlowLog(0)
+	assert.Assert(t, err == nil && len(records) == 2 && records[1].Kind == server.SlowKindTxnScan, records)
+
+	// fast scans are not recorded
+	atomic.StoreInt64(&kvdb.delay, 0)
+	_, err = c.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("slow:")}, Limit: 1})
+	assert.Assert(t, err == nil)
+	records, err = c.SlowLog(0)
+	assert.Assert(t, err == nil && records[0].ID == 3)
+
+	// document finds share the log
+	db := document.NewDB(kvdb, document.DBOption{SlowLog: slowLog})
+	defer db.Close()
+	coll, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+	for i := 0; i < 3; i++ {
+		_, err = coll.InsertOne(bson.M{"i": i}, nil)
+		assert.Assert(t, err == nil)
+	}
+	atomic.StoreInt64(&kvdb.delay, int64(100*time.Millisecond))
+	_, docs, err := coll.Find(bson.M{"i": bson.M{"$gt": 0}}, nil)
+	assert.Assert(t, err == nil && len(docs) == 2)
+	records = slowLog.Recent(1)
+	r = records[0]
+	assert.Assert(t, r.Kind == document.SlowKindFind && r.Namespace == "c" && r.Returned == 2 && r.Skipped == 1, r)
+	assert.Assert(t, strings.Contains(r.Option, "filter="), r.Option)
+}
+
+type recordingAccessLogger struct {
+	mu      sync.Mutex
+	entries []server.AccessEntry
+	txns    []server.TxnAccessEntry
+}
+
+func (l *recordingAccessLogger) LogAccess(entry *server.AccessEntry) {
+	e := *entry
+	e.Key = append([]byte(nil), entry.Key...)
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+}
+
+func (l *recordingAccessLogger) LogTxnAccess(entry *server.TxnAccessEntry) {
+	l.mu.Lock()
+	l.txns = append(l.txns, *entry)
+	l.mu.Unlock()
+}
+
+// logged waits for n entries and m txns, since they're reported after the response is written
+func (l *recordingAccessLogger) logged(n, m int) (entries []server.AccessEntry, txns []server.TxnAccessEntry) {
+	for i := 0; i < 100; i++ {
+		l.mu.Lock()
+		entries, txns = append(entries[:0], l.entries...), append(txns[:0], l.txns...)
+		l.mu.Unlock()
+		if len(entries) >= n && len(txns) >= m {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return
+}
+
+func TestAccessLog(t *testing.T) {
+	os.RemoveAll(dataDir)
+	accessLogger := &recordingAccessLogger{}
+	// everything is slow so that the zap path is taken as well
+	option := server.Option{AccessLogger: accessLogger, SlowThreshold: time.Nanosecond}
+	s := server.New(addr, provider.NewBadger(), option, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+	c := client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+
+	bigKey := bytes.Repeat([]byte("k"), 1000)
+	assert.Assert(t, c.Set(bigKey, []byte("v"), nil) == nil)
+	_, _, err := c.Get([]byte("access:none"))
+	assert.Assert(t, err == kv.ErrKeyNotFound)
+	_, err = c.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("access:")}, Limit: 1})
+	assert.Assert(t, err == nil)
+
+	entries, _ := accessLogger.logged(3, 0)
+	assert.Assert(t, len(entries) == 3, entries)
+	assert.Assert(t, entries[0].Cmd == server.SetCmd && bytes.Equal(entries[0].Key, bigKey) && entries[0].PayloadSize > len(bigKey), entries[0])
+	assert.Assert(t, entries[0].Duration > 0 && entries[0].RemoteAddr != "", entries[0])
+	assert.Assert(t, entries[1].Cmd == server.GetCmd && string(entries[1].Key) == "access:none", entries[1])
+	assert.Assert(t, entries[2].Cmd == server.ScanCmd && string(entries[2].Key) == "access:", entries[2])
+
+	start := time.Now()
+	err = c.Update(func(txn mondis.Txn) error {
+		for _, k := range []string{"access:1", "access:2", "access:1"} {
+			err := txn.Set([]byte(k), []byte(k), nil)
+			if err != nil {
+				return err
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		_, err := txn.Scan(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("access:")}, Limit: 10})
+		return err
+	})
+	assert.Assert(t, err == nil)
+	err = c.View(func(txn mondis.Txn) error {
+		_, _, err := txn.Get([]byte("access:3"))
+		if err == kv.ErrKeyNotFound {
+			err = nil
+		}
+		return err
+	})
+	assert.Assert(t, err == nil)
+
+	// frames of transactions are not reported one by one
+	entries, txns := accessLogger.logged(3, 2)
+	assert.Assert(t, len(entries) == 3 && len(txns) == 2, entries, txns)
+	// 3 sets, 1 scan and commit
+	assert.Assert(t, txns[0].Frames == 5 && txns[0].Keys == 2 && txns[0].Committed, txns[0])
+	assert.Assert(t, txns[0].Duration >= 50*time.Millisecond && txns[0].Duration <= time.Since(start), txns[0])
+	// a get and discard
+	assert.Assert(t, txns[1].Frames == 2 && txns[1].Keys == 1 && !txns[1].Committed && txns[1].ID > txns[0].ID, txns[1])
+}
+
+func TestWatch(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{EnableWatchCmd: true, WatchMaxUnacked: 16}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+	c := client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+
+	events := make(chan mondis.WatchEvent, 100)
+	cancel, err := c.Watch([]byte("w:"), func(ev mondis.WatchEvent) {
+		events <- ev
+	})
+	assert.Assert(t, err == nil)
+
+	next := func() (ev mondis.WatchEvent) {
+		select {
+		case ev = <-events:
+		case <-time.After(time.Second * 5):
+			t.Fatal("no event")
+		}
+		return
+	}
+
+	assert.Assert(t, c.Set([]byte("x:a"), []byte("1"), nil) == nil)
+	assert.Assert(t, c.Set([]byte("w:a"), []byte("1"), nil) == nil)
+	assert.Assert(t, c.Delete([]byte("w:a")) == nil)
+	_, err = c.Incr([]byte("w:n"), 2)
+	assert.Assert(t, err == nil)
+	err = c.Update(func(txn mondis.Txn) error {
+		err := txn.Set([]byte("w:b"), []byte("2"), nil)
+		if err != nil {
+			return err
+		}
+		return txn.Delete([]byte("w:b"))
+	})
+	assert.Assert(t, err == nil)
+
+	var seq uint64
+	for _, expected := range []mondis.WatchEvent{
+		{Key: []byte("w:a"), Value: []byte("1")},
+		{Key: []byte("w:a"), Deleted: true},
+		{Key: []byte("w:n"), Value: numeric.Encode2Binary(2, nil)},
+		{Key: []byte("w:b"), Value: []byte("2")},
+		{Key: []byte("w:b"), Deleted: true},
+	} {
+		ev := next()
+		assert.Assert(t, ev.Err == nil && ev.Seq > seq, ev)
+		assert.Assert(t, bytes.Equal(ev.Key, expected.Key) && bytes.Equal(ev.Value, expected.Value) && ev.Deleted == expected.Deleted, ev)
+		seq = ev.Seq
+	}
+
+	// nothing after cancel
+	cancel()
+	assert.Assert(t, c.Set([]byte("w:c"), []byte("1"), nil) == nil)
+	select {
+	case ev := <-events:
+		t.Fatal("unexpected event", ev)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	// a watcher not keeping up is dropped
+	block := make(chan struct{})
+	var lastErr atomic.Value
+	cancel, err = c.Watch([]byte("w:"), func(ev mondis.WatchEvent) {
+		if ev.Err != nil {
+			lastErr.Store(ev.Err)
+			return
+		}
+		<-block
+	})
+	assert.Assert(t, err == nil)
+	defer cancel()
+	for i := 0; i < 40; i++ {
+		assert.Assert(t, c.Set([]byte("w:d"), []byte("1"), nil) == nil)
+	}
+	close(block)
+	for i := 0; i < 50 && lastErr.Load() == nil; i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	assert.Assert(t, lastErr.Load() == server.ErrWatcherTooSlow, lastErr.Load())
+
+	// disabled
+	s2 := server.New("localhost:8104", provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir + "_nowatch"})
+	go s2.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer os.RemoveAll(dataDir + "_nowatch")
+	defer s2.Stop()
+	c2 := client.New("localhost:8104", client.Option{}).(*client.Client)
+	defer c2.Close()
+	_, err = c2.Watch([]byte("w:"), func(mondis.WatchEvent) {})
+	assert.Assert(t, err != nil)
+}
+
+func TestIncr(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{PoolSize: 4})
+	defer c.Close()
+
+	key := []byte("counter")
+	n, err := c.(*client.Client).Incr(key, 5)
+	assert.Assert(t, err == nil && n == 5)
+	n, err = c.(*client.Client).Incr(key, -7)
+	assert.Assert(t, err == nil && n == -2)
+
+	// concurrent increments are serialized
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.(*client.Client).Incr(key, 1)
+			errs.add(err)
+		}()
+	}
+	wg.Wait()
+	errs.check(t)
+
+	// the same counter as Inc
+	v, _, err := c.Get(key)
+	assert.Assert(t, err == nil && bytes.Equal(v, numeric.Encode2Binary(8, nil)), v)
+	n, err = c.(*client.Client).Inc(key, 1)
+	assert.Assert(t, err == nil && n == 9)
+
+	// overflow doesn't wrap, and the counter is kept
+	key = []byte("counter:max")
+	n, err = c.(*client.Client).Incr(key, math.MaxInt64)
+	assert.Assert(t, err == nil && n == math.MaxInt64)
+	_, err = c.(*client.Client).Incr(key, 1)
+	assert.Assert(t, err == kv.ErrOverflow, err)
+	n, err = c.(*client.Client).Incr(key, math.MinInt64)
+	assert.Assert(t, err == nil && n == -1)
+	_, err = c.(*client.Client).Incr(key, math.MinInt64)
+	assert.Assert(t, err == kv.ErrOverflow, err)
+	n, err = c.(*client.Client).Incr(key, 0)
+	assert.Assert(t, err == nil && n == -1)
+}
+
+func TestInc(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{MaxRetries: 10})
+	defer c.Close()
+
+	key := []byte("inc")
+	n, err := c.(*client.Client).Inc(key, 5)
+	assert.Assert(t, err == nil && n == 5)
+	n, err = c.(*client.Client).Inc(key, -7)
+	assert.Assert(t, err == nil && n == -2)
+	v, _, err := c.Get(key)
+	assert.Assert(t, err == nil && bytes.Equal(v, numeric.Encode2Binary(uint64(n), nil)))
+
+	// overflow doesn't wrap
+	_, err = c.(*client.Client).Inc(key, math.MinInt64)
+	assert.Assert(t, err == kv.ErrOverflow, err)
+	n, err = c.(*client.Client).Inc(key, 0)
+	assert.Assert(t, err == nil && n == -2)
+
+	// participate in a transaction
+	err = c.Update(func(txn mondis.Txn) error {
+		n, err := txn.(*client.Txn).Inc(key, 3)
+		assert.Assert(t, err == nil && n == 1)
+		n, err = txn.(*client.Txn).Inc(key, 1)
+		assert.Assert(t, err == nil && n == 2)
+		return nil
+	})
+	assert.Assert(t, err == nil)
+
+	// transaction started by Inc
+	err = c.Update(func(txn mondis.Txn) error {
+		_, err := txn.(*client.Txn).Inc([]byte("inc2"), 1)
+		assert.Assert(t, err == nil)
+		return txn.Set([]byte("inc3"), nil, nil)
+	})
+	assert.Assert(t, err == nil)
+
+	n, err = c.(*client.Client).Inc(key, 0)
+	assert.Assert(t, err == nil && n == 2)
+	n, err = c.(*client.Client).Inc([]byte("inc2"), 0)
+	assert.Assert(t, err == nil && n == 1)
+}
+
+func TestTxnSplit(t *testing.T) {
+	os.RemoveAll(dataDir)
+	// badger bounds a transaction by 15% of MaxTableSize, about 1.6k entries here
+	kvoption := mondis.KVOption{Dir: dataDir, MaxTableSize: 1 << 20}
+	s := server.New(addr, provider.NewBadger(), server.Option{EnableTxnSplit: true}, kvoption)
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+
+	// 256MB in all
+	const n = 4096
+	value := bytes.Repeat([]byte("v"), 64<<10)
+	setAll := func(prefix string) func(txn mondis.Txn) error {
+		return func(txn mondis.Txn) error {
+			for i := 0; i < n; i++ {
+				err := txn.Set([]byte(fmt.Sprintf("%s%05d", prefix, i)), value, nil)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	count := func(prefix string) int64 {
+		n, err := c.Count(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte(prefix)}})
+		assert.Assert(t, err == nil)
+		return n
+	}
+
+	// not split unless asked
+	err := c.Update(setAll("plain"))
+	assert.Assert(t, err == kv.ErrTxnTooBig, err)
+	assert.Assert(t, count("plain") == 0)
+
+	splits, err := c.UpdateSplit(setAll("split"))
+	assert.Assert(t, err == nil && splits >= 2, err, splits)
+	assert.Assert(t, count("split") == n)
+	v, _, err := c.Get([]byte(fmt.Sprintf("split%05d", n-1)))
+	assert.Assert(t, err == nil && bytes.Equal(v, value))
+
+	// small ones aren't split
+	splits, err = c.UpdateSplit(func(txn mondis.Txn) error {
+		return txn.Delete([]byte("split00000"))
+	})
+	assert.Assert(t, err == nil && splits == 0)
+	assert.Assert(t, count("split") == n-1)
+
+	// split is ignored by server without Option.EnableTxnSplit
+	s2 := server.New("localhost:8104", provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir + "_nosplit", MaxTableSize: 1 << 20})
+	go s2.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer func() {
+		s2.Stop()
+		os.RemoveAll(dataDir + "_nosplit")
+	}()
+	c2 := client.New("localhost:8104", client.Option{}).(*client.Client)
+	defer c2.Close()
+	splits, err = c2.UpdateSplit(setAll("split"))
+	assert.Assert(t, err == kv.ErrTxnTooBig && splits == 0, err)
+}
+
+type tokenAuth map[string][]server.Grant
+
+func (a tokenAuth) Authenticate(token string) (grants []server.Grant, err error) {
+	grants, ok := a[token]
+	if !ok {
+		err = errors.New("unknown token")
+	}
+	return
+}
+
+func TestAuth(t *testing.T) {
+	os.RemoveAll(dataDir)
+	auth := tokenAuth{
+		"admin":  {{Perm: server.PermRead | server.PermWrite | server.PermAdmin}},
+		"ro":     {{Perm: server.PermRead}},
+		"scoped": {{Prefix: []byte("a:"), Perm: server.PermRead | server.PermWrite}, {Prefix: []byte("b:"), Perm: server.PermRead}},
+	}
+	s := server.New(addr, provider.NewBadger(), server.Option{AuthProvider: auth, EnableListTxnsCmd: true}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	newClient := func(token string) *client.Client {
+		return client.New(addr, client.Option{Token: token}).(*client.Client)
+	}
+	prefixOption := func(prefix string) mondis.ScanOption {
+		return mondis.ScanOption{Limit: 10, ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte(prefix)}}
+	}
+
+	admin := newClient("admin")
+	defer admin.Close()
+	err := admin.Set([]byte("b:1"), []byte("b"), nil)
+	assert.Assert(t, err == nil)
+	_, err = admin.ListTxns()
+	assert.Assert(t, err == nil)
+
+	// everything but AuthCmd is rejected before authenticated
+	anonymous := newClient("")
+	defer anonymous.Close()
+	_, _, err = anonymous.Get([]byte("b:1"))
+	assert.Assert(t, err == server.ErrPermissionDenied, err)
+	err = anonymous.Update(func(txn mondis.Txn) error {
+		return txn.Set([]byte("a:1"), nil, nil)
+	})
+	assert.Assert(t, err == server.ErrPermissionDenied, err)
+
+	wrong := newClient("wrong")
+	defer wrong.Close()
+	_, _, err = wrong.Get([]byte("b:1"))
+	assert.Assert(t, err == server.ErrAuthFailed, err)
+
+	ro := newClient("ro")
+	defer ro.Close()
+	v, _, err := ro.Get([]byte("b:1"))
+	assert.Assert(t, err == nil && string(v) == "b")
+	err = ro.Set([]byte("b:2"), []byte("b"), nil)
+	assert.Assert(t, err == server.ErrPermissionDenied, err)
+	_, err = ro.Incr([]byte("b:2"), 1)
+	assert.Assert(t, err == server.ErrPermissionDenied, err)
+	_, err = ro.ListTxns()
+	assert.Assert(t, err == server.ErrPermissionDenied, err)
+	// the transaction goes on without the denied operation
+	err = ro.Update(func(txn mondis.Txn) error {
+		_, _, err := txn.Get([]byte("b:1"))
+		assert.Assert(t, err == nil)
+		err = txn.Set([]byte("b:2"), []byte("b"), nil)
+		assert.Assert(t, err == server.ErrPermissionDenied, err)
+		return nil
+	})
+	assert.Assert(t, err == nil)
+	exists, err := admin.Exists([]byte("b:2"))
+	assert.Assert(t, err == nil && !exists)
+
+	scoped := newClient("scoped")
+	defer scoped.Close()
+	err = scoped.Set([]byte("a:1"), []byte("a"), nil)
+	assert.Assert(t, err == nil)
+	err = scoped.Set([]byte("b:2"), []byte("b"), nil)
+	assert.Assert(t, err == server.ErrPermissionDenied, err)
+	_, _, err = scoped.Get([]byte("c:1"))
+	assert.Assert(t, err == server.ErrPermissionDenied, err)
+	entries, err := scoped.Scan(prefixOption("b:"))
+	assert.Assert(t, err == nil && len(entries) == 1)
+	// scans need the grant on their prefix
+	for _, prefix := range []string{"", "b", "c:"} {
+		_, err = scoped.Scan(prefixOption(prefix))
+		assert.Assert(t, err == server.ErrPermissionDenied, prefix)
+		_, err = scoped.Count(prefixOption(prefix))
+		assert.Assert(t, err == server.ErrPermissionDenied, prefix)
+		err = scoped.ScanStream(prefixOption(prefix), func(entry mondis.Entry) bool { return true })
+		assert.Assert(t, err == server.ErrPermissionDenied, prefix)
+	}
+	err = scoped.Update(func(txn mondis.Txn) error {
+		err := txn.Set([]byte("a:2"), []byte("a"), nil)
+		assert.Assert(t, err == nil)
+		_, err = txn.Scan(prefixOption("c:"))
+		assert.Assert(t, err == server.ErrPermissionDenied, err)
+		return nil
+	})
+	assert.Assert(t, err == nil, err)
+	// a transaction starting with a denied operation is rejected as a whole
+	err = scoped.Update(func(txn mondis.Txn) error {
+		return txn.Delete([]byte("b:1"))
+	})
+	assert.Assert(t, err == server.ErrPermissionDenied, err)
+
+	entries, err = admin.Scan(prefixOption(""))
+	assert.Assert(t, err == nil && len(entries) == 3, entries)
+}
+
+func TestCAS(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{PoolSize: 4})
+	defer c.Close()
+	cc := c.(*client.Client)
+
+	key := []byte("cas")
+	// absent key never matches expected
+	swapped, err := cc.CompareAndSwap(key, nil, []byte("v1"))
+	assert.Assert(t, err == nil && !swapped)
+	swapped, err = cc.CompareAndSwapAbsent(key, []byte("v1"))
+	assert.Assert(t, err == nil && swapped)
+	swapped, err = cc.CompareAndSwapAbsent(key, []byte("v2"))
+	assert.Assert(t, err == nil && !swapped)
+
+	swapped, err = cc.CompareAndSwap(key, []byte("v2"), []byte("v3"))
+	assert.Assert(t, err == nil && !swapped)
+	swapped, err = cc.CompareAndSwap(key, []byte("v1"), []byte("v2"))
+	assert.Assert(t, err == nil && swapped)
+	v, _, err := c.Get(key)
+	assert.Assert(t, err == nil && string(v) == "v2")
+
+	// only one of the concurrent swaps from the same value succeeds
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+		wins int32
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			swapped, err := cc.CompareAndSwap(key, []byte("v2"), []byte(fmt.Sprintf("w%d", i)))
+			errs.add(err)
+			if swapped {
+				atomic.AddInt32(&wins, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	errs.check(t)
+	assert.Assert(t, wins == 1, wins)
+
+	// mismatches return the current value
+	key = []byte("cas2")
+	swapped, current, err := cc.CompareAndSet(key, []byte("v1"), []byte("v2"))
+	assert.Assert(t, err == nil && !swapped && current == nil)
+	swapped, current, err = cc.CompareAndSet(key, nil, []byte{})
+	assert.Assert(t, err == nil && swapped && current == nil)
+	swapped, current, err = cc.CompareAndSet(key, nil, []byte("v1"))
+	assert.Assert(t, err == nil && !swapped && current != nil && len(current) == 0)
+	swapped, _, err = cc.CompareAndSet(key, []byte{}, []byte("v1"))
+	assert.Assert(t, err == nil && swapped)
+
+	deleted, current, err := cc.CompareAndDelete(key, []byte("v2"))
+	assert.Assert(t, err == nil && !deleted && string(current) == "v1")
+	deleted, _, err = cc.CompareAndDelete(key, []byte("v1"))
+	assert.Assert(t, err == nil && deleted)
+	_, _, err = c.Get(key)
+	assert.Assert(t, err == kv.ErrKeyNotFound)
+	deleted, current, err = cc.CompareAndDelete(key, nil)
+	assert.Assert(t, err == nil && deleted && current == nil)
+
+	// within a transaction, as the first operation and later ones
+	key = []byte("cas3")
+	err = c.Update(func(txn mondis.Txn) error {
+		ct := txn.(*client.Txn)
+		swapped, current, err := ct.CompareAndSet(key, nil, []byte("v1"))
+		assert.Assert(t, err == nil && swapped && current == nil, err)
+		swapped, current, err = ct.CompareAndSet(key, []byte("v2"), []byte("v3"))
+		assert.Assert(t, err == nil && !swapped && string(current) == "v1", err)
+		swapped, _, err = ct.CompareAndSet(key, []byte("v1"), []byte("v2"))
+		assert.Assert(t, err == nil && swapped, err)
+		v, _, err := ct.Get(key)
+		assert.Assert(t, err == nil && string(v) == "v2", err)
+		deleted, current, err := ct.CompareAndDelete([]byte("cas3:other"), []byte("v"))
+		assert.Assert(t, err == nil && !deleted && current == nil, err)
+		return nil
+	})
+	assert.Assert(t, err == nil, err)
+	v, _, err = c.Get(key)
+	assert.Assert(t, err == nil && string(v) == "v2")
+
+	err = c.Update(func(txn mondis.Txn) error {
+		ct := txn.(*client.Txn)
+		_, _, err := ct.Get(key)
+		assert.Assert(t, err == nil, err)
+		deleted, _, err := ct.CompareAndDelete(key, []byte("v2"))
+		assert.Assert(t, err == nil && deleted, err)
+		return nil
+	})
+	assert.Assert(t, err == nil, err)
+	_, _, err = c.Get(key)
+	assert.Assert(t, err == kv.ErrKeyNotFound)
+
+	// nothing is written on discard
+	errDiscard := errors.New("discard")
+	err = c.Update(func(txn mondis.Txn) error {
+		swapped, _, err := txn.(*client.Txn).CompareAndSet(key, nil, []byte("v1"))
+		assert.Assert(t, err == nil && swapped, err)
+		return errDiscard
+	})
+	assert.Assert(t, err == errDiscard, err)
+	_, _, err = c.Get(key)
+	assert.Assert(t, err == kv.ErrKeyNotFound)
+
+	err = c.View(func(txn mondis.Txn) error {
+		_, _, err := txn.(*client.Txn).CompareAndSet(key, nil, []byte("v1"))
+		return err
+	})
+	assert.Assert(t, err == client.ErrMutateForROTxn, err)
+}
+
+func TestScanStream(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{})
+	defer c.Close()
+
+	n := mondis.MaxEntry + server.ScanStreamBatchSize/2
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("stream:%05d", i))
+		assert.Assert(t, c.Set(key, key, nil) == nil)
+	}
+
+	scan := func(limit int, stopAt int) (keys [][]byte, err error) {
+		option := mondis.ScanOption{Limit: limit, ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("stream:")}}
+		err = c.(*client.Client).ScanStream(option, func(entry mondis.Entry) bool {
+			keys = append(keys, entry.Key)
+			return len(keys) != stopAt
+		})
+		return
+	}
+
+	// no MaxEntry limitation
+	keys, err := scan(0, -1)
+	assert.Assert(t, err == nil && len(keys) == n, err)
+	for i, key := range keys {
+		assert.Assert(t, bytes.Equal(key, []byte(fmt.Sprintf("stream:%05d", i))))
+	}
+
+	keys, err = scan(300, -1)
+	assert.Assert(t, err == nil && len(keys) == 300, err)
+
+	// stop early
+	keys, err = scan(0, 10)
+	assert.Assert(t, err == nil && len(keys) == 10, err)
+
+	// connection still usable
+	_, _, err = c.Get(keys[0])
+	assert.Assert(t, err == nil)
+}
+
+func TestCountRPC(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{})
+	defer c.Close()
+	cc := c.(*client.Client)
+
+	// more than MaxEntry since count isn't limited by it
+	n := mondis.MaxEntry + 10
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("count:%05d", i))
+		assert.Assert(t, c.Set(key, key, nil) == nil)
+	}
+	assert.Assert(t, c.Set([]byte("other"), nil, nil) == nil)
+
+	prefix := mondis.ProviderScanOption{Prefix: []byte("count:")}
+	total, err := cc.Count(mondis.ScanOption{ProviderScanOption: prefix})
+	assert.Assert(t, err == nil && total == int64(n), total, err)
+
+	total, err = cc.Count(mondis.ScanOption{Limit: 5, ProviderScanOption: prefix})
+	assert.Assert(t, err == nil && total == 5, total, err)
+
+	total, err = cc.Count(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("count:"), Reverse: true}})
+	assert.Assert(t, err == nil && total == int64(n), total, err)
+
+	total, err = cc.Count(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{Prefix: []byte("none:")}})
+	assert.Assert(t, err == nil && total == 0, total, err)
+}
+
+func TestScanKeyPattern(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	c := client.New(addr, client.Option{})
+	defer c.Close()
+	cc := c.(*client.Client)
+
+	for _, key := range []string{
+		"admin:1:profile",
+		"user:1:profile",
+		"user:1:settings",
+		"user:22:profile",
+		"user:22:profile:old",
+		"user:3:profile",
+		"user:*:profile",
+		"users:4:profile",
+	} {
+		assert.Assert(t, c.Set([]byte(key), []byte(key), nil) == nil)
+	}
+
+	scan := func(option mondis.ProviderScanOption) (keys []string, err error) {
+		entries, err := c.Scan(mondis.ScanOption{Limit: mondis.MaxEntry, ProviderScanOption: option})
+		for _, entry := range entries {
+			keys = append(keys, string(entry.Key))
+		}
+		return
+	}
+
+	keys, err := scan(mondis.ProviderScanOption{KeyPattern: "user:*:profile"})
+	assert.Assert(t, err == nil, err)
+	assert.DeepEqual(t, keys, []string{"user:*:profile", "user:1:profile", "user:22:profile", "user:3:profile"})
+
+	keys, err = scan(mondis.ProviderScanOption{KeyPattern: "user:?:profile*", Reverse: true})
+	assert.Assert(t, err == nil, err)
+	assert.DeepEqual(t, keys, []string{"user:3:profile", "user:1:profile", "user:*:profile"})
+
+	keys, err = scan(mondis.ProviderScanOption{KeyPattern: `user:\*:*`})
+	assert.Assert(t, err == nil, err)
+	assert.DeepEqual(t, keys, []string{"user:*:profile"})
+
+	// prefix and pattern narrow each other
+	keys, err = scan(mondis.ProviderScanOption{Prefix: []byte("user:2"), KeyPattern: "user:*:profile*"})
+	assert.Assert(t, err == nil, err)
+	assert.DeepEqual(t, keys, []string{"user:22:profile", "user:22:profile:old"})
+	keys, err = scan(mondis.ProviderScanOption{Prefix: []byte("admin:"), KeyPattern: "user:*"})
+	assert.Assert(t, err == nil && len(keys) == 0, keys, err)
+
+	total, err := cc.Count(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{KeyPattern: "*:profile"}})
+	assert.Assert(t, err == nil && total == 6, total, err)
+
+	var streamed []string
+	err = cc.ScanStream(mondis.ScanOption{ProviderScanOption: mondis.ProviderScanOption{KeyPattern: "*:1:*"}}, func(entry mondis.Entry) bool {
+		streamed = append(streamed, string(entry.Key))
+		return true
+	})
+	assert.Assert(t, err == nil, err)
+	assert.DeepEqual(t, streamed, []string{"admin:1:profile", "user:1:profile", "user:1:settings"})
+
+	_, err = scan(mondis.ProviderScanOption{KeyPattern: strings.Repeat("*a", kv.MaxKeyPatternStars+1)})
+	assert.Assert(t, err != nil && strings.Contains(err.Error(), kv.ErrKeyPatternTooExpensive.Error()), err)
+	_, err = scan(mondis.ProviderScanOption{KeyPattern: `user:\`})
+	assert.Assert(t, err != nil && strings.Contains(err.Error(), kv.ErrInvalidKeyPattern.Error()), err)
+}
+
+func TestFsck(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	s := server.New(addr, kvdb, server.Option{EnableFsckCmd: true, FsckInterval: time.Second}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	err := util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) error {
+		m := meta.NewMeta(txn)
+		for _, id := range []int64{1, 3} {
+			if err := m.CreateDatabase(&model.DBInfo{ID: id, Name: fmt.Sprintf("db%d", id)}); err != nil {
+				return err
+			}
+			if err := m.CreateCollection(id, &model.CollectionInfo{ID: id + 1, Name: "c"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Assert(t, err == nil)
+
+	c := client.New(addr, client.Option{})
+	defer c.Close()
+	fsck := func(c mondis.Client, option client.FsckOption) (report client.FsckReport, err error) {
+		rs, err := c.(*client.Client).Fsck(option)
+		if err != nil {
+			return
+		}
+		for {
+			var done bool
+			report, done, err = rs.Next()
+			if err != nil || done {
+				return
+			}
+		}
+	}
+
+	report, err := fsck(c, client.FsckOption{Scope: client.FsckScopeMeta})
+	assert.Assert(t, err == nil && report.Checked == 1 && len(report.Issues) == 0, err)
+
+	// corrupt field count of the database list
+	dbsMetaKey := memcomparable.EncodeBytes(append([]byte{}, keyspace.MetaPrefixBytes...), []byte("dbs"))
+	dbsMetaKey = append(dbsMetaKey, byte(structure.HashMeta))
+	err = c.Set(dbsMetaKey, numeric.Encode2Binary(5, nil), nil)
+	assert.Assert(t, err == nil)
+
+	report, err = fsck(c, client.FsckOption{Scope: client.FsckScopeMeta})
+	assert.Assert(t, err == nil && len(report.Issues) == 1 && report.Repaired() == 0, err)
+	report, err = fsck(c, client.FsckOption{Scope: client.FsckScopeMeta, Repair: true})
+	assert.Assert(t, err == nil && len(report.Issues) == 1 && report.Repaired() == 1, err)
+	report, err = fsck(c, client.FsckOption{Scope: client.FsckScopeAll})
+	assert.Assert(t, err == nil && report.Checked == 3 && len(report.Issues) == 0, err)
+	report, err = fsck(c, client.FsckOption{Scope: client.FsckScopeDB, DBID: 3})
+	assert.Assert(t, err == nil && report.Checked == 1 && len(report.Issues) == 0, err)
+
+	// issues of earlier frames are kept in the reports of later ones
+	err = c.Set(dbsMetaKey, numeric.Encode2Binary(5, nil), nil)
+	assert.Assert(t, err == nil)
+	report, err = fsck(c, client.FsckOption{Scope: client.FsckScopeAll})
+	assert.Assert(t, err == nil && report.Checked == 3 && len(report.Issues) == 1, err)
+	err = c.Set(dbsMetaKey, numeric.Encode2Binary(2, nil), nil)
+	assert.Assert(t, err == nil)
+
+	// only one fsck at a time, and it's cancellable,
+	// use another client since unread progress frames block the connection
+	c2 := client.New(addr, client.Option{})
+	defer c2.Close()
+	rs, err := c.(*client.Client).Fsck(client.FsckOption{Scope: client.FsckScopeAll})
+	assert.Assert(t, err == nil)
+	report, done, err := rs.Next()
+	assert.Assert(t, err == nil && !done && report.Checked == 1, err)
+	_, err = fsck(c2, client.FsckOption{Scope: client.FsckScopeAll})
+	assert.Assert(t, err == server.ErrFsckRunning, err)
+	assert.Assert(t, c2.(*client.Client).CancelFsck() == nil)
+	for err == server.ErrFsckRunning || (err == nil && !done) {
+		_, done, err = rs.Next()
+	}
+	assert.Assert(t, err != nil && err != io.EOF, err)
+	assert.Assert(t, c2.(*client.Client).CancelFsck() == server.ErrNoFsckRunning)
+
+	// fsck commands need the option
+	s2 := server.New("localhost:8104", provider.NewMemory(), server.Option{}, mondis.KVOption{})
+	go s2.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s2.Stop()
+	c3 := client.New("localhost:8104", client.Option{})
+	defer c3.Close()
+	_, err = fsck(c3, client.FsckOption{Scope: client.FsckScopeMeta})
+	assert.Assert(t, err != nil && strings.Contains(err.Error(), "not enabled"), err)
+	err = c3.(*client.Client).CancelFsck()
+	assert.Assert(t, err != nil && strings.Contains(err.Error(), "not enabled"), err)
+}
+
+func TestMetaByName(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) error {
+		m := meta.NewMeta(txn)
+		err := m.CreateDatabase(&model.DBInfo{ID: 1, Name: "db"})
+		assert.Assert(t, err == nil)
+		err = m.CreateDatabase(&model.DBInfo{ID: 2, Name: "db"})
+		assert.Assert(t, err == meta.ErrDBExists)
+		err = m.CreateCollection(1, &model.CollectionInfo{ID: 2, Name: "c"})
+		assert.Assert(t, err == nil)
+		err = m.CreateCollection(1, &model.CollectionInfo{ID: 3, Name: "c"})
+		assert.Assert(t, err == meta.ErrCollectionExists)
+
+		dbInfo, err := m.GetDatabaseByName("db")
+		assert.Assert(t, err == nil && dbInfo.ID == 1)
+		collectionInfo, err := m.GetCollectionByName(1, "c")
+		assert.Assert(t, err == nil && collectionInfo.ID == 2)
+		_, err = m.GetCollectionByName(1, "c2")
+		assert.Assert(t, err == meta.ErrCollectionNotExists)
+
+		// rename removes the old index entry
+		err = m.UpdateCollection(1, &model.CollectionInfo{ID: 2, Name: "c2"})
+		assert.Assert(t, err == nil)
+		_, err = m.GetCollectionByName(1, "c")
+		assert.Assert(t, err == meta.ErrCollectionNotExists)
+		collectionInfo, err = m.GetCollectionByName(1, "c2")
+		assert.Assert(t, err == nil && collectionInfo.ID == 2)
+		err = m.UpdateDatabase(&model.DBInfo{ID: 1, Name: "db2"})
+		assert.Assert(t, err == nil)
+		_, err = m.GetDatabaseByName("db")
+		assert.Assert(t, err == meta.ErrDBNotExists)
+		dbInfo, err = m.GetDatabaseByName("db2")
+		assert.Assert(t, err == nil && dbInfo.ID == 1)
+
+		err = m.DropCollection(1, 2, true)
+		assert.Assert(t, err == nil)
+		_, err = m.GetCollectionByName(1, "c2")
+		assert.Assert(t, err == meta.ErrCollectionNotExists)
+		err = m.DropDatabase(1)
+		assert.Assert(t, err == nil)
+		_, err = m.GetDatabaseByName("db2")
+		assert.Assert(t, err == meta.ErrDBNotExists)
+		return nil
+	})
+	assert.Assert(t, err == nil)
+
+	// index pointing at a missing collection
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) error {
+		m := meta.NewMeta(txn)
+		err := m.CreateDatabase(&model.DBInfo{ID: 1, Name: "db"})
+		assert.Assert(t, err == nil)
+		err = m.CreateCollection(1, &model.CollectionInfo{ID: 2, Name: "c"})
+		assert.Assert(t, err == nil)
+
+		txStruct := structure.New(txn, keyspace.MetaPrefixBytes)
+		err = txStruct.HDel([]byte("db:1"), []byte("collectionInfo:2"))
+		assert.Assert(t, err == nil)
+		_, err = m.GetCollectionByName(1, "c")
+		assert.Assert(t, err == meta.ErrCollectionNameIndexCorrupt)
+
+		issues, err := m.CheckDatabase(1, true)
+		assert.Assert(t, err == nil && len(issues) == 1 && issues[0].Repaired, issues)
+		_, err = m.GetCollectionByName(1, "c")
+		assert.Assert(t, err == meta.ErrCollectionNotExists)
+		return nil
+	})
+	assert.Assert(t, err == nil)
+}
+
+func TestRateLimit(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+
+	// writes/sec, a burst of one second is allowed
+	c, err := db.Collection("c", document.CollectionOption{RateLimit: document.RateLimit{WritesPerSecond: 50}})
+	assert.Assert(t, err == nil)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		_, err = c.InsertOne(bson.M{"i": int32(i)}, nil)
+		assert.Assert(t, err == nil)
+	}
+	elapsed := time.Since(start)
+	assert.Assert(t, elapsed > time.Millisecond*800 && elapsed < time.Millisecond*1500, elapsed)
+
+	// global bytes/sec shared by collections
+	c.SetRateLimit(document.RateLimit{})
+	doc := bson.M{"s": string(make([]byte, 1000))}
+	data, _ := bson.Marshal(doc)
+	db.SetRateLimit(document.RateLimit{BytesPerSecond: float64(len(data) * 20)})
+	c2, err := db.Collection("c2")
+	assert.Assert(t, err == nil)
+	start = time.Now()
+	for i := 0; i < 20; i++ {
+		_, err = c.InsertOne(doc, nil)
+		assert.Assert(t, err == nil)
+		_, err = c2.InsertOne(doc, nil)
+		assert.Assert(t, err == nil)
+	}
+	elapsed = time.Since(start)
+	assert.Assert(t, elapsed > time.Millisecond*800 && elapsed < time.Millisecond*1500, elapsed)
+	db.SetRateLimit(document.RateLimit{})
+
+	// too long to wait
+	c.SetRateLimit(document.RateLimit{WritesPerSecond: 0.01})
+	_, err = c.InsertOne(bson.M{}, nil)
+	assert.Assert(t, err == nil)
+	_, err = c.InsertOne(bson.M{}, nil)
+	assert.Assert(t, err == document.ErrRateLimited)
+
+	// options of later calls don't reset the shared collection
+	c, err = db.Collection("c", document.CollectionOption{})
+	assert.Assert(t, err == nil)
+	_, err = c.InsertOne(bson.M{}, nil)
+	assert.Assert(t, err == document.ErrRateLimited)
+
+	// collection tokens are given back when the db rejects the write
+	c.SetRateLimit(document.RateLimit{WritesPerSecond: 1})
+	db.SetRateLimit(document.RateLimit{WritesPerSecond: 0.01})
+	_, err = c.InsertOne(bson.M{}, nil)
+	assert.Assert(t, err == nil)
+	_, err = c.InsertOne(bson.M{}, nil)
+	assert.Assert(t, err == document.ErrRateLimited)
+	db.SetRateLimit(document.RateLimit{})
+	start = time.Now()
+	_, err = c.InsertOne(bson.M{}, nil)
+	assert.Assert(t, err == nil)
+	elapsed = time.Since(start)
+	assert.Assert(t, elapsed < time.Millisecond*1500, elapsed)
+}
+
+func TestCount(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+
+	db := document.NewDB(kvdb)
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+	for i := 0; i < 100; i++ {
+		_, err = c.InsertOne(bson.M{"i": int32(i % 2)}, nil)
+		assert.Assert(t, err == nil)
+	}
+	// scanned while all in memtable
+	n, exact, err := c.Count(document.CountOption{}, nil)
+	assert.Assert(t, err == nil && exact && n == 100, n)
+	db.Close()
+	// flush memtable into tables
+	kvdb.Close()
+
+	kvdb = provider.NewBadger()
+	err = kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+	db = document.NewDB(kvdb)
+	defer db.Close()
+	c, err = db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	n, exact, err = c.Count(document.CountOption{}, nil)
+	assert.Assert(t, err == nil && !exact && n >= 100, n)
+
+	n, exact, err = c.Count(document.CountOption{Exact: true}, nil)
+	assert.Assert(t, err == nil && exact && n == 100, n)
+
+	// filter always scans
+	n, exact, err = c.Count(document.CountOption{Filter: bson.M{"i": int32(1)}}, nil)
+	assert.Assert(t, err == nil && exact && n == 50, n)
+
+	n, err = c.CountDocuments(nil)
+	assert.Assert(t, err == nil && n == 100, n)
+	txn := kvdb.NewTransaction(true)
+	defer txn.Discard()
+	did, err := c.InsertOne(bson.M{"i": int32(0)}, txn)
+	assert.Assert(t, err == nil)
+	_, err = c.InsertOne(bson.M{"i": int32(0)}, txn)
+	assert.Assert(t, err == nil)
+	err = c.DeleteOne(did, txn)
+	assert.Assert(t, err == nil)
+	n, err = c.CountDocuments(txn)
+	assert.Assert(t, err == nil && n == 101, n)
+	n, err = c.CountDocuments(nil)
+	assert.Assert(t, err == nil && n == 100, n)
+
+	counters, err := db.CountersCollection("counters")
+	assert.Assert(t, err == nil)
+	_, err = counters.CountDocuments(nil)
+	assert.Assert(t, err == document.ErrCollectionKindMismatch)
+}
+
+func TestCollectionForEach(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	n, _, err := c.Count(document.CountOption{}, nil)
+	assert.Assert(t, err == nil && n == 0)
+
+	var dids []int64
+	for i := 0; i < 3; i++ {
+		did, err := c.InsertOne(bson.M{"i": int32(i)}, nil)
+		assert.Assert(t, err == nil)
+		dids = append(dids, did)
+	}
+
+	n, _, err = c.Count(document.CountOption{}, nil)
+	assert.Assert(t, err == nil && n == 3)
+
+	var (
+		visited []int64
+		values  []int32
+	)
+	err = c.ForEach(func(did int64, doc bson.M) bool {
+		visited = append(visited, did)
+		values = append(values, doc["i"].(int32))
+		return true
+	}, nil)
+	assert.Assert(t, err == nil)
+	assert.DeepEqual(t, visited, dids)
+	assert.DeepEqual(t, values, []int32{0, 1, 2})
+
+	visited = nil
+	err = c.ForEach(func(did int64, doc bson.M) bool {
+		visited = append(visited, did)
+		return false
+	}, nil)
+	assert.Assert(t, err == nil && len(visited) == 1)
+
+	// documents of the next collection are not visited
+	c2, err := db.Collection("c2")
+	assert.Assert(t, err == nil)
+	_, err = c2.InsertOne(bson.M{"i": int32(3)}, nil)
+	assert.Assert(t, err == nil)
+	visited = nil
+	err = c.Iterate(func(did int64, doc bson.M) bool {
+		visited = append(visited, did)
+		return true
+	}, nil)
+	assert.Assert(t, err == nil)
+	assert.DeepEqual(t, visited, dids)
+}
+
+func TestInsertMany(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	did, err := c.InsertOne(bson.M{"i": -1}, nil)
+	assert.Assert(t, err == nil)
+
+	// more than the band left in the lease
+	docs := make([]bson.M, 1500)
+	for i := range docs {
+		docs[i] = bson.M{"i": i}
+	}
+	dids, err := c.InsertMany(docs, nil)
+	assert.Assert(t, err == nil && len(dids) == len(docs))
+	for i, id := range dids {
+		assert.Assert(t, id > did && (i == 0 || id == dids[i-1]+1))
+	}
+	datas, err := c.GetMany(dids, nil)
+	assert.Assert(t, err == nil)
+	for i, data := range datas {
+		assert.Assert(t, data["i"] == int32(i), data)
+	}
+
+	// later ids don't overlap
+	next, err := c.InsertOne(bson.M{"i": len(docs)}, nil)
+	assert.Assert(t, err == nil && next > dids[len(dids)-1])
+
+	// nothing is written if any doc fails
+	for _, bad := range []bson.M{{"ch": make(chan int)}, {db.SystemField(document.SystemFieldRev): 1}} {
+		_, err = c.InsertMany([]bson.M{{"i": "ok"}, bad}, nil)
+		assert.Assert(t, err != nil)
+	}
+	n, _, err := c.Count(document.CountOption{Exact: true}, nil)
+	assert.Assert(t, err == nil && n == int64(len(docs)+2), n)
+
+	// within txn, visible only once committed
+	txn := kvdb.NewTransaction(true)
+	dids, err = c.InsertMany([]bson.M{{"i": "a"}, {"i": "b"}}, txn)
+	assert.Assert(t, err == nil && len(dids) == 2)
+	_, err = c.GetOne(dids[0], nil)
+	assert.Assert(t, err == document.ErrDocNotFound)
+	assert.Assert(t, txn.Commit() == nil)
+	datas, err = c.GetMany(dids, nil)
+	assert.Assert(t, err == nil && datas[0]["i"] == "a" && datas[1]["i"] == "b")
+
+	dids, err = c.InsertMany(nil, nil)
+	assert.Assert(t, err == nil && len(dids) == 0)
+
+	// entries of unique indexes are written, a duplicate value fails the whole batch
+	_, err = c.CreateIndex(document.IndexDefinition{Name: "i", Fields: []document.IndexField{{Name: "i"}}, Option: document.IndexOption{Unique: true}})
+	assert.Assert(t, err == nil)
+	_, err = c.InsertMany([]bson.M{{"i": "c"}, {"i": "c"}}, nil)
+	assert.Assert(t, err == document.ErrUniqueViolated, err)
+	dids, err = c.InsertMany([]bson.M{{"i": "c"}, {"i": "d"}}, nil)
+	assert.Assert(t, err == nil)
+	inserted, updated, err := c.UpsertByKey("i", []bson.M{{"i": "d", "v": 1}}, nil)
+	assert.Assert(t, err == nil && inserted == 0 && updated == 1, inserted, updated, err)
+	datas, err = c.GetMany(dids, nil)
+	assert.Assert(t, err == nil && datas[1]["v"] == int32(1), datas)
+}
+
+func TestGetManyPartial(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	for _, did := range []int64{1, 3, 4} {
+		assert.Assert(t, c.InsertOneManaged(did, bson.M{"did": did}, nil) == nil)
+	}
+
+	dids := []int64{4, 2, 1, 5, 3, 1}
+	datas, found, err := c.GetManyPartial(dids, nil)
+	assert.Assert(t, err == nil && len(datas) == len(dids))
+	assert.DeepEqual(t, found, []int64{4, 1, 3, 1})
+	for i, did := range dids {
+		if did == 2 || did == 5 {
+			assert.Assert(t, datas[i] == nil)
+		} else {
+			assert.Assert(t, datas[i]["did"] == did, datas[i])
+		}
+	}
+
+	// GetMany still fails on the first missing one
+	_, err = c.GetMany(dids, nil)
+	assert.Assert(t, err == document.ErrDocNotFound)
+
+	datas, found, err = c.GetManyPartial([]int64{7, 8}, nil)
+	assert.Assert(t, err == nil && len(datas) == 2 && datas[0] == nil && datas[1] == nil && len(found) == 0)
+}
+
+func TestGetRange(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+	other, err := db.Collection("other")
+	assert.Assert(t, err == nil)
+
+	for _, did := range []int64{99, 100, 101, 103, 104, 107, 200, 201} {
+		assert.Assert(t, c.InsertOneManaged(did, bson.M{"did": did}, nil) == nil)
+		assert.Assert(t, other.InsertOneManaged(did+1, bson.M{"did": did}, nil) == nil)
+	}
+	assert.Assert(t, c.InsertOneManaged(math.MaxInt64, bson.M{"did": int64(math.MaxInt64)}, nil) == nil)
+
+	datas, err := c.GetRange(100, 200, nil)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, len(datas) == 6, len(datas))
+	for _, did := range []int64{100, 101, 103, 104, 107, 200} {
+		assert.Assert(t, datas[did]["did"] == did)
+	}
+
+	datas, err = c.GetRange(201, math.MaxInt64, nil)
+	assert.Assert(t, err == nil && len(datas) == 2)
+	datas, err = c.GetRange(105, 106, nil)
+	assert.Assert(t, err == nil && len(datas) == 0)
+	datas, err = c.GetRange(200, 100, nil)
+	assert.Assert(t, err == nil && len(datas) == 0)
+}
+
+// onFirstGetKVDB calls hook once on the first Get of read only txns after hook is armed
+type onFirstGetKVDB struct {
+	mondis.KVDB
+	hook atomic.Value
+}
+
+type onFirstGetTxn struct {
+	mondis.ProviderTxn
+	kvdb *onFirstGetKVDB
+}
+
+func (o *onFirstGetKVDB) NewTransaction(update bool) mondis.ProviderTxn {
+	txn := o.KVDB.NewTransaction(update)
+	if update {
+		return txn
+	}
+	return &onFirstGetTxn{ProviderTxn: txn, kvdb: o}
+}
+
+func (t *onFirstGetTxn) Get(k []byte) ([]byte, mondis.VMetaResp, error) {
+	if hook, ok := t.kvdb.hook.Load().(func()); ok && hook != nil {
+		t.kvdb.hook.Store((func())(nil))
+		hook()
+	}
+	return t.ProviderTxn.Get(k)
+}
+
+func TestMultiGet(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := &onFirstGetKVDB{KVDB: provider.NewBadger()}
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	users, err := db.Collection("users")
+	assert.Assert(t, err == nil)
+	orders, err := db.Collection("orders")
+	assert.Assert(t, err == nil)
+	_, err = db.CountersCollection("counters")
+	assert.Assert(t, err == nil)
+
+	uid, err := users.InsertOne(bson.M{"name": "a"}, nil)
+	assert.Assert(t, err == nil)
+	oid, err := orders.InsertOne(bson.M{"user": uid, "total": 1}, nil)
+	assert.Assert(t, err == nil)
+
+	// the order is updated after the txn of MultiGet starts, which it must not see
+	kvdb.hook.Store(func() {
+		exists, err := orders.UpdateOne(oid, bson.M{"user": uid, "total": 2}, nil)
+		assert.Assert(t, err == nil && exists)
+	})
+	refs := []document.DocRef{
+		{CollectionName: "users", ID: uid},
+		{CollectionName: "orders", ID: oid},
+		{CollectionName: "orders", ID: oid + 100},
+		{CollectionName: "none", ID: 1},
+	}
+	docs, err := db.MultiGet(refs)
+	assert.Assert(t, err == nil && len(docs) == 2, err)
+	assert.Assert(t, docs[refs[0]]["name"] == "a")
+	assert.Assert(t, docs[refs[1]]["total"] == int32(1), docs[refs[1]])
+
+	docs, err = db.MultiGet(refs[1:2])
+	assert.Assert(t, err == nil && docs[refs[1]]["total"] == int32(2), docs)
+
+	// missing collections are not created
+	_, err = db.MultiGet([]document.DocRef{{CollectionName: "none", ID: 1}})
+	assert.Assert(t, err == nil)
+	exists, err := kvdb.Exists(document.EncodeMetaCollectionName2IDKey(nil, "none"))
+	assert.Assert(t, err == nil && !exists)
+
+	_, err = db.MultiGet([]document.DocRef{{CollectionName: "counters", ID: 1}})
+	assert.Assert(t, err == document.ErrCollectionKindMismatch, err)
+}
+
+func TestClone(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+	cloneDir := dataDir + "_clone"
+	os.RemoveAll(cloneDir)
+	defer os.RemoveAll(cloneDir)
+	cloneKVDB := provider.NewBadger()
+	err = cloneKVDB.Open(mondis.KVOption{Dir: cloneDir})
+	assert.Assert(t, err == nil)
+	defer cloneKVDB.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	users, err := db.Collection("users", document.CollectionOption{CreationTimeIndex: true})
+	assert.Assert(t, err == nil)
+	_, err = users.CreateIndex(document.IndexDefinition{Name: "name", Fields: []document.IndexField{{Name: "name"}}, Option: document.IndexOption{Unique: true}})
+	assert.Assert(t, err == nil)
+	inserted, _, err := users.UpsertByKey("name", []bson.M{{"name": "a"}, {"name": "b"}}, nil)
+	assert.Assert(t, err == nil && inserted == 2)
+	// beyond the lease of the sequence
+	err = users.InsertOneManaged(100000, bson.M{"name": "managed"}, nil)
+	assert.Assert(t, err == nil)
+	counters, err := db.CountersCollection("counters")
+	assert.Assert(t, err == nil)
+	_, err = counters.IncByName("hits", 3, nil)
+	assert.Assert(t, err == nil)
+
+	cloneDB := document.NewDB(cloneKVDB)
+	defer cloneDB.Close()
+	selfDB := document.NewDB(kvdb)
+	assert.Assert(t, db.Clone(selfDB) == document.ErrCloneToSelf)
+	selfDB.Close()
+	err = db.Clone(cloneDB)
+	assert.Assert(t, err == nil, err)
+
+	// opened by Clone already
+	cloned, err := cloneDB.Collection("users")
+	assert.Assert(t, err == nil)
+	cloned.SetCreationTimeIndex(true)
+	var srcDids, dstDids []int64
+	var maxDid int64
+	err = users.ForEach(func(did int64, doc bson.M) bool {
+		srcDids = append(srcDids, did)
+		return true
+	}, nil)
+	assert.Assert(t, err == nil)
+	err = cloned.ForEach(func(did int64, doc bson.M) bool {
+		dstDids = append(dstDids, did)
+		maxDid = did
+		return true
+	}, nil)
+	assert.Assert(t, err == nil && len(srcDids) == 3 && maxDid == 100000)
+	for i := range srcDids {
+		assert.Assert(t, srcDids[i] == dstDids[i])
+	}
+	doc, err := cloned.GetOne(srcDids[0], nil)
+	assert.Assert(t, err == nil && doc["name"] == "a", doc)
+	assert.Assert(t, len(cloned.GetIndexes()) == 1)
+
+	// inserts on the clone never take cloned ids
+	did, err := cloned.InsertOne(bson.M{"name": "new"}, nil)
+	assert.Assert(t, err == nil && did > maxDid, did)
+	// unique index entries are cloned
+	inserted, updated, err := cloned.UpsertByKey("name", []bson.M{{"name": "a", "v": 1}}, nil)
+	assert.Assert(t, err == nil && inserted == 0 && updated == 1)
+	// so are creation time index entries
+	found, _, err := cloned.FindByTimeRange(time.Unix(0, 0), time.Now().Add(time.Hour), document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 4, found)
+
+	clonedCounters, err := cloneDB.CountersCollection("counters")
+	assert.Assert(t, err == nil)
+	n, err := clonedCounters.GetCounterByName("hits", nil)
+	assert.Assert(t, err == nil && n == 3, n)
+}
+
+func TestFindPage(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	for i := 0; i < 7; i++ {
+		_, err = c.InsertOne(bson.M{"i": int32(i), "even": i%2 == 0}, nil)
+		assert.Assert(t, err == nil)
+	}
+
+	result, err := c.FindPage(nil, 1, 3, nil)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, result.Total == 7 && result.TotalPages == 3 && result.HasNext && len(result.Docs) == 3)
+	assert.Assert(t, result.Docs[0]["i"] == int32(0) && result.Docs[2]["i"] == int32(2))
+
+	result, err = c.FindPage(nil, 3, 3, nil)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, !result.HasNext && len(result.Docs) == 1 && result.Docs[0]["i"] == int32(6))
+
+	result, err = c.FindPage(bson.M{"even": true}, 1, 3, map[string]int{"i": -1})
+	assert.Assert(t, err == nil)
+	assert.Assert(t, result.Total == 4 && result.TotalPages == 2 && result.HasNext && len(result.Docs) == 3)
+	assert.Assert(t, result.Docs[0]["i"] == int32(6) && result.Docs[2]["i"] == int32(2))
+
+	result, err = c.FindPage(bson.M{"even": true}, 2, 3, map[string]int{"i": -1})
+	assert.Assert(t, err == nil)
+	assert.Assert(t, !result.HasNext && len(result.Docs) == 1 && result.Docs[0]["i"] == int32(0))
+
+	result, err = c.FindPage(nil, 4, 3, nil)
+	assert.Assert(t, err == nil && len(result.Docs) == 0 && result.Total == 7)
+
+	_, err = c.FindPage(nil, 0, 3, nil)
+	assert.Assert(t, err == document.ErrInvalidPage)
+}
+
+func TestReserveDids(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	// each loader has its own db like in separate processes
+	loaders, bands, bandSize := 4, 5, 100
+	var cs []*document.Collection
+	for i := 0; i < loaders; i++ {
+		db := document.NewDB(kvdb)
+		defer db.Close()
+		c, err := db.Collection("c")
+		assert.Assert(t, err == nil)
+		cs = append(cs, c)
+	}
+
+	_, err = cs[0].ReserveDids(0)
+	assert.Assert(t, err == document.ErrZeroBandwidth)
+
+	getLease := func() uint64 {
+		v, _, err := kvdb.Get(document.EncodeMetaSequenceKey(nil, []byte("c")))
+		assert.Assert(t, err == nil)
+		lease, err := numeric.DecodeFromBinary(v)
+		assert.Assert(t, err == nil)
+		return lease
+	}
+	leaseBefore := getLease()
+
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+		mu   sync.Mutex
+		dids = make(map[int64]bool)
+	)
+	for _, c := range cs {
+		wg.Add(1)
+		go func(c *document.Collection) {
+			defer wg.Done()
+			for i := 0; i < bands; i++ {
+				band, err := c.ReserveDids(bandSize)
+				if err == nil && len(band) != bandSize {
+					err = fmt.Errorf("band of %d dids", len(band))
+				}
+				if errs.add(err) {
+					return
+				}
+				for _, did := range band {
+					err = c.InsertOneManaged(did, bson.M{"did": did}, nil)
+					if errs.add(err) {
+						return
+					}
+				}
+				mu.Lock()
+				for _, did := range band {
+					if dids[did] {
+						errs.add(fmt.Errorf("did %d reserved twice", did))
+					}
+					dids[did] = true
+				}
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	errs.check(t)
+
+	total := loaders * bands * bandSize
+	assert.Assert(t, len(dids) == total)
+	n, _, err := cs[0].Count(document.CountOption{Exact: true}, nil)
+	assert.Assert(t, err == nil && n == int64(total), n, err)
+	// the lease is advanced only by the bands
+	assert.Assert(t, getLease() == leaseBefore+uint64(total))
+
+	// ids allocated afterwards don't collide with reserved ones
+	did, err := cs[0].InsertOne(bson.M{}, nil)
+	assert.Assert(t, err == nil && !dids[did], did)
+}
+
+func TestSequenceMultiProcess(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	// two sequences on the same key like in separate processes,
+	// a tiny bandwidth makes them extend leases concurrently all the time
+	keyword := []byte("seq")
+	var seqs []*document.Sequence
+	for i := 0; i < 2; i++ {
+		seq, err := document.NewSequence(kvdb, keyword, 2)
+		assert.Assert(t, err == nil)
+		seqs = append(seqs, seq)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		errs      goErrs
+		mu        sync.Mutex
+		ids       = make(map[uint64]bool)
+		conflicts int64
+	)
+	issued := func(batch ...uint64) {
+		mu.Lock()
+		for _, id := range batch {
+			if ids[id] {
+				errs.add(fmt.Errorf("id %d issued twice", id))
+			}
+			ids[id] = true
+		}
+		mu.Unlock()
+	}
+	for _, seq := range seqs {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(seq *document.Sequence, band bool) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					if band {
+						start, end, err := seq.AllocateBand(3)
+						if err == document.ErrSequenceConflict {
+							atomic.AddInt64(&conflicts, 1)
+							continue
+						}
+						if errs.add(err) {
+							return
+						}
+						issued(start, start+1, end-1)
+						continue
+					}
+					id, err := seq.Next()
+					if err == document.ErrSequenceConflict {
+						atomic.AddInt64(&conflicts, 1)
+						continue
+					}
+					if errs.add(err) {
+						return
+					}
+					issued(id)
+				}
+			}(seq, g == 0)
+		}
+	}
+	wg.Wait()
+	errs.check(t)
+	assert.Assert(t, len(ids) > 0 && conflicts < int64(len(ids)), len(ids), conflicts)
+}
+
+func TestSequenceSetIfGreater(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	keyword := []byte("imported")
+	a, err := document.NewSequence(kvdb, keyword, 10)
+	assert.Assert(t, err == nil)
+	b, err := document.NewSequence(kvdb, keyword, 10)
+	assert.Assert(t, err == nil)
+	cur, err := a.Cur()
+	assert.Assert(t, err == nil && cur == 20, cur)
+
+	val, err := a.Next()
+	assert.Assert(t, err == nil && val == 1)
+	assert.Assert(t, b.SetIfGreater(100) == nil)
+	cur, err = b.Cur()
+	assert.Assert(t, err == nil && cur == 100, cur)
+	// lower is a no-op
+	assert.Assert(t, b.SetIfGreater(50) == nil)
+	val, err = b.Next()
+	assert.Assert(t, err == nil && val == 101, val)
+	cur, err = a.Cur()
+	assert.Assert(t, err == nil && cur == 110, cur)
+	// the lease of a is below, so it's dropped
+	assert.Assert(t, a.SetIfGreater(5) == nil)
+	val, err = a.Next()
+	assert.Assert(t, err == nil && val == 111, val)
+
+	// initial value
+	c, err := document.NewSequence(kvdb, []byte("initial"), 10, document.SequenceOption{Initial: 1000})
+	assert.Assert(t, err == nil)
+	val, err = c.Next()
+	assert.Assert(t, err == nil && val == 1001, val)
+	c, err = document.NewSequence(kvdb, []byte("initial"), 10, document.SequenceOption{Initial: 500})
+	assert.Assert(t, err == nil)
+	val, err = c.Next()
+	assert.Assert(t, err == nil && val == 1011, val)
+
+	// concurrent with Next of another instance
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+		mu   sync.Mutex
+		seen = make(map[uint64]bool)
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				val, err := a.Next()
+				if errs.add(err) {
+					return
+				}
+				mu.Lock()
+				if seen[val] {
+					errs.add(fmt.Errorf("%d issued twice", val))
+				}
+				seen[val] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	for v := uint64(200); v <= 2000; v += 200 {
+		assert.Assert(t, b.SetIfGreater(v) == nil)
+	}
+	wg.Wait()
+	errs.check(t)
+	cur, err = b.Cur()
+	assert.Assert(t, err == nil && cur >= 2000)
+	val, err = b.Next()
+	assert.Assert(t, err == nil && val > 2000, val)
+}
+
+func TestSequenceJournal(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	// released leases are reused
+	for _, option := range []document.SequenceOption{{}, {Journal: true}} {
+		keyword := []byte(fmt.Sprintf("released %v", option.Journal))
+		seq, err := document.NewSequence(kvdb, keyword, 10, option)
+		assert.Assert(t, err == nil)
+		val, err := seq.Next()
+		assert.Assert(t, err == nil && val == 1)
+		assert.Assert(t, seq.ReleaseRemaining() == nil)
+		cur, err := seq.Cur()
+		assert.Assert(t, err == nil && cur == 1, cur)
+
+		seq, err = document.NewSequence(kvdb, keyword, 10, option)
+		assert.Assert(t, err == nil && seq.Lost() == 0)
+		val, err = seq.Next()
+		assert.Assert(t, err == nil && val == 2, val)
+	}
+
+	// a lease not released is reported
+	keyword := []byte("crashed")
+	seq, err := document.NewSequence(kvdb, keyword, 10, document.SequenceOption{Journal: true})
+	assert.Assert(t, err == nil && seq.Lost() == 0)
+	_, err = seq.Next()
+	assert.Assert(t, err == nil)
+	seq, err = document.NewSequence(kvdb, keyword, 10, document.SequenceOption{Journal: true})
+	assert.Assert(t, err == nil && seq.Lost() == 10, seq.Lost())
+	val, err := seq.Next()
+	assert.Assert(t, err == nil && val == 11, val)
+	assert.Assert(t, seq.ReleaseRemaining() == nil)
+	seq, err = document.NewSequence(kvdb, keyword, 10, document.SequenceOption{Journal: true})
+	assert.Assert(t, err == nil && seq.Lost() == 0)
+	val, err = seq.Next()
+	assert.Assert(t, err == nil && val == 12, val)
+
+	// not released once others have leased after it
+	a, err := document.NewSequence(kvdb, []byte("shared"), 10)
+	assert.Assert(t, err == nil)
+	b, err := document.NewSequence(kvdb, []byte("shared"), 10)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, a.ReleaseRemaining() == nil)
+	val, err = b.Next()
+	assert.Assert(t, err == nil && val == 11, val)
+	cur, err := a.Cur()
+	assert.Assert(t, err == nil && cur == 20, cur)
+}
+
+func TestSequenceReleaseDurable(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+
+	keyword := []byte("durable")
+	seq, err := document.NewSequence(kvdb, keyword, 10)
+	assert.Assert(t, err == nil)
+	for i := uint64(1); i <= 3; i++ {
+		val, err := seq.Next()
+		assert.Assert(t, err == nil && val == i, val)
+	}
+	assert.Assert(t, seq.ReleaseRemaining() == nil)
+	assert.Assert(t, kvdb.Close() == nil)
+
+	// the release is committed, so the released range is not skipped after reopen
+	kvdb = provider.NewBadger()
+	err = kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+	seq, err = document.NewSequence(kvdb, keyword, 10)
+	assert.Assert(t, err == nil)
+	val, err := seq.Next()
+	assert.Assert(t, err == nil && val == 4, val)
+}
+
+func TestSequencePeek(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	seq, err := document.NewSequence(kvdb, []byte("peek"), 2)
+	assert.Assert(t, err == nil)
+	for i := uint64(1); i <= 5; i++ {
+		val, err := seq.Peek()
+		assert.Assert(t, err == nil && val == i, val)
+		val, err = seq.Peek()
+		assert.Assert(t, err == nil && val == i, val)
+		val, err = seq.Next()
+		assert.Assert(t, err == nil && val == i, val)
+	}
+	val, err := seq.Next()
+	assert.Assert(t, err == nil && val == 6, val)
+	// the lease used up by Next is extended by Peek
+	cur, err := seq.Cur()
+	assert.Assert(t, err == nil && cur == 6, cur)
+	val, err = seq.Peek()
+	assert.Assert(t, err == nil && val == 7, val)
+	cur, err = seq.Cur()
+	assert.Assert(t, err == nil && cur == 8, cur)
+
+	seed := []byte("peek near ceiling")
+	err = kvdb.Set(document.EncodeMetaSequenceKey(nil, seed), numeric.Encode2Binary(math.MaxUint64-1, nil), nil)
+	assert.Assert(t, err == nil)
+	seq, err = document.NewSequence(kvdb, seed, 2)
+	assert.Assert(t, err == nil)
+	val, err = seq.Next()
+	assert.Assert(t, err == nil && val == math.MaxUint64)
+	_, err = seq.Peek()
+	assert.Assert(t, err == document.ErrSequenceExhausted, err)
+}
+
+func TestSequenceReset(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	keyword := []byte("reset")
+	seq, err := document.NewSequence(kvdb, keyword, 10, document.SequenceOption{Journal: true})
+	assert.Assert(t, err == nil)
+	val, err := seq.Next()
+	assert.Assert(t, err == nil && val == 1)
+
+	// higher, then lower
+	for _, v := range []uint64{500, 3} {
+		assert.Assert(t, seq.Reset(v) == nil)
+		cur, err := seq.Cur()
+		assert.Assert(t, err == nil && cur == v, cur)
+		val, err = seq.Next()
+		assert.Assert(t, err == nil && val == v+1, val)
+	}
+	// nothing leased is left after Reset, so the journal is cleared
+	assert.Assert(t, seq.Reset(100) == nil)
+	seq, err = document.NewSequence(kvdb, keyword, 10, document.SequenceOption{Journal: true})
+	assert.Assert(t, err == nil && seq.Lost() == 0, seq.Lost())
+	val, err = seq.Next()
+	assert.Assert(t, err == nil && val == 101, val)
+
+	// concurrent with Next, values are unique and either from the lease before or after value
+	const value = 10000
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+		mu   sync.Mutex
+		seen = make(map[uint64]bool)
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				val, err := seq.Next()
+				if errs.add(err) {
+					return
+				}
+				mu.Lock()
+				if seen[val] {
+					errs.add(fmt.Errorf("%d issued twice", val))
+				}
+				seen[val] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	assert.Assert(t, seq.Reset(value) == nil)
+	wg.Wait()
+	errs.check(t)
+	for val := range seen {
+		assert.Assert(t, val > value || val < value-400, val)
+	}
+	val, err = seq.Next()
+	assert.Assert(t, err == nil && val > value, val)
+}
+
+func TestSequenceExhausted(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	seed := func(keyword []byte, stored uint64) {
+		err := kvdb.Set(document.EncodeMetaSequenceKey(nil, keyword), numeric.Encode2Binary(stored, nil), nil)
+		assert.Assert(t, err == nil)
+	}
+
+	keyword := []byte("near ceiling")
+	seed(keyword, math.MaxUint64-3)
+	seq, err := document.NewSequence(kvdb, keyword, 2)
+	assert.Assert(t, err == nil)
+	// the last lease is narrowed to what's left
+	for i := uint64(2); i > 0; i-- {
+		val, err := seq.Next()
+		assert.Assert(t, err == nil && val == math.MaxUint64-i, val)
+	}
+	val, err := seq.Next()
+	assert.Assert(t, err == nil && val == math.MaxUint64)
+	for i := 0; i < 2; i++ {
+		_, err = seq.Next()
+		assert.Assert(t, err == document.ErrSequenceExhausted, err)
+	}
+
+	keyword = []byte("band near ceiling")
+	seed(keyword, math.MaxUint64-10)
+	seq, err = document.NewSequence(kvdb, keyword, 1)
+	assert.Assert(t, err == nil)
+	// the exclusive end can't be represented
+	_, _, err = seq.AllocateBand(9)
+	assert.Assert(t, err == document.ErrSequenceExhausted)
+	start, end, err := seq.AllocateBand(8)
+	assert.Assert(t, err == nil && start == math.MaxUint64-8 && end == math.MaxUint64, start, end)
+	_, _, err = seq.AllocateBand(1)
+	assert.Assert(t, err == document.ErrSequenceExhausted)
+}
+
+type recordingMetrics struct {
+	mu       sync.Mutex
+	ops      map[string]int
+	errs     map[string]int
+	leases   map[string]int
+	hits     int
+	misses   int
+	negative bool
+}
+
+func (m *recordingMetrics) ObserveOp(collection string, op document.MetricOp, err error, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := collection + "." + op.String()
+	m.ops[key]++
+	if err != nil {
+		m.errs[key]++
+	}
+	if latency < 0 {
+		m.negative = true
+	}
+}
+
+func (m *recordingMetrics) SequenceLeaseExtended(keyword string) {
+	m.mu.Lock()
+	m.leases[keyword]++
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) CollectionCacheAccess(hit bool) {
+	m.mu.Lock()
+	if hit {
+		m.hits++
+	} else {
+		m.misses++
+	}
+	m.mu.Unlock()
+}
+
+// commitGate holds commits of update txns until ch is closed, if ch is set
+type commitGate struct {
+	mondis.KVDB
+	ch chan struct{}
+}
+
+type gatedTxn struct {
+	mondis.ProviderTxn
+	ch chan struct{}
+}
+
+func (g *commitGate) NewTransaction(update bool) mondis.ProviderTxn {
+	txn := g.KVDB.NewTransaction(update)
+	if !update || g.ch == nil {
+		return txn
+	}
+	return &gatedTxn{ProviderTxn: txn, ch: g.ch}
+}
+
+func (txn *gatedTxn) Commit() error {
+	<-txn.ch
+	return txn.ProviderTxn.Commit()
+}
+
+func TestImplicitTxnLimit(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	gate := &commitGate{KVDB: kvdb}
+	db := document.NewDB(gate, document.DBOption{MaxImplicitTxns: 2, ImplicitTxnWait: time.Millisecond * 20})
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+	did, err := c.InsertOne(bson.M{"i": 0}, nil)
+	assert.Assert(t, err == nil)
+
+	gate.ch = make(chan struct{})
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+	)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := c.InsertOne(bson.M{"i": i}, nil)
+			errs.add(err)
+		}(i)
+	}
+	for db.ImplicitTxns() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = c.InsertOne(bson.M{"i": 2}, nil)
+	assert.Assert(t, err == document.ErrTooBusy, err)
+	_, err = c.GetOne(did, nil)
+	assert.Assert(t, err == document.ErrTooBusy, err)
+	// txns of callers are not limited, neither is ForEach
+	txn := kvdb.NewTransaction(true)
+	_, err = c.InsertOne(bson.M{"i": 2}, txn)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, txn.Commit() == nil)
+	assert.Assert(t, c.ForEach(func(int64, bson.M) bool { return true }, nil) == nil)
+	assert.Assert(t, db.ImplicitTxns() == 2)
+
+	close(gate.ch)
+	wg.Wait()
+	errs.check(t)
+	assert.Assert(t, db.ImplicitTxns() == 0)
+	n, _, err := c.Count(document.CountOption{Exact: true}, nil)
+	assert.Assert(t, err == nil && n == 4, n)
+
+	// a synthetic spike of updates on a few hot documents, the protective effect is reported by -v
+	spike := func(max int) (p99 time.Duration, conflictRate float64) {
+		os.RemoveAll(dataDir)
+		kvdb := provider.NewBadger()
+		err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+		assert.Assert(t, err == nil)
+		defer kvdb.Close()
+		db := document.NewDB(kvdb, document.DBOption{MaxImplicitTxns: max, ImplicitTxnWait: time.Minute})
+		defer db.Close()
+		c, err := db.Collection("c")
+		assert.Assert(t, err == nil)
+		for did := int64(1); did <= 4; did++ {
+			assert.Assert(t, c.InsertOneManaged(did, bson.M{"n": 0}, nil) == nil)
+		}
+
+		const (
+			workers = 64
+			rounds  = 20
+		)
+		var (
+			mu        sync.Mutex
+			latencies []time.Duration
+			conflicts int
+			wg        sync.WaitGroup
+			errs      goErrs
+		)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for r := 0; r < rounds; r++ {
+					start := time.Now()
+					_, err := c.UpdateOne(int64(w%4)+1, bson.M{"n": r}, nil)
+					latency := time.Since(start)
+					if err != kv.ErrTxnConflict {
+						errs.add(err)
+					}
+					mu.Lock()
+					latencies = append(latencies, latency)
+					if err != nil {
+						conflicts++
+					}
+					mu.Unlock()
+				}
+			}(w)
+		}
+		wg.Wait()
+		errs.check(t)
+		assert.Assert(t, db.ImplicitTxns() == 0)
+
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		p99 = latencies[len(latencies)*99/100]
+		conflictRate = float64(conflicts) / float64(len(latencies))
+		return
+	}
+	p99, conflictRate := spike(0)
+	t.Logf("spike without limiter: p99 %v, conflict rate %.3f", p99, conflictRate)
+	p99, conflictRate = spike(4)
+	t.Logf("spike with limiter: p99 %v, conflict rate %.3f", p99, conflictRate)
+}
+
+func TestDocumentMetrics(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	metrics := &recordingMetrics{ops: make(map[string]int), errs: make(map[string]int), leases: make(map[string]int)}
+	db := document.NewDB(kvdb, document.DBOption{Metrics: metrics})
+	defer db.Close()
+
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+	_, err = db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	// one more than the band leased by the collection
+	var did int64
+	for i := 0; i < 1001; i++ {
+		did, err = c.InsertOne(bson.M{"i": i}, nil)
+		assert.Assert(t, err == nil)
+	}
+	_, err = c.InsertOne(bson.M{db.SystemField(document.SystemFieldRev): 1}, nil)
+	assert.Assert(t, err == document.ErrReservedField)
+	err = c.InsertOneManaged(did, bson.M{"i": 0}, nil)
+	assert.Assert(t, err == document.ErrDocIDExists)
+
+	exists, err := c.UpdateOne(did, bson.M{"i": 0}, nil)
+	assert.Assert(t, err == nil && exists)
+	isNew, err := c.MergeUpsert(did+1, bson.M{"i": 1}, nil)
+	assert.Assert(t, err == nil && isNew)
+
+	_, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil)
+	_, err = c.GetOne(did+2, nil)
+	assert.Assert(t, err == document.ErrDocNotFound)
+	_, err = c.GetMany([]int64{did, did + 2}, nil)
+	assert.Assert(t, err == document.ErrDocNotFound)
+	_, err = c.GetRange(1, did, nil)
+	assert.Assert(t, err == nil)
+
+	assert.Assert(t, c.DeleteOne(did, nil) == nil)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	assert.DeepEqual(t, metrics.ops, map[string]int{"c.insert": 1003, "c.update": 2, "c.get": 4, "c.delete": 1})
+	assert.DeepEqual(t, metrics.errs, map[string]int{"c.insert": 2, "c.get": 2})
+	assert.DeepEqual(t, metrics.leases, map[string]int{"c": 1})
+	assert.Assert(t, metrics.hits == 1 && metrics.misses == 1, metrics.hits, metrics.misses)
+	assert.Assert(t, !metrics.negative)
+}
+
+func TestApplyBatch(t *testing.T) {
+	kvdb := provider.NewMemory()
+	err := kvdb.Open(mondis.KVOption{})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	assert.Assert(t, c.InsertOneManaged(7, bson.M{"v": "old"}, nil) == nil)
+
+	// more than one batch
+	entries := make(map[int64]bson.M)
+	for did := int64(1); did <= 250; did++ {
+		entries[did] = bson.M{"v": did}
+	}
+	inserted, replaced, err := c.ApplyBatch(entries, nil)
+	assert.Assert(t, err == nil && len(inserted) == 249, err)
+	assert.DeepEqual(t, replaced, []int64{7})
+	for i, did := range inserted {
+		assert.Assert(t, did != 7 && (i == 0 || did > inserted[i-1]))
+	}
+	data, err := c.GetOne(7, nil)
+	assert.Assert(t, err == nil && data["v"] == int64(7), data)
+
+	// replaying is idempotent
+	inserted, replaced, err = c.ApplyBatch(entries, nil)
+	assert.Assert(t, err == nil && len(inserted) == 0 && len(replaced) == 250, err)
+	n, _, err := c.Count(document.CountOption{Exact: true}, nil)
+	assert.Assert(t, err == nil && n == 250, n)
+
+	// within txn, the caller discards it on error
+	txn := kvdb.NewTransaction(true)
+	_, _, err = c.ApplyBatch(map[int64]bson.M{300: {"v": 1}, 301: {db.SystemField(document.SystemFieldRev): 1}}, txn)
+	assert.Assert(t, err == document.ErrReservedField)
+	txn.Discard()
+	_, err = c.GetOne(300, nil)
+	assert.Assert(t, err == document.ErrDocNotFound)
+}
+
+func TestUpsertByKey(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	// more than one batch
+	n := 250
+	docs := func(version int) (docs []bson.M) {
+		for i := 0; i < n; i++ {
+			docs = append(docs, bson.M{"email": fmt.Sprintf("%d@example.com", i), "version": int32(version)})
+		}
+		return
+	}
+
+	_, _, err = c.UpsertByKey("email", docs(1), nil)
+	assert.Assert(t, err == document.ErrUniqueIndexRequired)
+	// written before the index, found by the backfill of CreateIndex
+	earlyDid, err := c.InsertOne(bson.M{"email": "early@example.com", "version": int32(1)}, nil)
+	assert.Assert(t, err == nil)
+	_, err = c.CreateIndex(document.IndexDefinition{Name: "email", Fields: []document.IndexField{{Name: "email"}}, Option: document.IndexOption{Unique: true}})
+	assert.Assert(t, err == nil)
+	inserted, updated, err := c.UpsertByKey("email", []bson.M{{"email": "early@example.com", "version": int32(2)}}, nil)
+	assert.Assert(t, err == nil && inserted == 0 && updated == 1, inserted, updated, err)
+	doc, err := c.GetOne(earlyDid, nil)
+	assert.Assert(t, err == nil && doc["version"] == int32(2), doc)
+	err = c.DeleteOne(earlyDid, nil)
+	assert.Assert(t, err == nil)
+
+	_, _, err = c.UpsertByKey("email", []bson.M{{"version": int32(1)}}, nil)
+	assert.Assert(t, err == document.ErrKeyFieldMissing)
+
+	inserted, updated, err = c.UpsertByKey("email", docs(1), nil)
+	assert.Assert(t, err == nil && inserted == n && updated == 0, inserted, updated, err)
+
+	// the second sync updates instead of duplicating
+	inserted, updated, err = c.UpsertByKey("email", docs(2), nil)
+	assert.Assert(t, err == nil && inserted == 0 && updated == n, inserted, updated, err)
+
+	count, _, err := c.Count(document.CountOption{Exact: true}, nil)
+	assert.Assert(t, err == nil && count == int64(n), count, err)
+	err = c.ForEach(func(did int64, doc bson.M) bool {
+		assert.Assert(t, doc["version"] == int32(2), doc)
+		return true
+	}, nil)
+	assert.Assert(t, err == nil)
+
+	// in the caller's txn, a duplicate key in the same batch updates the former one
+	txn := kvdb.NewTransaction(true)
+	inserted, updated, err = c.UpsertByKey("email", []bson.M{{"email": "new@example.com"}, {"email": "new@example.com"}}, txn)
+	assert.Assert(t, err == nil && inserted == 1 && updated == 1, inserted, updated, err)
+	assert.Assert(t, txn.Commit() == nil)
+	count, _, err = c.Count(document.CountOption{Exact: true}, nil)
+	assert.Assert(t, err == nil && count == int64(n+1), count, err)
+
+	// a document inserted normally is updated instead of duplicated
+	did, err := c.InsertOne(bson.M{"email": "inserted@example.com", "version": int32(1)}, nil)
+	assert.Assert(t, err == nil)
+	inserted, updated, err = c.UpsertByKey("email", []bson.M{{"email": "inserted@example.com", "version": int32(2)}}, nil)
+	assert.Assert(t, err == nil && inserted == 0 && updated == 1, inserted, updated, err)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc["version"] == int32(2), doc)
+
+	// after the key is changed by UpdateOne, the old key inserts and the new key updates
+	_, err = c.UpdateOne(did, bson.M{"email": "renamed@example.com", "version": int32(3)}, nil)
+	assert.Assert(t, err == nil)
+	inserted, updated, err = c.UpsertByKey("email", []bson.M{{"email": "inserted@example.com", "version": int32(4)}}, nil)
+	assert.Assert(t, err == nil && inserted == 1 && updated == 0, inserted, updated, err)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc["email"] == "renamed@example.com" && doc["version"] == int32(3), doc)
+	inserted, updated, err = c.UpsertByKey("email", []bson.M{{"email": "renamed@example.com", "version": int32(5)}}, nil)
+	assert.Assert(t, err == nil && inserted == 0 && updated == 1, inserted, updated, err)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc["version"] == int32(5), doc)
+
+	// so does a key changed by Merge
+	err = c.Merge(did, bson.M{"email": "merged@example.com"}, nil)
+	assert.Assert(t, err == nil)
+	inserted, updated, err = c.UpsertByKey("email", []bson.M{{"email": "renamed@example.com"}, {"email": "merged@example.com", "version": int32(6)}}, nil)
+	assert.Assert(t, err == nil && inserted == 1 && updated == 1, inserted, updated, err)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc["version"] == int32(6), doc)
+	err = c.Merge(did, bson.M{"email": "renamed@example.com"}, nil)
+	assert.Assert(t, err == document.ErrUniqueViolated, err)
+
+	// a value taken by another document is rejected
+	_, err = c.InsertOne(bson.M{"email": "inserted@example.com"}, nil)
+	assert.Assert(t, err == document.ErrUniqueViolated, err)
+	_, err = c.UpdateOne(did, bson.M{"email": "new@example.com"}, nil)
+	assert.Assert(t, err == document.ErrUniqueViolated, err)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc["email"] == "merged@example.com", doc)
+
+	// a deleted document is inserted again
+	err = c.DeleteOne(did, nil)
+	assert.Assert(t, err == nil)
+	inserted, updated, err = c.UpsertByKey("email", []bson.M{{"email": "merged@example.com"}}, nil)
+	assert.Assert(t, err == nil && inserted == 1 && updated == 0, inserted, updated, err)
+	count, _, err = c.Count(document.CountOption{Exact: true}, nil)
+	assert.Assert(t, err == nil && count == int64(n+4), count, err)
+
+	// existing duplicates fail CreateIndex, which leaves no index behind
+	for i := 0; i < 2; i++ {
+		_, err = c.InsertOne(bson.M{"name": "dup"}, nil)
+		assert.Assert(t, err == nil)
+	}
+	_, err = c.CreateIndex(document.IndexDefinition{Name: "name", Fields: []document.IndexField{{Name: "name"}}, Option: document.IndexOption{Unique: true}})
+	assert.Assert(t, err == document.ErrUniqueViolated, err)
+	assert.Assert(t, len(c.GetIndexes()) == 1)
+}
+
+func TestReservedFields(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+	_, err = c.CreateIndex(document.IndexDefinition{Name: "k", Fields: []document.IndexField{{Name: "k"}}, Option: document.IndexOption{Unique: true}})
+	assert.Assert(t, err == nil)
+
+	did, err := c.InsertOne(bson.M{"k": "v", "_rev": int32(1), "_deleted": false}, nil)
+	assert.Assert(t, err == nil)
+
+	for _, name := range []string{document.SystemFieldRev, document.SystemFieldDeleted, document.SystemFieldDid} {
+		doc := bson.M{"k": "v", db.SystemField(name): int32(1)}
+		_, err = c.InsertOne(doc, nil)
+		assert.Assert(t, err == document.ErrReservedField, name)
+		err = c.InsertOneManaged(did+100, doc, nil)
+		assert.Assert(t, err == document.ErrReservedField, name)
+		_, err = c.UpdateOne(did, doc, nil)
+		assert.Assert(t, err == document.ErrReservedField, name)
+		_, err = c.UpsertOne(did, doc, nil)
+		assert.Assert(t, err == document.ErrReservedField, name)
+		err = c.Merge(did, doc, nil)
+		assert.Assert(t, err == document.ErrReservedField, name)
+		_, _, err = c.UpsertByKey("k", []bson.M{doc}, nil)
+		assert.Assert(t, err == document.ErrReservedField, name)
+	}
+
+	// written as user field by a db with another prefix
+	otherDB := document.NewDB(kvdb, document.DBOption{ReservedFieldPrefix: "_other_"})
+	defer otherDB.Close()
+	otherC, err := otherDB.Collection("c")
+	assert.Assert(t, err == nil)
+	rev := db.SystemField(document.SystemFieldRev)
+	err = otherC.Merge(did, bson.M{rev: int32(2)}, nil)
+	assert.Assert(t, err == nil)
+
+	// system fields are stripped from reads by default
+	doc, err := c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc[rev] == nil && doc["_rev"] == int32(1), doc)
+	docs, err := c.GetMany([]int64{did}, nil)
+	assert.Assert(t, err == nil && docs[0][rev] == nil, docs)
+	err = c.ForEach(func(_ int64, doc bson.M) bool {
+		assert.Assert(t, doc[rev] == nil, doc)
+		return true
+	}, nil)
+	assert.Assert(t, err == nil)
+
+	// options only apply when the collection is opened first
+	c, err = db.Collection("c", document.CollectionOption{IncludeSystemFields: true})
+	assert.Assert(t, err == nil)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc[rev] == nil, doc)
+
+	c.SetIncludeSystemFields(true)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc[rev] == int32(2), doc)
+}
+
+func TestFindCtx(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	n := 2000
+	txn := kvdb.NewTransaction(true)
+	for i := 0; i < n; i++ {
+		_, err = c.InsertOne(bson.M{"i": int32(i), "even": i%2 == 0}, txn)
+		assert.Assert(t, err == nil)
+	}
+	err = txn.Commit()
+	assert.Assert(t, err == nil)
+
+	docs, err := c.FindCtx(context.Background(), bson.M{"even": true})
+	assert.Assert(t, err == nil && len(docs) == n/2)
+	assert.Assert(t, docs[0]["i"] == int32(0) && docs[1]["i"] == int32(2))
+
+	// cancelled before scan
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	docs, err = c.FindCtx(ctx, nil)
+	assert.Assert(t, err == context.Canceled && docs == nil)
+
+	// cancelled during scan
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	for {
+		docs, err = c.FindCtx(ctx, nil)
+		if err != nil {
+			break
+		}
+		assert.Assert(t, len(docs) == n)
+	}
+	assert.Assert(t, err == context.Canceled && docs == nil)
+	assert.Assert(t, time.Since(start) < time.Second)
+}
+
+func TestFind(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	var dids []int64
+	for i, doc := range []bson.M{
+		{"name": "a", "age": int32(20)},
+		{"name": "b", "age": int64(30)},
+		{"name": "c", "age": 40.5},
+		{"name": "d", "age": "50"},
+		{"name": "e"},
+	} {
+		did, err := c.InsertOne(doc, nil)
+		assert.Assert(t, err == nil, i)
+		dids = append(dids, did)
+	}
+
+	names := func(filter bson.M) (got []string) {
+		found, docs, err := c.Find(filter, nil)
+		assert.Assert(t, err == nil && len(found) == len(docs), err)
+		for i, doc := range docs {
+			got = append(got, doc["name"].(string))
+			assert.Assert(t, found[i] == dids[doc["name"].(string)[0]-'a'])
+		}
+		return
+	}
+	assert.Assert(t, reflect.DeepEqual(names(nil), []string{"a", "b", "c", "d", "e"}))
+	assert.Assert(t, reflect.DeepEqual(names(bson.M{"name": "b"}), []string{"b"}))
+	// numbers compare across types
+	assert.Assert(t, reflect.DeepEqual(names(bson.M{"age": 30}), []string{"b"}))
+	assert.Assert(t, reflect.DeepEqual(names(bson.M{"age": nil}), []string{"e"}))
+	assert.Assert(t, reflect.DeepEqual(names(bson.M{"name": bson.M{"$in": bson.A{"a", "c", "z"}}}), []string{"a", "c"}))
+	assert.Assert(t, reflect.DeepEqual(names(bson.M{"age": bson.M{"$in": []interface{}{20.0, nil}}}), []string{"a", "e"}))
+	// $gt and $lt only match the same type
+	assert.Assert(t, reflect.DeepEqual(names(bson.M{"age": bson.M{"$gt": 25}}), []string{"b", "c"}))
+	assert.Assert(t, reflect.DeepEqual(names(bson.M{"age": bson.M{"$lt": 40}}), []string{"a", "b"}))
+	assert.Assert(t, reflect.DeepEqual(names(bson.M{"age": bson.M{"$gt": 20, "$lt": 40.5}}), []string{"b"}))
+	assert.Assert(t, reflect.DeepEqual(names(bson.M{"age": bson.M{"$gt": "1"}, "name": "d"}), []string{"d"}))
+	assert.Assert(t, len(names(bson.M{"age": bson.M{"$gt": 100}})) == 0)
+
+	_, _, err = c.Find(bson.M{"age": bson.M{"$ne": 1}}, nil)
+	assert.Assert(t, err == document.ErrInvalidFilter)
+	_, _, err = c.Find(bson.M{"age": bson.M{"$in": 1}}, nil)
+	assert.Assert(t, err == document.ErrInvalidFilter)
+	_, _, err = c.Count(document.CountOption{Filter: bson.M{"age": bson.M{"$ne": 1}}}, nil)
+	assert.Assert(t, err == document.ErrInvalidFilter)
+	n, _, err := c.Count(document.CountOption{Filter: bson.M{"age": bson.M{"$gt": 25}}}, nil)
+	assert.Assert(t, err == nil && n == 2)
+
+	// in txn
+	txn := kvdb.NewTransaction(true)
+	defer txn.Discard()
+	_, err = c.InsertOne(bson.M{"name": "f", "age": 35}, txn)
+	assert.Assert(t, err == nil)
+	_, docs, err := c.Find(bson.M{"age": bson.M{"$gt": 30}}, txn)
+	assert.Assert(t, err == nil && len(docs) == 2 && docs[1]["name"] == "f", docs)
+}
+
+func TestCounters(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	c, err := db.CountersCollection("counters")
+	assert.Assert(t, err == nil && c.Kind() == model.CollectionKindCounters)
+
+	// generic document APIs are rejected
+	_, err = c.InsertOne(bson.M{"a": 1}, nil)
+	assert.Assert(t, err == document.ErrCollectionKindMismatch)
+	_, err = c.GetOne(1, nil)
+	assert.Assert(t, err == document.ErrCollectionKindMismatch)
+	_, err = c.FindCtx(context.Background(), nil)
+	assert.Assert(t, err == document.ErrCollectionKindMismatch)
+
+	// and counters APIs are rejected on document collection
+	dc, err := db.Collection("docs")
+	assert.Assert(t, err == nil && dc.Kind() == model.CollectionKindDocument)
+	_, err = dc.IncBy(1, 1, nil)
+	assert.Assert(t, err == document.ErrCollectionKindMismatch)
+	_, err = db.CountersCollection("docs")
+	assert.Assert(t, err == document.ErrCollectionKindMismatch)
+
+	did, err := c.InsertCounter(10, nil)
+	assert.Assert(t, err == nil)
+	n, err := c.IncBy(did, -3, nil)
+	assert.Assert(t, err == nil && n == 7)
+	err = c.SetCounter(did, math.MaxInt64, nil)
+	assert.Assert(t, err == nil)
+	_, err = c.IncBy(did, 1, nil)
+	assert.Assert(t, err == kv.ErrOverflow)
+	_, err = c.GetCounter(-1, nil)
+	assert.Assert(t, err == document.ErrDocNotFound)
+	err = c.SetCounter(did, 0, nil)
+	assert.Assert(t, err == nil)
+
+	// concurrent increments are not lost
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+	)
+	workers, incs := 10, 20
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < incs; j++ {
+				_, err := c.IncBy(did, 1, nil)
+				errs.add(err)
+			}
+		}()
+	}
+	wg.Wait()
+	errs.check(t)
+	n, err = c.GetCounter(did, nil)
+	assert.Assert(t, err == nil && n == int64(workers*incs))
+
+	// concurrent resolutions of a new name agree on did
+	_, err = c.GetCounterByName("views", nil)
+	assert.Assert(t, err == document.ErrDocNotFound)
+	dids := make([]int64, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			dids[i], err = c.CounterID("views")
+			if errs.add(err) {
+				return
+			}
+			_, err = c.IncByName("views", 1, nil)
+			errs.add(err)
+		}(i)
+	}
+	wg.Wait()
+	errs.check(t)
+	for i := 1; i < workers; i++ {
+		assert.Assert(t, dids[i] == dids[0])
+	}
+	n, err = c.GetCounterByName("views", nil)
+	assert.Assert(t, err == nil && n == int64(workers))
+	n, err = c.GetCounter(dids[0], nil)
+	assert.Assert(t, err == nil && n == int64(workers))
+	db.Close()
+
+	// kind and names survive reopen
+	db = document.NewDB(kvdb)
+	defer db.Close()
+	c, err = db.Collection("counters")
+	assert.Assert(t, err == nil && c.Kind() == model.CollectionKindCounters)
+	viewsDid, err := c.CounterID("views")
+	assert.Assert(t, err == nil && viewsDid == dids[0])
+	err = c.SetCounterByName("views", 100, nil)
+	assert.Assert(t, err == nil)
+	n, err = c.GetCounterByName("views", nil)
+	assert.Assert(t, err == nil && n == 100)
+}
+
+func TestGetOneAsOf(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir, NumVersionsToKeep: 3})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	did, err := c.InsertOne(bson.M{"v": int32(0)}, nil)
+	assert.Assert(t, err == nil)
+
+	var versions []uint64
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			_, err = c.UpdateOne(did, bson.M{"v": int32(i)}, nil)
+			assert.Assert(t, err == nil)
+		}
+		_, version, err := c.GetOneWithVersion(did, nil)
+		assert.Assert(t, err == nil)
+		versions = append(versions, version)
+	}
+
+	for i, version := range versions {
+		doc, err := c.GetOneAsOf(did, version)
+		assert.Assert(t, err == nil && doc["v"] == int32(i))
+	}
+}
+
+func TestMerge(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	did, err := c.InsertOne(bson.M{"a": int32(1), "b": "b"}, nil)
+	assert.Assert(t, err == nil)
+
+	// merge into existing document
+	err = c.Merge(did, bson.M{"a": int32(2), "c": true}, nil)
+	assert.Assert(t, err == nil)
+	doc, err := c.GetOne(did, nil)
+	assert.Assert(t, err == nil && reflect.DeepEqual(doc, bson.M{"a": int32(2), "b": "b", "c": true}), doc)
+
+	// merge into absent document
+	absent := did + 100
+	err = c.Merge(absent, bson.M{"a": int32(1)}, nil)
+	assert.Assert(t, err == document.ErrDocNotFound)
+	isNew, err := c.MergeUpsert(absent, bson.M{"a": int32(1)}, nil)
+	assert.Assert(t, err == nil && isNew)
+	isNew, err = c.MergeUpsert(absent, bson.M{"b": int32(2)}, nil)
+	assert.Assert(t, err == nil && !isNew)
+	doc, err = c.GetOne(absent, nil)
+	assert.Assert(t, err == nil && reflect.DeepEqual(doc, bson.M{"a": int32(1), "b": int32(2)}), doc)
+}
+
+func TestDocumentTTL(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+	c.SetCreationTimeIndex(true)
+
+	ttl := document.WriteOption{TTL: time.Second}
+	expiring, err := c.InsertOneWithOption(bson.M{"a": 1}, ttl, nil)
+	assert.Assert(t, err == nil)
+	permanent, err := c.InsertOne(bson.M{"a": 2}, nil)
+	assert.Assert(t, err == nil)
+	updated, err := c.InsertOne(bson.M{"a": 3}, nil)
+	assert.Assert(t, err == nil)
+	exists, err := c.UpdateOneWithOption(updated, bson.M{"a": 4}, ttl, nil)
+	assert.Assert(t, err == nil && exists)
+	// a write without TTL makes it permanent again
+	renewed, err := c.InsertOneWithOption(bson.M{"a": 5}, ttl, nil)
+	assert.Assert(t, err == nil)
+	exists, err = c.UpdateOne(renewed, bson.M{"a": 6}, nil)
+	assert.Assert(t, err == nil && exists)
+
+	doc, err := c.GetOne(expiring, nil)
+	assert.Assert(t, err == nil && doc["a"] == int32(1), doc)
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	dids, _, err := c.FindByTimeRange(from, to, document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(dids) == 4, dids)
+
+	time.Sleep(time.Second * 2)
+
+	_, err = c.GetOne(expiring, nil)
+	assert.Assert(t, err == document.ErrDocNotFound, err)
+	_, err = c.GetOne(updated, nil)
+	assert.Assert(t, err == document.ErrDocNotFound, err)
+	doc, err = c.GetOne(permanent, nil)
+	assert.Assert(t, err == nil && doc["a"] == int32(2), doc)
+	doc, err = c.GetOne(renewed, nil)
+	assert.Assert(t, err == nil && doc["a"] == int32(6), doc)
+
+	// creation time entries expire along
+	dids, _, err = c.FindByTimeRange(from, to, document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && reflect.DeepEqual(dids, []int64{permanent, renewed}), dids)
+}
+
+func TestUpdateFields(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	did, err := c.InsertOne(bson.M{"a": int32(1), "b": "b", "n": bson.M{"x": 1.5}}, nil)
+	assert.Assert(t, err == nil)
+
+	err = c.UpdateFields(did, bson.M{"$set": bson.M{"b": "bb", "n.y": "y", "m.z": true}, "$inc": bson.M{"a": 2, "n.x": 1, "cnt": int64(3)}}, nil)
+	assert.Assert(t, err == nil)
+	doc, err := c.GetOne(did, nil)
+	expected := bson.M{"a": int32(3), "b": "bb", "n": bson.M{"x": 2.5, "y": "y"}, "m": bson.M{"z": true}, "cnt": int64(3)}
+	assert.Assert(t, err == nil && reflect.DeepEqual(doc, expected), doc)
+
+	// int32 is promoted on overflow
+	err = c.UpdateFields(did, bson.M{"$inc": bson.M{"a": math.MaxInt32}}, nil)
+	assert.Assert(t, err == nil)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc["a"] == int64(math.MaxInt32)+3, doc)
+
+	// invalid updates change nothing
+	for _, update := range []bson.M{
+		{"a": 1},
+		{"$unset": bson.M{"a": 1}},
+		{"$set": bson.M{"a": 1}, "$inc": bson.M{"a": 1}},
+		{"$set": bson.M{"n": 1, "n.x": 1}},
+		{"$set": bson.M{"b.c": 1}},
+		{"$set": bson.M{"a..b": 1}},
+		{},
+	} {
+		err = c.UpdateFields(did, update, nil)
+		assert.Assert(t, err == document.ErrInvalidUpdate, update)
+	}
+	err = c.UpdateFields(did, bson.M{"$inc": bson.M{"b": 1}}, nil)
+	assert.Assert(t, err == document.ErrIncNonNumeric)
+	err = c.UpdateFields(did, bson.M{"$inc": bson.M{"a": "1"}}, nil)
+	assert.Assert(t, err == document.ErrIncNonNumeric)
+	err = c.UpdateFields(did, bson.M{"$inc": bson.M{"cnt": int64(math.MaxInt64)}}, nil)
+	assert.Assert(t, err == kv.ErrOverflow)
+	err = c.UpdateFields(did, bson.M{"$set": bson.M{db.SystemField(document.SystemFieldRev): 1}}, nil)
+	assert.Assert(t, err == document.ErrReservedField)
+	err = c.UpdateFields(did+100, bson.M{"$set": bson.M{"a": 1}}, nil)
+	assert.Assert(t, err == document.ErrDocNotFound)
+	doc2, err := c.GetOne(did, nil)
+	assert.Assert(t, err == nil && reflect.DeepEqual(doc, doc2), doc2)
+
+	// concurrent updates of different fields are all kept
+	var (
+		wg   sync.WaitGroup
+		errs goErrs
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs.add(c.UpdateFields(did, bson.M{"$set": bson.M{fmt.Sprintf("f%d", i): i}, "$inc": bson.M{"hits": 1}}, nil))
+		}(i)
+	}
+	wg.Wait()
+	errs.check(t)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc["hits"] == int32(10), doc)
+	for i := 0; i < 10; i++ {
+		assert.Assert(t, doc[fmt.Sprintf("f%d", i)] == int32(i), doc)
+	}
+
+	// entries of unique indexes move with the updated fields
+	_, err = c.CreateIndex(document.IndexDefinition{Name: "b", Fields: []document.IndexField{{Name: "b"}}, Option: document.IndexOption{Unique: true}})
+	assert.Assert(t, err == nil)
+	other, err := c.InsertOne(bson.M{"b": "other"}, nil)
+	assert.Assert(t, err == nil)
+	err = c.UpdateFields(did, bson.M{"$set": bson.M{"b": "other"}}, nil)
+	assert.Assert(t, err == document.ErrUniqueViolated, err)
+	err = c.UpdateFields(did, bson.M{"$set": bson.M{"b": "bbb"}}, nil)
+	assert.Assert(t, err == nil)
+	inserted, updated, err := c.UpsertByKey("b", []bson.M{{"b": "bb"}, {"b": "bbb", "v": 1}, {"b": "other", "v": 2}}, nil)
+	assert.Assert(t, err == nil && inserted == 1 && updated == 2, inserted, updated, err)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil && doc["v"] == int32(1), doc)
+	doc, err = c.GetOne(other, nil)
+	assert.Assert(t, err == nil && doc["v"] == int32(2), doc)
+}
+
+func TestUpsertOneReturning(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	did := int64(100)
+	for i, doc := range []bson.M{
+		{"a": 1, "b": "b", "c": bson.M{"d": []string{"e"}}},
+		{"a": int64(2)},
+	} {
+		result, isNew, err := c.UpsertOneReturning(did, doc, nil)
+		assert.Assert(t, err == nil && isNew == (i == 0), err)
+		stored, err := c.GetOne(did, nil)
+		assert.Assert(t, err == nil)
+		assert.Assert(t, reflect.DeepEqual(result, stored), result, stored)
+	}
+}
+
+func TestAutoTimestamps(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	now := time.Unix(1500000000, 0)
+	db := document.NewDB(kvdb, document.DBOption{Clock: func() time.Time { return now }})
+	defer db.Close()
+	c, err := db.Collection("c", document.CollectionOption{AutoTimestamps: true})
+	assert.Assert(t, err == nil)
+
+	created := primitive.NewDateTimeFromTime(now)
+	did, err := c.InsertOne(bson.M{"a": 1}, nil)
+	assert.Assert(t, err == nil)
+	doc, err := c.GetOne(did, nil)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, doc[document.CreatedAtField] == created && doc[document.UpdatedAtField] == created, doc)
+
+	// update bumps only updatedAt
+	now = now.Add(time.Hour)
+	updated := primitive.NewDateTimeFromTime(now)
+	exists, err := c.UpdateOne(did, bson.M{"a": 2}, nil)
+	assert.Assert(t, err == nil && exists)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, doc["a"] == int32(2) && doc[document.CreatedAtField] == created && doc[document.UpdatedAtField] == updated, doc)
+
+	now = now.Add(time.Hour)
+	err = c.Merge(did, bson.M{"b": 1}, nil)
+	assert.Assert(t, err == nil)
+	doc, err = c.GetOne(did, nil)
+	assert.Assert(t, err == nil)
+	assert.Assert(t, doc[document.CreatedAtField] == created && doc[document.UpdatedAtField] == primitive.NewDateTimeFromTime(now), doc)
+
+	// values provided by the caller are kept unless OverwriteTimestamps
+	provided := primitive.NewDateTimeFromTime(time.Unix(1, 0))
+	input := bson.M{document.CreatedAtField: provided, document.UpdatedAtField: provided}
+	result, isNew, err := c.UpsertOneReturning(did+1, input, nil)
+	assert.Assert(t, err == nil && isNew)
+	assert.Assert(t, result[document.CreatedAtField] == provided && result[document.UpdatedAtField] == provided, result)
+	assert.Assert(t, len(input) == 2)
+
+	c.SetAutoTimestamps(true, true)
+	now = now.Add(time.Hour)
+	result, isNew, err = c.UpsertOneReturning(did+1, input, nil)
+	assert.Assert(t, err == nil && !isNew)
+	assert.Assert(t, result[document.CreatedAtField] == provided && result[document.UpdatedAtField] == primitive.NewDateTimeFromTime(now), result)
+
+	c.SetAutoTimestamps(false, false)
+	_, err = c.UpsertOne(did+1, bson.M{"a": 1}, nil)
+	assert.Assert(t, err == nil)
+	doc, err = c.GetOne(did+1, nil)
+	assert.Assert(t, err == nil)
+	_, ok := doc[document.UpdatedAtField]
+	assert.Assert(t, !ok, doc)
+}
+
+func TestCreationTimeIndex(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	now := time.Unix(1500000000, 0)
+	db := document.NewDB(kvdb, document.DBOption{Clock: func() time.Time { return now }})
+	defer db.Close()
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	// written before the index is enabled
+	legacy, err := c.InsertOne(bson.M{"v": 0}, nil)
+	assert.Assert(t, err == nil)
+
+	c.SetCreationTimeIndex(true)
+	start := now
+	var dids []int64
+	for i := 1; i <= 5; i++ {
+		now = now.Add(time.Minute)
+		did, err := c.InsertOne(bson.M{"v": i}, nil)
+		assert.Assert(t, err == nil)
+		dids = append(dids, did)
+	}
+	// [start+2m, start+4m) covers the 2nd and 3rd
+	found, docs, err := c.FindByTimeRange(start.Add(2*time.Minute), start.Add(4*time.Minute), document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 2 && found[0] == dids[1] && found[1] == dids[2], found)
+	assert.Assert(t, docs[0]["v"] == int32(2) && len(docs[0]) == 1, docs)
+	found, _, err = c.FindByTimeRange(start, start.Add(time.Hour), document.TimeRangeOption{Reverse: true}, nil)
+	assert.Assert(t, err == nil && len(found) == 5 && found[0] == dids[4] && found[4] == dids[0], found)
+	found, _, err = c.FindByTimeRange(start, start.Add(time.Hour), document.TimeRangeOption{Reverse: true, Limit: 2}, nil)
+	assert.Assert(t, err == nil && len(found) == 2 && found[0] == dids[4] && found[1] == dids[3], found)
+	found, _, err = c.FindByTimeRange(start.Add(time.Hour), start, document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 0)
+
+	// updates never touch the creation time
+	now = now.Add(time.Hour)
+	exists, err := c.UpdateOne(dids[0], bson.M{"v": 10}, nil)
+	assert.Assert(t, err == nil && exists)
+	err = c.Merge(dids[1], bson.M{"w": 1}, nil)
+	assert.Assert(t, err == nil)
+	found, docs, err = c.FindByTimeRange(start, start.Add(3*time.Minute), document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 2 && docs[0]["v"] == int32(10) && docs[1]["w"] == int32(1), docs)
+
+	c.SetIncludeSystemFields(true)
+	doc, err := c.GetOne(dids[0], nil)
+	assert.Assert(t, err == nil && doc[db.SystemField(document.SystemFieldCreatedAt)] == primitive.NewDateTimeFromTime(start.Add(time.Minute)), doc)
+	c.SetIncludeSystemFields(false)
+
+	// deletes remove the entries
+	err = c.DeleteOne(dids[2], nil)
+	assert.Assert(t, err == nil)
+	found, _, err = c.FindByTimeRange(start, start.Add(time.Hour), document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 4, found)
+	// a leaked entry would find the did inserted again at its old time
+	err = c.InsertOneManaged(dids[2], bson.M{"v": 3}, nil)
+	assert.Assert(t, err == nil)
+	found, _, err = c.FindByTimeRange(start, start.Add(time.Hour), document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 4, found)
+	err = c.DeleteOne(dids[2], nil)
+	assert.Assert(t, err == nil)
+
+	// upserted new documents are created now
+	_, err = c.UpsertOne(dids[4]+100, bson.M{"v": 100}, nil)
+	assert.Assert(t, err == nil)
+	found, _, err = c.FindByTimeRange(now, now.Add(time.Millisecond), document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 1 && found[0] == dids[4]+100, found)
+
+	// backfill regards legacy documents as created at the zero of Unix time
+	found, _, err = c.FindByTimeRange(time.Unix(0, 0), start, document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 0)
+	n, err := c.BackfillCreationTime(context.Background())
+	assert.Assert(t, err == nil && n == 1, n)
+	n, err = c.BackfillCreationTime(context.Background())
+	assert.Assert(t, err == nil && n == 0, n)
+	found, docs, err = c.FindByTimeRange(time.Unix(0, 0), start, document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 1 && found[0] == legacy && docs[0]["v"] == int32(0), found)
+
+	_, err = c.DeleteAll(nil)
+	assert.Assert(t, err == nil, err)
+	found, _, err = c.FindByTimeRange(time.Unix(0, 0), now.Add(time.Hour), document.TimeRangeOption{}, nil)
+	assert.Assert(t, err == nil && len(found) == 0, found)
+}
+
+func TestIntents(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+
+	const kind = "attachment"
+	chunkKey := func(name string, i int) []byte {
+		return []byte(fmt.Sprintf("attachment:%s:%d", name, i))
+	}
+	// upload writes one chunk per transaction, crash before n chunks written if crashAt < n
+	upload := func(name string, n, crashAt int, lease time.Duration) {
+		var id int64
+		for i := 0; i < n; i++ {
+			if i == crashAt {
+				return
+			}
+			err := util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+				if i == 0 {
+					id, err = intents.BeginWithLease(kind, []byte(name), lease, txn)
+				} else {
+					err = intents.Renew(id, txn)
+				}
+				if err != nil {
+					return
+				}
+				err = txn.Set(chunkKey(name, i), []byte{byte(i)}, nil)
+				if err != nil {
+					return
+				}
+				if i == n-1 {
+					err = intents.Complete(id, txn)
+				}
+				return
+			})
+			assert.Assert(t, err == nil)
+		}
+	}
+
+	upload("done", 3, -1, time.Millisecond)
+	upload("crashed", 3, 2, time.Millisecond)
+	// still running by another handle
+	upload("live", 3, 2, intents.DefaultLease)
+
+	err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		dangling, err := intents.List(txn)
+		assert.Assert(t, err == nil && len(dangling) == 2 && dangling[0].Kind == kind && string(dangling[0].Payload) == "crashed", dangling)
+		return
+	})
+	assert.Assert(t, err == nil)
+	time.Sleep(10 * time.Millisecond)
+
+	// reopen after crash, rolling back the partial upload
+	kvdb.Close()
+	kvdb = provider.NewBadger()
+	err = kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	var recovered []string
+	err = intents.RegisterRecovery(kind, func(kvdb mondis.KVDB, intent intents.Intent) error {
+		recovered = append(recovered, string(intent.Payload))
+		return util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+			for i := 0; i < 3; i++ {
+				err = txn.Delete(chunkKey(string(intent.Payload), i))
+				if err != nil {
+					return
+				}
+			}
+			return
+		})
+	})
+	assert.Assert(t, err == nil)
+	defer intents.UnregisterRecovery(kind)
+	err = intents.RegisterRecovery(kind, nil)
+	assert.Assert(t, err == intents.ErrRecoveryRegistered)
+
+	db := document.NewDB(kvdb)
+	defer db.Close()
+	assert.DeepEqual(t, recovered, []string{"crashed"})
+
+	// only the expired intent is recovered
+	err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		dangling, err := intents.List(txn)
+		assert.Assert(t, err == nil && len(dangling) == 1 && string(dangling[0].Payload) == "live", dangling)
+		for i := 0; i < 3; i++ {
+			_, _, err = txn.Get(chunkKey("done", i))
+			assert.Assert(t, err == nil)
+			_, _, err = txn.Get(chunkKey("crashed", i))
+			assert.Assert(t, err == kv.ErrKeyNotFound)
+		}
+		_, _, err = txn.Get(chunkKey("live", 1))
+		assert.Assert(t, err == nil)
+		return nil
+	})
+	assert.Assert(t, err == nil)
+
+	// recovery is done once
+	n, err := intents.Recover(kvdb)
+	assert.Assert(t, err == nil && n == 0)
+}
+
+func TestList(t *testing.T) {
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	txn := kvdb.NewTransaction(true)
+	txStruct := structure.New(txn, []byte("l"))
+	list1 := []byte("list1")
+	l, err := txStruct.LLen(list1)
+	assert.Assert(t, err == nil && l == 0)
+
+	err = txStruct.LPush(list1, []byte("item1"), []byte("item2"))
+	assert.Assert(t, err == nil)
+	l, err = txStruct.LLen(list1)
+	assert.Assert(t, err == nil && l == 2)
+
+	item, err := txStruct.LPop(list1)
+	assert.Assert(t, err == nil && bytes.Equal(item, []byte("item2")))
+
+	item, err = txStruct.LPop(list1)
+	assert.Assert(t, err == nil && bytes.Equal(item, []byte("item1")))
+
+	err = txStruct.RPush(list1, []byte("item1"), []byte("item2"))
+	assert.Assert(t, err == nil)
+	l, err = txStruct.LLen(list1)
+	assert.Assert(t, err == nil && l == 2)
+
+	item, err = txStruct.LPop(list1)
+	assert.Assert(t, err == nil && bytes.Equal(item, []byte("item1")))
+
+	item, err = txStruct.LPop(list1)
+	assert.Assert(t, err == nil && bytes.Equal(item, []byte("item2")))
+
+	l, err = txStruct.LLen(list1)
+	assert.Assert(t, err == nil && l == 0)
+
+	err = txn.Commit()
+	assert.Assert(t, err == nil)
+}
+
+func TestHash(t *testing.T) {
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	if err != nil {
+		t.Fatal("kvdb.Open", err)
+	}
+	defer kvdb.Close()
+
+	txn := kvdb.NewTransaction(true)
+	txStruct := structure.New(txn, []byte("l"))
+	hash1 := []byte("hash1")
+
+	err = txStruct.HClear(hash1)
+	if err != nil {
+		t.FailNow()
+	}
+
+	f1 := []byte("f1")
+	v1 := []byte("v1")
+	f2 := []byte("f2")
+	v2 := []byte("v2")
+	f3 := []byte("f3")
+	v3 := []byte("v3")
+	err = txStruct.HSet(hash1, f1, v1)
+	if err != nil {
+		t.FailNow()
+	}
+	err = txStruct.HSet(hash1, f2, v2)
+	if err != nil {
+		t.FailNow()
+	}
+	err = txStruct.HSet(hash1, f3, v3)
+	if err != nil {
+		t.FailNow()
+	}
+
+	n, err := txStruct.HLen(hash1)
+	if err != nil || n != 3 {
+		t.FailNow()
+	}
+
+	err = txStruct.HDel(hash1, f1)
+	if err != nil {
+		t.FailNow()
+	}
+	n, err = txStruct.HLen(hash1)
+	if err != nil || n != 2 {
+		t.FailNow()
+	}
+	err = txStruct.HSet(hash1, f1, v1)
+	if err != nil {
+		t.FailNow()
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		t.FailNow()
+	}
+
+	txn = kvdb.NewTransaction(true)
+	txStruct = structure.New(txn, []byte("l"))
+	pairs, err := txStruct.HGetN(hash1, 3)
+	if err != nil {
+		t.FailNow()
+	}
+	pairsDesc, err := txStruct.HGetNDesc(hash1, 3)
+	if err != nil {
+		t.FailNow()
+	}
+
+	if len(pairs) != 3 || len(pairsDesc) != 3 {
+		t.FailNow()
+	}
+
+	for i, p := range pairs {
+		pdesc := pairsDesc[2-i]
+		if !(bytes.Equal(p.Field, pdesc.Field) && bytes.Equal(p.Value, pdesc.Value)) {
+			t.FailNow()
+		}
+	}
+}
+
+func TestWB(t *testing.T) {
+
+	for _, provider := range kvdbProviders {
+		{
+			os.RemoveAll(dataDir)
+			kvdb := provider()
+			err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+			assert.Assert(t, err == nil)
+
+			b := kvdb.WriteBatch()
+			k := []byte("k")
+			v := []byte("v")
+			err = b.Set(k, v)
+			assert.Assert(t, err == nil)
+			err = b.Commit()
+			assert.Assert(t, err == nil)
+
+			vget, _, err := kvdb.Get(k)
+			assert.Assert(t, err == nil && reflect.DeepEqual(vget, v))
+
+			assert.Assert(t, kvdb.Close() == nil)
+		}
+	}
+
+}
+
+func TestGCWorker(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	prefix := []byte("gc:")
+	n := 3000
+	wb := kvdb.WriteBatch()
+	for i := 0; i < n; i++ {
+		assert.Assert(t, wb.Set([]byte(fmt.Sprintf("gc:%05d", i)), []byte("v")) == nil)
+	}
+	assert.Assert(t, wb.Set([]byte("gd"), []byte("v")) == nil)
+	assert.Assert(t, wb.Commit() == nil)
+
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) error {
+		return meta.NewMeta(txn).AddDeleteRange(&model.DeleteRange{Prefix: prefix})
+	})
+	assert.Assert(t, err == nil)
+
+	w := gcworker.New(kvdb)
+	var wg sync.WaitGroup
+
+	// nothing is deleted when stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.Start(ctx, &wg)
+	wg.Wait()
+	status, err := w.Status()
+	assert.Assert(t, err == nil && status.PendingRanges == 1, status)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	w.Start(ctx, &wg)
+	for i := 0; i < 100 && status.PendingRanges > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+		status, err = w.Status()
+		assert.Assert(t, err == nil)
+	}
+	cancel()
+	wg.Wait()
+	assert.Assert(t, status.PendingRanges == 0 && status.KeysDeleted == int64(n), status)
+
+	count := 0
+	err = kvdb.Scan(mondis.ProviderScanOption{Prefix: prefix, KeysOnly: true}, func(key []byte, value []byte, meta mondis.VMetaResp) bool {
+		count++
+		return true
+	})
+	assert.Assert(t, err == nil && count == 0, count)
+	_, _, err = kvdb.Get([]byte("gd"))
+	assert.Assert(t, err == nil)
+}
+
+// TestDocument is kept last, the domain it starts can not be stopped and
+// would keep running ddl worker against the closed kvdb in later tests.
+func TestDocCmds(t *testing.T) {
+	os.RemoveAll(dataDir)
+	s := server.New(addr, provider.NewBadger(), server.Option{EnableDocumentCmds: true, MetricsAddr: metricsAddr}, mondis.KVOption{Dir: dataDir})
+	go s.Start()
+	time.Sleep(time.Millisecond * 500)
+	defer s.Stop()
+
+	_, err := s.(*server.Server).Domain().DDL().CreateSchema(context.Background(), ddl.CreateSchemaInput{DB: "db", Collections: []string{"c"}})
+	assert.Assert(t, err == nil)
+
+	c := client.New(addr, client.Option{}).(*client.Client)
+	defer c.Close()
+
+	did, err := c.DocInsert("db", "c", bson.M{"name": "alice", "age": int32(20)})
+	assert.Assert(t, err == nil, err)
+
+	doc, err := c.DocGet("db", "c", did)
+	assert.Assert(t, err == nil, err)
+	assert.Assert(t, doc.Lookup("name").StringValue() == "alice" && doc.Lookup("age").Int32() == 20)
+
+	// update replaces the whole document
+	isNew, err := c.DocUpdate("db", "c", did, bson.M{"name": "bob"}, false)
+	assert.Assert(t, err == nil && !isNew, err)
+	doc, err = c.DocGet("db", "c", did)
+	assert.Assert(t, err == nil, err)
+	_, err = doc.LookupErr("age")
+	assert.Assert(t, doc.Lookup("name").StringValue() == "bob" && err != nil)
+
+	// update fails on missing did unless upsert
+	_, err = c.DocUpdate("db", "c", did+100, bson.M{"name": "carol"}, false)
+	assert.Assert(t, err == dml.ErrDocNotFound, err)
+	isNew, err = c.DocUpdate("db", "c", did+100, bson.M{"name": "carol"}, true)
+	assert.Assert(t, err == nil && isNew, err)
+	isNew, err = c.DocUpdate("db", "c", did+100, bson.M{"name": "dave"}, true)
+	assert.Assert(t, err == nil && !isNew, err)
+
+	// routing errors
+	_, err = c.DocInsert("nodb", "c", bson.M{})
+	assert.Assert(t, err == dml.ErrDBNotExists, err)
+	_, err = c.DocGet("db", "noc", did)
+	assert.Assert(t, err == dml.ErrCollectionNotExists, err)
+
+	// delete
+	for _, d := range []int64{did, did + 100} {
+		assert.Assert(t, c.DocDelete("db", "c", d) == nil)
+		_, err = c.DocGet("db", "c", d)
+		assert.Assert(t, err == dml.ErrDocNotFound, err)
+	}
+	assert.Assert(t, c.DocDelete("db", "c", did) == nil)
+
+	// failed ones are not counted
+	samples := scrapeMetrics(t)
+	assert.Assert(t, samples[`kvrpc_document_ops_total{db="db",collection="c",op="insert"}`] == 2, samples)
+	assert.Assert(t, samples[`kvrpc_document_ops_total{db="db",collection="c",op="update"}`] == 2, samples)
+	assert.Assert(t, samples[`kvrpc_document_ops_total{db="db",collection="c",op="delete"}`] == 3, samples)
+}
+
+func TestWaitSchemaVersion(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	// enables the reload loop, which is how a Domain learns DDL done by another one
+	conf := config.Load()
+	lease := conf.Lease
+	conf.Lease = time.Millisecond * 20
+	defer func() {
+		conf.Lease = lease
+	}()
+
+	doA := domain.NewDomain(kvdb)
+	assert.Assert(t, doA.Init() == nil)
+	defer doA.Close()
+	doB := domain.NewDomain(kvdb)
+	assert.Assert(t, doB.Init() == nil)
+	defer doB.Close()
+
+	job, err := doA.DDL().CreateSchema(context.Background(), ddl.CreateSchemaInput{DB: "db", Collections: []string{"c"}})
+	assert.Assert(t, err == nil && job.SchemaVersion > 0, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Assert(t, doB.WaitSchemaVersion(ctx, job.SchemaVersion) == nil)
+	_, err = doB.DB("db")
+	assert.Assert(t, err == nil)
+	localVersion, storedVersion, err := doB.SchemaLag()
+	assert.Assert(t, err == nil && localVersion == storedVersion && localVersion >= job.SchemaVersion, localVersion, storedVersion)
+
+	// a waiter is woken up by the reload after DDL
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- doB.WaitSchemaVersion(ctx, job.SchemaVersion+1)
+	}()
+	start := time.Now()
+	job, err = doA.DDL().CreateCollection(context.Background(), ddl.CreateCollectionInput{DB: "db", Collection: "c2"})
+	assert.Assert(t, err == nil)
+	assert.Assert(t, <-waitCh == nil)
+	assert.Assert(t, time.Since(start) < time.Second)
+	assert.Assert(t, doB.WaitSchemaVersion(ctx, job.SchemaVersion) == nil)
+	db, err := doB.DB("db")
+	assert.Assert(t, err == nil)
+	_, err = db.Collection("c2")
+	assert.Assert(t, err == nil)
+
+	// a version never reached
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer shortCancel()
+	assert.Assert(t, doB.WaitSchemaVersion(shortCtx, job.SchemaVersion+100) == context.DeadlineExceeded)
+}
+
+func TestDDLLocalSync(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	// each state change would sleep 2 leases otherwise
+	conf := config.Load()
+	lease := conf.Lease
+	conf.Lease = time.Second
+	defer func() {
+		conf.Lease = lease
+	}()
+
+	do := domain.NewDomain(kvdb, ddl.Options{LocalSync: true})
+	assert.Assert(t, do.Init() == nil)
+	defer do.Close()
+
+	start := time.Now()
+	_, err = do.DDL().CreateSchema(context.Background(), ddl.CreateSchemaInput{DB: "db", Collections: []string{"c"}})
+	assert.Assert(t, err == nil)
+	_, err = do.DDL().CreateCollection(context.Background(), ddl.CreateCollectionInput{DB: "db", Collection: "c2"})
+	assert.Assert(t, err == nil)
+	_, err = do.DDL().CreateCollection(context.Background(), ddl.CreateCollectionInput{DB: "db", Collection: "c2"})
+	assert.Assert(t, err == ddl.ErrCollectionAlreadyExists)
+	_, err = do.DDL().AddIndex(context.Background(), ddl.AddIndexInput{DB: "db", Collection: "c2", IndexInfo: ddl.IndexInfo{Name: "n", Columns: []string{"n"}}})
+	assert.Assert(t, err == nil)
+	_, err = do.DDL().DropCollection(context.Background(), ddl.DropCollectionInput{DB: "db", Collection: "c"})
+	assert.Assert(t, err == nil)
+	assert.Assert(t, time.Since(start) < conf.Lease, time.Since(start))
+
+	db, err := do.DB("db")
+	assert.Assert(t, err == nil)
+	_, err = db.Collection("c2")
+	assert.Assert(t, err == nil)
+}
+
+func TestCancelDDLJob(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	conf := config.Load()
+	lease := conf.Lease
+	conf.Lease = time.Second
+	defer func() {
+		conf.Lease = lease
+	}()
+
+	do := domain.NewDomain(kvdb, ddl.Options{LocalSync: true})
+	assert.Assert(t, do.Init() == nil)
+	defer do.Close()
+	d := do.DDL()
+
+	// jobs stay queued while the worker is stopped
+	d.Stop()
+	names := []string{"db1", "db2", "db3"}
+	errs := make(map[string]error)
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	var pending []*model.Job
+	for i, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			_, err := d.CreateSchema(context.Background(), ddl.CreateSchemaInput{DB: name})
+			mu.Lock()
+			errs[name] = err
+			mu.Unlock()
+		}(name)
+		// enqueue one by one, concurrent enqueues conflict
+		for j := 0; j < 50; j++ {
+			pending, err = d.GetPendingJobs()
+			assert.Assert(t, err == nil)
+			if len(pending) == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		assert.Assert(t, len(pending) == i+1, pending)
+	}
+
+	// the head is started
+	head := pending[0]
+	head.State = model.JobStateRunning
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) error {
+		return meta.NewMeta(txn).UpdateDDLJob(0, head)
+	})
+	assert.Assert(t, err == nil)
+	err = d.CancelJob(head.ID)
+	assert.Assert(t, err == ddl.ErrJobAlreadyRunning, err)
+	assert.Assert(t, d.CancelJob(-1) == meta.ErrJobNotExists)
+
+	cancelled := pending[1]
+	assert.Assert(t, d.CancelJob(cancelled.ID) == nil)
+	// idempotent
+	assert.Assert(t, d.CancelJob(cancelled.ID) == nil)
+	pending, err = d.GetPendingJobs()
+	assert.Assert(t, err == nil && len(pending) == 3 && pending[1].IsCancelled())
+
+	d.Start()
+	wg.Wait()
+
+	var cancelledDB model.DBInfo
+	assert.Assert(t, cancelled.DecodeArg(&cancelledDB) == nil)
+	cancelledName := cancelledDB.Name
+	for _, name := range names {
+		if name == cancelledName {
+			assert.Assert(t, errs[name] != nil && errs[name].Error() == ddl.ErrCancelledDDLJob.Error(), errs[name])
+		} else {
+			assert.Assert(t, errs[name] == nil, errs[name])
+		}
+	}
+
+	var dbs []*model.DBInfo
+	err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		dbs, err = meta.NewMeta(txn).ListDatabases()
+		return
+	})
+	assert.Assert(t, err == nil && len(dbs) == 2, dbs)
+	for _, db := range dbs {
+		assert.Assert(t, db.Name != cancelledName)
+	}
+
+	pending, err = d.GetPendingJobs()
+	assert.Assert(t, err == nil && len(pending) == 0)
+	history, err := d.GetHistoryJobs(2)
+	assert.Assert(t, err == nil && len(history) == 2 && history[0].ID > history[1].ID, history)
+	history, err = d.GetHistoryJobs(0)
+	assert.Assert(t, err == nil && len(history) == 3, history)
+	for _, job := range history {
+		if job.ID == cancelled.ID {
+			assert.Assert(t, job.IsCancelled())
+		}
+	}
+}
+
+func TestHistoryDDLJobs(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	// ids are not contiguous in the global id space
+	ids := []int64{3, 7, 8, 20, 300}
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		for _, id := range ids {
+			err = m.AddHistoryDDLJob(&model.Job{ID: id, Type: model.ActionCreateSchema, State: model.JobStateSynced})
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+	assert.Assert(t, err == nil)
+
+	jobIDs := func(jobs []*model.Job) (ids []int64) {
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		return
+	}
+	err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		m := meta.NewMeta(txn)
+		jobs, err := m.GetLastNHistoryDDLJobs(2)
+		assert.Assert(t, err == nil)
+		assert.DeepEqual(t, jobIDs(jobs), []int64{300, 20})
+		jobs, err = m.GetHistoryDDLJobsInRange(4, 20)
+		assert.Assert(t, err == nil)
+		assert.DeepEqual(t, jobIDs(jobs), []int64{7, 8, 20})
+		jobs, err = m.GetHistoryDDLJobsInRange(21, math.MaxInt64)
+		assert.Assert(t, err == nil)
+		assert.DeepEqual(t, jobIDs(jobs), []int64{300})
+		jobs, err = m.GetHistoryDDLJobsInRange(9, 19)
+		assert.Assert(t, err == nil && len(jobs) == 0)
+		return
+	})
+	assert.Assert(t, err == nil)
+
+	do := domain.NewDomain(kvdb, ddl.Options{LocalSync: true})
+	assert.Assert(t, do.Init() == nil)
+	defer do.Close()
+	d := do.DDL()
+
+	// fewer jobs than kept
+	removed, err := d.PruneHistoryJobs(10)
+	assert.Assert(t, err == nil && removed == 0)
+	removed, err = d.PruneHistoryJobs(3)
+	assert.Assert(t, err == nil && removed == 2)
+	history, err := d.GetHistoryJobs(0)
+	assert.Assert(t, err == nil)
+	assert.DeepEqual(t, jobIDs(history), []int64{300, 20, 8})
+
+	// pruned periodically once retention is set
+	conf := config.Load()
+	retention, interval := conf.HistoryJobRetention, conf.HistoryJobPruneInterval
+	conf.HistoryJobRetention, conf.HistoryJobPruneInterval = 1, 10*time.Millisecond
+	defer func() {
+		conf.HistoryJobRetention, conf.HistoryJobPruneInterval = retention, interval
+	}()
+	d.Stop()
+	d.Start()
+	for i := 0; i < 50; i++ {
+		history, err = d.GetHistoryJobs(0)
+		assert.Assert(t, err == nil)
+		if len(history) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.DeepEqual(t, jobIDs(history), []int64{300})
+}
+
+func TestRebuildIndex(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	do := domain.NewDomain(kvdb, ddl.Options{LocalSync: true, ReorgInterval: time.Millisecond})
+	assert.Assert(t, do.Init() == nil)
+	defer do.Close()
+
+	_, err = do.DDL().CreateSchema(context.Background(), ddl.CreateSchemaInput{DB: "db", Collections: []string{"c"}})
+	assert.Assert(t, err == nil)
+	_, err = do.DDL().AddIndex(context.Background(), ddl.AddIndexInput{DB: "db", Collection: "c", IndexInfo: ddl.IndexInfo{Name: "n", Columns: []string{"n"}}})
+	assert.Assert(t, err == nil)
+
+	db, err := do.DB("db")
+	assert.Assert(t, err == nil)
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	// more than a batch of reorganization
+	const n = 300
+	var dids []int64
+	for i := 0; i < n; i++ {
+		did, err := c.InsertOne(bson.M{"n": i}, nil)
+		assert.Assert(t, err == nil)
+		dids = append(dids, did)
+	}
+	report, err := c.VerifyIndex(context.Background(), "n", dml.VerifyOption{})
+	assert.Assert(t, err == nil && report.Clean() && report.Docs == n && report.Entries == n, report)
+
+	var cid, iid int64
+	err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		dbs, err := meta.NewMeta(txn).ListDatabases()
+		if err != nil {
+			return
+		}
+		ci := dbs[0].CollectionInfo("c")
+		cid, iid = ci.ID, ci.IndexInfo("n").ID
+		return
+	})
+	assert.Assert(t, err == nil)
+	entryKey := func(v int, did int64) []byte {
+		key, err := dml.EncodeCollectionIndexKey(nil, cid, iid, []interface{}{v})
+		assert.Assert(t, err == nil)
+		return memcomparable.EncodeInt64(key, did)
+	}
+	err = util.RunInNewUpdateTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		err = txn.Delete(entryKey(0, dids[0]))
+		if err != nil {
+			return
+		}
+		err = txn.Set(entryKey(1, dids[n-1]+100), nil, nil)
+		if err != nil {
+			return
+		}
+		err = txn.Set(entryKey(-1, dids[2]), nil, nil)
+		return
+	})
+	assert.Assert(t, err == nil)
+	report, err = c.VerifyIndex(context.Background(), "n", dml.VerifyOption{})
+	assert.Assert(t, err == nil && report.Missing == 1 && report.Extra == 1 && report.Mismatched == 1, report)
+
+	_, err = do.DDL().RebuildIndex(context.Background(), ddl.RebuildIndexInput{DB: "db", Collection: "c", IndexName: "n"})
+	assert.Assert(t, err == nil, err)
+	report, err = c.VerifyIndex(context.Background(), "n", dml.VerifyOption{})
+	assert.Assert(t, err == nil && report.Clean() && report.Docs == n && report.Entries == n, report)
+
+	_, err = do.DDL().RebuildIndex(context.Background(), ddl.RebuildIndexInput{DB: "db", Collection: "c", IndexName: "none"})
+	assert.Assert(t, err == ddl.ErrIndexNotExists)
+}
+
+func TestDropIndex(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	// onChanged is called by the ddl worker between steps of a job
+	var (
+		mu        sync.Mutex
+		onChanged func(job *model.Job)
+	)
+	var do *domain.Domain
+	do = domain.NewDomain(kvdb, ddl.Options{LocalSync: true, ReorgInterval: time.Millisecond, Callback: ddl.Callback{OnChanged: func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil || onChanged == nil {
+			return
+		}
+		jobs, err := do.DDL().GetPendingJobs()
+		if err == nil && len(jobs) > 0 && jobs[0].Type == model.ActionDropIndex {
+			onChanged(jobs[0])
+		}
+	}}})
+	assert.Assert(t, do.Init() == nil)
+	defer do.Close()
+
+	_, err = do.DDL().CreateSchema(context.Background(), ddl.CreateSchemaInput{DB: "db", Collections: []string{"c"}})
+	assert.Assert(t, err == nil)
+	for _, name := range []string{"n", "m"} {
+		_, err = do.DDL().AddIndex(context.Background(), ddl.AddIndexInput{DB: "db", Collection: "c", IndexInfo: ddl.IndexInfo{Name: name, Columns: []string{"n"}}})
+		assert.Assert(t, err == nil)
+	}
+
+	db, err := do.DB("db")
+	assert.Assert(t, err == nil)
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	// more than 2 batches of entries to delete
+	const (
+		values = 100
+		dups   = 6
+	)
+	for i := 0; i < values*dups; i++ {
+		_, err := c.InsertOne(bson.M{"n": i % values}, nil)
+		assert.Assert(t, err == nil)
+	}
+
+	var cid, iid int64
+	err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) (err error) {
+		dbs, err := meta.NewMeta(txn).ListDatabases()
+		if err != nil {
+			return
+		}
+		ci := dbs[0].CollectionInfo("c")
+		cid, iid = ci.ID, ci.IndexInfo("n").ID
+		return
+	})
+	assert.Assert(t, err == nil)
+
+	// queries never miss entries as long as the index is selectable
+	var (
+		stop                    = make(chan struct{})
+		done                    = make(chan struct{})
+		found, dropping, missed int64
+	)
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			dids, err := c.FindByIndex("n", i%values, nil)
+			switch err {
+			case nil:
+				atomic.AddInt64(&found, 1)
+				if len(dids) != dups {
+					atomic.AddInt64(&missed, 1)
+				}
+			case dml.ErrIndexDropping, dml.ErrIndexNotExists:
+				atomic.AddInt64(&dropping, 1)
+			default:
+				atomic.AddInt64(&missed, 1)
+			}
+		}
+	}()
+
+	lookups := make(map[osc.SchemaState]error)
+	var cancelErr error
+	mu.Lock()
+	onChanged = func(job *model.Job) {
+		if _, ok := lookups[job.SchemaState]; !ok {
+			_, lookups[job.SchemaState] = c.FindByIndex("n", 0, nil)
+		}
+		if job.SchemaState == osc.StateDeleteOnly && cancelErr == nil {
+			cancelErr = do.DDL().CancelJob(job.ID)
+		}
+	}
+	mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	_, err = do.DDL().DropIndex(context.Background(), ddl.DropIndexInput{DB: "db", Collection: "c", IndexName: "n"})
+	assert.Assert(t, err == nil, err)
+	close(stop)
+	<-done
+	assert.Assert(t, found > 0 && missed == 0, found, missed)
+
+	// it's too late to cancel once entries are being deleted, and the index is removed at last
+	assert.Assert(t, cancelErr == ddl.ErrJobAlreadyRunning, cancelErr)
+	assert.Assert(t, lookups[osc.StateWriteOnly] == dml.ErrIndexDropping, lookups)
+	assert.Assert(t, lookups[osc.StateDeleteOnly] == dml.ErrIndexDropping, lookups)
+	_, err = c.FindByIndex("n", 0, nil)
+	assert.Assert(t, err == dml.ErrIndexNotExists)
+
+	// no entry is left
+	var entries int
+	err = kvdb.Scan(mondis.ProviderScanOption{Prefix: dml.AppendCollectionIndexPrefix(nil, cid, iid), KeysOnly: true}, func(key []byte, value []byte, meta mondis.VMetaResp) bool {
+		entries++
+		return true
+	})
+	assert.Assert(t, err == nil && entries == 0, entries)
+	_, err = do.DDL().DropIndex(context.Background(), ddl.DropIndexInput{DB: "db", Collection: "c", IndexName: "n"})
+	assert.Assert(t, err == ddl.ErrIndexNotExists)
+
+	// cancelled while write only
+	mu.Lock()
+	onChanged = func(job *model.Job) {
+		if job.SchemaState == osc.StateWriteOnly && job.State == model.JobStateRunning {
+			cancelErr = do.DDL().CancelJob(job.ID)
+		}
+	}
+	mu.Unlock()
+	_, err = do.DDL().DropIndex(context.Background(), ddl.DropIndexInput{DB: "db", Collection: "c", IndexName: "m"})
+	assert.Assert(t, err != nil && err.Error() == ddl.ErrCancelledDDLJob.Error(), err)
+	assert.Assert(t, cancelErr == nil)
+	dids, err := c.FindByIndex("m", 0, nil)
+	assert.Assert(t, err == nil && len(dids) == dups)
+	report, err := c.VerifyIndex(context.Background(), "m", dml.VerifyOption{})
+	assert.Assert(t, err == nil && report.Clean() && report.Entries == values*dups, report)
+}
+
+func TestDocument(t *testing.T) {
+	os.RemoveAll(dataDir)
+	kvdb := provider.NewBadger()
+	err := kvdb.Open(mondis.KVOption{Dir: dataDir})
+	assert.Assert(t, err == nil)
+	defer kvdb.Close()
+
+	do := domain.NewDomain(kvdb)
+	assert.Assert(t, do.Init() == nil)
+	// ddl workers use kvdb in background
+	defer do.Close()
+	_, err = do.DDL().CreateSchema(context.Background(), ddl.CreateSchemaInput{DB: "db", Collections: []string{"c"}})
+	assert.Assert(t, err == nil)
+	db, err := do.DB("db")
+	assert.Assert(t, err == nil)
+
+	c, err := db.Collection("c")
+	assert.Assert(t, err == nil)
+
+	// test CreateCollection in existing db
+	{
+		_, err = do.DDL().CreateCollection(context.Background(), ddl.CreateCollectionInput{DB: "db", Collection: "c2"})
+		assert.Assert(t, err == nil)
+		_, err = do.DDL().CreateCollection(context.Background(), ddl.CreateCollectionInput{DB: "db", Collection: "c2"})
+		assert.Assert(t, err == ddl.ErrCollectionAlreadyExists)
+		_, err = do.DDL().CreateCollection(context.Background(), ddl.CreateCollectionInput{DB: "nodb", Collection: "c2"})
+		assert.Assert(t, err == ddl.ErrDBNotExists)
+
+		c2, err := db.Collection("c2")
+		assert.Assert(t, err == nil)
+		did, err := c2.InsertOne(bson.M{"key": "value"}, nil)
+		assert.Assert(t, err == nil)
+		var data bson.M
+		err = c2.GetOne(did, &data, nil)
+		assert.Assert(t, err == nil && data["key"] == "value")
+	}
+
+	// test GetDidRange before DeleteAll
+	{
+		key := "key range"
+		did1, err := c.InsertOne(bson.M{key: "value"}, nil)
+		assert.Assert(t, err == nil)
+		did2, err := c.InsertOne(bson.M{key: "value"}, nil)
+		assert.Assert(t, err == nil)
+
+		min, max, err := c.GetDidRange(nil)
+		assert.Assert(t, err == nil && min == did1 && max == did2)
+
+		n, err := c.Count(nil)
+		assert.Assert(t, err == nil && n == 2)
+
+		var result []bson.M
+		err = c.GetMany([]int64{did1, did2}, &result, nil)
+		assert.Assert(t, err == nil && len(result) == 2)
+		result = nil
+		err = c.GetAll(&result, nil)
+		assert.Assert(t, err == nil && len(result) == 2)
+	}
+
+	n, err := c.DeleteAll(nil)
+	assert.Assert(t, err == nil)
+
+	key := "key"
+	did, err := c.InsertOne(bson.M{key: "value"}, nil)
+	assert.Assert(t, err == nil)
+
+	var data bson.M
+	err = c.GetOne(did, &data, nil)
+	assert.Assert(t, err == nil && data[key] == "value")
+
+	updated, err := c.UpdateOne(did, bson.M{key: "value2"}, nil)
+	assert.Assert(t, err == nil && updated)
+
+	err = c.GetOne(did, &data, nil)
+	assert.Assert(t, err == nil && data[key] == "value2")
+
+	n, err = c.Count(nil)
+	assert.Assert(t, err == nil && n == 1)
+
+	err = c.DeleteOne(did, nil)
+	assert.Assert(t, err == nil)
+
+	err = c.InsertOneManaged(1000, bson.M{key: "value"}, nil)
+	assert.Assert(t, err == nil)
+	n, err = c.Count(nil)
+	assert.Assert(t, err == nil && n == 1)
+	err = c.DeleteOne(1000, nil)
+	assert.Assert(t, err == nil)
+
+	err = c.GetOne(did, nil, nil)
+	assert.Assert(t, err == dml.ErrDocNotFound)
+
+	// test DropCollection and DropSchema
+	{
+		var c2ID int64
+		err = util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) error {
+			dbInfo, err := meta.NewMeta(txn).GetDatabaseByName("db")
+			if err != nil {
+				return err
+			}
+			c2ID = dbInfo.CollectionInfo("c2").ID
+			return nil
+		})
+		assert.Assert(t, err == nil)
+		c2, err := db.Collection("c2")
+		assert.Assert(t, err == nil)
+		docs := 3000
+		err = db.RunInNewUpdateTxn(func(txn *txn.Txn) error {
+			for i := 0; i < docs; i++ {
+				_, err := c2.InsertOne(bson.M{"i": i}, txn)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.Assert(t, err == nil)
+
+		_, err = do.DDL().DropCollection(context.Background(), ddl.DropCollectionInput{DB: "db", Collection: "c2"})
+		assert.Assert(t, err == nil)
+		_, err = do.DDL().DropCollection(context.Background(), ddl.DropCollectionInput{DB: "db", Collection: "c2"})
+		assert.Assert(t, err == ddl.ErrCollectionNotExists)
+		_, err = db.Collection("c2")
+		assert.Assert(t, err == dml.ErrCollectionNotExists)
+		_, err = c2.InsertOne(bson.M{"key": "value"}, nil)
+		assert.Assert(t, err == dml.ErrCollectionNotExists)
+
+		// documents are deleted by gc worker in background
+		var status gcworker.Status
+		for i := 0; i < 100; i++ {
+			time.Sleep(time.Millisecond * 10)
+			status, err = do.DDL().GCStatus()
+			assert.Assert(t, err == nil)
+			if status.PendingRanges == 0 {
+				break
+			}
+		}
+		// the single document inserted before counts too
+		assert.Assert(t, status.PendingRanges == 0 && status.KeysDeleted >= int64(docs+1), status)
+		err = kvdb.Scan(mondis.ProviderScanOption{Prefix: dml.AppendCollectionDocumentPrefix(nil, c2ID), KeysOnly: true}, func(key []byte, value []byte, meta mondis.VMetaResp) bool {
+			t.Fatal("document not deleted")
+			return false
+		})
+		assert.Assert(t, err == nil)
+
+		// the name can be reused
+		_, err = do.DDL().CreateCollection(context.Background(), ddl.CreateCollectionInput{DB: "db", Collection: "c2"})
+		assert.Assert(t, err == nil)
+
+		_, err = do.DDL().DropSchema(context.Background(), ddl.DropSchemaInput{DB: "db"})
+		assert.Assert(t, err == nil)
+		_, err = do.DDL().DropSchema(context.Background(), ddl.DropSchemaInput{DB: "db"})
+		assert.Assert(t, err == ddl.ErrDBNotExists)
+		_, err = do.DB("db")
+		assert.Assert(t, err == dml.ErrDBNotExists)
+		_, err = c.InsertOne(bson.M{"key": "value"}, nil)
+		assert.Assert(t, err == dml.ErrCollectionNotExists)
+
+		_, err = do.DDL().CreateSchema(context.Background(), ddl.CreateSchemaInput{DB: "db", Collections: []string{"c"}})
+		assert.Assert(t, err == nil)
+		_, err = do.DB("db")
+		assert.Assert(t, err == nil)
+	}
+
+	// test AddIndex
+	{
+		db, err := do.DB("db")
+		assert.Assert(t, err == nil)
+		_, err = do.DDL().CreateCollection(context.Background(), ddl.CreateCollectionInput{DB: "db", Collection: "idx"})
+		assert.Assert(t, err == nil)
+		ci, err := db.Collection("idx")
+		assert.Assert(t, err == nil)
+
+		docs := 600
+		err = db.RunInNewUpdateTxn(func(txn *txn.Txn) error {
+			for i := 0; i < docs; i++ {
+				_, err := ci.InsertOne(bson.M{"n": i, "dup": i % 2}, txn)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.Assert(t, err == nil)
+
+		countEntries := func(iif *model.IndexInfo) (n int) {
+			var cid int64
+			err := util.RunInNewTxn(kvdb, func(txn mondis.ProviderTxn) error {
+				dbInfo, err := meta.NewMeta(txn).GetDatabaseByName("db")
+				if err != nil {
+					return err
+				}
+				cid = dbInfo.CollectionInfo("idx").ID
+				return nil
+			})
+			assert.Assert(t, err == nil)
+			err = kvdb.Scan(mondis.ProviderScanOption{Prefix: dml.AppendCollectionIndexPrefix(nil, cid, iif.ID), KeysOnly: true}, func(key []byte, value []byte, meta mondis.VMetaResp) bool {
+				n++
+				return true
+			})
+			assert.Assert(t, err == nil)
+			return
+		}
+
+		// documents inserted concurrently are indexed too
+		stopCh := make(chan struct{})
+		insertedCh := make(chan int)
+		go func() {
+			inserted := 0
+			for {
+				select {
+				case <-stopCh:
+					insertedCh <- inserted
+					return
+				default:
+				}
+				_, err := ci.InsertOne(bson.M{"n": docs + inserted}, nil)
+				if err == nil {
+					inserted++
+				} else {
+					assert.Check(t, err == txn.ErrDDLConflict, err)
+				}
+			}
+		}()
+		_, err = do.DDL().AddIndex(context.Background(), ddl.AddIndexInput{DB: "db", Collection: "idx", IndexInfo: ddl.IndexInfo{Name: "n", Columns: []string{"n"}}})
+		close(stopCh)
+		inserted := <-insertedCh
+		assert.Assert(t, err == nil, err)
+
+		iifs, err := ci.GetIndices(nil)
+		assert.Assert(t, err == nil && len(iifs) == 1 && iifs[0].State == osc.StatePublic, iifs)
+		assert.Assert(t, countEntries(iifs[0]) == docs+inserted)
+
+		// unique violation rolls the job back
+		_, err = do.DDL().AddIndex(context.Background(), ddl.AddIndexInput{DB: "db", Collection: "idx", IndexInfo: ddl.IndexInfo{Name: "dup", Columns: []string{"dup"}, Unique: true}})
+		assert.Assert(t, err != nil && strings.Contains(err.Error(), dml.ErrUniqueViolated.Error()+": did "), err)
+		iifs, err = ci.GetIndices(nil)
+		assert.Assert(t, err == nil && len(iifs) == 1 && iifs[0].Name == "n", iifs)
+	}
+
+	// {
+	// 	// test index
+	// 	c, err := db.Collection("i")
+	// 	if err != nil {
+	// 		t.Fatal("db.Collection", err)
+	// 	}
+	// 	idxName := "test_idx"
+	// 	idef := document.IndexDefinition{
+	// 		Name: idxName,
+	// 		Fields: []document.IndexField{
+	// 			document.IndexField{Name: "f1"},
+	// 		},
+	// 	}
+	// 	iid, err := c.CreateIndex(idef)
+	// 	if err != nil {
+	// 		t.Fatal("c.CreateIndex", err)
+	// 	}
+	// 	if iid <= 0 {
+	// 		t.Fatal("iid <=0", iid)
+	// 	}
+
+	// 	allIndexes := c.GetIndexes()
+	// 	if len(allIndexes) != 1 {
+	// 		t.Fatal("len(allIndexes)!=1")
+	// 	}
+
+	// 	exists, err := c.DropIndex(idxName)
+	// 	if err != nil {
+	// 		t.Fatal("c.DropIndex", err)
+	// 	}
+	// 	if !exists {
+	// 		t.Fatal()
+	// 	}
+	// }
+	// db.Close()
+
+	// err = c.DeleteOne(did, nil)
+	// if err != document.ErrAlreadyClosed {
+	// 	t.Fatal("err != document.ErrAlreadyClosed")
+	// }
+
 }
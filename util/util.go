@@ -80,6 +80,37 @@ func RunInNewUpdateTxn(kvdb mondis.KVDB, f func(mondis.ProviderTxn) error) (err
 	return
 }
 
+// RunInNewUpdateTxnWithRetry is like RunInNewUpdateTxn but reruns f in a fresh transaction
+// on kv.ErrTxnConflict up to maxRetries times, so f should have no side effects other than through the txn.
+func RunInNewUpdateTxnWithRetry(kvdb mondis.KVDB, f func(mondis.ProviderTxn) error, maxRetries int) (err error) {
+	err = RetryOnConflict(maxRetries, func() error {
+		return RunInNewUpdateTxn(kvdb, f)
+	})
+	return
+}
+
+const (
+	retryBaseBackoff = time.Millisecond * 5
+	retryMaxBackoff  = time.Second
+)
+
+// RetryOnConflict calls f and retries with exponential backoff up to maxRetries times when it returns kv.ErrTxnConflict
+func RetryOnConflict(maxRetries int, f func() error) (err error) {
+	backoff := retryBaseBackoff
+	for i := 0; ; i++ {
+		err = f()
+		if err != kv.ErrTxnConflict || i >= maxRetries {
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > retryMaxBackoff {
+			backoff = retryMaxBackoff
+		}
+	}
+}
+
 // RunInNewTxn for run f in a new read-only transaction
 func RunInNewTxn(kvdb mondis.KVDB, f func(mondis.ProviderTxn) error) (err error) {
 	txn := kvdb.NewTransaction(false)